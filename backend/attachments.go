@@ -0,0 +1,637 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Хранилище вложений; инициализируется в main
+var attachmentStore blobStore
+
+// Допустимые типы вложений: заключения лабораторий, ЭКГ, снимки
+var allowedAttachmentTypes = map[string]bool{
+	"application/pdf":   true,
+	"image/png":         true,
+	"image/jpeg":        true,
+	"image/gif":         true,
+	"image/webp":        true,
+	"application/dicom": true,
+}
+
+// Attachment - файл, привязанный к карте/эпизоду и разделу карты
+type Attachment struct {
+	ID          int64   `json:"id"`
+	PatientUID  string  `json:"patientUid"`
+	CardID      *int64  `json:"cardId,omitempty"`
+	EpisodeID   *int64  `json:"episodeId,omitempty"`
+	ClinicID    *string `json:"clinicId,omitempty"`
+	Section     string  `json:"section"`           // labResults, specialistEntries, ...
+	ItemKey     string  `json:"itemKey,omitempty"` // название анализа или специальность
+	FileName    string  `json:"fileName"`
+	ContentType string  `json:"contentType"`
+	Size        int64   `json:"size"`
+	SHA256      string  `json:"sha256"`
+	UploadedBy  string  `json:"uploadedBy"`
+	CreatedAt   string  `json:"createdAt"`
+	// URL - постоянная ссылка для fileUrl в карте, DownloadURL - временная подписанная
+	URL          string `json:"url"`
+	DownloadURL  string `json:"downloadUrl,omitempty"`
+	Deduplicated bool   `json:"deduplicated,omitempty"`
+}
+
+func migrateAttachments(ctx context.Context, tx pgx.Tx) error {
+	_, err := tx.Exec(ctx, `
+CREATE TABLE IF NOT EXISTS attachment_blobs (
+  sha256       TEXT PRIMARY KEY,
+  size         BIGINT NOT NULL,
+  content_type TEXT NOT NULL,
+  storage_key  TEXT NOT NULL,
+  created_at   TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+`)
+	if err != nil {
+		return fmt.Errorf("migrate attachment_blobs: %w", err)
+	}
+
+	_, err = tx.Exec(ctx, `
+CREATE TABLE IF NOT EXISTS attachments (
+  id          SERIAL PRIMARY KEY,
+  sha256      TEXT NOT NULL REFERENCES attachment_blobs(sha256),
+  patient_uid TEXT NOT NULL,
+  card_id     INTEGER REFERENCES ambulatory_cards(id) ON DELETE SET NULL,
+  episode_id  INTEGER REFERENCES exam_episodes(id) ON DELETE CASCADE,
+  clinic_id   TEXT,
+  section     TEXT NOT NULL,
+  item_key    TEXT,
+  file_name   TEXT NOT NULL,
+  uploaded_by TEXT NOT NULL,
+  created_at  TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+`)
+	if err != nil {
+		return fmt.Errorf("migrate attachments: %w", err)
+	}
+
+	for _, stmt := range []string{
+		`CREATE INDEX IF NOT EXISTS idx_attachments_patient ON attachments(patient_uid);`,
+		`CREATE INDEX IF NOT EXISTS idx_attachments_episode ON attachments(episode_id);`,
+		`CREATE INDEX IF NOT EXISTS idx_attachments_sha256 ON attachments(sha256);`,
+	} {
+		if _, err := tx.Exec(ctx, stmt); err != nil {
+			return fmt.Errorf("attachments index: %w", err)
+		}
+	}
+	return nil
+}
+
+func maxAttachmentBytes() int64 {
+	if v, err := strconv.ParseInt(os.Getenv("ATTACHMENT_MAX_BYTES"), 10, 64); err == nil && v > 0 {
+		return v
+	}
+	return 25 << 20
+}
+
+// detectAttachmentType определяет тип по содержимому, а не по заголовку клиента
+func detectAttachmentType(head []byte) string {
+	// DICOM: 128 байт преамбулы и сигнатура "DICM"
+	if len(head) >= 132 && string(head[128:132]) == "DICM" {
+		return "application/dicom"
+	}
+	ct, _, _ := mime.ParseMediaType(http.DetectContentType(head))
+	return ct
+}
+
+// --- Подписанные ссылки на скачивание ---
+
+func attachmentURLTTL() time.Duration {
+	if d, err := time.ParseDuration(os.Getenv("ATTACHMENT_URL_TTL")); err == nil && d > 0 {
+		return d
+	}
+	return 15 * time.Minute
+}
+
+func attachmentSignature(id int64, uid string, expires int64) string {
+	mac := hmac.New(sha256.New, serverKeys.AttachmentURL)
+	fmt.Fprintf(mac, "%d\n%s\n%d", id, uid, expires)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// signedAttachmentURL выдаёт ссылку, действующую attachmentURLTTL и привязанную к пользователю
+func signedAttachmentURL(id int64, uid string, now time.Time) string {
+	expires := now.Add(attachmentURLTTL()).Unix()
+	q := url.Values{}
+	q.Set("uid", uid)
+	q.Set("expires", strconv.FormatInt(expires, 10))
+	q.Set("sig", attachmentSignature(id, uid, expires))
+	return fmt.Sprintf("/api/attachments/%d/download?%s", id, q.Encode())
+}
+
+func verifyAttachmentURL(id int64, q url.Values, now time.Time) error {
+	expires, err := strconv.ParseInt(q.Get("expires"), 10, 64)
+	if err != nil {
+		return errors.New("invalid link")
+	}
+	want := attachmentSignature(id, q.Get("uid"), expires)
+	if !hmac.Equal([]byte(want), []byte(q.Get("sig"))) {
+		return errors.New("invalid link signature")
+	}
+	if now.Unix() > expires {
+		return errors.New("link expired")
+	}
+	return nil
+}
+
+// --- Доступ ---
+
+// userClinicID - клиника, от имени которой работает пользователь
+func userClinicID(u *User) string {
+	if u.Role == UserRoleClinic {
+		return u.ID
+	}
+	if u.ClinicID != nil {
+		return *u.ClinicID
+	}
+	return ""
+}
+
+func isClinicStaff(u *User) bool {
+	return u.Role == UserRoleClinic || u.Role == UserRoleDoctor || u.Role == UserRoleRegistration
+}
+
+// canAccessPatientFiles: сам пациент или сотрудники клиники, проводящей осмотр.
+// Работодатель медицинские файлы не видит.
+func canAccessPatientFiles(u *User, patientUID string, clinicID *string) bool {
+	if u.Role == UserRoleEmployee {
+		return u.ID == patientUID
+	}
+	if !isClinicStaff(u) {
+		return false
+	}
+	return clinicID == nil || *clinicID == "" || *clinicID == userClinicID(u)
+}
+
+func requestUser(ctx context.Context, w http.ResponseWriter, r *http.Request) (*User, bool) {
+	uid := requestUserID(r)
+	if uid == "" {
+		errorResponse(w, http.StatusUnauthorized, "user id is required")
+		return nil, false
+	}
+	u, err := records.User(ctx, uid)
+	if err != nil {
+		errorResponse(w, http.StatusUnauthorized, "unknown user")
+		return nil, false
+	}
+	return u, true
+}
+
+// --- Запросы ---
+
+const attachmentColumns = `
+a.id, a.patient_uid, a.card_id, a.episode_id, a.clinic_id, a.section, COALESCE(a.item_key, ''), a.file_name,
+b.content_type, b.size, a.sha256, a.uploaded_by, a.created_at`
+
+func scanAttachment(row pgx.Row) (*Attachment, error) {
+	var a Attachment
+	var created time.Time
+	if err := row.Scan(&a.ID, &a.PatientUID, &a.CardID, &a.EpisodeID, &a.ClinicID, &a.Section, &a.ItemKey, &a.FileName,
+		&a.ContentType, &a.Size, &a.SHA256, &a.UploadedBy, &created); err != nil {
+		return nil, err
+	}
+	a.CreatedAt = created.Format(time.RFC3339)
+	a.URL = fmt.Sprintf("/api/attachments/%d", a.ID)
+	return &a, nil
+}
+
+func loadAttachment(ctx context.Context, q dbExecutor, id int64) (*Attachment, error) {
+	return scanAttachment(q.QueryRow(ctx, `SELECT `+attachmentColumns+`
+FROM attachments a JOIN attachment_blobs b ON b.sha256 = a.sha256 WHERE a.id = $1`, id))
+}
+
+// attachmentTarget - к чему привязывается загружаемый файл
+type attachmentTarget struct {
+	PatientUID string
+	CardID     *int64
+	EpisodeID  *int64
+	ClinicID   *string
+	Locked     bool
+}
+
+func resolveAttachmentTarget(ctx context.Context, episodeID, cardID int64, patientUID string) (*attachmentTarget, error) {
+	t := &attachmentTarget{PatientUID: patientUID}
+	if episodeID > 0 {
+		e, err := loadEpisode(ctx, db, episodeID)
+		if err != nil {
+			return nil, errors.New("episode not found")
+		}
+		t.PatientUID, t.EpisodeID, t.ClinicID, t.Locked = e.PatientUID, &e.ID, e.ClinicID, e.Locked
+	}
+	if cardID > 0 {
+		var uid string
+		if err := db.QueryRow(ctx, `SELECT patient_uid FROM ambulatory_cards WHERE id = $1`, cardID).Scan(&uid); err != nil {
+			return nil, errors.New("card not found")
+		}
+		if t.PatientUID != "" && t.PatientUID != uid {
+			return nil, errors.New("card and episode belong to different patients")
+		}
+		t.PatientUID, t.CardID = uid, &cardID
+	}
+	if t.PatientUID == "" {
+		return nil, errors.New("episodeId, cardId or patientUid is required")
+	}
+	if t.CardID == nil {
+		var id int64
+		if err := db.QueryRow(ctx, `SELECT id FROM ambulatory_cards WHERE patient_uid = $1`, t.PatientUID).Scan(&id); err == nil {
+			t.CardID = &id
+		}
+	}
+	return t, nil
+}
+
+// putAttachmentBlob сохраняет содержимое, если такого ещё нет; exists - найден дубликат
+func putAttachmentBlob(ctx context.Context, body io.Reader, size int64, sum, contentType string) (bool, error) {
+	var exists bool
+	if err := db.QueryRow(ctx, `SELECT EXISTS(SELECT 1 FROM attachment_blobs WHERE sha256 = $1)`, sum).Scan(&exists); err != nil {
+		return false, err
+	}
+	if exists {
+		return true, nil
+	}
+	key := "sha256/" + sum[:2] + "/" + sum
+	if err := attachmentStore.Put(ctx, key, body, size, sum, contentType); err != nil {
+		return false, err
+	}
+	_, err := db.Exec(ctx, `
+INSERT INTO attachment_blobs (sha256, size, content_type, storage_key) VALUES ($1, $2, $3, $4)
+ON CONFLICT (sha256) DO NOTHING
+`, sum, size, contentType, key)
+	return false, err
+}
+
+func insertAttachment(ctx context.Context, t *attachmentTarget, sum, section, itemKey, fileName, uploadedBy string) (int64, error) {
+	fileName = strings.TrimSpace(fileName)
+	if fileName == "" {
+		fileName = "file"
+	}
+	var id int64
+	err := db.QueryRow(ctx, `
+INSERT INTO attachments (sha256, patient_uid, card_id, episode_id, clinic_id, section, item_key, file_name, uploaded_by)
+VALUES ($1, $2, $3, $4, $5, $6, NULLIF($7, ''), $8, $9)
+RETURNING id
+`, sum, t.PatientUID, t.CardID, t.EpisodeID, t.ClinicID, section, itemKey, fileName, uploadedBy).Scan(&id)
+	return id, err
+}
+
+// saveGeneratedAttachment архивирует документ, сформированный сервером (PDF, DOCX).
+// Повторное сохранение того же содержимого в тот же раздел возвращает существующее вложение.
+func saveGeneratedAttachment(ctx context.Context, user *User, episodeID, cardID int64, section, fileName, contentType string, data []byte) (*Attachment, error) {
+	target, err := resolveAttachmentTarget(ctx, episodeID, cardID, "")
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(data)
+	sumHex := hex.EncodeToString(sum[:])
+
+	var id int64
+	err = db.QueryRow(ctx, `
+SELECT id FROM attachments
+WHERE sha256 = $1 AND patient_uid = $2 AND section = $3 AND episode_id IS NOT DISTINCT FROM $4
+ORDER BY id LIMIT 1
+`, sumHex, target.PatientUID, section, target.EpisodeID).Scan(&id)
+	deduplicated := err == nil
+	if err == pgx.ErrNoRows {
+		if _, err := putAttachmentBlob(ctx, bytes.NewReader(data), int64(len(data)), sumHex, contentType); err != nil {
+			return nil, err
+		}
+		id, err = insertAttachment(ctx, target, sumHex, section, "", fileName, user.ID)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	a, err := loadAttachment(ctx, db, id)
+	if err != nil {
+		return nil, err
+	}
+	a.Deduplicated = deduplicated
+	a.DownloadURL = signedAttachmentURL(a.ID, user.ID, time.Now())
+	return a, nil
+}
+
+// --- HANDLERS ---
+
+// POST /api/attachments (multipart/form-data: file, section, itemKey, episodeId | cardId | patientUid)
+func uploadAttachmentHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 60*time.Second)
+	defer cancel()
+
+	user, ok := requestUser(ctx, w, r)
+	if !ok {
+		return
+	}
+	if !isClinicStaff(user) {
+		errorResponse(w, http.StatusForbidden, "only clinic staff can upload attachments")
+		return
+	}
+
+	maxBytes := maxAttachmentBytes()
+	r.Body = http.MaxBytesReader(w, r.Body, maxBytes+1<<20) // запас на поля формы
+	if err := r.ParseMultipartForm(8 << 20); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			errorResponse(w, http.StatusRequestEntityTooLarge, "file is too large")
+			return
+		}
+		errorResponse(w, http.StatusBadRequest, "invalid multipart form")
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		errorResponse(w, http.StatusBadRequest, "file is required")
+		return
+	}
+	defer file.Close()
+	if header.Size > maxBytes {
+		errorResponse(w, http.StatusRequestEntityTooLarge, "file is too large")
+		return
+	}
+	if header.Size == 0 {
+		errorResponse(w, http.StatusBadRequest, "file is empty")
+		return
+	}
+
+	section := strings.TrimSpace(r.FormValue("section"))
+	if section == "" || len(section) > 100 {
+		errorResponse(w, http.StatusBadRequest, "section is required")
+		return
+	}
+	episodeID, _ := strconv.ParseInt(r.FormValue("episodeId"), 10, 64)
+	cardID, _ := strconv.ParseInt(r.FormValue("cardId"), 10, 64)
+	target, err := resolveAttachmentTarget(ctx, episodeID, cardID, r.FormValue("patientUid"))
+	if err != nil {
+		errorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if !canAccessPatientFiles(user, target.PatientUID, target.ClinicID) {
+		errorResponse(w, http.StatusForbidden, "episode belongs to another clinic")
+		return
+	}
+	if target.Locked {
+		errorResponse(w, http.StatusLocked, "episode is locked by the final conclusion")
+		return
+	}
+
+	head := make([]byte, 512)
+	n, _ := io.ReadFull(file, head)
+	contentType := detectAttachmentType(head[:n])
+	if !allowedAttachmentTypes[contentType] {
+		errorResponse(w, http.StatusUnsupportedMediaType, "unsupported file type "+contentType)
+		return
+	}
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		errorResponse(w, http.StatusInternalServerError, "read error")
+		return
+	}
+	hasher := sha256.New()
+	size, err := io.Copy(hasher, file)
+	if err != nil {
+		errorResponse(w, http.StatusInternalServerError, "read error")
+		return
+	}
+	sum := hex.EncodeToString(hasher.Sum(nil))
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		errorResponse(w, http.StatusInternalServerError, "read error")
+		return
+	}
+	exists, err := putAttachmentBlob(ctx, file, size, sum, contentType)
+	if err != nil {
+		log.Printf("uploadAttachment: storage error: %v", err)
+		errorResponse(w, http.StatusBadGateway, "storage error")
+		return
+	}
+
+	id, err := insertAttachment(ctx, target, sum, section, r.FormValue("itemKey"), header.Filename, user.ID)
+	if err != nil {
+		log.Printf("uploadAttachment: insert error: %v", err)
+		errorResponse(w, http.StatusInternalServerError, "db error")
+		return
+	}
+
+	a, err := loadAttachment(ctx, db, id)
+	if err != nil {
+		errorResponse(w, http.StatusInternalServerError, "db error")
+		return
+	}
+	a.Deduplicated = exists
+	a.DownloadURL = signedAttachmentURL(a.ID, user.ID, time.Now())
+
+	broadcastToUser(a.PatientUID, "attachment_added", map[string]interface{}{
+		"attachmentId": a.ID,
+		"episodeId":    a.EpisodeID,
+		"section":      a.Section,
+	})
+	jsonResponse(w, http.StatusCreated, a)
+}
+
+// GET /api/attachments?episodeId=|patientUid=[&section=]
+func listAttachmentsHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	user, ok := requestUser(ctx, w, r)
+	if !ok {
+		return
+	}
+
+	q := r.URL.Query()
+	where := ""
+	var args []interface{}
+	if id, err := strconv.ParseInt(q.Get("episodeId"), 10, 64); err == nil && id > 0 {
+		args = append(args, id)
+		where = "a.episode_id = $1"
+	} else if uid := q.Get("patientUid"); uid != "" {
+		args = append(args, uid)
+		where = "a.patient_uid = $1"
+	} else {
+		errorResponse(w, http.StatusBadRequest, "episodeId or patientUid is required")
+		return
+	}
+	if section := q.Get("section"); section != "" {
+		args = append(args, section)
+		where += fmt.Sprintf(" AND a.section = $%d", len(args))
+	}
+
+	rows, err := db.Query(ctx, `SELECT `+attachmentColumns+`
+FROM attachments a JOIN attachment_blobs b ON b.sha256 = a.sha256
+WHERE `+where+` ORDER BY a.created_at DESC, a.id DESC`, args...)
+	if err != nil {
+		log.Printf("listAttachments error: %v", err)
+		errorResponse(w, http.StatusInternalServerError, "db error")
+		return
+	}
+	defer rows.Close()
+
+	now := time.Now()
+	res := make([]*Attachment, 0)
+	for rows.Next() {
+		a, err := scanAttachment(rows)
+		if err != nil {
+			errorResponse(w, http.StatusInternalServerError, "db error")
+			return
+		}
+		if !canAccessPatientFiles(user, a.PatientUID, a.ClinicID) {
+			continue
+		}
+		a.DownloadURL = signedAttachmentURL(a.ID, user.ID, now)
+		res = append(res, a)
+	}
+	jsonResponse(w, http.StatusOK, res)
+}
+
+// GET /api/attachments/{id} - метаданные и свежая ссылка на скачивание
+func getAttachmentHandler(w http.ResponseWriter, r *http.Request, id int64) {
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	user, ok := requestUser(ctx, w, r)
+	if !ok {
+		return
+	}
+	a, err := loadAttachment(ctx, db, id)
+	if err != nil {
+		errorResponse(w, http.StatusNotFound, "attachment not found")
+		return
+	}
+	if !canAccessPatientFiles(user, a.PatientUID, a.ClinicID) {
+		errorResponse(w, http.StatusForbidden, "access denied")
+		return
+	}
+	a.DownloadURL = signedAttachmentURL(a.ID, user.ID, time.Now())
+	jsonResponse(w, http.StatusOK, a)
+}
+
+// GET /api/attachments/{id}/download?uid=&expires=&sig=
+func downloadAttachmentHandler(w http.ResponseWriter, r *http.Request, id int64) {
+	if err := verifyAttachmentURL(id, r.URL.Query(), time.Now()); err != nil {
+		errorResponse(w, http.StatusForbidden, err.Error())
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Minute)
+	defer cancel()
+
+	a, err := loadAttachment(ctx, db, id)
+	if err != nil {
+		errorResponse(w, http.StatusNotFound, "attachment not found")
+		return
+	}
+	var key string
+	if err := db.QueryRow(ctx, `SELECT storage_key FROM attachment_blobs WHERE sha256 = $1`, a.SHA256).Scan(&key); err != nil {
+		errorResponse(w, http.StatusNotFound, "attachment not found")
+		return
+	}
+	body, err := attachmentStore.Get(ctx, key)
+	if errors.Is(err, errBlobNotFound) {
+		errorResponse(w, http.StatusNotFound, "attachment content is missing")
+		return
+	}
+	if err != nil {
+		log.Printf("downloadAttachment: storage error: %v", err)
+		errorResponse(w, http.StatusBadGateway, "storage error")
+		return
+	}
+	defer body.Close()
+
+	disposition := "attachment"
+	if r.URL.Query().Get("inline") == "1" {
+		disposition = "inline"
+	}
+	w.Header().Set("Content-Type", a.ContentType)
+	w.Header().Set("Content-Length", strconv.FormatInt(a.Size, 10))
+	w.Header().Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": a.FileName}))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", "private, no-store")
+	if _, err := io.Copy(w, body); err != nil {
+		log.Printf("downloadAttachment: copy error: %v", err)
+	}
+}
+
+// DELETE /api/attachments/{id}; содержимое удаляется, когда на него не осталось ссылок
+func deleteAttachmentHandler(w http.ResponseWriter, r *http.Request, id int64) {
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	user, ok := requestUser(ctx, w, r)
+	if !ok {
+		return
+	}
+	a, err := loadAttachment(ctx, db, id)
+	if err != nil {
+		errorResponse(w, http.StatusNotFound, "attachment not found")
+		return
+	}
+	if !isClinicStaff(user) || !canAccessPatientFiles(user, a.PatientUID, a.ClinicID) {
+		errorResponse(w, http.StatusForbidden, "access denied")
+		return
+	}
+	if a.EpisodeID != nil {
+		var locked bool
+		if err := db.QueryRow(ctx, `SELECT locked FROM exam_episodes WHERE id = $1`, *a.EpisodeID).Scan(&locked); err == nil && locked {
+			errorResponse(w, http.StatusLocked, "episode is locked by the final conclusion")
+			return
+		}
+	}
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		errorResponse(w, http.StatusInternalServerError, "db error")
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, `DELETE FROM attachments WHERE id = $1`, id); err != nil {
+		errorResponse(w, http.StatusInternalServerError, "db error")
+		return
+	}
+	var orphanKey string
+	err = tx.QueryRow(ctx, `
+DELETE FROM attachment_blobs
+WHERE sha256 = $1 AND NOT EXISTS (SELECT 1 FROM attachments WHERE sha256 = $1)
+  AND NOT EXISTS (SELECT 1 FROM contract_documents WHERE pdf_sha256 = $1 OR docx_sha256 = $1)
+RETURNING storage_key
+`, a.SHA256).Scan(&orphanKey)
+	if err != nil && err != pgx.ErrNoRows {
+		errorResponse(w, http.StatusInternalServerError, "db error")
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		errorResponse(w, http.StatusInternalServerError, "db error")
+		return
+	}
+	if orphanKey != "" {
+		if err := attachmentStore.Delete(ctx, orphanKey); err != nil {
+			log.Printf("deleteAttachment: storage error: %v", err)
+		}
+	}
+	jsonResponse(w, http.StatusOK, map[string]string{"status": "deleted"})
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"strings"
+)
+
+// Ширины штрихов/пробелов символов Code128 (значения 0..105) и стоп-символа
+var code128Patterns = [...]string{
+	"212222", "222122", "222221", "121223", "121322", "131222", "122213", "122312", "132212", "221213",
+	"221312", "231212", "112232", "122132", "122231", "113222", "123122", "123221", "223211", "221132",
+	"221231", "213212", "223112", "312131", "311222", "321122", "321221", "312212", "322112", "322211",
+	"212123", "212321", "232121", "111323", "131123", "131321", "112313", "132113", "132311", "211313",
+	"231113", "231311", "112133", "112331", "132131", "113123", "113321", "133121", "313121", "211331",
+	"231131", "213113", "213311", "213131", "311123", "311321", "331121", "312113", "312311", "332111",
+	"314111", "221411", "431111", "111224", "111422", "121124", "121421", "141122", "141221", "112214",
+	"112412", "122114", "122411", "142112", "142211", "241211", "221114", "413111", "241112", "134111",
+	"111242", "121142", "121241", "114212", "124112", "124211", "411212", "421112", "421211", "212141",
+	"214121", "412121", "111143", "111341", "131141", "114113", "114311", "411113", "411311", "113141",
+	"114131", "311141", "411131", "211412", "211214", "211232",
+}
+
+const (
+	code128StartB  = 104
+	code128StartC  = 105
+	code128Stop    = "2331112"
+	code128Quiet   = 10 // тихая зона в модулях с каждой стороны
+	barcodeHeight  = 80
+	barcodeMaxText = 48
+)
+
+// encodeCode128 возвращает последовательность модулей (true - штрих).
+// Чисто цифровые значения чётной длины кодируются набором C, остальные - набором B.
+func encodeCode128(value string) ([]bool, error) {
+	if value == "" {
+		return nil, errors.New("empty barcode value")
+	}
+	if len(value) > barcodeMaxText {
+		return nil, errors.New("barcode value is too long")
+	}
+
+	var codes []int
+	if len(value)%2 == 0 && isDigits(value) {
+		codes = append(codes, code128StartC)
+		for i := 0; i < len(value); i += 2 {
+			codes = append(codes, int(value[i]-'0')*10+int(value[i+1]-'0'))
+		}
+	} else {
+		codes = append(codes, code128StartB)
+		for _, c := range []byte(value) {
+			if c < 32 || c > 126 {
+				return nil, fmt.Errorf("character %q is not supported by Code128 set B", c)
+			}
+			codes = append(codes, int(c)-32)
+		}
+	}
+
+	checksum := codes[0]
+	for i := 1; i < len(codes); i++ {
+		checksum += i * codes[i]
+	}
+	codes = append(codes, checksum%103)
+
+	var modules []bool
+	appendPattern := func(p string) {
+		for i, w := range p {
+			for n := 0; n < int(w-'0'); n++ {
+				modules = append(modules, i%2 == 0)
+			}
+		}
+	}
+	for _, c := range codes {
+		appendPattern(code128Patterns[c])
+	}
+	appendPattern(code128Stop)
+	return modules, nil
+}
+
+func isDigits(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// renderCode128PNG рисует штрихкод; scale - ширина модуля в пикселях
+func renderCode128PNG(value string, scale int) ([]byte, error) {
+	modules, err := encodeCode128(value)
+	if err != nil {
+		return nil, err
+	}
+	if scale < 1 {
+		scale = 1
+	}
+	width := (len(modules) + 2*code128Quiet) * scale
+	img := image.NewGray(image.Rect(0, 0, width, barcodeHeight))
+	for i := range img.Pix {
+		img.Pix[i] = 0xff
+	}
+	for i, bar := range modules {
+		if !bar {
+			continue
+		}
+		x0 := (code128Quiet + i) * scale
+		for x := x0; x < x0+scale; x++ {
+			for y := 0; y < barcodeHeight; y++ {
+				img.SetGray(x, y, color.Gray{Y: 0})
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// zplField убирает управляющие символы ZPL из текстового поля
+func zplField(s string) string {
+	return strings.NewReplacer("^", " ", "~", " ", "\n", " ", "\r", " ").Replace(s)
+}
@@ -0,0 +1,705 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Справки с QR-кодом: допуск к смене (предсменный осмотр) и заключение о годности
+// (подписанный эпизод периодического осмотра). В QR - ссылка с подписанным Ed25519
+// токеном: номер справки, результат и срок действия. Проверка публичная и не раскрывает
+// медицинских сведений; при переоткрытии заключения справка отзывается.
+
+const (
+	CertShiftAdmission = "shift_admission"
+	CertFitness        = "fitness"
+
+	CertActive  = "active"
+	CertRevoked = "revoked"
+
+	certTokenVersion = 1
+	certPayloadLen   = 27
+	// Допуск действует на одну смену
+	certAdmissionValidity = 12 * time.Hour
+	// Сверок ИИН одной справки с одного адреса за окно; дальше 429 до конца окна
+	certIINCheckLimit  = 5
+	certIINCheckWindow = time.Hour
+)
+
+var errTooManyIINChecks = errors.New("too many IIN checks")
+
+var certKindCodes = map[string]byte{CertShiftAdmission: 1, CertFitness: 2}
+
+// Результат в токене: допуск к смене - всегда admitted, годность - итог заключения
+var certResultCodes = map[string]byte{
+	"admitted":          1,
+	ActOutcomeFit:       2,
+	ActOutcomeTempUnfit: 3,
+	ActOutcomePermUnfit: 4,
+}
+
+var certResultTitles = map[string]string{
+	"admitted":          "допущен к работе",
+	ActOutcomeFit:       "годен к работе",
+	ActOutcomeTempUnfit: "временно не годен",
+	ActOutcomePermUnfit: "постоянно не годен",
+}
+
+type Certificate struct {
+	ID          int64   `json:"id"`
+	Kind        string  `json:"kind"`
+	ShiftExamID *int64  `json:"shiftExamId,omitempty"`
+	EpisodeID   *int64  `json:"episodeId,omitempty"`
+	PatientUID  *string `json:"patientUid,omitempty"`
+	ClinicID    string  `json:"clinicId"`
+	ClientBIN   string  `json:"clientBin,omitempty"`
+	HolderName  string  `json:"holderName"`
+	Result      string  `json:"result"`
+	IssuedAt    string  `json:"issuedAt"`
+	ValidUntil  string  `json:"validUntil"`
+	Status      string  `json:"status"`
+	RevokedAt   *string `json:"revokedAt,omitempty"`
+	RevokedWhy  string  `json:"revokeReason,omitempty"`
+	Token       string  `json:"token"`
+	VerifyURL   string  `json:"verifyUrl"`
+}
+
+func migrateCertificates(ctx context.Context, tx pgx.Tx) error {
+	_, err := tx.Exec(ctx, `
+CREATE TABLE IF NOT EXISTS certificates (
+  id             SERIAL PRIMARY KEY,
+  kind           TEXT NOT NULL,
+  shift_exam_id  INTEGER REFERENCES shift_exams(id) ON DELETE CASCADE,
+  episode_id     INTEGER REFERENCES exam_episodes(id) ON DELETE CASCADE,
+  patient_uid    TEXT,
+  clinic_id      TEXT NOT NULL,
+  client_bin     TEXT NOT NULL DEFAULT '',
+  holder_name    TEXT NOT NULL,
+  iin_hash       BYTEA,
+  result         TEXT NOT NULL,
+  issued_at      TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  valid_until    TIMESTAMPTZ NOT NULL,
+  token          TEXT NOT NULL DEFAULT '',
+  status         TEXT NOT NULL DEFAULT 'active',
+  issued_by      TEXT NOT NULL,
+  revoked_at     TIMESTAMPTZ,
+  revoke_reason  TEXT NOT NULL DEFAULT '',
+  CONSTRAINT valid_certificate_kind CHECK (kind IN ('shift_admission', 'fitness')),
+  CONSTRAINT valid_certificate_status CHECK (status IN ('active', 'revoked'))
+);
+`)
+	if err != nil {
+		return fmt.Errorf("migrate certificates: %w", err)
+	}
+	// Счётчики сверок ИИН по справке и адресу клиента; хеши первой версии (8 байт SHA-256
+	// без ключа) подбираются перебором и удаляются - по таким справкам сверка ИИН недоступна
+	_, err = tx.Exec(ctx, `
+CREATE TABLE IF NOT EXISTS certificate_iin_checks (
+  certificate_id INTEGER NOT NULL REFERENCES certificates(id) ON DELETE CASCADE,
+  client_ip      TEXT NOT NULL,
+  checks         INTEGER NOT NULL DEFAULT 0,
+  since          TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  PRIMARY KEY (certificate_id, client_ip)
+);
+ALTER TABLE certificates DROP COLUMN IF EXISTS iin_checks, DROP COLUMN IF EXISTS iin_checks_since;
+UPDATE certificates SET iin_hash = NULL WHERE octet_length(iin_hash) <> 32;
+`)
+	if err != nil {
+		return fmt.Errorf("migrate certificates iin checks: %w", err)
+	}
+	// Одна действующая справка на осмотр: повторная выдача возвращает её же
+	_, err = tx.Exec(ctx, `CREATE UNIQUE INDEX IF NOT EXISTS idx_certificates_shift ON certificates(shift_exam_id) WHERE status = 'active';`)
+	if err != nil {
+		return fmt.Errorf("create index certificates_shift: %w", err)
+	}
+	_, err = tx.Exec(ctx, `CREATE UNIQUE INDEX IF NOT EXISTS idx_certificates_episode ON certificates(episode_id) WHERE status = 'active';`)
+	if err != nil {
+		return fmt.Errorf("create index certificates_episode: %w", err)
+	}
+	return nil
+}
+
+// --- Подпись ---
+
+// certIINHash привязывает справку к ИИН: HMAC на ключе сервера, без которого ИИН по
+// хешу не подобрать; номер справки не даёт сопоставлять справки одного человека.
+// Хеш хранится только в базе и в токен не попадает.
+func certIINHash(id int64, iin string) []byte {
+	iin = strings.TrimSpace(iin)
+	if iin == "" {
+		return nil
+	}
+	mac := hmac.New(sha256.New, serverKeys.CertIIN)
+	fmt.Fprintf(mac, "%d:%s", id, iin)
+	return mac.Sum(nil)
+}
+
+type certClaims struct {
+	Kind       string
+	ID         int64
+	Result     string
+	IssuedAt   time.Time
+	ValidUntil time.Time
+}
+
+// signCertificate: версия, вид, номер, 8 байт резерва (в первых токенах - хеш ИИН),
+// результат, выдана, действует до + подпись
+func signCertificate(c certClaims) string {
+	p := make([]byte, certPayloadLen)
+	p[0] = certTokenVersion
+	p[1] = certKindCodes[c.Kind]
+	binary.BigEndian.PutUint64(p[2:10], uint64(c.ID))
+	p[18] = certResultCodes[c.Result]
+	binary.BigEndian.PutUint32(p[19:23], uint32(c.IssuedAt.Unix()))
+	binary.BigEndian.PutUint32(p[23:27], uint32(c.ValidUntil.Unix()))
+	return base64.RawURLEncoding.EncodeToString(append(p, ed25519.Sign(serverKeys.CertSigning, p)...))
+}
+
+func parseCertificateToken(token string) (*certClaims, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(strings.TrimSpace(token))
+	if err != nil || len(raw) != certPayloadLen+ed25519.SignatureSize || raw[0] != certTokenVersion {
+		return nil, errors.New("malformed certificate token")
+	}
+	p, sig := raw[:certPayloadLen], raw[certPayloadLen:]
+	if !ed25519.Verify(serverKeys.CertSigning.Public().(ed25519.PublicKey), p, sig) {
+		return nil, errors.New("invalid certificate signature")
+	}
+	c := &certClaims{
+		ID:         int64(binary.BigEndian.Uint64(p[2:10])),
+		IssuedAt:   time.Unix(int64(binary.BigEndian.Uint32(p[19:23])), 0),
+		ValidUntil: time.Unix(int64(binary.BigEndian.Uint32(p[23:27])), 0),
+	}
+	for k, code := range certKindCodes {
+		if code == p[1] {
+			c.Kind = k
+		}
+	}
+	for r, code := range certResultCodes {
+		if code == p[18] {
+			c.Result = r
+		}
+	}
+	return c, nil
+}
+
+// certVerifyURL - адрес из QR; CERT_VERIFY_URL указывает на публичную страницу проверки
+func certVerifyURL(token string) string {
+	return mustGetEnv("CERT_VERIFY_URL", "/api/certificates/verify") + "?t=" + token
+}
+
+// maskedName: «Иванов Иван Иванович» -> «Иванов И. И.»
+func maskedName(full string) string {
+	parts := strings.Fields(full)
+	if len(parts) == 0 {
+		return ""
+	}
+	out := parts[0]
+	for _, p := range parts[1:] {
+		r := []rune(p)
+		out += " " + string(r[0]) + "."
+	}
+	return out
+}
+
+// --- Загрузка ---
+
+const certificateColumns = `id, kind, shift_exam_id, episode_id, patient_uid, clinic_id, client_bin, holder_name, result,
+issued_at, valid_until, status, revoked_at, revoke_reason, token`
+
+func scanCertificate(row pgx.Row) (*Certificate, error) {
+	var c Certificate
+	var issued, until time.Time
+	var revoked *time.Time
+	err := row.Scan(&c.ID, &c.Kind, &c.ShiftExamID, &c.EpisodeID, &c.PatientUID, &c.ClinicID, &c.ClientBIN, &c.HolderName, &c.Result,
+		&issued, &until, &c.Status, &revoked, &c.RevokedWhy, &c.Token)
+	if err != nil {
+		return nil, err
+	}
+	c.IssuedAt = issued.UTC().Format(time.RFC3339)
+	c.ValidUntil = until.UTC().Format(time.RFC3339)
+	if revoked != nil {
+		s := revoked.Format(time.RFC3339)
+		c.RevokedAt = &s
+	}
+	c.VerifyURL = certVerifyURL(c.Token)
+	return &c, nil
+}
+
+// canSeeCertificate: выдавшая клиника, работник и его организация
+func canSeeCertificate(u *User, c *Certificate) bool {
+	switch {
+	case isClinicStaff(u):
+		return userClinicID(u) == c.ClinicID
+	case u.Role == UserRoleEmployee:
+		return c.PatientUID != nil && *c.PatientUID == u.ID
+	case u.Role == UserRoleOrganization:
+		return u.BIN != nil && c.ClientBIN != "" && *u.BIN == c.ClientBIN
+	}
+	return false
+}
+
+// certSource - то, что подписывается в справке, из осмотра-основания
+type certSource struct {
+	kind       string
+	shiftExam  *int64
+	episode    *int64
+	patientUID *string
+	clinicID   string
+	clientBIN  string
+	holder     string
+	iin        string
+	result     string
+	issuedAt   time.Time
+	validUntil time.Time
+}
+
+// shiftCertSource - допуск по предсменному осмотру
+func shiftCertSource(ctx context.Context, u *User, id int64) (*certSource, int, error) {
+	e, _, err := scanShiftExam(db.QueryRow(ctx, `SELECT `+shiftExamColumns+` FROM shift_exams WHERE id = $1`, id))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, http.StatusNotFound, errors.New("shift exam not found")
+	}
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	if e.ClinicID != userClinicID(u) {
+		return nil, http.StatusForbidden, errForeignClinic
+	}
+	if e.Kind != ShiftExamPre || !e.Admitted {
+		return nil, http.StatusConflict, errors.New("certificate is issued only for an admitted pre-shift exam")
+	}
+	examAt, _ := time.Parse(time.RFC3339, e.ExamAt)
+	src := &certSource{
+		kind: CertShiftAdmission, shiftExam: &e.ID, patientUID: e.PatientUID, clinicID: e.ClinicID, clientBIN: e.ClientBIN,
+		holder: e.EmployeeName, result: "admitted", issuedAt: examAt, validUntil: examAt.Add(certAdmissionValidity),
+	}
+	if e.PatientUID != nil {
+		_ = db.QueryRow(ctx, `SELECT iin FROM ambulatory_cards WHERE patient_uid = $1`, *e.PatientUID).Scan(&src.iin)
+	}
+	return src, 0, nil
+}
+
+// fitnessCertSource - годность по подписанному заключению эпизода.
+// Срок - до следующего периодического осмотра.
+func fitnessCertSource(ctx context.Context, u *User, id int64) (*certSource, int, error) {
+	e, err := loadEpisode(ctx, db, id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, http.StatusNotFound, errors.New("episode not found")
+	}
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	if e.ClinicID == nil || *e.ClinicID != userClinicID(u) {
+		return nil, http.StatusForbidden, errForeignClinic
+	}
+	if !e.Locked {
+		return nil, http.StatusConflict, errors.New("final conclusion is not signed")
+	}
+	var final map[string]any
+	_ = json.Unmarshal(e.Final, &final)
+	var worker FinalActWorker
+	classifyWorker(&worker, final, nil)
+	if _, ok := certResultCodes[worker.Outcome]; !ok {
+		return nil, http.StatusConflict, errors.New("conclusion requires further examination")
+	}
+
+	examDate, err := parseDate(e.ExamDate)
+	if err != nil {
+		examDate = today()
+	}
+	src := &certSource{
+		kind: CertFitness, episode: &e.ID, patientUID: &e.PatientUID, clinicID: *e.ClinicID,
+		result: worker.Outcome, issuedAt: time.Now(),
+	}
+	var general map[string]any
+	var generalJSON []byte
+	if db.QueryRow(ctx, `SELECT iin, general FROM ambulatory_cards WHERE patient_uid = $1`, e.PatientUID).Scan(&src.iin, &generalJSON) == nil {
+		_ = json.Unmarshal(generalJSON, &general)
+		src.holder = cardText(general, "fullName")
+	}
+	if e.ContractID != nil {
+		_ = db.QueryRow(ctx, `SELECT client_bin FROM contracts WHERE id = $1`, *e.ContractID).Scan(&src.clientBIN)
+	}
+	// Срок годности: расчёт из реестра повторных осмотров, иначе дата из заключения, иначе год
+	var due time.Time
+	if db.QueryRow(ctx, `SELECT next_due FROM exam_recalls WHERE episode_id = $1`, e.ID).Scan(&due) != nil {
+		if d, ok := parseExamDate(cardText(final, "nextExamDate")); ok && d.After(examDate) {
+			due = d
+		} else {
+			due = addMonths(examDate, annualExamMonths)
+		}
+	}
+	src.validUntil = due.AddDate(0, 0, 1) // включительно
+	if src.holder == "" {
+		return nil, http.StatusConflict, errors.New("patient name is missing in the ambulatory card")
+	}
+	return src, 0, nil
+}
+
+// issueCertificate сохраняет справку и подписывает её по присвоенному номеру.
+// Если действующая справка уже есть, возвращает её.
+func issueCertificate(ctx context.Context, u *User, src *certSource) (*Certificate, bool, error) {
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+	defer tx.Rollback(ctx)
+
+	var id int64
+	err = tx.QueryRow(ctx, `
+INSERT INTO certificates (kind, shift_exam_id, episode_id, patient_uid, clinic_id, client_bin, holder_name, result,
+  issued_at, valid_until, issued_by)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+ON CONFLICT DO NOTHING
+RETURNING id`, src.kind, src.shiftExam, src.episode, src.patientUID, src.clinicID, src.clientBIN, src.holder, src.result,
+		src.issuedAt, src.validUntil, u.ID).Scan(&id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		c, err := scanCertificate(tx.QueryRow(ctx, `SELECT `+certificateColumns+` FROM certificates
+WHERE status = 'active' AND (shift_exam_id = $1 OR episode_id = $2)`, src.shiftExam, src.episode))
+		return c, false, err
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	hash := certIINHash(id, src.iin)
+	token := signCertificate(certClaims{
+		Kind: src.kind, ID: id, Result: src.result, IssuedAt: src.issuedAt, ValidUntil: src.validUntil,
+	})
+	c, err := scanCertificate(tx.QueryRow(ctx, `UPDATE certificates SET token = $2, iin_hash = $3 WHERE id = $1
+RETURNING `+certificateColumns, id, token, hash))
+	if err != nil {
+		return nil, false, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, false, err
+	}
+	return c, true, nil
+}
+
+// revokeEpisodeCertificates отзывает справки о годности при переоткрытии заключения
+func revokeEpisodeCertificates(ctx context.Context, q dbExecutor, episodeID int64, reason string) ([]int64, error) {
+	rows, err := q.Query(ctx, `
+UPDATE certificates SET status = 'revoked', revoked_at = NOW(), revoke_reason = $2
+WHERE episode_id = $1 AND status = 'active'
+RETURNING id`, episodeID, reason)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// --- Handlers ---
+
+// POST /api/certificates {shiftExamId} | {episodeId}
+func issueCertificateHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	user, ok := requestUser(ctx, w, r)
+	if !ok {
+		return
+	}
+	if !isClinicStaff(user) {
+		errorResponse(w, http.StatusForbidden, "only medical staff can issue certificates")
+		return
+	}
+	var in struct {
+		ShiftExamID *int64 `json:"shiftExamId"`
+		EpisodeID   *int64 `json:"episodeId"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		errorResponse(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+
+	var src *certSource
+	var status int
+	var err error
+	switch {
+	case in.ShiftExamID != nil && in.EpisodeID == nil:
+		src, status, err = shiftCertSource(ctx, user, *in.ShiftExamID)
+	case in.EpisodeID != nil && in.ShiftExamID == nil:
+		src, status, err = fitnessCertSource(ctx, user, *in.EpisodeID)
+	default:
+		errorResponse(w, http.StatusBadRequest, "exactly one of shiftExamId or episodeId is required")
+		return
+	}
+	if err != nil {
+		if status == http.StatusInternalServerError {
+			log.Printf("issueCertificate source error: %v", err)
+			errorResponse(w, status, "db error")
+			return
+		}
+		errorResponse(w, status, err.Error())
+		return
+	}
+
+	c, created, err := issueCertificate(ctx, user, src)
+	if err != nil {
+		log.Printf("issueCertificate error: %v", err)
+		errorResponse(w, http.StatusInternalServerError, "db error")
+		return
+	}
+	if !created {
+		jsonResponse(w, http.StatusOK, c)
+		return
+	}
+	event := map[string]any{"id": c.ID, "kind": c.Kind, "result": c.Result, "validUntil": c.ValidUntil}
+	if c.PatientUID != nil {
+		broadcastToUser(*c.PatientUID, "certificate_issued", event)
+	}
+	jsonResponse(w, http.StatusCreated, c)
+}
+
+// GET /api/certificates/{id}, GET .../qr (PNG), GET .../print?format=pdf|docx,
+// POST .../revoke {reason}
+func certificateHandler(w http.ResponseWriter, r *http.Request, id int64, sub string) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	user, ok := requestUser(ctx, w, r)
+	if !ok {
+		return
+	}
+	c, err := scanCertificate(db.QueryRow(ctx, `SELECT `+certificateColumns+` FROM certificates WHERE id = $1`, id))
+	if errors.Is(err, pgx.ErrNoRows) {
+		errorResponse(w, http.StatusNotFound, "certificate not found")
+		return
+	}
+	if err != nil {
+		log.Printf("certificate %d: %v", id, err)
+		errorResponse(w, http.StatusInternalServerError, "db error")
+		return
+	}
+	if !canSeeCertificate(user, c) {
+		errorResponse(w, http.StatusForbidden, "access denied")
+		return
+	}
+
+	switch {
+	case sub == "" && r.Method == http.MethodGet:
+		jsonResponse(w, http.StatusOK, c)
+	case sub == "qr" && r.Method == http.MethodGet:
+		scale, _ := strconv.Atoi(r.URL.Query().Get("scale"))
+		if scale <= 0 || scale > 20 {
+			scale = 6
+		}
+		img, err := renderQRPNG(c.VerifyURL, scale)
+		if err != nil {
+			errorResponse(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(img)
+	case sub == "print" && r.Method == http.MethodGet:
+		report, err := buildCertificateReport(ctx, c)
+		if err != nil {
+			errorResponse(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		writeReport(w, report, fmt.Sprintf("certificate-%d", c.ID), r.URL.Query().Get("format"))
+	case sub == "revoke" && r.Method == http.MethodPost:
+		if !isClinicStaff(user) {
+			errorResponse(w, http.StatusForbidden, "only the issuing clinic can revoke a certificate")
+			return
+		}
+		var in struct {
+			Reason string `json:"reason"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&in); err != nil || strings.TrimSpace(in.Reason) == "" {
+			errorResponse(w, http.StatusBadRequest, "reason is required")
+			return
+		}
+		c, err = scanCertificate(db.QueryRow(ctx, `
+UPDATE certificates SET status = 'revoked', revoked_at = NOW(), revoke_reason = $2
+WHERE id = $1 AND status = 'active'
+RETURNING `+certificateColumns, id, strings.TrimSpace(in.Reason)))
+		if errors.Is(err, pgx.ErrNoRows) {
+			errorResponse(w, http.StatusConflict, "certificate is already revoked")
+			return
+		}
+		if err != nil {
+			log.Printf("revokeCertificate %d: %v", id, err)
+			errorResponse(w, http.StatusInternalServerError, "db error")
+			return
+		}
+		if c.PatientUID != nil {
+			broadcastToUser(*c.PatientUID, "certificate_revoked", map[string]any{"id": c.ID, "kind": c.Kind})
+		}
+		jsonResponse(w, http.StatusOK, c)
+	default:
+		errorResponse(w, http.StatusNotFound, "not found")
+	}
+}
+
+func buildCertificateReport(ctx context.Context, c *Certificate) (*reportDoc, error) {
+	q, err := encodeQR([]byte(c.VerifyURL))
+	if err != nil {
+		return nil, err
+	}
+	var clinicName string
+	_ = db.QueryRow(ctx, `SELECT COALESCE(company_name, '') FROM users WHERE id = $1`, c.ClinicID).Scan(&clinicName)
+	issued, _ := time.Parse(time.RFC3339, c.IssuedAt)
+	until, _ := time.Parse(time.RFC3339, c.ValidUntil)
+
+	d := &reportDoc{footer: "Справка № " + strconv.FormatInt(c.ID, 10)}
+	if c.Kind == CertShiftAdmission {
+		d.Title("СПРАВКА О ДОПУСКЕ К РАБОТЕ ПО РЕЗУЛЬТАТАМ ПРЕДСМЕННОГО МЕДИЦИНСКОГО ОСМОТРА")
+	} else {
+		d.Title("СПРАВКА О РЕЗУЛЬТАТАХ ПЕРИОДИЧЕСКОГО МЕДИЦИНСКОГО ОСМОТРА")
+	}
+	d.Text("№ " + strconv.FormatInt(c.ID, 10) + " от " + issued.Local().Format("02.01.2006 15:04"))
+	d.Text("Работник: " + c.HolderName)
+	d.Text("Медицинская организация: " + orDash(clinicName))
+	d.Bold("Результат: " + certResultTitles[c.Result])
+	d.Text("Действительна до: " + until.Local().Format("02.01.2006 15:04"))
+	if c.Status == CertRevoked {
+		d.Bold("СПРАВКА ОТОЗВАНА: " + c.RevokedWhy)
+	}
+	d.QR(q, 140)
+	d.Text("Подлинность и срок действия проверяются по QR-коду. При проверке сверьте ИИН с удостоверением личности.")
+	return d, nil
+}
+
+// GET /api/certificates/verify?t=<токен>[&iin=] - публичная проверка по QR.
+// Показывает только вид справки, результат, срок и инициалы.
+func verifyCertificateHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	claims, err := parseCertificateToken(r.URL.Query().Get("t"))
+	if err != nil {
+		jsonResponse(w, http.StatusOK, map[string]any{"valid": false, "status": "invalid"})
+		return
+	}
+	var holder, clinicName, status string
+	err = db.QueryRow(ctx, `
+SELECT c.holder_name, COALESCE(u.company_name, ''), c.status
+FROM certificates c LEFT JOIN users u ON u.id = c.clinic_id
+WHERE c.id = $1`, claims.ID).Scan(&holder, &clinicName, &status)
+	if err != nil {
+		// Подпись верна, но записи нет - справку удалили вместе с осмотром
+		jsonResponse(w, http.StatusOK, map[string]any{"valid": false, "status": "invalid"})
+		return
+	}
+
+	res := map[string]any{
+		"id":         claims.ID,
+		"kind":       claims.Kind,
+		"result":     claims.Result,
+		"resultText": certResultTitles[claims.Result],
+		"issuedAt":   claims.IssuedAt.UTC().Format(time.RFC3339),
+		"validUntil": claims.ValidUntil.UTC().Format(time.RFC3339),
+		"holder":     maskedName(holder),
+		"clinicName": clinicName,
+	}
+	switch {
+	case status == CertRevoked:
+		// Причину отзыва не показываем: в ней могут быть медицинские сведения
+		res["status"] = "revoked"
+	case time.Now().After(claims.ValidUntil):
+		res["status"] = "expired"
+	default:
+		res["status"] = "valid"
+	}
+	res["valid"] = res["status"] == "valid"
+	if iin := strings.TrimSpace(r.URL.Query().Get("iin")); iin != "" {
+		match, err := checkCertificateIIN(ctx, db, claims.ID, certClientIP(r), iin)
+		if errors.Is(err, errTooManyIINChecks) {
+			w.Header().Set("Retry-After", strconv.Itoa(int(certIINCheckWindow.Seconds())))
+			errorResponse(w, http.StatusTooManyRequests, err.Error())
+			return
+		}
+		if err != nil {
+			log.Printf("verifyCertificate %d: iin check: %v", claims.ID, err)
+			errorResponse(w, http.StatusInternalServerError, "db error")
+			return
+		}
+		// nil - справка выдана без ИИН или до смены хеша, сверить нельзя
+		res["iinMatch"] = match
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	jsonResponse(w, http.StatusOK, res)
+}
+
+// certClientIP - адрес клиента для счётчика сверок. За обратным прокси (TRUST_PROXY=on)
+// берётся последний адрес X-Forwarded-For - его дописал наш прокси, остальные присланы клиентом.
+func certClientIP(r *http.Request) string {
+	if mustGetEnv("TRUST_PROXY", "off") == "on" {
+		hops := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+		if ip := strings.TrimSpace(hops[len(hops)-1]); ip != "" {
+			return ip
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// checkCertificateIIN сверяет ИИН с хешем справки. Сверки считаются по справке и адресу
+// клиента: перебор с одного адреса упирается в certIINCheckLimit, но не блокирует
+// сверку владельцу и работодателю с других адресов.
+func checkCertificateIIN(ctx context.Context, q dbExecutor, id int64, clientIP, iin string) (*bool, error) {
+	since := time.Now().Add(-certIINCheckWindow)
+	_, err := q.Exec(ctx, `DELETE FROM certificate_iin_checks WHERE certificate_id = $1 AND since <= $2`, id, since)
+	if err != nil {
+		return nil, err
+	}
+	var checks int
+	err = q.QueryRow(ctx, `
+INSERT INTO certificate_iin_checks (certificate_id, client_ip, checks) VALUES ($1, $2, 1)
+ON CONFLICT (certificate_id, client_ip) DO UPDATE SET checks = certificate_iin_checks.checks + 1
+RETURNING checks`, id, clientIP).Scan(&checks)
+	if err != nil {
+		return nil, err
+	}
+	if checks > certIINCheckLimit {
+		return nil, errTooManyIINChecks
+	}
+	var stored []byte
+	if err := q.QueryRow(ctx, `SELECT iin_hash FROM certificates WHERE id = $1`, id).Scan(&stored); err != nil {
+		return nil, err
+	}
+	if len(stored) == 0 {
+		return nil, nil
+	}
+	match := hmac.Equal(certIINHash(id, iin), stored)
+	return &match, nil
+}
+
+// GET /api/certificates/public-key - для офлайн-проверки подписи
+func certificatePublicKeyHandler(w http.ResponseWriter, r *http.Request) {
+	pub := serverKeys.CertSigning.Public().(ed25519.PublicKey)
+	jsonResponse(w, http.StatusOK, map[string]any{
+		"algorithm": "Ed25519",
+		"publicKey": base64.StdEncoding.EncodeToString(pub),
+		"format":    "base64url(payload[27] || signature[64]); payload: version, kind, id u64, reserved[8], result, issued u32, valid until u32",
+	})
+}
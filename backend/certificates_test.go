@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+func testClaims() certClaims {
+	issued := time.Date(2026, 3, 2, 7, 30, 0, 0, time.UTC)
+	return certClaims{Kind: CertFitness, ID: 4242, Result: ActOutcomeTempUnfit, IssuedAt: issued, ValidUntil: issued.AddDate(1, 0, 0)}
+}
+
+func TestCertificateTokenRoundTrip(t *testing.T) {
+	want := testClaims()
+	token := signCertificate(want)
+	got, err := parseCertificateToken(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Kind != want.Kind || got.ID != want.ID || got.Result != want.Result ||
+		!got.IssuedAt.Equal(want.IssuedAt) || !got.ValidUntil.Equal(want.ValidUntil) {
+		t.Errorf("parsed %+v, want %+v", got, want)
+	}
+
+	// ИИН и его хеш в токен не попадают
+	raw, _ := base64.RawURLEncoding.DecodeString(token)
+	if !bytes.Equal(raw[10:18], make([]byte, 8)) {
+		t.Errorf("reserved bytes are not empty: %x", raw[10:18])
+	}
+}
+
+func TestCertificateTokenRejectsTampering(t *testing.T) {
+	raw, _ := base64.RawURLEncoding.DecodeString(signCertificate(testClaims()))
+	encode := base64.RawURLEncoding.EncodeToString
+
+	// Результат «временно не годен» -> «годен»
+	forged := bytes.Clone(raw)
+	forged[18] = certResultCodes[ActOutcomeFit]
+	// Срок действия продлён
+	extended := bytes.Clone(raw)
+	extended[23]++
+	badSig := bytes.Clone(raw)
+	badSig[len(badSig)-1] ^= 1
+	version := bytes.Clone(raw)
+	version[0] = certTokenVersion + 1
+
+	// Подпись чужим ключом
+	other := ed25519.NewKeyFromSeed(bytes.Repeat([]byte{1}, ed25519.SeedSize))
+	foreign := append(bytes.Clone(raw[:certPayloadLen]), ed25519.Sign(other, raw[:certPayloadLen])...)
+
+	for name, token := range map[string]string{
+		"result":    encode(forged),
+		"validity":  encode(extended),
+		"signature": encode(badSig),
+		"version":   encode(version),
+		"foreign":   encode(foreign),
+		"truncated": encode(raw[:len(raw)-1]),
+		"empty":     "",
+		"garbage":   "not a token!",
+	} {
+		if c, err := parseCertificateToken(token); err == nil {
+			t.Errorf("%s: tampered token accepted: %+v", name, c)
+		}
+	}
+}
+
+func TestCertIINHash(t *testing.T) {
+	h := certIINHash(7, " 850412300123 ")
+	if len(h) != 32 || !bytes.Equal(h, certIINHash(7, "850412300123")) {
+		t.Fatalf("hash = %x", h)
+	}
+	if bytes.Equal(h, certIINHash(8, "850412300123")) {
+		t.Error("hash must depend on the certificate number")
+	}
+	if certIINHash(7, "  ") != nil {
+		t.Error("empty IIN must not be hashed")
+	}
+
+	// Без ключа сервера хеш не воспроизводится
+	prev := serverKeys
+	defer func() { serverKeys = prev }()
+	k := *prev
+	k.CertIIN = bytes.Repeat([]byte{9}, 32)
+	serverKeys = &k
+	if bytes.Equal(h, certIINHash(7, "850412300123")) {
+		t.Error("hash must depend on the server key")
+	}
+}
+
+func TestMaskedName(t *testing.T) {
+	for in, want := range map[string]string{
+		"Иванов Иван Иванович": "Иванов И. И.",
+		"Иванов":               "Иванов",
+		"  ":                   "",
+	} {
+		if got := maskedName(in); got != want {
+			t.Errorf("maskedName(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+// fakeIINCheckDB - счётчики сверок по справке и адресу и хеш ИИН справки
+type fakeIINCheckDB struct {
+	checks map[string]int
+	hash   []byte
+}
+
+func (f *fakeIINCheckDB) Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error) {
+	if !strings.Contains(sql, "DELETE FROM certificate_iin_checks") {
+		return pgconn.CommandTag{}, errors.New("unexpected exec: " + sql)
+	}
+	return pgconn.NewCommandTag("DELETE 0"), nil
+}
+
+func (f *fakeIINCheckDB) QueryRow(ctx context.Context, sql string, args ...any) pgx.Row {
+	switch {
+	case strings.Contains(sql, "INSERT INTO certificate_iin_checks"):
+		key := fmt.Sprintf("%d/%s", args[0], args[1])
+		f.checks[key]++
+		return fakeRow{vals: []any{f.checks[key]}}
+	case strings.Contains(sql, "SELECT iin_hash FROM certificates"):
+		return fakeRow{vals: []any{f.hash}}
+	}
+	return fakeRow{err: errors.New("unexpected query: " + sql)}
+}
+
+func (f *fakeIINCheckDB) Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error) {
+	return nil, errors.New("unexpected query")
+}
+
+// Перебор ИИН с одного адреса не блокирует сверку с других
+func TestCheckCertificateIINLimitsPerClient(t *testing.T) {
+	ctx := context.Background()
+	q := &fakeIINCheckDB{checks: map[string]int{}, hash: certIINHash(7, "850412300123")}
+	for i := 0; i < certIINCheckLimit; i++ {
+		match, err := checkCertificateIIN(ctx, q, 7, "203.0.113.5", fmt.Sprintf("85041230%04d", i))
+		if err != nil || match == nil || *match {
+			t.Fatalf("check %d: %v, %v", i, match, err)
+		}
+	}
+	if _, err := checkCertificateIIN(ctx, q, 7, "203.0.113.5", "850412300123"); !errors.Is(err, errTooManyIINChecks) {
+		t.Fatalf("check over the limit: %v", err)
+	}
+
+	match, err := checkCertificateIIN(ctx, q, 7, "198.51.100.9", "850412300123")
+	if err != nil || match == nil || !*match {
+		t.Errorf("holder locked out by another client: %v, %v", match, err)
+	}
+	if _, err := checkCertificateIIN(ctx, q, 8, "203.0.113.5", "850412300123"); err != nil {
+		t.Errorf("limit of one certificate applied to another: %v", err)
+	}
+}
+
+func TestCertClientIP(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/api/certificates/verify", nil)
+	r.RemoteAddr = "10.0.0.2:51234"
+	r.Header.Set("X-Forwarded-For", "1.2.3.4, 203.0.113.5")
+	if got := certClientIP(r); got != "10.0.0.2" {
+		t.Errorf("without TRUST_PROXY: %s", got)
+	}
+	t.Setenv("TRUST_PROXY", "on")
+	// Первые адреса цепочки присылает клиент, последний дописал наш прокси
+	if got := certClientIP(r); got != "203.0.113.5" {
+		t.Errorf("behind proxy: %s", got)
+	}
+	r.Header.Del("X-Forwarded-For")
+	if got := certClientIP(r); got != "10.0.0.2" {
+		t.Errorf("proxy without header: %s", got)
+	}
+}
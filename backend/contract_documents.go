@@ -0,0 +1,375 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Документы договора, которые формирует сервер (заключительный акт и т.п.).
+// Каждая перегенерация с изменившимся содержимым - новая версия; файлы лежат
+// в том же хранилище, что и вложения (attachment_blobs).
+
+const (
+	ContractDocFinalAct   = "final_act"
+	ContractDocHealthPlan = "health_plan"
+)
+
+var contractDocTitles = map[string]string{
+	ContractDocFinalAct:   "Заключительный акт",
+	ContractDocHealthPlan: "План оздоровления",
+}
+
+type ContractDocumentVersion struct {
+	ID          int64           `json:"id"`
+	ContractID  int64           `json:"contractId"`
+	DocType     string          `json:"type"`
+	Version     int             `json:"version"`
+	Title       string          `json:"title"`
+	ContentHash string          `json:"contentSha256"`
+	Content     json.RawMessage `json:"content,omitempty"`
+	PDFURL      string          `json:"pdfUrl"`
+	DOCXURL     string          `json:"docxUrl"`
+	GeneratedBy string          `json:"generatedBy"`
+	CreatedAt   string          `json:"createdAt"`
+	// Unchanged - содержимое совпало с последней версией, новая не создавалась
+	Unchanged bool `json:"unchanged,omitempty"`
+}
+
+func migrateContractDocuments(ctx context.Context, tx pgx.Tx) error {
+	_, err := tx.Exec(ctx, `
+CREATE TABLE IF NOT EXISTS contract_documents (
+  id             SERIAL PRIMARY KEY,
+  contract_id    INTEGER NOT NULL REFERENCES contracts(id) ON DELETE CASCADE,
+  doc_type       TEXT NOT NULL,
+  version        INTEGER NOT NULL,
+  title          TEXT NOT NULL,
+  content        JSONB NOT NULL,
+  content_sha256 TEXT NOT NULL,
+  pdf_sha256     TEXT NOT NULL REFERENCES attachment_blobs(sha256),
+  docx_sha256    TEXT NOT NULL REFERENCES attachment_blobs(sha256),
+  generated_by   TEXT NOT NULL,
+  created_at     TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  UNIQUE(contract_id, doc_type, version)
+);
+`)
+	if err != nil {
+		return fmt.Errorf("migrate contract_documents: %w", err)
+	}
+	return nil
+}
+
+// contractParties - стороны договора и их пользователи (для прав доступа и уведомлений)
+type contractParties struct {
+	ClientBIN    string
+	ClinicBIN    string
+	ClinicUserID string
+	OrgUserID    string
+}
+
+func loadContractParties(ctx context.Context, contractID int64) (*contractParties, error) {
+	p := &contractParties{}
+	err := db.QueryRow(ctx, `
+SELECT c.client_bin, c.clinic_bin,
+       COALESCE((SELECT id FROM users WHERE bin = c.clinic_bin AND role = 'clinic' LIMIT 1), ''),
+       COALESCE((SELECT id FROM users WHERE bin = c.client_bin AND role = 'organization' LIMIT 1), '')
+FROM contracts c WHERE c.id = $1
+`, contractID).Scan(&p.ClientBIN, &p.ClinicBIN, &p.ClinicUserID, &p.OrgUserID)
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// isClinicSide - пользователь работает в клинике - исполнителе договора
+func (p *contractParties) isClinicSide(u *User) bool {
+	if !isClinicStaff(u) {
+		return false
+	}
+	if p.ClinicUserID != "" && userClinicID(u) == p.ClinicUserID {
+		return true
+	}
+	if u.Role == UserRoleClinic {
+		return u.BIN != nil && *u.BIN == p.ClinicBIN
+	}
+	return u.ClinicBIN != nil && *u.ClinicBIN == p.ClinicBIN
+}
+
+func (p *contractParties) isClientSide(u *User) bool {
+	return u.Role == UserRoleOrganization && u.BIN != nil && *u.BIN == p.ClientBIN
+}
+
+func (p *contractParties) userIDs() []string {
+	var ids []string
+	for _, id := range []string{p.ClinicUserID, p.OrgUserID} {
+		if id != "" {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// authorizeContract загружает стороны договора и проверяет, что пользователь - одна из них.
+// clinicOnly - действие доступно только клинике.
+func authorizeContract(ctx context.Context, w http.ResponseWriter, r *http.Request, contractID int64, clinicOnly bool) (*User, *contractParties, bool) {
+	user, ok := requestUser(ctx, w, r)
+	if !ok {
+		return nil, nil, false
+	}
+	if clinicOnly && !isClinicStaff(user) {
+		errorResponse(w, http.StatusForbidden, "access denied")
+		return nil, nil, false
+	}
+	parties, err := records.ContractParties(ctx, contractID)
+	if err == pgx.ErrNoRows {
+		errorResponse(w, http.StatusNotFound, "contract not found")
+		return nil, nil, false
+	}
+	if err != nil {
+		log.Printf("authorizeContract: %v", err)
+		errorResponse(w, http.StatusInternalServerError, "db error")
+		return nil, nil, false
+	}
+	if parties.isClinicSide(user) || (!clinicOnly && parties.isClientSide(user)) {
+		return user, parties, true
+	}
+	errorResponse(w, http.StatusForbidden, "access denied")
+	return nil, nil, false
+}
+
+func contractDocumentURL(contractID, docID int64, format string) string {
+	return fmt.Sprintf("/api/contracts/%d/documents/%d/%s", contractID, docID, format)
+}
+
+const contractDocumentColumns = `id, contract_id, doc_type, version, title, content_sha256, generated_by, created_at`
+
+func scanContractDocument(row pgx.Row) (*ContractDocumentVersion, error) {
+	var d ContractDocumentVersion
+	var createdAt time.Time
+	if err := row.Scan(&d.ID, &d.ContractID, &d.DocType, &d.Version, &d.Title, &d.ContentHash, &d.GeneratedBy, &createdAt); err != nil {
+		return nil, err
+	}
+	d.CreatedAt = createdAt.Format(time.RFC3339)
+	d.PDFURL = contractDocumentURL(d.ContractID, d.ID, "pdf")
+	d.DOCXURL = contractDocumentURL(d.ContractID, d.ID, "docx")
+	return &d, nil
+}
+
+// saveContractDocument сохраняет новую версию документа. Если содержимое не изменилось
+// с прошлой версии, возвращается она (Unchanged = true).
+func saveContractDocument(ctx context.Context, contractID int64, docType string, content any, pdf, docx []byte, generatedBy string) (*ContractDocumentVersion, error) {
+	contentJSON, err := json.Marshal(content)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(contentJSON)
+	contentHash := hex.EncodeToString(sum[:])
+
+	last, err := scanContractDocument(db.QueryRow(ctx, `
+SELECT `+contractDocumentColumns+` FROM contract_documents
+WHERE contract_id = $1 AND doc_type = $2 ORDER BY version DESC LIMIT 1
+`, contractID, docType))
+	if err == nil && last.ContentHash == contentHash {
+		last.Unchanged = true
+		return last, nil
+	}
+	if err != nil && err != pgx.ErrNoRows {
+		return nil, err
+	}
+
+	putFile := func(data []byte, contentType string) (string, error) {
+		s := sha256.Sum256(data)
+		h := hex.EncodeToString(s[:])
+		_, err := putAttachmentBlob(ctx, bytes.NewReader(data), int64(len(data)), h, contentType)
+		return h, err
+	}
+	pdfSum, err := putFile(pdf, "application/pdf")
+	if err != nil {
+		return nil, fmt.Errorf("store pdf: %w", err)
+	}
+	docxSum, err := putFile(docx, "application/vnd.openxmlformats-officedocument.wordprocessingml.document")
+	if err != nil {
+		return nil, fmt.Errorf("store docx: %w", err)
+	}
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	// Блокировка договора сериализует параллельные перегенерации (номер версии)
+	if _, err := tx.Exec(ctx, `SELECT 1 FROM contracts WHERE id = $1 FOR UPDATE`, contractID); err != nil {
+		return nil, err
+	}
+	doc, err := scanContractDocument(tx.QueryRow(ctx, `
+INSERT INTO contract_documents (contract_id, doc_type, version, title, content, content_sha256, pdf_sha256, docx_sha256, generated_by)
+VALUES ($1, $2, (SELECT COALESCE(MAX(version), 0) + 1 FROM contract_documents WHERE contract_id = $1 AND doc_type = $2),
+        $3, $4, $5, $6, $7, $8)
+RETURNING `+contractDocumentColumns,
+		contractID, docType, contractDocTitles[docType], contentJSON, contentHash, pdfSum, docxSum, generatedBy))
+	if err != nil {
+		return nil, err
+	}
+
+	// Карточка документа в contracts.documents - её показывает список документов договора
+	entry, _ := json.Marshal(map[string]any{
+		"id":      fmt.Sprintf("%s_v%d", docType, doc.Version),
+		"type":    docType,
+		"title":   fmt.Sprintf("%s (версия %d)", doc.Title, doc.Version),
+		"date":    time.Now().Format("02.01.2006"),
+		"url":     doc.PDFURL,
+		"docxUrl": doc.DOCXURL,
+		"version": doc.Version,
+	})
+	_, err = tx.Exec(ctx, `
+UPDATE contracts SET documents = COALESCE((
+    SELECT jsonb_agg(d) FROM jsonb_array_elements(COALESCE(documents, '[]'::jsonb)) AS d
+    WHERE d->>'type' IS DISTINCT FROM $2
+), '[]'::jsonb) || jsonb_build_array($3::jsonb)
+WHERE id = $1
+`, contractID, docType, entry)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// --- HANDLERS ---
+
+// GET /api/contracts/{id}/documents[?type=final_act]
+func listContractDocumentsHandler(w http.ResponseWriter, r *http.Request, contractID int64) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	user, parties, ok := authorizeContract(ctx, w, r, contractID, false)
+	if !ok {
+		return
+	}
+
+	query := `SELECT ` + contractDocumentColumns + ` FROM contract_documents WHERE contract_id = $1`
+	args := []any{contractID}
+	if t := r.URL.Query().Get("type"); t != "" {
+		args = append(args, t)
+		query += fmt.Sprintf(" AND doc_type = $%d", len(args))
+	}
+	// Работодателю - только документы без медицинских данных
+	if !parties.isClinicSide(user) {
+		args = append(args, ContractDocHealthPlan)
+		query += fmt.Sprintf(" AND doc_type = $%d", len(args))
+	}
+	query += " ORDER BY doc_type, version DESC"
+
+	rows, err := db.Query(ctx, query, args...)
+	if err != nil {
+		log.Printf("listContractDocuments error: %v", err)
+		errorResponse(w, http.StatusInternalServerError, "db error")
+		return
+	}
+	defer rows.Close()
+	res := []*ContractDocumentVersion{}
+	for rows.Next() {
+		d, err := scanContractDocument(rows)
+		if err != nil {
+			errorResponse(w, http.StatusInternalServerError, "db error")
+			return
+		}
+		res = append(res, d)
+	}
+	jsonResponse(w, http.StatusOK, res)
+}
+
+// GET /api/contracts/{id}/documents/{docId}[/pdf|/docx]
+func contractDocumentHandler(w http.ResponseWriter, r *http.Request, contractID int64, rest string) {
+	idPart, format, _ := strings.Cut(rest, "/")
+	docID, err := strconv.ParseInt(idPart, 10, 64)
+	if err != nil || docID <= 0 {
+		errorResponse(w, http.StatusBadRequest, "invalid document id")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	user, parties, ok := authorizeContract(ctx, w, r, contractID, false)
+	if !ok {
+		return
+	}
+
+	var content []byte
+	var docType, pdfSum, docxSum string
+	err = db.QueryRow(ctx, `
+SELECT doc_type, content, pdf_sha256, docx_sha256 FROM contract_documents WHERE id = $1 AND contract_id = $2
+`, docID, contractID).Scan(&docType, &content, &pdfSum, &docxSum)
+	if err != nil || (!parties.isClinicSide(user) && !contractDocVisibleToClient(docType)) {
+		errorResponse(w, http.StatusNotFound, "document not found")
+		return
+	}
+
+	var sum, contentType, ext string
+	switch format {
+	case "":
+		d, err := scanContractDocument(db.QueryRow(ctx, `SELECT `+contractDocumentColumns+` FROM contract_documents WHERE id = $1`, docID))
+		if err != nil {
+			errorResponse(w, http.StatusInternalServerError, "db error")
+			return
+		}
+		d.Content = content
+		jsonResponse(w, http.StatusOK, d)
+		return
+	case "pdf":
+		sum, contentType, ext = pdfSum, "application/pdf", "pdf"
+	case "docx":
+		sum, contentType, ext = docxSum, "application/vnd.openxmlformats-officedocument.wordprocessingml.document", "docx"
+	default:
+		errorResponse(w, http.StatusNotFound, "not found")
+		return
+	}
+
+	var key string
+	var size int64
+	if err := db.QueryRow(ctx, `SELECT storage_key, size FROM attachment_blobs WHERE sha256 = $1`, sum).Scan(&key, &size); err != nil {
+		errorResponse(w, http.StatusNotFound, "file not found")
+		return
+	}
+	etag := `"` + sum + `"`
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	body, err := attachmentStore.Get(ctx, key)
+	if errors.Is(err, errBlobNotFound) {
+		errorResponse(w, http.StatusNotFound, "file not found")
+		return
+	}
+	if err != nil {
+		log.Printf("contractDocument: storage error: %v", err)
+		errorResponse(w, http.StatusBadGateway, "storage error")
+		return
+	}
+	defer body.Close()
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="contract-%d-doc-%d.%s"`, contractID, docID, ext))
+	w.Header().Set("ETag", etag)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if _, err := io.Copy(w, body); err != nil {
+		log.Printf("contractDocument: stream error: %v", err)
+	}
+}
@@ -0,0 +1,429 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Статистика и ход исполнения договора. Считается агрегатами SQL по контингенту
+// (contracts.employees), визитам и подписанным заключениям; результат кешируется
+// на CONTRACT_STATS_TTL и сбрасывается при записях, которые его меняют.
+
+type statsCounts struct {
+	Planned    int `json:"planned"`
+	Registered int `json:"registered"`
+	Examined   int `json:"examined"`
+	Concluded  int `json:"concluded"`
+}
+
+type statsBucket struct {
+	Key string `json:"key"`
+	statsCounts
+}
+
+type fitnessCounts struct {
+	Fit         int `json:"fit"`
+	Unfit       int `json:"unfit"`
+	Observation int `json:"observation"`
+	Restriction int `json:"restriction"`
+	Pending     int `json:"pending"`
+}
+
+type specialtyStats struct {
+	Specialty string  `json:"specialty"`
+	Type      string  `json:"type"` // doctor / research
+	Total     int     `json:"total"`
+	Completed int     `json:"completed"`
+	Percent   float64 `json:"percent"`
+}
+
+type dailyStats struct {
+	Date                 string `json:"date"`
+	InPlan               bool   `json:"inPlan"`
+	Registered           int    `json:"registered"`
+	Concluded            int    `json:"concluded"`
+	Target               int    `json:"target"`
+	CumulativeRegistered int    `json:"cumulativeRegistered"`
+	CumulativeConcluded  int    `json:"cumulativeConcluded"`
+	CumulativeTarget     int    `json:"cumulativeTarget"`
+}
+
+type ContractStats struct {
+	ContractID       int64 `json:"contractId"`
+	PlannedHeadcount int   `json:"plannedHeadcount"`
+	statsCounts
+	Coverage    float64                  `json:"coverage"` // % завершивших от контингента
+	Fitness     fitnessCounts            `json:"fitness"`
+	Breakdown   map[string][]statsBucket `json:"breakdown"` // site, position, gender, harmfulFactor
+	Specialties []specialtyStats         `json:"specialties"`
+	Plan        struct {
+		StartDate   string `json:"startDate,omitempty"`
+		EndDate     string `json:"endDate,omitempty"`
+		Days        int    `json:"days"`
+		DailyTarget int    `json:"dailyTarget"`
+	} `json:"plan"`
+	Daily       []dailyStats `json:"daily"`
+	GeneratedAt string       `json:"generatedAt"`
+}
+
+// --- Кеш ---
+
+type contractStatsEntry struct {
+	stats   *ContractStats
+	expires time.Time
+}
+
+// contractStatsCache хранит готовую статистику. Счётчик поколений не даёт положить
+// в кеш результат, посчитанный до сброса, который случился во время расчёта;
+// epoch растёт при сбросе всего кеша.
+type contractStatsCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[int64]contractStatsEntry
+	gens    map[int64]uint64
+	epoch   uint64
+}
+
+var contractStatsTTL = func() time.Duration {
+	if d, err := time.ParseDuration(os.Getenv("CONTRACT_STATS_TTL")); err == nil && d >= 0 {
+		return d
+	}
+	return time.Minute
+}()
+
+var statsCache = &contractStatsCache{
+	ttl:     contractStatsTTL,
+	entries: map[int64]contractStatsEntry{},
+	gens:    map[int64]uint64{},
+}
+
+func (c *contractStatsCache) get(id int64, now time.Time) (*ContractStats, uint64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[id]
+	if ok && now.Before(e.expires) {
+		return e.stats, c.gens[id] + c.epoch, true
+	}
+	return nil, c.gens[id] + c.epoch, false
+}
+
+func (c *contractStatsCache) put(id int64, gen uint64, s *ContractStats, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.gens[id]+c.epoch != gen {
+		return
+	}
+	c.entries[id] = contractStatsEntry{stats: s, expires: now.Add(c.ttl)}
+}
+
+func (c *contractStatsCache) invalidate(ids ...int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, id := range ids {
+		delete(c.entries, id)
+		c.gens[id]++
+	}
+}
+
+func (c *contractStatsCache) reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = map[int64]contractStatsEntry{}
+	c.epoch++
+}
+
+// Сброс между репликами: NOTIFY с номером договора, канал слушает шина событий
+const contractStatsChannel = "contract_stats"
+
+// invalidateContractStats сбрасывает статистику договоров на всех репликах. NOTIFY
+// уходит через q: из транзакции он доставляется только после фиксации, и ни одна
+// реплика, включая эту, не пересчитает статистику по незафиксированным данным.
+// Вне транзакции свой кеш сбрасывается сразу, не дожидаясь уведомления.
+func invalidateContractStats(ctx context.Context, q dbExecutor, ids ...int64) {
+	if len(ids) == 0 {
+		return
+	}
+	if _, inTx := q.(pgx.Tx); !inTx {
+		statsCache.invalidate(ids...)
+	}
+	_, err := q.Exec(ctx, `SELECT pg_notify($1, id::text) FROM unnest($2::bigint[]) AS id`, contractStatsChannel, ids)
+	if err != nil {
+		log.Printf("contract stats: notify invalidation of %v: %v", ids, err)
+	}
+}
+
+// receiveContractStatsInvalidation - уведомление о сбросе от любой реплики
+func receiveContractStatsInvalidation(payload string) {
+	if id, err := strconv.ParseInt(payload, 10, 64); err == nil {
+		statsCache.invalidate(id)
+	}
+}
+
+// invalidateContractStatsForVisit - сброс по визиту (маршрутный лист, заключение)
+func invalidateContractStatsForVisit(ctx context.Context, q dbExecutor, visitID int64) {
+	var contractID *int64
+	if err := q.QueryRow(ctx, `SELECT contract_id FROM employee_visits WHERE id = $1`, visitID).Scan(&contractID); err == nil && contractID != nil {
+		invalidateContractStats(ctx, q, *contractID)
+	}
+}
+
+// invalidateContractStatsForEmployee - сброс по всем договорам, где у работника есть визиты
+func invalidateContractStatsForEmployee(ctx context.Context, employeeID string) {
+	rows, err := db.Query(ctx, `SELECT DISTINCT contract_id FROM employee_visits WHERE employee_id = $1 AND contract_id IS NOT NULL`, employeeID)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if rows.Scan(&id) == nil {
+			ids = append(ids, id)
+		}
+	}
+	invalidateContractStats(ctx, db, ids...)
+}
+
+// --- Расчёт ---
+
+// statsWorkersCTE - по строке на работника контингента с его последним визитом
+// (подписанный важнее) и итогом заключения
+const statsWorkersCTE = `
+WITH emp AS (
+  SELECT e->>'id' AS id,
+         COALESCE(e->>'userId', '') AS user_id,
+         COALESCE(NULLIF(TRIM(e->>'site'), ''), '—') AS site,
+         COALESCE(NULLIF(TRIM(e->>'position'), ''), '—') AS position,
+         CASE UPPER(TRIM(COALESCE(e->>'gender', ''))) WHEN 'Ж' THEN 'Ж' WHEN 'М' THEN 'М' ELSE '—' END AS gender,
+         COALESCE(e->>'harmfulFactor', '') AS harmful_factor,
+         COALESCE(e->>'status', '') AS legacy_status
+  FROM contracts c
+  CROSS JOIN LATERAL jsonb_array_elements(
+    CASE WHEN jsonb_typeof(c.employees) = 'array' THEN c.employees ELSE '[]'::jsonb END) AS e
+  WHERE c.id = $1
+),
+vis AS (
+  SELECT v.employee_id,
+         v.status = 'completed' OR NOT EXISTS (
+           SELECT 1 FROM jsonb_array_elements(
+             CASE WHEN jsonb_typeof(v.route_sheet) = 'array' THEN v.route_sheet ELSE '[]'::jsonb END) AS i
+           WHERE COALESCE(i->>'status', 'pending') <> 'completed'
+         ) AS examined,
+         COALESCE(ep.locked, FALSE) AS concluded,
+         COALESCE(ep.final_conclusion, '{}'::jsonb) AS final,
+         v.visit_date, v.id
+  FROM employee_visits v
+  LEFT JOIN exam_episodes ep ON ep.visit_id = v.id
+  WHERE v.contract_id = $1 AND v.status <> 'cancelled'
+),
+w AS (
+  SELECT emp.*,
+         lv.employee_id IS NOT NULL AS registered,
+         COALESCE(lv.examined OR lv.concluded, FALSE) AS examined,
+         COALESCE(lv.concluded, FALSE) AS concluded,
+         CASE
+           WHEN lv.concluded THEN CASE
+             WHEN lv.final->'needsFurtherExamination' = 'true'::jsonb THEN 'observation'
+             WHEN lv.final->'isFit' = 'true'::jsonb AND COALESCE(TRIM(lv.final->>'restrictions'), '') <> '' THEN 'restriction'
+             WHEN lv.final->'isFit' = 'true'::jsonb THEN 'fit'
+             ELSE 'unfit' END
+           WHEN lv.employee_id IS NULL THEN CASE emp.legacy_status
+             WHEN 'fit' THEN 'fit'
+             WHEN 'fit_with_restrictions' THEN 'restriction'
+             WHEN 'unfit' THEN 'unfit'
+             WHEN 'needs_observation' THEN 'observation' END
+         END AS fitness
+  FROM emp
+  LEFT JOIN LATERAL (
+    SELECT * FROM vis
+    WHERE vis.employee_id = emp.id OR (emp.user_id <> '' AND vis.employee_id = emp.user_id)
+    ORDER BY vis.concluded DESC, vis.visit_date DESC, vis.id DESC
+    LIMIT 1
+  ) lv ON TRUE
+)`
+
+const statsCountColumns = `COUNT(*), COUNT(*) FILTER (WHERE registered), COUNT(*) FILTER (WHERE examined), COUNT(*) FILTER (WHERE concluded)`
+
+func computeContractStats(ctx context.Context, contractID int64) (*ContractStats, error) {
+	s := &ContractStats{ContractID: contractID, Breakdown: map[string][]statsBucket{}, Specialties: []specialtyStats{}, Daily: []dailyStats{}}
+
+	var start, end *time.Time
+	err := db.QueryRow(ctx, `
+SELECT planned_headcount,
+       CASE WHEN calendar_plan->>'startDate' ~ '^\d{4}-\d{2}-\d{2}' THEN LEFT(calendar_plan->>'startDate', 10)::date END,
+       CASE WHEN calendar_plan->>'endDate' ~ '^\d{4}-\d{2}-\d{2}' THEN LEFT(calendar_plan->>'endDate', 10)::date END
+FROM contracts WHERE id = $1
+`, contractID).Scan(&s.PlannedHeadcount, &start, &end)
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.QueryRow(ctx, statsWorkersCTE+`
+SELECT `+statsCountColumns+`,
+       COUNT(*) FILTER (WHERE fitness = 'fit'),
+       COUNT(*) FILTER (WHERE fitness = 'unfit'),
+       COUNT(*) FILTER (WHERE fitness = 'observation'),
+       COUNT(*) FILTER (WHERE fitness = 'restriction'),
+       COUNT(*) FILTER (WHERE fitness IS NULL)
+FROM w
+`, contractID).Scan(&s.Planned, &s.Registered, &s.Examined, &s.Concluded,
+		&s.Fitness.Fit, &s.Fitness.Unfit, &s.Fitness.Observation, &s.Fitness.Restriction, &s.Fitness.Pending)
+	if err != nil {
+		return nil, err
+	}
+	s.Coverage = percent(s.Concluded, s.Planned)
+
+	// Разрезы; вредные факторы записаны через запятую или точку с запятой
+	rows, err := db.Query(ctx, statsWorkersCTE+`
+SELECT dim, key, `+statsCountColumns+` FROM (
+  SELECT 'site' AS dim, site AS key, registered, examined, concluded FROM w
+  UNION ALL SELECT 'position', position, registered, examined, concluded FROM w
+  UNION ALL SELECT 'gender', gender, registered, examined, concluded FROM w
+  UNION ALL SELECT 'harmfulFactor', COALESCE(NULLIF(TRIM(f), ''), '—'), registered, examined, concluded
+    FROM w CROSS JOIN LATERAL regexp_split_to_table(w.harmful_factor, '\s*[;,]\s*') AS f
+) d
+GROUP BY dim, key
+ORDER BY dim, COUNT(*) DESC, key
+`, contractID)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var dim string
+		var b statsBucket
+		if err := rows.Scan(&dim, &b.Key, &b.Planned, &b.Registered, &b.Examined, &b.Concluded); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		s.Breakdown[dim] = append(s.Breakdown[dim], b)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = db.Query(ctx, `
+SELECT COALESCE(NULLIF(TRIM(i->>'specialty'), ''), '—'), COALESCE(NULLIF(i->>'type', ''), 'doctor'),
+       COUNT(*), COUNT(*) FILTER (WHERE i->>'status' = 'completed')
+FROM employee_visits v
+CROSS JOIN LATERAL jsonb_array_elements(
+  CASE WHEN jsonb_typeof(v.route_sheet) = 'array' THEN v.route_sheet ELSE '[]'::jsonb END) AS i
+WHERE v.contract_id = $1 AND v.status <> 'cancelled'
+GROUP BY 1, 2
+ORDER BY 2, 1
+`, contractID)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var sp specialtyStats
+		if err := rows.Scan(&sp.Specialty, &sp.Type, &sp.Total, &sp.Completed); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		sp.Percent = percent(sp.Completed, sp.Total)
+		s.Specialties = append(s.Specialties, sp)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Поток по дням: от начала плана (или первого визита) до конца плана (или последней подписи)
+	rows, err = db.Query(ctx, `
+WITH reg AS (
+  SELECT visit_date AS d, COUNT(*) AS n FROM employee_visits
+  WHERE contract_id = $1 AND status <> 'cancelled' GROUP BY 1
+),
+con AS (
+  SELECT ep.final_signed_at::date AS d, COUNT(*) AS n
+  FROM exam_episodes ep JOIN employee_visits v ON v.id = ep.visit_id
+  WHERE v.contract_id = $1 AND ep.locked AND ep.final_signed_at IS NOT NULL GROUP BY 1
+),
+bounds AS (
+  SELECT LEAST($2::date, (SELECT MIN(d) FROM reg), (SELECT MIN(d) FROM con)) AS lo,
+         GREATEST($3::date, (SELECT MAX(d) FROM reg), (SELECT MAX(d) FROM con)) AS hi
+)
+SELECT day::date, COALESCE(reg.n, 0), COALESCE(con.n, 0)
+FROM bounds
+CROSS JOIN LATERAL generate_series(bounds.lo, bounds.hi, interval '1 day') AS day
+LEFT JOIN reg ON reg.d = day::date
+LEFT JOIN con ON con.d = day::date
+ORDER BY 1
+`, contractID, start, end)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var day time.Time
+		var d dailyStats
+		if err := rows.Scan(&day, &d.Registered, &d.Concluded); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		d.Date = day.Format("2006-01-02")
+		d.InPlan = start != nil && end != nil && !day.Before(*start) && !day.After(*end)
+		s.Daily = append(s.Daily, d)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if start != nil && end != nil && !end.Before(*start) {
+		s.Plan.StartDate = start.Format("2006-01-02")
+		s.Plan.EndDate = end.Format("2006-01-02")
+		s.Plan.Days = int(end.Sub(*start).Hours()/24) + 1
+		planned := max(s.Planned, s.PlannedHeadcount)
+		s.Plan.DailyTarget = (planned + s.Plan.Days - 1) / s.Plan.Days
+	}
+	var cumReg, cumCon, cumTarget int
+	for i := range s.Daily {
+		d := &s.Daily[i]
+		if d.InPlan {
+			d.Target = s.Plan.DailyTarget
+		}
+		cumReg += d.Registered
+		cumCon += d.Concluded
+		cumTarget += d.Target
+		d.CumulativeRegistered, d.CumulativeConcluded, d.CumulativeTarget = cumReg, cumCon, cumTarget
+	}
+
+	s.GeneratedAt = time.Now().UTC().Format(time.RFC3339)
+	return s, nil
+}
+
+// GET /api/contracts/{id}/stats[?fresh=1]
+func contractStatsHandler(w http.ResponseWriter, r *http.Request, contractID int64) {
+	ctx, cancel := context.WithTimeout(r.Context(), 15*time.Second)
+	defer cancel()
+
+	if _, _, ok := authorizeContract(ctx, w, r, contractID, false); !ok {
+		return
+	}
+
+	now := time.Now()
+	stats, gen, hit := statsCache.get(contractID, now)
+	if hit && r.URL.Query().Get("fresh") != "1" {
+		w.Header().Set("X-Cache", "HIT")
+		jsonResponse(w, http.StatusOK, stats)
+		return
+	}
+
+	stats, err := computeContractStats(ctx, contractID)
+	if err != nil {
+		log.Printf("contractStats %d error: %v", contractID, err)
+		errorResponse(w, http.StatusInternalServerError, "db error")
+		return
+	}
+	statsCache.put(contractID, gen, stats, now)
+	w.Header().Set("X-Cache", "MISS")
+	jsonResponse(w, http.StatusOK, stats)
+}
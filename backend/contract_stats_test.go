@@ -0,0 +1,37 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestContractStatsInvalidation(t *testing.T) {
+	now := time.Now()
+	cache := func(id int64) {
+		_, gen, _ := statsCache.get(id, now)
+		statsCache.put(id, gen, &ContractStats{ContractID: id}, now)
+	}
+	cached := func(id int64) bool {
+		_, _, ok := statsCache.get(id, now)
+		return ok
+	}
+	cache(21)
+	cache(22)
+	_, inflight, _ := statsCache.get(23, now)
+
+	// Уведомление от любой реплики сбрасывает только свой договор
+	receiveContractStatsInvalidation("21")
+	receiveContractStatsInvalidation("23")
+	receiveContractStatsInvalidation("not a number")
+	if cached(21) || !cached(22) {
+		t.Errorf("after invalidation of 21: 21 cached=%v, 22 cached=%v", cached(21), cached(22))
+	}
+	// Расчёт, начатый до сброса, в кеш не попадает
+	statsCache.put(23, inflight, &ContractStats{ContractID: 23}, now)
+	if cached(23) {
+		t.Error("stale stats were cached after invalidation")
+	}
+	if _, _, ok := statsCache.get(22, now.Add(contractStatsTTL+time.Second)); ok && contractStatsTTL > 0 {
+		t.Error("entry must expire after TTL")
+	}
+}
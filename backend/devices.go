@@ -0,0 +1,676 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Приборы пунктов предсменного осмотра: алкотестеры, автоматические тонометры, термометры.
+// Клиника регистрирует прибор и получает его ключ Ed25519 (хранится только открытая часть).
+// Прибор отправляет подписанные измерения с привязкой к работнику; показания прибора
+// с истёкшей поверкой не принимаются. Медработник подставляет измерения в осмотр.
+
+const (
+	DeviceBreathalyzer = "breathalyzer"
+	DeviceTonometer    = "tonometer"
+	DeviceThermometer  = "thermometer"
+
+	ReadingAlcohol       = "alcohol"
+	ReadingBloodPressure = "blood_pressure"
+	ReadingTemperature   = "temperature"
+
+	deviceMaxBody = 16 << 10
+	// Допустимое расхождение часов прибора и сервера
+	deviceClockSkew = 10 * time.Minute
+	// Измерение подставляется в осмотр, пока работник у кабинета
+	deviceReadingTTL = time.Hour
+	// Порог алкотестера с учётом погрешности прибора, мг/л выдыхаемого воздуха
+	alcoholThresholdMgL = 0.16
+)
+
+// deviceReadingTypes - что умеет измерять прибор каждого вида
+var deviceReadingTypes = map[string]string{
+	DeviceBreathalyzer: ReadingAlcohol,
+	DeviceTonometer:    ReadingBloodPressure,
+	DeviceThermometer:  ReadingTemperature,
+}
+
+var (
+	errDeviceUnknown     = errors.New("unknown device")
+	errDeviceInactive    = errors.New("device is deactivated")
+	errDeviceSignature   = errors.New("invalid device signature")
+	errDeviceCalibration = errors.New("device calibration has expired")
+	errDeviceReplay      = errors.New("reading sequence number was already used")
+)
+
+type Device struct {
+	ID               int64  `json:"id"`
+	ClinicID         string `json:"clinicId"`
+	Kind             string `json:"kind"`
+	Model            string `json:"model"`
+	SerialNumber     string `json:"serialNumber"`
+	CalibratedAt     string `json:"calibratedAt"`
+	CalibrationUntil string `json:"calibrationValidUntil"`
+	Active           bool   `json:"active"`
+	LastSeq          int64  `json:"lastSeq"`
+	LastSeenAt       string `json:"lastSeenAt,omitempty"`
+	CreatedAt        string `json:"createdAt"`
+
+	publicKey        ed25519.PublicKey
+	calibrationUntil time.Time
+}
+
+// deviceWorker - кого измеряли: uid пациента или табельный номер в организации
+type deviceWorker struct {
+	PatientUID      string `json:"patientUid,omitempty"`
+	ClientBIN       string `json:"clientBin,omitempty"`
+	PersonnelNumber string `json:"personnelNumber,omitempty"`
+}
+
+// deviceReading - тело запроса прибора; подписывается целиком
+type deviceReading struct {
+	DeviceID    int64        `json:"deviceId"`
+	Seq         int64        `json:"seq"` // возрастающий счётчик прибора
+	MeasuredAt  string       `json:"measuredAt"`
+	Type        string       `json:"type"`
+	Worker      deviceWorker `json:"worker"`
+	AlcoholMgL  *float64     `json:"alcoholMgL,omitempty"`
+	Systolic    *int         `json:"systolic,omitempty"`
+	Diastolic   *int         `json:"diastolic,omitempty"`
+	Pulse       *int         `json:"pulse,omitempty"`
+	Temperature *float64     `json:"temperature,omitempty"`
+}
+
+type DeviceMeasurement struct {
+	ID               int64        `json:"id"`
+	DeviceID         int64        `json:"deviceId"`
+	DeviceSerial     string       `json:"deviceSerial,omitempty"`
+	ClinicID         string       `json:"clinicId"`
+	Seq              int64        `json:"seq"`
+	Type             string       `json:"type"`
+	Worker           deviceWorker `json:"worker"`
+	MeasuredAt       string       `json:"measuredAt"`
+	CalibrationUntil string       `json:"calibrationValidUntil"`
+	AlcoholMgL       *float64     `json:"alcoholMgL,omitempty"`
+	Systolic         *int         `json:"systolic,omitempty"`
+	Diastolic        *int         `json:"diastolic,omitempty"`
+	Pulse            *int         `json:"pulse,omitempty"`
+	Temperature      *float64     `json:"temperature,omitempty"`
+	ShiftExamID      *int64       `json:"shiftExamId,omitempty"`
+	ReceivedAt       string       `json:"receivedAt"`
+
+	measuredAt time.Time
+}
+
+func migrateDevices(ctx context.Context, tx pgx.Tx) error {
+	_, err := tx.Exec(ctx, `
+CREATE TABLE IF NOT EXISTS devices (
+  id                 SERIAL PRIMARY KEY,
+  clinic_id          TEXT NOT NULL,
+  kind               TEXT NOT NULL,
+  model              TEXT NOT NULL DEFAULT '',
+  serial_number      TEXT NOT NULL,
+  public_key         BYTEA NOT NULL,
+  calibrated_at      DATE NOT NULL,
+  calibration_until  DATE NOT NULL,
+  active             BOOLEAN NOT NULL DEFAULT TRUE,
+  last_seq           BIGINT NOT NULL DEFAULT 0,
+  last_seen_at       TIMESTAMPTZ,
+  created_at         TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  UNIQUE(clinic_id, kind, serial_number),
+  CONSTRAINT valid_device_kind CHECK (kind IN ('breathalyzer', 'tonometer', 'thermometer'))
+);
+`)
+	if err != nil {
+		return fmt.Errorf("migrate devices: %w", err)
+	}
+	_, err = tx.Exec(ctx, `
+CREATE TABLE IF NOT EXISTS device_measurements (
+  id                 SERIAL PRIMARY KEY,
+  device_id          INTEGER NOT NULL REFERENCES devices(id) ON DELETE CASCADE,
+  clinic_id          TEXT NOT NULL,
+  seq                BIGINT NOT NULL,
+  type               TEXT NOT NULL,
+  patient_uid        TEXT NOT NULL DEFAULT '',
+  client_bin         TEXT NOT NULL DEFAULT '',
+  personnel_number   TEXT NOT NULL DEFAULT '',
+  measured_at        TIMESTAMPTZ NOT NULL,
+  calibration_until  DATE NOT NULL,
+  alcohol_mg_l       NUMERIC(4,2),
+  bp_systolic        INTEGER,
+  bp_diastolic       INTEGER,
+  pulse              INTEGER,
+  temperature        NUMERIC(3,1),
+  raw                JSONB NOT NULL,
+  signature          TEXT NOT NULL,
+  shift_exam_id      INTEGER REFERENCES shift_exams(id) ON DELETE SET NULL,
+  received_at        TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  UNIQUE(device_id, seq)
+);
+`)
+	if err != nil {
+		return fmt.Errorf("migrate device_measurements: %w", err)
+	}
+	_, err = tx.Exec(ctx, `CREATE INDEX IF NOT EXISTS idx_device_measurements_worker ON device_measurements(clinic_id, measured_at) WHERE shift_exam_id IS NULL;`)
+	if err != nil {
+		return fmt.Errorf("create index device_measurements_worker: %w", err)
+	}
+	return nil
+}
+
+// --- Проверка измерения ---
+
+// deviceStore - реестр приборов для приёма измерений; в тестах заменяется памятью
+type deviceStore interface {
+	Device(ctx context.Context, id int64) (*Device, error)
+	// SaveMeasurement сохраняет измерение, если seq больше последнего принятого (иначе errDeviceReplay)
+	SaveMeasurement(ctx context.Context, m *DeviceMeasurement, raw []byte, signature string) error
+}
+
+// verifyDeviceReading проверяет подпись, поверку, время и значения измерения
+func verifyDeviceReading(dev *Device, body []byte, signature string, now time.Time) (*DeviceMeasurement, error) {
+	if !dev.Active {
+		return nil, errDeviceInactive
+	}
+	sig, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil || !ed25519.Verify(dev.publicKey, body, sig) {
+		return nil, errDeviceSignature
+	}
+	var in deviceReading
+	if err := json.Unmarshal(body, &in); err != nil {
+		return nil, errors.New("invalid json")
+	}
+	if in.DeviceID != dev.ID {
+		return nil, errDeviceSignature
+	}
+	if in.Seq <= 0 {
+		return nil, errors.New("seq must be positive")
+	}
+	measuredAt, err := time.Parse(time.RFC3339, in.MeasuredAt)
+	if err != nil {
+		return nil, errors.New("measuredAt must be RFC3339")
+	}
+	if measuredAt.After(now.Add(deviceClockSkew)) || measuredAt.Before(now.Add(-deviceClockSkew)) {
+		return nil, errors.New("measuredAt differs from server time")
+	}
+	// Поверка действует по дату окончания включительно
+	if !measuredAt.Before(dev.calibrationUntil.AddDate(0, 0, 1)) {
+		return nil, errDeviceCalibration
+	}
+	w := deviceWorker{
+		PatientUID:      strings.TrimSpace(in.Worker.PatientUID),
+		ClientBIN:       strings.TrimSpace(in.Worker.ClientBIN),
+		PersonnelNumber: strings.TrimSpace(in.Worker.PersonnelNumber),
+	}
+	if w.PatientUID == "" && (w.ClientBIN == "" || w.PersonnelNumber == "") {
+		return nil, errors.New("worker must have patientUid or clientBin with personnelNumber")
+	}
+	if deviceReadingTypes[dev.Kind] != in.Type {
+		return nil, fmt.Errorf("%s cannot report %q readings", dev.Kind, in.Type)
+	}
+
+	m := &DeviceMeasurement{
+		DeviceID: dev.ID, DeviceSerial: dev.SerialNumber, ClinicID: dev.ClinicID, Seq: in.Seq, Type: in.Type, Worker: w,
+		MeasuredAt: measuredAt.UTC().Format(time.RFC3339), CalibrationUntil: dev.CalibrationUntil, measuredAt: measuredAt,
+	}
+	switch in.Type {
+	case ReadingAlcohol:
+		if in.AlcoholMgL == nil || *in.AlcoholMgL < 0 || *in.AlcoholMgL > 5 {
+			return nil, errors.New("alcoholMgL is out of range")
+		}
+		m.AlcoholMgL = in.AlcoholMgL
+	case ReadingBloodPressure:
+		if in.Systolic == nil || in.Diastolic == nil || in.Pulse == nil {
+			return nil, errors.New("systolic, diastolic and pulse are required")
+		}
+		if msg := checkVitals(*in.Systolic, *in.Diastolic, *in.Pulse, nil); msg != "" {
+			return nil, errors.New(msg)
+		}
+		m.Systolic, m.Diastolic, m.Pulse = in.Systolic, in.Diastolic, in.Pulse
+	case ReadingTemperature:
+		if in.Temperature == nil || *in.Temperature < 34 || *in.Temperature > 42 {
+			return nil, errors.New("temperature is out of range")
+		}
+		m.Temperature = in.Temperature
+	}
+	return m, nil
+}
+
+// ingestDeviceMeasurement - POST /api/device-measurements от прибора.
+// Заголовки: X-Device-Id и X-Device-Signature (base64 Ed25519 от тела запроса).
+func ingestDeviceMeasurement(store deviceStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+		defer cancel()
+
+		id, err := strconv.ParseInt(r.Header.Get("X-Device-Id"), 10, 64)
+		if err != nil {
+			errorResponse(w, http.StatusUnauthorized, "X-Device-Id is required")
+			return
+		}
+		body, err := io.ReadAll(io.LimitReader(r.Body, deviceMaxBody+1))
+		if err != nil || len(body) > deviceMaxBody {
+			errorResponse(w, http.StatusBadRequest, "invalid body")
+			return
+		}
+		dev, err := store.Device(ctx, id)
+		if errors.Is(err, errDeviceUnknown) {
+			errorResponse(w, http.StatusUnauthorized, err.Error())
+			return
+		}
+		if err != nil {
+			log.Printf("ingestDeviceMeasurement: device %d: %v", id, err)
+			errorResponse(w, http.StatusInternalServerError, "db error")
+			return
+		}
+
+		signature := r.Header.Get("X-Device-Signature")
+		m, err := verifyDeviceReading(dev, body, signature, time.Now())
+		switch {
+		case errors.Is(err, errDeviceSignature), errors.Is(err, errDeviceInactive):
+			errorResponse(w, http.StatusUnauthorized, err.Error())
+			return
+		case errors.Is(err, errDeviceCalibration):
+			errorResponse(w, http.StatusUnprocessableEntity, err.Error())
+			return
+		case err != nil:
+			errorResponse(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		err = store.SaveMeasurement(ctx, m, body, signature)
+		if errors.Is(err, errDeviceReplay) {
+			errorResponse(w, http.StatusConflict, err.Error())
+			return
+		}
+		if err != nil {
+			log.Printf("ingestDeviceMeasurement: save %d/%d: %v", id, m.Seq, err)
+			errorResponse(w, http.StatusInternalServerError, "db error")
+			return
+		}
+		broadcastToUser(m.ClinicID, "device_measurement", m)
+		jsonResponse(w, http.StatusCreated, map[string]any{"id": m.ID, "seq": m.Seq})
+	}
+}
+
+// --- Хранилище в Postgres ---
+
+type pgDeviceStore struct{}
+
+const deviceColumns = `id, clinic_id, kind, model, serial_number, public_key, calibrated_at, calibration_until,
+active, last_seq, last_seen_at, created_at`
+
+func scanDevice(row pgx.Row) (*Device, error) {
+	var d Device
+	var key []byte
+	var calibrated, created time.Time
+	var lastSeen *time.Time
+	err := row.Scan(&d.ID, &d.ClinicID, &d.Kind, &d.Model, &d.SerialNumber, &key, &calibrated, &d.calibrationUntil,
+		&d.Active, &d.LastSeq, &lastSeen, &created)
+	if err != nil {
+		return nil, err
+	}
+	d.publicKey = ed25519.PublicKey(key)
+	d.CalibratedAt = calibrated.Format("2006-01-02")
+	d.CalibrationUntil = d.calibrationUntil.Format("2006-01-02")
+	d.CreatedAt = created.Format(time.RFC3339)
+	if lastSeen != nil {
+		d.LastSeenAt = lastSeen.Format(time.RFC3339)
+	}
+	return &d, nil
+}
+
+func (pgDeviceStore) Device(ctx context.Context, id int64) (*Device, error) {
+	d, err := scanDevice(db.QueryRow(ctx, `SELECT `+deviceColumns+` FROM devices WHERE id = $1`, id))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, errDeviceUnknown
+	}
+	return d, err
+}
+
+func (pgDeviceStore) SaveMeasurement(ctx context.Context, m *DeviceMeasurement, raw []byte, signature string) error {
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	// Счётчик сдвигается только вперёд: повтор перехваченного запроса не пройдёт
+	tag, err := tx.Exec(ctx, `UPDATE devices SET last_seq = $2, last_seen_at = NOW() WHERE id = $1 AND last_seq < $2`, m.DeviceID, m.Seq)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return errDeviceReplay
+	}
+	var received time.Time
+	err = tx.QueryRow(ctx, `
+INSERT INTO device_measurements (device_id, clinic_id, seq, type, patient_uid, client_bin, personnel_number, measured_at,
+  calibration_until, alcohol_mg_l, bp_systolic, bp_diastolic, pulse, temperature, raw, signature)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16)
+RETURNING id, received_at`,
+		m.DeviceID, m.ClinicID, m.Seq, m.Type, m.Worker.PatientUID, m.Worker.ClientBIN, m.Worker.PersonnelNumber, m.measuredAt,
+		m.CalibrationUntil, m.AlcoholMgL, m.Systolic, m.Diastolic, m.Pulse, m.Temperature, raw, signature).Scan(&m.ID, &received)
+	if err != nil {
+		return err
+	}
+	m.ReceivedAt = received.Format(time.RFC3339)
+	return tx.Commit(ctx)
+}
+
+const measurementColumns = `m.id, m.device_id, d.serial_number, m.clinic_id, m.seq, m.type, m.patient_uid, m.client_bin,
+m.personnel_number, m.measured_at, m.calibration_until, m.alcohol_mg_l::float8, m.bp_systolic, m.bp_diastolic, m.pulse,
+m.temperature::float8, m.shift_exam_id, m.received_at`
+
+func scanMeasurement(row pgx.Row) (*DeviceMeasurement, error) {
+	var m DeviceMeasurement
+	var calibration, received time.Time
+	err := row.Scan(&m.ID, &m.DeviceID, &m.DeviceSerial, &m.ClinicID, &m.Seq, &m.Type, &m.Worker.PatientUID, &m.Worker.ClientBIN,
+		&m.Worker.PersonnelNumber, &m.measuredAt, &calibration, &m.AlcoholMgL, &m.Systolic, &m.Diastolic, &m.Pulse,
+		&m.Temperature, &m.ShiftExamID, &received)
+	if err != nil {
+		return nil, err
+	}
+	m.MeasuredAt = m.measuredAt.UTC().Format(time.RFC3339)
+	m.CalibrationUntil = calibration.Format("2006-01-02")
+	m.ReceivedAt = received.Format(time.RFC3339)
+	return &m, nil
+}
+
+// matches - измерение снято с этого работника
+func (w deviceWorker) matches(patientUID *string, clientBIN, personnelNumber string) bool {
+	if w.PatientUID != "" {
+		return patientUID != nil && *patientUID == w.PatientUID
+	}
+	return w.ClientBIN == clientBIN && personnelNumber != "" && w.PersonnelNumber == personnelNumber
+}
+
+// claimMeasurements загружает неиспользованные свежие измерения клиники для осмотра
+// и блокирует их до конца транзакции
+func claimMeasurements(ctx context.Context, tx pgx.Tx, clinicID string, ids []int64) ([]*DeviceMeasurement, error) {
+	rows, err := tx.Query(ctx, `SELECT `+measurementColumns+`
+FROM device_measurements m JOIN devices d ON d.id = m.device_id
+WHERE m.id = ANY($1) AND m.clinic_id = $2 AND m.shift_exam_id IS NULL AND m.measured_at > $3
+FOR UPDATE OF m`, ids, clinicID, time.Now().Add(-deviceReadingTTL))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var res []*DeviceMeasurement
+	for rows.Next() {
+		m, err := scanMeasurement(rows)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(res) != len(ids) {
+		return nil, errors.New("measurement not found, already used or older than an hour")
+	}
+	return res, nil
+}
+
+// --- Управление приборами ---
+
+func newDeviceKey() (ed25519.PublicKey, string, error) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, "", err
+	}
+	return pub, base64.StdEncoding.EncodeToString(priv.Seed()), nil
+}
+
+// GET /api/devices - приборы клиники
+func listDevicesHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	user, ok := requestUser(ctx, w, r)
+	if !ok {
+		return
+	}
+	if !isClinicStaff(user) {
+		errorResponse(w, http.StatusForbidden, "access denied")
+		return
+	}
+	rows, err := db.Query(ctx, `SELECT `+deviceColumns+` FROM devices WHERE clinic_id = $1 ORDER BY kind, serial_number`, userClinicID(user))
+	if err != nil {
+		log.Printf("listDevices error: %v", err)
+		errorResponse(w, http.StatusInternalServerError, "db error")
+		return
+	}
+	defer rows.Close()
+	res := []*Device{}
+	for rows.Next() {
+		d, err := scanDevice(rows)
+		if err != nil {
+			log.Printf("listDevices scan error: %v", err)
+			errorResponse(w, http.StatusInternalServerError, "db error")
+			return
+		}
+		res = append(res, d)
+	}
+	jsonResponse(w, http.StatusOK, res)
+}
+
+// POST /api/devices - регистрация прибора. Закрытый ключ возвращается один раз.
+func createDeviceHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	user, ok := requestUser(ctx, w, r)
+	if !ok {
+		return
+	}
+	if user.Role != UserRoleClinic {
+		errorResponse(w, http.StatusForbidden, "only a clinic can register devices")
+		return
+	}
+	var in struct {
+		Kind             string `json:"kind"`
+		Model            string `json:"model"`
+		SerialNumber     string `json:"serialNumber"`
+		CalibratedAt     string `json:"calibratedAt"`
+		CalibrationUntil string `json:"calibrationValidUntil"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		errorResponse(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+	if _, ok := deviceReadingTypes[in.Kind]; !ok {
+		errorResponse(w, http.StatusBadRequest, "kind must be breathalyzer, tonometer or thermometer")
+		return
+	}
+	if strings.TrimSpace(in.SerialNumber) == "" {
+		errorResponse(w, http.StatusBadRequest, "serialNumber is required")
+		return
+	}
+	calibrated, until, msg := parseCalibration(in.CalibratedAt, in.CalibrationUntil)
+	if msg != "" {
+		errorResponse(w, http.StatusBadRequest, msg)
+		return
+	}
+	pub, seed, err := newDeviceKey()
+	if err != nil {
+		errorResponse(w, http.StatusInternalServerError, "key generation failed")
+		return
+	}
+	d, err := scanDevice(db.QueryRow(ctx, `
+INSERT INTO devices (clinic_id, kind, model, serial_number, public_key, calibrated_at, calibration_until)
+VALUES ($1, $2, $3, $4, $5, $6, $7)
+ON CONFLICT (clinic_id, kind, serial_number) DO NOTHING
+RETURNING `+deviceColumns, userClinicID(user), in.Kind, strings.TrimSpace(in.Model), strings.TrimSpace(in.SerialNumber), []byte(pub), calibrated, until))
+	if errors.Is(err, pgx.ErrNoRows) {
+		errorResponse(w, http.StatusConflict, "device with this serial number is already registered")
+		return
+	}
+	if err != nil {
+		log.Printf("createDevice error: %v", err)
+		errorResponse(w, http.StatusInternalServerError, "db error")
+		return
+	}
+	jsonResponse(w, http.StatusCreated, map[string]any{"device": d, "privateKey": seed})
+}
+
+func parseCalibration(from, until string) (time.Time, time.Time, string) {
+	f, err := parseDate(from)
+	if err != nil {
+		return f, f, "calibratedAt must be YYYY-MM-DD"
+	}
+	u, err := parseDate(until)
+	if err != nil || u.Before(f) {
+		return f, u, "calibrationValidUntil must be YYYY-MM-DD not before calibratedAt"
+	}
+	return f, u, ""
+}
+
+// PATCH /api/devices/{id} {calibratedAt, calibrationValidUntil, active} - новая поверка или списание;
+// POST /api/devices/{id}/rotate-key - новый ключ (прежний перестаёт действовать)
+func deviceHandler(w http.ResponseWriter, r *http.Request, id int64, sub string) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	user, ok := requestUser(ctx, w, r)
+	if !ok {
+		return
+	}
+	if user.Role != UserRoleClinic {
+		errorResponse(w, http.StatusForbidden, "only a clinic can manage devices")
+		return
+	}
+
+	switch {
+	case sub == "" && r.Method == http.MethodPatch:
+		var in struct {
+			CalibratedAt     *string `json:"calibratedAt"`
+			CalibrationUntil *string `json:"calibrationValidUntil"`
+			Active           *bool   `json:"active"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+			errorResponse(w, http.StatusBadRequest, "invalid json")
+			return
+		}
+		var calibrated, until *time.Time
+		if in.CalibratedAt != nil || in.CalibrationUntil != nil {
+			if in.CalibratedAt == nil || in.CalibrationUntil == nil {
+				errorResponse(w, http.StatusBadRequest, "calibratedAt and calibrationValidUntil are updated together")
+				return
+			}
+			f, u, msg := parseCalibration(*in.CalibratedAt, *in.CalibrationUntil)
+			if msg != "" {
+				errorResponse(w, http.StatusBadRequest, msg)
+				return
+			}
+			calibrated, until = &f, &u
+		}
+		d, err := scanDevice(db.QueryRow(ctx, `
+UPDATE devices SET calibrated_at = COALESCE($3, calibrated_at), calibration_until = COALESCE($4, calibration_until),
+  active = COALESCE($5, active)
+WHERE id = $1 AND clinic_id = $2
+RETURNING `+deviceColumns, id, userClinicID(user), calibrated, until, in.Active))
+		if errors.Is(err, pgx.ErrNoRows) {
+			errorResponse(w, http.StatusNotFound, "device not found")
+			return
+		}
+		if err != nil {
+			log.Printf("updateDevice %d: %v", id, err)
+			errorResponse(w, http.StatusInternalServerError, "db error")
+			return
+		}
+		jsonResponse(w, http.StatusOK, d)
+	case sub == "rotate-key" && r.Method == http.MethodPost:
+		pub, seed, err := newDeviceKey()
+		if err != nil {
+			errorResponse(w, http.StatusInternalServerError, "key generation failed")
+			return
+		}
+		d, err := scanDevice(db.QueryRow(ctx, `UPDATE devices SET public_key = $3 WHERE id = $1 AND clinic_id = $2 RETURNING `+deviceColumns,
+			id, userClinicID(user), []byte(pub)))
+		if errors.Is(err, pgx.ErrNoRows) {
+			errorResponse(w, http.StatusNotFound, "device not found")
+			return
+		}
+		if err != nil {
+			log.Printf("rotateDeviceKey %d: %v", id, err)
+			errorResponse(w, http.StatusInternalServerError, "db error")
+			return
+		}
+		jsonResponse(w, http.StatusOK, map[string]any{"device": d, "privateKey": seed})
+	default:
+		errorResponse(w, http.StatusNotFound, "not found")
+	}
+}
+
+// GET /api/device-measurements?patientUid=|clientBin=&personnelNumber= - свежие
+// неиспользованные измерения работника для подстановки в осмотр
+func listDeviceMeasurementsHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	user, ok := requestUser(ctx, w, r)
+	if !ok {
+		return
+	}
+	if !isClinicStaff(user) {
+		errorResponse(w, http.StatusForbidden, "access denied")
+		return
+	}
+	q := r.URL.Query()
+	query := `SELECT ` + measurementColumns + `
+FROM device_measurements m JOIN devices d ON d.id = m.device_id
+WHERE m.clinic_id = $1 AND m.shift_exam_id IS NULL AND m.measured_at > $2`
+	args := []any{userClinicID(user), time.Now().Add(-deviceReadingTTL)}
+	switch {
+	case q.Get("patientUid") != "":
+		args = append(args, q.Get("patientUid"))
+		query += ` AND m.patient_uid = $3`
+	case q.Get("clientBin") != "" && q.Get("personnelNumber") != "":
+		args = append(args, q.Get("clientBin"), q.Get("personnelNumber"))
+		query += ` AND m.client_bin = $3 AND m.personnel_number = $4`
+	}
+	query += ` ORDER BY m.measured_at DESC LIMIT 200`
+
+	rows, err := db.Query(ctx, query, args...)
+	if err != nil {
+		log.Printf("listDeviceMeasurements error: %v", err)
+		errorResponse(w, http.StatusInternalServerError, "db error")
+		return
+	}
+	defer rows.Close()
+	res := []*DeviceMeasurement{}
+	for rows.Next() {
+		m, err := scanMeasurement(rows)
+		if err != nil {
+			log.Printf("listDeviceMeasurements scan error: %v", err)
+			errorResponse(w, http.StatusInternalServerError, "db error")
+			return
+		}
+		res = append(res, m)
+	}
+	jsonResponse(w, http.StatusOK, res)
+}
+
+// deviceAlcoholValue - запись пробы для журнала: показание и прибор
+func deviceAlcoholValue(m *DeviceMeasurement) string {
+	s := fmt.Sprintf("%.2f мг/л", *m.AlcoholMgL)
+	if m.DeviceSerial != "" {
+		s += fmt.Sprintf(", прибор № %s (поверка до %s)", m.DeviceSerial, m.CalibrationUntil)
+	}
+	return s
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memDeviceStore - реестр приборов в памяти с той же проверкой счётчика, что и в Postgres
+type memDeviceStore struct {
+	mu       sync.Mutex
+	devices  map[int64]*Device
+	readings []*DeviceMeasurement
+}
+
+func (s *memDeviceStore) Device(ctx context.Context, id int64) (*Device, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d, ok := s.devices[id]
+	if !ok {
+		return nil, errDeviceUnknown
+	}
+	cp := *d
+	return &cp, nil
+}
+
+func (s *memDeviceStore) SaveMeasurement(ctx context.Context, m *DeviceMeasurement, raw []byte, signature string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d := s.devices[m.DeviceID]
+	if m.Seq <= d.LastSeq {
+		return errDeviceReplay
+	}
+	d.LastSeq = m.Seq
+	m.ID = int64(len(s.readings) + 1)
+	s.readings = append(s.readings, m)
+	return nil
+}
+
+// simulatedDevice - прибор пункта осмотра: подписывает показания своим ключом и отправляет их
+type simulatedDevice struct {
+	id    int64
+	key   ed25519.PrivateKey
+	seq   int64
+	url   string
+	clock func() time.Time
+}
+
+func (d *simulatedDevice) send(t *testing.T, reading deviceReading) (int, string) {
+	t.Helper()
+	if reading.Seq == 0 {
+		d.seq++
+		reading.Seq = d.seq
+	}
+	reading.DeviceID = d.id
+	if reading.MeasuredAt == "" {
+		reading.MeasuredAt = d.clock().Format(time.RFC3339)
+	}
+	body, _ := json.Marshal(reading)
+	return d.post(t, body, base64.StdEncoding.EncodeToString(ed25519.Sign(d.key, body)))
+}
+
+func (d *simulatedDevice) post(t *testing.T, body []byte, signature string) (int, string) {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodPost, d.url, bytes.NewReader(body))
+	req.Header.Set("X-Device-Id", strconv.FormatInt(d.id, 10))
+	req.Header.Set("X-Device-Signature", signature)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("post: %v", err)
+	}
+	defer resp.Body.Close()
+	var out map[string]any
+	json.NewDecoder(resp.Body).Decode(&out)
+	msg, _ := out["error"].(string)
+	return resp.StatusCode, msg
+}
+
+func newDeviceTestbed(t *testing.T) (*memDeviceStore, func(kind string, calibrationUntil time.Time) *simulatedDevice) {
+	t.Helper()
+	store := &memDeviceStore{devices: map[int64]*Device{}}
+	srv := httptest.NewServer(ingestDeviceMeasurement(store))
+	t.Cleanup(srv.Close)
+
+	register := func(kind string, calibrationUntil time.Time) *simulatedDevice {
+		pub, priv, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		id := int64(len(store.devices) + 1)
+		store.devices[id] = &Device{
+			ID: id, ClinicID: "clinic-1", Kind: kind, SerialNumber: "SN-" + strconv.FormatInt(id, 10), Active: true,
+			CalibrationUntil: calibrationUntil.Format("2006-01-02"), publicKey: pub, calibrationUntil: calibrationUntil,
+		}
+		return &simulatedDevice{id: id, key: priv, url: srv.URL, clock: time.Now}
+	}
+	return store, register
+}
+
+func ptr[T any](v T) *T { return &v }
+
+func TestDeviceIngestAcceptsSignedReadings(t *testing.T) {
+	store, register := newDeviceTestbed(t)
+	valid := time.Now().AddDate(0, 6, 0)
+	breathalyzer := register(DeviceBreathalyzer, valid)
+	tonometer := register(DeviceTonometer, valid)
+	worker := deviceWorker{ClientBIN: "123456789012", PersonnelNumber: "T-17"}
+
+	if code, msg := breathalyzer.send(t, deviceReading{Type: ReadingAlcohol, Worker: worker, AlcoholMgL: ptr(0.0)}); code != http.StatusCreated {
+		t.Fatalf("alcohol reading: %d %s", code, msg)
+	}
+	code, msg := tonometer.send(t, deviceReading{Type: ReadingBloodPressure, Worker: worker, Systolic: ptr(128), Diastolic: ptr(82), Pulse: ptr(71)})
+	if code != http.StatusCreated {
+		t.Fatalf("pressure reading: %d %s", code, msg)
+	}
+
+	if len(store.readings) != 2 {
+		t.Fatalf("want 2 stored readings, got %d", len(store.readings))
+	}
+	bp := store.readings[1]
+	if bp.DeviceID != tonometer.id || *bp.Systolic != 128 || bp.Worker != worker || bp.CalibrationUntil != valid.Format("2006-01-02") {
+		t.Errorf("stored reading = %+v", bp)
+	}
+	if !bp.Worker.matches(nil, "123456789012", "T-17") || bp.Worker.matches(nil, "123456789012", "T-18") {
+		t.Error("reading must match only the measured worker")
+	}
+}
+
+func TestDeviceIngestRejectsForgedReadings(t *testing.T) {
+	store, register := newDeviceTestbed(t)
+	dev := register(DeviceBreathalyzer, time.Now().AddDate(1, 0, 0))
+	other := register(DeviceBreathalyzer, time.Now().AddDate(1, 0, 0))
+	worker := deviceWorker{PatientUID: "emp-1"}
+
+	if code, msg := dev.send(t, deviceReading{Type: ReadingAlcohol, Worker: worker, AlcoholMgL: ptr(0.0)}); code != http.StatusCreated {
+		t.Fatalf("legitimate reading: %d %s", code, msg)
+	}
+
+	// Показание подписано ключом другого прибора
+	impostor := *dev
+	impostor.key = other.key
+	if code, _ := impostor.send(t, deviceReading{Type: ReadingAlcohol, Worker: worker, AlcoholMgL: ptr(0.0)}); code != http.StatusUnauthorized {
+		t.Errorf("foreign key: want 401, got %d", code)
+	}
+
+	// Тело изменено после подписи
+	body, _ := json.Marshal(deviceReading{DeviceID: dev.id, Seq: 10, MeasuredAt: time.Now().Format(time.RFC3339),
+		Type: ReadingAlcohol, Worker: worker, AlcoholMgL: ptr(0.9)})
+	sig := base64.StdEncoding.EncodeToString(ed25519.Sign(dev.key, body))
+	tampered := bytes.Replace(body, []byte("0.9"), []byte("0.0"), 1)
+	if code, _ := dev.post(t, tampered, sig); code != http.StatusUnauthorized {
+		t.Errorf("tampered body: want 401, got %d", code)
+	}
+
+	// Второй прибор подписал своим ключом показание от имени первого
+	if code, _ := other.post(t, body, base64.StdEncoding.EncodeToString(ed25519.Sign(other.key, body))); code != http.StatusUnauthorized {
+		t.Errorf("mismatched device id: want 401, got %d", code)
+	}
+
+	// Незарегистрированный прибор
+	ghost := *dev
+	ghost.id = 42
+	if code, _ := ghost.send(t, deviceReading{Type: ReadingAlcohol, Worker: worker, AlcoholMgL: ptr(0.0)}); code != http.StatusUnauthorized {
+		t.Errorf("unknown device: want 401, got %d", code)
+	}
+
+	// Отключённый прибор
+	store.devices[dev.id].Active = false
+	if code, _ := dev.send(t, deviceReading{Type: ReadingAlcohol, Worker: worker, AlcoholMgL: ptr(0.0)}); code != http.StatusUnauthorized {
+		t.Errorf("inactive device: want 401, got %d", code)
+	}
+	if len(store.readings) != 1 {
+		t.Errorf("only the legitimate reading must be stored, got %d", len(store.readings))
+	}
+}
+
+func TestDeviceIngestRejectsExpiredCalibration(t *testing.T) {
+	_, register := newDeviceTestbed(t)
+	dev := register(DeviceThermometer, time.Now().AddDate(0, 0, -2))
+
+	code, msg := dev.send(t, deviceReading{Type: ReadingTemperature, Worker: deviceWorker{PatientUID: "emp-1"}, Temperature: ptr(36.6)})
+	if code != http.StatusUnprocessableEntity || msg != errDeviceCalibration.Error() {
+		t.Fatalf("want 422 calibration error, got %d %q", code, msg)
+	}
+}
+
+func TestVerifyDeviceReadingCalibrationBoundary(t *testing.T) {
+	pub, priv, _ := ed25519.GenerateKey(rand.Reader)
+	until := time.Date(2026, 3, 31, 0, 0, 0, 0, time.UTC)
+	dev := &Device{ID: 7, Kind: DeviceThermometer, Active: true, publicKey: pub, calibrationUntil: until}
+
+	check := func(at time.Time) error {
+		body, _ := json.Marshal(deviceReading{DeviceID: 7, Seq: 1, MeasuredAt: at.Format(time.RFC3339), Type: ReadingTemperature,
+			Worker: deviceWorker{PatientUID: "emp-1"}, Temperature: ptr(36.6)})
+		_, err := verifyDeviceReading(dev, body, base64.StdEncoding.EncodeToString(ed25519.Sign(priv, body)), at)
+		return err
+	}
+	// Последний день поверки ещё действует, следующий - нет
+	if err := check(until.Add(23 * time.Hour)); err != nil {
+		t.Errorf("last calibration day: %v", err)
+	}
+	if err := check(until.Add(25 * time.Hour)); !errors.Is(err, errDeviceCalibration) {
+		t.Errorf("day after calibration: want errDeviceCalibration, got %v", err)
+	}
+}
+
+func TestDeviceIngestRejectsReplayAndBadReadings(t *testing.T) {
+	store, register := newDeviceTestbed(t)
+	dev := register(DeviceTonometer, time.Now().AddDate(1, 0, 0))
+	worker := deviceWorker{PatientUID: "emp-2"}
+	reading := deviceReading{Type: ReadingBloodPressure, Worker: worker, Systolic: ptr(120), Diastolic: ptr(80), Pulse: ptr(65)}
+
+	if code, msg := dev.send(t, reading); code != http.StatusCreated {
+		t.Fatalf("first reading: %d %s", code, msg)
+	}
+	// Повтор перехваченного запроса с тем же счётчиком
+	replay := reading
+	replay.Seq = dev.seq
+	if code, _ := dev.send(t, replay); code != http.StatusConflict {
+		t.Errorf("replay: want 409, got %d", code)
+	}
+
+	cases := map[string]deviceReading{
+		"wrong type for device": {Type: ReadingAlcohol, Worker: worker, AlcoholMgL: ptr(0.0)},
+		"implausible pressure":  {Type: ReadingBloodPressure, Worker: worker, Systolic: ptr(80), Diastolic: ptr(120), Pulse: ptr(65)},
+		"no worker":             {Type: ReadingBloodPressure, Systolic: ptr(120), Diastolic: ptr(80), Pulse: ptr(65)},
+		"clock far off":         {Type: ReadingBloodPressure, Worker: worker, MeasuredAt: time.Now().Add(-time.Hour).Format(time.RFC3339), Systolic: ptr(120), Diastolic: ptr(80), Pulse: ptr(65)},
+	}
+	for name, r := range cases {
+		if code, _ := dev.send(t, r); code != http.StatusBadRequest {
+			t.Errorf("%s: want 400, got %d", name, code)
+		}
+	}
+	if len(store.readings) != 1 {
+		t.Errorf("want 1 stored reading, got %d", len(store.readings))
+	}
+}
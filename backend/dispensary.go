@@ -0,0 +1,993 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Диспансерное наблюдение: регистр пациентов, взятых на учёт по коду МКБ-10, с наблюдающим
+// врачом и периодичностью явок. У активного наблюдения всегда одна запланированная явка;
+// после её выполнения назначается следующая. Планировщик напоминает пациенту и врачу
+// о предстоящих явках и сообщает клинике о просроченных.
+
+const (
+	ObservationActive = "active"
+	ObservationEnded  = "ended"
+
+	FollowupScheduled = "scheduled"
+	FollowupCompleted = "completed"
+	FollowupMissed    = "missed"    // пациент не явился, назначена новая дата
+	FollowupCancelled = "cancelled" // наблюдение прекращено
+)
+
+const (
+	defaultObservationInterval = 6 // месяцев между явками
+	followupReminderDays       = 3 // за сколько дней до явки напоминать
+)
+
+// Причины снятия с диспансерного учёта
+var observationEndReasons = map[string]string{
+	"recovered":   "выздоровление",
+	"remission":   "стойкая ремиссия",
+	"transferred": "передан под наблюдение в другую организацию",
+	"moved":       "выбыл",
+	"deceased":    "смерть",
+	"other":       "прочие причины",
+}
+
+type DispensaryObservation struct {
+	ID             int64                `json:"id"`
+	PatientUID     string               `json:"patientUid"`
+	PatientName    string               `json:"patientName"`
+	ClinicID       string               `json:"clinicId"`
+	ContractID     *int64               `json:"contractId,omitempty"`
+	EpisodeID      *int64               `json:"episodeId,omitempty"`
+	ICDCode        string               `json:"icdCode"`
+	Diagnosis      string               `json:"diagnosis"`
+	DoctorID       *int64               `json:"doctorId,omitempty"`
+	DoctorName     string               `json:"doctorName,omitempty"`
+	Room           string               `json:"room,omitempty"`
+	IntervalMonths int                  `json:"intervalMonths"`
+	EnrolledOn     string               `json:"enrolledOn"`
+	NextDue        *string              `json:"nextDue,omitempty"`
+	Status         string               `json:"status"`
+	EndedOn        *string              `json:"endedOn,omitempty"`
+	EndReason      *string              `json:"endReason,omitempty"`
+	EndNote        *string              `json:"endNote,omitempty"`
+	CreatedBy      *string              `json:"createdBy,omitempty"`
+	EndedBy        *string              `json:"endedBy,omitempty"`
+	CreatedAt      string               `json:"createdAt"`
+	UpdatedAt      string               `json:"updatedAt"`
+	Followups      []DispensaryFollowup `json:"followups,omitempty"`
+}
+
+// DispensaryFollowup - явка на диспансерный осмотр
+type DispensaryFollowup struct {
+	ID            int64   `json:"id"`
+	ObservationID int64   `json:"observationId"`
+	DueDate       string  `json:"dueDate"`
+	Status        string  `json:"status"`
+	VisitID       *int64  `json:"visitId,omitempty"`
+	CompletedOn   *string `json:"completedOn,omitempty"`
+	Note          *string `json:"note,omitempty"`
+	RemindedAt    *string `json:"remindedAt,omitempty"`
+}
+
+func migrateDispensary(ctx context.Context, tx pgx.Tx) error {
+	_, err := tx.Exec(ctx, `
+CREATE TABLE IF NOT EXISTS dispensary_observations (
+  id              SERIAL PRIMARY KEY,
+  patient_uid     TEXT NOT NULL,
+  patient_name    TEXT NOT NULL DEFAULT '',
+  clinic_id       TEXT NOT NULL,
+  contract_id     INTEGER REFERENCES contracts(id) ON DELETE SET NULL,
+  episode_id      INTEGER REFERENCES exam_episodes(id) ON DELETE SET NULL,
+  icd_code        TEXT NOT NULL,
+  diagnosis       TEXT NOT NULL DEFAULT '',
+  doctor_id       INTEGER REFERENCES doctors(id) ON DELETE SET NULL,
+  interval_months INTEGER NOT NULL DEFAULT 6,
+  enrolled_on     DATE NOT NULL DEFAULT CURRENT_DATE,
+  status          TEXT NOT NULL DEFAULT 'active',
+  ended_on        DATE,
+  end_reason      TEXT,
+  end_note        TEXT,
+  created_by      TEXT,
+  ended_by        TEXT,
+  created_at      TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  updated_at      TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  CONSTRAINT valid_observation_status CHECK (status IN ('active', 'ended')),
+  CONSTRAINT valid_observation_interval CHECK (interval_months BETWEEN 1 AND 24)
+);
+`)
+	if err != nil {
+		return fmt.Errorf("migrate dispensary_observations: %w", err)
+	}
+	// По одному коду пациент стоит на учёте не более одного раза
+	_, err = tx.Exec(ctx, `
+CREATE UNIQUE INDEX IF NOT EXISTS idx_dispensary_active
+ON dispensary_observations(patient_uid, icd_code) WHERE status = 'active';
+`)
+	if err != nil {
+		return fmt.Errorf("create index dispensary_active: %w", err)
+	}
+	_, err = tx.Exec(ctx, `CREATE INDEX IF NOT EXISTS idx_dispensary_clinic ON dispensary_observations(clinic_id, status);`)
+	if err != nil {
+		return fmt.Errorf("create index dispensary_clinic: %w", err)
+	}
+
+	_, err = tx.Exec(ctx, `
+CREATE TABLE IF NOT EXISTS dispensary_followups (
+  id                  SERIAL PRIMARY KEY,
+  observation_id      INTEGER NOT NULL REFERENCES dispensary_observations(id) ON DELETE CASCADE,
+  due_date            DATE NOT NULL,
+  status              TEXT NOT NULL DEFAULT 'scheduled',
+  visit_id            INTEGER REFERENCES employee_visits(id) ON DELETE SET NULL,
+  completed_on        DATE,
+  note                TEXT,
+  reminded_at         TIMESTAMPTZ,
+  overdue_notified_at TIMESTAMPTZ,
+  created_at          TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  updated_at          TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  CONSTRAINT valid_followup_status CHECK (status IN ('scheduled', 'completed', 'missed', 'cancelled'))
+);
+`)
+	if err != nil {
+		return fmt.Errorf("migrate dispensary_followups: %w", err)
+	}
+	_, err = tx.Exec(ctx, `
+CREATE UNIQUE INDEX IF NOT EXISTS idx_dispensary_followups_scheduled
+ON dispensary_followups(observation_id) WHERE status = 'scheduled';
+`)
+	if err != nil {
+		return fmt.Errorf("create index dispensary_followups_scheduled: %w", err)
+	}
+	_, err = tx.Exec(ctx, `CREATE INDEX IF NOT EXISTS idx_dispensary_followups_due ON dispensary_followups(due_date) WHERE status = 'scheduled';`)
+	if err != nil {
+		return fmt.Errorf("create index dispensary_followups_due: %w", err)
+	}
+	return nil
+}
+
+// addMonths прибавляет месяцы, не перескакивая через конец месяца (31.01 + 1 = 28.02)
+func addMonths(t time.Time, months int) time.Time {
+	first := time.Date(t.Year(), t.Month()+time.Month(months), 1, 0, 0, 0, 0, t.Location())
+	lastDay := first.AddDate(0, 1, -1).Day()
+	day := t.Day()
+	if day > lastDay {
+		day = lastDay
+	}
+	return first.AddDate(0, 0, day-1)
+}
+
+// normalizeICD приводит код к виду «A00.0»: верхний регистр, кириллические двойники букв
+func normalizeICD(code string) (string, bool) {
+	code = icdLookalikes.Replace(strings.ToUpper(strings.TrimSpace(code)))
+	return code, code != "" && icdPattern.FindString(code) == code
+}
+
+func parseDate(s string) (time.Time, error) {
+	return time.Parse("2006-01-02", s)
+}
+
+// today - текущая дата без времени, сопоставимая с parseDate
+func today() time.Time {
+	t, _ := parseDate(time.Now().Format("2006-01-02"))
+	return t
+}
+
+func formatDatePtr(t *time.Time) *string {
+	if t == nil {
+		return nil
+	}
+	s := t.Format("2006-01-02")
+	return &s
+}
+
+const observationColumns = `o.id, o.patient_uid, o.patient_name, o.clinic_id, o.contract_id, o.episode_id, o.icd_code,
+o.diagnosis, o.doctor_id, COALESCE(d.name, ''), COALESCE(d.room_number, ''), o.interval_months, o.enrolled_on,
+(SELECT MIN(f.due_date) FROM dispensary_followups f WHERE f.observation_id = o.id AND f.status = 'scheduled'),
+o.status, o.ended_on, o.end_reason, o.end_note, o.created_by, o.ended_by, o.created_at, o.updated_at`
+
+const observationFrom = ` FROM dispensary_observations o LEFT JOIN doctors d ON d.id = o.doctor_id `
+
+func scanObservation(row pgx.Row) (*DispensaryObservation, error) {
+	var o DispensaryObservation
+	var enrolled, createdAt, updatedAt time.Time
+	var nextDue, ended *time.Time
+	err := row.Scan(&o.ID, &o.PatientUID, &o.PatientName, &o.ClinicID, &o.ContractID, &o.EpisodeID, &o.ICDCode,
+		&o.Diagnosis, &o.DoctorID, &o.DoctorName, &o.Room, &o.IntervalMonths, &enrolled, &nextDue,
+		&o.Status, &ended, &o.EndReason, &o.EndNote, &o.CreatedBy, &o.EndedBy, &createdAt, &updatedAt)
+	if err != nil {
+		return nil, err
+	}
+	o.EnrolledOn = enrolled.Format("2006-01-02")
+	o.NextDue = formatDatePtr(nextDue)
+	o.EndedOn = formatDatePtr(ended)
+	o.CreatedAt = createdAt.Format(time.RFC3339)
+	o.UpdatedAt = updatedAt.Format(time.RFC3339)
+	return &o, nil
+}
+
+func loadObservation(ctx context.Context, q dbExecutor, id int64) (*DispensaryObservation, error) {
+	o, err := scanObservation(q.QueryRow(ctx, `SELECT `+observationColumns+observationFrom+`WHERE o.id = $1`, id))
+	if err != nil {
+		return nil, err
+	}
+	rows, err := q.Query(ctx, `
+SELECT id, observation_id, due_date, status, visit_id, completed_on, note, reminded_at
+FROM dispensary_followups WHERE observation_id = $1 ORDER BY due_date DESC, id DESC
+`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var f DispensaryFollowup
+		var due time.Time
+		var completed, reminded *time.Time
+		if err := rows.Scan(&f.ID, &f.ObservationID, &due, &f.Status, &f.VisitID, &completed, &f.Note, &reminded); err != nil {
+			return nil, err
+		}
+		f.DueDate = due.Format("2006-01-02")
+		f.CompletedOn = formatDatePtr(completed)
+		if reminded != nil {
+			s := reminded.Format(time.RFC3339)
+			f.RemindedAt = &s
+		}
+		o.Followups = append(o.Followups, f)
+	}
+	return o, rows.Err()
+}
+
+// doctorUserIDs - учётные записи врача (users.doctor_id ссылается на doctors.id)
+func doctorUserIDs(ctx context.Context, doctorID *int64) []string {
+	if doctorID == nil {
+		return nil
+	}
+	rows, err := db.Query(ctx, `SELECT id FROM users WHERE role = 'doctor' AND doctor_id = $1`, strconv.FormatInt(*doctorID, 10))
+	if err != nil {
+		log.Printf("doctorUserIDs %d: %v", *doctorID, err)
+		return nil
+	}
+	defer rows.Close()
+	var ids []string
+	for rows.Next() {
+		var id string
+		if rows.Scan(&id) == nil {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+func broadcastObservation(ctx context.Context, o *DispensaryObservation, event string, data map[string]any) {
+	data["observationId"] = o.ID
+	data["patientUid"] = o.PatientUID
+	recipients := append([]string{o.ClinicID, o.PatientUID}, doctorUserIDs(ctx, o.DoctorID)...)
+	broadcastToUsers(recipients, event, data)
+}
+
+// GET /api/dispensary?status=active&doctorId=&patientUid=&icd=&contractId=
+func listDispensaryHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	user, ok := requestUser(ctx, w, r)
+	if !ok {
+		return
+	}
+	query := `SELECT ` + observationColumns + observationFrom + `WHERE `
+	var args []any
+	switch {
+	case isClinicStaff(user):
+		args = append(args, userClinicID(user))
+		query += `o.clinic_id = $1`
+	case user.Role == UserRoleEmployee:
+		args = append(args, user.ID)
+		query += `o.patient_uid = $1`
+	default:
+		errorResponse(w, http.StatusForbidden, "access denied")
+		return
+	}
+	q := r.URL.Query()
+	status := q.Get("status")
+	if status == "" {
+		status = ObservationActive
+	}
+	if status != "all" {
+		args = append(args, status)
+		query += fmt.Sprintf(" AND o.status = $%d", len(args))
+	}
+	if v := q.Get("doctorId"); v != "" {
+		args = append(args, v)
+		query += fmt.Sprintf(" AND o.doctor_id = $%d::int", len(args))
+	}
+	if v := q.Get("patientUid"); v != "" {
+		args = append(args, v)
+		query += fmt.Sprintf(" AND o.patient_uid = $%d", len(args))
+	}
+	if v := q.Get("contractId"); v != "" {
+		args = append(args, v)
+		query += fmt.Sprintf(" AND o.contract_id = $%d::int", len(args))
+	}
+	if v := q.Get("icd"); v != "" {
+		// Префикс: «I11» находит I11.0 и I11.9
+		args = append(args, icdLookalikes.Replace(strings.ToUpper(v)))
+		query += fmt.Sprintf(" AND o.icd_code LIKE $%d || '%%'", len(args))
+	}
+	query += ` ORDER BY o.patient_name, o.icd_code LIMIT 1000`
+
+	rows, err := db.Query(ctx, query, args...)
+	if err != nil {
+		log.Printf("listDispensary error: %v", err)
+		errorResponse(w, http.StatusInternalServerError, "db error")
+		return
+	}
+	defer rows.Close()
+	res := []*DispensaryObservation{}
+	for rows.Next() {
+		o, err := scanObservation(rows)
+		if err != nil {
+			log.Printf("listDispensary scan error: %v", err)
+			errorResponse(w, http.StatusInternalServerError, "db error")
+			return
+		}
+		res = append(res, o)
+	}
+	jsonResponse(w, http.StatusOK, res)
+}
+
+// DueFollowup - строка списка явок клиники
+type DueFollowup struct {
+	FollowupID    int64  `json:"followupId"`
+	ObservationID int64  `json:"observationId"`
+	PatientUID    string `json:"patientUid"`
+	PatientName   string `json:"patientName"`
+	ICDCode       string `json:"icdCode"`
+	Diagnosis     string `json:"diagnosis"`
+	DoctorID      *int64 `json:"doctorId,omitempty"`
+	DoctorName    string `json:"doctorName,omitempty"`
+	Room          string `json:"room,omitempty"`
+	DueDate       string `json:"dueDate"`
+	Overdue       bool   `json:"overdue"`
+	DaysOverdue   int    `json:"daysOverdue,omitempty"`
+}
+
+// GET /api/dispensary/due?days=14&doctorId= - просроченные и предстоящие явки клиники
+func dispensaryDueHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	user, ok := requestUser(ctx, w, r)
+	if !ok {
+		return
+	}
+	if !isClinicStaff(user) {
+		errorResponse(w, http.StatusForbidden, "only clinic staff can view the follow-up list")
+		return
+	}
+	days := 14
+	if v := r.URL.Query().Get("days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 || n > 366 {
+			errorResponse(w, http.StatusBadRequest, "days must be between 0 and 366")
+			return
+		}
+		days = n
+	}
+	args := []any{userClinicID(user), days}
+	query := `
+SELECT f.id, o.id, o.patient_uid, o.patient_name, o.icd_code, o.diagnosis, o.doctor_id,
+       COALESCE(d.name, ''), COALESCE(d.room_number, ''), f.due_date, CURRENT_DATE - f.due_date
+FROM dispensary_followups f
+JOIN dispensary_observations o ON o.id = f.observation_id
+LEFT JOIN doctors d ON d.id = o.doctor_id
+WHERE o.clinic_id = $1 AND o.status = 'active' AND f.status = 'scheduled'
+  AND f.due_date <= CURRENT_DATE + $2::int`
+	if v := r.URL.Query().Get("doctorId"); v != "" {
+		args = append(args, v)
+		query += fmt.Sprintf(" AND o.doctor_id = $%d::int", len(args))
+	}
+	query += ` ORDER BY f.due_date, o.patient_name`
+
+	rows, err := db.Query(ctx, query, args...)
+	if err != nil {
+		log.Printf("dispensaryDue error: %v", err)
+		errorResponse(w, http.StatusInternalServerError, "db error")
+		return
+	}
+	defer rows.Close()
+	res := []DueFollowup{}
+	for rows.Next() {
+		var f DueFollowup
+		var due time.Time
+		var late int
+		if err := rows.Scan(&f.FollowupID, &f.ObservationID, &f.PatientUID, &f.PatientName, &f.ICDCode, &f.Diagnosis,
+			&f.DoctorID, &f.DoctorName, &f.Room, &due, &late); err != nil {
+			log.Printf("dispensaryDue scan error: %v", err)
+			errorResponse(w, http.StatusInternalServerError, "db error")
+			return
+		}
+		f.DueDate = due.Format("2006-01-02")
+		if late > 0 {
+			f.Overdue, f.DaysOverdue = true, late
+		}
+		res = append(res, f)
+	}
+	jsonResponse(w, http.StatusOK, res)
+}
+
+// checkClinicDoctor проверяет, что врач работает в клинике
+func checkClinicDoctor(ctx context.Context, doctorID int64, clinicID string) error {
+	var exists bool
+	err := db.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM doctors WHERE id = $1 AND clinic_uid = $2)`, doctorID, clinicID).Scan(&exists)
+	if err == nil && !exists {
+		return errors.New("doctor not found in this clinic")
+	}
+	return err
+}
+
+// POST /api/dispensary {patientUid, icdCode, diagnosis, doctorId, intervalMonths, enrolledOn, firstFollowup, episodeId, contractId}
+func createDispensaryHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	user, ok := requestUser(ctx, w, r)
+	if !ok {
+		return
+	}
+	if !isClinicStaff(user) {
+		errorResponse(w, http.StatusForbidden, "only clinic staff can enrol patients")
+		return
+	}
+	var in struct {
+		PatientUID     string `json:"patientUid"`
+		ICDCode        string `json:"icdCode"`
+		Diagnosis      string `json:"diagnosis"`
+		DoctorID       *int64 `json:"doctorId"`
+		IntervalMonths int    `json:"intervalMonths"`
+		EnrolledOn     string `json:"enrolledOn"`
+		FirstFollowup  string `json:"firstFollowup"`
+		EpisodeID      *int64 `json:"episodeId"`
+		ContractID     *int64 `json:"contractId"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		errorResponse(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+	clinicID := userClinicID(user)
+
+	// Из эпизода осмотра берутся пациент и договор
+	if in.EpisodeID != nil {
+		e, err := loadEpisode(ctx, db, *in.EpisodeID)
+		if err != nil {
+			errorResponse(w, http.StatusBadRequest, "episode not found")
+			return
+		}
+		if !canAccessPatientFiles(user, e.PatientUID, e.ClinicID) || (in.PatientUID != "" && in.PatientUID != e.PatientUID) {
+			errorResponse(w, http.StatusForbidden, "episode belongs to another patient or clinic")
+			return
+		}
+		in.PatientUID = e.PatientUID
+		if in.ContractID == nil {
+			in.ContractID = e.ContractID
+		}
+	}
+	if in.PatientUID == "" {
+		errorResponse(w, http.StatusBadRequest, "patientUid is required")
+		return
+	}
+	// Без эпизода пациента можно взять на учёт, только если клиника его уже осматривала
+	if in.EpisodeID == nil {
+		known, err := records.PatientInClinic(ctx, in.PatientUID, clinicID)
+		if err != nil {
+			log.Printf("createDispensary: patient %s: %v", in.PatientUID, err)
+			errorResponse(w, http.StatusInternalServerError, "db error")
+			return
+		}
+		if !known {
+			errorResponse(w, http.StatusForbidden, "patient is not examined by this clinic")
+			return
+		}
+	}
+	code, ok := normalizeICD(in.ICDCode)
+	if !ok {
+		errorResponse(w, http.StatusBadRequest, "icdCode must be an ICD-10 code like I11.9")
+		return
+	}
+	if in.IntervalMonths == 0 {
+		in.IntervalMonths = defaultObservationInterval
+	}
+	if in.IntervalMonths < 1 || in.IntervalMonths > 24 {
+		errorResponse(w, http.StatusBadRequest, "intervalMonths must be between 1 and 24")
+		return
+	}
+	enrolled := today()
+	if in.EnrolledOn != "" {
+		t, err := parseDate(in.EnrolledOn)
+		if err != nil {
+			errorResponse(w, http.StatusBadRequest, "enrolledOn must be YYYY-MM-DD")
+			return
+		}
+		enrolled = t
+	}
+	first := addMonths(enrolled, in.IntervalMonths)
+	if in.FirstFollowup != "" {
+		t, err := parseDate(in.FirstFollowup)
+		if err != nil || t.Before(enrolled) {
+			errorResponse(w, http.StatusBadRequest, "firstFollowup must be YYYY-MM-DD not earlier than enrolment")
+			return
+		}
+		first = t
+	}
+	if in.DoctorID != nil {
+		if err := checkClinicDoctor(ctx, *in.DoctorID, clinicID); err != nil {
+			errorResponse(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		errorResponse(w, http.StatusInternalServerError, "db error")
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	var id int64
+	err = tx.QueryRow(ctx, `
+INSERT INTO dispensary_observations
+  (patient_uid, patient_name, clinic_id, contract_id, episode_id, icd_code, diagnosis, doctor_id, interval_months, enrolled_on, created_by)
+VALUES ($1,
+  COALESCE(
+    (SELECT NULLIF(general->>'fullName', '') FROM ambulatory_cards WHERE patient_uid = $1),
+    (SELECT employee_name FROM employee_visits WHERE employee_id = $1 AND employee_name IS NOT NULL ORDER BY created_at DESC LIMIT 1),
+    ''),
+  $2, $3, $4, $5, $6, $7, $8, $9, $10)
+ON CONFLICT (patient_uid, icd_code) WHERE status = 'active' DO NOTHING
+RETURNING id
+`, in.PatientUID, clinicID, in.ContractID, in.EpisodeID, code, strings.TrimSpace(in.Diagnosis), in.DoctorID,
+		in.IntervalMonths, enrolled, user.ID).Scan(&id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		errorResponse(w, http.StatusConflict, "patient is already under observation for this code")
+		return
+	}
+	if err != nil {
+		log.Printf("createDispensary error: %v", err)
+		errorResponse(w, http.StatusInternalServerError, "db error")
+		return
+	}
+	if _, err := tx.Exec(ctx, `INSERT INTO dispensary_followups (observation_id, due_date) VALUES ($1, $2)`, id, first); err != nil {
+		log.Printf("createDispensary followup error: %v", err)
+		errorResponse(w, http.StatusInternalServerError, "db error")
+		return
+	}
+	o, err := loadObservation(ctx, tx, id)
+	if err != nil || tx.Commit(ctx) != nil {
+		log.Printf("createDispensary commit error: %v", err)
+		errorResponse(w, http.StatusInternalServerError, "db error")
+		return
+	}
+
+	broadcastObservation(ctx, o, "dispensary_enrolled", map[string]any{"icdCode": o.ICDCode, "nextDue": o.NextDue})
+	jsonResponse(w, http.StatusCreated, o)
+}
+
+// /api/dispensary/{id}[/end | /followups/{fid}[/complete | /miss]]
+func dispensaryHandler(w http.ResponseWriter, r *http.Request, id int64, sub string) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	user, ok := requestUser(ctx, w, r)
+	if !ok {
+		return
+	}
+	o, err := loadObservation(ctx, db, id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		errorResponse(w, http.StatusNotFound, "observation not found")
+		return
+	}
+	if err != nil {
+		log.Printf("dispensary %d: %v", id, err)
+		errorResponse(w, http.StatusInternalServerError, "db error")
+		return
+	}
+	if !canAccessPatientFiles(user, o.PatientUID, &o.ClinicID) {
+		errorResponse(w, http.StatusForbidden, "access denied")
+		return
+	}
+	if r.Method == http.MethodGet && sub == "" {
+		jsonResponse(w, http.StatusOK, o)
+		return
+	}
+	if !isClinicStaff(user) {
+		errorResponse(w, http.StatusForbidden, "only clinic staff can change observations")
+		return
+	}
+	if o.Status != ObservationActive {
+		errorResponse(w, http.StatusConflict, "observation has ended")
+		return
+	}
+
+	rest, isFollowup := strings.CutPrefix(sub, "followups/")
+	switch {
+	case sub == "" && r.Method == http.MethodPatch:
+		updateObservation(ctx, w, r, o)
+	case sub == "end" && r.Method == http.MethodPost:
+		endObservation(ctx, w, r, o, user)
+	case isFollowup:
+		idPart, action, _ := strings.Cut(rest, "/")
+		fid, err := strconv.ParseInt(idPart, 10, 64)
+		if err != nil {
+			errorResponse(w, http.StatusNotFound, "not found")
+			return
+		}
+		switch {
+		case action == "" && r.Method == http.MethodPatch:
+			rescheduleFollowup(ctx, w, r, o, fid)
+		case (action == "complete" || action == "miss") && r.Method == http.MethodPost:
+			closeFollowup(ctx, w, r, o, fid, action == "miss")
+		default:
+			errorResponse(w, http.StatusNotFound, "not found")
+		}
+	default:
+		errorResponse(w, http.StatusNotFound, "not found")
+	}
+}
+
+// PATCH {doctorId, intervalMonths, diagnosis}. Новый интервал действует со следующей явки.
+func updateObservation(ctx context.Context, w http.ResponseWriter, r *http.Request, o *DispensaryObservation) {
+	var in struct {
+		DoctorID       *int64  `json:"doctorId"`
+		IntervalMonths *int    `json:"intervalMonths"`
+		Diagnosis      *string `json:"diagnosis"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		errorResponse(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+	if in.IntervalMonths != nil && (*in.IntervalMonths < 1 || *in.IntervalMonths > 24) {
+		errorResponse(w, http.StatusBadRequest, "intervalMonths must be between 1 and 24")
+		return
+	}
+	if in.DoctorID != nil {
+		if err := checkClinicDoctor(ctx, *in.DoctorID, o.ClinicID); err != nil {
+			errorResponse(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	if in.Diagnosis != nil {
+		*in.Diagnosis = strings.TrimSpace(*in.Diagnosis)
+	}
+	_, err := db.Exec(ctx, `
+UPDATE dispensary_observations SET
+  doctor_id = COALESCE($2, doctor_id),
+  interval_months = COALESCE($3, interval_months),
+  diagnosis = COALESCE($4, diagnosis),
+  updated_at = NOW()
+WHERE id = $1
+`, o.ID, in.DoctorID, in.IntervalMonths, in.Diagnosis)
+	if err != nil {
+		log.Printf("updateObservation %d: %v", o.ID, err)
+		errorResponse(w, http.StatusInternalServerError, "db error")
+		return
+	}
+	updated, err := loadObservation(ctx, db, o.ID)
+	if err != nil {
+		errorResponse(w, http.StatusInternalServerError, "db error")
+		return
+	}
+	jsonResponse(w, http.StatusOK, updated)
+}
+
+// POST /end {reason, note, date} - снятие с учёта; запланированная явка отменяется
+func endObservation(ctx context.Context, w http.ResponseWriter, r *http.Request, o *DispensaryObservation, user *User) {
+	var in struct {
+		Reason string `json:"reason"`
+		Note   string `json:"note"`
+		Date   string `json:"date"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		errorResponse(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+	if _, ok := observationEndReasons[in.Reason]; !ok {
+		errorResponse(w, http.StatusBadRequest, "unknown reason")
+		return
+	}
+	ended := today()
+	if in.Date != "" {
+		t, err := parseDate(in.Date)
+		if err != nil {
+			errorResponse(w, http.StatusBadRequest, "date must be YYYY-MM-DD")
+			return
+		}
+		ended = t
+	}
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		errorResponse(w, http.StatusInternalServerError, "db error")
+		return
+	}
+	defer tx.Rollback(ctx)
+	tag, err := tx.Exec(ctx, `
+UPDATE dispensary_observations SET status = 'ended', ended_on = $2, end_reason = $3, end_note = NULLIF($4, ''),
+  ended_by = $5, updated_at = NOW()
+WHERE id = $1 AND status = 'active'
+`, o.ID, ended, in.Reason, strings.TrimSpace(in.Note), user.ID)
+	if err != nil {
+		log.Printf("endObservation %d: %v", o.ID, err)
+		errorResponse(w, http.StatusInternalServerError, "db error")
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		errorResponse(w, http.StatusConflict, "observation has ended")
+		return
+	}
+	if _, err := tx.Exec(ctx, `
+UPDATE dispensary_followups SET status = 'cancelled', updated_at = NOW()
+WHERE observation_id = $1 AND status = 'scheduled'
+`, o.ID); err != nil {
+		log.Printf("endObservation %d followups: %v", o.ID, err)
+		errorResponse(w, http.StatusInternalServerError, "db error")
+		return
+	}
+	updated, err := loadObservation(ctx, tx, o.ID)
+	if err != nil || tx.Commit(ctx) != nil {
+		errorResponse(w, http.StatusInternalServerError, "db error")
+		return
+	}
+
+	broadcastObservation(ctx, updated, "dispensary_ended", map[string]any{"reason": in.Reason})
+	jsonResponse(w, http.StatusOK, updated)
+}
+
+// PATCH /followups/{fid} {dueDate} - перенос явки; напоминание уйдёт заново
+func rescheduleFollowup(ctx context.Context, w http.ResponseWriter, r *http.Request, o *DispensaryObservation, fid int64) {
+	var in struct {
+		DueDate string `json:"dueDate"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		errorResponse(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+	due, err := parseDate(in.DueDate)
+	if err != nil {
+		errorResponse(w, http.StatusBadRequest, "dueDate must be YYYY-MM-DD")
+		return
+	}
+	tag, err := db.Exec(ctx, `
+UPDATE dispensary_followups SET due_date = $3, reminded_at = NULL, overdue_notified_at = NULL, updated_at = NOW()
+WHERE id = $1 AND observation_id = $2 AND status = 'scheduled'
+`, fid, o.ID, due)
+	if err != nil {
+		log.Printf("rescheduleFollowup %d: %v", fid, err)
+		errorResponse(w, http.StatusInternalServerError, "db error")
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		errorResponse(w, http.StatusConflict, "follow-up is not scheduled")
+		return
+	}
+	updated, err := loadObservation(ctx, db, o.ID)
+	if err != nil {
+		errorResponse(w, http.StatusInternalServerError, "db error")
+		return
+	}
+	broadcastObservation(ctx, updated, "dispensary_followup_rescheduled", map[string]any{"followupId": fid, "dueDate": in.DueDate})
+	jsonResponse(w, http.StatusOK, updated)
+}
+
+// POST /followups/{fid}/complete {date, visitId, note} - явка состоялась, следующая через интервал.
+// POST /followups/{fid}/miss {nextDate, note} - неявка, назначается новая дата.
+func closeFollowup(ctx context.Context, w http.ResponseWriter, r *http.Request, o *DispensaryObservation, fid int64, missed bool) {
+	var in struct {
+		Date     string `json:"date"`
+		NextDate string `json:"nextDate"`
+		VisitID  *int64 `json:"visitId"`
+		Note     string `json:"note"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		errorResponse(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+	day := today()
+	if in.Date != "" {
+		t, err := parseDate(in.Date)
+		if err != nil {
+			errorResponse(w, http.StatusBadRequest, "date must be YYYY-MM-DD")
+			return
+		}
+		day = t
+	}
+	next := addMonths(day, o.IntervalMonths)
+	if in.NextDate != "" {
+		t, err := parseDate(in.NextDate)
+		if err != nil {
+			errorResponse(w, http.StatusBadRequest, "nextDate must be YYYY-MM-DD")
+			return
+		}
+		next = t
+	} else if missed {
+		errorResponse(w, http.StatusBadRequest, "nextDate is required for a missed follow-up")
+		return
+	}
+	if !next.After(day) {
+		errorResponse(w, http.StatusBadRequest, "nextDate must be after date")
+		return
+	}
+	if in.VisitID != nil {
+		var exists bool
+		err := db.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM employee_visits WHERE id = $1 AND employee_id = $2)`, *in.VisitID, o.PatientUID).Scan(&exists)
+		if err != nil || !exists {
+			errorResponse(w, http.StatusBadRequest, "visit not found for this patient")
+			return
+		}
+	}
+
+	status := FollowupCompleted
+	if missed {
+		status = FollowupMissed
+	}
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		errorResponse(w, http.StatusInternalServerError, "db error")
+		return
+	}
+	defer tx.Rollback(ctx)
+	tag, err := tx.Exec(ctx, `
+UPDATE dispensary_followups SET status = $3, completed_on = $4, visit_id = $5, note = NULLIF($6, ''), updated_at = NOW()
+WHERE id = $1 AND observation_id = $2 AND status = 'scheduled'
+`, fid, o.ID, status, day, in.VisitID, strings.TrimSpace(in.Note))
+	if err != nil {
+		log.Printf("closeFollowup %d: %v", fid, err)
+		errorResponse(w, http.StatusInternalServerError, "db error")
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		errorResponse(w, http.StatusConflict, "follow-up is not scheduled")
+		return
+	}
+	if _, err := tx.Exec(ctx, `INSERT INTO dispensary_followups (observation_id, due_date) VALUES ($1, $2)`, o.ID, next); err != nil {
+		log.Printf("closeFollowup %d next: %v", fid, err)
+		errorResponse(w, http.StatusInternalServerError, "db error")
+		return
+	}
+	updated, err := loadObservation(ctx, tx, o.ID)
+	if err != nil || tx.Commit(ctx) != nil {
+		errorResponse(w, http.StatusInternalServerError, "db error")
+		return
+	}
+
+	broadcastObservation(ctx, updated, "dispensary_followup_"+status, map[string]any{"followupId": fid, "nextDue": updated.NextDue})
+	jsonResponse(w, http.StatusOK, updated)
+}
+
+// --- Напоминания ---
+
+// startDispensaryScheduler раз в час рассылает напоминания о явках.
+// Отметка reminded_at ставится тем же UPDATE, поэтому несколько экземпляров не дублируют рассылку.
+func startDispensaryScheduler() {
+	if mustGetEnv("DISPENSARY_REMINDERS", "on") == "off" {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(time.Hour)
+		defer ticker.Stop()
+		for {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+			if err := sendDispensaryReminders(ctx); err != nil {
+				log.Printf("dispensary reminders: %v", err)
+			}
+			cancel()
+			<-ticker.C
+		}
+	}()
+}
+
+type followupNotice struct {
+	FollowupID    int64
+	ObservationID int64
+	DueDate       time.Time
+	PatientUID    string
+	PatientName   string
+	ClinicID      string
+	ICDCode       string
+	DoctorID      *int64
+	DoctorName    string
+	Room          string
+}
+
+// followupClaim - вид рассылки: отметка, которую ставит рассылка, и какие явки она забирает
+type followupClaim struct {
+	Mark          string // reminded_at / overdue_notified_at
+	Event         string
+	From, To      *time.Time // границы срока явки включительно; nil - без границы
+	NotifyPatient bool
+}
+
+// followupClaims: пациенту и врачу - за followupReminderDays до явки,
+// клинике и врачу - один раз после срока
+func followupClaims(day time.Time) []followupClaim {
+	soon := day.AddDate(0, 0, followupReminderDays)
+	overdue := day.AddDate(0, 0, -1)
+	return []followupClaim{
+		{Mark: "reminded_at", Event: "dispensary_followup_reminder", From: &day, To: &soon, NotifyPatient: true},
+		{Mark: "overdue_notified_at", Event: "dispensary_followup_overdue", To: &overdue},
+	}
+}
+
+// claimFollowups ставит отметку рассылки и возвращает отмеченные явки одним UPDATE:
+// явку с отметкой другой экземпляр уже не заберёт
+func claimFollowups(ctx context.Context, q dbExecutor, c followupClaim) ([]followupNotice, error) {
+	rows, err := q.Query(ctx, `
+UPDATE dispensary_followups f SET `+c.Mark+` = NOW()
+FROM dispensary_observations o LEFT JOIN doctors d ON d.id = o.doctor_id
+WHERE o.id = f.observation_id AND o.status = 'active' AND f.status = 'scheduled' AND f.`+c.Mark+` IS NULL
+  AND ($1::date IS NULL OR f.due_date >= $1) AND ($2::date IS NULL OR f.due_date <= $2)
+RETURNING f.id, o.id, f.due_date, o.patient_uid, o.patient_name, o.clinic_id, o.icd_code, o.doctor_id,
+  COALESCE(d.name, ''), COALESCE(d.room_number, '')
+`, c.From, c.To)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var res []followupNotice
+	for rows.Next() {
+		var n followupNotice
+		if err := rows.Scan(&n.FollowupID, &n.ObservationID, &n.DueDate, &n.PatientUID, &n.PatientName, &n.ClinicID,
+			&n.ICDCode, &n.DoctorID, &n.DoctorName, &n.Room); err != nil {
+			return nil, err
+		}
+		res = append(res, n)
+	}
+	return res, rows.Err()
+}
+
+func (n followupNotice) data() map[string]any {
+	return map[string]any{
+		"followupId":    n.FollowupID,
+		"observationId": n.ObservationID,
+		"dueDate":       n.DueDate.Format("2006-01-02"),
+		"patientUid":    n.PatientUID,
+		"patientName":   n.PatientName,
+		"icdCode":       n.ICDCode,
+		"doctorName":    n.DoctorName,
+		"room":          n.Room,
+	}
+}
+
+// recipients - кому отправить напоминание о явке
+func (n followupNotice) recipients(c followupClaim, doctorUsers []string) []string {
+	var res []string
+	if c.NotifyPatient {
+		res = append(res, n.PatientUID)
+	}
+	return append(append(res, n.ClinicID), doctorUsers...)
+}
+
+func sendDispensaryReminders(ctx context.Context) error {
+	for _, c := range followupClaims(today()) {
+		notices, err := claimFollowups(ctx, db, c)
+		if err != nil {
+			return fmt.Errorf("claim %s: %w", c.Mark, err)
+		}
+		for _, n := range notices {
+			broadcastToUsers(n.recipients(c, doctorUserIDs(ctx, n.DoctorID)), c.Event, n.data())
+		}
+		if len(notices) > 0 {
+			log.Printf("dispensary reminders: %d %s", len(notices), c.Event)
+		}
+	}
+	return nil
+}
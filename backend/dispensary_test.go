@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+func mustDate(s string) time.Time {
+	t, err := parseDate(s)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func TestAddMonths(t *testing.T) {
+	for _, tc := range []struct {
+		from   string
+		months int
+		want   string
+	}{
+		{"2026-03-15", 6, "2026-09-15"},
+		{"2026-01-31", 1, "2026-02-28"},
+		{"2028-01-31", 1, "2028-02-29"},
+		{"2026-08-31", 6, "2027-02-28"},
+		{"2026-10-31", 2, "2026-12-31"},
+		{"2026-11-30", 3, "2027-02-28"},
+		{"2026-05-31", 24, "2028-05-31"},
+		{"2026-03-31", -1, "2026-02-28"},
+	} {
+		if got := addMonths(mustDate(tc.from), tc.months).Format("2006-01-02"); got != tc.want {
+			t.Errorf("addMonths(%s, %d) = %s, want %s", tc.from, tc.months, got, tc.want)
+		}
+	}
+}
+
+func TestFollowupClaimWindows(t *testing.T) {
+	claims := followupClaims(mustDate("2026-03-30"))
+	if len(claims) != 2 {
+		t.Fatalf("%d claims", len(claims))
+	}
+	upcoming, overdue := claims[0], claims[1]
+	if upcoming.Mark == overdue.Mark {
+		t.Fatal("reminder and overdue notice must use separate marks")
+	}
+
+	in := func(c followupClaim, due string) bool {
+		d := mustDate(due)
+		return (c.From == nil || !d.Before(*c.From)) && (c.To == nil || !d.After(*c.To))
+	}
+	for _, tc := range []struct {
+		due               string
+		reminder, overdue bool
+	}{
+		{"2026-03-29", false, true},
+		{"2025-12-01", false, true},
+		{"2026-03-30", true, false},
+		{"2026-04-02", true, false},
+		{"2026-04-03", false, false},
+	} {
+		if got := in(upcoming, tc.due); got != tc.reminder {
+			t.Errorf("due %s: reminder = %v, want %v", tc.due, got, tc.reminder)
+		}
+		if got := in(overdue, tc.due); got != tc.overdue {
+			t.Errorf("due %s: overdue = %v, want %v", tc.due, got, tc.overdue)
+		}
+	}
+
+	n := followupNotice{PatientUID: "emp-a", ClinicID: "clinic-a"}
+	if got := n.recipients(upcoming, []string{"doc-a"}); !reflect.DeepEqual(got, []string{"emp-a", "clinic-a", "doc-a"}) {
+		t.Errorf("reminder recipients = %v", got)
+	}
+	// О просрочке сообщают клинике и врачу, не пациенту
+	if got := n.recipients(overdue, []string{"doc-a"}); !reflect.DeepEqual(got, []string{"clinic-a", "doc-a"}) {
+		t.Errorf("overdue recipients = %v", got)
+	}
+}
+
+// fakeClaimDB отвечает на UPDATE ... RETURNING заранее заданными строками
+type fakeClaimDB struct {
+	sql  string
+	args []any
+	rows [][]any
+}
+
+func (f *fakeClaimDB) Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error) {
+	f.sql, f.args = sql, args
+	return &fakeRows{rows: f.rows}, nil
+}
+
+func (f *fakeClaimDB) QueryRow(ctx context.Context, sql string, args ...any) pgx.Row {
+	return fakeRow{err: errors.New("unexpected query row")}
+}
+
+func (f *fakeClaimDB) Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error) {
+	return pgconn.CommandTag{}, errors.New("unexpected exec")
+}
+
+type fakeRows struct {
+	rows [][]any
+	cur  []any
+}
+
+func (r *fakeRows) Next() bool {
+	if len(r.rows) == 0 {
+		return false
+	}
+	r.cur, r.rows = r.rows[0], r.rows[1:]
+	return true
+}
+
+func (r *fakeRows) Scan(dest ...any) error {
+	return scanValues(r.cur, dest)
+}
+
+func (r *fakeRows) Close()                                       {}
+func (r *fakeRows) Err() error                                   { return nil }
+func (r *fakeRows) CommandTag() pgconn.CommandTag                { return pgconn.CommandTag{} }
+func (r *fakeRows) FieldDescriptions() []pgconn.FieldDescription { return nil }
+func (r *fakeRows) Values() ([]any, error)                       { return r.cur, nil }
+func (r *fakeRows) RawValues() [][]byte                          { return nil }
+func (r *fakeRows) Conn() *pgx.Conn                              { return nil }
+
+func TestClaimFollowupsMarksInTheSameStatement(t *testing.T) {
+	doctor := int64(10)
+	due := mustDate("2026-04-01")
+	q := &fakeClaimDB{rows: [][]any{
+		{int64(7), int64(3), due, "emp-a", "Иванов Иван", "clinic-a", "I11.9", &doctor, "Петров П.", "12"},
+		{int64(8), int64(4), due, "emp-b", "Сидоров Сидор", "clinic-a", "E11", (*int64)(nil), "", ""},
+	}}
+	c := followupClaims(mustDate("2026-03-30"))[0]
+	notices, err := claimFollowups(context.Background(), q, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Отметка ставится тем же UPDATE, что и выборка, и только на неотмеченные активные явки
+	for _, part := range []string{
+		"UPDATE dispensary_followups f SET reminded_at = NOW()",
+		"f.reminded_at IS NULL",
+		"o.status = 'active'",
+		"f.status = 'scheduled'",
+	} {
+		if !strings.Contains(q.sql, part) {
+			t.Errorf("claim query lacks %q:\n%s", part, q.sql)
+		}
+	}
+	if !reflect.DeepEqual(q.args, []any{c.From, c.To}) {
+		t.Errorf("args = %v", q.args)
+	}
+
+	if len(notices) != 2 || notices[0].FollowupID != 7 || *notices[0].DoctorID != 10 || notices[1].DoctorID != nil {
+		t.Fatalf("notices = %+v", notices)
+	}
+	data := notices[0].data()
+	if data["dueDate"] != "2026-04-01" || data["icdCode"] != "I11.9" || data["room"] != "12" {
+		t.Errorf("data = %v", data)
+	}
+
+	overdue := followupClaims(mustDate("2026-03-30"))[1]
+	q = &fakeClaimDB{}
+	if _, err := claimFollowups(context.Background(), q, overdue); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(q.sql, "SET overdue_notified_at = NOW()") || !strings.Contains(q.sql, "f.overdue_notified_at IS NULL") {
+		t.Errorf("overdue claim query:\n%s", q.sql)
+	}
+}
+
+// Без эпизода на учёт берут только пациента, которого клиника уже осматривала
+func TestCreateDispensaryRequiresPatientOfClinic(t *testing.T) {
+	prev := records
+	records = memRecordStore{}
+	defer func() { records = prev }()
+	unreachableDB(t)
+
+	for _, tc := range []struct {
+		patient string
+		want    int
+	}{
+		{"emp-b", http.StatusForbidden},
+		{"nobody", http.StatusForbidden},
+		// Проверка пройдена - дальше запрос упирается в недоступную базу
+		{"emp-a", http.StatusInternalServerError},
+	} {
+		rec := callAs(t, doctorA, http.MethodPost, "/api/dispensary", `{"patientUid": "`+tc.patient+`", "icdCode": "I11.9"}`, createDispensaryHandler)
+		if rec.Code != tc.want {
+			t.Errorf("enrol %s: status %d, want %d: %s", tc.patient, rec.Code, tc.want, rec.Body)
+		}
+		if tc.want == http.StatusForbidden && strings.Contains(rec.Body.String(), tc.patient) {
+			t.Errorf("enrol %s: response leaks the patient: %s", tc.patient, rec.Body)
+		}
+	}
+}
+
+func TestCloseFollowupRejectsNextDateNotAfterDate(t *testing.T) {
+	o := &DispensaryObservation{ID: 1, PatientUID: "emp-a", IntervalMonths: 6}
+	for _, body := range []string{
+		`{"date": "2026-03-10", "nextDate": "2026-03-10"}`,
+		`{"date": "2026-03-10", "nextDate": "2026-03-01"}`,
+	} {
+		for _, missed := range []bool{false, true} {
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, "/api/dispensary/1/followups/2/complete", strings.NewReader(body))
+			closeFollowup(context.Background(), rec, req, o, 2, missed)
+			if rec.Code != http.StatusBadRequest {
+				t.Errorf("%s (missed=%v): status %d, want 400", body, missed, rec.Code)
+			}
+		}
+	}
+}
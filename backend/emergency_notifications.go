@@ -0,0 +1,725 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
+	"unicode"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Экстренное извещение в СЭС (п. 19 Правил, форма 058/у): при выявлении инфекционного
+// или паразитарного заболевания либо носительства. Черновик создаётся автоматически по
+// кодам МКБ-10 в записях специалистов и результатах анализов, отправляет его клиника.
+
+const (
+	EmergencyStatusDraft        = "draft"
+	EmergencyStatusSending      = "sending"
+	EmergencyStatusSent         = "sent"
+	EmergencyStatusAcknowledged = "acknowledged"
+)
+
+// Письменное извещение направляется не позднее 12 часов с момента выявления
+const emergencyNoticeDeadline = 12 * time.Hour
+
+// Отправка, не записавшая результат за это время (сбой процесса), считается прерванной
+const emergencySendingStale = 5 * time.Minute
+
+// sesChannel - канал доставки в СЭС (SES_CHANNEL), nil - не настроен
+var sesChannel notificationChannel
+
+// EmergencyNotice - поля формы 058/у
+type EmergencyNotice struct {
+	Diagnosis       string `json:"diagnosis"`
+	ICDCode         string `json:"icdCode"`
+	PatientName     string `json:"patientName"`
+	IIN             string `json:"iin"`
+	Gender          string `json:"gender"`
+	BirthDate       string `json:"birthDate"`
+	Address         string `json:"address"`
+	Workplace       string `json:"workplace"`
+	Position        string `json:"position"`
+	DetectedAt      string `json:"detectedAt"`
+	Source          string `json:"source"`     // specialist | lab
+	SourceName      string `json:"sourceName"` // специальность или исследование
+	ReportedBy      string `json:"reportedBy"`
+	Measures        string `json:"measures"`        // первичные противоэпидемические мероприятия
+	PhoneReportedAt string `json:"phoneReportedAt"` // первичная сигнализация по телефону
+	ClinicName      string `json:"clinicName"`
+}
+
+type EmergencyNotification struct {
+	ID                 int64           `json:"id"`
+	EpisodeID          int64           `json:"episodeId"`
+	PatientUID         string          `json:"patientUid"`
+	ClinicID           *string         `json:"clinicId,omitempty"`
+	ICDCode            string          `json:"icdCode"`
+	Content            EmergencyNotice `json:"content"`
+	Status             string          `json:"status"`
+	Channel            *string         `json:"channel,omitempty"`
+	Attempts           int             `json:"attempts"`
+	LastError          *string         `json:"lastError,omitempty"`
+	SentAt             *string         `json:"sentAt,omitempty"`
+	SentBy             *string         `json:"sentBy,omitempty"`
+	AcknowledgedAt     *string         `json:"acknowledgedAt,omitempty"`
+	AcknowledgedBy     *string         `json:"acknowledgedBy,omitempty"`
+	RegistrationNumber *string         `json:"registrationNumber,omitempty"` // № в журнале СЭС
+	DueAt              string          `json:"dueAt"`
+	CreatedAt          string          `json:"createdAt"`
+	UpdatedAt          string          `json:"updatedAt"`
+	Text               string          `json:"text,omitempty"` // текст извещения по шаблону
+}
+
+func migrateEmergencyNotifications(ctx context.Context, tx pgx.Tx) error {
+	_, err := tx.Exec(ctx, `
+CREATE TABLE IF NOT EXISTS emergency_notifications (
+  id                  SERIAL PRIMARY KEY,
+  episode_id          INTEGER NOT NULL REFERENCES exam_episodes(id) ON DELETE CASCADE,
+  patient_uid         TEXT NOT NULL,
+  clinic_id           TEXT,
+  icd_code            TEXT NOT NULL,
+  content             JSONB NOT NULL,
+  status              TEXT NOT NULL DEFAULT 'draft',
+  channel             TEXT,
+  attempts            INTEGER NOT NULL DEFAULT 0,
+  last_error          TEXT,
+  sent_at             TIMESTAMPTZ,
+  sent_by             TEXT,
+  acknowledged_at     TIMESTAMPTZ,
+  acknowledged_by     TEXT,
+  registration_number TEXT,
+  created_at          TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  updated_at          TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  CONSTRAINT valid_emergency_status CHECK (status IN ('draft', 'sending', 'sent', 'acknowledged')),
+  UNIQUE (episode_id, icd_code)
+);
+`)
+	if err != nil {
+		return fmt.Errorf("migrate emergency_notifications: %w", err)
+	}
+	_, err = tx.Exec(ctx, `
+ALTER TABLE emergency_notifications DROP CONSTRAINT IF EXISTS valid_emergency_status;
+ALTER TABLE emergency_notifications ADD CONSTRAINT valid_emergency_status
+  CHECK (status IN ('draft', 'sending', 'sent', 'acknowledged'));
+`)
+	if err != nil {
+		return fmt.Errorf("migrate emergency_notifications status: %w", err)
+	}
+	// Извещения без клиники не видит никто: клиника берётся из визита эпизода
+	_, err = tx.Exec(ctx, `
+UPDATE emergency_notifications n SET clinic_id = v.clinic_id
+FROM exam_episodes e JOIN employee_visits v ON v.id = e.visit_id
+WHERE n.clinic_id IS NULL AND e.id = n.episode_id
+`)
+	if err != nil {
+		return fmt.Errorf("backfill emergency_notifications clinic: %w", err)
+	}
+	_, err = tx.Exec(ctx, `CREATE INDEX IF NOT EXISTS idx_emergency_notifications_clinic ON emergency_notifications(clinic_id, status);`)
+	if err != nil {
+		return fmt.Errorf("create index emergency_notifications_clinic: %w", err)
+	}
+	return nil
+}
+
+// --- Выявление ---
+
+// emergencyFinding - код МКБ-10, требующий извещения, и где он найден
+type emergencyFinding struct {
+	Code       string
+	Text       string
+	Source     string
+	SourceName string
+	Doctor     string
+}
+
+// Поля записей, в которых ищутся коды. Значение анализа не просматривается:
+// «витамин В12 180 пг/мл» - это результат, а не диагноз
+var (
+	emergencySpecFields = []string{"diagnosis", "icd10Code", "conclusion"}
+	emergencyLabFields  = []string{"diagnosis", "icd10Code", "conclusion"}
+)
+
+// Коды набирают и кириллицей: «А15.0», «В20». Замена применяется только к слову,
+// которое уже целиком похоже на код
+var icdLookalikes = strings.NewReplacer("А", "A", "В", "B")
+
+var icdWord = regexp.MustCompile(`^[A-TV-ZАВ][0-9]{2}(?:\.[0-9]{1,2})?$`)
+
+// icdCodesInText - коды МКБ-10, записанные в тексте отдельными словами
+func icdCodesInText(text string) []string {
+	var res []string
+	words := strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '.'
+	})
+	for _, w := range words {
+		w = strings.TrimRight(w, ".")
+		if icdWord.MatchString(w) {
+			res = append(res, icdLookalikes.Replace(w))
+		}
+	}
+	return res
+}
+
+// Рубрики класса I МКБ-10; между блоками рубрик нет (A10-A14, A29, B10-B14, B84)
+var infectiousICDRanges = [][2]string{
+	{"A00", "A09"}, {"A15", "A28"}, {"A30", "A99"},
+	{"B00", "B09"}, {"B15", "B83"}, {"B85", "B99"},
+}
+
+// isInfectiousICD - класс I МКБ-10 (A00-B99) и носительство возбудителей (Z22)
+func isInfectiousICD(code string) bool {
+	if len(code) < 3 {
+		return false
+	}
+	category := code[:3]
+	if category == "Z22" {
+		return true
+	}
+	for _, r := range infectiousICDRanges {
+		if category >= r[0] && category <= r[1] {
+			return true
+		}
+	}
+	return false
+}
+
+func findEmergencyFindings(spec, labs map[string]map[string]any) []emergencyFinding {
+	var res []emergencyFinding
+	seen := map[string]bool{}
+	scan := func(source, name string, entry map[string]any, fields []string) {
+		for _, field := range fields {
+			text := cardText(entry, field)
+			for _, code := range icdCodesInText(text) {
+				if !isInfectiousICD(code) || seen[code] {
+					continue
+				}
+				seen[code] = true
+				res = append(res, emergencyFinding{Code: code, Text: text, Source: source, SourceName: name, Doctor: cardText(entry, "doctorName")})
+			}
+		}
+	}
+	for _, name := range sortedKeys(spec) {
+		scan("specialist", name, spec[name], emergencySpecFields)
+	}
+	for _, name := range sortedKeys(labs) {
+		scan("lab", name, labs[name], emergencyLabFields)
+	}
+	return res
+}
+
+// buildEmergencyNotice заполняет форму по карте пациента; остальное дописывает врач в черновике
+func buildEmergencyNotice(f emergencyFinding, iin string, general map[string]any, workplace, clinicName string, detected time.Time) EmergencyNotice {
+	if workplace == "" {
+		workplace = cardText(general, "workPlace")
+	}
+	gender := cardText(general, "gender")
+	switch gender {
+	case "male":
+		gender = "мужской"
+	case "female":
+		gender = "женский"
+	}
+	return EmergencyNotice{
+		Diagnosis:   f.Text,
+		ICDCode:     f.Code,
+		PatientName: cardText(general, "fullName"),
+		IIN:         iin,
+		Gender:      gender,
+		BirthDate:   cardText(general, "dob"),
+		Address:     cardText(general, "address"),
+		Workplace:   workplace,
+		Position:    cardText(general, "position"),
+		DetectedAt:  detected.Format("2006-01-02 15:04"),
+		Source:      f.Source,
+		SourceName:  f.SourceName,
+		ReportedBy:  f.Doctor,
+		ClinicName:  clinicName,
+	}
+}
+
+const emergencyColumns = `id, episode_id, patient_uid, clinic_id, icd_code, content, status, channel, attempts, last_error,
+sent_at, sent_by, acknowledged_at, acknowledged_by, registration_number, created_at, updated_at`
+
+func scanEmergencyNotification(row pgx.Row) (*EmergencyNotification, error) {
+	var n EmergencyNotification
+	var content []byte
+	var sentAt, ackAt *time.Time
+	var createdAt, updatedAt time.Time
+	err := row.Scan(&n.ID, &n.EpisodeID, &n.PatientUID, &n.ClinicID, &n.ICDCode, &content, &n.Status, &n.Channel, &n.Attempts,
+		&n.LastError, &sentAt, &n.SentBy, &ackAt, &n.AcknowledgedBy, &n.RegistrationNumber, &createdAt, &updatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(content, &n.Content); err != nil {
+		return nil, fmt.Errorf("parse emergency notice %d: %w", n.ID, err)
+	}
+	if sentAt != nil {
+		s := sentAt.Format(time.RFC3339)
+		n.SentAt = &s
+	}
+	if ackAt != nil {
+		s := ackAt.Format(time.RFC3339)
+		n.AcknowledgedAt = &s
+	}
+	n.DueAt = createdAt.Add(emergencyNoticeDeadline).Format(time.RFC3339)
+	n.CreatedAt = createdAt.Format(time.RFC3339)
+	n.UpdatedAt = updatedAt.Format(time.RFC3339)
+	return &n, nil
+}
+
+func loadEmergencyNotification(ctx context.Context, q dbExecutor, id int64, forUpdate bool) (*EmergencyNotification, error) {
+	query := `SELECT ` + emergencyColumns + ` FROM emergency_notifications WHERE id = $1`
+	if forUpdate {
+		query += ` FOR UPDATE`
+	}
+	return scanEmergencyNotification(q.QueryRow(ctx, query, id))
+}
+
+// createEmergencyNotifications создаёт черновики по новым кодам эпизода.
+// Уже заведённые извещения (эпизод + код) не дублируются.
+func createEmergencyNotifications(ctx context.Context, episodeID int64) ([]*EmergencyNotification, error) {
+	e, err := loadEpisode(ctx, db, episodeID)
+	if err != nil {
+		return nil, err
+	}
+	var spec, labs map[string]map[string]any
+	_ = json.Unmarshal(e.Spec, &spec)
+	_ = json.Unmarshal(e.Labs, &labs)
+	findings := findEmergencyFindings(spec, labs)
+	if len(findings) == 0 {
+		return nil, nil
+	}
+
+	var iin string
+	var generalJSON []byte
+	err = db.QueryRow(ctx, `SELECT iin, general FROM ambulatory_cards WHERE patient_uid = $1`, e.PatientUID).Scan(&iin, &generalJSON)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return nil, err
+	}
+	var general map[string]any
+	_ = json.Unmarshal(generalJSON, &general)
+
+	clinicID, err := episodeClinicID(ctx, db, e)
+	if err != nil {
+		return nil, err
+	}
+	if clinicID == "" {
+		// Без клиники извещение некому отправить и увидеть
+		return nil, fmt.Errorf("episode %d has no clinic, %d findings not recorded", e.ID, len(findings))
+	}
+
+	var workplace, clinicName string
+	if e.ContractID != nil {
+		_ = db.QueryRow(ctx, `SELECT client_name FROM contracts WHERE id = $1`, *e.ContractID).Scan(&workplace)
+	}
+	_ = db.QueryRow(ctx, `SELECT COALESCE(company_name, '') FROM users WHERE id = $1`, clinicID).Scan(&clinicName)
+
+	var created []*EmergencyNotification
+	now := time.Now()
+	for _, f := range findings {
+		content, err := json.Marshal(buildEmergencyNotice(f, iin, general, workplace, clinicName, now))
+		if err != nil {
+			return created, err
+		}
+		n, err := scanEmergencyNotification(db.QueryRow(ctx, `
+INSERT INTO emergency_notifications (episode_id, patient_uid, clinic_id, icd_code, content)
+VALUES ($1, $2, $3, $4, $5)
+ON CONFLICT (episode_id, icd_code) DO NOTHING
+RETURNING `+emergencyColumns, e.ID, e.PatientUID, clinicID, f.Code, content))
+		if errors.Is(err, pgx.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return created, err
+		}
+		created = append(created, n)
+	}
+	return created, nil
+}
+
+// checkEmergencyNotifications вызывается после записи осмотров и анализов; ошибка не
+// должна срывать сохранение, поэтому только логируется
+func checkEmergencyNotifications(ctx context.Context, episodeID int64) {
+	created, err := createEmergencyNotifications(ctx, episodeID)
+	if err != nil {
+		log.Printf("emergencyNotifications: episode %d: %v", episodeID, err)
+	}
+	for _, n := range created {
+		log.Printf("emergencyNotifications: draft %d for episode %d, code %s", n.ID, n.EpisodeID, n.ICDCode)
+		if n.ClinicID != nil {
+			broadcastToUsers([]string{*n.ClinicID}, "emergency_notification_created", map[string]interface{}{
+				"id":        n.ID,
+				"episodeId": n.EpisodeID,
+				"icdCode":   n.ICDCode,
+				"dueAt":     n.DueAt,
+			})
+		}
+	}
+}
+
+// --- Шаблон формы ---
+
+var emergencyNoticeTemplate = template.Must(template.New("058").Funcs(template.FuncMap{"dash": orDash}).Parse(
+	`ЭКСТРЕННОЕ ИЗВЕЩЕНИЕ
+об инфекционном заболевании, пищевом, остром профессиональном отравлении,
+необычной реакции на прививку (форма № 058/у)
+
+Извещение № {{.ID}}
+Медицинская организация: {{dash .ClinicName}}
+
+1. Диагноз: {{dash .Diagnosis}}
+   Код по МКБ-10: {{.ICDCode}}
+2. Фамилия, имя, отчество: {{dash .PatientName}}
+3. ИИН: {{dash .IIN}}
+4. Пол: {{dash .Gender}}
+5. Дата рождения: {{dash .BirthDate}}
+6. Адрес проживания: {{dash .Address}}
+7. Место работы: {{dash .Workplace}}; должность: {{dash .Position}}
+8. Дата выявления: {{.DetectedAt}} ({{.SourceTitle}}: {{dash .SourceName}})
+9. Проведённые первичные противоэпидемические мероприятия: {{dash .Measures}}
+10. Дата и час первичной сигнализации (по телефону) в СЭС: {{dash .PhoneReportedAt}}
+11. Фамилия сообщившего: {{dash .ReportedBy}}
+12. Дата и час отсылки извещения: {{.SentAt}}
+`))
+
+// renderEmergencyNotice - тема и текст извещения. В теме нет персональных данных.
+func renderEmergencyNotice(n *EmergencyNotification, sentAt time.Time) (string, string, error) {
+	sourceTitle := "осмотр специалиста"
+	if n.Content.Source == "lab" {
+		sourceTitle = "лабораторное исследование"
+	}
+	var b strings.Builder
+	err := emergencyNoticeTemplate.Execute(&b, struct {
+		EmergencyNotice
+		ID          int64
+		SourceTitle string
+		SentAt      string
+	}{n.Content, n.ID, sourceTitle, sentAt.Format("2006-01-02 15:04")})
+	if err != nil {
+		return "", "", err
+	}
+	return fmt.Sprintf("Экстренное извещение № %d (форма 058/у)", n.ID), b.String(), nil
+}
+
+// --- Handlers ---
+
+func canManageEmergencyNotification(u *User, n *EmergencyNotification) bool {
+	if !isClinicStaff(u) {
+		return false
+	}
+	return n.ClinicID != nil && *n.ClinicID != "" && *n.ClinicID == userClinicID(u)
+}
+
+// GET /api/emergency-notifications?status=&episodeId=&patientUid=
+func listEmergencyNotificationsHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	user, ok := requestUser(ctx, w, r)
+	if !ok {
+		return
+	}
+	if !isClinicStaff(user) {
+		errorResponse(w, http.StatusForbidden, "access denied")
+		return
+	}
+
+	query := `SELECT ` + emergencyColumns + ` FROM emergency_notifications WHERE clinic_id = $1`
+	args := []any{userClinicID(user)}
+	q := r.URL.Query()
+	if v := q.Get("status"); v != "" {
+		args = append(args, v)
+		query += fmt.Sprintf(" AND status = $%d", len(args))
+	}
+	if v := q.Get("episodeId"); v != "" {
+		args = append(args, v)
+		query += fmt.Sprintf(" AND episode_id = $%d::int", len(args))
+	}
+	if v := q.Get("patientUid"); v != "" {
+		args = append(args, v)
+		query += fmt.Sprintf(" AND patient_uid = $%d", len(args))
+	}
+	query += ` ORDER BY created_at DESC, id DESC LIMIT 500`
+
+	rows, err := db.Query(ctx, query, args...)
+	if err != nil {
+		log.Printf("listEmergencyNotifications error: %v", err)
+		errorResponse(w, http.StatusInternalServerError, "db error")
+		return
+	}
+	defer rows.Close()
+	res := []*EmergencyNotification{}
+	for rows.Next() {
+		n, err := scanEmergencyNotification(rows)
+		if err != nil {
+			log.Printf("listEmergencyNotifications scan error: %v", err)
+			errorResponse(w, http.StatusInternalServerError, "db error")
+			return
+		}
+		res = append(res, n)
+	}
+	jsonResponse(w, http.StatusOK, res)
+}
+
+// /api/emergency-notifications/{id}[/send|/acknowledge]
+func emergencyNotificationHandler(w http.ResponseWriter, r *http.Request, id int64, sub string) {
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	// Подтверждение от СЭС приходит подписанным запросом без пользователя
+	if sub == "acknowledge" && r.Method == http.MethodPost && r.Header.Get("X-Signature") != "" {
+		acknowledgeEmergencyNotification(ctx, w, r, id, nil)
+		return
+	}
+
+	user, ok := requestUser(ctx, w, r)
+	if !ok {
+		return
+	}
+	n, err := loadEmergencyNotification(ctx, db, id, false)
+	if errors.Is(err, pgx.ErrNoRows) {
+		errorResponse(w, http.StatusNotFound, "notification not found")
+		return
+	}
+	if err != nil {
+		log.Printf("emergencyNotification %d: %v", id, err)
+		errorResponse(w, http.StatusInternalServerError, "db error")
+		return
+	}
+	if !canManageEmergencyNotification(user, n) {
+		errorResponse(w, http.StatusForbidden, "access denied")
+		return
+	}
+
+	switch {
+	case sub == "" && r.Method == http.MethodGet:
+		ts := time.Now()
+		if n.SentAt != nil {
+			ts, _ = time.Parse(time.RFC3339, *n.SentAt)
+		}
+		_, n.Text, _ = renderEmergencyNotice(n, ts)
+		jsonResponse(w, http.StatusOK, n)
+	case sub == "" && r.Method == http.MethodPatch:
+		updateEmergencyNotification(ctx, w, r, n)
+	case sub == "send" && r.Method == http.MethodPost:
+		sendEmergencyNotification(ctx, w, id, user)
+	case sub == "acknowledge" && r.Method == http.MethodPost:
+		acknowledgeEmergencyNotification(ctx, w, r, id, user)
+	default:
+		errorResponse(w, http.StatusNotFound, "not found")
+	}
+}
+
+// PATCH - правка формы, пока извещение не отправлено. Код и источник не меняются.
+func updateEmergencyNotification(ctx context.Context, w http.ResponseWriter, r *http.Request, n *EmergencyNotification) {
+	if n.Status != EmergencyStatusDraft {
+		errorResponse(w, http.StatusConflict, "notification is already sent")
+		return
+	}
+	content := n.Content
+	if err := json.NewDecoder(r.Body).Decode(&content); err != nil {
+		errorResponse(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+	content.ICDCode, content.Source, content.SourceName = n.Content.ICDCode, n.Content.Source, n.Content.SourceName
+
+	updated, err := scanEmergencyNotification(db.QueryRow(ctx, `
+UPDATE emergency_notifications SET content = $2, updated_at = NOW()
+WHERE id = $1 AND status = 'draft'
+RETURNING `+emergencyColumns, n.ID, content))
+	if errors.Is(err, pgx.ErrNoRows) {
+		errorResponse(w, http.StatusConflict, "notification is already sent")
+		return
+	}
+	if err != nil {
+		log.Printf("updateEmergencyNotification %d: %v", n.ID, err)
+		errorResponse(w, http.StatusInternalServerError, "db error")
+		return
+	}
+	jsonResponse(w, http.StatusOK, updated)
+}
+
+// POST /send - отправка черновика через настроенный канал. Извещение сначала
+// помечается sending и фиксируется, чтобы два запроса не отправили его дважды, затем
+// отправляется без открытой транзакции, и результат записывается отдельно.
+func sendEmergencyNotification(ctx context.Context, w http.ResponseWriter, id int64, user *User) {
+	if sesChannel == nil {
+		errorResponse(w, http.StatusServiceUnavailable, errChannelNotConfigured.Error())
+		return
+	}
+
+	n, err := scanEmergencyNotification(db.QueryRow(ctx, `
+UPDATE emergency_notifications SET status = 'sending', updated_at = NOW()
+WHERE id = $1 AND (status = 'draft' OR (status = 'sending' AND updated_at < $2))
+RETURNING `+emergencyColumns, id, time.Now().Add(-emergencySendingStale)))
+	if errors.Is(err, pgx.ErrNoRows) {
+		errorResponse(w, http.StatusConflict, "notification is already sent")
+		return
+	}
+	if err != nil {
+		log.Printf("sendEmergencyNotification %d: %v", id, err)
+		errorResponse(w, http.StatusInternalServerError, "db error")
+		return
+	}
+
+	now := time.Now()
+	subject, text, err := renderEmergencyNotice(n, now)
+	var sendErr error
+	if err != nil {
+		log.Printf("sendEmergencyNotification %d: template: %v", id, err)
+		sendErr = fmt.Errorf("template: %w", err)
+	} else {
+		msg := outboundMessage{ID: fmt.Sprintf("emergency-%d", n.ID), Subject: subject, Body: text, Payload: n.Content}
+		sendErr = sesChannel.Send(ctx, msg)
+	}
+
+	var lastError *string
+	status, sentAt := EmergencyStatusDraft, (*time.Time)(nil)
+	if sendErr != nil {
+		s := sendErr.Error()
+		lastError = &s
+	} else {
+		status, sentAt = EmergencyStatusSent, &now
+	}
+	// Результат записывается, даже если запрос уже отменён: иначе отправленное
+	// извещение осталось бы в sending
+	saveCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 10*time.Second)
+	defer cancel()
+	n, err = scanEmergencyNotification(db.QueryRow(saveCtx, `
+UPDATE emergency_notifications SET
+  status = $2, channel = $3, attempts = attempts + 1, last_error = $4,
+  sent_at = COALESCE($5, sent_at), sent_by = CASE WHEN $5::timestamptz IS NULL THEN sent_by ELSE $6 END,
+  updated_at = NOW()
+WHERE id = $1 AND status = 'sending'
+RETURNING `+emergencyColumns, id, status, sesChannel.Name(), lastError, sentAt, user.ID))
+	if err != nil {
+		log.Printf("sendEmergencyNotification %d: record result (sent=%v): %v", id, sendErr == nil, err)
+		errorResponse(w, http.StatusInternalServerError, "db error")
+		return
+	}
+
+	if sendErr != nil {
+		log.Printf("sendEmergencyNotification %d via %s: %v", id, sesChannel.Name(), sendErr)
+		errorResponse(w, http.StatusBadGateway, "delivery failed: "+sendErr.Error())
+		return
+	}
+	n.Text = text
+	if n.ClinicID != nil {
+		broadcastToUsers([]string{*n.ClinicID}, "emergency_notification_updated", map[string]interface{}{"id": n.ID, "status": n.Status})
+	}
+	jsonResponse(w, http.StatusOK, n)
+}
+
+// emergencyAckMaxSkew - допустимое расхождение метки времени подписанного подтверждения с нашими часами
+const emergencyAckMaxSkew = 5 * time.Minute
+
+// emergencyAck - тело подтверждения. Подписанный запрос СЭС содержит id извещения
+// и метку времени (unix, секунды): подпись покрывает только тело
+type emergencyAck struct {
+	ID                 int64  `json:"id"`
+	Timestamp          int64  `json:"timestamp"`
+	RegistrationNumber string `json:"registrationNumber"`
+	AcceptedBy         string `json:"acceptedBy"`
+}
+
+var (
+	errAckSignature = errors.New("invalid signature")
+	errAckForeign   = errors.New("signed acknowledgement is for another notification")
+	errAckStale     = errors.New("signed acknowledgement is expired")
+)
+
+// verifyEmergencyAck проверяет подписанное подтверждение: перехваченный запрос
+// нельзя повторить ни для другого извещения, ни позже emergencyAckMaxSkew
+func verifyEmergencyAck(secret string, body []byte, signature string, id int64, now time.Time) (emergencyAck, error) {
+	var in emergencyAck
+	if !verifyWebhookSignature(secret, body, signature) {
+		return in, errAckSignature
+	}
+	if err := json.Unmarshal(body, &in); err != nil {
+		return in, fmt.Errorf("invalid json: %w", err)
+	}
+	if in.ID != id {
+		return in, errAckForeign
+	}
+	skew := now.Sub(time.Unix(in.Timestamp, 0))
+	if in.Timestamp == 0 || skew > emergencyAckMaxSkew || skew < -emergencyAckMaxSkew {
+		return in, errAckStale
+	}
+	return in, nil
+}
+
+// POST /acknowledge {registrationNumber, acceptedBy} - СЭС приняла извещение.
+// Отмечает сотрудник клиники (по телефонному подтверждению) или сама СЭС подписанным
+// запросом, в теле которого также id извещения и timestamp.
+func acknowledgeEmergencyNotification(ctx context.Context, w http.ResponseWriter, r *http.Request, id int64, user *User) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, 64<<10))
+	if err != nil {
+		errorResponse(w, http.StatusBadRequest, "invalid body")
+		return
+	}
+	var in emergencyAck
+	if user == nil {
+		in, err = verifyEmergencyAck(os.Getenv("SES_WEBHOOK_SECRET"), body, r.Header.Get("X-Signature"), id, time.Now())
+		switch {
+		case errors.Is(err, errAckSignature), errors.Is(err, errAckStale):
+			errorResponse(w, http.StatusUnauthorized, err.Error())
+			return
+		case errors.Is(err, errAckForeign):
+			errorResponse(w, http.StatusBadRequest, err.Error())
+			return
+		case err != nil:
+			errorResponse(w, http.StatusBadRequest, "invalid json")
+			return
+		}
+	} else if len(body) > 0 {
+		if err := json.Unmarshal(body, &in); err != nil {
+			errorResponse(w, http.StatusBadRequest, "invalid json")
+			return
+		}
+	}
+	acceptedBy := strings.TrimSpace(in.AcceptedBy)
+	if acceptedBy == "" && user != nil {
+		acceptedBy = user.ID
+	}
+
+	n, err := scanEmergencyNotification(db.QueryRow(ctx, `
+UPDATE emergency_notifications SET
+  status = 'acknowledged', acknowledged_at = NOW(), acknowledged_by = NULLIF($2, ''),
+  registration_number = NULLIF($3, ''), updated_at = NOW()
+WHERE id = $1 AND status = 'sent'
+RETURNING `+emergencyColumns, id, acceptedBy, strings.TrimSpace(in.RegistrationNumber)))
+	if errors.Is(err, pgx.ErrNoRows) {
+		// Повторное подтверждение не ошибка; черновик подтвердить нельзя
+		n, err = loadEmergencyNotification(ctx, db, id, false)
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			errorResponse(w, http.StatusNotFound, "notification not found")
+		case err != nil:
+			errorResponse(w, http.StatusInternalServerError, "db error")
+		case n.Status == EmergencyStatusAcknowledged:
+			jsonResponse(w, http.StatusOK, n)
+		default:
+			errorResponse(w, http.StatusConflict, "notification is not sent yet")
+		}
+		return
+	}
+	if err != nil {
+		log.Printf("acknowledgeEmergencyNotification %d: %v", id, err)
+		errorResponse(w, http.StatusInternalServerError, "db error")
+		return
+	}
+	if n.ClinicID != nil {
+		broadcastToUsers([]string{*n.ClinicID}, "emergency_notification_updated", map[string]interface{}{"id": n.ID, "status": n.Status})
+	}
+	jsonResponse(w, http.StatusOK, n)
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFindEmergencyFindings(t *testing.T) {
+	spec := map[string]map[string]any{
+		"Терапевт":   {"diagnosis": "I10 Гипертоническая болезнь"},
+		"Фтизиатр":   {"diagnosis": "А15.0 Туберкулёз лёгких", "doctorName": "Петров П.П."}, // кириллическая «А»
+		"Дерматолог": {"diagnosis": "B35.1 Микоз ногтей", "conclusion": "повторно B35.1"},
+	}
+	labs := map[string]map[string]any{
+		"Кал на яйца гельминтов": {"value": "обнаружены яйца", "conclusion": "аскаридоз (B77.0)", "flag": "A"},
+		"HBsAg":      {"value": "положительный", "conclusion": "носительство Z22.5"},
+		"Гемоглобин": {"value": "142 г/л", "norm": "130–160"},
+	}
+
+	got := findEmergencyFindings(spec, labs)
+	var codes []string
+	for _, f := range got {
+		codes = append(codes, f.Source+":"+f.SourceName+":"+f.Code)
+	}
+	want := []string{
+		"specialist:Дерматолог:B35.1",
+		"specialist:Фтизиатр:A15.0",
+		"lab:HBsAg:Z22.5",
+		"lab:Кал на яйца гельминтов:B77.0",
+	}
+	if !reflect.DeepEqual(codes, want) {
+		t.Fatalf("findings = %v, want %v", codes, want)
+	}
+	if got[1].Doctor != "Петров П.П." || got[1].Text != "А15.0 Туберкулёз лёгких" {
+		t.Errorf("finding = %+v", got[1])
+	}
+
+	if f := findEmergencyFindings(map[string]map[string]any{"Терапевт": {"diagnosis": "J06.9, K29.5"}}, nil); len(f) != 0 {
+		t.Errorf("non-infectious codes produced findings: %+v", f)
+	}
+}
+
+// Похожие на коды слова в обычном тексте и значениях анализов извещений не создают
+func TestFindEmergencyFindingsIgnoresLookalikes(t *testing.T) {
+	spec := map[string]map[string]any{
+		"Терапевт":    {"diagnosis": "D51.0 Анемия", "conclusion": "дефицит витамина В12, назначен B12 в/м"},
+		"Офтальмолог": {"conclusion": "острота зрения ВА15.0, кабинет А-15"},
+		"Хирург":      {"diagnosis": "B84 нет такой рубрики, A29 тоже", "icd10Code": "A10"},
+	}
+	labs := map[string]map[string]any{
+		"Витамин В12": {"value": "В12 180 пг/мл", "norm": "В12 > 200"},
+		"Посев":       {"value": "A15.0"}, // значение не просматривается
+	}
+	if f := findEmergencyFindings(spec, labs); len(f) != 0 {
+		t.Fatalf("lookalikes produced findings: %+v", f)
+	}
+}
+
+func TestIsInfectiousICD(t *testing.T) {
+	for code, want := range map[string]bool{
+		"A00": true, "A09.9": true, "A15.0": true, "A28": true, "A30": true, "A99": true,
+		"B00.1": true, "B09": true, "B15": true, "B20.0": true, "B83.9": true, "B85.0": true, "B99": true,
+		"Z22.5": true,
+		"A10":   false, "A14": false, "A29": false, "B10": false, "B12": false, "B14": false, "B84": false,
+		"Z21": false, "Z23": false, "I11.9": false, "B1": false,
+	} {
+		if got := isInfectiousICD(code); got != want {
+			t.Errorf("isInfectiousICD(%q) = %v, want %v", code, got, want)
+		}
+	}
+}
+
+func TestCanManageEmergencyNotificationRequiresClinic(t *testing.T) {
+	own := &EmergencyNotification{ClinicID: strp("clinic-a")}
+	if !canManageEmergencyNotification(doctorA, own) || !canManageEmergencyNotification(clinicA, own) {
+		t.Error("clinic staff must manage own notifications")
+	}
+	if canManageEmergencyNotification(doctorB, own) || canManageEmergencyNotification(employeA, own) {
+		t.Error("foreign clinic or patient must not manage the notification")
+	}
+	for _, n := range []*EmergencyNotification{{}, {ClinicID: strp("")}} {
+		if canManageEmergencyNotification(doctorA, n) || canManageEmergencyNotification(doctorB, n) {
+			t.Errorf("notification without clinic is open to every clinic: %+v", n)
+		}
+	}
+}
+
+func TestRenderEmergencyNotice(t *testing.T) {
+	general := map[string]any{"fullName": "Иванов Иван", "gender": "male", "dob": "1985-04-12", "address": "г. Караганда", "position": "повар"}
+	f := emergencyFinding{Code: "A15.0", Text: "А15.0 Туберкулёз лёгких", Source: "specialist", SourceName: "Фтизиатр", Doctor: "Петров П.П."}
+	detected := time.Date(2026, 3, 2, 10, 30, 0, 0, time.UTC)
+	n := &EmergencyNotification{ID: 42, Content: buildEmergencyNotice(f, "850412300123", general, "ТОО «Кафе»", "Клиника №1", detected)}
+
+	subject, text, err := renderEmergencyNotice(n, detected.Add(2*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if subject != "Экстренное извещение № 42 (форма 058/у)" {
+		t.Errorf("subject = %q", subject)
+	}
+	if strings.Contains(subject, "Иванов") || strings.Contains(subject, "A15") {
+		t.Errorf("subject exposes patient data: %q", subject)
+	}
+	for _, s := range []string{
+		"форма № 058/у", "Извещение № 42", "Медицинская организация: Клиника №1",
+		"Код по МКБ-10: A15.0", "Фамилия, имя, отчество: Иванов Иван", "ИИН: 850412300123",
+		"Пол: мужской", "Место работы: ТОО «Кафе»; должность: повар",
+		"Дата выявления: 2026-03-02 10:30 (осмотр специалиста: Фтизиатр)",
+		"мероприятия: —", "Фамилия сообщившего: Петров П.П.", "отсылки извещения: 2026-03-02 12:30",
+	} {
+		if !strings.Contains(text, s) {
+			t.Errorf("notice text lacks %q:\n%s", s, text)
+		}
+	}
+}
+
+func TestVerifyEmergencyAckBindsIDAndTime(t *testing.T) {
+	now := time.Date(2026, 3, 2, 12, 0, 0, 0, time.UTC)
+	body := func(id int64, at time.Time) []byte {
+		return []byte(fmt.Sprintf(`{"id":%d,"timestamp":%d,"registrationNumber":"R-1"}`, id, at.Unix()))
+	}
+
+	ackA := body(1, now.Add(-time.Minute))
+	sigA := signWebhookBody("s3cret", ackA)
+	in, err := verifyEmergencyAck("s3cret", ackA, sigA, 1, now)
+	if err != nil || in.RegistrationNumber != "R-1" {
+		t.Fatalf("valid ack: %+v, %v", in, err)
+	}
+	// Подпись подтверждения извещения 1 не подтверждает извещение 2
+	if _, err := verifyEmergencyAck("s3cret", ackA, sigA, 2, now); !errors.Is(err, errAckForeign) {
+		t.Errorf("ack for id 1 replayed on id 2: %v", err)
+	}
+	if _, err := verifyEmergencyAck("s3cret", ackA, sigA, 1, now.Add(10*time.Minute)); !errors.Is(err, errAckStale) {
+		t.Errorf("replayed stale ack: %v", err)
+	}
+
+	for name, b := range map[string][]byte{
+		"future":       body(1, now.Add(6*time.Minute)),
+		"no timestamp": []byte(`{"id":1}`),
+	} {
+		if _, err := verifyEmergencyAck("s3cret", b, signWebhookBody("s3cret", b), 1, now); !errors.Is(err, errAckStale) {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+	if _, err := verifyEmergencyAck("s3cret", body(2, now), sigA, 2, now); !errors.Is(err, errAckSignature) {
+		t.Errorf("body changed after signing: %v", err)
+	}
+}
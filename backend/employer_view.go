@@ -0,0 +1,282 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Проекция итогов осмотра для работодателя. Организация видит только годность,
+// ограничения, дату следующего осмотра и рекомендации по своему контингенту -
+// диагнозы, записи специалистов и анализы в ответ не попадают.
+
+// Статус работника для работодателя
+const (
+	EmployerStatusFit         = "fit"
+	EmployerStatusRestricted  = "fit_with_restrictions"
+	EmployerStatusTempUnfit   = "temporarily_unfit"
+	EmployerStatusPermUnfit   = "permanently_unfit"
+	EmployerStatusNeedsExam   = "needs_examination" // направлен на дообследование
+	EmployerStatusInProgress  = "in_progress"       // осмотр начат, заключение не подписано
+	EmployerStatusNotExamined = "not_examined"
+)
+
+// EmployerOutcome - всё, что работодатель узнаёт о работнике. Поля перечислены явно:
+// новое поле заключения не попадёт сюда, пока его не добавят в employerOutcome.
+type EmployerOutcome struct {
+	EmployeeID      string   `json:"employeeId"`
+	Name            string   `json:"name"`
+	Site            string   `json:"site,omitempty"`
+	Position        string   `json:"position,omitempty"`
+	Status          string   `json:"status"`
+	Restrictions    string   `json:"restrictions,omitempty"`
+	NextExamDate    string   `json:"nextExamDate,omitempty"`
+	Recommendations []string `json:"recommendations,omitempty"`
+	ConclusionDate  string   `json:"conclusionDate,omitempty"`
+}
+
+type EmployerOutcomes struct {
+	ContractID     int64             `json:"contractId"`
+	ContractNumber string            `json:"contractNumber"`
+	Counts         map[string]int    `json:"counts"`
+	Workers        []EmployerOutcome `json:"workers"`
+}
+
+// employerVisit - визит работника в объёме, нужном проекции: заключение только подписанное
+type employerVisit struct {
+	ID        int64
+	Concluded bool
+	Final     map[string]any
+}
+
+// employerOutcome строит запись для работодателя. Из заключения читаются только
+// разрешённые ключи; записи специалистов и диагноз сюда не передаются вовсе.
+func employerOutcome(e contractEmployee, v *employerVisit) EmployerOutcome {
+	o := EmployerOutcome{
+		EmployeeID: e.ID,
+		Name:       strings.TrimSpace(e.Name),
+		Site:       e.Site,
+		Position:   e.Position,
+	}
+	switch {
+	case v != nil && v.Concluded:
+		final := v.Final
+		o.Restrictions = cardText(final, "restrictions")
+		switch {
+		case conclusionFlag(final, "needsFurtherExamination"):
+			o.Status = EmployerStatusNeedsExam
+		case conclusionFlag(final, "isFit") && o.Restrictions != "":
+			o.Status = EmployerStatusRestricted
+		case conclusionFlag(final, "isFit"):
+			o.Status = EmployerStatusFit
+		case cardText(final, "unfitType") == "permanent":
+			o.Status = EmployerStatusPermUnfit
+		default:
+			o.Status = EmployerStatusTempUnfit
+		}
+		o.NextExamDate = cardText(final, "nextExamDate")
+		o.ConclusionDate = cardText(final, "date")
+		for _, m := range actMeasures {
+			if conclusionFlag(final, m.Key) {
+				o.Recommendations = append(o.Recommendations, m.Title)
+			}
+		}
+		if r := cardText(final, "recommendations"); r != "" {
+			o.Recommendations = append(o.Recommendations, r)
+		}
+	case v != nil:
+		o.Status = EmployerStatusInProgress
+	default:
+		// Договоры без визитов в системе: статус проставлен в контингенте вручную
+		switch e.Status {
+		case "fit":
+			o.Status = EmployerStatusFit
+		case "fit_with_restrictions":
+			o.Status = EmployerStatusRestricted
+		case "unfit":
+			o.Status = EmployerStatusTempUnfit
+		case "needs_observation":
+			o.Status = EmployerStatusNeedsExam
+		default:
+			o.Status = EmployerStatusNotExamined
+		}
+	}
+	return o
+}
+
+func loadEmployerOutcomes(ctx context.Context, contractID int64) (*EmployerOutcomes, error) {
+	res := &EmployerOutcomes{ContractID: contractID, Counts: map[string]int{}, Workers: []EmployerOutcome{}}
+	var employeesJSON []byte
+	if err := db.QueryRow(ctx, `SELECT number, employees FROM contracts WHERE id = $1`, contractID).
+		Scan(&res.ContractNumber, &employeesJSON); err != nil {
+		return nil, err
+	}
+	var employees []contractEmployee
+	if len(employeesJSON) > 0 {
+		if err := json.Unmarshal(employeesJSON, &employees); err != nil {
+			return nil, fmt.Errorf("parse contract employees: %w", err)
+		}
+	}
+
+	// Заключение выбирается только у подписанных эпизодов, записи специалистов не читаются
+	rows, err := db.Query(ctx, `
+SELECT v.id, v.employee_id, COALESCE(e.locked, FALSE),
+       CASE WHEN e.locked THEN e.final_conclusion ELSE '{}'::jsonb END
+FROM employee_visits v
+LEFT JOIN exam_episodes e ON e.visit_id = v.id
+WHERE v.contract_id = $1 AND v.status <> 'cancelled'
+ORDER BY v.visit_date, v.id
+`, contractID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	visits := map[string]*employerVisit{}
+	for rows.Next() {
+		var v employerVisit
+		var employeeID string
+		var finalJSON []byte
+		if err := rows.Scan(&v.ID, &employeeID, &v.Concluded, &finalJSON); err != nil {
+			return nil, err
+		}
+		_ = json.Unmarshal(finalJSON, &v.Final)
+		if prev, ok := visits[employeeID]; !ok || v.Concluded || !prev.Concluded {
+			visits[employeeID] = &v
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, e := range employees {
+		v := visits[e.ID]
+		if v == nil && e.UserID != "" {
+			v = visits[e.UserID]
+		}
+		o := employerOutcome(e, v)
+		res.Counts[o.Status]++
+		res.Workers = append(res.Workers, o)
+	}
+	sort.SliceStable(res.Workers, func(i, j int) bool {
+		if res.Workers[i].Site != res.Workers[j].Site {
+			return res.Workers[i].Site < res.Workers[j].Site
+		}
+		return res.Workers[i].Name < res.Workers[j].Name
+	})
+	return res, nil
+}
+
+// canReadAmbulatoryCard - полную карту видят только сам пациент и сотрудники клиник.
+// Работодатель получает итоги через /api/contracts/{id}/outcomes.
+func canReadAmbulatoryCard(u *User, patientUID string) bool {
+	if u.Role == UserRoleOrganization {
+		return false
+	}
+	return canAccessPatientFiles(u, patientUID, nil)
+}
+
+// --- Доступ к медицинским записям ---
+
+// recordStore - данные для проверки доступа к медицинским записям и ответа на запрос;
+// в тестах заменяется памятью
+type recordStore interface {
+	User(ctx context.Context, uid string) (*User, error)
+	ContractParties(ctx context.Context, contractID int64) (*contractParties, error)
+	Episode(ctx context.Context, id int64) (*ExamEpisode, error)
+	FinalAct(ctx context.Context, contractID int64) (*FinalAct, error)
+	EmployerOutcomes(ctx context.Context, contractID int64) (*EmployerOutcomes, error)
+	// VisitScope - клиника визита и работник
+	VisitScope(ctx context.Context, visitID int64) (clinicID, patientUID string, err error)
+	PatientInClinic(ctx context.Context, patientUID, clinicID string) (bool, error)
+}
+
+var records recordStore = pgRecordStore{}
+
+type pgRecordStore struct{}
+
+func (pgRecordStore) User(ctx context.Context, uid string) (*User, error) { return loadUser(ctx, uid) }
+func (pgRecordStore) ContractParties(ctx context.Context, id int64) (*contractParties, error) {
+	return loadContractParties(ctx, id)
+}
+func (pgRecordStore) Episode(ctx context.Context, id int64) (*ExamEpisode, error) {
+	return loadEpisode(ctx, db, id)
+}
+func (pgRecordStore) FinalAct(ctx context.Context, id int64) (*FinalAct, error) {
+	return loadFinalAct(ctx, id)
+}
+func (pgRecordStore) EmployerOutcomes(ctx context.Context, id int64) (*EmployerOutcomes, error) {
+	return loadEmployerOutcomes(ctx, id)
+}
+func (pgRecordStore) VisitScope(ctx context.Context, id int64) (string, string, error) {
+	var clinicID, patientUID string
+	err := db.QueryRow(ctx, `SELECT COALESCE(clinic_id, ''), employee_id FROM employee_visits WHERE id = $1`, id).Scan(&clinicID, &patientUID)
+	return clinicID, patientUID, err
+}
+func (pgRecordStore) PatientInClinic(ctx context.Context, patientUID, clinicID string) (bool, error) {
+	return pgTopicDirectory{}.PatientInClinic(ctx, patientUID, clinicID)
+}
+
+// authorizePatientRecords - записи пациента (эпизоды, динамика анализов) видят сам пациент и сотрудники клиник
+func authorizePatientRecords(ctx context.Context, w http.ResponseWriter, r *http.Request, patientUID string) (*User, bool) {
+	user, ok := requestUser(ctx, w, r)
+	if !ok {
+		return nil, false
+	}
+	if !canReadAmbulatoryCard(user, patientUID) {
+		errorResponse(w, http.StatusForbidden, "access denied")
+		return nil, false
+	}
+	return user, true
+}
+
+// authorizeEpisode загружает эпизод и проверяет доступ: читать - пациенту и сотрудникам
+// клиники осмотра, изменять - только сотрудникам клиники осмотра
+func authorizeEpisode(ctx context.Context, w http.ResponseWriter, r *http.Request, id int64, write bool) (*User, *ExamEpisode, bool) {
+	user, ok := requestUser(ctx, w, r)
+	if !ok {
+		return nil, nil, false
+	}
+	e, err := records.Episode(ctx, id)
+	if err != nil {
+		errorResponse(w, http.StatusNotFound, "episode not found")
+		return nil, nil, false
+	}
+	allowed := canReadAmbulatoryCard(user, e.PatientUID) && canAccessPatientFiles(user, e.PatientUID, e.ClinicID)
+	if write {
+		allowed = allowed && isClinicStaff(user)
+	}
+	if !allowed {
+		errorResponse(w, http.StatusForbidden, "access denied")
+		return nil, nil, false
+	}
+	return user, e, true
+}
+
+// contractDocVisibleToClient - документы договора, которые видит работодатель.
+// Заключительный акт содержит диагнозы - работодателю он не отдаётся.
+func contractDocVisibleToClient(docType string) bool {
+	return docType == ContractDocHealthPlan
+}
+
+// GET /api/contracts/{id}/outcomes - итоги осмотра контингента без медицинских данных
+func employerOutcomesHandler(w http.ResponseWriter, r *http.Request, contractID int64) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	if _, _, ok := authorizeContract(ctx, w, r, contractID, false); !ok {
+		return
+	}
+	res, err := records.EmployerOutcomes(ctx, contractID)
+	if err != nil {
+		log.Printf("employerOutcomes: contract %d: %v", contractID, err)
+		errorResponse(w, http.StatusInternalServerError, "db error")
+		return
+	}
+	w.Header().Set("Cache-Control", "private, no-store")
+	jsonResponse(w, http.StatusOK, res)
+}
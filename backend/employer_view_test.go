@@ -0,0 +1,278 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Заключение и записи специалистов с медицинскими данными, которые не должны уйти работодателю
+const secretFinal = `{
+	"isFit": true,
+	"healthGroup": "III",
+	"restrictions": "Без работы на высоте",
+	"nextExamDate": "2027-03-01",
+	"date": "2026-03-01",
+	"diagnosis": "I11.9 Гипертоническая болезнь",
+	"newlyDiagnosed": true,
+	"occupationalSuspicion": true,
+	"unfitReason": "SECRET-UNFIT-REASON",
+	"complaints": "SECRET-COMPLAINTS",
+	"dispensaryObservation": true,
+	"recommendations": "Контроль давления у терапевта"
+}`
+
+const secretSpec = `{
+	"Терапевт": {"diagnosis": "I11.9 SECRET-SPEC-DIAGNOSIS", "complaints": "SECRET-SPEC-COMPLAINTS",
+		"anamnesis": "SECRET-ANAMNESIS", "recommendations": "SECRET-SPEC-RECOMMENDATION"},
+	"Невропатолог": {"diagnosis": "G43.0 SECRET-MIGRAINE", "objective": "SECRET-OBJECTIVE"}
+}`
+
+func decodeJSON[T any](t *testing.T, s string) T {
+	t.Helper()
+	var v T
+	if err := json.Unmarshal([]byte(s), &v); err != nil {
+		t.Fatalf("decode %s: %v", s, err)
+	}
+	return v
+}
+
+func TestEmployerOutcomeWhitelist(t *testing.T) {
+	final := decodeJSON[map[string]any](t, secretFinal)
+	// Записи специалистов лежат в той же структуре данных эпизода, что и заключение:
+	// подкладываем их в заключение, чтобы проверить, что вложенные поля не копируются
+	final["specialistEntries"] = decodeJSON[map[string]any](t, secretSpec)
+	final["labResults"] = map[string]any{"blood": map[string]any{"value": "SECRET-LAB"}}
+
+	emp := contractEmployee{ID: "u1", Name: " Иванов Иван ", Site: "Цех 1", Position: "Сварщик",
+		Dob: "1980-01-01", Gender: "М", HarmfulFactor: "п. 12", HealthGroup: "IV"}
+	got := employerOutcome(emp, &employerVisit{ID: 7, Concluded: true, Final: final})
+
+	want := EmployerOutcome{
+		EmployeeID:      "u1",
+		Name:            "Иванов Иван",
+		Site:            "Цех 1",
+		Position:        "Сварщик",
+		Status:          EmployerStatusRestricted,
+		Restrictions:    "Без работы на высоте",
+		NextExamDate:    "2027-03-01",
+		ConclusionDate:  "2026-03-01",
+		Recommendations: []string{"диспансерное наблюдение", "Контроль давления у терапевта"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("outcome = %+v, want %+v", got, want)
+	}
+
+	raw, err := json.Marshal(EmployerOutcomes{ContractID: 1, Counts: map[string]int{got.Status: 1}, Workers: []EmployerOutcome{got}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := string(raw)
+	for _, leak := range []string{
+		"I11", "G43", "SECRET", "Гипертон",
+		`"diagnos`, `"healthGroup"`, `"specialistEntries"`, `"labResults"`, `"complaints"`,
+		`"anamnesis"`, `"newlyDiagnosed"`, `"occupationalSuspicion"`, `"harmfulFactor"`, `"dob"`,
+	} {
+		if strings.Contains(body, leak) {
+			t.Errorf("employer projection leaks %q: %s", leak, body)
+		}
+	}
+}
+
+// Ответ содержит ровно перечисленные поля - новое поле структуры должно пройти ревью
+func TestEmployerOutcomeFields(t *testing.T) {
+	allowed := map[string]bool{
+		"employeeId": true, "name": true, "site": true, "position": true, "status": true,
+		"restrictions": true, "nextExamDate": true, "recommendations": true, "conclusionDate": true,
+	}
+	typ := reflect.TypeOf(EmployerOutcome{})
+	for i := 0; i < typ.NumField(); i++ {
+		name := strings.Split(typ.Field(i).Tag.Get("json"), ",")[0]
+		if !allowed[name] {
+			t.Errorf("EmployerOutcome exposes unexpected field %q", name)
+		}
+	}
+}
+
+func TestEmployerOutcomeStatus(t *testing.T) {
+	cases := []struct {
+		name   string
+		emp    contractEmployee
+		visit  *employerVisit
+		status string
+	}{
+		{"fit", contractEmployee{}, &employerVisit{Concluded: true, Final: map[string]any{"isFit": true}}, EmployerStatusFit},
+		{"temporarily unfit", contractEmployee{}, &employerVisit{Concluded: true, Final: map[string]any{"isFit": false}}, EmployerStatusTempUnfit},
+		{"permanently unfit", contractEmployee{}, &employerVisit{Concluded: true, Final: map[string]any{"isFit": false, "unfitType": "permanent"}}, EmployerStatusPermUnfit},
+		{"further examination", contractEmployee{}, &employerVisit{Concluded: true, Final: map[string]any{"isFit": true, "needsFurtherExamination": true}}, EmployerStatusNeedsExam},
+		{"unsigned conclusion", contractEmployee{}, &employerVisit{Final: map[string]any{}}, EmployerStatusInProgress},
+		{"legacy status", contractEmployee{Status: "fit_with_restrictions"}, nil, EmployerStatusRestricted},
+		{"no visit", contractEmployee{Status: "pending"}, nil, EmployerStatusNotExamined},
+	}
+	for _, c := range cases {
+		got := employerOutcome(c.emp, c.visit)
+		if got.Status != c.status {
+			t.Errorf("%s: status = %q, want %q", c.name, got.Status, c.status)
+		}
+		if c.visit != nil && !c.visit.Concluded && (got.Restrictions != "" || got.Recommendations != nil) {
+			t.Errorf("%s: unsigned conclusion must not be exposed: %+v", c.name, got)
+		}
+	}
+}
+
+func TestCanReadAmbulatoryCard(t *testing.T) {
+	clinic := "clinic-1"
+	cases := []struct {
+		name string
+		user User
+		want bool
+	}{
+		{"organization", User{ID: "org", Role: UserRoleOrganization}, false},
+		{"patient", User{ID: "p1", Role: UserRoleEmployee}, true},
+		{"other employee", User{ID: "p2", Role: UserRoleEmployee}, false},
+		{"doctor", User{ID: "d1", Role: UserRoleDoctor, ClinicID: &clinic}, true},
+		{"registration", User{ID: "r1", Role: UserRoleRegistration, ClinicID: &clinic}, true},
+		{"clinic", User{ID: clinic, Role: UserRoleClinic}, true},
+	}
+	for _, c := range cases {
+		if got := canReadAmbulatoryCard(&c.user, "p1"); got != c.want {
+			t.Errorf("%s: canReadAmbulatoryCard = %v, want %v", c.name, got, c.want)
+		}
+	}
+}
+
+// memRecordStore - пользователи, договор 1 (clinic-a / org-a), эпизод 1 пациента emp-a и акт с диагнозами
+type memRecordStore struct{}
+
+var recordUsers = map[string]*User{}
+
+func init() {
+	for _, u := range []*User{clinicA, clinicB, doctorA, doctorB, regA, orgA, employeA} {
+		recordUsers[u.ID] = u
+	}
+}
+
+func (memRecordStore) User(ctx context.Context, uid string) (*User, error) {
+	if u, ok := recordUsers[uid]; ok {
+		return u, nil
+	}
+	return nil, pgx.ErrNoRows
+}
+
+func (memRecordStore) ContractParties(ctx context.Context, id int64) (*contractParties, error) {
+	return testDirectory.ContractParties(ctx, id)
+}
+
+func (memRecordStore) Episode(ctx context.Context, id int64) (*ExamEpisode, error) {
+	if id != 1 {
+		return nil, pgx.ErrNoRows
+	}
+	return &ExamEpisode{ID: 1, PatientUID: "emp-a", ClinicID: strp("clinic-a"), Spec: json.RawMessage(secretSpec),
+		Final: json.RawMessage(secretFinal)}, nil
+}
+
+func (memRecordStore) FinalAct(ctx context.Context, id int64) (*FinalAct, error) {
+	return &FinalAct{ContractID: id, Workers: []FinalActWorker{{
+		EmployeeID: "emp-a", Name: "Иванов", Outcome: ActOutcomeFit,
+		Diagnoses: []string{"I11.9"}, Recommendations: []actRecommendation{{Source: RecommendationSpecialist, Specialty: "Терапевт", Text: "SECRET-SPEC-RECOMMENDATION"}},
+	}}}, nil
+}
+
+func (memRecordStore) VisitScope(ctx context.Context, id int64) (string, string, error) {
+	return testDirectory.VisitScope(ctx, id)
+}
+
+func (memRecordStore) PatientInClinic(ctx context.Context, patientUID, clinicID string) (bool, error) {
+	return testDirectory.PatientInClinic(ctx, patientUID, clinicID)
+}
+
+func (memRecordStore) EmployerOutcomes(ctx context.Context, id int64) (*EmployerOutcomes, error) {
+	var v employerVisit
+	v.Concluded = true
+	json.Unmarshal([]byte(secretFinal), &v.Final)
+	o := employerOutcome(contractEmployee{ID: "emp-a", Name: "Иванов"}, &v)
+	return &EmployerOutcomes{ContractID: id, Counts: map[string]int{o.Status: 1}, Workers: []EmployerOutcome{o}}, nil
+}
+
+func callAs(t *testing.T, u *User, method, target string, body string, h func(http.ResponseWriter, *http.Request)) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	if u != nil {
+		req.Header.Set("X-User-ID", u.ID)
+	}
+	rec := httptest.NewRecorder()
+	h(rec, req)
+	return rec
+}
+
+// Медицинские данные не доходят до работодателя ни через один обработчик
+func TestMedicalRecordHandlersHideDiagnosesFromEmployer(t *testing.T) {
+	prev := records
+	records = memRecordStore{}
+	defer func() { records = prev }()
+
+	episode := func(h func(http.ResponseWriter, *http.Request, int64)) func(http.ResponseWriter, *http.Request) {
+		return func(w http.ResponseWriter, r *http.Request) { h(w, r, 1) }
+	}
+	secrets := []string{"I11.9", "SECRET", "diagnos"}
+
+	cases := []struct {
+		name   string
+		user   *User
+		method string
+		target string
+		body   string
+		h      func(http.ResponseWriter, *http.Request)
+		want   int
+	}{
+		{"act json", orgA, "GET", "/api/contracts/1/final-act", "", episode(finalActHandler), http.StatusOK},
+		{"act pdf", orgA, "GET", "/api/contracts/1/final-act?format=pdf", "", episode(finalActHandler), http.StatusForbidden},
+		{"act docx", orgA, "GET", "/api/contracts/1/final-act?format=docx", "", episode(finalActHandler), http.StatusForbidden},
+		{"act save", orgA, "POST", "/api/contracts/1/final-act", "", episode(finalActHandler), http.StatusForbidden},
+		{"abnormal labs", orgA, "GET", "/api/contracts/1/abnormal-labs", "", episode(contractAbnormalLabsHandler), http.StatusForbidden},
+		{"episode", orgA, "GET", "/api/episodes/1", "", episode(getEpisodeHandler), http.StatusForbidden},
+		{"episode labs", orgA, "GET", "/api/episodes/1/lab-results", "", episode(listEpisodeLabResultsHandler), http.StatusForbidden},
+		{"patient episodes", orgA, "GET", "/api/patients/emp-a/episodes", "", listPatientEpisodesHandler, http.StatusForbidden},
+		{"lab trends", orgA, "GET", "/api/patients/emp-a/lab-trends", "", labTrendsHandler, http.StatusForbidden},
+		{"episode update", orgA, "PATCH", "/api/episodes/1", `{"specialistEntries":{}}`, episode(updateEpisodeHandler), http.StatusForbidden},
+		{"lab results", orgA, "POST", "/api/episodes/1/lab-results", `[{"testCode":"hgb","value":"120"}]`, episode(createLabResultsHandler), http.StatusForbidden},
+
+		{"anonymous update", nil, "PATCH", "/api/episodes/1", `{"specialistEntries":{}}`, episode(updateEpisodeHandler), http.StatusUnauthorized},
+		{"anonymous episode", nil, "GET", "/api/episodes/1", "", episode(getEpisodeHandler), http.StatusUnauthorized},
+		{"patient update", employeA, "PATCH", "/api/episodes/1", `{"specialistEntries":{}}`, episode(updateEpisodeHandler), http.StatusForbidden},
+		{"other clinic episode", doctorB, "GET", "/api/episodes/1", "", episode(getEpisodeHandler), http.StatusForbidden},
+		{"other clinic labs", doctorB, "GET", "/api/contracts/1/abnormal-labs", "", episode(contractAbnormalLabsHandler), http.StatusForbidden},
+	}
+	for _, c := range cases {
+		rec := callAs(t, c.user, c.method, c.target, c.body, c.h)
+		if rec.Code != c.want {
+			t.Errorf("%s: status %d, want %d (%s)", c.name, rec.Code, c.want, rec.Body)
+		}
+		if c.user == orgA {
+			for _, s := range secrets {
+				if strings.Contains(rec.Body.String(), s) {
+					t.Errorf("%s: response leaks %q: %s", c.name, s, rec.Body)
+				}
+			}
+		}
+	}
+
+	// Клиника и пациент свои данные видят
+	if rec := callAs(t, clinicA, "GET", "/api/contracts/1/final-act", "", episode(finalActHandler)); !strings.Contains(rec.Body.String(), "I11.9") {
+		t.Errorf("clinic must see the full act, got %d %s", rec.Code, rec.Body)
+	}
+	for _, u := range []*User{doctorA, employeA} {
+		if rec := callAs(t, u, "GET", "/api/episodes/1", "", episode(getEpisodeHandler)); rec.Code != http.StatusOK {
+			t.Errorf("%s: episode status %d", u.ID, rec.Code)
+		}
+	}
+	if !contractDocVisibleToClient(ContractDocHealthPlan) || contractDocVisibleToClient(ContractDocFinalAct) {
+		t.Error("employer may download the health plan but not the final act")
+	}
+}
@@ -0,0 +1,568 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// --- EXAM EPISODES (эпизоды медосмотров) ---
+//
+// Паспортная часть и медицинский анамнез хранятся в ambulatory_cards (один раз на пациента),
+// а записи специалистов, анализы и заключение - в эпизоде конкретного осмотра.
+
+// Виды медосмотров по Приказу
+const (
+	ExamTypePreliminary   = "preliminary"   // предварительный
+	ExamTypePeriodic      = "periodic"      // периодический
+	ExamTypePreShift      = "pre_shift"     // предсменный
+	ExamTypeExtraordinary = "extraordinary" // внеочередной
+)
+
+func validExamType(t string) bool {
+	switch t {
+	case ExamTypePreliminary, ExamTypePeriodic, ExamTypePreShift, ExamTypeExtraordinary:
+		return true
+	}
+	return false
+}
+
+// ExamEpisode - один медосмотр пациента
+type ExamEpisode struct {
+	ID            int64           `json:"id"`
+	PatientUID    string          `json:"patientUid"`
+	VisitID       *int64          `json:"visitId,omitempty"`
+	ContractID    *int64          `json:"contractId,omitempty"`
+	ClinicID      *string         `json:"clinicId,omitempty"`
+	ExamType      string          `json:"examType"`
+	ExamDate      string          `json:"examDate"`
+	Status        string          `json:"status"`
+	Spec          json.RawMessage `json:"specialistEntries,omitempty"`
+	Labs          json.RawMessage `json:"labResults,omitempty"`
+	Final         json.RawMessage `json:"finalConclusion,omitempty"`
+	Commission    json.RawMessage `json:"commission,omitempty"`
+	FinalSignedBy *int64          `json:"finalSignedBy,omitempty"`
+	FinalSignedAt *string         `json:"finalSignedAt,omitempty"`
+	Locked        bool            `json:"locked"`
+	CreatedAt     string          `json:"createdAt"`
+	UpdatedAt     string          `json:"updatedAt"`
+}
+
+// dbExecutor - общий интерфейс пула и транзакции
+type dbExecutor interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+func migrateEpisodes(ctx context.Context, tx pgx.Tx) error {
+	_, err := tx.Exec(ctx, `
+CREATE TABLE IF NOT EXISTS exam_episodes (
+  id                 SERIAL PRIMARY KEY,
+  patient_uid        TEXT NOT NULL,
+  visit_id           INTEGER UNIQUE REFERENCES employee_visits(id) ON DELETE SET NULL,
+  contract_id        INTEGER REFERENCES contracts(id) ON DELETE SET NULL,
+  clinic_id          TEXT,
+  exam_type          TEXT NOT NULL DEFAULT 'periodic',
+  exam_date          DATE NOT NULL DEFAULT CURRENT_DATE,
+  status             TEXT NOT NULL DEFAULT 'open',
+  specialist_entries JSONB NOT NULL DEFAULT '{}'::jsonb,
+  lab_results        JSONB NOT NULL DEFAULT '{}'::jsonb,
+  final_conclusion   JSONB NOT NULL DEFAULT '{}'::jsonb,
+  commission         JSONB NOT NULL DEFAULT '[]'::jsonb,
+  final_signed_by    INTEGER,
+  final_signed_at    TIMESTAMPTZ,
+  locked             BOOLEAN NOT NULL DEFAULT FALSE,
+  reopened_by        INTEGER,
+  reopened_at        TIMESTAMPTZ,
+  reopen_reason      TEXT,
+  created_at         TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  updated_at         TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  CONSTRAINT valid_exam_type CHECK (exam_type IN ('preliminary', 'periodic', 'pre_shift', 'extraordinary')),
+  CONSTRAINT valid_episode_status CHECK (status IN ('open', 'concluded', 'cancelled'))
+);
+`)
+	if err != nil {
+		return fmt.Errorf("migrate exam_episodes: %w", err)
+	}
+
+	_, err = tx.Exec(ctx, `CREATE INDEX IF NOT EXISTS idx_exam_episodes_patient ON exam_episodes(patient_uid, exam_date);`)
+	if err != nil {
+		return fmt.Errorf("create index exam_episodes_patient: %w", err)
+	}
+
+	_, err = tx.Exec(ctx, `CREATE INDEX IF NOT EXISTS idx_exam_episodes_contract ON exam_episodes(contract_id) WHERE contract_id IS NOT NULL;`)
+	if err != nil {
+		return fmt.Errorf("create index exam_episodes_contract: %w", err)
+	}
+
+	// Карты, заведённые до появления эпизодов, хранили записи и заключение в своих колонках
+	var legacy bool
+	err = tx.QueryRow(ctx, `
+SELECT EXISTS (SELECT 1 FROM information_schema.columns
+               WHERE table_name = 'ambulatory_cards' AND column_name = 'final_conclusion')
+`).Scan(&legacy)
+	if err != nil {
+		return fmt.Errorf("check legacy card columns: %w", err)
+	}
+	if !legacy {
+		return nil
+	}
+
+	// В базах до подписи председателем колонок подписи ещё нет - добавляем, чтобы перенос их прочитал
+	_, err = tx.Exec(ctx, `
+ALTER TABLE ambulatory_cards
+  ADD COLUMN IF NOT EXISTS specialist_entries JSONB,
+  ADD COLUMN IF NOT EXISTS lab_results JSONB,
+  ADD COLUMN IF NOT EXISTS commission JSONB,
+  ADD COLUMN IF NOT EXISTS final_signed_by INTEGER,
+  ADD COLUMN IF NOT EXISTS final_signed_at TIMESTAMPTZ,
+  ADD COLUMN IF NOT EXISTS final_visit_id INTEGER,
+  ADD COLUMN IF NOT EXISTS locked BOOLEAN NOT NULL DEFAULT FALSE
+`)
+	if err != nil {
+		return fmt.Errorf("prepare legacy card columns: %w", err)
+	}
+
+	// Переносим их в эпизоды (по одному эпизоду на карту) и убираем колонки из карты
+	_, err = tx.Exec(ctx, `
+INSERT INTO exam_episodes (patient_uid, visit_id, contract_id, clinic_id, exam_date, status,
+                           specialist_entries, lab_results, final_conclusion, commission,
+                           final_signed_by, final_signed_at, locked, created_at, updated_at)
+SELECT c.patient_uid, v.id, v.contract_id, v.clinic_id, COALESCE(v.visit_date, c.created_at::date),
+       CASE WHEN c.locked THEN 'concluded' ELSE 'open' END,
+       COALESCE(c.specialist_entries, '{}'::jsonb), COALESCE(c.lab_results, '{}'::jsonb),
+       COALESCE(c.final_conclusion, '{}'::jsonb), COALESCE(c.commission, '[]'::jsonb),
+       c.final_signed_by, c.final_signed_at, c.locked, c.created_at, c.updated_at
+FROM ambulatory_cards c
+LEFT JOIN employee_visits v ON v.id = c.final_visit_id
+WHERE NOT EXISTS (SELECT 1 FROM exam_episodes e WHERE e.patient_uid = c.patient_uid)
+`)
+	if err != nil {
+		return fmt.Errorf("backfill exam_episodes: %w", err)
+	}
+
+	_, err = tx.Exec(ctx, `
+ALTER TABLE ambulatory_cards
+  DROP COLUMN IF EXISTS specialist_entries,
+  DROP COLUMN IF EXISTS lab_results,
+  DROP COLUMN IF EXISTS final_conclusion,
+  DROP COLUMN IF EXISTS commission,
+  DROP COLUMN IF EXISTS final_signed_by,
+  DROP COLUMN IF EXISTS final_signed_at,
+  DROP COLUMN IF EXISTS final_visit_id,
+  DROP COLUMN IF EXISTS locked,
+  DROP COLUMN IF EXISTS reopened_by,
+  DROP COLUMN IF EXISTS reopened_at,
+  DROP COLUMN IF EXISTS reopen_reason
+`)
+	if err != nil {
+		return fmt.Errorf("drop legacy card columns: %w", err)
+	}
+	return nil
+}
+
+const episodeColumns = `id, patient_uid, visit_id, contract_id, clinic_id, exam_type, exam_date, status,
+       specialist_entries, lab_results, final_conclusion, commission, final_signed_by, final_signed_at, locked,
+       created_at, updated_at`
+
+func scanEpisode(row pgx.Row) (*ExamEpisode, error) {
+	var e ExamEpisode
+	var examDate, createdAt, updatedAt time.Time
+	var signedAt *time.Time
+	var spec, labs, final, commission []byte
+	err := row.Scan(&e.ID, &e.PatientUID, &e.VisitID, &e.ContractID, &e.ClinicID, &e.ExamType, &examDate, &e.Status,
+		&spec, &labs, &final, &commission, &e.FinalSignedBy, &signedAt, &e.Locked, &createdAt, &updatedAt)
+	if err != nil {
+		return nil, err
+	}
+	e.ExamDate = examDate.Format("2006-01-02")
+	e.Spec = json.RawMessage(spec)
+	e.Labs = json.RawMessage(labs)
+	e.Final = json.RawMessage(final)
+	e.Commission = json.RawMessage(commission)
+	if signedAt != nil {
+		s := signedAt.Format(time.RFC3339)
+		e.FinalSignedAt = &s
+	}
+	e.CreatedAt = createdAt.Format(time.RFC3339)
+	e.UpdatedAt = updatedAt.Format(time.RFC3339)
+	return &e, nil
+}
+
+func loadEpisode(ctx context.Context, q dbExecutor, id int64) (*ExamEpisode, error) {
+	return scanEpisode(q.QueryRow(ctx, `SELECT `+episodeColumns+` FROM exam_episodes WHERE id = $1`, id))
+}
+
+// ensureEpisodeForVisit возвращает эпизод визита, создавая его при первом обращении
+func ensureEpisodeForVisit(ctx context.Context, q dbExecutor, visitID int64, examType string) (int64, error) {
+	if !validExamType(examType) {
+		examType = ExamTypePeriodic
+	}
+	var id int64
+	err := q.QueryRow(ctx, `
+INSERT INTO exam_episodes (patient_uid, visit_id, contract_id, clinic_id, exam_type, exam_date)
+SELECT employee_id, id, NULLIF(contract_id, 0), clinic_id, $2, visit_date FROM employee_visits WHERE id = $1
+ON CONFLICT (visit_id) DO UPDATE SET updated_at = exam_episodes.updated_at
+RETURNING id
+`, visitID, examType).Scan(&id)
+	return id, err
+}
+
+// openVisitEpisodeID - эпизод открытого визита пациента; create=true заводит его, если визит есть
+func openVisitEpisodeID(ctx context.Context, q dbExecutor, patientUID string, create bool) (int64, bool, error) {
+	var visitID int64
+	err := q.QueryRow(ctx, `
+SELECT id FROM employee_visits
+WHERE employee_id = $1 AND status IN ('registered', 'in_progress')
+ORDER BY created_at DESC LIMIT 1
+`, patientUID).Scan(&visitID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	if create {
+		id, err := ensureEpisodeForVisit(ctx, q, visitID, ExamTypePeriodic)
+		return id, err == nil, err
+	}
+	var id int64
+	err = q.QueryRow(ctx, `SELECT id FROM exam_episodes WHERE visit_id = $1`, visitID).Scan(&id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, false, nil
+	}
+	return id, err == nil, err
+}
+
+// currentEpisodeID - эпизод для просмотра карты: эпизод открытого визита,
+// иначе последний по дате, в том числе подписанный
+func currentEpisodeID(ctx context.Context, q dbExecutor, patientUID string) (int64, bool, error) {
+	if id, ok, err := openVisitEpisodeID(ctx, q, patientUID, false); ok || err != nil {
+		return id, ok, err
+	}
+	var id int64
+	err := q.QueryRow(ctx, `
+SELECT id FROM exam_episodes WHERE patient_uid = $1
+ORDER BY exam_date DESC, id DESC LIMIT 1
+`, patientUID).Scan(&id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, false, nil
+	}
+	return id, err == nil, err
+}
+
+// writableEpisodeID - эпизод для записи осмотров и анализов: эпизод открытого визита,
+// иначе последний неподписанный эпизод клиники clinicID (пустой - любой клиники).
+// Подписанный эпизод не подставляется: create=true заводит новый в клинике clinicID,
+// иначе эпизод не найден.
+func writableEpisodeID(ctx context.Context, q dbExecutor, patientUID, clinicID string, create bool) (int64, bool, error) {
+	if id, ok, err := openVisitEpisodeID(ctx, q, patientUID, create); ok || err != nil {
+		return id, ok, err
+	}
+	var id int64
+	err := q.QueryRow(ctx, `
+SELECT id FROM exam_episodes WHERE patient_uid = $1 AND NOT locked AND ($2 = '' OR clinic_id = $2)
+ORDER BY exam_date DESC, id DESC LIMIT 1
+`, patientUID, clinicID).Scan(&id)
+	if err == nil {
+		return id, true, nil
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return 0, false, err
+	}
+	if !create {
+		return 0, false, nil
+	}
+
+	err = q.QueryRow(ctx, `INSERT INTO exam_episodes (patient_uid, clinic_id) VALUES ($1, NULLIF($2, '')) RETURNING id`, patientUID, clinicID).Scan(&id)
+	return id, err == nil, err
+}
+
+// GET /api/patients/{patientUid}/episodes
+func listPatientEpisodesHandler(w http.ResponseWriter, r *http.Request) {
+	rest := strings.TrimPrefix(r.URL.Path, "/api/patients/")
+	patientUID, err := url.PathUnescape(strings.TrimSuffix(rest, "/episodes"))
+	if err != nil || patientUID == "" {
+		errorResponse(w, http.StatusBadRequest, "invalid patient uid")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	if _, ok := authorizePatientRecords(ctx, w, r, patientUID); !ok {
+		return
+	}
+
+	query := `SELECT ` + episodeColumns + ` FROM exam_episodes WHERE patient_uid = $1`
+	args := []any{patientUID}
+	if t := r.URL.Query().Get("examType"); t != "" {
+		query += " AND exam_type = $2"
+		args = append(args, t)
+	}
+	query += " ORDER BY exam_date, id"
+
+	rows, err := db.Query(ctx, query, args...)
+	if err != nil {
+		log.Printf("listPatientEpisodes error: %v", err)
+		errorResponse(w, http.StatusInternalServerError, "db error")
+		return
+	}
+	defer rows.Close()
+
+	res := []ExamEpisode{}
+	for rows.Next() {
+		e, err := scanEpisode(rows)
+		if err != nil {
+			log.Printf("scan episode: %v", err)
+			continue
+		}
+		res = append(res, *e)
+	}
+	jsonResponse(w, http.StatusOK, res)
+}
+
+// POST /api/episodes
+func createEpisodeHandler(w http.ResponseWriter, r *http.Request) {
+	var in ExamEpisode
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		errorResponse(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+	if in.ExamType == "" {
+		in.ExamType = ExamTypePeriodic
+	}
+	if !validExamType(in.ExamType) {
+		errorResponse(w, http.StatusBadRequest, "invalid examType")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	user, ok := requestUser(ctx, w, r)
+	if !ok {
+		return
+	}
+	// Эпизод заводится только в своей клинике: clinicId из запроса не принимается
+	clinicID := userClinicID(user)
+	if !isClinicStaff(user) || clinicID == "" {
+		errorResponse(w, http.StatusForbidden, "access denied")
+		return
+	}
+	if !authorizeNewEpisode(ctx, w, user, &in) {
+		return
+	}
+
+	var id int64
+	var err error
+	if in.VisitID != nil {
+		id, err = ensureEpisodeForVisit(ctx, db, *in.VisitID, in.ExamType)
+	} else {
+		examDate := in.ExamDate
+		if examDate == "" {
+			examDate = time.Now().Format("2006-01-02")
+		}
+		err = db.QueryRow(ctx, `
+INSERT INTO exam_episodes (patient_uid, contract_id, clinic_id, exam_type, exam_date)
+VALUES ($1, $2, $3, $4, $5) RETURNING id
+`, in.PatientUID, in.ContractID, clinicID, in.ExamType, examDate).Scan(&id)
+	}
+	if err != nil {
+		log.Printf("createEpisode error: %v", err)
+		errorResponse(w, http.StatusInternalServerError, "db error")
+		return
+	}
+
+	e, err := loadEpisode(ctx, db, id)
+	if err != nil {
+		errorResponse(w, http.StatusInternalServerError, "db error")
+		return
+	}
+	jsonResponse(w, http.StatusCreated, e)
+}
+
+// authorizeNewEpisode проверяет, что визит, пациент и договор нового эпизода относятся
+// к клинике сотрудника. Пациент без визита в клинике допускается только по её договору.
+func authorizeNewEpisode(ctx context.Context, w http.ResponseWriter, user *User, in *ExamEpisode) bool {
+	clinicID := userClinicID(user)
+	if in.VisitID != nil {
+		visitClinic, patientUID, err := records.VisitScope(ctx, *in.VisitID)
+		if errors.Is(err, pgx.ErrNoRows) {
+			errorResponse(w, http.StatusNotFound, "visit not found")
+			return false
+		}
+		if err != nil {
+			log.Printf("createEpisode: visit %d: %v", *in.VisitID, err)
+			errorResponse(w, http.StatusInternalServerError, "db error")
+			return false
+		}
+		if visitClinic != clinicID {
+			errorResponse(w, http.StatusForbidden, "visit belongs to another clinic")
+			return false
+		}
+		if in.PatientUID != "" && in.PatientUID != patientUID {
+			errorResponse(w, http.StatusBadRequest, "visit belongs to another patient")
+			return false
+		}
+		return true
+	}
+
+	if in.PatientUID == "" {
+		errorResponse(w, http.StatusBadRequest, "patientUid or visitId is required")
+		return false
+	}
+	if in.ContractID != nil {
+		parties, err := records.ContractParties(ctx, *in.ContractID)
+		if errors.Is(err, pgx.ErrNoRows) {
+			errorResponse(w, http.StatusNotFound, "contract not found")
+			return false
+		}
+		if err != nil {
+			log.Printf("createEpisode: contract %d: %v", *in.ContractID, err)
+			errorResponse(w, http.StatusInternalServerError, "db error")
+			return false
+		}
+		if !parties.isClinicSide(user) {
+			errorResponse(w, http.StatusForbidden, "contract belongs to another clinic")
+			return false
+		}
+		return true
+	}
+	known, err := records.PatientInClinic(ctx, in.PatientUID, clinicID)
+	if err != nil {
+		log.Printf("createEpisode: patient %s: %v", in.PatientUID, err)
+		errorResponse(w, http.StatusInternalServerError, "db error")
+		return false
+	}
+	if !known {
+		errorResponse(w, http.StatusForbidden, "patient is not examined by this clinic")
+		return false
+	}
+	return true
+}
+
+// GET /api/episodes/{id}
+func getEpisodeHandler(w http.ResponseWriter, r *http.Request, id int64) {
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	_, e, ok := authorizeEpisode(ctx, w, r, id, false)
+	if !ok {
+		return
+	}
+	jsonResponse(w, http.StatusOK, e)
+}
+
+// PATCH /api/episodes/{id} - записи специалистов и анализы конкретного эпизода
+func updateEpisodeHandler(w http.ResponseWriter, r *http.Request, id int64) {
+	var in struct {
+		Spec     json.RawMessage `json:"specialistEntries"`
+		Labs     json.RawMessage `json:"labResults"`
+		ExamType *string         `json:"examType"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		errorResponse(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+	if in.ExamType != nil && !validExamType(*in.ExamType) {
+		errorResponse(w, http.StatusBadRequest, "invalid examType")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	_, episode, ok := authorizeEpisode(ctx, w, r, id, true)
+	if !ok {
+		return
+	}
+
+	// Раздел, который сейчас правит коллега, не перезаписываем
+	if cardPresenceStore != nil {
+		sections, err := episodeSectionChanges(ctx, id, in.Spec, in.Labs)
+		if err != nil {
+			log.Printf("updateEpisode: changed sections error: %v", err)
+			errorResponse(w, http.StatusInternalServerError, "db error")
+			return
+		}
+		if !checkSectionLocks(ctx, w, r, cardPresenceStore, episode.PatientUID, sections) {
+			return
+		}
+	}
+
+	tag, err := db.Exec(ctx, `
+UPDATE exam_episodes SET
+  specialist_entries = COALESCE($2::jsonb, specialist_entries),
+  lab_results = COALESCE($3::jsonb, lab_results),
+  exam_type = COALESCE($4, exam_type),
+  updated_at = NOW()
+WHERE id = $1 AND NOT locked
+`, id, in.Spec, in.Labs, in.ExamType)
+	if err != nil {
+		log.Printf("updateEpisode error: %v", err)
+		errorResponse(w, http.StatusInternalServerError, "db error")
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		var locked bool
+		if err := db.QueryRow(ctx, `SELECT locked FROM exam_episodes WHERE id = $1`, id).Scan(&locked); err != nil {
+			errorResponse(w, http.StatusNotFound, "episode not found")
+			return
+		}
+		errorResponse(w, http.StatusLocked, "episode is locked by the final conclusion")
+		return
+	}
+
+	if in.Spec != nil || in.Labs != nil {
+		checkEmergencyNotifications(ctx, id)
+	}
+
+	e, err := loadEpisode(ctx, db, id)
+	if err != nil {
+		errorResponse(w, http.StatusInternalServerError, "db error")
+		return
+	}
+	broadcastToUser(e.PatientUID, "visit_updated", map[string]interface{}{
+		"employeeId": e.PatientUID,
+		"episodeId":  e.ID,
+	})
+	jsonResponse(w, http.StatusOK, e)
+}
+
+// episodeClinicID - клиника эпизода, а если она не записана - клиника визита или договора
+func episodeClinicID(ctx context.Context, q dbExecutor, e *ExamEpisode) (string, error) {
+	if e.ClinicID != nil && *e.ClinicID != "" {
+		return *e.ClinicID, nil
+	}
+	if e.VisitID != nil {
+		var clinicID string
+		err := q.QueryRow(ctx, `SELECT clinic_id FROM employee_visits WHERE id = $1`, *e.VisitID).Scan(&clinicID)
+		if err == nil && clinicID != "" {
+			return clinicID, nil
+		}
+		if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+			return "", err
+		}
+	}
+	if e.ContractID != nil {
+		p, err := loadContractParties(ctx, *e.ContractID)
+		if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+			return "", err
+		}
+		if p != nil {
+			return p.ClinicUserID, nil
+		}
+	}
+	return "", nil
+}
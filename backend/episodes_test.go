@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// fakeEpisodeDB отвечает на запросы currentEpisodeID/writableEpisodeID без базы
+type fakeEpisodeDB struct {
+	openVisit      int64 // 0 - открытого визита нет
+	visitEpisode   int64
+	latest         int64 // последний эпизод, в том числе подписанный
+	latestUnlocked int64
+	created        int64
+	inserts        int
+}
+
+// fakeRow - строка с заданными значениями колонок или ошибкой
+type fakeRow struct {
+	vals []any
+	err  error
+}
+
+func (r fakeRow) Scan(dest ...any) error {
+	if r.err != nil {
+		return r.err
+	}
+	return scanValues(r.vals, dest)
+}
+
+func scanValues(vals, dest []any) error {
+	if len(dest) != len(vals) {
+		return fmt.Errorf("scan %d columns into %d destinations", len(vals), len(dest))
+	}
+	for i, v := range vals {
+		reflect.ValueOf(dest[i]).Elem().Set(reflect.ValueOf(v))
+	}
+	return nil
+}
+
+func (f *fakeEpisodeDB) QueryRow(ctx context.Context, sql string, args ...any) pgx.Row {
+	row := func(id int64) pgx.Row {
+		if id == 0 {
+			return fakeRow{err: pgx.ErrNoRows}
+		}
+		return fakeRow{vals: []any{id}}
+	}
+	switch {
+	case strings.Contains(sql, "INSERT INTO exam_episodes (patient_uid, visit_id"):
+		f.inserts++
+		return row(f.visitEpisode)
+	case strings.Contains(sql, "INSERT INTO exam_episodes"):
+		f.inserts++
+		return row(f.created)
+	case strings.Contains(sql, "FROM employee_visits"):
+		return row(f.openVisit)
+	case strings.Contains(sql, "WHERE visit_id"):
+		return row(f.visitEpisode)
+	case strings.Contains(sql, "NOT locked"):
+		return row(f.latestUnlocked)
+	case strings.Contains(sql, "FROM exam_episodes WHERE patient_uid"):
+		return row(f.latest)
+	}
+	return fakeRow{err: errors.New("unexpected query: " + sql)}
+}
+
+func (f *fakeEpisodeDB) Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error) {
+	return pgconn.CommandTag{}, errors.New("unexpected exec")
+}
+
+func (f *fakeEpisodeDB) Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error) {
+	return nil, errors.New("unexpected query")
+}
+
+func TestWritableEpisodeIDSkipsSignedEpisodes(t *testing.T) {
+	ctx := context.Background()
+	cases := []struct {
+		name    string
+		db      fakeEpisodeDB
+		create  bool
+		want    int64
+		found   bool
+		inserts int
+	}{
+		{"open visit", fakeEpisodeDB{openVisit: 5, visitEpisode: 50, latest: 70, latestUnlocked: 60}, false, 50, true, 0},
+		{"open visit, create", fakeEpisodeDB{openVisit: 5, visitEpisode: 50}, true, 50, true, 1},
+		{"last unlocked", fakeEpisodeDB{latest: 70, latestUnlocked: 60}, false, 60, true, 0},
+		// Последний эпизод подписан: новый заводится, а не пишется в подписанный
+		{"only signed, create", fakeEpisodeDB{latest: 70, created: 80}, true, 80, true, 1},
+		{"only signed", fakeEpisodeDB{latest: 70}, false, 0, false, 0},
+		{"no episodes, create", fakeEpisodeDB{created: 80}, true, 80, true, 1},
+	}
+	for _, tc := range cases {
+		db := tc.db
+		id, found, err := writableEpisodeID(ctx, &db, "emp-a", "clinic-a", tc.create)
+		if err != nil || id != tc.want || found != tc.found || db.inserts != tc.inserts {
+			t.Errorf("%s: id=%d found=%v inserts=%d err=%v, want id=%d found=%v inserts=%d",
+				tc.name, id, found, db.inserts, err, tc.want, tc.found, tc.inserts)
+		}
+	}
+}
+
+// Для просмотра карты подписанный эпизод остаётся текущим
+func TestCurrentEpisodeIDShowsSignedEpisode(t *testing.T) {
+	ctx := context.Background()
+	db := fakeEpisodeDB{latest: 70, latestUnlocked: 60}
+	if id, found, err := currentEpisodeID(ctx, &db, "emp-a"); err != nil || !found || id != 70 {
+		t.Errorf("id=%d found=%v err=%v, want 70", id, found, err)
+	}
+	db = fakeEpisodeDB{openVisit: 5, visitEpisode: 50, latest: 70}
+	if id, _, _ := currentEpisodeID(ctx, &db, "emp-a"); id != 50 || db.inserts != 0 {
+		t.Errorf("id=%d inserts=%d, want the open visit episode without inserts", id, db.inserts)
+	}
+	db = fakeEpisodeDB{}
+	if _, found, err := currentEpisodeID(ctx, &db, "emp-a"); found || err != nil {
+		t.Errorf("found=%v err=%v for a patient without episodes", found, err)
+	}
+}
+
+// Эпизод заводится и карта заполняется только в своей клинике; отказ - до записи в базу
+func TestEpisodeWritesStayInClinic(t *testing.T) {
+	prev := records
+	records = memRecordStore{}
+	defer func() { records = prev }()
+
+	for _, tc := range []struct {
+		name string
+		user *User
+		body string
+		h    func(http.ResponseWriter, *http.Request)
+		want int
+	}{
+		{"anonymous episode", nil, `{"visitId":1}`, createEpisodeHandler, http.StatusUnauthorized},
+		{"employer episode", orgA, `{"visitId":1}`, createEpisodeHandler, http.StatusForbidden},
+		{"patient episode", employeA, `{"patientUid":"emp-a"}`, createEpisodeHandler, http.StatusForbidden},
+		{"foreign visit", doctorB, `{"visitId":1,"clinicId":"clinic-b"}`, createEpisodeHandler, http.StatusForbidden},
+		{"unknown visit", doctorA, `{"visitId":99}`, createEpisodeHandler, http.StatusNotFound},
+		{"visit of another patient", doctorA, `{"visitId":1,"patientUid":"emp-b"}`, createEpisodeHandler, http.StatusBadRequest},
+		{"patient of another clinic", doctorA, `{"patientUid":"emp-b","clinicId":"clinic-a"}`, createEpisodeHandler, http.StatusForbidden},
+		{"foreign contract", doctorA, `{"patientUid":"emp-x","contractId":2}`, createEpisodeHandler, http.StatusForbidden},
+
+		{"anonymous card", nil, `{"patientUid":"emp-a","episodeId":1}`, upsertAmbulatoryCardHandler, http.StatusUnauthorized},
+		{"employer card", orgA, `{"patientUid":"emp-a","episodeId":1}`, upsertAmbulatoryCardHandler, http.StatusForbidden},
+		{"patient card", employeA, `{"patientUid":"emp-a","episodeId":1}`, upsertAmbulatoryCardHandler, http.StatusForbidden},
+		{"foreign episode", doctorB, `{"patientUid":"emp-a","episodeId":1}`, upsertAmbulatoryCardHandler, http.StatusForbidden},
+		{"episode of another patient", doctorA, `{"patientUid":"emp-b","episodeId":1}`, upsertAmbulatoryCardHandler, http.StatusBadRequest},
+		{"card of another clinic's patient", doctorB, `{"patientUid":"emp-a"}`, upsertAmbulatoryCardHandler, http.StatusForbidden},
+	} {
+		if rec := callAs(t, tc.user, http.MethodPost, "/", tc.body, tc.h); rec.Code != tc.want {
+			t.Errorf("%s: status %d, want %d: %s", tc.name, rec.Code, tc.want, rec.Body)
+		}
+	}
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// --- Шина событий между репликами ---
+//
+// Hub живёт в процессе, поэтому при нескольких репликах событие, возникшее на одной,
+// должно дойти до клиентов, подключенных к другим. Все broadcastTo* идут через шину:
+// реплика записывает событие в журнал (eventlog.go), доставляет своим клиентам
+// и публикует через NOTIFY, остальные получают его через LISTEN и доставляют своим.
+// EVENT_BUS=local отключает межпроцессную доставку (одна реплика).
+// Тем же соединением слушаются каналы сброса кешей (cacheChannels) - при любом EVENT_BUS.
+
+// Адресаты события
+const (
+	busScopeAll   = "all"
+	busScopeUser  = "user"
+	busScopeUsers = "users"
+	busScopeRole  = "role"
+	busScopeTopic = "topic"
+)
+
+// busEvent - событие и его адресаты
+type busEvent struct {
+	Scope   string           `json:"scope"`
+	Keys    []string         `json:"keys,omitempty"` // пользователи, роль или тема
+	Message WebSocketMessage `json:"message"`
+}
+
+// eventBus доставляет событие клиентам всех реплик
+type eventBus interface {
+	Publish(ev busEvent)
+	// PublishLogged записывает событие в журнал до возврата и сообщает об ошибке записи
+	PublishLogged(ctx context.Context, ev busEvent) error
+}
+
+var bus eventBus
+
+func publishEvent(ev busEvent) {
+	if bus == nil {
+		deliverLocal(hub, ev)
+		return
+	}
+	bus.Publish(ev)
+}
+
+// publishLogged - для событий, отправку которых отмечают в базе: отметку ставят только
+// после записи в журнал, откуда событие получат и клиенты, бывшие не в сети
+func publishLogged(ctx context.Context, ev busEvent) error {
+	if bus == nil {
+		deliverLocal(hub, ev)
+		return nil
+	}
+	return bus.PublishLogged(ctx, ev)
+}
+
+// deliverLocal доставляет событие клиентам этой реплики
+func deliverLocal(h *Hub, ev busEvent) {
+	if h == nil {
+		return
+	}
+	switch ev.Scope {
+	case busScopeAll:
+		h.Broadcast(ev.Message)
+	case busScopeUser, busScopeUsers:
+		if len(ev.Keys) == 1 {
+			h.BroadcastToUser(ev.Keys[0], ev.Message)
+		} else {
+			h.BroadcastToUsers(ev.Keys, ev.Message)
+		}
+	case busScopeRole:
+		for _, role := range ev.Keys {
+			h.BroadcastToRole(UserRole(role), ev.Message)
+		}
+	case busScopeTopic:
+		h.PublishTopics(ev.Keys, ev.Message)
+	}
+}
+
+// --- Postgres LISTEN/NOTIFY ---
+
+const (
+	eventBusChannel = "ws_events"
+	// Полезная нагрузка NOTIFY ограничена 8000 байтами; крупные события
+	// передаём ссылкой на запись журнала
+	maxNotifyPayload = 7500
+	busQueueSize     = 4096
+)
+
+// listenPollInterval - как часто ожидание уведомления прерывается, чтобы проверить ctx
+var listenPollInterval = time.Minute
+
+// cacheChannel - канал NOTIFY со сбросами кеша. reset вызывается при каждом
+// подключении: сбросы, пришедшие за время обрыва, потеряны
+type cacheChannel struct {
+	name       string
+	invalidate func(payload string)
+	reset      func()
+}
+
+var cacheChannels = []cacheChannel{
+	{contractStatsChannel, receiveContractStatsInvalidation, statsCache.reset},
+}
+
+var errEventTooLarge = errors.New("event does not fit into NOTIFY and is not logged")
+
+// busEnvelope - то, что уходит в NOTIFY: само событие или ссылка на него в журнале
+type busEnvelope struct {
+	Origin string    `json:"origin"`
+	Event  *busEvent `json:"event,omitempty"`
+	Ref    int64     `json:"ref,omitempty"`
+}
+
+// pgEventBus записывает события в журнал и рассылает их в фоне, чтобы обработчики
+// не ждали базу. Порядок событий одной реплики сохраняется: отправитель один.
+type pgEventBus struct {
+	hub    *Hub
+	events eventLog
+	origin string
+	queue  chan busEvent
+	notify bool // false при EVENT_BUS=local: только журнал, без NOTIFY
+	// connect открывает соединение для LISTEN; по умолчанию - забранное из пула
+	connect func(ctx context.Context) (listenConn, error)
+}
+
+// listenConn - выделенное соединение для LISTEN (*pgx.Conn)
+type listenConn interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+	WaitForNotification(ctx context.Context) (*pgconn.Notification, error)
+	Close(ctx context.Context) error
+}
+
+// hijackListenConn забирает соединение из пула насовсем: после LISTEN его нельзя отдавать другим
+func hijackListenConn(ctx context.Context) (listenConn, error) {
+	conn, err := db.Acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return conn.Hijack(), nil
+}
+
+func newEventBusFromEnv(h *Hub, events eventLog) eventBus {
+	b := &pgEventBus{hub: h, events: events, origin: newBusOrigin(), queue: make(chan busEvent, busQueueSize),
+		connect: hijackListenConn}
+	b.notify = mustGetEnv("EVENT_BUS", "postgres") != "local"
+	go b.sendLoop()
+	go b.listenLoop()
+	if b.notify {
+		log.Printf("event bus: postgres LISTEN/NOTIFY, instance %s", b.origin)
+	}
+	return b
+}
+
+func newBusOrigin() string {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(buf)
+}
+
+func (b *pgEventBus) Publish(ev busEvent) {
+	select {
+	case b.queue <- ev:
+	default:
+		// Очередь переполнена - хотя бы свои клиенты получат событие (без id)
+		log.Printf("event bus: queue is full, %s event is delivered locally only", ev.Message.Type)
+		deliverLocal(b.hub, ev)
+	}
+}
+
+func (b *pgEventBus) PublishLogged(ctx context.Context, ev busEvent) error {
+	id, err := b.events.Append(ctx, ev)
+	if err != nil {
+		return fmt.Errorf("log %s: %w", ev.Message.Type, err)
+	}
+	ev.Message.ID = id
+	deliverLocal(b.hub, ev)
+	if b.notify {
+		// Другие реплики без NOTIFY доставят событие досылкой из журнала
+		if err := b.send(ctx, ev); err != nil {
+			log.Printf("event bus: publish %s: %v", ev.Message.Type, err)
+		}
+	}
+	return nil
+}
+
+func (b *pgEventBus) sendLoop() {
+	for ev := range b.queue {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		id, err := b.events.Append(ctx, ev)
+		if err != nil {
+			log.Printf("event bus: log %s: %v", ev.Message.Type, err)
+		}
+		ev.Message.ID = id
+		deliverLocal(b.hub, ev)
+		if b.notify {
+			if err := b.send(ctx, ev); err != nil {
+				log.Printf("event bus: publish %s: %v", ev.Message.Type, err)
+			}
+		}
+		cancel()
+	}
+}
+
+// encodeNotify возвращает полезную нагрузку NOTIFY: событие целиком или, если оно
+// не помещается, ссылку на запись журнала
+func encodeNotify(origin string, ev busEvent) ([]byte, error) {
+	payload, err := json.Marshal(busEnvelope{Origin: origin, Event: &ev})
+	if err != nil || len(payload) <= maxNotifyPayload {
+		return payload, err
+	}
+	if ev.Message.ID == 0 {
+		return nil, errEventTooLarge
+	}
+	return json.Marshal(busEnvelope{Origin: origin, Ref: ev.Message.ID})
+}
+
+func (b *pgEventBus) send(ctx context.Context, ev busEvent) error {
+	payload, err := encodeNotify(b.origin, ev)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(ctx, `SELECT pg_notify($1, $2)`, eventBusChannel, string(payload))
+	return err
+}
+
+// listenLoop держит отдельное соединение с LISTEN и переподключается при обрыве.
+// События других реплик, опубликованные во время обрыва, клиенты этой реплики
+// получат только досылкой из журнала при переподключении.
+func (b *pgEventBus) listenLoop() {
+	backoff := time.Second
+	for {
+		err := b.listen(context.Background())
+		log.Printf("event bus: listener stopped: %v; reconnecting in %s", err, backoff)
+		time.Sleep(backoff)
+		if backoff < 30*time.Second {
+			backoff *= 2
+		}
+	}
+}
+
+func (b *pgEventBus) listen(ctx context.Context) error {
+	conn, err := b.connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close(context.Background())
+
+	if _, err := conn.Exec(ctx, "LISTEN "+eventBusChannel); err != nil {
+		return err
+	}
+	for _, ch := range cacheChannels {
+		if _, err := conn.Exec(ctx, "LISTEN "+ch.name); err != nil {
+			return err
+		}
+		ch.reset()
+	}
+	for {
+		waitCtx, cancel := context.WithTimeout(ctx, listenPollInterval)
+		n, err := conn.WaitForNotification(waitCtx)
+		cancel()
+		if err != nil {
+			if waitCtx.Err() == context.DeadlineExceeded && ctx.Err() == nil {
+				continue
+			}
+			return err
+		}
+		if ch := findCacheChannel(n.Channel); ch != nil {
+			ch.invalidate(n.Payload)
+			continue
+		}
+		b.receive(n.Payload)
+	}
+}
+
+func findCacheChannel(name string) *cacheChannel {
+	for i := range cacheChannels {
+		if cacheChannels[i].name == name {
+			return &cacheChannels[i]
+		}
+	}
+	return nil
+}
+
+func (b *pgEventBus) receive(payload string) {
+	var env busEnvelope
+	if err := json.Unmarshal([]byte(payload), &env); err != nil {
+		log.Printf("event bus: bad notification: %v", err)
+		return
+	}
+	if env.Origin == b.origin {
+		return // уже доставлено своим клиентам при публикации
+	}
+	ev := env.Event
+	if env.Ref != 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		var err error
+		if ev, err = b.events.Load(ctx, env.Ref); err != nil {
+			log.Printf("event bus: load event %d: %v", env.Ref, err)
+			return
+		}
+	}
+	if ev != nil {
+		deliverLocal(b.hub, *ev)
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+func TestEncodeNotifyMovesLargeEventsOutOfBand(t *testing.T) {
+	small := busEvent{Scope: busScopeUser, Keys: []string{"u1"}, Message: newMessage("visit_updated", map[string]any{"visitId": 1})}
+	payload, err := encodeNotify("r1", small)
+	var env busEnvelope
+	if err != nil || json.Unmarshal(payload, &env) != nil || env.Event == nil || env.Ref != 0 {
+		t.Fatalf("small event must go inline: %s, %v", payload, err)
+	}
+
+	big := busEvent{Scope: busScopeTopic, Keys: []string{"clinic:c1"}, Message: newMessage("report", strings.Repeat("я", maxNotifyPayload))}
+	if _, err := encodeNotify("r1", big); !errors.Is(err, errEventTooLarge) {
+		t.Fatalf("unlogged large event: want errEventTooLarge, got %v", err)
+	}
+	big.Message.ID = 42
+	payload, err = encodeNotify("r1", big)
+	env = busEnvelope{}
+	if err != nil || len(payload) > maxNotifyPayload || json.Unmarshal(payload, &env) != nil || env.Event != nil || env.Ref != 42 {
+		t.Fatalf("large event must be sent as a log reference: %s, %v", payload, err)
+	}
+}
+
+// Уведомление от другой реплики доставляется местным клиентам, своё - пропускается
+func TestEventBusDeliversRemoteEventsOnce(t *testing.T) {
+	h := NewHub()
+	b := &pgEventBus{hub: h, origin: "replica-a"}
+	doctor := newTestClient(h, "d", "doc-1", UserRoleDoctor, "clinic-1", 8)
+	other := newTestClient(h, "o", "doc-2", UserRoleDoctor, "clinic-2", 8)
+	h.Register(doctor)
+	h.Register(other)
+
+	ev := busEvent{Scope: busScopeTopic, Keys: []string{clinicTopic("clinic-1")}, Message: newMessage("visit_started", nil)}
+	remote, _ := encodeNotify("replica-b", ev)
+	own, _ := encodeNotify("replica-a", ev)
+	b.receive(string(remote))
+	b.receive(string(own))
+	b.receive("not json")
+
+	if got := received(doctor); len(got) != 1 || got[0].Type != "visit_started" {
+		t.Errorf("clinic-1 doctor: want one remote event, got %v", got)
+	}
+	if got := received(other); len(got) != 0 {
+		t.Errorf("clinic-2 doctor must not receive clinic-1 events, got %v", got)
+	}
+}
+
+// fakeListenConn отдаёт заготовленные уведомления; nil - тишина до таймаута ожидания
+type fakeListenConn struct {
+	execs         []string
+	notifications []*pgconn.Notification
+	closed        bool
+}
+
+var errConnLost = errors.New("connection lost")
+
+func (c *fakeListenConn) Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error) {
+	if c.closed {
+		return pgconn.CommandTag{}, errors.New("exec on closed connection")
+	}
+	c.execs = append(c.execs, sql)
+	return pgconn.CommandTag{}, nil
+}
+
+func (c *fakeListenConn) WaitForNotification(ctx context.Context) (*pgconn.Notification, error) {
+	if len(c.execs) == 0 {
+		return nil, errors.New("waiting before LISTEN")
+	}
+	if len(c.notifications) == 0 {
+		return nil, errConnLost
+	}
+	n := c.notifications[0]
+	c.notifications = c.notifications[1:]
+	if n == nil {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	return n, nil
+}
+
+func (c *fakeListenConn) Close(ctx context.Context) error {
+	c.closed = true
+	return nil
+}
+
+func TestListenDeliversNotificationsUntilConnectionIsLost(t *testing.T) {
+	defer func(d time.Duration) { listenPollInterval = d }(listenPollInterval)
+	listenPollInterval = 10 * time.Millisecond
+
+	h := NewHub()
+	doctor := newTestClient(h, "d", "doc-1", UserRoleDoctor, "clinic-1", 8)
+	h.Register(doctor)
+	ev := busEvent{Scope: busScopeTopic, Keys: []string{clinicTopic("clinic-1")}, Message: newMessage("visit_started", nil)}
+	remote, _ := encodeNotify("replica-b", ev)
+	event := &pgconn.Notification{Channel: eventBusChannel, Payload: string(remote)}
+
+	conn := &fakeListenConn{notifications: []*pgconn.Notification{nil, event, nil, event}}
+	b := &pgEventBus{hub: h, origin: "replica-a", connect: func(context.Context) (listenConn, error) { return conn, nil }}
+	if err := b.listen(context.Background()); !errors.Is(err, errConnLost) {
+		t.Fatalf("listen must return the connection error, got %v", err)
+	}
+	if len(conn.execs) != 2 || conn.execs[0] != "LISTEN "+eventBusChannel || conn.execs[1] != "LISTEN "+contractStatsChannel {
+		t.Errorf("execs = %v", conn.execs)
+	}
+	if !conn.closed {
+		t.Error("listener connection must be closed on exit")
+	}
+	// Таймаут ожидания - не обрыв: оба уведомления доставлены
+	if got := received(doctor); len(got) != 2 {
+		t.Errorf("want 2 remote events, got %v", got)
+	}
+
+	failing := &pgEventBus{hub: h, connect: func(context.Context) (listenConn, error) { return nil, errConnLost }}
+	if err := failing.listen(context.Background()); !errors.Is(err, errConnLost) {
+		t.Errorf("connect error: got %v", err)
+	}
+}
+
+// Сбросы кеша приходят отдельным каналом и клиентам не рассылаются; при подключении
+// кеш сбрасывается целиком - сбросы за время обрыва потеряны
+func TestListenResetsCachesOnConnect(t *testing.T) {
+	now := time.Now()
+	_, gen, _ := statsCache.get(7, now)
+	statsCache.put(7, gen, &ContractStats{ContractID: 7}, now)
+	_, inflight, _ := statsCache.get(8, now) // расчёт начат до переподключения
+
+	h := NewHub()
+	doctor := newTestClient(h, "d", "doc-1", UserRoleDoctor, "clinic-1", 8)
+	h.Register(doctor)
+	conn := &fakeListenConn{notifications: []*pgconn.Notification{{Channel: contractStatsChannel, Payload: "9"}}}
+	b := &pgEventBus{hub: h, origin: "replica-a", connect: func(context.Context) (listenConn, error) { return conn, nil }}
+	b.listen(context.Background())
+
+	if _, _, ok := statsCache.get(7, now); ok {
+		t.Error("cache must be reset on connect")
+	}
+	statsCache.put(8, inflight, &ContractStats{ContractID: 8}, now)
+	if _, _, ok := statsCache.get(8, now); ok {
+		t.Error("stats computed before reconnect must not be cached")
+	}
+	if msgs := received(doctor); len(msgs) != 0 {
+		t.Errorf("cache invalidation reached clients: %v", msgs)
+	}
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// --- Журнал событий и досылка после переподключения ---
+//
+// Каждое событие получает id из общего счётчика, поэтому в любом потоке
+// адресатов (пользователь, роль, тема) id растут. Счётчик - строка ws_event_seq,
+// заблокированная до фиксации вставки: события разных реплик фиксируются строго
+// в порядке id, и клиент, видевший событие N, не пропустит зафиксированное позже N-1.
+// Клиент запоминает id последнего события и при переподключении передаёт его: /ws?lastEventId=N или
+// {"type":"subscribe","topic":"visit:42","lastEventId":N}. Пропущенное приходит одним
+// сообщением replay; если журнал уже очищен или пропущено больше replayLimit -
+// приходит resync_required, и клиент перечитывает данные через REST.
+// Доставка "хотя бы один раз": событие может прийти и в replay, и отдельно, клиент
+// отбрасывает повторы по id.
+
+const (
+	replayLimit            = 500
+	defaultEventRetentionH = 24
+)
+
+var errResyncRequired = errors.New("resync required")
+
+// eventLog - журнал событий
+type eventLog interface {
+	// Append сохраняет событие и возвращает его id
+	Append(ctx context.Context, ev busEvent) (int64, error)
+	Load(ctx context.Context, id int64) (*busEvent, error)
+	// Since - события потоков после after по возрастанию id, не больше limit;
+	// errResyncRequired, если часть из них уже удалена или их больше limit
+	Since(ctx context.Context, streams []string, after int64, limit int) ([]WebSocketMessage, error)
+	// Ack отмечает события как полученные пользователем; отмечаются только события
+	// потоков streams, которые получает клиент. Возвращает число отмеченных
+	Ack(ctx context.Context, userID string, streams []string, ids []int64) (int, error)
+}
+
+// streams - потоки, в которые попадает событие
+func (ev busEvent) streams() []string {
+	switch ev.Scope {
+	case busScopeAll:
+		return []string{"all"}
+	case busScopeUser, busScopeUsers:
+		out := make([]string, 0, len(ev.Keys))
+		for _, id := range ev.Keys {
+			out = append(out, "user:"+id)
+		}
+		return out
+	case busScopeRole:
+		out := make([]string, 0, len(ev.Keys))
+		for _, role := range ev.Keys {
+			out = append(out, "role:"+role)
+		}
+		return out
+	}
+	return ev.Keys
+}
+
+// streams - потоки, которые получает клиент: общий, свой, своей роли и подписанные темы
+func (h *Hub) streams(c *Client) []string {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	out := []string{"all", "user:" + c.UserID, "role:" + string(c.Role)}
+	for topic := range c.topics {
+		out = append(out, topic)
+	}
+	return out
+}
+
+// replayEvents досылает клиенту события потоков после lastEventID
+func replayEvents(ctx context.Context, events eventLog, c *Client, streams []string, lastEventID int64) {
+	if events == nil || lastEventID <= 0 {
+		return
+	}
+	msgs, err := events.Since(ctx, streams, lastEventID, replayLimit)
+	if errors.Is(err, errResyncRequired) {
+		c.Hub.SendTo(c, newMessage("resync_required", map[string]any{"lastEventId": lastEventID, "streams": streams}))
+		return
+	}
+	if err != nil {
+		log.Printf("WebSocket replay for %s after %d: %v", c.UserID, lastEventID, err)
+		c.Hub.SendTo(c, newMessage("resync_required", map[string]any{"lastEventId": lastEventID, "streams": streams}))
+		return
+	}
+	if len(msgs) == 0 {
+		return
+	}
+	c.Hub.SendTo(c, newMessage("replay", map[string]any{"events": msgs, "lastEventId": msgs[len(msgs)-1].ID}))
+}
+
+func parseLastEventID(s string) int64 {
+	id, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || id < 0 {
+		return 0
+	}
+	return id
+}
+
+// --- Хранение в Postgres ---
+
+func migrateEventLog(ctx context.Context, tx pgx.Tx) error {
+	_, err := tx.Exec(ctx, `
+CREATE TABLE IF NOT EXISTS ws_events (
+  id         BIGSERIAL PRIMARY KEY,
+  streams    TEXT[] NOT NULL,
+  scope      TEXT NOT NULL,
+  keys       TEXT[] NOT NULL DEFAULT '{}',
+  message    JSONB NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);`)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(ctx, `CREATE INDEX IF NOT EXISTS idx_ws_events_streams ON ws_events USING GIN (streams);`)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(ctx, `CREATE INDEX IF NOT EXISTS idx_ws_events_created ON ws_events(created_at);`)
+	if err != nil {
+		return err
+	}
+	// pruned_through - наибольший удалённый id: досылка после него невозможна
+	_, err = tx.Exec(ctx, `
+CREATE TABLE IF NOT EXISTS ws_event_retention (
+  id             INT PRIMARY KEY CHECK (id = 1),
+  pruned_through BIGINT NOT NULL DEFAULT 0
+);`)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(ctx, `INSERT INTO ws_event_retention (id) VALUES (1) ON CONFLICT DO NOTHING;`)
+	if err != nil {
+		return err
+	}
+	// Подтверждения получения (ack от клиента); удаляются вместе с событием
+	_, err = tx.Exec(ctx, `
+CREATE TABLE IF NOT EXISTS ws_event_acks (
+  event_id  BIGINT NOT NULL REFERENCES ws_events(id) ON DELETE CASCADE,
+  user_id   TEXT NOT NULL,
+  acked_at  TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  PRIMARY KEY (event_id, user_id)
+);`)
+	if err != nil {
+		return err
+	}
+	// Счётчик id; на существующей базе продолжает последовательность ws_events
+	_, err = tx.Exec(ctx, `
+CREATE TABLE IF NOT EXISTS ws_event_seq (
+  id      INT PRIMARY KEY CHECK (id = 1),
+  last_id BIGINT NOT NULL
+);`)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(ctx, `INSERT INTO ws_event_seq (id, last_id) SELECT 1, COALESCE(MAX(id), 0) FROM ws_events ON CONFLICT DO NOTHING;`)
+	if err != nil {
+		return err
+	}
+	// ws_event_payloads хранила тела крупных NOTIFY до появления журнала; теперь NOTIFY
+	// несёт ссылку на ws_events, а таблица остаётся только на базах, где уже успела появиться
+	_, err = tx.Exec(ctx, `DROP TABLE IF EXISTS ws_event_payloads;`)
+	return err
+}
+
+type pgEventLog struct{}
+
+func (pgEventLog) Append(ctx context.Context, ev busEvent) (int64, error) {
+	msg, err := json.Marshal(ev.Message)
+	if err != nil {
+		return 0, err
+	}
+	keys := ev.Keys
+	if keys == nil {
+		keys = []string{}
+	}
+	// id выдаётся под блокировкой строки счётчика и освобождается при фиксации вставки
+	var id int64
+	err = db.QueryRow(ctx, `
+WITH next AS (
+  UPDATE ws_event_seq SET last_id = last_id + 1 WHERE id = 1 RETURNING last_id
+)
+INSERT INTO ws_events (id, streams, scope, keys, message)
+SELECT last_id, $1, $2, $3, $4 FROM next
+RETURNING id
+`, ev.streams(), ev.Scope, keys, msg).Scan(&id)
+	return id, err
+}
+
+func (pgEventLog) Load(ctx context.Context, id int64) (*busEvent, error) {
+	var ev busEvent
+	var msg []byte
+	if err := db.QueryRow(ctx, `SELECT scope, keys, message FROM ws_events WHERE id = $1`, id).Scan(&ev.Scope, &ev.Keys, &msg); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(msg, &ev.Message); err != nil {
+		return nil, err
+	}
+	ev.Message.ID = id
+	return &ev, nil
+}
+
+func (pgEventLog) Since(ctx context.Context, streams []string, after int64, limit int) ([]WebSocketMessage, error) {
+	var prunedThrough int64
+	if err := db.QueryRow(ctx, `SELECT pruned_through FROM ws_event_retention WHERE id = 1`).Scan(&prunedThrough); err != nil {
+		return nil, err
+	}
+	if after < prunedThrough {
+		return nil, errResyncRequired
+	}
+
+	rows, err := db.Query(ctx, `
+SELECT id, message FROM ws_events WHERE id > $1 AND streams && $2 ORDER BY id LIMIT $3
+`, after, streams, limit+1)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []WebSocketMessage
+	for rows.Next() {
+		var id int64
+		var raw []byte
+		if err := rows.Scan(&id, &raw); err != nil {
+			return nil, err
+		}
+		var m WebSocketMessage
+		if err := json.Unmarshal(raw, &m); err != nil {
+			return nil, err
+		}
+		m.ID = id
+		out = append(out, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(out) > limit {
+		return nil, errResyncRequired
+	}
+	return out, nil
+}
+
+// Ack отмечает только события, которые ещё есть в журнале и адресованы клиенту;
+// повторное подтверждение ничего не меняет
+func (pgEventLog) Ack(ctx context.Context, userID string, streams []string, ids []int64) (int, error) {
+	tag, err := db.Exec(ctx, `
+INSERT INTO ws_event_acks (event_id, user_id)
+SELECT id, $1 FROM ws_events WHERE id = ANY($2) AND streams && $3
+ON CONFLICT DO NOTHING
+`, userID, ids, streams)
+	if err != nil {
+		return 0, err
+	}
+	return int(tag.RowsAffected()), nil
+}
+
+// pruneEventLog удаляет события старше срока хранения и сдвигает границу досылки
+func pruneEventLog(ctx context.Context, retention time.Duration) error {
+	_, err := db.Exec(ctx, `
+WITH deleted AS (
+  DELETE FROM ws_events WHERE created_at < $1 RETURNING id
+)
+UPDATE ws_event_retention
+SET pruned_through = GREATEST(pruned_through, (SELECT COALESCE(MAX(id), 0) FROM deleted))
+WHERE id = 1
+`, time.Now().Add(-retention))
+	return err
+}
+
+// startEventLogPruner раз в час чистит журнал; срок хранения - EVENT_LOG_RETENTION_HOURS
+func startEventLogPruner() {
+	hours, err := strconv.Atoi(mustGetEnv("EVENT_LOG_RETENTION_HOURS", strconv.Itoa(defaultEventRetentionH)))
+	if err != nil || hours <= 0 {
+		hours = defaultEventRetentionH
+	}
+	retention := time.Duration(hours) * time.Hour
+	go func() {
+		ticker := time.NewTicker(time.Hour)
+		defer ticker.Stop()
+		for {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+			if err := pruneEventLog(ctx, retention); err != nil {
+				log.Printf("event log prune: %v", err)
+			}
+			cancel()
+			<-ticker.C
+		}
+	}()
+}
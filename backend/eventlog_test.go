@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memEventLog - журнал в памяти с той же семантикой, что и ws_events
+type memEventLog struct {
+	mu            sync.Mutex
+	events        []busEvent
+	prunedThrough int64
+	acks          map[string]bool
+}
+
+func (l *memEventLog) Append(ctx context.Context, ev busEvent) (int64, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	ev.Message.ID = l.prunedThrough + int64(len(l.events)) + 1
+	l.events = append(l.events, ev)
+	return ev.Message.ID, nil
+}
+
+func (l *memEventLog) Load(ctx context.Context, id int64) (*busEvent, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	ev := l.events[id-l.prunedThrough-1]
+	return &ev, nil
+}
+
+func (l *memEventLog) Since(ctx context.Context, streams []string, after int64, limit int) ([]WebSocketMessage, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if after < l.prunedThrough {
+		return nil, errResyncRequired
+	}
+	want := map[string]bool{}
+	for _, s := range streams {
+		want[s] = true
+	}
+	var out []WebSocketMessage
+	for _, ev := range l.events {
+		if ev.Message.ID <= after {
+			continue
+		}
+		for _, s := range ev.streams() {
+			if want[s] {
+				out = append(out, ev.Message)
+				break
+			}
+		}
+	}
+	if len(out) > limit {
+		return nil, errResyncRequired
+	}
+	return out, nil
+}
+
+func (l *memEventLog) Ack(ctx context.Context, userID string, streams []string, ids []int64) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.acks == nil {
+		l.acks = map[string]bool{}
+	}
+	want := map[string]bool{}
+	for _, s := range streams {
+		want[s] = true
+	}
+	addressed := func(id int64) bool {
+		for _, s := range l.events[id-l.prunedThrough-1].streams() {
+			if want[s] {
+				return true
+			}
+		}
+		return false
+	}
+	n := 0
+	for _, id := range ids {
+		key := fmt.Sprintf("%d/%s", id, userID)
+		if id > l.prunedThrough && id <= l.prunedThrough+int64(len(l.events)) && addressed(id) && !l.acks[key] {
+			l.acks[key] = true
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (l *memEventLog) prune(n int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.prunedThrough += int64(n)
+	l.events = l.events[n:]
+}
+
+// newLoggedBus - шина одной реплики (без NOTIFY) поверх журнала в памяти
+func newLoggedBus(t *testing.T, h *Hub, events eventLog) *pgEventBus {
+	t.Helper()
+	b := &pgEventBus{hub: h, events: events, origin: "test", queue: make(chan busEvent, 64)}
+	go b.sendLoop()
+	t.Cleanup(func() { close(b.queue) })
+	return b
+}
+
+// publishAndWait публикует событие и ждёт, пока шина его обработает
+func publishAndWait(t *testing.T, b *pgEventBus, events *memEventLog, ev busEvent) {
+	t.Helper()
+	events.mu.Lock()
+	before := len(events.events)
+	events.mu.Unlock()
+	b.Publish(ev)
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		events.mu.Lock()
+		n := len(events.events)
+		events.mu.Unlock()
+		if n > before {
+			time.Sleep(5 * time.Millisecond) // доставка идёт сразу после записи
+			return
+		}
+	}
+	t.Fatal("event was not logged")
+}
+
+func clinicEvent(clinicID, typ string) busEvent {
+	return busEvent{Scope: busScopeTopic, Keys: []string{clinicTopic(clinicID)}, Message: newMessage(typ, map[string]any{"clinicId": clinicID})}
+}
+
+func TestReplayAfterReconnectDeliversMissedEvents(t *testing.T) {
+	h := NewHub()
+	events := &memEventLog{}
+	b := newLoggedBus(t, h, events)
+
+	registrar := connectTestUser(h, regA)
+	publishAndWait(t, b, events, clinicEvent("clinic-a", "visit_started"))
+	got := received(registrar)
+	if len(got) != 1 || got[0].ID == 0 {
+		t.Fatalf("live event must carry an id, got %v", got)
+	}
+	lastEventID := got[0].ID
+
+	// Wi-Fi пропал: пока клиента нет, события продолжают идти
+	h.Unregister(registrar)
+	publishAndWait(t, b, events, clinicEvent("clinic-a", "visit_updated"))
+	publishAndWait(t, b, events, clinicEvent("clinic-b", "visit_started")) // чужая клиника
+	publishAndWait(t, b, events, busEvent{Scope: busScopeUser, Keys: []string{regA.ID}, Message: newMessage("doctor_updated", nil)})
+	publishAndWait(t, b, events, clinicEvent("clinic-a", "visit_updated"))
+
+	back := connectTestUser(h, regA)
+	replayEvents(context.Background(), events, back, h.streams(back), lastEventID)
+	msgs := received(back)
+	if len(msgs) != 1 || msgs[0].Type != "replay" {
+		t.Fatalf("want a single replay message, got %v", msgs)
+	}
+	data := msgs[0].Data.(map[string]any)
+	missed := data["events"].([]WebSocketMessage)
+	var types []string
+	prev := lastEventID
+	for _, m := range missed {
+		if m.ID <= prev {
+			t.Errorf("replayed ids must grow: %d after %d", m.ID, prev)
+		}
+		prev = m.ID
+		types = append(types, m.Type)
+	}
+	if len(types) != 3 || types[0] != "visit_updated" || types[1] != "doctor_updated" || types[2] != "visit_updated" {
+		t.Errorf("replayed %v, want the clinic-a and personal events only", types)
+	}
+	if data["lastEventId"] != prev {
+		t.Errorf("lastEventId = %v, want %d", data["lastEventId"], prev)
+	}
+
+	// Нечего досылать - ничего не приходит
+	replayEvents(context.Background(), events, back, h.streams(back), prev)
+	if msgs := received(back); len(msgs) != 0 {
+		t.Errorf("nothing missed, got %v", msgs)
+	}
+}
+
+func TestReplayRequiresResyncWhenGapIsTooLarge(t *testing.T) {
+	h := NewHub()
+	events := &memEventLog{}
+	for i := 0; i < 10; i++ {
+		events.Append(context.Background(), clinicEvent("clinic-a", "visit_updated"))
+	}
+	c := connectTestUser(h, clinicA)
+
+	// Часть пропущенного уже удалена из журнала
+	events.prune(5)
+	replayEvents(context.Background(), events, c, h.streams(c), 3)
+	if msgs := received(c); len(msgs) != 1 || msgs[0].Type != "resync_required" {
+		t.Fatalf("pruned gap: want resync_required, got %v", msgs)
+	}
+
+	// Пропущено больше, чем досылается за раз
+	for i := 0; i < replayLimit; i++ {
+		events.Append(context.Background(), clinicEvent("clinic-a", "visit_updated"))
+	}
+	replayEvents(context.Background(), events, c, h.streams(c), 6)
+	if msgs := received(c); len(msgs) != 1 || msgs[0].Type != "resync_required" {
+		t.Fatalf("too many missed events: want resync_required, got %v", msgs)
+	}
+}
+
+// PublishLogged возвращается, когда событие уже в журнале, и сообщает об ошибке записи
+func TestPublishLoggedWritesBeforeReturn(t *testing.T) {
+	events := &memEventLog{}
+	b := &pgEventBus{hub: NewHub(), events: events, origin: "test"}
+	if err := b.PublishLogged(context.Background(), clinicEvent("clinic-a", "exam_recall_due")); err != nil {
+		t.Fatal(err)
+	}
+	msgs, err := events.Since(context.Background(), []string{clinicTopic("clinic-a")}, 0, 10)
+	if err != nil || len(msgs) != 1 || msgs[0].ID != 1 || msgs[0].Type != "exam_recall_due" {
+		t.Fatalf("logged = %+v, %v", msgs, err)
+	}
+
+	b.events = &failingEventLog{}
+	if err := b.PublishLogged(context.Background(), clinicEvent("clinic-a", "exam_recall_due")); err == nil {
+		t.Error("log failure is not reported")
+	}
+}
+
+type failingEventLog struct{ memEventLog }
+
+func (*failingEventLog) Append(ctx context.Context, ev busEvent) (int64, error) {
+	return 0, errors.New("db is down")
+}
@@ -0,0 +1,595 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Срок следующего периодического осмотра. Периодичность выводится из вредных факторов
+// работника и целевой группы по приказу ҚР ДСМ-131/2020; срок хранится по работнику
+// организации и обновляется при каждом подписании заключения. Ежедневная задача
+// сообщает организации и клинике о работниках, которым осмотр предстоит в ближайшие дни -
+// из этого списка собирается контингент следующего договора.
+
+const (
+	// п. 11 Правил: ежегодный периодический осмотр - 1 раз в год
+	annualExamMonths = 12
+
+	RecallSourceComputed   = "computed"   // по периодичности от даты осмотра
+	RecallSourceConclusion = "conclusion" // дату указала комиссия в заключении
+)
+
+// Ссылки на пункты Перечня в строке вредных факторов: «п. 33 Шум, п. 1 Азот...»
+var factorRefPattern = regexp.MustCompile(`п\.\s*(\d+)`)
+
+// decreedGroupNone - работник явно не входит ни в одну целевую группу Приложения 1
+const decreedGroupNone = "none"
+
+// Целевые группы Приложения 1 с осмотром чаще раза в год. Группа берётся из кода
+// decreedGroup строки контингента; для строк без кода - по словам должности и участка
+// (ключ совпадает с началом слова); except - кто в группе осматривается по общему сроку.
+var decreedGroups = []struct {
+	Row      string
+	Title    string
+	Keywords []string
+	Except   []string
+	Months   int
+}{
+	{"1", "работники объектов общественного питания",
+		[]string{"повар", "официант", "бармен", "буфет", "кухон", "посудомо", "общественного питания"}, nil, 6},
+	{"3", "работники кремово-кондитерских производств и детских молочных кухонь",
+		[]string{"кондитер", "молочной кухни", "молочная кухня"}, nil, 6},
+	{"7", "работники сезонных детских и подростковых оздоровительных организаций",
+		[]string{"вожат", "оздоровительн лагер", "детский лагерь", "детского лагеря"}, nil, 6},
+	{"8", "работники дошкольных организаций, школ-интернатов, детских домов",
+		[]string{"воспитател", "няня", "детский сад", "детского сада", "дошкольн", "школа-интернат", "детский дом"}, nil, 6},
+	{"10", "медицинские работники хирургического, гинекологического, акушерского, стоматологического профилей, лабораторий, гемодиализа",
+		[]string{"хирург", "гинеколог", "акушер", "стоматолог", "гематолог", "гемодиализ", "операционн", "лаборант"},
+		[]string{"санитар", "младш"}, 6},
+	{"12", "работники сферы обслуживания: бани, сауны, парикмахерские, косметологические салоны, прачечные, бассейны",
+		[]string{"парикмахер", "косметолог", "маникюр", "педикюр", "банщик", "сауна", "прачечн", "химчистк", "бассейн", "массажист"}, nil, 6},
+}
+
+// examPeriodicity - периодичность осмотра работника и её основание
+func examPeriodicity(e contractEmployee) (int, string) {
+	months := annualExamMonths
+	var basis []string
+
+	// Пункты Перечня: из кодов строки, для старых договоров - ссылки «п. N» в тексте
+	items := e.FactorCodes
+	if len(items) == 0 {
+		for _, m := range factorRefPattern.FindAllStringSubmatch(e.HarmfulFactor, -1) {
+			items = append(items, m[1])
+		}
+	}
+	switch {
+	case len(items) > 0:
+		basis = append(basis, "вредные факторы (Приложение 4, п. "+strings.Join(items, ", ")+"): 1 раз в год")
+	case strings.TrimSpace(e.HarmfulFactor) != "":
+		basis = append(basis, "вредные факторы: 1 раз в год")
+	default:
+		basis = append(basis, "ежегодный периодический осмотр (п. 11 Правил)")
+	}
+
+	for _, g := range decreedGroups {
+		var note string
+		switch {
+		case e.DecreedGroup != "":
+			if e.DecreedGroup != g.Row {
+				continue
+			}
+		case matchesDecreedGroup(e.Position+" "+e.Site, g.Keywords, g.Except):
+			note = ", по должности"
+		default:
+			continue
+		}
+		if g.Months < months {
+			months = g.Months
+		}
+		basis = append(basis, fmt.Sprintf("Приложение 1, п. %s (%s%s): через каждые %d мес.", g.Row, g.Title, note, g.Months))
+	}
+	return months, strings.Join(basis, "; ")
+}
+
+// matchesDecreedGroup - в тексте есть ключ группы и нет исключения. Ключ из нескольких
+// слов совпадает с идущими подряд словами текста, каждое слово ключа - с началом слова
+// текста: «повар» находит «повар-кондитер», но «операционн» не находит «кооперационный».
+func matchesDecreedGroup(text string, keywords, except []string) bool {
+	words := decreedWords(text)
+	containsAny := func(keys []string) bool {
+		for _, k := range keys {
+			kw := decreedWords(k)
+			for i := 0; i+len(kw) <= len(words); i++ {
+				ok := true
+				for j, w := range kw {
+					if !strings.HasPrefix(words[i+j], w) {
+						ok = false
+						break
+					}
+				}
+				if ok {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	return containsAny(keywords) && !containsAny(except)
+}
+
+func decreedWords(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(strings.ReplaceAll(s, "ё", "е")), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// parseExamDate принимает даты заключения в виде 2026-03-01 или 01.03.2026
+func parseExamDate(s string) (time.Time, bool) {
+	s = strings.TrimSpace(s)
+	for _, layout := range []string{"2006-01-02", "02.01.2006"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, true
+		}
+	}
+	if len(s) > 10 {
+		if t, err := time.Parse("2006-01-02", s[:10]); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// examRecall - срок следующего осмотра работника организации
+type examRecall struct {
+	PatientUID        string
+	ClientBIN         string
+	ClientName        string
+	ClinicID          string
+	ContractID        int64
+	EpisodeID         int64
+	Employee          map[string]any // строка контингента для следующего договора
+	PeriodicityMonths int
+	Basis             string
+	LastExamDate      time.Time
+	NextDue           time.Time
+	Source            string
+}
+
+// planExamRecall считает срок по заключению: дата, указанная комиссией, важнее расчётной
+func planExamRecall(emp contractEmployee, raw map[string]any, final map[string]any, examDate time.Time) examRecall {
+	months, basis := examPeriodicity(emp)
+	rec := examRecall{
+		Employee:          raw,
+		PeriodicityMonths: months,
+		Basis:             basis,
+		LastExamDate:      examDate,
+		NextDue:           addMonths(examDate, months),
+		Source:            RecallSourceComputed,
+	}
+	if d, ok := parseExamDate(cardText(final, "nextExamDate")); ok && d.After(examDate) {
+		rec.NextDue, rec.Source = d, RecallSourceConclusion
+	}
+	return rec
+}
+
+func migrateExamRecalls(ctx context.Context, tx pgx.Tx) error {
+	_, err := tx.Exec(ctx, `
+CREATE TABLE IF NOT EXISTS exam_recalls (
+  id                 SERIAL PRIMARY KEY,
+  patient_uid        TEXT NOT NULL,
+  client_bin         TEXT NOT NULL,
+  client_name        TEXT NOT NULL DEFAULT '',
+  clinic_id          TEXT,
+  contract_id        INTEGER REFERENCES contracts(id) ON DELETE SET NULL,
+  episode_id         INTEGER REFERENCES exam_episodes(id) ON DELETE SET NULL,
+  employee           JSONB NOT NULL DEFAULT '{}',
+  periodicity_months INTEGER NOT NULL,
+  basis              TEXT NOT NULL DEFAULT '',
+  last_exam_date     DATE NOT NULL,
+  next_due           DATE NOT NULL,
+  source             TEXT NOT NULL DEFAULT 'computed',
+  notified_at        TIMESTAMPTZ,
+  updated_at         TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  UNIQUE(client_bin, patient_uid),
+  CONSTRAINT valid_recall_source CHECK (source IN ('computed', 'conclusion'))
+);
+`)
+	if err != nil {
+		return fmt.Errorf("migrate exam_recalls: %w", err)
+	}
+	_, err = tx.Exec(ctx, `CREATE INDEX IF NOT EXISTS idx_exam_recalls_due ON exam_recalls(next_due);`)
+	if err != nil {
+		return fmt.Errorf("create index exam_recalls_due: %w", err)
+	}
+	return nil
+}
+
+// contractEmployeeRecall находит работника в контингенте договора и считает его срок.
+// ok=false - договора нет или работника нет в контингенте (срок не ведётся).
+func contractEmployeeRecall(ctx context.Context, q dbExecutor, contractID int64, patientUID string, final map[string]any, examDate time.Time) (examRecall, bool, error) {
+	var clientBIN, clientName string
+	var employeesJSON []byte
+	err := q.QueryRow(ctx, `SELECT client_bin, client_name, employees FROM contracts WHERE id = $1`, contractID).
+		Scan(&clientBIN, &clientName, &employeesJSON)
+	if err == pgx.ErrNoRows {
+		return examRecall{}, false, nil
+	}
+	if err != nil {
+		return examRecall{}, false, err
+	}
+	var rows []map[string]any
+	_ = json.Unmarshal(employeesJSON, &rows)
+	for _, raw := range rows {
+		b, _ := json.Marshal(raw)
+		var emp contractEmployee
+		if json.Unmarshal(b, &emp) != nil || !emp.matches(patientUID) {
+			continue
+		}
+		rec := planExamRecall(emp, raw, final, examDate)
+		rec.PatientUID, rec.ClientBIN, rec.ClientName, rec.ContractID = patientUID, clientBIN, clientName, contractID
+		return rec, true, nil
+	}
+	return examRecall{}, false, nil
+}
+
+// saveExamRecall сохраняет срок. Заключение по более раннему осмотру новый срок не затирает;
+// при смене даты напоминание уйдёт заново.
+func saveExamRecall(ctx context.Context, q dbExecutor, rec examRecall) error {
+	employee, _ := json.Marshal(rec.Employee)
+	_, err := q.Exec(ctx, `
+INSERT INTO exam_recalls (patient_uid, client_bin, client_name, clinic_id, contract_id, episode_id, employee,
+  periodicity_months, basis, last_exam_date, next_due, source)
+VALUES ($1, $2, $3, NULLIF($4, ''), $5, $6, $7, $8, $9, $10, $11, $12)
+ON CONFLICT (client_bin, patient_uid) DO UPDATE SET
+  client_name = EXCLUDED.client_name,
+  clinic_id = EXCLUDED.clinic_id,
+  contract_id = EXCLUDED.contract_id,
+  episode_id = EXCLUDED.episode_id,
+  employee = EXCLUDED.employee,
+  periodicity_months = EXCLUDED.periodicity_months,
+  basis = EXCLUDED.basis,
+  last_exam_date = EXCLUDED.last_exam_date,
+  next_due = EXCLUDED.next_due,
+  source = EXCLUDED.source,
+  notified_at = CASE WHEN exam_recalls.next_due = EXCLUDED.next_due THEN exam_recalls.notified_at END,
+  updated_at = NOW()
+WHERE exam_recalls.last_exam_date <= EXCLUDED.last_exam_date
+`, rec.PatientUID, rec.ClientBIN, rec.ClientName, rec.ClinicID, rec.ContractID, rec.EpisodeID, employee,
+		rec.PeriodicityMonths, rec.Basis, rec.LastExamDate, rec.NextDue, rec.Source)
+	return err
+}
+
+// recomputeContractRecalls пересчитывает сроки по всем подписанным заключениям договора
+func recomputeContractRecalls(ctx context.Context, contractID int64) (int, error) {
+	rows, err := db.Query(ctx, `
+SELECT DISTINCT ON (patient_uid) id, patient_uid, COALESCE(clinic_id, ''), final_conclusion, exam_date
+FROM exam_episodes
+WHERE contract_id = $1 AND locked
+ORDER BY patient_uid, exam_date DESC, id DESC
+`, contractID)
+	if err != nil {
+		return 0, err
+	}
+	type signed struct {
+		id                   int64
+		patientUID, clinicID string
+		final                map[string]any
+		examDate             time.Time
+	}
+	var list []signed
+	for rows.Next() {
+		var s signed
+		var finalJSON []byte
+		if err := rows.Scan(&s.id, &s.patientUID, &s.clinicID, &finalJSON, &s.examDate); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		_ = json.Unmarshal(finalJSON, &s.final)
+		if d, ok := parseExamDate(cardText(s.final, "date")); ok {
+			s.examDate = d
+		}
+		list = append(list, s)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	saved := 0
+	for _, s := range list {
+		rec, ok, err := contractEmployeeRecall(ctx, db, contractID, s.patientUID, s.final, s.examDate)
+		if err != nil {
+			return saved, err
+		}
+		if !ok {
+			continue
+		}
+		rec.ClinicID, rec.EpisodeID = s.clinicID, s.id
+		if err := saveExamRecall(ctx, db, rec); err != nil {
+			return saved, err
+		}
+		saved++
+	}
+	return saved, nil
+}
+
+// ExamRecall - строка списка предстоящих осмотров
+type ExamRecall struct {
+	PatientUID        string `json:"patientUid"`
+	Name              string `json:"name"`
+	Site              string `json:"site,omitempty"`
+	Position          string `json:"position,omitempty"`
+	HarmfulFactor     string `json:"harmfulFactor,omitempty"`
+	ClientBIN         string `json:"clientBin"`
+	ClientName        string `json:"clientName"`
+	ContractID        *int64 `json:"contractId,omitempty"`
+	PeriodicityMonths int    `json:"periodicityMonths"`
+	Basis             string `json:"basis"`
+	LastExamDate      string `json:"lastExamDate"`
+	NextDue           string `json:"nextDue"`
+	Source            string `json:"source"`
+	DaysLeft          int    `json:"daysLeft"` // отрицательное - осмотр просрочен
+}
+
+// nextContingentRow - строка контингента нового договора: итоги прошлого осмотра сброшены
+func nextContingentRow(employee map[string]any, lastExam time.Time) map[string]any {
+	row := map[string]any{}
+	for k, v := range employee {
+		switch k {
+		case "status", "healthGroup", "visitId", "absenceReason":
+			continue
+		}
+		row[k] = v
+	}
+	row["status"] = "pending"
+	row["lastMedDate"] = lastExam.Format("02.01.2006")
+	return row
+}
+
+// GET /api/exam-recalls?days=60[&clientBin=][&format=contingent]
+// Организация видит своих работников, клиника - осмотренных у неё.
+// format=contingent отдаёт строки для контингента следующего договора.
+func listExamRecallsHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	user, ok := requestUser(ctx, w, r)
+	if !ok {
+		return
+	}
+	q := r.URL.Query()
+	days := examRecallDays()
+	if v := q.Get("days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 || n > 730 {
+			errorResponse(w, http.StatusBadRequest, "days must be between 0 and 730")
+			return
+		}
+		days = n
+	}
+
+	query := `
+SELECT patient_uid, client_bin, client_name, contract_id, employee, periodicity_months, basis,
+       last_exam_date, next_due, source, next_due - CURRENT_DATE
+FROM exam_recalls WHERE next_due <= CURRENT_DATE + $1::int AND `
+	args := []any{days}
+	switch {
+	case user.Role == UserRoleOrganization && user.BIN != nil:
+		args = append(args, *user.BIN)
+		query += `client_bin = $2`
+	case isClinicStaff(user):
+		args = append(args, userClinicID(user))
+		query += `clinic_id = $2`
+		if v := q.Get("clientBin"); v != "" {
+			args = append(args, v)
+			query += ` AND client_bin = $3`
+		}
+	default:
+		errorResponse(w, http.StatusForbidden, "access denied")
+		return
+	}
+	query += ` ORDER BY next_due, client_name, patient_uid`
+
+	rows, err := db.Query(ctx, query, args...)
+	if err != nil {
+		log.Printf("listExamRecalls error: %v", err)
+		errorResponse(w, http.StatusInternalServerError, "db error")
+		return
+	}
+	defer rows.Close()
+	res := []ExamRecall{}
+	contingent := []map[string]any{}
+	for rows.Next() {
+		var rec ExamRecall
+		var employeeJSON []byte
+		var last, due time.Time
+		if err := rows.Scan(&rec.PatientUID, &rec.ClientBIN, &rec.ClientName, &rec.ContractID, &employeeJSON,
+			&rec.PeriodicityMonths, &rec.Basis, &last, &due, &rec.Source, &rec.DaysLeft); err != nil {
+			log.Printf("listExamRecalls scan error: %v", err)
+			errorResponse(w, http.StatusInternalServerError, "db error")
+			return
+		}
+		var employee map[string]any
+		_ = json.Unmarshal(employeeJSON, &employee)
+		rec.Name = cardText(employee, "name")
+		rec.Site, rec.Position = cardText(employee, "site"), cardText(employee, "position")
+		rec.HarmfulFactor = cardText(employee, "harmfulFactor")
+		rec.LastExamDate, rec.NextDue = last.Format("2006-01-02"), due.Format("2006-01-02")
+		res = append(res, rec)
+		contingent = append(contingent, nextContingentRow(employee, last))
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("listExamRecalls rows error: %v", err)
+		errorResponse(w, http.StatusInternalServerError, "db error")
+		return
+	}
+	if q.Get("format") == "contingent" {
+		jsonResponse(w, http.StatusOK, contingent)
+		return
+	}
+	jsonResponse(w, http.StatusOK, res)
+}
+
+// POST /api/contracts/{id}/exam-recalls - пересчёт сроков по подписанным заключениям договора
+func recomputeExamRecallsHandler(w http.ResponseWriter, r *http.Request, contractID int64) {
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	if _, _, ok := authorizeContract(ctx, w, r, contractID, true); !ok {
+		return
+	}
+	n, err := recomputeContractRecalls(ctx, contractID)
+	if err != nil {
+		log.Printf("recomputeExamRecalls: contract %d: %v", contractID, err)
+		errorResponse(w, http.StatusInternalServerError, "db error")
+		return
+	}
+	jsonResponse(w, http.StatusOK, map[string]any{"contractId": contractID, "updated": n})
+}
+
+// --- Ежедневная задача ---
+
+// examRecallDays - за сколько дней до срока сообщать о предстоящем осмотре
+func examRecallDays() int {
+	n, err := strconv.Atoi(mustGetEnv("EXAM_RECALL_DAYS", "60"))
+	if err != nil || n < 0 {
+		return 60
+	}
+	return n
+}
+
+// startExamRecallScheduler раз в сутки сообщает о работниках с подходящим сроком осмотра
+func startExamRecallScheduler() {
+	if mustGetEnv("EXAM_RECALLS", "on") == "off" {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(24 * time.Hour)
+		defer ticker.Stop()
+		for {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+			if err := notifyExamRecalls(ctx, examRecallDays()); err != nil {
+				log.Printf("exam recalls: %v", err)
+			}
+			cancel()
+			<-ticker.C
+		}
+	}()
+}
+
+type recallDue struct {
+	PatientUID string `json:"patientUid"`
+	Name       string `json:"name"`
+	NextDue    string `json:"nextDue"`
+}
+
+// notifyExamRecalls рассылает ещё не сообщённые сроки списком: организации - по её
+// работникам, клинике - по осмотренным у неё
+func notifyExamRecalls(ctx context.Context, days int) error {
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+	n, err := claimExamRecalls(ctx, tx, days, publishLogged)
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+	if n > 0 {
+		log.Printf("exam recalls: notified %d organizations", n)
+	}
+	return nil
+}
+
+// claimExamRecalls блокирует неотмеченные сроки (другие реплики их пропускают) и ставит
+// notified_at только после того, как все уведомления записаны в журнал событий. При ошибке
+// ничего не отмечается: уже записанные уведомления придут повторно, но ни одно не потеряется.
+// Возвращает число уведомлённых организаций.
+func claimExamRecalls(ctx context.Context, q dbExecutor, days int, publish func(context.Context, busEvent) error) (int, error) {
+	rows, err := q.Query(ctx, `
+SELECT id, client_bin, client_name, COALESCE(clinic_id, ''), patient_uid, COALESCE(employee->>'name', ''), next_due
+FROM exam_recalls
+WHERE notified_at IS NULL AND next_due <= CURRENT_DATE + $1::int
+ORDER BY client_bin, next_due, patient_uid
+FOR UPDATE SKIP LOCKED
+`, days)
+	if err != nil {
+		return 0, err
+	}
+	var ids []int64
+	var bins []string
+	byClient := map[string][]recallDue{}
+	var clinicKeys []string
+	byClinic := map[string][]recallDue{}
+	names := map[string]string{}
+	for rows.Next() {
+		var id int64
+		var clientBIN, clientName, clinicID string
+		var d recallDue
+		var due time.Time
+		if err := rows.Scan(&id, &clientBIN, &clientName, &clinicID, &d.PatientUID, &d.Name, &due); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		d.NextDue = due.Format("2006-01-02")
+		ids = append(ids, id)
+		if _, ok := byClient[clientBIN]; !ok {
+			bins = append(bins, clientBIN)
+		}
+		names[clientBIN] = clientName
+		byClient[clientBIN] = append(byClient[clientBIN], d)
+		if clinicID != "" {
+			key := clinicID + "\x00" + clientBIN
+			if _, ok := byClinic[key]; !ok {
+				clinicKeys = append(clinicKeys, key)
+			}
+			byClinic[key] = append(byClinic[key], d)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	due := func(bin string, list []recallDue) map[string]any {
+		return map[string]any{"clientBin": bin, "clientName": names[bin], "days": days, "count": len(list), "employees": list}
+	}
+	var events []busEvent
+	for _, bin := range bins {
+		if users := organizationUserIDs(ctx, q, bin); len(users) > 0 {
+			events = append(events, busEvent{Scope: busScopeUsers, Keys: users, Message: newMessage("exam_recall_due", due(bin, byClient[bin]))})
+		}
+	}
+	for _, key := range clinicKeys {
+		clinicID, bin, _ := strings.Cut(key, "\x00")
+		msg := newMessage("exam_recall_due", due(bin, byClinic[key]))
+		msg.UserID = clinicID
+		events = append(events, busEvent{Scope: busScopeUser, Keys: []string{clinicID}, Message: msg})
+	}
+	for _, ev := range events {
+		if err := publish(ctx, ev); err != nil {
+			return 0, err
+		}
+	}
+
+	if _, err := q.Exec(ctx, `UPDATE exam_recalls SET notified_at = NOW() WHERE id = ANY($1)`, ids); err != nil {
+		return 0, err
+	}
+	return len(bins), nil
+}
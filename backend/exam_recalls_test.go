@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+func TestExamPeriodicity(t *testing.T) {
+	cases := []struct {
+		name   string
+		emp    contractEmployee
+		months int
+		basis  []string // фрагменты основания
+	}{
+		{"no factors", contractEmployee{Position: "бухгалтер"}, 12, []string{"п. 11 Правил"}},
+		{"factor refs in text", contractEmployee{Position: "машинист", HarmfulFactor: "п. 33 Шум, п.1 Азот"}, 12,
+			[]string{"Приложение 4, п. 33, 1"}},
+		{"factor codes win over text", contractEmployee{HarmfulFactor: "п. 33 Шум", FactorCodes: []string{"35", "36"}}, 12,
+			[]string{"Приложение 4, п. 35, 36"}},
+		{"decreed group code", contractEmployee{Position: "бухгалтер", DecreedGroup: "1"}, 6,
+			[]string{"Приложение 1, п. 1 (работники объектов общественного питания): через каждые 6 мес."}},
+		// Код группы заменяет поиск по должности
+		{"explicit none", contractEmployee{Position: "повар", DecreedGroup: decreedGroupNone}, 12, nil},
+		{"unknown code", contractEmployee{Position: "повар", DecreedGroup: "99"}, 12, nil},
+		{"position fallback", contractEmployee{Position: "Повар-кондитер 4 разряда"}, 6,
+			[]string{"п. 1 (работники объектов общественного питания, по должности)", "п. 3 ("}},
+		{"site fallback", contractEmployee{Position: "воспитатель", Site: "Детский сад №5"}, 6, []string{"п. 8 ("}},
+		{"except", contractEmployee{Position: "санитарка операционного блока"}, 12, nil},
+		{"not inside a word", contractEmployee{Position: "инженер кооперационного отдела"}, 12, nil},
+	}
+	for _, tc := range cases {
+		months, basis := examPeriodicity(tc.emp)
+		if months != tc.months {
+			t.Errorf("%s: months = %d, want %d (%s)", tc.name, months, tc.months, basis)
+		}
+		for _, want := range tc.basis {
+			if !strings.Contains(basis, want) {
+				t.Errorf("%s: basis %q has no %q", tc.name, basis, want)
+			}
+		}
+		if tc.basis == nil && strings.Contains(basis, "Приложение 1") {
+			t.Errorf("%s: unexpected decreed group in %q", tc.name, basis)
+		}
+	}
+}
+
+func TestMatchesDecreedGroup(t *testing.T) {
+	for _, tc := range []struct {
+		text string
+		want bool
+	}{
+		{"повар", true},
+		{"ПОВАРЁНОК", true},
+		{"шеф-повар", true},
+		{"работник общественного питания", true},
+		{"общественного транспорта, питание", false},
+		{"водитель", false},
+		{"", false},
+	} {
+		if got := matchesDecreedGroup(tc.text, []string{"повар", "общественного питания"}, nil); got != tc.want {
+			t.Errorf("matchesDecreedGroup(%q) = %v, want %v", tc.text, got, tc.want)
+		}
+	}
+	if matchesDecreedGroup("младший хирург", []string{"хирург"}, []string{"младш"}) {
+		t.Error("except must win")
+	}
+}
+
+func TestPlanExamRecall(t *testing.T) {
+	exam := time.Date(2026, 8, 31, 0, 0, 0, 0, time.UTC)
+	raw := map[string]any{"id": "emp-a"}
+
+	rec := planExamRecall(contractEmployee{DecreedGroup: "1"}, raw, map[string]any{}, exam)
+	if rec.PeriodicityMonths != 6 || rec.Source != RecallSourceComputed || !rec.NextDue.Equal(time.Date(2027, 2, 28, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("computed recall = %+v", rec)
+	}
+
+	// Дата комиссии важнее расчётной, но только если она позже осмотра
+	rec = planExamRecall(contractEmployee{}, raw, map[string]any{"nextExamDate": "01.03.2027"}, exam)
+	if rec.Source != RecallSourceConclusion || !rec.NextDue.Equal(time.Date(2027, 3, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("conclusion recall = %+v", rec)
+	}
+	rec = planExamRecall(contractEmployee{}, raw, map[string]any{"nextExamDate": "2026-01-01"}, exam)
+	if rec.Source != RecallSourceComputed || rec.NextDue.Year() != 2027 {
+		t.Errorf("past conclusion date must be ignored: %+v", rec)
+	}
+}
+
+func TestNextContingentRowKeepsCodes(t *testing.T) {
+	row := nextContingentRow(map[string]any{
+		"id": "emp-a", "status": "fit", "healthGroup": "II", "visitId": 5,
+		"factorCodes": []any{"33"}, "decreedGroup": "1",
+	}, time.Date(2026, 3, 2, 0, 0, 0, 0, time.UTC))
+	if row["status"] != "pending" || row["lastMedDate"] != "02.03.2026" || row["healthGroup"] != nil || row["visitId"] != nil {
+		t.Errorf("row = %v", row)
+	}
+	if row["decreedGroup"] != "1" || row["factorCodes"] == nil {
+		t.Errorf("codes must carry over to the next contract: %v", row)
+	}
+}
+
+// fakeRecallDB - неотмеченные сроки и учётные записи организаций
+type fakeRecallDB struct {
+	recalls [][]any
+	orgs    map[string][]string
+	marked  []int64
+	execs   int
+}
+
+func (f *fakeRecallDB) Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error) {
+	if strings.Contains(sql, "FROM users") {
+		var rows [][]any
+		for _, id := range f.orgs[args[0].(string)] {
+			rows = append(rows, []any{id})
+		}
+		return &fakeRows{rows: rows}, nil
+	}
+	if !strings.Contains(sql, "FOR UPDATE SKIP LOCKED") || strings.Contains(sql, "UPDATE exam_recalls") {
+		return nil, errors.New("recalls must be locked, not marked, before delivery:\n" + sql)
+	}
+	return &fakeRows{rows: f.recalls}, nil
+}
+
+func (f *fakeRecallDB) QueryRow(ctx context.Context, sql string, args ...any) pgx.Row {
+	return fakeRow{err: errors.New("unexpected query row")}
+}
+
+func (f *fakeRecallDB) Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error) {
+	f.execs++
+	if !strings.Contains(sql, "SET notified_at = NOW() WHERE id = ANY($1)") {
+		return pgconn.CommandTag{}, errors.New("unexpected exec: " + sql)
+	}
+	f.marked = args[0].([]int64)
+	return pgconn.NewCommandTag("UPDATE 3"), nil
+}
+
+func TestClaimExamRecallsMarksAfterDelivery(t *testing.T) {
+	due := mustDate("2026-05-01")
+	recalls := func() *fakeRecallDB {
+		return &fakeRecallDB{
+			recalls: [][]any{
+				{int64(1), "900", "ТОО «А»", "clinic-a", "emp-a", "Иванов", due},
+				{int64(2), "900", "ТОО «А»", "", "emp-c", "Сидоров", due},
+				{int64(3), "800", "ТОО «Б»", "clinic-b", "emp-b", "Петров", due},
+			},
+			orgs: map[string][]string{"900": {"org-a"}},
+		}
+	}
+
+	q := recalls()
+	var sent []busEvent
+	n, err := claimExamRecalls(context.Background(), q, 60, func(ctx context.Context, ev busEvent) error {
+		if len(q.marked) > 0 {
+			t.Error("recalls marked before delivery")
+		}
+		sent = append(sent, ev)
+		return nil
+	})
+	if err != nil || n != 2 {
+		t.Fatalf("claim: %d, %v", n, err)
+	}
+	if !reflect.DeepEqual(q.marked, []int64{1, 2, 3}) {
+		t.Errorf("marked = %v", q.marked)
+	}
+	// Организации без учётных записей сообщать некому; клиники получают своих работников
+	var got []string
+	for _, ev := range sent {
+		data := ev.Message.Data.(map[string]any)
+		got = append(got, fmt.Sprintf("%s:%v:%v:%d", ev.Scope, ev.Keys, data["clientBin"], data["count"]))
+	}
+	want := []string{"users:[org-a]:900:2", "user:[clinic-a]:900:1", "user:[clinic-b]:800:1"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("events = %v, want %v", got, want)
+	}
+
+	// Недоставленное уведомление не отмечается - его отправят при следующем запуске
+	q = recalls()
+	calls := 0
+	_, err = claimExamRecalls(context.Background(), q, 60, func(ctx context.Context, ev busEvent) error {
+		if calls++; calls == 2 {
+			return errors.New("event log is unavailable")
+		}
+		return nil
+	})
+	if err == nil || q.execs != 0 {
+		t.Errorf("failed delivery: err %v, %d updates", err, q.execs)
+	}
+
+	q = &fakeRecallDB{}
+	if n, err := claimExamRecalls(context.Background(), q, 60, nil); n != 0 || err != nil || q.execs != 0 {
+		t.Errorf("nothing due: %d, %v, %d updates", n, err, q.execs)
+	}
+}
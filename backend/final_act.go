@@ -0,0 +1,817 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Заключительный акт (Приложение 1 к Правилам): собирается из договора,
+// визитов и подписанных заключений эпизодов.
+
+// contractEmployee - запись контингента договора (Приложение 3), хранится в contracts.employees
+type contractEmployee struct {
+	ID                 string `json:"id"`
+	Name               string `json:"name"`
+	Dob                string `json:"dob"`
+	Gender             string `json:"gender"`
+	Site               string `json:"site"`
+	Position           string `json:"position"`
+	TotalExperience    string `json:"totalExperience"`
+	PositionExperience string `json:"positionExperience"`
+	HarmfulFactor      string `json:"harmfulFactor"`
+	// Пункты Перечня вредных факторов (Приложение 4) и целевой группы (Приложение 1,
+	// "none" - не входит) - по ним считается периодичность осмотров
+	FactorCodes  []string `json:"factorCodes,omitempty"`
+	DecreedGroup string   `json:"decreedGroup,omitempty"`
+	Status       string   `json:"status"`
+	HealthGroup  string   `json:"healthGroup"`
+	UserID       string   `json:"userId"`
+	// sick_leave / business_trip / vacation / dismissal / refusal - почему не прошёл осмотр
+	AbsenceReason string `json:"absenceReason"`
+}
+
+var yearPattern = regexp.MustCompile(`(19|20)\d{2}`)
+
+// matches - строка контингента относится к пациенту (по id строки или uid пользователя)
+func (e contractEmployee) matches(patientUID string) bool {
+	return e.ID == patientUID || (e.UserID != "" && e.UserID == patientUID)
+}
+
+func (e contractEmployee) birthYear() string {
+	return yearPattern.FindString(e.Dob)
+}
+
+// Итог осмотра работника
+const (
+	ActOutcomeFit          = "fit"
+	ActOutcomeTempUnfit    = "temporarily_unfit"
+	ActOutcomePermUnfit    = "permanently_unfit"
+	ActOutcomeNoConclusion = "no_conclusion" // нуждается в дообследовании
+	ActOutcomeNotCompleted = "not_completed" // начал, но не завершил осмотр
+	ActOutcomeNotExamined  = "not_examined"
+)
+
+var absenceReasonTitles = map[string]string{
+	"sick_leave":    "больничный лист",
+	"business_trip": "командировка",
+	"vacation":      "очередной отпуск",
+	"dismissal":     "увольнение",
+	"refusal":       "отказ от прохождения",
+}
+
+var absenceReasonOrder = []string{"sick_leave", "business_trip", "vacation", "dismissal", "refusal"}
+
+// Мероприятия по результатам осмотра; ключи совпадают с полями finalConclusion
+var actMeasures = []struct{ Key, Title string }{
+	{"profPathologyCenter", "обследование в центре профпатологии"},
+	{"outpatientTreatment", "амбулаторное обследование и лечение"},
+	{"inpatientTreatment", "стационарное обследование и лечение"},
+	{"sanatoriumTreatment", "санаторно-курортное лечение"},
+	{"therapeuticNutrition", "лечебно-профилактическое питание"},
+	{"dispensaryObservation", "диспансерное наблюдение"},
+	{"transferToOtherWork", "перевод на другую работу"},
+}
+
+type FinalActWorker struct {
+	EmployeeID            string              `json:"employeeId"`
+	Name                  string              `json:"name"`
+	Gender                string              `json:"gender"`
+	BirthYear             string              `json:"birthYear"`
+	Site                  string              `json:"site"`
+	Position              string              `json:"position"`
+	HarmfulFactor         string              `json:"harmfulFactor"`
+	Experience            string              `json:"experience"`
+	VisitID               *int64              `json:"visitId,omitempty"`
+	EpisodeID             *int64              `json:"episodeId,omitempty"`
+	Outcome               string              `json:"outcome"`
+	HealthGroup           string              `json:"healthGroup,omitempty"`
+	Diagnoses             []string            `json:"diagnoses,omitempty"` // коды МКБ-10
+	NewlyDiagnosed        bool                `json:"newlyDiagnosed,omitempty"`
+	OccupationalSuspicion bool                `json:"occupationalSuspicion,omitempty"`
+	Measures              map[string]bool     `json:"measures,omitempty"`
+	Recommendations       []actRecommendation `json:"recommendations,omitempty"`
+	AbsenceReason         string              `json:"absenceReason,omitempty"`
+	ProfReferral          *actReferral        `json:"profReferral,omitempty"` // направление в центр профпатологии
+}
+
+// Источники рекомендаций в акте
+const (
+	RecommendationRestrictions = "restrictions" // ограничения из заключения председателя
+	RecommendationChairman     = "chairman"     // рекомендации председателя комиссии
+	RecommendationSpecialist   = "specialist"   // рекомендации специалиста
+)
+
+// actRecommendation - рекомендация из карты: специальность хранится отдельно от текста,
+// чтобы текст с двоеточием не принимался за специальность
+type actRecommendation struct {
+	Source    string `json:"source"`
+	Specialty string `json:"specialty,omitempty"`
+	Text      string `json:"text"`
+}
+
+// label - кто дал рекомендацию, как в поименном списке и плане оздоровления
+func (r actRecommendation) label() string {
+	switch r.Source {
+	case RecommendationRestrictions:
+		return "Ограничения"
+	case RecommendationChairman:
+		return "Председатель комиссии"
+	}
+	return r.Specialty
+}
+
+func (r actRecommendation) String() string {
+	if r.Source == RecommendationChairman {
+		return r.Text
+	}
+	return r.label() + ": " + r.Text
+}
+
+// actReferral - направление работника в центр профпатологии и его итог
+type actReferral struct {
+	ID        int64   `json:"id"`
+	Status    string  `json:"status"`
+	Outcome   *string `json:"outcome,omitempty"`
+	ICDCode   *string `json:"icdCode,omitempty"`
+	Diagnosis *string `json:"diagnosis,omitempty"`
+}
+
+func (r *actReferral) text() string {
+	return referralOutcomeText(r.Status, r.Outcome, r.ICDCode, r.Diagnosis)
+}
+
+// applyReferral дополняет итог работника заключением центра профпатологии
+func (w *FinalActWorker) applyReferral(r *actReferral) {
+	w.ProfReferral = r
+	if r.Status == ReferralStatusCancelled {
+		return
+	}
+	w.OccupationalSuspicion = true
+	if r.Outcome != nil && *r.Outcome == ReferralOutcomeConfirmed && r.ICDCode != nil && *r.ICDCode != "" {
+		for _, code := range w.Diagnoses {
+			if code == *r.ICDCode {
+				return
+			}
+		}
+		w.Diagnoses = append(w.Diagnoses, *r.ICDCode)
+		sort.Strings(w.Diagnoses)
+	}
+}
+
+type headcount struct {
+	Total int `json:"total"`
+	Women int `json:"women"`
+}
+
+func (h *headcount) add(w FinalActWorker) {
+	h.Total++
+	if strings.EqualFold(w.Gender, "Ж") {
+		h.Women++
+	}
+}
+
+type FinalActSummaryRow struct {
+	Key   string `json:"key"`
+	Title string `json:"title"`
+	headcount
+}
+
+type FinalAct struct {
+	ContractID       int64              `json:"contractId"`
+	ContractNumber   string             `json:"contractNumber"`
+	ContractDate     string             `json:"contractDate"`
+	ActDate          string             `json:"actDate"`
+	OrganizationName string             `json:"organizationName"`
+	OrganizationBIN  string             `json:"organizationBin"`
+	ClinicName       string             `json:"clinicName"`
+	ClinicBIN        string             `json:"clinicBin"`
+	ExamFrom         string             `json:"examFrom"`
+	ExamTo           string             `json:"examTo"`
+	Chairman         *CommissionMember  `json:"chairman,omitempty"`
+	Members          []CommissionMember `json:"members"`
+
+	Employees    headcount      `json:"employees"` // п. 1
+	Harmful      headcount      `json:"harmful"`   // п. 2
+	Subject      headcount      `json:"subject"`   // п. 3
+	Examined     headcount      `json:"examined"`  // п. 4
+	Coverage     float64        `json:"coverage"`  // п. 5, %
+	CoverageW    float64        `json:"coverageWomen"`
+	NotFinished  headcount      `json:"notFinished"` // п. 6
+	NotExamined  headcount      `json:"notExamined"` // п. 7
+	AbsenceCount map[string]int `json:"absenceReasons"`
+
+	Summary []FinalActSummaryRow `json:"summary"` // сводная таблица 1
+	Workers []FinalActWorker     `json:"workers"` // сводная таблица 2
+}
+
+// recommendationWorkers - поименный список к акту: кому назначены мероприятия
+func (a *FinalAct) recommendationWorkers() []FinalActWorker {
+	var res []FinalActWorker
+	for _, w := range a.Workers {
+		if len(w.Recommendations) > 0 || len(w.Measures) > 0 || w.Outcome == ActOutcomeTempUnfit || w.Outcome == ActOutcomePermUnfit {
+			res = append(res, w)
+		}
+	}
+	return res
+}
+
+var icdPattern = regexp.MustCompile(`\b[A-TV-Z][0-9]{2}(?:\.[0-9]{1,2})?\b`)
+
+func conclusionFlag(m map[string]any, key string) bool {
+	v, _ := m[key].(bool)
+	return v
+}
+
+// classifyWorker заполняет итог осмотра по подписанному заключению эпизода
+func classifyWorker(w *FinalActWorker, final map[string]any, spec map[string]map[string]any) {
+	switch {
+	case conclusionFlag(final, "needsFurtherExamination"):
+		w.Outcome = ActOutcomeNoConclusion
+	case conclusionFlag(final, "isFit"):
+		w.Outcome = ActOutcomeFit
+	case cardText(final, "unfitType") == "permanent":
+		w.Outcome = ActOutcomePermUnfit
+	default:
+		w.Outcome = ActOutcomeTempUnfit
+	}
+	if g := cardText(final, "healthGroup"); g != "" {
+		w.HealthGroup = g
+	}
+	w.NewlyDiagnosed = conclusionFlag(final, "newlyDiagnosed")
+	w.OccupationalSuspicion = occupationalSuspected(final)
+
+	for _, m := range actMeasures {
+		if conclusionFlag(final, m.Key) {
+			if w.Measures == nil {
+				w.Measures = map[string]bool{}
+			}
+			w.Measures[m.Key] = true
+		}
+	}
+	// III-VI группы здоровья состоят на диспансерном учёте
+	switch w.HealthGroup {
+	case "III", "IV", "V", occupationalDiseaseGroup:
+		if w.Measures == nil {
+			w.Measures = map[string]bool{}
+		}
+		w.Measures["dispensaryObservation"] = true
+	}
+
+	codes := map[string]bool{}
+	for _, code := range icdPattern.FindAllString(cardText(final, "diagnosis"), -1) {
+		codes[code] = true
+	}
+	if r := cardText(final, "restrictions"); r != "" {
+		w.Recommendations = append(w.Recommendations, actRecommendation{Source: RecommendationRestrictions, Text: r})
+	}
+	if r := cardText(final, "recommendations"); r != "" {
+		w.Recommendations = append(w.Recommendations, actRecommendation{Source: RecommendationChairman, Text: r})
+	}
+	for _, specialty := range sortedKeys(spec) {
+		e := spec[specialty]
+		for _, code := range icdPattern.FindAllString(cardText(e, "diagnosis"), -1) {
+			codes[code] = true
+		}
+		if r := cardText(e, "recommendations"); r != "" {
+			w.Recommendations = append(w.Recommendations, actRecommendation{Source: RecommendationSpecialist, Specialty: specialty, Text: r})
+		}
+	}
+	w.Diagnoses = sortedKeys(codes)
+}
+
+// classifyLegacyStatus - договоры без визитов в системе: статус проставлен в контингенте вручную
+func classifyLegacyStatus(w *FinalActWorker, status string) bool {
+	switch status {
+	case "fit", "fit_with_restrictions":
+		w.Outcome = ActOutcomeFit
+	case "unfit":
+		w.Outcome = ActOutcomeTempUnfit
+	case "needs_observation":
+		w.Outcome = ActOutcomeNoConclusion
+	default:
+		return false
+	}
+	return true
+}
+
+type actVisit struct {
+	ID         int64
+	EmployeeID string
+	Status     string
+	Date       time.Time
+	EpisodeID  *int64
+	Concluded  bool
+	Final      map[string]any
+	Spec       map[string]map[string]any
+}
+
+func loadFinalAct(ctx context.Context, contractID int64) (*FinalAct, error) {
+	act := &FinalAct{ContractID: contractID, AbsenceCount: map[string]int{}, Members: []CommissionMember{}, Workers: []FinalActWorker{}}
+	var contractDate time.Time
+	var employeesJSON, planJSON []byte
+	err := db.QueryRow(ctx, `
+SELECT number, date, client_name, client_bin, clinic_name, clinic_bin, employees, calendar_plan
+FROM contracts WHERE id = $1
+`, contractID).Scan(&act.ContractNumber, &contractDate, &act.OrganizationName, &act.OrganizationBIN,
+		&act.ClinicName, &act.ClinicBIN, &employeesJSON, &planJSON)
+	if err != nil {
+		return nil, err
+	}
+	act.ContractDate = contractDate.Format("2006-01-02")
+
+	var employees []contractEmployee
+	if len(employeesJSON) > 0 {
+		if err := json.Unmarshal(employeesJSON, &employees); err != nil {
+			return nil, fmt.Errorf("parse contract employees: %w", err)
+		}
+	}
+	var plan struct {
+		StartDate string `json:"startDate"`
+		EndDate   string `json:"endDate"`
+	}
+	if len(planJSON) > 0 {
+		_ = json.Unmarshal(planJSON, &plan)
+	}
+
+	// Комиссия - врачи клиники, председатель первым
+	rows, err := db.Query(ctx, `
+SELECT d.id, d.name, d.specialty, d.is_chairman
+FROM doctors d JOIN users u ON u.id = d.clinic_uid
+WHERE u.bin = $1 AND u.role = 'clinic'
+ORDER BY d.is_chairman DESC, d.specialty, d.name
+`, act.ClinicBIN)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var id int64
+		var m CommissionMember
+		if err := rows.Scan(&id, &m.Name, &m.Specialty, &m.Chairman); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		m.DoctorID = strconv.FormatInt(id, 10)
+		if m.Chairman && act.Chairman == nil {
+			chairman := m
+			act.Chairman = &chairman
+			continue
+		}
+		act.Members = append(act.Members, m)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Визиты по договору; на работника берём завершённый, затем самый поздний
+	rows, err = db.Query(ctx, `
+SELECT v.id, v.employee_id, v.status, v.visit_date, e.id, COALESCE(e.locked, FALSE),
+       COALESCE(e.final_conclusion, '{}'::jsonb), COALESCE(e.specialist_entries, '{}'::jsonb)
+FROM employee_visits v
+LEFT JOIN exam_episodes e ON e.visit_id = v.id
+WHERE v.contract_id = $1
+ORDER BY v.visit_date, v.id
+`, contractID)
+	if err != nil {
+		return nil, err
+	}
+	visits := map[string]*actVisit{}
+	var first, last time.Time
+	for rows.Next() {
+		var v actVisit
+		var finalJSON, specJSON []byte
+		if err := rows.Scan(&v.ID, &v.EmployeeID, &v.Status, &v.Date, &v.EpisodeID, &v.Concluded, &finalJSON, &specJSON); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		_ = json.Unmarshal(finalJSON, &v.Final)
+		_ = json.Unmarshal(specJSON, &v.Spec)
+		if v.Status == "cancelled" {
+			continue
+		}
+		if first.IsZero() || v.Date.Before(first) {
+			first = v.Date
+		}
+		if v.Date.After(last) {
+			last = v.Date
+		}
+		if prev, ok := visits[v.EmployeeID]; !ok || v.Concluded || !prev.Concluded {
+			visits[v.EmployeeID] = &v
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Направления в центр профпатологии по эпизодам договора
+	rows, err = db.Query(ctx, `
+SELECT episode_id, id, status, outcome, outcome_icd, outcome_diagnosis
+FROM occupational_referrals WHERE contract_id = $1
+`, contractID)
+	if err != nil {
+		return nil, err
+	}
+	referrals := map[int64]*actReferral{}
+	for rows.Next() {
+		var episodeID int64
+		var ref actReferral
+		if err := rows.Scan(&episodeID, &ref.ID, &ref.Status, &ref.Outcome, &ref.ICDCode, &ref.Diagnosis); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		referrals[episodeID] = &ref
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	act.ExamFrom, act.ExamTo = plan.StartDate, plan.EndDate
+	if act.ExamFrom == "" && !first.IsZero() {
+		act.ExamFrom = first.Format("2006-01-02")
+	}
+	if act.ExamTo == "" && !last.IsZero() {
+		act.ExamTo = last.Format("2006-01-02")
+	}
+	act.ActDate = act.ExamTo
+
+	for _, e := range employees {
+		w := FinalActWorker{
+			EmployeeID:    e.ID,
+			Name:          strings.TrimSpace(e.Name),
+			Gender:        strings.TrimSpace(e.Gender),
+			BirthYear:     e.birthYear(),
+			Site:          e.Site,
+			Position:      e.Position,
+			HarmfulFactor: e.HarmfulFactor,
+			Experience:    e.PositionExperience,
+			HealthGroup:   e.HealthGroup,
+		}
+		if w.Experience == "" {
+			w.Experience = e.TotalExperience
+		}
+		v := visits[e.ID]
+		if v == nil && e.UserID != "" {
+			v = visits[e.UserID]
+		}
+
+		switch {
+		case v != nil && v.Concluded:
+			w.VisitID, w.EpisodeID = &v.ID, v.EpisodeID
+			classifyWorker(&w, v.Final, v.Spec)
+			if v.EpisodeID != nil && referrals[*v.EpisodeID] != nil {
+				w.applyReferral(referrals[*v.EpisodeID])
+			}
+		case v != nil:
+			w.VisitID, w.EpisodeID = &v.ID, v.EpisodeID
+			w.Outcome = ActOutcomeNotCompleted
+		case classifyLegacyStatus(&w, e.Status):
+		default:
+			w.Outcome = ActOutcomeNotExamined
+			w.AbsenceReason = e.AbsenceReason
+		}
+
+		act.Employees.add(w)
+		act.Subject.add(w)
+		if strings.TrimSpace(e.HarmfulFactor) != "" {
+			act.Harmful.add(w)
+		}
+		switch w.Outcome {
+		case ActOutcomeNotCompleted:
+			act.NotFinished.add(w)
+		case ActOutcomeNotExamined:
+			act.NotFinished.add(w)
+			act.NotExamined.add(w)
+			if _, ok := absenceReasonTitles[w.AbsenceReason]; ok {
+				act.AbsenceCount[w.AbsenceReason]++
+			}
+		default:
+			act.Examined.add(w)
+		}
+		act.Workers = append(act.Workers, w)
+	}
+
+	// Список - по участкам и фамилиям, как в сводной таблице 2
+	sort.SliceStable(act.Workers, func(i, j int) bool {
+		if act.Workers[i].Site != act.Workers[j].Site {
+			return act.Workers[i].Site < act.Workers[j].Site
+		}
+		return act.Workers[i].Name < act.Workers[j].Name
+	})
+
+	act.Coverage = percent(act.Examined.Total, act.Subject.Total)
+	act.CoverageW = percent(act.Examined.Women, act.Subject.Women)
+	act.Summary = buildActSummary(act.Workers)
+	return act, nil
+}
+
+func percent(part, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	// Округление до десятых
+	return float64(int(float64(part)*1000/float64(total)+0.5)) / 10
+}
+
+func buildActSummary(workers []FinalActWorker) []FinalActSummaryRow {
+	rows := []FinalActSummaryRow{
+		{Key: ActOutcomeFit, Title: "Число лиц, профпригодных к работе с вредными и (или) опасными веществами и производственными факторами, к видам работ"},
+		{Key: ActOutcomeTempUnfit, Title: "Число лиц, временно профнепригодных к работе с вредными и (или) опасными веществами и производственными факторами, к видам работ"},
+		{Key: ActOutcomePermUnfit, Title: "Число лиц, постоянно профнепригодных к работе с вредными и (или) опасными веществами и производственными факторами, к видам работ"},
+		{Key: ActOutcomeNoConclusion, Title: "Число лиц, нуждающихся в дообследовании (заключение не дано)"},
+		{Key: "occupationalSuspicion", Title: "Число лиц с подозрением на профессиональное заболевание"},
+		{Key: "outpatientTreatment", Title: "Число лиц, нуждающихся в амбулаторном обследовании и лечении"},
+		{Key: "inpatientTreatment", Title: "Число лиц, нуждающихся в стационарном обследовании и лечении"},
+		{Key: "sanatoriumTreatment", Title: "Число лиц, нуждающихся в санаторно-курортном лечении"},
+		{Key: "therapeuticNutrition", Title: "Число лиц, нуждающихся в лечебно-профилактическом питании"},
+		{Key: "dispensaryObservation", Title: "Число лиц, нуждающихся в диспансерном наблюдении"},
+	}
+	for i := range rows {
+		for _, w := range workers {
+			match := w.Outcome == rows[i].Key || w.Measures[rows[i].Key]
+			if rows[i].Key == "occupationalSuspicion" {
+				match = w.OccupationalSuspicion
+			}
+			if match {
+				rows[i].add(w)
+			}
+		}
+	}
+	return rows
+}
+
+// --- Печать ---
+
+func formatActDate(s string) string {
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		return t.Format("02.01.2006")
+	}
+	return orDash(s)
+}
+
+func formatPercent(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64) + "%"
+}
+
+func mark(v bool) string {
+	if v {
+		return "+"
+	}
+	return ""
+}
+
+func buildFinalActReport(act *FinalAct) *reportDoc {
+	d := &reportDoc{footer: fmt.Sprintf("Заключительный акт · договор № %s · %s", act.ContractNumber, act.OrganizationName)}
+	d.Title("Заключительный акт от " + formatActDate(act.ActDate))
+	d.Text("по результатам проведенного периодического медицинского осмотра (обследования) работников")
+	d.Text("Наименование организации: " + act.OrganizationName + " (БИН " + act.OrganizationBIN + ")")
+	d.Text(fmt.Sprintf("По договору № %s от %s", act.ContractNumber, formatActDate(act.ContractDate)))
+	d.Text("Медицинская организация: " + act.ClinicName + " (БИН " + act.ClinicBIN + ")")
+	d.Text(fmt.Sprintf("Медосмотр проводился с %s по %s комиссией:", formatActDate(act.ExamFrom), formatActDate(act.ExamTo)))
+	chairman := "—"
+	if act.Chairman != nil {
+		chairman = act.Chairman.Name + ", " + act.Chairman.Specialty
+	}
+	d.Text("Председатель комиссии: " + chairman)
+	if len(act.Members) > 0 {
+		var members []string
+		for _, m := range act.Members {
+			members = append(members, m.Name+", "+m.Specialty)
+		}
+		d.Text("Члены комиссии:\n" + strings.Join(members, "\n"))
+	}
+
+	count := func(n int, title string, h headcount) {
+		d.Heading(fmt.Sprintf("%d. %s", n, title))
+		d.Text(fmt.Sprintf("всего: %d, в том числе женщин: %d", h.Total, h.Women))
+	}
+	count(1, "Число работников организации (предприятия), цеха:", act.Employees)
+	count(2, "Число работников, работающих с вредными и (или) опасными веществами и производственными факторами, а также на работах:", act.Harmful)
+	count(3, "Число работников, подлежащих медицинскому осмотру (обследованию) в данном году:", act.Subject)
+	count(4, "Число работников, прошедших медицинский осмотр (обследование):", act.Examined)
+	d.Heading("5. % охвата периодическими медицинскими осмотрами:")
+	d.Text(fmt.Sprintf("всего: %s, в том числе женщин: %s", formatPercent(act.Coverage), formatPercent(act.CoverageW)))
+	count(6, "Число работников, не завершивших/не прошедших периодический медицинский осмотр (обследование):", act.NotFinished)
+
+	var notCompleted, notExamined [][]string
+	for _, w := range act.Workers {
+		switch w.Outcome {
+		case ActOutcomeNotCompleted:
+			notCompleted = append(notCompleted, []string{strconv.Itoa(len(notCompleted) + 1), w.Name, orDash(w.Site)})
+		case ActOutcomeNotExamined:
+			notExamined = append(notExamined, []string{strconv.Itoa(len(notExamined) + 1), w.Name, orDash(w.Site), orDash(absenceReasonTitles[w.AbsenceReason])})
+		}
+	}
+	d.Bold("Поименный список работников, не завершивших медицинский осмотр (обследование):")
+	if len(notCompleted) == 0 {
+		d.Text("нет")
+	} else {
+		d.Table([]float64{0.08, 0.52, 0.40}, []string{"№", "Ф.И.О.", "Подразделение"}, notCompleted)
+	}
+
+	count(7, "Число работников, не прошедших медицинский осмотр (обследование):", act.NotExamined)
+	var reasons []string
+	for _, key := range absenceReasonOrder {
+		reasons = append(reasons, fmt.Sprintf("%s: %d", absenceReasonTitles[key], act.AbsenceCount[key]))
+	}
+	d.Text("в том числе по причинам: " + strings.Join(reasons, "; "))
+	d.Bold("Поименный список работников, не прошедших периодический медицинский осмотр (обследование):")
+	if len(notExamined) == 0 {
+		d.Text("нет")
+	} else {
+		d.Table([]float64{0.08, 0.40, 0.30, 0.22}, []string{"№", "Ф.И.О.", "Подразделение", "Причина"}, notExamined)
+	}
+
+	d.Heading("8. Заключение по результатам данного периодического медицинского осмотра (обследования)")
+	d.Bold("Сводная таблица 1")
+	var summary [][]string
+	for _, row := range act.Summary {
+		summary = append(summary, []string{row.Title, strconv.Itoa(row.Total), strconv.Itoa(row.Women)})
+	}
+	d.Table([]float64{0.70, 0.15, 0.15}, []string{"Результаты периодического медицинского осмотра (обследования)", "всего", "в т.ч. женщин"}, summary)
+
+	d.PageBreak()
+	d.Bold("Сводная таблица 2")
+	var table2 [][]string
+	n := 0
+	for _, w := range act.Workers {
+		if w.Outcome == ActOutcomeNotCompleted || w.Outcome == ActOutcomeNotExamined {
+			continue
+		}
+		n++
+		var measures []string
+		for _, m := range actMeasures {
+			if w.Measures[m.Key] {
+				measures = append(measures, m.Title)
+			}
+		}
+		if w.OccupationalSuspicion {
+			measures = append(measures, "подозрение на профзаболевание")
+		}
+		diag := strings.Join(w.Diagnoses, ", ")
+		if w.HealthGroup != "" {
+			diag = strings.TrimPrefix(diag+", гр. "+w.HealthGroup, ", ")
+		}
+		if w.NewlyDiagnosed {
+			diag += " (впервые)"
+		}
+		table2 = append(table2, []string{
+			strconv.Itoa(n), w.Name, w.Gender, w.BirthYear, w.Site, w.Position, w.HarmfulFactor, w.Experience,
+			orDash(diag), actOutcomeTitles[w.Outcome], strings.Join(measures, "; "),
+		})
+	}
+	d.Table([]float64{0.04, 0.14, 0.04, 0.06, 0.09, 0.1, 0.12, 0.07, 0.1, 0.1, 0.14},
+		[]string{"№", "Ф.И.О.", "пол", "год рожд.", "участок", "профессия", "вредные факторы, виды работ", "стаж", "МКБ-10, группа", "заключение", "мероприятия"},
+		table2)
+
+	d.Heading("9. Выявлено лиц с подозрением на профессиональное заболевание:")
+	var suspected [][]string
+	for _, w := range act.Workers {
+		if w.OccupationalSuspicion {
+			referral := "не направлен"
+			if w.ProfReferral != nil {
+				referral = w.ProfReferral.text()
+			}
+			suspected = append(suspected, []string{strconv.Itoa(len(suspected) + 1), w.Name, orDash(w.Site), orDash(w.Position), orDash(w.HarmfulFactor), referral})
+		}
+	}
+	if len(suspected) == 0 {
+		d.Text("не выявлено")
+	} else {
+		d.Table([]float64{0.05, 0.22, 0.15, 0.15, 0.18, 0.25}, []string{"№", "Ф.И.О.", "Подразделение", "Профессия, должность", "Вредные факторы", "Центр профпатологии"}, suspected)
+	}
+
+	d.Heading("Поименный список лиц с рекомендациями (перевод на другую работу, лечение, питание, наблюдение)")
+	var recs [][]string
+	for _, w := range act.recommendationWorkers() {
+		var lines []string
+		for _, m := range actMeasures {
+			if w.Measures[m.Key] {
+				lines = append(lines, m.Title)
+			}
+		}
+		for _, r := range w.Recommendations {
+			lines = append(lines, r.String())
+		}
+		recs = append(recs, []string{strconv.Itoa(len(recs) + 1), w.Name, orDash(w.Position), strings.Join(lines, "\n")})
+	}
+	if len(recs) == 0 {
+		d.Text("нет")
+	} else {
+		d.Table([]float64{0.06, 0.26, 0.2, 0.48}, []string{"№", "Ф.И.О.", "Профессия, должность", "Рекомендации"}, recs)
+	}
+
+	d.Text("")
+	d.Text("Председатель врачебной комиссии: ____________________ " + chairman)
+	d.Text("Руководитель медицинской организации: ____________________")
+	d.Text("М.П.")
+	return d
+}
+
+var actOutcomeTitles = map[string]string{
+	ActOutcomeFit:          "годен",
+	ActOutcomeTempUnfit:    "временно непригоден",
+	ActOutcomePermUnfit:    "постоянно непригоден",
+	ActOutcomeNoConclusion: "заключение не дано",
+	ActOutcomeNotCompleted: "не завершил осмотр",
+	ActOutcomeNotExamined:  "не прошёл осмотр",
+}
+
+// GET  /api/contracts/{id}/final-act - расчёт акта (JSON), ?format=pdf|docx - файл без сохранения
+// POST /api/contracts/{id}/final-act - сформировать и сохранить новую версию документа договора
+func finalActHandler(w http.ResponseWriter, r *http.Request, contractID int64) {
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	user, parties, ok := authorizeContract(ctx, w, r, contractID, r.Method == http.MethodPost)
+	if !ok {
+		return
+	}
+
+	// В акте диагнозы и рекомендации специалистов - работодателю только итоги без медицинских данных
+	if !parties.isClinicSide(user) {
+		if r.URL.Query().Get("format") != "" {
+			errorResponse(w, http.StatusForbidden, "final act documents are available to the clinic only")
+			return
+		}
+		res, err := records.EmployerOutcomes(ctx, contractID)
+		if err != nil {
+			log.Printf("finalAct: outcomes for contract %d: %v", contractID, err)
+			errorResponse(w, http.StatusInternalServerError, "db error")
+			return
+		}
+		w.Header().Set("Cache-Control", "private, no-store")
+		jsonResponse(w, http.StatusOK, res)
+		return
+	}
+
+	act, err := records.FinalAct(ctx, contractID)
+	if err != nil {
+		log.Printf("finalAct: load contract %d: %v", contractID, err)
+		errorResponse(w, http.StatusInternalServerError, "db error")
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if r.Method == http.MethodGet && format == "" {
+		jsonResponse(w, http.StatusOK, act)
+		return
+	}
+
+	report := buildFinalActReport(act)
+	docx := report.DOCX()
+	var pdf []byte
+	if r.Method == http.MethodPost || format == "pdf" {
+		regular, bold, err := loadPDFFonts()
+		if err != nil {
+			log.Printf("finalAct: font error: %v", err)
+			errorResponse(w, http.StatusInternalServerError, "pdf font is not available")
+			return
+		}
+		pdf = report.PDF(regular, bold)
+	}
+
+	if r.Method == http.MethodGet {
+		switch format {
+		case "pdf":
+			w.Header().Set("Content-Type", "application/pdf")
+			w.Header().Set("Content-Disposition", fmt.Sprintf(`inline; filename="final-act-%d.pdf"`, contractID))
+			w.Write(pdf)
+		case "docx":
+			w.Header().Set("Content-Type", "application/vnd.openxmlformats-officedocument.wordprocessingml.document")
+			w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="final-act-%d.docx"`, contractID))
+			w.Write(docx)
+		default:
+			errorResponse(w, http.StatusBadRequest, "format must be pdf or docx")
+		}
+		return
+	}
+
+	doc, err := saveContractDocument(ctx, contractID, ContractDocFinalAct, act, pdf, docx, user.ID)
+	if err != nil {
+		log.Printf("finalAct: save contract %d: %v", contractID, err)
+		errorResponse(w, http.StatusInternalServerError, "save error")
+		return
+	}
+	if doc.Unchanged {
+		jsonResponse(w, http.StatusOK, doc)
+		return
+	}
+
+	broadcastToUsers(parties.userIDs(), "contract_document_created", map[string]interface{}{
+		"contractId": contractID,
+		"documentId": doc.ID,
+		"type":       doc.DocType,
+		"version":    doc.Version,
+	})
+	jsonResponse(w, http.StatusCreated, doc)
+}
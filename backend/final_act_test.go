@@ -0,0 +1,135 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestClassifyWorkerOutcome(t *testing.T) {
+	cases := []struct {
+		name  string
+		final map[string]any
+		want  string
+	}{
+		{"fit", map[string]any{"isFit": true}, ActOutcomeFit},
+		// Дообследование важнее отметки о годности
+		{"further examination", map[string]any{"isFit": true, "needsFurtherExamination": true}, ActOutcomeNoConclusion},
+		{"permanently unfit", map[string]any{"isFit": false, "unfitType": "permanent"}, ActOutcomePermUnfit},
+		{"temporarily unfit", map[string]any{"isFit": false, "unfitType": "temporary"}, ActOutcomeTempUnfit},
+		{"unfit without type", map[string]any{}, ActOutcomeTempUnfit},
+	}
+	for _, tc := range cases {
+		var w FinalActWorker
+		classifyWorker(&w, tc.final, nil)
+		if w.Outcome != tc.want {
+			t.Errorf("%s: outcome = %s, want %s", tc.name, w.Outcome, tc.want)
+		}
+	}
+}
+
+func TestClassifyWorkerCollectsDiagnosesAndMeasures(t *testing.T) {
+	var w FinalActWorker
+	final := map[string]any{
+		"isFit":               true,
+		"healthGroup":         "III",
+		"diagnosis":           "I11.9 Гипертоническая болезнь; J44",
+		"newlyDiagnosed":      true,
+		"sanatoriumTreatment": true,
+		"inpatientTreatment":  false,
+	}
+	spec := map[string]map[string]any{
+		"Терапевт": {"diagnosis": "I11.9"},
+		"ЛОР":      {"diagnosis": "H90.3 нейросенсорная тугоухость", "recommendations": "беруши"},
+	}
+	classifyWorker(&w, final, spec)
+
+	if want := []string{"H90.3", "I11.9", "J44"}; !reflect.DeepEqual(w.Diagnoses, want) {
+		t.Errorf("diagnoses = %v, want %v", w.Diagnoses, want)
+	}
+	// III группа здоровья - диспансерное наблюдение без отдельной отметки
+	if want := map[string]bool{"sanatoriumTreatment": true, "dispensaryObservation": true}; !reflect.DeepEqual(w.Measures, want) {
+		t.Errorf("measures = %v, want %v", w.Measures, want)
+	}
+	if w.HealthGroup != "III" || !w.NewlyDiagnosed || w.OccupationalSuspicion {
+		t.Errorf("worker = %+v", w)
+	}
+	if len(w.Recommendations) != 1 || w.Recommendations[0].Specialty != "ЛОР" {
+		t.Errorf("recommendations = %+v", w.Recommendations)
+	}
+
+	var prof FinalActWorker
+	classifyWorker(&prof, map[string]any{"isFit": false, "healthGroup": occupationalDiseaseGroup}, nil)
+	if !prof.OccupationalSuspicion || !prof.Measures["dispensaryObservation"] {
+		t.Errorf("group VI worker = %+v", prof)
+	}
+}
+
+func TestBuildActSummary(t *testing.T) {
+	workers := []FinalActWorker{
+		{Gender: "Ж", Outcome: ActOutcomeFit, Measures: map[string]bool{"sanatoriumTreatment": true}},
+		{Gender: "М", Outcome: ActOutcomeFit},
+		{Gender: "ж", Outcome: ActOutcomeTempUnfit, OccupationalSuspicion: true, Measures: map[string]bool{"dispensaryObservation": true}},
+		{Gender: "М", Outcome: ActOutcomePermUnfit, Measures: map[string]bool{"inpatientTreatment": true, "dispensaryObservation": true}},
+		{Gender: "Ж", Outcome: ActOutcomeNoConclusion},
+		// Не прошедшие осмотр в сводную таблицу не попадают
+		{Gender: "Ж", Outcome: ActOutcomeNotExamined, AbsenceReason: "vacation"},
+		{Gender: "М", Outcome: ActOutcomeNotCompleted},
+	}
+	got := map[string]headcount{}
+	for _, row := range buildActSummary(workers) {
+		if row.Title == "" {
+			t.Errorf("row %s has no title", row.Key)
+		}
+		got[row.Key] = row.headcount
+	}
+	want := map[string]headcount{
+		ActOutcomeFit:           {Total: 2, Women: 1},
+		ActOutcomeTempUnfit:     {Total: 1, Women: 1},
+		ActOutcomePermUnfit:     {Total: 1},
+		ActOutcomeNoConclusion:  {Total: 1, Women: 1},
+		"occupationalSuspicion": {Total: 1, Women: 1},
+		"outpatientTreatment":   {},
+		"inpatientTreatment":    {Total: 1},
+		"sanatoriumTreatment":   {Total: 1, Women: 1},
+		"therapeuticNutrition":  {},
+		"dispensaryObservation": {Total: 2, Women: 1},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("summary = %+v, want %+v", got, want)
+	}
+}
+
+func TestPercent(t *testing.T) {
+	for _, tc := range []struct {
+		part, total int
+		want        float64
+	}{
+		{0, 0, 0},
+		{1, 3, 33.3},
+		{2, 3, 66.7},
+		{7, 7, 100},
+	} {
+		if got := percent(tc.part, tc.total); got != tc.want {
+			t.Errorf("percent(%d, %d) = %v, want %v", tc.part, tc.total, got, tc.want)
+		}
+	}
+}
+
+func TestApplyReferral(t *testing.T) {
+	confirmed, code := ReferralOutcomeConfirmed, "J62.8"
+	w := FinalActWorker{Diagnoses: []string{"K29.5"}}
+	w.applyReferral(&actReferral{ID: 1, Status: ReferralStatusCompleted, Outcome: &confirmed, ICDCode: &code})
+	if !w.OccupationalSuspicion || !reflect.DeepEqual(w.Diagnoses, []string{"J62.8", "K29.5"}) {
+		t.Errorf("worker = %+v", w)
+	}
+	w.applyReferral(&actReferral{ID: 1, Status: ReferralStatusCompleted, Outcome: &confirmed, ICDCode: &code})
+	if len(w.Diagnoses) != 2 {
+		t.Errorf("diagnosis added twice: %v", w.Diagnoses)
+	}
+
+	cancelled := FinalActWorker{}
+	cancelled.applyReferral(&actReferral{ID: 2, Status: ReferralStatusCancelled, Outcome: &confirmed, ICDCode: &code})
+	if cancelled.OccupationalSuspicion || cancelled.Diagnoses != nil || cancelled.ProfReferral == nil {
+		t.Errorf("cancelled referral applied: %+v", cancelled)
+	}
+}
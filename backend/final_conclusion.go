@@ -0,0 +1,527 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// --- FINAL CONCLUSION (итоговое заключение председателя комиссии, п. 115 Приказа) ---
+
+// CommissionMember - член комиссии, на запись которого опирается итоговое заключение
+type CommissionMember struct {
+	DoctorID  string `json:"doctorId,omitempty"`
+	Name      string `json:"name"`
+	Specialty string `json:"specialty"`
+	Date      string `json:"date,omitempty"`
+	Chairman  bool   `json:"chairman,omitempty"`
+}
+
+// RouteStep - шаг маршрутного листа визита (employee_visits.route_sheet)
+type RouteStep struct {
+	Type        string `json:"type"`
+	Specialty   string `json:"specialty"`
+	DoctorID    any    `json:"doctorId,omitempty"`
+	DoctorName  string `json:"doctorName,omitempty"`
+	Status      string `json:"status"`
+	CompletedAt string `json:"completedAt,omitempty"`
+	Required    *bool  `json:"required,omitempty"`
+}
+
+// doctorIDString приводит doctorId шага к строке (во фронтенде это число или строка)
+func (s RouteStep) doctorIDString() string {
+	if s.DoctorID == nil {
+		return ""
+	}
+	return fmt.Sprintf("%v", s.DoctorID)
+}
+
+// isRequired - шаг обязателен, если явно не помечен required=false
+func (s RouteStep) isRequired() bool {
+	return s.Required == nil || *s.Required
+}
+
+var (
+	errNotChairman   = errors.New("only the commission chairman may do this")
+	errForeignClinic = errors.New("chairman belongs to another clinic")
+)
+
+// loadChairman возвращает врача-председателя (профпатолога), от имени которого выполняется запрос
+func loadChairman(ctx context.Context, r *http.Request) (*Doctor, error) {
+	uid := requestUserID(r)
+	if uid == "" {
+		return nil, errNotChairman
+	}
+	u, err := loadUser(ctx, uid)
+	if err != nil || u.Role != UserRoleDoctor || u.DoctorID == nil {
+		return nil, errNotChairman
+	}
+
+	var doctorID int64
+	if _, err := fmt.Sscanf(*u.DoctorID, "%d", &doctorID); err != nil {
+		return nil, errNotChairman
+	}
+
+	var d Doctor
+	var phone *string
+	err = db.QueryRow(ctx, `
+SELECT id, clinic_uid, name, specialty, phone, is_chairman, room_number
+FROM doctors WHERE id = $1
+`, doctorID).Scan(&d.ID, &d.ClinicUID, &d.Name, &d.Specialty, &phone, &d.IsChairman, &d.RoomNumber)
+	if err != nil || !d.IsChairman {
+		return nil, errNotChairman
+	}
+	if phone != nil {
+		d.Phone = *phone
+	}
+	return &d, nil
+}
+
+// visitForConclusion - данные визита, нужные для подписания заключения
+type visitForConclusion struct {
+	ID         int64
+	EmployeeID string
+	ClinicID   string
+	ContractID *int64
+	Status     string
+	RouteSheet []RouteStep
+}
+
+func loadVisitForConclusion(ctx context.Context, visitID int64) (*visitForConclusion, error) {
+	var v visitForConclusion
+	var routeSheet []byte
+	err := db.QueryRow(ctx, `
+SELECT id, employee_id, clinic_id, contract_id, status, route_sheet
+FROM employee_visits WHERE id = $1
+`, visitID).Scan(&v.ID, &v.EmployeeID, &v.ClinicID, &v.ContractID, &v.Status, &routeSheet)
+	if err != nil {
+		return nil, err
+	}
+	if len(routeSheet) > 0 {
+		if err := json.Unmarshal(routeSheet, &v.RouteSheet); err != nil {
+			return nil, fmt.Errorf("route sheet: %w", err)
+		}
+	}
+	return &v, nil
+}
+
+// isChairmanStep - шаг маршрута, который закрывает сам председатель своим заключением
+func isChairmanStep(step RouteStep, chairman *Doctor) bool {
+	if step.doctorIDString() == fmt.Sprintf("%d", chairman.ID) {
+		return true
+	}
+	return step.Type == "doctor" && normalizeSpecialty(step.Specialty) == normalizeSpecialty(chairman.Specialty)
+}
+
+// pendingRouteSteps возвращает обязательные незавершённые шаги маршрута (кроме шага председателя)
+func pendingRouteSteps(steps []RouteStep, chairman *Doctor) []string {
+	var pending []string
+	for _, step := range steps {
+		if !step.isRequired() || isChairmanStep(step, chairman) {
+			continue
+		}
+		if step.Status != "completed" {
+			pending = append(pending, step.Specialty)
+		}
+	}
+	return pending
+}
+
+// normalizeSpecialty приводит специальность к виду для сравнения ("Врач-терапевт" -> "терапевт")
+func normalizeSpecialty(s string) string {
+	s = strings.ToLower(strings.TrimSpace(s))
+	s = strings.ReplaceAll(s, "врач-", "")
+	s = strings.ReplaceAll(s, "врач", "")
+	return strings.TrimSpace(s)
+}
+
+// completeRouteSteps отмечает выполненными шаги маршрута визита, выбранные match.
+// Специальности сравниваются только в Go (normalizeSpecialty) - SQL переписывает шаги по номерам.
+func completeRouteSteps(ctx context.Context, q dbExecutor, visitID int64, steps []RouteStep, match func(RouteStep) bool) error {
+	var idx []int
+	for i, step := range steps {
+		if match(step) {
+			idx = append(idx, i+1)
+		}
+	}
+	if len(idx) == 0 {
+		return nil
+	}
+	_, err := q.Exec(ctx, `
+		UPDATE employee_visits SET
+			route_sheet = (
+				SELECT jsonb_agg(
+					CASE WHEN ord = ANY($2::int[])
+						THEN item || jsonb_build_object('status', 'completed', 'completedAt', NOW())
+						ELSE item
+					END ORDER BY ord
+				)
+				FROM jsonb_array_elements(route_sheet) WITH ORDINALITY AS t(item, ord)
+			),
+			updated_at = NOW()
+		WHERE id = $1
+	`, visitID, idx)
+	return err
+}
+
+// buildCommission собирает состав комиссии из записей специалистов и маршрутного листа:
+// специалисты по алфавиту, председатель последним
+func buildCommission(entries map[string]map[string]any, steps []RouteStep, chairman *Doctor) []CommissionMember {
+	stepsBySpecialty := make(map[string]RouteStep)
+	for _, step := range steps {
+		if step.Type == "doctor" {
+			stepsBySpecialty[normalizeSpecialty(step.Specialty)] = step
+		}
+	}
+
+	members := []CommissionMember{}
+	for _, specialty := range sortedKeys(entries) {
+		entry := entries[specialty]
+		m := CommissionMember{Specialty: specialty}
+		if name, ok := entry["doctorName"].(string); ok {
+			m.Name = name
+		}
+		if date, ok := entry["date"].(string); ok {
+			m.Date = date
+		}
+		if step, ok := stepsBySpecialty[normalizeSpecialty(specialty)]; ok {
+			m.DoctorID = step.doctorIDString()
+			if m.Name == "" {
+				m.Name = step.DoctorName
+			}
+			if m.Date == "" {
+				m.Date = step.CompletedAt
+			}
+		}
+		members = append(members, m)
+	}
+
+	members = append(members, CommissionMember{
+		DoctorID:  fmt.Sprintf("%d", chairman.ID),
+		Name:      chairman.Name,
+		Specialty: chairman.Specialty,
+		Date:      time.Now().Format("2006-01-02"),
+		Chairman:  true,
+	})
+	return members
+}
+
+// parseVisitConclusionPath разбирает /api/visits/{id}/final-conclusion[/reopen]
+func parseVisitConclusionPath(path string) (int64, bool, bool) {
+	rest := strings.TrimPrefix(path, "/api/visits/")
+	parts := strings.Split(strings.Trim(rest, "/"), "/")
+	if len(parts) < 2 || parts[1] != "final-conclusion" {
+		return 0, false, false
+	}
+	var id int64
+	if _, err := fmt.Sscanf(parts[0], "%d", &id); err != nil || id <= 0 {
+		return 0, false, false
+	}
+	switch {
+	case len(parts) == 2:
+		return id, false, true
+	case len(parts) == 3 && parts[2] == "reopen":
+		return id, true, true
+	}
+	return 0, false, false
+}
+
+// authorizeChairmanForVisit проверяет, что запрос делает председатель клиники визита
+func authorizeChairmanForVisit(ctx context.Context, w http.ResponseWriter, r *http.Request, visitID int64) (*Doctor, *visitForConclusion, bool) {
+	chairman, err := loadChairman(ctx, r)
+	if err != nil {
+		errorResponse(w, http.StatusForbidden, err.Error())
+		return nil, nil, false
+	}
+
+	visit, err := loadVisitForConclusion(ctx, visitID)
+	if err != nil {
+		log.Printf("finalConclusion: visit %d not found: %v", visitID, err)
+		errorResponse(w, http.StatusNotFound, "visit not found")
+		return nil, nil, false
+	}
+	if visit.ClinicID != chairman.ClinicUID {
+		errorResponse(w, http.StatusForbidden, errForeignClinic.Error())
+		return nil, nil, false
+	}
+	return chairman, visit, true
+}
+
+// POST /api/visits/{id}/final-conclusion
+func signFinalConclusionHandler(w http.ResponseWriter, r *http.Request, visitID int64) {
+	var in struct {
+		Conclusion map[string]any `json:"conclusion"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil || in.Conclusion == nil {
+		errorResponse(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	chairman, visit, ok := authorizeChairmanForVisit(ctx, w, r, visitID)
+	if !ok {
+		return
+	}
+	if visit.Status == "cancelled" {
+		errorResponse(w, http.StatusConflict, "visit is cancelled")
+		return
+	}
+
+	if pending := pendingRouteSteps(visit.RouteSheet, chairman); len(pending) > 0 {
+		jsonResponse(w, http.StatusConflict, map[string]any{
+			"error":   "route sheet is not completed",
+			"pending": pending,
+		})
+		return
+	}
+
+	episodeID, err := ensureEpisodeForVisit(ctx, db, visit.ID, ExamTypePeriodic)
+	if err != nil {
+		log.Printf("signFinalConclusion: episode for visit %d: %v", visit.ID, err)
+		errorResponse(w, http.StatusInternalServerError, "db error")
+		return
+	}
+
+	var locked bool
+	var specJSON []byte
+	err = db.QueryRow(ctx, `SELECT locked, specialist_entries FROM exam_episodes WHERE id = $1`, episodeID).Scan(&locked, &specJSON)
+	if err != nil {
+		errorResponse(w, http.StatusNotFound, "episode not found")
+		return
+	}
+	if locked {
+		errorResponse(w, http.StatusConflict, "final conclusion is already signed, reopen it first")
+		return
+	}
+
+	entries := map[string]map[string]any{}
+	if len(specJSON) > 0 {
+		_ = json.Unmarshal(specJSON, &entries)
+	}
+	commission := buildCommission(entries, visit.RouteSheet, chairman)
+
+	in.Conclusion["chairmanName"] = chairman.Name
+	if d, _ := in.Conclusion["date"].(string); d == "" {
+		in.Conclusion["date"] = time.Now().Format("2006-01-02")
+	}
+
+	// Срок следующего осмотра: периодичность по вредным факторам работника, если комиссия его не указала
+	var recall *examRecall
+	if visit.ContractID != nil {
+		examDate, ok := parseExamDate(cardText(in.Conclusion, "date"))
+		if !ok {
+			examDate = today()
+		}
+		rec, ok, err := contractEmployeeRecall(ctx, db, *visit.ContractID, visit.EmployeeID, in.Conclusion, examDate)
+		if err != nil {
+			log.Printf("signFinalConclusion: next exam date for visit %d: %v", visit.ID, err)
+		}
+		if ok {
+			rec.ClinicID, rec.EpisodeID = visit.ClinicID, episodeID
+			recall = &rec
+			if cardText(in.Conclusion, "nextExamDate") == "" {
+				in.Conclusion["nextExamDate"] = rec.NextDue.Format("2006-01-02")
+			}
+		}
+	}
+
+	conclusionJSON, _ := json.Marshal(in.Conclusion)
+	commissionJSON, _ := json.Marshal(commission)
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		errorResponse(w, http.StatusInternalServerError, "db error")
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	// Блокировка проверяется в самом UPDATE: из двух одновременных подписей проходит одна
+	tag, err := tx.Exec(ctx, `
+		UPDATE exam_episodes SET
+			final_conclusion = $1,
+			commission = $2,
+			final_signed_by = $3,
+			final_signed_at = NOW(),
+			status = 'concluded',
+			locked = TRUE,
+			updated_at = NOW()
+		WHERE id = $4 AND NOT locked
+	`, conclusionJSON, commissionJSON, chairman.ID, episodeID)
+	if err != nil {
+		log.Printf("signFinalConclusion: update episode error: %v", err)
+		errorResponse(w, http.StatusInternalServerError, "db error")
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		errorResponse(w, http.StatusConflict, "final conclusion is already signed, reopen it first")
+		return
+	}
+
+	// Шаг председателя отмечаем выполненным - тот же, что пропускает pendingRouteSteps, - и завершаем визит
+	err = completeRouteSteps(ctx, tx, visit.ID, visit.RouteSheet, func(step RouteStep) bool {
+		return isChairmanStep(step, chairman)
+	})
+	if err == nil {
+		_, err = tx.Exec(ctx, `
+		UPDATE employee_visits SET
+			status = 'completed',
+			check_out_time = NOW(),
+			updated_at = NOW()
+		WHERE id = $1
+	`, visit.ID)
+	}
+	if err != nil {
+		log.Printf("signFinalConclusion: update visit error: %v", err)
+		errorResponse(w, http.StatusInternalServerError, "db error")
+		return
+	}
+
+	if recall != nil {
+		if err := saveExamRecall(ctx, tx, *recall); err != nil {
+			log.Printf("signFinalConclusion: save exam recall error: %v", err)
+			errorResponse(w, http.StatusInternalServerError, "db error")
+			return
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		errorResponse(w, http.StatusInternalServerError, "db error")
+		return
+	}
+
+	if visit.ContractID != nil {
+		invalidateContractStats(ctx, db, *visit.ContractID)
+	}
+
+	// Подозрение на профзаболевание - извещение и направление в центр профпатологии
+	referral, err := syncOccupationalReferral(ctx, db, episodeID, in.Conclusion, chairman)
+	if err != nil {
+		log.Printf("signFinalConclusion: occupational referral for episode %d: %v", episodeID, err)
+	}
+
+	event := map[string]interface{}{
+		"visitId":    visit.ID,
+		"employeeId": visit.EmployeeID,
+		"episodeId":  episodeID,
+	}
+	if referral != nil {
+		event["occupationalReferralId"] = referral.ID
+		broadcastToUser(visit.ClinicID, "occupational_referral_issued", event)
+	}
+	broadcastToUser(visit.ClinicID, "final_conclusion_signed", event)
+	broadcastToUser(visit.EmployeeID, "visit_updated", event)
+	publishToTopic(visitTopic(visit.ID), "visit_updated", event)
+
+	res := map[string]any{
+		"episodeId":       episodeID,
+		"visitId":         visit.ID,
+		"finalConclusion": json.RawMessage(conclusionJSON),
+		"commission":      commission,
+		"locked":          true,
+	}
+	if referral != nil {
+		res["occupationalReferral"] = referral
+	}
+	jsonResponse(w, http.StatusOK, res)
+}
+
+// POST /api/visits/{id}/final-conclusion/reopen
+func reopenFinalConclusionHandler(w http.ResponseWriter, r *http.Request, visitID int64) {
+	var in struct {
+		Reason string `json:"reason"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil || strings.TrimSpace(in.Reason) == "" {
+		errorResponse(w, http.StatusBadRequest, "reason is required")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	chairman, visit, ok := authorizeChairmanForVisit(ctx, w, r, visitID)
+	if !ok {
+		return
+	}
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		errorResponse(w, http.StatusInternalServerError, "db error")
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	var episodeID int64
+	err = tx.QueryRow(ctx, `
+		UPDATE exam_episodes SET
+			locked = FALSE,
+			status = 'open',
+			final_signed_at = NULL,
+			reopened_by = $1,
+			reopened_at = NOW(),
+			reopen_reason = $2,
+			updated_at = NOW()
+		WHERE visit_id = $3 AND locked
+		RETURNING id
+	`, chairman.ID, in.Reason, visit.ID).Scan(&episodeID)
+	if err != nil {
+		errorResponse(w, http.StatusConflict, "no signed conclusion for this visit")
+		return
+	}
+
+	_, err = tx.Exec(ctx, `UPDATE employee_visits SET status = 'in_progress', check_out_time = NULL, updated_at = NOW() WHERE id = $1`, visit.ID)
+	if err != nil {
+		log.Printf("reopenFinalConclusion: update visit error: %v", err)
+		errorResponse(w, http.StatusInternalServerError, "db error")
+		return
+	}
+
+	// Справки о годности по этому заключению больше не действуют
+	revoked, err := revokeEpisodeCertificates(ctx, tx, episodeID, "заключение переоткрыто: "+in.Reason)
+	if err != nil {
+		log.Printf("reopenFinalConclusion: revoke certificates error: %v", err)
+		errorResponse(w, http.StatusInternalServerError, "db error")
+		return
+	}
+
+	// Невыполненное направление в центр профпатологии отменяется вместе с заключением
+	cancelledReferral, err := cancelEpisodeReferral(ctx, tx, episodeID)
+	if err != nil {
+		log.Printf("reopenFinalConclusion: cancel occupational referral error: %v", err)
+		errorResponse(w, http.StatusInternalServerError, "db error")
+		return
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		errorResponse(w, http.StatusInternalServerError, "db error")
+		return
+	}
+
+	if visit.ContractID != nil {
+		invalidateContractStats(ctx, db, *visit.ContractID)
+	}
+
+	event := map[string]interface{}{
+		"visitId":    visit.ID,
+		"employeeId": visit.EmployeeID,
+		"episodeId":  episodeID,
+	}
+	if cancelledReferral != nil {
+		event["occupationalReferralId"] = *cancelledReferral
+		broadcastToUser(visit.ClinicID, "occupational_referral_cancelled", event)
+	}
+	broadcastToUser(visit.ClinicID, "final_conclusion_reopened", event)
+	broadcastToUser(visit.EmployeeID, "visit_updated", event)
+	publishToTopic(visitTopic(visit.ID), "visit_updated", event)
+	for _, id := range revoked {
+		broadcastToUser(visit.EmployeeID, "certificate_revoked", map[string]any{"id": id, "kind": CertFitness})
+	}
+
+	jsonResponse(w, http.StatusOK, map[string]any{"episodeId": episodeID, "locked": false})
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestNormalizeSpecialty(t *testing.T) {
+	for in, want := range map[string]string{
+		"Врач-терапевт":     "терапевт",
+		" ТЕРАПЕВТ ":        "терапевт",
+		"врач терапевт":     "терапевт",
+		"Профпатолог":       "профпатолог",
+		"Оториноларинголог": "оториноларинголог",
+	} {
+		if got := normalizeSpecialty(in); got != want {
+			t.Errorf("normalizeSpecialty(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestBuildCommissionIsSorted(t *testing.T) {
+	chairman := &Doctor{ID: 7, Name: "Петров П.П.", Specialty: "Профпатолог"}
+	entries := map[string]map[string]any{
+		"Терапевт":   {"doctorName": "Иванова И.И.", "date": "2026-03-01"},
+		"Окулист":    {},
+		"Невролог":   {"doctorName": "Сидоров С.С."},
+		"Хирург":     {"date": "2026-03-02"},
+		"Дерматолог": {},
+	}
+	steps := []RouteStep{
+		{Type: "doctor", Specialty: "Врач-окулист", DoctorID: float64(12), DoctorName: "Орлова О.О.", CompletedAt: "2026-02-28"},
+		{Type: "lab", Specialty: "Хирург", DoctorName: "не врач"},
+	}
+	var first []CommissionMember
+	for i := 0; i < 20; i++ {
+		got := buildCommission(entries, steps, chairman)
+		if first == nil {
+			first = got
+			continue
+		}
+		if !reflect.DeepEqual(got, first) {
+			t.Fatalf("commission order changed between calls:\n%v\n%v", first, got)
+		}
+	}
+
+	var specialties []string
+	for _, m := range first {
+		specialties = append(specialties, m.Specialty)
+	}
+	want := []string{"Дерматолог", "Невролог", "Окулист", "Терапевт", "Хирург", "Профпатолог"}
+	if !reflect.DeepEqual(specialties, want) {
+		t.Errorf("order = %v, want %v", specialties, want)
+	}
+	if oc := first[2]; oc.Name != "Орлова О.О." || oc.DoctorID != "12" || oc.Date != "2026-02-28" {
+		t.Errorf("route step not matched by normalized specialty: %+v", oc)
+	}
+	if h := first[4]; h.Name != "" || h.DoctorID != "" {
+		t.Errorf("non-doctor step must not fill the member: %+v", h)
+	}
+	if last := first[len(first)-1]; !last.Chairman || last.DoctorID != "7" {
+		t.Errorf("chairman must be last: %+v", last)
+	}
+}
+
+func TestPendingRouteStepsSkipsChairman(t *testing.T) {
+	chairman := &Doctor{ID: 7, Specialty: "Профпатолог"}
+	no := false
+	steps := []RouteStep{
+		{Type: "doctor", Specialty: "Врач-профпатолог", Status: "pending"},
+		{Type: "doctor", Specialty: "Терапевт", Status: "completed"},
+		{Type: "doctor", Specialty: "Окулист", Status: "pending"},
+		{Type: "doctor", Specialty: "Стоматолог", Status: "pending", Required: &no},
+		{Type: "doctor", Specialty: "Хирург", Status: "pending", DoctorID: "7"},
+	}
+	if got := pendingRouteSteps(steps, chairman); !reflect.DeepEqual(got, []string{"Окулист"}) {
+		t.Errorf("pending = %v", got)
+	}
+}
+
+func TestUpsertAmbulatoryCardRejectsFinalConclusion(t *testing.T) {
+	body := `{"patientUid":"emp-a","general":{},"medical":{},"finalConclusion":{"isFit":true}}`
+	if rec := callAs(t, doctorA, http.MethodPost, "/api/ambulatory-cards", body, upsertAmbulatoryCardHandler); rec.Code != http.StatusBadRequest {
+		t.Errorf("status %d, want 400", rec.Code)
+	}
+}
@@ -0,0 +1,413 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// form052Data - всё, что печатается в форме 052/у; собирается из карты и эпизода
+type form052Data struct {
+	CardID      int64
+	EpisodeID   int64
+	PatientUID  string
+	IIN         string
+	ClinicName  string
+	ExamType    string
+	ExamDate    string
+	General     map[string]any
+	Medical     map[string]any
+	Comm        map[string]any
+	Instruction string
+	Dispensary  []string // активные диспансерные наблюдения из регистра
+	Spec        map[string]map[string]any
+	Labs        map[string]map[string]any
+	Final       map[string]any
+	Commission  []CommissionMember
+	SignedAt    string
+}
+
+var examTypeTitles = map[string]string{
+	ExamTypePreliminary:   "предварительный",
+	ExamTypePeriodic:      "периодический",
+	ExamTypePreShift:      "предсменный",
+	ExamTypeExtraordinary: "внеочередной",
+}
+
+// cardText приводит значение из JSON карты к строке для печати
+func cardText(m map[string]any, key string) string {
+	switch v := m[key].(type) {
+	case nil:
+		return ""
+	case string:
+		return strings.TrimSpace(v)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		if v {
+			return "да"
+		}
+		return "нет"
+	default:
+		b, _ := json.Marshal(v)
+		return string(b)
+	}
+}
+
+func loadForm052Data(ctx context.Context, cardID, episodeID int64) (*form052Data, error) {
+	d := &form052Data{CardID: cardID}
+	var general, medical []byte
+	var comm, instr *string
+	err := db.QueryRow(ctx, `
+SELECT patient_uid, iin, general, medical, communication, patient_instruction
+FROM ambulatory_cards WHERE id = $1
+`, cardID).Scan(&d.PatientUID, &d.IIN, &general, &medical, &comm, &instr)
+	if err != nil {
+		return nil, err
+	}
+	_ = json.Unmarshal(general, &d.General)
+	_ = json.Unmarshal(medical, &d.Medical)
+	if comm != nil {
+		_ = json.Unmarshal([]byte(*comm), &d.Comm)
+	}
+	if instr != nil {
+		d.Instruction = *instr
+	}
+
+	rows, err := db.Query(ctx, `
+SELECT o.icd_code, o.diagnosis, o.enrolled_on, COALESCE(d.name, '')
+FROM dispensary_observations o LEFT JOIN doctors d ON d.id = o.doctor_id
+WHERE o.patient_uid = $1 AND o.status = 'active' ORDER BY o.enrolled_on
+`, d.PatientUID)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var code, diagnosis, doctor string
+		var enrolled time.Time
+		if err := rows.Scan(&code, &diagnosis, &enrolled, &doctor); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		line := strings.TrimSpace(code+" "+diagnosis) + ", на учёте с " + enrolled.Format("02.01.2006")
+		if doctor != "" {
+			line += ", врач " + doctor
+		}
+		d.Dispensary = append(d.Dispensary, line)
+	}
+	rows.Close()
+
+	if episodeID == 0 {
+		episodeID, _, _ = currentEpisodeID(ctx, db, d.PatientUID)
+	}
+	if episodeID == 0 {
+		return d, nil
+	}
+	e, err := loadEpisode(ctx, db, episodeID)
+	if err != nil || e.PatientUID != d.PatientUID {
+		return nil, fmt.Errorf("episode %d does not belong to card %d", episodeID, cardID)
+	}
+	d.EpisodeID, d.ExamType, d.ExamDate = e.ID, e.ExamType, e.ExamDate
+	_ = json.Unmarshal(e.Spec, &d.Spec)
+	_ = json.Unmarshal(e.Labs, &d.Labs)
+	_ = json.Unmarshal(e.Final, &d.Final)
+	_ = json.Unmarshal(e.Commission, &d.Commission)
+	if e.FinalSignedAt != nil {
+		d.SignedAt = *e.FinalSignedAt
+	}
+	if e.ClinicID != nil {
+		var name *string
+		if err := db.QueryRow(ctx, `SELECT company_name FROM users WHERE id = $1`, *e.ClinicID).Scan(&name); err == nil && name != nil {
+			d.ClinicName = *name
+		}
+	}
+	return d, nil
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+const (
+	fontRegular = 0
+	fontBold    = 1
+)
+
+// renderForm052 печатает форму; результат зависит только от данных
+func renderForm052(data *form052Data, regular, bold *ttfFont) []byte {
+	doc := newPDFDoc(regular, bold)
+	doc.footer = func(d *pdfDoc, page, total int) {
+		d.Line(pdfMargin, pdfPageHeight-40, pdfPageWidth-pdfMargin, pdfPageHeight-40, 0.5)
+		d.Text(fontRegular, 8, pdfMargin, pdfPageHeight-28, fmt.Sprintf("Форма № 052/у · карта № %d · ИИН %s", data.CardID, data.IIN))
+		label := fmt.Sprintf("стр. %d из %d", page, total)
+		d.Text(fontRegular, 8, pdfPageWidth-pdfMargin-regular.width(label, 8), pdfPageHeight-28, label)
+	}
+
+	field := func(label, value string) {
+		if value == "" {
+			value = "—"
+		}
+		doc.Paragraph(fontRegular, 10.5, 0, label+": "+value)
+	}
+	checkbox := func(label string, options [][2]string, selected string) {
+		doc.Paragraph(fontRegular, 10.5, 0, label)
+		doc.ensureSpace(16)
+		x := pdfMargin + 12
+		y := doc.y + 4
+		for _, opt := range options {
+			doc.Rect(x, y, 9, 9, 0.6)
+			if opt[0] == selected {
+				doc.Text(fontBold, 9, x+1.5, y+8, "X")
+			}
+			doc.Text(fontRegular, 10.5, x+14, y+8.5, opt[1])
+			x += 24 + regular.width(opt[1], 10.5)
+		}
+		doc.y += 16
+	}
+	heading := func(s string) {
+		doc.ensureSpace(40)
+		doc.Space(8)
+		doc.Paragraph(fontBold, 11.5, 0, s)
+		doc.Space(2)
+	}
+
+	// --- Титульный лист ---
+	if data.ClinicName != "" {
+		doc.Paragraph(fontRegular, 9, 0, "Медицинская организация: "+data.ClinicName)
+		doc.Space(6)
+	}
+	doc.Centered(fontBold, 13, "Форма № 052/у «Медицинская карта амбулаторного пациента»")
+	doc.Centered(fontBold, 13, fmt.Sprintf("№ %d", data.CardID))
+	if data.ExamType != "" {
+		doc.Centered(fontRegular, 10, fmt.Sprintf("Медицинский осмотр: %s, %s", examTypeTitles[data.ExamType], data.ExamDate))
+	}
+
+	g := data.General
+	heading("Общая часть. Паспортные данные")
+	field("1. ИИН", data.IIN)
+	field("2. ФИО (при его наличии)", cardText(g, "fullName"))
+	field("3. Дата рождения", cardText(g, "dob"))
+	checkbox("4. Пол", [][2]string{{"male", "мужской"}, {"female", "женский"}}, cardText(g, "gender"))
+	field("5. Возраст", cardText(g, "age"))
+	field("6. Национальность", cardText(g, "nationality"))
+	checkbox("7. Житель", [][2]string{{"city", "города"}, {"village", "села"}}, cardText(g, "residentType"))
+	field("8. Гражданство", cardText(g, "citizenship"))
+	field("9. Адрес проживания", cardText(g, "address"))
+	field("10. Место работы/учебы/детского учреждения", cardText(g, "workPlace"))
+	field("Должность", cardText(g, "position"))
+	field("Образование", cardText(g, "education"))
+	insurance := strings.TrimSpace(strings.Join([]string{cardText(g, "insuranceCompany"), cardText(g, "insurancePolicyNumber")}, " "))
+	field("11. Наименование страховой компании, № страхового полиса", insurance)
+	field("12. Тип возмещения", cardText(g, "compensationType"))
+	field("13. Социальный статус", cardText(g, "socialStatus"))
+	field("14. Повод обращения", cardText(g, "visitReason"))
+
+	m := data.Medical
+	heading("Минимальные медицинские данные")
+	field("1. Группа крови, резус-фактор", strings.TrimSpace(cardText(m, "bloodGroup")+" "+cardText(m, "rhFactor")))
+	field("2. Аллергические реакции", cardText(m, "allergies"))
+	field("3. Физиологическое состояние пациента (беременность)", cardText(m, "pregnancyStatus"))
+	field("4. Дата проведения и результат скрининга", cardText(m, "screeningResults"))
+	field("5. Вредные привычки и риски для здоровья", cardText(m, "badHabits"))
+	field("6. Профилактические мероприятия, в том числе прививки", cardText(m, "vaccinations"))
+	field("7. История болезней и нарушений", cardText(m, "diseaseHistory"))
+	field("8. Список текущих проблем со здоровьем", cardText(m, "currentProblems"))
+	observation := cardText(m, "dynamicObservation")
+	if len(data.Dispensary) > 0 {
+		observation = strings.TrimSpace(observation + "\nДиспансерный учёт: " + strings.Join(data.Dispensary, "; "))
+	}
+	field("9. Динамическое наблюдение", observation)
+	field("10. Группа инвалидности", cardText(m, "disabilityGroup"))
+	field("11. Список принимаемых лекарственных средств", cardText(m, "currentMedications"))
+	var anthro []string
+	if a, ok := m["anthropometry"].(map[string]any); ok {
+		for _, p := range [][2]string{{"height", "рост"}, {"weight", "вес"}, {"bmi", "ИМТ"}, {"pressure", "АД"}, {"pulse", "пульс"}} {
+			if v := cardText(a, p[0]); v != "" {
+				anthro = append(anthro, p[1]+" "+v)
+			}
+		}
+	}
+	field("12. Антропометрические данные", strings.Join(anthro, ", "))
+	field("13. Оценка риска падения", cardText(m, "fallRisk"))
+	field("14. Оценка боли", cardText(m, "painScore"))
+	var occupational []string
+	if list, ok := m["occupationalDiseases"].([]any); ok {
+		for _, it := range list {
+			e, ok := it.(map[string]any)
+			if !ok {
+				continue
+			}
+			line := strings.TrimSpace(cardText(e, "icdCode") + " " + cardText(e, "diagnosis"))
+			if cardText(e, "outcome") == ReferralOutcomeNotConfirmed {
+				line = "не подтверждено: " + line
+			}
+			occupational = append(occupational, strings.TrimSpace(cardText(e, "date")+" "+line+" ("+orDash(cardText(e, "center"))+")"))
+		}
+	}
+	field("15. Профессиональные заболевания (заключения центра профпатологии)", strings.Join(occupational, "; "))
+
+	if len(data.Comm) > 0 || data.Instruction != "" {
+		heading("Коммуникация и инструкции пациенту")
+		field("Язык общения", cardText(data.Comm, "language"))
+		field("Условия проживания", cardText(data.Comm, "livingConditions"))
+		if data.Instruction != "" {
+			field("Инструкция пациенту", data.Instruction)
+		}
+	}
+
+	// --- Записи специалистов: каждая с новой страницы ---
+	fitness := map[string]string{"fit": "годен", "unfit": "не годен", "needs_observation": "требует наблюдения"}
+	for _, specialty := range sortedKeys(data.Spec) {
+		e := data.Spec[specialty]
+		doc.AddPage()
+		doc.Centered(fontBold, 12, "Осмотр специалиста: "+specialty)
+		doc.Space(6)
+		field("Врач", cardText(e, "doctorName"))
+		field("Дата осмотра", cardText(e, "date"))
+		heading("Жалобы")
+		doc.Paragraph(fontRegular, 10.5, 0, orDash(cardText(e, "complaints")))
+		heading("Анамнез")
+		doc.Paragraph(fontRegular, 10.5, 0, orDash(cardText(e, "anamnesis")))
+		heading("Объективные данные (Status praesens)")
+		doc.Paragraph(fontRegular, 10.5, 0, orDash(cardText(e, "objective")))
+		heading("Диагноз (МКБ-10)")
+		doc.Paragraph(fontRegular, 10.5, 0, orDash(cardText(e, "diagnosis")))
+		heading("Рекомендации")
+		doc.Paragraph(fontRegular, 10.5, 0, orDash(cardText(e, "recommendations")))
+		doc.Space(6)
+		field("Профпригодность", fitness[cardText(e, "fitnessStatus")])
+		doc.Space(18)
+		doc.Paragraph(fontRegular, 10.5, 0, "Подпись врача: ____________________  "+cardText(e, "doctorName"))
+	}
+
+	// --- Лабораторные и функциональные исследования ---
+	if len(data.Labs) > 0 {
+		doc.AddPage()
+		doc.Centered(fontBold, 12, "Результаты лабораторных и функциональных исследований")
+		doc.Space(8)
+		widths := []float64{190, 70, 130, pdfPageWidth - 2*pdfMargin - 390}
+		header := func() {
+			doc.TableRow(fontBold, 9.5, widths, []string{"Исследование", "Дата", "Результат", "Норма"}, nil)
+		}
+		row := func(font int, cells ...string) {
+			doc.TableRow(font, 9.5, widths, cells, header)
+		}
+		header()
+		for _, name := range sortedKeys(data.Labs) {
+			l := data.Labs[name]
+			row(fontRegular, name, cardText(l, "date"), cardText(l, "value"), cardText(l, "norm"))
+		}
+	}
+
+	// --- Заключение ---
+	if len(data.Final) > 0 {
+		doc.AddPage()
+		doc.Centered(fontBold, 12, "Заключение врачебной комиссии")
+		doc.Space(8)
+		fit := "не годен к работе"
+		if v, _ := data.Final["isFit"].(bool); v {
+			fit = "годен к работе"
+		}
+		field("Группа здоровья", cardText(data.Final, "healthGroup"))
+		field("Профпригодность", fit)
+		field("Ограничения", cardText(data.Final, "restrictions"))
+		field("Дата следующего осмотра", cardText(data.Final, "nextExamDate"))
+		field("Дата заключения", cardText(data.Final, "date"))
+		if len(data.Commission) > 0 {
+			heading("Состав комиссии")
+			for _, c := range data.Commission {
+				role := c.Specialty
+				if c.Chairman {
+					role += " (председатель)"
+				}
+				doc.Paragraph(fontRegular, 10.5, 12, role+": "+c.Name+"  ____________________")
+			}
+		}
+		doc.Space(18)
+		doc.Paragraph(fontRegular, 10.5, 0, "Председатель комиссии: ____________________  "+cardText(data.Final, "chairmanName"))
+		if data.SignedAt != "" {
+			doc.Paragraph(fontRegular, 9, 0, "Подписано: "+data.SignedAt)
+		}
+	}
+
+	return doc.Bytes()
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "—"
+	}
+	return s
+}
+
+// GET /api/ambulatory-cards/{id}/form-052.pdf[?episodeId=]
+// POST - то же, с сохранением копии во вложения карты (архив)
+func form052Handler(w http.ResponseWriter, r *http.Request, cardID int64) {
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	user, ok := requestUser(ctx, w, r)
+	if !ok {
+		return
+	}
+
+	regular, bold, err := loadPDFFonts()
+	if err != nil {
+		log.Printf("form052: font error: %v", err)
+		errorResponse(w, http.StatusInternalServerError, "pdf font is not available")
+		return
+	}
+
+	episodeID, _ := strconv.ParseInt(r.URL.Query().Get("episodeId"), 10, 64)
+	data, err := loadForm052Data(ctx, cardID, episodeID)
+	if err != nil {
+		errorResponse(w, http.StatusNotFound, "card not found")
+		return
+	}
+	if !canReadAmbulatoryCard(user, data.PatientUID) {
+		errorResponse(w, http.StatusForbidden, "access denied")
+		return
+	}
+	pdf := renderForm052(data, regular, bold)
+	sum := sha256.Sum256(pdf)
+	hash := hex.EncodeToString(sum[:])
+
+	if r.Method == http.MethodPost {
+		if !isClinicStaff(user) {
+			errorResponse(w, http.StatusForbidden, "only clinic staff can archive documents")
+			return
+		}
+		a, err := saveGeneratedAttachment(ctx, user, data.EpisodeID, cardID, "form052", fmt.Sprintf("form-052-%d.pdf", cardID), "application/pdf", pdf)
+		if err != nil {
+			log.Printf("form052: archive error: %v", err)
+			errorResponse(w, http.StatusInternalServerError, "archive error")
+			return
+		}
+		jsonResponse(w, http.StatusCreated, a)
+		return
+	}
+
+	etag := `"` + hash + `"`
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`inline; filename="form-052-%d.pdf"`, cardID))
+	w.Header().Set("ETag", etag)
+	w.Header().Set("X-Content-SHA256", hash)
+	w.Write(pdf)
+}
@@ -0,0 +1,688 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// План оздоровительных мероприятий по договору: строится по заключительному акту
+// (мероприятия из заключений и рекомендации специалистов), дальше ведётся вручную -
+// клиника и работодатель проставляют сроки, ответственных и отметки о выполнении.
+
+const (
+	PlanItemPlanned    = "planned"
+	PlanItemInProgress = "in_progress"
+	PlanItemDone       = "done"
+	PlanItemCancelled  = "cancelled"
+)
+
+// Срок по умолчанию (дней от даты акта) и ответственный для каждого вида мероприятий
+var planMeasureDefaults = map[string]struct {
+	Days        int
+	Responsible string
+}{
+	"transferToOtherWork":   {30, "Работодатель"},
+	"profPathologyCenter":   {90, "Работодатель, центр профпатологии"},
+	"inpatientTreatment":    {90, "Медицинская организация по месту прикрепления"},
+	"outpatientTreatment":   {30, "Медицинская организация по месту прикрепления"},
+	"sanatoriumTreatment":   {365, "Работодатель"},
+	"therapeuticNutrition":  {30, "Работодатель"},
+	"dispensaryObservation": {365, "Медицинская организация по месту прикрепления (ПМСП)"},
+	"specialist":            {90, "Медицинская организация по месту прикрепления"},
+}
+
+type HealthPlanItem struct {
+	ID             int64   `json:"id"`
+	ContractID     int64   `json:"contractId"`
+	EmployeeID     string  `json:"employeeId"`
+	EmployeeName   string  `json:"employeeName"`
+	Site           string  `json:"site,omitempty"`
+	Position       string  `json:"position,omitempty"`
+	Measure        string  `json:"measure"`
+	ItemKey        string  `json:"itemKey"`
+	Description    string  `json:"description"`
+	Source         string  `json:"source"` // conclusion / specialist / manual
+	Deadline       *string `json:"deadline,omitempty"`
+	Responsible    string  `json:"responsible"`
+	Status         string  `json:"status"`
+	CompletedAt    *string `json:"completedAt,omitempty"`
+	CompletionNote string  `json:"completionNote,omitempty"`
+	UpdatedBy      string  `json:"updatedBy,omitempty"`
+	UpdatedAt      string  `json:"updatedAt"`
+}
+
+func migrateHealthPlans(ctx context.Context, tx pgx.Tx) error {
+	_, err := tx.Exec(ctx, `
+CREATE TABLE IF NOT EXISTS health_plan_items (
+  id              SERIAL PRIMARY KEY,
+  contract_id     INTEGER NOT NULL REFERENCES contracts(id) ON DELETE CASCADE,
+  employee_id     TEXT NOT NULL,
+  employee_name   TEXT NOT NULL,
+  site            TEXT,
+  position        TEXT,
+  measure         TEXT NOT NULL,
+  item_key        TEXT NOT NULL,
+  description     TEXT NOT NULL,
+  source          TEXT NOT NULL DEFAULT 'manual',
+  deadline        DATE,
+  responsible     TEXT NOT NULL DEFAULT '',
+  status          TEXT NOT NULL DEFAULT 'planned',
+  completed_at    TIMESTAMPTZ,
+  completion_note TEXT,
+  updated_by      TEXT,
+  created_at      TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  updated_at      TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  CONSTRAINT valid_plan_item_status CHECK (status IN ('planned', 'in_progress', 'done', 'cancelled')),
+  CONSTRAINT valid_plan_item_source CHECK (source IN ('conclusion', 'specialist', 'manual'))
+);
+`)
+	if err != nil {
+		return fmt.Errorf("migrate health_plan_items: %w", err)
+	}
+
+	// Сгенерированные пункты уникальны, чтобы перегенерация их не дублировала
+	_, err = tx.Exec(ctx, `
+CREATE UNIQUE INDEX IF NOT EXISTS idx_health_plan_generated
+ON health_plan_items(contract_id, employee_id, item_key) WHERE source <> 'manual';
+`)
+	if err != nil {
+		return fmt.Errorf("create index health_plan_generated: %w", err)
+	}
+	_, err = tx.Exec(ctx, `CREATE INDEX IF NOT EXISTS idx_health_plan_contract ON health_plan_items(contract_id);`)
+	if err != nil {
+		return fmt.Errorf("create index health_plan_contract: %w", err)
+	}
+	return nil
+}
+
+// planItemsFromAct выводит пункты плана из акта: по мероприятию на каждую отметку
+// в заключении и по пункту на рекомендацию каждого специалиста
+func planItemsFromAct(act *FinalAct) []HealthPlanItem {
+	base, err := time.Parse("2006-01-02", act.ActDate)
+	if err != nil {
+		base = time.Now()
+	}
+	deadline := func(measure string) *string {
+		d := base.AddDate(0, 0, planMeasureDefaults[measure].Days).Format("2006-01-02")
+		return &d
+	}
+
+	var items []HealthPlanItem
+	for _, w := range act.recommendationWorkers() {
+		item := func(measure, key, description, source string) HealthPlanItem {
+			return HealthPlanItem{
+				ContractID:   act.ContractID,
+				EmployeeID:   w.EmployeeID,
+				EmployeeName: w.Name,
+				Site:         w.Site,
+				Position:     w.Position,
+				Measure:      measure,
+				ItemKey:      key,
+				Description:  description,
+				Source:       source,
+				Deadline:     deadline(measure),
+				Responsible:  planMeasureDefaults[measure].Responsible,
+				Status:       PlanItemPlanned,
+			}
+		}
+		for _, m := range actMeasures {
+			if w.Measures[m.Key] {
+				items = append(items, item(m.Key, m.Key, m.Title, "conclusion"))
+			}
+		}
+		// Непригодным без отметок о мероприятиях - перевод на другую работу
+		if (w.Outcome == ActOutcomeTempUnfit || w.Outcome == ActOutcomePermUnfit) && !w.Measures["transferToOtherWork"] {
+			items = append(items, item("transferToOtherWork", "transferToOtherWork", "перевод на другую работу по состоянию здоровья", "conclusion"))
+		}
+		for _, rec := range w.Recommendations {
+			items = append(items, item("specialist", "specialist:"+rec.label(), rec.label()+": "+rec.Text, "specialist"))
+		}
+	}
+	return items
+}
+
+const healthPlanColumns = `id, contract_id, employee_id, employee_name, COALESCE(site, ''), COALESCE(position, ''),
+       measure, item_key, description, source, deadline, responsible, status, completed_at,
+       COALESCE(completion_note, ''), COALESCE(updated_by, ''), updated_at`
+
+func scanHealthPlanItem(row pgx.Row) (*HealthPlanItem, error) {
+	var it HealthPlanItem
+	var deadline *time.Time
+	var completedAt *time.Time
+	var updatedAt time.Time
+	err := row.Scan(&it.ID, &it.ContractID, &it.EmployeeID, &it.EmployeeName, &it.Site, &it.Position,
+		&it.Measure, &it.ItemKey, &it.Description, &it.Source, &deadline, &it.Responsible, &it.Status, &completedAt,
+		&it.CompletionNote, &it.UpdatedBy, &updatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if deadline != nil {
+		s := deadline.Format("2006-01-02")
+		it.Deadline = &s
+	}
+	if completedAt != nil {
+		s := completedAt.Format(time.RFC3339)
+		it.CompletedAt = &s
+	}
+	it.UpdatedAt = updatedAt.Format(time.RFC3339)
+	return &it, nil
+}
+
+func loadHealthPlan(ctx context.Context, contractID int64) ([]HealthPlanItem, error) {
+	rows, err := db.Query(ctx, `
+SELECT `+healthPlanColumns+` FROM health_plan_items
+WHERE contract_id = $1
+ORDER BY COALESCE(site, ''), employee_name, employee_id, measure, item_key, id
+`, contractID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []HealthPlanItem{}
+	for rows.Next() {
+		it, err := scanHealthPlanItem(rows)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, *it)
+	}
+	return items, rows.Err()
+}
+
+// syncHealthPlan добавляет новые пункты и обновляет тексты нетронутых. Пункты, которых
+// больше нет в акте, удаляются, только если по ним ещё ничего не делали.
+func syncHealthPlan(ctx context.Context, act *FinalAct, userID string) (added, removed int, err error) {
+	items := planItemsFromAct(act)
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	keys := make([]string, 0, len(items))
+	for _, it := range items {
+		var inserted bool
+		err := tx.QueryRow(ctx, `
+INSERT INTO health_plan_items (contract_id, employee_id, employee_name, site, position, measure, item_key,
+                               description, source, deadline, responsible, updated_by)
+VALUES ($1, $2, $3, NULLIF($4, ''), NULLIF($5, ''), $6, $7, $8, $9, $10::text::date, $11, $12)
+ON CONFLICT (contract_id, employee_id, item_key) WHERE source <> 'manual' DO UPDATE SET
+  description = EXCLUDED.description,
+  employee_name = EXCLUDED.employee_name,
+  site = EXCLUDED.site,
+  position = EXCLUDED.position,
+  updated_at = NOW()
+WHERE health_plan_items.status = 'planned'
+RETURNING (xmax = 0)
+`, it.ContractID, it.EmployeeID, it.EmployeeName, it.Site, it.Position, it.Measure, it.ItemKey,
+			it.Description, it.Source, it.Deadline, it.Responsible, userID).Scan(&inserted)
+		if err != nil && err != pgx.ErrNoRows {
+			return 0, 0, err
+		}
+		if inserted {
+			added++
+		}
+		keys = append(keys, it.EmployeeID+"\x1f"+it.ItemKey)
+	}
+
+	tag, err := tx.Exec(ctx, `
+DELETE FROM health_plan_items
+WHERE contract_id = $1 AND source <> 'manual' AND status = 'planned'
+  AND NOT (employee_id || chr(31) || item_key = ANY($2))
+`, act.ContractID, keys)
+	if err != nil {
+		return 0, 0, err
+	}
+	removed = int(tag.RowsAffected())
+	return added, removed, tx.Commit(ctx)
+}
+
+type healthPlanProgress struct {
+	Total     int     `json:"total"`
+	Done      int     `json:"done"`
+	Cancelled int     `json:"cancelled"`
+	Overdue   int     `json:"overdue"`
+	Percent   float64 `json:"percent"`
+}
+
+func healthPlanSummary(items []HealthPlanItem, today string) (healthPlanProgress, map[string]*healthPlanProgress) {
+	var total healthPlanProgress
+	byMeasure := map[string]*healthPlanProgress{}
+	for _, it := range items {
+		m := byMeasure[it.Measure]
+		if m == nil {
+			m = &healthPlanProgress{}
+			byMeasure[it.Measure] = m
+		}
+		for _, p := range []*healthPlanProgress{&total, m} {
+			p.Total++
+			switch {
+			case it.Status == PlanItemDone:
+				p.Done++
+			case it.Status == PlanItemCancelled:
+				p.Cancelled++
+			case it.Deadline != nil && *it.Deadline < today:
+				p.Overdue++
+			}
+		}
+	}
+	total.Percent = percent(total.Done, total.Total-total.Cancelled)
+	for _, m := range byMeasure {
+		m.Percent = percent(m.Done, m.Total-m.Cancelled)
+	}
+	return total, byMeasure
+}
+
+// --- Печать ---
+
+// planMeasureTitle - название вида мероприятий; пустая строка для неизвестных
+func planMeasureTitle(measure string) string {
+	switch measure {
+	case "specialist":
+		return "рекомендации специалистов"
+	case "custom":
+		return "прочие мероприятия"
+	}
+	for _, m := range actMeasures {
+		if m.Key == measure {
+			return m.Title
+		}
+	}
+	return ""
+}
+
+var planStatusTitles = map[string]string{
+	PlanItemPlanned:    "запланировано",
+	PlanItemInProgress: "выполняется",
+	PlanItemDone:       "выполнено",
+	PlanItemCancelled:  "отменено",
+}
+
+// healthPlanContent - снимок плана для версии документа
+type healthPlanContent struct {
+	ContractID       int64              `json:"contractId"`
+	ContractNumber   string             `json:"contractNumber"`
+	OrganizationName string             `json:"organizationName"`
+	ClinicName       string             `json:"clinicName"`
+	ActDate          string             `json:"actDate"`
+	Progress         healthPlanProgress `json:"progress"`
+	Items            []HealthPlanItem   `json:"items"`
+}
+
+func buildHealthPlanReport(c *healthPlanContent) *reportDoc {
+	d := &reportDoc{footer: fmt.Sprintf("План оздоровления · договор № %s · %s", c.ContractNumber, c.OrganizationName)}
+	d.Title("План оздоровительных мероприятий")
+	d.Text("работников " + c.OrganizationName + " по результатам периодического медицинского осмотра")
+	d.Text(fmt.Sprintf("Договор № %s, заключительный акт от %s", c.ContractNumber, formatActDate(c.ActDate)))
+	d.Text("Медицинская организация: " + c.ClinicName)
+	d.Text(fmt.Sprintf("Выполнено мероприятий: %d из %d (%s)", c.Progress.Done, c.Progress.Total-c.Progress.Cancelled, formatPercent(c.Progress.Percent)))
+
+	// Группируем по видам мероприятий, как в разделе распределения плана
+	order := []string{}
+	for _, m := range actMeasures {
+		order = append(order, m.Key)
+	}
+	order = append(order, "specialist", "custom")
+	n := 0
+	for _, measure := range order {
+		var rows [][]string
+		for _, it := range c.Items {
+			if it.Measure != measure && !(measure == "custom" && planMeasureTitle(it.Measure) == "") {
+				continue
+			}
+			deadline := ""
+			if it.Deadline != nil {
+				deadline = formatActDate(*it.Deadline)
+			}
+			status := planStatusTitles[it.Status]
+			if it.CompletionNote != "" {
+				status += "\n" + it.CompletionNote
+			}
+			rows = append(rows, []string{strconv.Itoa(len(rows) + 1), it.EmployeeName, orDash(it.Position), it.Description, deadline, it.Responsible, status})
+		}
+		if len(rows) == 0 {
+			continue
+		}
+		n++
+		title := []rune(planMeasureTitle(measure))
+		d.Heading(fmt.Sprintf("%d. %s%s", n, strings.ToUpper(string(title[:1])), string(title[1:])))
+		d.Table([]float64{0.05, 0.18, 0.13, 0.24, 0.1, 0.16, 0.14},
+			[]string{"№", "Ф.И.О.", "Должность", "Мероприятие", "Срок", "Ответственный", "Отметка о выполнении"}, rows)
+	}
+	if n == 0 {
+		d.Text("Работников, нуждающихся в оздоровительных мероприятиях, не выявлено.")
+	}
+
+	d.Text("")
+	d.Text("Врач-профпатолог: ____________________")
+	d.Text("Представитель работодателя: ____________________")
+	return d
+}
+
+// --- HANDLERS ---
+
+// /api/contracts/{id}/health-plan[/generate | /items[/{itemId}] | /document]
+func healthPlanHandler(w http.ResponseWriter, r *http.Request, contractID int64, rest string) {
+	switch {
+	case rest == "" && r.Method == http.MethodGet:
+		getHealthPlanHandler(w, r, contractID)
+	case rest == "generate" && r.Method == http.MethodPost:
+		generateHealthPlanHandler(w, r, contractID)
+	case rest == "items" && r.Method == http.MethodPost:
+		createHealthPlanItemHandler(w, r, contractID)
+	case strings.HasPrefix(rest, "items/") && (r.Method == http.MethodPatch || r.Method == http.MethodDelete):
+		itemID, err := strconv.ParseInt(strings.TrimPrefix(rest, "items/"), 10, 64)
+		if err != nil || itemID <= 0 {
+			errorResponse(w, http.StatusBadRequest, "invalid item id")
+			return
+		}
+		if r.Method == http.MethodPatch {
+			updateHealthPlanItemHandler(w, r, contractID, itemID)
+		} else {
+			deleteHealthPlanItemHandler(w, r, contractID, itemID)
+		}
+	case rest == "document" && r.Method == http.MethodPost:
+		healthPlanDocumentHandler(w, r, contractID)
+	default:
+		errorResponse(w, http.StatusNotFound, "not found")
+	}
+}
+
+func notifyHealthPlan(parties *contractParties, contractID int64, data map[string]interface{}) {
+	data["contractId"] = contractID
+	broadcastToUsers(parties.userIDs(), "health_plan_updated", data)
+}
+
+// GET /api/contracts/{id}/health-plan
+func getHealthPlanHandler(w http.ResponseWriter, r *http.Request, contractID int64) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	if _, _, ok := authorizeContract(ctx, w, r, contractID, false); !ok {
+		return
+	}
+	items, err := loadHealthPlan(ctx, contractID)
+	if err != nil {
+		log.Printf("getHealthPlan error: %v", err)
+		errorResponse(w, http.StatusInternalServerError, "db error")
+		return
+	}
+	total, byMeasure := healthPlanSummary(items, time.Now().Format("2006-01-02"))
+	jsonResponse(w, http.StatusOK, map[string]any{
+		"contractId": contractID,
+		"items":      items,
+		"progress":   total,
+		"byMeasure":  byMeasure,
+	})
+}
+
+// POST /api/contracts/{id}/health-plan/generate - пункты по заключительному акту (клиника)
+func generateHealthPlanHandler(w http.ResponseWriter, r *http.Request, contractID int64) {
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	user, parties, ok := authorizeContract(ctx, w, r, contractID, true)
+	if !ok {
+		return
+	}
+	act, err := loadFinalAct(ctx, contractID)
+	if err != nil {
+		log.Printf("generateHealthPlan: load act %d: %v", contractID, err)
+		errorResponse(w, http.StatusInternalServerError, "db error")
+		return
+	}
+	added, removed, err := syncHealthPlan(ctx, act, user.ID)
+	if err != nil {
+		log.Printf("generateHealthPlan: sync %d: %v", contractID, err)
+		errorResponse(w, http.StatusInternalServerError, "db error")
+		return
+	}
+	items, err := loadHealthPlan(ctx, contractID)
+	if err != nil {
+		errorResponse(w, http.StatusInternalServerError, "db error")
+		return
+	}
+	notifyHealthPlan(parties, contractID, map[string]interface{}{"added": added, "removed": removed})
+	jsonResponse(w, http.StatusOK, map[string]any{"added": added, "removed": removed, "items": items})
+}
+
+type healthPlanItemInput struct {
+	EmployeeID     *string `json:"employeeId"`
+	EmployeeName   *string `json:"employeeName"`
+	Site           *string `json:"site"`
+	Position       *string `json:"position"`
+	Measure        *string `json:"measure"`
+	Description    *string `json:"description"`
+	Deadline       *string `json:"deadline"`
+	Responsible    *string `json:"responsible"`
+	Status         *string `json:"status"`
+	CompletionNote *string `json:"completionNote"`
+}
+
+func validPlanItemStatus(s string) bool {
+	_, ok := planStatusTitles[s]
+	return ok
+}
+
+func validPlanDeadline(s *string) bool {
+	if s == nil || *s == "" {
+		return true
+	}
+	_, err := time.Parse("2006-01-02", *s)
+	return err == nil
+}
+
+// POST /api/contracts/{id}/health-plan/items - пункт, добавленный вручную
+func createHealthPlanItemHandler(w http.ResponseWriter, r *http.Request, contractID int64) {
+	var in healthPlanItemInput
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		errorResponse(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+	if in.EmployeeName == nil || strings.TrimSpace(*in.EmployeeName) == "" || in.Description == nil || strings.TrimSpace(*in.Description) == "" {
+		errorResponse(w, http.StatusBadRequest, "employeeName and description are required")
+		return
+	}
+	if !validPlanDeadline(in.Deadline) {
+		errorResponse(w, http.StatusBadRequest, "deadline must be YYYY-MM-DD")
+		return
+	}
+	measure := "custom"
+	if in.Measure != nil && planMeasureTitle(*in.Measure) != "" {
+		measure = *in.Measure
+	}
+	str := func(p *string) string {
+		if p == nil {
+			return ""
+		}
+		return strings.TrimSpace(*p)
+	}
+	employeeID := str(in.EmployeeID)
+	if employeeID == "" {
+		employeeID = str(in.EmployeeName)
+	}
+	responsible := str(in.Responsible)
+	if responsible == "" {
+		responsible = planMeasureDefaults[measure].Responsible
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	user, parties, ok := authorizeContract(ctx, w, r, contractID, false)
+	if !ok {
+		return
+	}
+	it, err := scanHealthPlanItem(db.QueryRow(ctx, `
+INSERT INTO health_plan_items (contract_id, employee_id, employee_name, site, position, measure, item_key,
+                               description, source, deadline, responsible, updated_by)
+VALUES ($1, $2, $3, NULLIF($4, ''), NULLIF($5, ''), $6, $6, $7, 'manual', NULLIF($8, '')::date, $9, $10)
+RETURNING `+healthPlanColumns,
+		contractID, employeeID, str(in.EmployeeName), str(in.Site), str(in.Position), measure,
+		str(in.Description), str(in.Deadline), responsible, user.ID))
+	if err != nil {
+		log.Printf("createHealthPlanItem error: %v", err)
+		errorResponse(w, http.StatusInternalServerError, "db error")
+		return
+	}
+	notifyHealthPlan(parties, contractID, map[string]interface{}{"itemId": it.ID})
+	jsonResponse(w, http.StatusCreated, it)
+}
+
+// PATCH /api/contracts/{id}/health-plan/items/{itemId}
+// Сроки, ответственных и выполнение ведут обе стороны; текст мероприятия меняет только клиника.
+func updateHealthPlanItemHandler(w http.ResponseWriter, r *http.Request, contractID, itemID int64) {
+	var in healthPlanItemInput
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		errorResponse(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+	if in.Status != nil && !validPlanItemStatus(*in.Status) {
+		errorResponse(w, http.StatusBadRequest, "invalid status")
+		return
+	}
+	if !validPlanDeadline(in.Deadline) {
+		errorResponse(w, http.StatusBadRequest, "deadline must be YYYY-MM-DD")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	user, parties, ok := authorizeContract(ctx, w, r, contractID, false)
+	if !ok {
+		return
+	}
+	if in.Description != nil && !parties.isClinicSide(user) {
+		errorResponse(w, http.StatusForbidden, "only the clinic can change the measure")
+		return
+	}
+
+	it, err := scanHealthPlanItem(db.QueryRow(ctx, `
+UPDATE health_plan_items SET
+  description = COALESCE($3, description),
+  deadline = CASE WHEN $4::text IS NULL THEN deadline ELSE NULLIF($4, '')::date END,
+  responsible = COALESCE($5, responsible),
+  status = COALESCE($6, status),
+  completed_at = CASE
+    WHEN $6 = 'done' AND status <> 'done' THEN NOW()
+    WHEN $6 IS NOT NULL AND $6 <> 'done' THEN NULL
+    ELSE completed_at END,
+  completion_note = COALESCE($7, completion_note),
+  updated_by = $8,
+  updated_at = NOW()
+WHERE id = $1 AND contract_id = $2
+RETURNING `+healthPlanColumns,
+		itemID, contractID, in.Description, in.Deadline, in.Responsible, in.Status, in.CompletionNote, user.ID))
+	if err == pgx.ErrNoRows {
+		errorResponse(w, http.StatusNotFound, "item not found")
+		return
+	}
+	if err != nil {
+		log.Printf("updateHealthPlanItem error: %v", err)
+		errorResponse(w, http.StatusInternalServerError, "db error")
+		return
+	}
+	notifyHealthPlan(parties, contractID, map[string]interface{}{"itemId": it.ID, "status": it.Status})
+	jsonResponse(w, http.StatusOK, it)
+}
+
+// DELETE /api/contracts/{id}/health-plan/items/{itemId} - только пункты, добавленные вручную;
+// сгенерированные отменяются статусом cancelled
+func deleteHealthPlanItemHandler(w http.ResponseWriter, r *http.Request, contractID, itemID int64) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	_, parties, ok := authorizeContract(ctx, w, r, contractID, false)
+	if !ok {
+		return
+	}
+	var source string
+	err := db.QueryRow(ctx, `SELECT source FROM health_plan_items WHERE id = $1 AND contract_id = $2`, itemID, contractID).Scan(&source)
+	if err != nil {
+		errorResponse(w, http.StatusNotFound, "item not found")
+		return
+	}
+	if source != "manual" {
+		errorResponse(w, http.StatusConflict, "generated items cannot be deleted, cancel them instead")
+		return
+	}
+	if _, err := db.Exec(ctx, `DELETE FROM health_plan_items WHERE id = $1`, itemID); err != nil {
+		errorResponse(w, http.StatusInternalServerError, "db error")
+		return
+	}
+	notifyHealthPlan(parties, contractID, map[string]interface{}{"itemId": itemID, "deleted": true})
+	jsonResponse(w, http.StatusOK, map[string]string{"status": "deleted"})
+}
+
+// POST /api/contracts/{id}/health-plan/document - печатная версия плана (PDF/DOCX) в документы договора
+func healthPlanDocumentHandler(w http.ResponseWriter, r *http.Request, contractID int64) {
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	user, parties, ok := authorizeContract(ctx, w, r, contractID, false)
+	if !ok {
+		return
+	}
+	c := &healthPlanContent{ContractID: contractID}
+	var actDate *string
+	err := db.QueryRow(ctx, `
+SELECT c.number, c.client_name, c.clinic_name,
+       (SELECT content->>'actDate' FROM contract_documents
+        WHERE contract_id = c.id AND doc_type = $2 ORDER BY version DESC LIMIT 1)
+FROM contracts c WHERE c.id = $1
+`, contractID, ContractDocFinalAct).Scan(&c.ContractNumber, &c.OrganizationName, &c.ClinicName, &actDate)
+	if err != nil {
+		errorResponse(w, http.StatusNotFound, "contract not found")
+		return
+	}
+	if actDate != nil {
+		c.ActDate = *actDate
+	}
+	items, err := loadHealthPlan(ctx, contractID)
+	if err != nil {
+		errorResponse(w, http.StatusInternalServerError, "db error")
+		return
+	}
+	// updatedAt/updatedBy в снимок не попадают: версия меняется только при изменении сути плана
+	for i := range items {
+		items[i].UpdatedAt, items[i].UpdatedBy = "", ""
+	}
+	c.Items = items
+	// Просрочка зависит от даты печати, поэтому в снимке не считается
+	c.Progress, _ = healthPlanSummary(items, "")
+
+	regular, bold, err := loadPDFFonts()
+	if err != nil {
+		log.Printf("healthPlan: font error: %v", err)
+		errorResponse(w, http.StatusInternalServerError, "pdf font is not available")
+		return
+	}
+	report := buildHealthPlanReport(c)
+	doc, err := saveContractDocument(ctx, contractID, ContractDocHealthPlan, c, report.PDF(regular, bold), report.DOCX(), user.ID)
+	if err != nil {
+		log.Printf("healthPlan: save contract %d: %v", contractID, err)
+		errorResponse(w, http.StatusInternalServerError, "save error")
+		return
+	}
+	if doc.Unchanged {
+		jsonResponse(w, http.StatusOK, doc)
+		return
+	}
+	broadcastToUsers(parties.userIDs(), "contract_document_created", map[string]interface{}{
+		"contractId": contractID,
+		"documentId": doc.ID,
+		"type":       doc.DocType,
+		"version":    doc.Version,
+	})
+	jsonResponse(w, http.StatusCreated, doc)
+}
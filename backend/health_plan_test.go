@@ -0,0 +1,66 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPlanItemsFromActKeepsSpecialtyAndText(t *testing.T) {
+	w := FinalActWorker{EmployeeID: "emp-a", Name: "Иванов Иван", Position: "машинист"}
+	final := map[string]any{
+		"isFit":           true,
+		"restrictions":    "работа на высоте: не более 4 часов",
+		"recommendations": "Режим: сон не менее 8 часов",
+	}
+	spec := map[string]map[string]any{
+		"Терапевт":     {"recommendations": "Контроль АД: ежедневно"},
+		"Окулист":      {"recommendations": "очки"},
+		"Невропатолог": {},
+	}
+	classifyWorker(&w, final, spec)
+
+	wantRecs := []actRecommendation{
+		{Source: RecommendationRestrictions, Text: "работа на высоте: не более 4 часов"},
+		{Source: RecommendationChairman, Text: "Режим: сон не менее 8 часов"},
+		{Source: RecommendationSpecialist, Specialty: "Окулист", Text: "очки"},
+		{Source: RecommendationSpecialist, Specialty: "Терапевт", Text: "Контроль АД: ежедневно"},
+	}
+	if !reflect.DeepEqual(w.Recommendations, wantRecs) {
+		t.Fatalf("recommendations = %+v", w.Recommendations)
+	}
+
+	act := &FinalAct{ContractID: 1, ActDate: "2026-03-02", Workers: []FinalActWorker{w}}
+	items := planItemsFromAct(act)
+	type row struct{ Key, Description string }
+	var got []row
+	for _, it := range items {
+		if it.Source != "specialist" || it.EmployeeID != "emp-a" || it.Status != PlanItemPlanned {
+			t.Errorf("unexpected item %+v", it)
+		}
+		got = append(got, row{it.ItemKey, it.Description})
+	}
+	want := []row{
+		{"specialist:Ограничения", "Ограничения: работа на высоте: не более 4 часов"},
+		{"specialist:Председатель комиссии", "Председатель комиссии: Режим: сон не менее 8 часов"},
+		{"specialist:Окулист", "Окулист: очки"},
+		{"specialist:Терапевт", "Терапевт: Контроль АД: ежедневно"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("plan items = %+v", got)
+	}
+}
+
+func TestActRecommendationString(t *testing.T) {
+	for _, tc := range []struct {
+		r    actRecommendation
+		want string
+	}{
+		{actRecommendation{Source: RecommendationChairman, Text: "Режим: щадящий"}, "Режим: щадящий"},
+		{actRecommendation{Source: RecommendationRestrictions, Text: "без ночных смен"}, "Ограничения: без ночных смен"},
+		{actRecommendation{Source: RecommendationSpecialist, Specialty: "ЛОР", Text: "беруши"}, "ЛОР: беруши"},
+	} {
+		if got := tc.r.String(); got != tc.want {
+			t.Errorf("%+v: %q, want %q", tc.r, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,789 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// --- HL7 v2 ORU^R01 (результаты анализаторов по MLLP и HTTP) ---
+
+// MLLP-обрамление: <VT> сообщение <FS><CR>
+const (
+	mllpStart    = 0x0b
+	mllpEnd      = 0x1c
+	mllpCR       = 0x0d
+	mllpMaxFrame = 1 << 20
+)
+
+// Коды подтверждения (MSA-1)
+const (
+	hl7AckAccept = "AA" // принято
+	hl7AckError  = "AE" // ошибка обработки
+	hl7AckReject = "AR" // отклонено (неверный формат/тип)
+)
+
+var (
+	errMLLPFrameTooLarge = errors.New("mllp frame too large")
+	errHL7Duplicate      = errors.New("duplicate message")
+)
+
+// hl7Message - разобранное сообщение HL7 v2 (ER7)
+type hl7Message struct {
+	fieldSep, compSep, repSep, escChar, subSep byte
+	segments                                   [][]string
+}
+
+// parseHL7 разбирает сообщение; сегменты разделяются \r (допускаются \n и \r\n)
+func parseHL7(raw []byte) (*hl7Message, error) {
+	text := strings.ReplaceAll(string(raw), "\r\n", "\r")
+	text = strings.ReplaceAll(text, "\n", "\r")
+	text = strings.Trim(text, "\r \t\x0b\x1c")
+	if !strings.HasPrefix(text, "MSH") || len(text) < 8 {
+		return nil, errors.New("message must start with MSH segment")
+	}
+
+	m := &hl7Message{
+		fieldSep: text[3],
+		compSep:  text[4],
+		repSep:   text[5],
+		escChar:  text[6],
+		subSep:   text[7],
+	}
+	for _, line := range strings.Split(text, "\r") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		m.segments = append(m.segments, strings.Split(line, string(m.fieldSep)))
+	}
+	return m, nil
+}
+
+// field возвращает поле по номеру HL7 (MSH-1 - сам разделитель полей)
+func (m *hl7Message) field(seg []string, n int) string {
+	idx := n
+	if len(seg) > 0 && seg[0] == "MSH" {
+		if n == 1 {
+			return string(m.fieldSep)
+		}
+		idx = n - 1
+	}
+	if idx <= 0 || idx >= len(seg) {
+		return ""
+	}
+	return seg[idx]
+}
+
+// component возвращает компонент поля (нумерация с 1) без escape-последовательностей
+func (m *hl7Message) component(value string, n int) string {
+	parts := strings.Split(value, string(m.compSep))
+	if n <= 0 || n > len(parts) {
+		return ""
+	}
+	return m.unescape(parts[n-1])
+}
+
+func (m *hl7Message) repetitions(value string) []string {
+	if value == "" {
+		return nil
+	}
+	return strings.Split(value, string(m.repSep))
+}
+
+func (m *hl7Message) unescape(s string) string {
+	if !strings.ContainsRune(s, rune(m.escChar)) {
+		return s
+	}
+	esc := string(m.escChar)
+	r := strings.NewReplacer(
+		esc+"F"+esc, string(m.fieldSep),
+		esc+"S"+esc, string(m.compSep),
+		esc+"R"+esc, string(m.repSep),
+		esc+"T"+esc, string(m.subSep),
+		esc+"E"+esc, esc,
+		esc+".br"+esc, "\n",
+	)
+	return r.Replace(s)
+}
+
+func (m *hl7Message) segment(name string) []string {
+	for _, seg := range m.segments {
+		if seg[0] == name {
+			return seg
+		}
+	}
+	return nil
+}
+
+func (m *hl7Message) messageType() string {
+	msh := m.segment("MSH")
+	v := m.field(msh, 9)
+	return m.component(v, 1) + "^" + m.component(v, 2)
+}
+
+func (m *hl7Message) controlID() string {
+	return m.field(m.segment("MSH"), 10)
+}
+
+// parseHL7Time разбирает YYYY[MM[DD[HH[MM[SS]]]]][.S][+ZZZZ]
+func parseHL7Time(s string) (time.Time, bool) {
+	if i := strings.IndexAny(s, "+-"); i > 0 {
+		s = s[:i]
+	}
+	if i := strings.IndexByte(s, '.'); i > 0 {
+		s = s[:i]
+	}
+	layouts := map[int]string{14: "20060102150405", 12: "200601021504", 10: "2006010215", 8: "20060102"}
+	layout, ok := layouts[len(s)]
+	if !ok {
+		return time.Time{}, false
+	}
+	t, err := time.ParseInLocation(layout, s, time.Local)
+	return t, err == nil
+}
+
+// hl7Observation - результат из сегмента OBX
+type hl7Observation struct {
+	Code            string
+	Name            string
+	ValueType       string
+	Value           string
+	Unit            string
+	RefRange        string
+	Flags           string
+	Status          string
+	ObservedAt      time.Time
+	SpecimenBarcode string
+}
+
+// hl7Result - данные ORU^R01, нужные для записи в карту
+type hl7Result struct {
+	Sender          string // MSH-3/MSH-4: анализатор и лаборатория
+	ControlID       string // MSH-10, уникален в пределах отправителя
+	IIN             string
+	PatientID       string
+	SpecimenBarcode string
+	Observations    []hl7Observation
+}
+
+// isIIN - ИИН Казахстана: 12 цифр
+func isIIN(s string) bool {
+	if len(s) != 12 {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// extractORU достаёт пациента, штрихкоды образцов и OBX из ORU^R01
+func extractORU(m *hl7Message) (*hl7Result, error) {
+	if t := m.messageType(); t != "ORU^R01" {
+		return nil, fmt.Errorf("unsupported message type %s", t)
+	}
+
+	msh := m.segment("MSH")
+	res := &hl7Result{
+		Sender:    m.component(m.field(msh, 3), 1) + "/" + m.component(m.field(msh, 4), 1),
+		ControlID: m.controlID(),
+	}
+	var currentBarcode string
+	for _, seg := range m.segments {
+		switch seg[0] {
+		case "PID":
+			// PID-3 (список идентификаторов), затем PID-2 и PID-4 для старых анализаторов
+			for _, n := range []int{3, 2, 4} {
+				for _, rep := range m.repetitions(m.field(seg, n)) {
+					id := m.component(rep, 1)
+					idType := strings.ToUpper(m.component(rep, 5))
+					if res.PatientID == "" {
+						res.PatientID = id
+					}
+					if res.IIN == "" && (idType == "IIN" || isIIN(id)) {
+						res.IIN = id
+					}
+				}
+			}
+		case "OBR":
+			// Штрихкод образца: OBR-3 (filler order number) или OBR-2 (placer)
+			currentBarcode = m.component(m.field(seg, 3), 1)
+			if currentBarcode == "" {
+				currentBarcode = m.component(m.field(seg, 2), 1)
+			}
+			if res.SpecimenBarcode == "" {
+				res.SpecimenBarcode = currentBarcode
+			}
+		case "SPM":
+			if id := m.component(m.field(seg, 2), 1); id != "" {
+				currentBarcode = id
+				res.SpecimenBarcode = id
+			}
+		case "OBX":
+			obsID := m.field(seg, 3)
+			obx := hl7Observation{
+				ValueType:       m.field(seg, 2),
+				Code:            m.component(obsID, 1),
+				Name:            m.component(obsID, 2),
+				Value:           m.unescape(m.field(seg, 5)),
+				Unit:            m.component(m.field(seg, 6), 1),
+				RefRange:        m.unescape(m.field(seg, 7)),
+				Flags:           m.field(seg, 8),
+				Status:          m.field(seg, 11),
+				SpecimenBarcode: currentBarcode,
+			}
+			if t, ok := parseHL7Time(m.field(seg, 14)); ok {
+				obx.ObservedAt = t
+			}
+			if obx.Code == "" {
+				continue
+			}
+			res.Observations = append(res.Observations, obx)
+		}
+	}
+
+	if res.IIN == "" && res.SpecimenBarcode == "" {
+		return nil, errors.New("message has neither IIN nor specimen barcode")
+	}
+	if len(res.Observations) == 0 {
+		return nil, errors.New("message has no OBX segments")
+	}
+	return res, nil
+}
+
+// buildHL7ACK формирует ACK на сообщение (req может быть nil, если его не удалось разобрать)
+func buildHL7ACK(req *hl7Message, code, text string, now time.Time) []byte {
+	sendingApp, sendingFacility, controlID, version := "", "", "", "2.5"
+	trigger := ""
+	if req != nil {
+		msh := req.segment("MSH")
+		sendingApp = req.field(msh, 3)
+		sendingFacility = req.field(msh, 4)
+		controlID = req.controlID()
+		trigger = req.component(req.field(msh, 9), 2)
+		if v := req.field(msh, 12); v != "" {
+			version = v
+		}
+	}
+	msgType := "ACK"
+	if trigger != "" {
+		msgType += "^" + trigger
+	}
+	text = strings.NewReplacer("|", " ", "^", " ", "~", " ", "\\", " ", "&", " ", "\r", " ", "\n", " ").Replace(text)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "MSH|^~\\&|MEDFLOW|MEDFLOW|%s|%s|%s||%s|ACK%d|P|%s\r",
+		sendingApp, sendingFacility, now.Format("20060102150405"), msgType, now.UnixNano(), version)
+	fmt.Fprintf(&b, "MSA|%s|%s", code, controlID)
+	if text != "" {
+		fmt.Fprintf(&b, "|%s", text)
+	}
+	b.WriteString("\r")
+	return []byte(b.String())
+}
+
+// readMLLPFrame читает одно сообщение между <VT> и <FS><CR>
+func readMLLPFrame(r *bufio.Reader) ([]byte, error) {
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		if b == mllpStart {
+			break
+		}
+	}
+
+	var buf bytes.Buffer
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		if b == mllpEnd {
+			next, err := r.ReadByte()
+			if err != nil {
+				return nil, err
+			}
+			if next == mllpCR {
+				return buf.Bytes(), nil
+			}
+			buf.WriteByte(b)
+			b = next
+		}
+		buf.WriteByte(b)
+		if buf.Len() > mllpMaxFrame {
+			return nil, errMLLPFrameTooLarge
+		}
+	}
+}
+
+func writeMLLPFrame(w io.Writer, msg []byte) error {
+	frame := make([]byte, 0, len(msg)+3)
+	frame = append(frame, mllpStart)
+	frame = append(frame, msg...)
+	frame = append(frame, mllpEnd, mllpCR)
+	_, err := w.Write(frame)
+	return err
+}
+
+// hl7Handler обрабатывает одно сообщение и возвращает ACK
+type hl7Handler func(ctx context.Context, raw []byte) []byte
+
+// serveMLLP принимает TCP-соединения анализаторов до закрытия listener'а;
+// соединения с адресов не из списка разрешённых сразу закрываются
+func serveMLLP(ln net.Listener, access *hl7Access, handle hl7Handler) error {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		if !access.allowsAddr(conn.RemoteAddr().String()) {
+			log.Printf("MLLP %s: address not allowed", conn.RemoteAddr())
+			conn.Close()
+			continue
+		}
+		go handleMLLPConn(conn, handle)
+	}
+}
+
+func handleMLLPConn(conn net.Conn, handle hl7Handler) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		conn.SetReadDeadline(time.Now().Add(10 * time.Minute))
+		msg, err := readMLLPFrame(r)
+		if err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				log.Printf("MLLP %s: read error: %v", conn.RemoteAddr(), err)
+			}
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		ack := handle(ctx, msg)
+		cancel()
+
+		conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+		if err := writeMLLPFrame(conn, ack); err != nil {
+			log.Printf("MLLP %s: write error: %v", conn.RemoteAddr(), err)
+			return
+		}
+	}
+}
+
+// --- Запись результатов в БД ---
+
+func migrateHL7(ctx context.Context, tx pgx.Tx) error {
+	_, err := tx.Exec(ctx, `
+CREATE TABLE IF NOT EXISTS lab_test_aliases (
+  alias     TEXT PRIMARY KEY,
+  test_code TEXT NOT NULL REFERENCES lab_tests(code) ON DELETE CASCADE
+);
+`)
+	if err != nil {
+		return fmt.Errorf("migrate lab_test_aliases: %w", err)
+	}
+
+	_, err = tx.Exec(ctx, `
+CREATE TABLE IF NOT EXISTS lab_specimens (
+  barcode     TEXT PRIMARY KEY,
+  episode_id  INTEGER NOT NULL REFERENCES exam_episodes(id) ON DELETE CASCADE,
+  patient_uid TEXT NOT NULL,
+  created_at  TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+`)
+	if err != nil {
+		return fmt.Errorf("migrate lab_specimens: %w", err)
+	}
+
+	_, err = tx.Exec(ctx, `
+CREATE TABLE IF NOT EXISTS hl7_messages (
+  id          SERIAL PRIMARY KEY,
+  control_id  TEXT,
+  channel     TEXT NOT NULL,
+  ack_code    TEXT NOT NULL,
+  error       TEXT,
+  patient_uid TEXT,
+  episode_id  INTEGER,
+  raw         TEXT NOT NULL,
+  received_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+`)
+	if err != nil {
+		return fmt.Errorf("migrate hl7_messages: %w", err)
+	}
+
+	// Принятые сообщения по MSH-10: повторная отправка того же сообщения не дублирует результаты
+	_, err = tx.Exec(ctx, `
+CREATE TABLE IF NOT EXISTS hl7_processed (
+  sender      TEXT NOT NULL,
+  control_id  TEXT NOT NULL,
+  episode_id  INTEGER,
+  received_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  PRIMARY KEY (sender, control_id)
+);
+`)
+	if err != nil {
+		return fmt.Errorf("migrate hl7_processed: %w", err)
+	}
+
+	_, _ = tx.Exec(ctx, `ALTER TABLE lab_observations ADD COLUMN IF NOT EXISTS specimen_barcode TEXT;`)
+
+	// Коды анализаторов и LOINC -> каталог
+	aliases := map[string]string{
+		"HGB": "hemoglobin", "718-7": "hemoglobin",
+		"RBC": "erythrocytes", "789-8": "erythrocytes",
+		"WBC": "leukocytes", "6690-2": "leukocytes",
+		"PLT": "platelets", "777-3": "platelets",
+		"ESR": "esr", "4537-7": "esr",
+		"GLU": "glucose-blood", "2345-7": "glucose-blood",
+		"CHOL": "total-cholesterol", "2093-3": "total-cholesterol",
+		"ALT": "alt", "1742-6": "alt",
+		"AST": "ast", "1920-8": "ast",
+		"CREA": "creatinine", "2160-0": "creatinine",
+		"U-PRO": "protein", "2888-6": "protein",
+		"U-GLU": "glucose", "2350-7": "glucose",
+	}
+	for alias, code := range aliases {
+		_, err = tx.Exec(ctx, `INSERT INTO lab_test_aliases (alias, test_code) VALUES ($1, $2) ON CONFLICT (alias) DO NOTHING`, alias, code)
+		if err != nil {
+			return fmt.Errorf("seed lab_test_aliases: %w", err)
+		}
+	}
+	return nil
+}
+
+// resolveLabTestCode сопоставляет код OBX-3 с каталогом (алиас анализатора, LOINC или код каталога)
+func resolveLabTestCode(ctx context.Context, q dbExecutor, code string) (string, bool) {
+	var testCode string
+	err := q.QueryRow(ctx, `
+SELECT test_code FROM lab_test_aliases WHERE UPPER(alias) = UPPER($1)
+UNION ALL
+SELECT code FROM lab_tests WHERE LOWER(code) = LOWER($1)
+LIMIT 1
+`, code).Scan(&testCode)
+	return testCode, err == nil
+}
+
+// resolveHL7Episode находит пациента и активный эпизод: сначала по штрихкоду образца, затем по ИИН
+func resolveHL7Episode(ctx context.Context, q dbExecutor, res *hl7Result) (string, int64, error) {
+	if res.SpecimenBarcode != "" {
+		var patientUID string
+		var episodeID int64
+		err := q.QueryRow(ctx, `SELECT patient_uid, episode_id FROM lab_specimens WHERE barcode = $1`, res.SpecimenBarcode).Scan(&patientUID, &episodeID)
+		if err == nil {
+			return patientUID, episodeID, nil
+		}
+	}
+	if res.IIN == "" {
+		return "", 0, fmt.Errorf("unknown specimen barcode %s", res.SpecimenBarcode)
+	}
+
+	var patientUID string
+	err := q.QueryRow(ctx, `SELECT patient_uid FROM ambulatory_cards WHERE iin = $1 ORDER BY updated_at DESC LIMIT 1`, res.IIN).Scan(&patientUID)
+	if err != nil {
+		return "", 0, fmt.Errorf("no patient with IIN %s", res.IIN)
+	}
+	episodeID, found, err := writableEpisodeID(ctx, q, patientUID, "", false)
+	if err != nil || !found {
+		return "", 0, fmt.Errorf("no active episode for IIN %s", res.IIN)
+	}
+	return patientUID, episodeID, nil
+}
+
+// ingestORU записывает результаты в активный эпизод одной транзакцией.
+// Неизвестные коды пропускаются и возвращаются как предупреждения.
+// Сообщение с уже принятым MSH-10 того же отправителя не записывается (errHL7Duplicate):
+// анализатор повторяет отправку, если не дождался ACK.
+func ingestORU(ctx context.Context, res *hl7Result) (string, int64, []string, error) {
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return "", 0, nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	// Отметка берётся первой: параллельный повтор ждёт коммита этой транзакции,
+	// а при откате сообщение можно прислать заново
+	if res.ControlID != "" {
+		tag, err := tx.Exec(ctx, `
+INSERT INTO hl7_processed (sender, control_id) VALUES ($1, $2)
+ON CONFLICT (sender, control_id) DO NOTHING
+`, res.Sender, res.ControlID)
+		if err != nil {
+			return "", 0, nil, err
+		}
+		if tag.RowsAffected() == 0 {
+			var episodeID int64
+			_ = tx.QueryRow(ctx, `SELECT COALESCE(episode_id, 0) FROM hl7_processed WHERE sender = $1 AND control_id = $2`,
+				res.Sender, res.ControlID).Scan(&episodeID)
+			return "", episodeID, nil, errHL7Duplicate
+		}
+	}
+
+	patientUID, episodeID, err := resolveHL7Episode(ctx, tx, res)
+	if err != nil {
+		return "", 0, nil, err
+	}
+
+	var locked bool
+	if err := tx.QueryRow(ctx, `SELECT locked FROM exam_episodes WHERE id = $1 FOR UPDATE`, episodeID).Scan(&locked); err != nil {
+		return patientUID, episodeID, nil, err
+	}
+	if locked {
+		return patientUID, episodeID, nil, errors.New("episode is locked by the final conclusion")
+	}
+
+	var warnings []string
+	stored := 0
+	for _, obx := range res.Observations {
+		// X - результат не получен, D - удалён
+		if obx.Status == "X" || obx.Status == "D" {
+			continue
+		}
+		code, ok := resolveLabTestCode(ctx, tx, obx.Code)
+		if !ok {
+			warnings = append(warnings, "unknown test "+obx.Code)
+			continue
+		}
+		in := LabResultInput{TestCode: code, Value: obx.Value, Unit: obx.Unit, SpecimenBarcode: obx.SpecimenBarcode}
+		if !obx.ObservedAt.IsZero() {
+			in.ObservedAt = obx.ObservedAt.Format(time.RFC3339)
+		}
+		if _, err := recordLabResult(ctx, tx, episodeID, patientUID, in, "hl7"); err != nil {
+			return patientUID, episodeID, nil, err
+		}
+		stored++
+	}
+	if stored == 0 {
+		return patientUID, episodeID, warnings, errors.New("no known observations in message")
+	}
+	if res.ControlID != "" {
+		_, err = tx.Exec(ctx, `UPDATE hl7_processed SET episode_id = $3 WHERE sender = $1 AND control_id = $2`,
+			res.Sender, res.ControlID, episodeID)
+		if err != nil {
+			return patientUID, episodeID, nil, err
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return patientUID, episodeID, nil, err
+	}
+	return patientUID, episodeID, warnings, nil
+}
+
+// handleORU - общий обработчик для MLLP и HTTP. token - секрет из заголовка HTTP;
+// если он пуст, секрет берётся из MSH-8 (Security).
+func handleORU(channel string, access *hl7Access, token string) hl7Handler {
+	return func(ctx context.Context, raw []byte) []byte {
+		now := time.Now()
+		msg, err := parseHL7(raw)
+		if err != nil {
+			logHL7Message(ctx, channel, nil, hl7AckReject, err.Error(), "", 0, raw)
+			return buildHL7ACK(nil, hl7AckReject, err.Error(), now)
+		}
+		// Секрет берётся из каждого сообщения: обработчик общий для всех соединений
+		secret := token
+		if secret == "" {
+			secret = msg.field(msg.segment("MSH"), 8)
+		}
+		if !access.allowsSecret(secret) {
+			// Сообщения без секрета в журнал не пишутся
+			log.Printf("HL7 %s: message %s rejected: invalid secret", channel, msg.controlID())
+			return buildHL7ACK(msg, hl7AckReject, "not authorized", now)
+		}
+
+		res, err := extractORU(msg)
+		if err != nil {
+			logHL7Message(ctx, channel, msg, hl7AckReject, err.Error(), "", 0, raw)
+			return buildHL7ACK(msg, hl7AckReject, err.Error(), now)
+		}
+
+		patientUID, episodeID, warnings, err := ingestORU(ctx, res)
+		if errors.Is(err, errHL7Duplicate) {
+			// Повтор уже принятого сообщения подтверждается так же, как оригинал
+			text := "duplicate of message " + res.ControlID + ", already accepted"
+			logHL7Message(ctx, channel, msg, hl7AckAccept, text, "", episodeID, raw)
+			return buildHL7ACK(msg, hl7AckAccept, text, now)
+		}
+		if err != nil {
+			log.Printf("HL7 %s: message %s rejected: %v", channel, msg.controlID(), err)
+			logHL7Message(ctx, channel, msg, hl7AckError, err.Error(), patientUID, episodeID, raw)
+			return buildHL7ACK(msg, hl7AckError, err.Error(), now)
+		}
+
+		text := strings.Join(warnings, "; ")
+		logHL7Message(ctx, channel, msg, hl7AckAccept, text, patientUID, episodeID, raw)
+		checkEmergencyNotifications(ctx, episodeID)
+		broadcastToUser(patientUID, "visit_updated", map[string]interface{}{
+			"employeeId": patientUID,
+			"episodeId":  episodeID,
+			"source":     "hl7",
+		})
+		return buildHL7ACK(msg, hl7AckAccept, text, now)
+	}
+}
+
+func logHL7Message(ctx context.Context, channel string, msg *hl7Message, ackCode, errText, patientUID string, episodeID int64, raw []byte) {
+	controlID := ""
+	if msg != nil {
+		controlID = msg.controlID()
+	}
+	_, err := db.Exec(ctx, `
+INSERT INTO hl7_messages (control_id, channel, ack_code, error, patient_uid, episode_id, raw)
+VALUES (NULLIF($1, ''), $2, $3, NULLIF($4, ''), NULLIF($5, ''), NULLIF($6, 0), $7)
+`, controlID, channel, ackCode, errText, patientUID, episodeID, string(raw))
+	if err != nil {
+		log.Printf("HL7: log message error: %v", err)
+	}
+}
+
+// POST /api/hl7/oru - тело: сообщение ER7, ответ: ACK.
+// Секрет - в заголовке Authorization: Bearer <HL7_SHARED_SECRET> или в MSH-8.
+func hl7HTTPHandler(w http.ResponseWriter, r *http.Request) {
+	if !hl7Auth.enabled() {
+		errorResponse(w, http.StatusServiceUnavailable, "hl7 intake is not configured")
+		return
+	}
+	if !hl7Auth.allowsAddr(r.RemoteAddr) {
+		errorResponse(w, http.StatusForbidden, "address not allowed")
+		return
+	}
+	raw, err := io.ReadAll(io.LimitReader(r.Body, mllpMaxFrame+1))
+	if err != nil || len(raw) == 0 {
+		errorResponse(w, http.StatusBadRequest, "empty message")
+		return
+	}
+	if len(raw) > mllpMaxFrame {
+		errorResponse(w, http.StatusRequestEntityTooLarge, "message too large")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	ack := handleORU("http", hl7Auth, strings.TrimSpace(token))(ctx, raw)
+	w.Header().Set("Content-Type", "x-application/hl7-v2+er7")
+	w.WriteHeader(http.StatusOK)
+	w.Write(ack)
+}
+
+// startMLLPListener поднимает MLLP-порт для анализаторов (HL7_MLLP_ADDR, "off" - выключено)
+func startMLLPListener() {
+	addr := mustGetEnv("HL7_MLLP_ADDR", ":2575")
+	if addr == "off" {
+		return
+	}
+	if !hl7Auth.enabled() {
+		log.Printf("HL7 MLLP listener disabled: set HL7_SHARED_SECRET or HL7_ALLOWED_IPS")
+		return
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Printf("HL7 MLLP listener disabled: %v", err)
+		return
+	}
+	log.Printf("HL7 MLLP listening on %s", addr)
+	go func() {
+		if err := serveMLLP(ln, hl7Auth, handleORU("mllp", hl7Auth, "")); err != nil {
+			log.Printf("HL7 MLLP server error: %v", err)
+		}
+	}()
+}
+
+// --- Доступ ---
+//
+// Результаты принимаются только от своих анализаторов: HL7_SHARED_SECRET (секрет в MSH-8
+// или в заголовке Authorization для HTTP) и/или HL7_ALLOWED_IPS (адреса и подсети через
+// запятую). Заданные проверки должны пройти обе; без обеих приём выключен.
+
+type hl7Access struct {
+	secret  []byte
+	allowed []netip.Prefix
+}
+
+var hl7Auth *hl7Access
+
+func newHL7AccessFromEnv() (*hl7Access, error) {
+	return loadHL7Access(os.Getenv)
+}
+
+func loadHL7Access(getenv func(string) string) (*hl7Access, error) {
+	a := &hl7Access{}
+	if s := getenv("HL7_SHARED_SECRET"); s != "" {
+		if len(s) < 16 {
+			return nil, errors.New("HL7_SHARED_SECRET must be at least 16 bytes")
+		}
+		a.secret = []byte(s)
+	}
+	for _, item := range strings.Split(getenv("HL7_ALLOWED_IPS"), ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		p, err := netip.ParsePrefix(item)
+		if err != nil {
+			addr, aerr := netip.ParseAddr(item)
+			if aerr != nil {
+				return nil, fmt.Errorf("HL7_ALLOWED_IPS: invalid address %q", item)
+			}
+			p = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		a.allowed = append(a.allowed, p.Masked())
+	}
+	return a, nil
+}
+
+func (a *hl7Access) enabled() bool {
+	return a != nil && (len(a.secret) > 0 || len(a.allowed) > 0)
+}
+
+// allowsAddr проверяет адрес вида host:port по списку HL7_ALLOWED_IPS
+func (a *hl7Access) allowsAddr(hostport string) bool {
+	if !a.enabled() {
+		return false
+	}
+	if len(a.allowed) == 0 {
+		return true
+	}
+	ap, err := netip.ParseAddrPort(hostport)
+	if err != nil {
+		return false
+	}
+	addr := ap.Addr().Unmap()
+	for _, p := range a.allowed {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *hl7Access) allowsSecret(token string) bool {
+	if !a.enabled() {
+		return false
+	}
+	if len(a.secret) == 0 {
+		return true
+	}
+	return subtle.ConstantTimeCompare([]byte(token), a.secret) == 1
+}
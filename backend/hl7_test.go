@@ -0,0 +1,333 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const sampleORU = "MSH|^~\\&|BC-5150|LAB|MEDFLOW|CLINIC|20250301101500||ORU^R01|MSG0001|P|2.5\r" +
+	"PID|1||950101300123^^^KZ^IIN~A-77^^^LIS^PI||Иванов^Иван\r" +
+	"OBR|1|ORD-1|SP000123|CBC^Общий анализ крови\r" +
+	"OBX|1|NM|HGB^Hemoglobin^L||142|g/L|130-160|N|||F|||20250301101000\r" +
+	"OBX|2|NM|WBC^Leukocytes^L||11.2|10*9/L|4-9|H|||F\r" +
+	"OBX|3|ST|COMMENT^Note^L||see \\T\\ check||||||X\r"
+
+// mllpTestClient - локальный MLLP-клиент вместо анализатора
+type mllpTestClient struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func dialMLLP(t *testing.T, addr string) *mllpTestClient {
+	t.Helper()
+	conn, err := net.DialTimeout("tcp", addr, 2*time.Second)
+	if err != nil {
+		t.Fatalf("dial %s: %v", addr, err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &mllpTestClient{conn: conn, r: bufio.NewReader(conn)}
+}
+
+func (c *mllpTestClient) send(t *testing.T, msg string) *hl7Message {
+	t.Helper()
+	c.conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if err := writeMLLPFrame(c.conn, []byte(msg)); err != nil {
+		t.Fatalf("write frame: %v", err)
+	}
+	raw, err := readMLLPFrame(c.r)
+	if err != nil {
+		t.Fatalf("read ack: %v", err)
+	}
+	ack, err := parseHL7(raw)
+	if err != nil {
+		t.Fatalf("parse ack %q: %v", raw, err)
+	}
+	return ack
+}
+
+func TestExtractORU(t *testing.T) {
+	msg, err := parseHL7([]byte(sampleORU))
+	if err != nil {
+		t.Fatalf("parseHL7: %v", err)
+	}
+	if got := msg.controlID(); got != "MSG0001" {
+		t.Errorf("controlID = %q, want MSG0001", got)
+	}
+
+	res, err := extractORU(msg)
+	if err != nil {
+		t.Fatalf("extractORU: %v", err)
+	}
+	if res.IIN != "950101300123" {
+		t.Errorf("IIN = %q, want 950101300123", res.IIN)
+	}
+	if res.Sender != "BC-5150/LAB" || res.ControlID != "MSG0001" {
+		t.Errorf("sender = %q, control id = %q", res.Sender, res.ControlID)
+	}
+	if res.SpecimenBarcode != "SP000123" {
+		t.Errorf("SpecimenBarcode = %q, want SP000123", res.SpecimenBarcode)
+	}
+	if len(res.Observations) != 3 {
+		t.Fatalf("got %d observations, want 3", len(res.Observations))
+	}
+
+	hgb := res.Observations[0]
+	if hgb.Code != "HGB" || hgb.Value != "142" || hgb.Unit != "g/L" || hgb.SpecimenBarcode != "SP000123" {
+		t.Errorf("unexpected HGB observation: %+v", hgb)
+	}
+	if want := time.Date(2025, 3, 1, 10, 10, 0, 0, time.Local); !hgb.ObservedAt.Equal(want) {
+		t.Errorf("ObservedAt = %v, want %v", hgb.ObservedAt, want)
+	}
+	if got := res.Observations[2].Value; got != "see & check" {
+		t.Errorf("unescaped value = %q", got)
+	}
+	if got := res.Observations[2].Status; got != "X" {
+		t.Errorf("status = %q, want X", got)
+	}
+}
+
+func TestExtractORURejectsOtherTypes(t *testing.T) {
+	msg, err := parseHL7([]byte("MSH|^~\\&|A|B|C|D|20250301||ADT^A01|1|P|2.5\rPID|1||950101300123\r"))
+	if err != nil {
+		t.Fatalf("parseHL7: %v", err)
+	}
+	if _, err := extractORU(msg); err == nil {
+		t.Fatal("expected error for ADT^A01")
+	}
+}
+
+func TestBuildHL7ACK(t *testing.T) {
+	msg, _ := parseHL7([]byte(sampleORU))
+	ack, err := parseHL7(buildHL7ACK(msg, hl7AckError, "no patient|with IIN", time.Now()))
+	if err != nil {
+		t.Fatalf("parse ack: %v", err)
+	}
+	if got := ack.messageType(); got != "ACK^R01" {
+		t.Errorf("ack type = %q", got)
+	}
+	msa := ack.segment("MSA")
+	if ack.field(msa, 1) != hl7AckError || ack.field(msa, 2) != "MSG0001" {
+		t.Errorf("unexpected MSA: %v", msa)
+	}
+	if got := ack.field(msa, 3); got != "no patient with IIN" {
+		t.Errorf("ack text = %q", got)
+	}
+	if got := ack.field(ack.segment("MSH"), 5); got != "BC-5150" {
+		t.Errorf("receiving app = %q, want sender of the original", got)
+	}
+}
+
+func TestMLLPRoundTrip(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer ln.Close()
+
+	// Обработчик без БД: принимает ORU^R01 с пациентом, остальное отклоняет
+	handler := func(ctx context.Context, raw []byte) []byte {
+		msg, err := parseHL7(raw)
+		if err != nil {
+			return buildHL7ACK(nil, hl7AckReject, err.Error(), time.Now())
+		}
+		if _, err := extractORU(msg); err != nil {
+			return buildHL7ACK(msg, hl7AckReject, err.Error(), time.Now())
+		}
+		return buildHL7ACK(msg, hl7AckAccept, "", time.Now())
+	}
+	go serveMLLP(ln, &hl7Access{allowed: []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8")}}, handler)
+
+	client := dialMLLP(t, ln.Addr().String())
+
+	ack := client.send(t, sampleORU)
+	if msa := ack.segment("MSA"); ack.field(msa, 1) != hl7AckAccept || ack.field(msa, 2) != "MSG0001" {
+		t.Fatalf("first ack MSA = %v", msa)
+	}
+
+	// Второе сообщение в том же соединении, с разделителями \n
+	second := strings.ReplaceAll(strings.Replace(sampleORU, "MSG0001", "MSG0002", 1), "\r", "\n")
+	ack = client.send(t, second)
+	if msa := ack.segment("MSA"); ack.field(msa, 1) != hl7AckAccept || ack.field(msa, 2) != "MSG0002" {
+		t.Fatalf("second ack MSA = %v", msa)
+	}
+
+	ack = client.send(t, "garbage")
+	if msa := ack.segment("MSA"); ack.field(msa, 1) != hl7AckReject {
+		t.Fatalf("garbage ack MSA = %v", msa)
+	}
+}
+
+func TestReadMLLPFrameKeepsEmbeddedFS(t *testing.T) {
+	r := bufio.NewReader(strings.NewReader("noise\x0bA\x1cB\x1c\r"))
+	frame, err := readMLLPFrame(r)
+	if err != nil {
+		t.Fatalf("readMLLPFrame: %v", err)
+	}
+	if string(frame) != "A\x1cB" {
+		t.Errorf("frame = %q", frame)
+	}
+}
+
+func TestLoadHL7Access(t *testing.T) {
+	a, err := loadHL7Access(envOf(map[string]string{}))
+	if err != nil || a.enabled() || a.allowsAddr("127.0.0.1:5000") || a.allowsSecret("") {
+		t.Fatal("intake without configuration must be closed")
+	}
+	for _, env := range []map[string]string{
+		{"HL7_SHARED_SECRET": "short"},
+		{"HL7_ALLOWED_IPS": "10.0.0.0/33"},
+		{"HL7_ALLOWED_IPS": "analyzer.local"},
+	} {
+		if _, err := loadHL7Access(envOf(env)); err == nil {
+			t.Errorf("%v: must be rejected", env)
+		}
+	}
+
+	a, err = loadHL7Access(envOf(map[string]string{"HL7_ALLOWED_IPS": "10.1.2.3, 192.168.5.0/24,fd00::/8"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for addr, want := range map[string]bool{
+		"10.1.2.3:40000": true, "10.1.2.4:40000": false, "192.168.5.77:1": true, "192.168.6.1:1": false,
+		"[fd00::1]:2575": true, "[::ffff:10.1.2.3]:1": true, "127.0.0.1:1": false, "garbage": false,
+	} {
+		if got := a.allowsAddr(addr); got != want {
+			t.Errorf("allowsAddr(%s) = %v, want %v", addr, got, want)
+		}
+	}
+	if !a.allowsSecret("") {
+		t.Error("without HL7_SHARED_SECRET the address check is enough")
+	}
+
+	secret := "analyzer-secret-0123"
+	a, _ = loadHL7Access(envOf(map[string]string{"HL7_SHARED_SECRET": secret}))
+	if !a.allowsSecret(secret) || a.allowsSecret("") || a.allowsSecret(secret+"x") || !a.allowsAddr("8.8.8.8:1") {
+		t.Error("secret check is wrong")
+	}
+}
+
+// Сообщение без секрета отклоняется до разбора и записи в БД
+func TestHandleORURequiresSecret(t *testing.T) {
+	secret := "analyzer-secret-0123"
+	access := &hl7Access{secret: []byte(secret)}
+	for _, tc := range []struct{ token, msh8 string }{
+		{"", ""},
+		{"wrong-secret-000000", ""},
+		{"", "wrong-secret-000000"},
+	} {
+		msg := strings.Replace(sampleORU, "20250301101500||ORU", "20250301101500|"+tc.msh8+"|ORU", 1)
+		ack, err := parseHL7(handleORU("http", access, tc.token)(context.Background(), []byte(msg)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if msa := ack.segment("MSA"); ack.field(msa, 1) != hl7AckReject || ack.field(msa, 3) != "not authorized" {
+			t.Errorf("%+v: MSA = %v", tc, msa)
+		}
+	}
+}
+
+// unreachableDB подменяет пул соединением, которое не устанавливается:
+// запросы возвращают ошибку вместо паники на nil-пуле
+func unreachableDB(t *testing.T) {
+	t.Helper()
+	pool, err := pgxpool.New(context.Background(), "postgres://medwork@127.0.0.1:1/medwork?connect_timeout=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	prev := db
+	db = pool
+	t.Cleanup(func() {
+		db = prev
+		pool.Close()
+	})
+}
+
+// Секрет из MSH-8 первого сообщения не переносится на следующие
+func TestHandleORUChecksSecretPerMessage(t *testing.T) {
+	unreachableDB(t)
+	secret := "analyzer-secret-0123"
+	handle := handleORU("mllp", &hl7Access{secret: []byte(secret)}, "")
+	authorized := func(msh8 string) bool {
+		msg := strings.Replace(sampleORU, "20250301101500||ORU", "20250301101500|"+msh8+"|ORU", 1)
+		ack, err := parseHL7(handle(context.Background(), []byte(msg)))
+		return err == nil && ack.field(ack.segment("MSA"), 3) != "not authorized"
+	}
+
+	for i, tc := range []struct {
+		msh8 string
+		want bool
+	}{
+		{"wrong-secret-000000", false},
+		{secret, true},
+		{"", false},
+		{secret, true},
+		{"wrong-secret-000000", false},
+	} {
+		if got := authorized(tc.msh8); got != tc.want {
+			t.Errorf("message %d with secret %q: authorized = %v, want %v", i, tc.msh8, got, tc.want)
+		}
+	}
+
+	// Соединения обслуживаются одним обработчиком параллельно
+	var wg sync.WaitGroup
+	for _, msh8 := range []string{secret, "wrong-secret-000000", secret, "wrong-secret-000000"} {
+		wg.Add(1)
+		go func(msh8 string) {
+			defer wg.Done()
+			if got := authorized(msh8); got != (msh8 == secret) {
+				t.Errorf("concurrent message with secret %q: authorized = %v", msh8, got)
+			}
+		}(msh8)
+	}
+	wg.Wait()
+}
+
+func TestHL7HTTPHandlerChecksAccess(t *testing.T) {
+	prev := hl7Auth
+	defer func() { hl7Auth = prev }()
+
+	hl7Auth = nil
+	if rec := callAs(t, nil, http.MethodPost, "/api/hl7/oru", sampleORU, hl7HTTPHandler); rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("unconfigured intake: status %d", rec.Code)
+	}
+	// httptest выставляет RemoteAddr 192.0.2.1
+	hl7Auth = &hl7Access{allowed: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}}
+	if rec := callAs(t, nil, http.MethodPost, "/api/hl7/oru", sampleORU, hl7HTTPHandler); rec.Code != http.StatusForbidden {
+		t.Errorf("foreign address: status %d", rec.Code)
+	}
+}
+
+func TestServeMLLPDropsForeignAddresses(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer ln.Close()
+	handled := make(chan struct{}, 1)
+	access := &hl7Access{allowed: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}}
+	go serveMLLP(ln, access, func(ctx context.Context, raw []byte) []byte {
+		handled <- struct{}{}
+		return nil
+	})
+
+	c := dialMLLP(t, ln.Addr().String())
+	c.conn.SetDeadline(time.Now().Add(5 * time.Second))
+	writeMLLPFrame(c.conn, []byte(sampleORU))
+	if _, err := c.r.ReadByte(); err == nil {
+		t.Errorf("connection from a foreign address must be closed, got %v", err)
+	}
+	select {
+	case <-handled:
+		t.Error("message from a foreign address was handled")
+	default:
+	}
+}
@@ -0,0 +1,483 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// --- WEBSOCKET ---
+
+var upgrader = websocket.Upgrader{
+	CheckOrigin: func(r *http.Request) bool {
+		// Разрешаем подключения с любого origin (для разработки)
+		// В продакшене нужно проверять origin
+		return true
+	},
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+}
+
+const (
+	clientSendBuffer = 256
+	wsPongWait       = 60 * time.Second
+	wsPingPeriod     = 54 * time.Second
+	wsWriteWait      = 10 * time.Second
+)
+
+// WebSocketMessage - структура сообщения WebSocket
+type WebSocketMessage struct {
+	Type      string      `json:"type"`
+	Data      interface{} `json:"data"`
+	Timestamp string      `json:"timestamp"`
+	UserID    string      `json:"userId,omitempty"`
+	// ID - номер события в журнале, для досылки после переподключения (см. eventlog.go)
+	ID int64 `json:"id,omitempty"`
+	// ReplyTo - id кадра клиента, на который это ответ (см. ws_protocol.go)
+	ReplyTo string `json:"replyTo,omitempty"`
+}
+
+// Client - подключенный WebSocket клиент.
+// Канал Send принадлежит хабу: закрывает его только хаб и только один раз.
+type Client struct {
+	ID       string
+	UserID   string
+	Role     UserRole
+	ClinicID string // клиника пользователя (для клиники - она сама), пусто у организаций и работников
+	User     *User  // для проверки прав при подписке на темы
+	Conn     *websocket.Conn
+	Send     chan WebSocketMessage
+	Hub      *Hub
+
+	dir      topicDirectory
+	events   eventLog
+	presence presenceStore       // присутствие в картах (presence.go); nil у SSE
+	topics   map[string]struct{} // под Hub.mu
+}
+
+type clientSet map[*Client]struct{}
+
+// Hub - реестр подключений с индексами по пользователю, роли и темам (клиника,
+// договор, визит, врач). Рассылка идёт под RLock; регистрация, подписки, отключение
+// и вытеснение медленных клиентов - под Lock. Поэтому канал не закрывается, пока в него кто-то пишет.
+type Hub struct {
+	mu      sync.RWMutex
+	clients clientSet
+	byUser  map[string]clientSet
+	byRole  map[UserRole]clientSet
+	byTopic map[string]clientSet
+}
+
+// NewHub создает новый Hub
+func NewHub() *Hub {
+	return &Hub{
+		clients: clientSet{},
+		byUser:  map[string]clientSet{},
+		byRole:  map[UserRole]clientSet{},
+		byTopic: map[string]clientSet{},
+	}
+}
+
+func addToIndex[K comparable](index map[K]clientSet, key K, c *Client) {
+	set, ok := index[key]
+	if !ok {
+		set = clientSet{}
+		index[key] = set
+	}
+	set[c] = struct{}{}
+}
+
+func removeFromIndex[K comparable](index map[K]clientSet, key K, c *Client) {
+	if set, ok := index[key]; ok {
+		delete(set, c)
+		if len(set) == 0 {
+			delete(index, key)
+		}
+	}
+}
+
+// Register добавляет клиента во все индексы; сотрудники клиники сразу подписаны на её тему
+func (h *Hub) Register(c *Client) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.clients[c] = struct{}{}
+	addToIndex(h.byUser, c.UserID, c)
+	addToIndex(h.byRole, c.Role, c)
+	if c.ClinicID != "" {
+		h.subscribeLocked(c, clinicTopic(c.ClinicID))
+	}
+}
+
+// Subscribe подписывает зарегистрированного клиента на тему. Права проверяет вызывающий.
+func (h *Hub) Subscribe(c *Client, topic string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.clients[c]; !ok {
+		return errClientGone
+	}
+	if _, ok := c.topics[topic]; !ok && len(c.topics) >= maxClientTopics {
+		return errTooManyTopics
+	}
+	h.subscribeLocked(c, topic)
+	return nil
+}
+
+func (h *Hub) subscribeLocked(c *Client, topic string) {
+	if c.topics == nil {
+		c.topics = map[string]struct{}{}
+	}
+	c.topics[topic] = struct{}{}
+	addToIndex(h.byTopic, topic, c)
+}
+
+// Unsubscribe отписывает клиента от темы
+func (h *Hub) Unsubscribe(c *Client, topic string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := c.topics[topic]; ok {
+		delete(c.topics, topic)
+		removeFromIndex(h.byTopic, topic, c)
+	}
+}
+
+// Unregister убирает клиента и закрывает его Send; повторный вызов ничего не делает
+func (h *Hub) Unregister(c *Client) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.removeLocked(c) {
+		log.Printf("WebSocket client disconnected: %s", c.ID)
+	}
+}
+
+func (h *Hub) removeLocked(c *Client) bool {
+	if _, ok := h.clients[c]; !ok {
+		return false
+	}
+	delete(h.clients, c)
+	removeFromIndex(h.byUser, c.UserID, c)
+	removeFromIndex(h.byRole, c.Role, c)
+	for topic := range c.topics {
+		removeFromIndex(h.byTopic, topic, c)
+	}
+	c.topics = nil
+	close(c.Send)
+	return true
+}
+
+// deliver рассылает сообщение клиентам из выбранных индексов. Клиент с заполненной
+// очередью не успевает читать - его отключаем, чтобы не держать остальных.
+// pick вызывается под RLock: индексы меняются только под Lock.
+func (h *Hub) deliver(message WebSocketMessage, pick func() []clientSet) {
+	var slow []*Client
+	h.mu.RLock()
+	sets := pick()
+	var seen map[*Client]bool
+	if len(sets) > 1 {
+		seen = map[*Client]bool{}
+	}
+	for _, set := range sets {
+		for c := range set {
+			if seen != nil {
+				if seen[c] {
+					continue
+				}
+				seen[c] = true
+			}
+			select {
+			case c.Send <- message:
+			default:
+				slow = append(slow, c)
+			}
+		}
+	}
+	h.mu.RUnlock()
+
+	if len(slow) == 0 {
+		return
+	}
+	h.mu.Lock()
+	for _, c := range slow {
+		if h.removeLocked(c) {
+			log.Printf("WebSocket client %s evicted: send queue is full", c.ID)
+		}
+	}
+	h.mu.Unlock()
+}
+
+// Broadcast отправляет сообщение всем подключенным клиентам
+func (h *Hub) Broadcast(message WebSocketMessage) {
+	h.deliver(message, func() []clientSet { return []clientSet{h.clients} })
+}
+
+// BroadcastToUser отправляет сообщение всем подключениям пользователя
+func (h *Hub) BroadcastToUser(userID string, message WebSocketMessage) {
+	h.deliver(message, func() []clientSet { return []clientSet{h.byUser[userID]} })
+}
+
+// BroadcastToRole отправляет сообщение всем пользователям с определенной ролью
+func (h *Hub) BroadcastToRole(role UserRole, message WebSocketMessage) {
+	h.deliver(message, func() []clientSet { return []clientSet{h.byRole[role]} })
+}
+
+// BroadcastToClinic отправляет сообщение клинике и её сотрудникам
+func (h *Hub) BroadcastToClinic(clinicID string, message WebSocketMessage) {
+	h.Publish(clinicTopic(clinicID), message)
+}
+
+// Publish отправляет сообщение подписчикам темы
+func (h *Hub) Publish(topic string, message WebSocketMessage) {
+	h.deliver(message, func() []clientSet { return []clientSet{h.byTopic[topic]} })
+}
+
+// PublishTopics отправляет сообщение подписчикам нескольких тем; подписанный на
+// несколько из них клиент получает его один раз
+func (h *Hub) PublishTopics(topics []string, message WebSocketMessage) {
+	h.deliver(message, func() []clientSet {
+		sets := make([]clientSet, 0, len(topics))
+		for _, topic := range topics {
+			if set, ok := h.byTopic[topic]; ok {
+				sets = append(sets, set)
+			}
+		}
+		return sets
+	})
+}
+
+// SendTo отправляет сообщение одному клиенту, если он ещё подключен
+func (h *Hub) SendTo(c *Client, message WebSocketMessage) {
+	h.deliver(message, func() []clientSet {
+		if _, ok := h.clients[c]; !ok {
+			return nil
+		}
+		return []clientSet{{c: {}}}
+	})
+}
+
+// BroadcastToUsers отправляет сообщение нескольким пользователям; каждое подключение получает его один раз
+func (h *Hub) BroadcastToUsers(userIDs []string, message WebSocketMessage) {
+	h.deliver(message, func() []clientSet {
+		sets := make([]clientSet, 0, len(userIDs))
+		for _, id := range userIDs {
+			if set, ok := h.byUser[id]; ok {
+				sets = append(sets, set)
+			}
+		}
+		return sets
+	})
+}
+
+// ClientCount - число подключений (для мониторинга и тестов)
+func (h *Hub) ClientCount() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.clients)
+}
+
+// readPump читает сообщения от клиента
+func (c *Client) readPump() {
+	defer func() {
+		c.Hub.Unregister(c)
+		c.Conn.Close()
+		c.leavePresence()
+	}()
+
+	c.Conn.SetReadLimit(maxClientFrame)
+	c.Conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	c.Conn.SetPongHandler(func(string) error {
+		c.Conn.SetReadDeadline(time.Now().Add(wsPongWait))
+		return nil
+	})
+
+	for {
+		_, raw, err := c.Conn.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
+				log.Printf("WebSocket error: %v", err)
+			}
+			break
+		}
+		c.Conn.SetReadDeadline(time.Now().Add(wsPongWait))
+		c.handleFrame(raw)
+	}
+}
+
+// writePump отправляет сообщения клиенту
+func (c *Client) writePump() {
+	ticker := time.NewTicker(wsPingPeriod)
+	defer func() {
+		ticker.Stop()
+		c.Conn.Close()
+	}()
+
+	for {
+		select {
+		case message, ok := <-c.Send:
+			c.Conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if !ok {
+				// Хаб отключил клиента
+				c.Conn.WriteMessage(websocket.CloseMessage, []byte{})
+				return
+			}
+
+			w, err := c.Conn.NextWriter(websocket.TextMessage)
+			if err != nil {
+				return
+			}
+			json.NewEncoder(w).Encode(message)
+
+			// Накопившиеся сообщения - в тот же кадр
+			n := len(c.Send)
+			for i := 0; i < n; i++ {
+				next, ok := <-c.Send
+				if !ok {
+					break
+				}
+				json.NewEncoder(w).Encode(next)
+			}
+
+			if err := w.Close(); err != nil {
+				return
+			}
+
+		case <-ticker.C:
+			c.Conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if err := c.Conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
+
+var hub *Hub
+
+// wsHandler обрабатывает WebSocket подключения; dir нужен для проверки подписок на темы,
+// events - для досылки событий, пропущенных до переподключения (?lastEventId=N),
+// presence - для присутствия и блокировок в амбулаторных картах
+func wsHandler(dir topicDirectory, events eventLog, presence presenceStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		serveWS(w, r, dir, events, presence)
+	}
+}
+
+func serveWS(w http.ResponseWriter, r *http.Request, dir topicDirectory, events eventLog, presence presenceStore) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Printf("WebSocket upgrade error: %v", err)
+		return
+	}
+
+	// Получаем userID из query параметров или заголовков
+	userID := r.URL.Query().Get("userId")
+	if userID == "" {
+		userID = r.Header.Get("X-User-ID")
+	}
+	if userID == "" {
+		log.Printf("WebSocket: no userId provided, closing connection")
+		conn.Close()
+		return
+	}
+
+	// Получаем пользователя из БД
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	// Сначала пытаемся найти по id (uid), затем по phone (на случай если передали phone вместо uid)
+	user, err := loadUser(ctx, userID)
+	if err != nil {
+		var foundID string
+		if err2 := db.QueryRow(ctx, "SELECT id FROM users WHERE phone = $1", userID).Scan(&foundID); err2 == nil {
+			user, err = loadUser(ctx, foundID)
+		}
+	}
+	if err != nil {
+		// Пользователь не найден - это нормально, если он ещё не зарегистрирован.
+		// Не логируем как ошибку, просто закрываем соединение тихо
+		conn.Close()
+		return
+	}
+
+	client := &Client{
+		ID:       fmt.Sprintf("client_%d_%s", time.Now().UnixNano(), user.ID),
+		UserID:   user.ID,
+		Role:     user.Role,
+		ClinicID: connectionClinicID(user),
+		User:     user,
+		Conn:     conn,
+		Send:     make(chan WebSocketMessage, clientSendBuffer),
+		Hub:      hub,
+		dir:      dir,
+		events:   events,
+		presence: presence,
+	}
+
+	hub.Register(client)
+	if user.Role == UserRoleDoctor && user.DoctorID != nil {
+		hub.Subscribe(client, doctorTopic(*user.DoctorID))
+	}
+	log.Printf("WebSocket client connected: %s (user: %s)", client.ID, client.UserID)
+	hub.SendTo(client, newMessage("welcome", map[string]any{"protocol": wsProtocolVersion, "clientId": client.ID}))
+
+	// Досылаем после регистрации: событие может прийти дважды, но не потеряется
+	if lastEventID := parseLastEventID(r.URL.Query().Get("lastEventId")); lastEventID > 0 {
+		replayEvents(ctx, events, client, hub.streams(client), lastEventID)
+	}
+
+	go client.writePump()
+	go client.readPump()
+}
+
+// broadcastMessage отправляет сообщение всем подключенным клиентам
+func broadcastMessage(messageType string, data interface{}) {
+	publishEvent(busEvent{Scope: busScopeAll, Message: newMessage(messageType, data)})
+}
+
+// broadcastToUser отправляет сообщение конкретному пользователю
+func broadcastToUser(userID string, messageType string, data interface{}) {
+	msg := newMessage(messageType, data)
+	msg.UserID = userID
+	publishEvent(busEvent{Scope: busScopeUser, Keys: []string{userID}, Message: msg})
+}
+
+// broadcastToRole отправляет сообщение всем пользователям с определенной ролью
+func broadcastToRole(role UserRole, messageType string, data interface{}) {
+	publishEvent(busEvent{Scope: busScopeRole, Keys: []string{string(role)}, Message: newMessage(messageType, data)})
+}
+
+// broadcastToClinic отправляет сообщение клинике и всем её сотрудникам, но не другим клиникам
+func broadcastToClinic(clinicID string, messageType string, data interface{}) {
+	if clinicID == "" {
+		return
+	}
+	publishToTopic(clinicTopic(clinicID), messageType, data)
+}
+
+// publishToTopic отправляет сообщение подписчикам темы (см. topics.go)
+func publishToTopic(topic string, messageType string, data interface{}) {
+	publishToTopics([]string{topic}, messageType, data)
+}
+
+// publishToTopics отправляет одно событие подписчикам нескольких тем без повторов
+func publishToTopics(topics []string, messageType string, data interface{}) {
+	if len(topics) == 0 {
+		return
+	}
+	publishEvent(busEvent{Scope: busScopeTopic, Keys: topics, Message: newMessage(messageType, data)})
+}
+
+// broadcastToUsers отправляет сообщение нескольким пользователям
+func broadcastToUsers(userIDs []string, messageType string, data interface{}) {
+	if len(userIDs) == 0 {
+		return
+	}
+	publishEvent(busEvent{Scope: busScopeUsers, Keys: userIDs, Message: newMessage(messageType, data)})
+}
+
+func newMessage(messageType string, data interface{}) WebSocketMessage {
+	return WebSocketMessage{Type: messageType, Data: data, Timestamp: time.Now().Format(time.RFC3339)}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestClient(h *Hub, id, userID string, role UserRole, clinicID string, buffer int) *Client {
+	return &Client{ID: id, UserID: userID, Role: role, ClinicID: clinicID, Send: make(chan WebSocketMessage, buffer), Hub: h}
+}
+
+// drain читает очередь клиента, пока хаб её не закроет
+func drain(c *Client) (received *int64, done chan struct{}) {
+	var n int64
+	done = make(chan struct{})
+	go func() {
+		defer close(done)
+		for range c.Send {
+			atomic.AddInt64(&n, 1)
+		}
+	}()
+	return &n, done
+}
+
+func TestHubRoutesThroughIndexes(t *testing.T) {
+	h := NewHub()
+	doctorA1 := newTestClient(h, "a1", "doc-a", UserRoleDoctor, "clinic-1", 8)
+	doctorA2 := newTestClient(h, "a2", "doc-a", UserRoleDoctor, "clinic-1", 8) // вторая вкладка
+	clinic := newTestClient(h, "c", "clinic-1", UserRoleClinic, "clinic-1", 8)
+	otherClinic := newTestClient(h, "c2", "clinic-2", UserRoleClinic, "clinic-2", 8)
+	org := newTestClient(h, "o", "org-1", UserRoleOrganization, "", 8)
+	for _, c := range []*Client{doctorA1, doctorA2, clinic, otherClinic, org} {
+		h.Register(c)
+	}
+
+	h.BroadcastToUser("doc-a", WebSocketMessage{Type: "to_user"})
+	h.BroadcastToRole(UserRoleClinic, WebSocketMessage{Type: "to_role"})
+	h.BroadcastToClinic("clinic-1", WebSocketMessage{Type: "to_clinic"})
+	// Пользователь указан дважды - подключение получает сообщение один раз
+	h.BroadcastToUsers([]string{"org-1", "doc-a", "org-1", "nobody"}, WebSocketMessage{Type: "to_users"})
+	h.Broadcast(WebSocketMessage{Type: "to_all"})
+
+	want := map[*Client][]string{
+		doctorA1:    {"to_user", "to_clinic", "to_users", "to_all"},
+		doctorA2:    {"to_user", "to_clinic", "to_users", "to_all"},
+		clinic:      {"to_role", "to_clinic", "to_all"},
+		otherClinic: {"to_role", "to_all"},
+		org:         {"to_users", "to_all"},
+	}
+	for c, types := range want {
+		var got []string
+		for len(c.Send) > 0 {
+			got = append(got, (<-c.Send).Type)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(types) {
+			t.Errorf("%s received %v, want %v", c.ID, got, types)
+		}
+	}
+}
+
+func TestHubUnregisterIsIdempotent(t *testing.T) {
+	h := NewHub()
+	c := newTestClient(h, "c", "u", UserRoleEmployee, "", 1)
+	h.Register(c)
+	h.Unregister(c)
+	h.Unregister(c) // повторное закрытие канала вызвало бы панику
+
+	if _, ok := <-c.Send; ok {
+		t.Fatal("Send must be closed after unregister")
+	}
+	if h.ClientCount() != 0 || len(h.byUser) != 0 || len(h.byRole) != 0 {
+		t.Fatalf("indexes must be empty: clients=%d users=%d roles=%d", h.ClientCount(), len(h.byUser), len(h.byRole))
+	}
+	h.BroadcastToUser("u", WebSocketMessage{Type: "late"}) // не должно паниковать
+}
+
+func TestHubEvictsSlowConsumer(t *testing.T) {
+	h := NewHub()
+	slow := newTestClient(h, "slow", "u1", UserRoleDoctor, "clinic-1", 2)
+	fast := newTestClient(h, "fast", "u2", UserRoleDoctor, "clinic-1", 2)
+	h.Register(slow)
+	h.Register(fast)
+	got, done := drain(fast)
+
+	for i := 0; i < 3; i++ {
+		h.BroadcastToClinic("clinic-1", WebSocketMessage{Type: "tick"})
+		time.Sleep(10 * time.Millisecond) // даём быстрому клиенту разобрать очередь
+	}
+
+	if h.ClientCount() != 1 {
+		t.Fatalf("slow client must be evicted, %d clients left", h.ClientCount())
+	}
+	if _, still := h.byTopic[clinicTopic("clinic-1")][slow]; still {
+		t.Fatal("evicted client is still indexed")
+	}
+	// Уже поставленные в очередь сообщения клиент дочитывает, затем канал закрыт
+	n := 0
+	for range slow.Send {
+		n++
+	}
+	if n != 2 {
+		t.Errorf("slow client kept %d queued messages, want 2", n)
+	}
+
+	h.Unregister(fast)
+	<-done
+	if atomic.LoadInt64(got) != 3 {
+		t.Errorf("fast client received %d messages, want 3", atomic.LoadInt64(got))
+	}
+}
+
+// Нагрузка: подключения, отключения и рассылки одновременно. Запускать с -race.
+func TestHubConcurrentLoad(t *testing.T) {
+	h := NewHub()
+	const (
+		connectors  = 16
+		perWorker   = 200
+		broadcaster = 8
+	)
+	roles := []UserRole{UserRoleClinic, UserRoleDoctor, UserRoleEmployee, UserRoleOrganization}
+
+	stop := make(chan struct{})
+	var sent int64
+	var bwg sync.WaitGroup
+	for b := 0; b < broadcaster; b++ {
+		bwg.Add(1)
+		go func(seed int64) {
+			defer bwg.Done()
+			rnd := rand.New(rand.NewSource(seed))
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				msg := WebSocketMessage{Type: "load"}
+				switch rnd.Intn(5) {
+				case 0:
+					h.Broadcast(msg)
+				case 1:
+					h.BroadcastToUser(fmt.Sprintf("user-%d", rnd.Intn(50)), msg)
+				case 2:
+					h.BroadcastToRole(roles[rnd.Intn(len(roles))], msg)
+				case 3:
+					h.BroadcastToClinic(fmt.Sprintf("clinic-%d", rnd.Intn(5)), msg)
+				case 4:
+					h.BroadcastToUsers([]string{fmt.Sprintf("user-%d", rnd.Intn(50)), fmt.Sprintf("user-%d", rnd.Intn(50))}, msg)
+				}
+				atomic.AddInt64(&sent, 1)
+			}
+		}(int64(b))
+	}
+
+	var cwg sync.WaitGroup
+	for w := 0; w < connectors; w++ {
+		cwg.Add(1)
+		go func(w int) {
+			defer cwg.Done()
+			rnd := rand.New(rand.NewSource(int64(1000 + w)))
+			for i := 0; i < perWorker; i++ {
+				// Часть клиентов не читает вовсе и будет вытеснена
+				reader := rnd.Intn(4) != 0
+				c := newTestClient(h, fmt.Sprintf("c-%d-%d", w, i), fmt.Sprintf("user-%d", rnd.Intn(50)),
+					roles[rnd.Intn(len(roles))], fmt.Sprintf("clinic-%d", rnd.Intn(5)), 1+rnd.Intn(4))
+				h.Register(c)
+				var done chan struct{}
+				if reader {
+					_, done = drain(c)
+				}
+				time.Sleep(time.Duration(rnd.Intn(200)) * time.Microsecond)
+				h.Unregister(c) // клиент мог быть уже вытеснен - это нормально
+				if done != nil {
+					<-done
+				}
+			}
+		}(w)
+	}
+	cwg.Wait()
+	close(stop)
+	bwg.Wait()
+
+	if atomic.LoadInt64(&sent) == 0 {
+		t.Fatal("broadcasters did not run")
+	}
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if len(h.clients) != 0 || len(h.byUser) != 0 || len(h.byRole) != 0 || len(h.byTopic) != 0 {
+		t.Fatalf("hub must be empty after all disconnects: clients=%d users=%d roles=%d topics=%d",
+			len(h.clients), len(h.byUser), len(h.byRole), len(h.byTopic))
+	}
+}
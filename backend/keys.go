@@ -0,0 +1,86 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"os"
+)
+
+// --- Ключи подписи ---
+//
+// Ссылки на вложения, штампы допуска и справки с QR подписываются ключами сервера.
+// Ключи общие для всех реплик и не меняются при перезапуске, поэтому читаются один раз
+// при запуске, и без них сервер не стартует. Достаточно SIGNING_SECRET: ключ каждого
+// назначения выводится из него. Отдельная переменная (ATTACHMENT_URL_SECRET,
+// SHIFT_STAMP_SECRET, CERT_IIN_SECRET, CERT_SIGNING_KEY) заменяет выведенный ключ -
+// так выданные раньше штампы и справки продолжают проверяться.
+
+const minSecretLen = 32
+
+type keyConfig struct {
+	AttachmentURL []byte             // HMAC ссылок на скачивание вложений
+	ShiftStamp    []byte             // HMAC штампов допуска к смене
+	CertIIN       []byte             // HMAC ИИН в справках
+	CertSigning   ed25519.PrivateKey // подпись токенов справок
+}
+
+var serverKeys *keyConfig
+
+func newKeyConfigFromEnv() (*keyConfig, error) {
+	return loadKeyConfig(os.Getenv)
+}
+
+func loadKeyConfig(getenv func(string) string) (*keyConfig, error) {
+	master := getenv("SIGNING_SECRET")
+	if master != "" && len(master) < minSecretLen {
+		return nil, fmt.Errorf("SIGNING_SECRET must be at least %d bytes", minSecretLen)
+	}
+	secret := func(name, purpose string) ([]byte, error) {
+		if s := getenv(name); s != "" {
+			if len(s) < minSecretLen {
+				return nil, fmt.Errorf("%s must be at least %d bytes", name, minSecretLen)
+			}
+			return []byte(s), nil
+		}
+		if master == "" {
+			return nil, fmt.Errorf("SIGNING_SECRET or %s must be set", name)
+		}
+		return deriveKey(master, purpose), nil
+	}
+
+	k := &keyConfig{}
+	var err error
+	if k.AttachmentURL, err = secret("ATTACHMENT_URL_SECRET", "attachment-url"); err != nil {
+		return nil, err
+	}
+	if k.ShiftStamp, err = secret("SHIFT_STAMP_SECRET", "shift-stamp"); err != nil {
+		return nil, err
+	}
+	if k.CertIIN, err = secret("CERT_IIN_SECRET", "certificate-iin"); err != nil {
+		return nil, err
+	}
+
+	var seed []byte
+	if s := getenv("CERT_SIGNING_KEY"); s != "" {
+		seed, err = base64.StdEncoding.DecodeString(s)
+		if err != nil || len(seed) != ed25519.SeedSize {
+			return nil, fmt.Errorf("CERT_SIGNING_KEY must be a base64-encoded %d-byte seed", ed25519.SeedSize)
+		}
+	} else if master != "" {
+		seed = deriveKey(master, "certificate-signing")
+	} else {
+		return nil, fmt.Errorf("SIGNING_SECRET or CERT_SIGNING_KEY must be set")
+	}
+	k.CertSigning = ed25519.NewKeyFromSeed(seed)
+	return k, nil
+}
+
+// deriveKey - ключ назначения purpose из общего секрета (32 байта)
+func deriveKey(master, purpose string) []byte {
+	mac := hmac.New(sha256.New, []byte(master))
+	mac.Write([]byte("medwork/" + purpose))
+	return mac.Sum(nil)
+}
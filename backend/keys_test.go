@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+const testSigningSecret = "test-signing-secret-0123456789abcdef"
+
+func init() {
+	k, err := loadKeyConfig(envOf(map[string]string{"SIGNING_SECRET": testSigningSecret}))
+	if err != nil {
+		panic(err)
+	}
+	serverKeys = k
+}
+
+func envOf(m map[string]string) func(string) string {
+	return func(name string) string { return m[name] }
+}
+
+func TestLoadKeyConfigRequiresSecrets(t *testing.T) {
+	for _, env := range []map[string]string{
+		{},
+		{"SIGNING_SECRET": "short"},
+		{"ATTACHMENT_URL_SECRET": strings.Repeat("a", 32), "SHIFT_STAMP_SECRET": strings.Repeat("s", 32)},
+		{"SIGNING_SECRET": testSigningSecret, "SHIFT_STAMP_SECRET": "short"},
+		{"SIGNING_SECRET": testSigningSecret, "CERT_SIGNING_KEY": "not-base64"},
+	} {
+		if _, err := loadKeyConfig(envOf(env)); err == nil {
+			t.Errorf("%v: server must not start", env)
+		}
+	}
+}
+
+func TestLoadKeyConfigDerivesAndOverrides(t *testing.T) {
+	a, err := loadKeyConfig(envOf(map[string]string{"SIGNING_SECRET": testSigningSecret}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := loadKeyConfig(envOf(map[string]string{"SIGNING_SECRET": testSigningSecret}))
+	if !bytes.Equal(a.ShiftStamp, b.ShiftStamp) || !a.CertSigning.Equal(b.CertSigning) {
+		t.Error("derived keys must not change between restarts")
+	}
+	keys := [][]byte{a.AttachmentURL, a.ShiftStamp, a.CertIIN, a.CertSigning.Seed()}
+	for i := range keys {
+		for j := i + 1; j < len(keys); j++ {
+			if bytes.Equal(keys[i], keys[j]) {
+				t.Errorf("keys %d and %d are the same", i, j)
+			}
+		}
+	}
+
+	// Ключи, заданные отдельно, сохраняют выданные штампы и справки
+	stamp := strings.Repeat("s", 32)
+	seed := bytes.Repeat([]byte{7}, ed25519.SeedSize)
+	c, err := loadKeyConfig(envOf(map[string]string{
+		"SIGNING_SECRET":     testSigningSecret,
+		"SHIFT_STAMP_SECRET": stamp,
+		"CERT_SIGNING_KEY":   base64.StdEncoding.EncodeToString(seed),
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(c.ShiftStamp) != stamp || !bytes.Equal(c.CertSigning.Seed(), seed) {
+		t.Error("explicit keys must override derived ones")
+	}
+	if !bytes.Equal(c.AttachmentURL, a.AttachmentURL) {
+		t.Error("keys without an override must still be derived")
+	}
+}
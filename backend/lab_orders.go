@@ -0,0 +1,616 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Типы биоматериала направления
+const (
+	SpecimenBlood   = "blood"
+	SpecimenUrine   = "urine"
+	SpecimenImaging = "imaging" // флюорография / рентген - без пробирки, штрихкод на направлении
+)
+
+// Состояния образца
+const (
+	SpecimenOrdered   = "ordered"
+	SpecimenCollected = "collected"
+	SpecimenReceived  = "received"
+	SpecimenResulted  = "resulted"
+)
+
+// LabOrder - направление на исследование из маршрутного листа
+type LabOrder struct {
+	ID           int64    `json:"id"`
+	VisitID      int64    `json:"visitId"`
+	EpisodeID    *int64   `json:"episodeId,omitempty"`
+	PatientUID   string   `json:"patientUid"`
+	EmployeeName string   `json:"employeeName,omitempty"`
+	ClinicID     string   `json:"clinicId"`
+	Research     string   `json:"research"`
+	SpecimenType string   `json:"specimenType"`
+	Panel        string   `json:"panel,omitempty"`
+	CreatedAt    string   `json:"createdAt"`
+	Specimen     Specimen `json:"specimen"`
+}
+
+// Specimen - образец со штрихкодом и отметками движения
+type Specimen struct {
+	Barcode     string  `json:"barcode"`
+	Status      string  `json:"status"`
+	CollectedAt *string `json:"collectedAt,omitempty"`
+	CollectedBy *string `json:"collectedBy,omitempty"`
+	ReceivedAt  *string `json:"receivedAt,omitempty"`
+	ReceivedBy  *string `json:"receivedBy,omitempty"`
+	ResultedAt  *string `json:"resultedAt,omitempty"`
+	ResultedBy  *string `json:"resultedBy,omitempty"`
+}
+
+func migrateLabOrders(ctx context.Context, tx pgx.Tx) error {
+	_, err := tx.Exec(ctx, `
+CREATE TABLE IF NOT EXISTS lab_orders (
+  id            SERIAL PRIMARY KEY,
+  visit_id      INTEGER NOT NULL REFERENCES employee_visits(id) ON DELETE CASCADE,
+  episode_id    INTEGER REFERENCES exam_episodes(id) ON DELETE CASCADE,
+  patient_uid   TEXT NOT NULL,
+  clinic_id     TEXT,
+  research      TEXT NOT NULL,
+  specimen_type TEXT NOT NULL,
+  panel         TEXT,
+  created_at    TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  CONSTRAINT valid_specimen_type CHECK (specimen_type IN ('blood', 'urine', 'imaging')),
+  UNIQUE (visit_id, research, specimen_type)
+);
+`)
+	if err != nil {
+		return fmt.Errorf("migrate lab_orders: %w", err)
+	}
+
+	_, err = tx.Exec(ctx, `CREATE SEQUENCE IF NOT EXISTS lab_specimen_seq;`)
+	if err != nil {
+		return fmt.Errorf("create sequence lab_specimen_seq: %w", err)
+	}
+
+	// lab_specimens создаётся в migrateHL7; здесь добавляем связь с направлением и состояния
+	_, err = tx.Exec(ctx, `
+ALTER TABLE lab_specimens
+  ADD COLUMN IF NOT EXISTS order_id INTEGER REFERENCES lab_orders(id) ON DELETE CASCADE,
+  ADD COLUMN IF NOT EXISTS status TEXT NOT NULL DEFAULT 'ordered'
+    CONSTRAINT valid_specimen_status CHECK (status IN ('ordered', 'collected', 'received', 'resulted')),
+  ADD COLUMN IF NOT EXISTS collected_at TIMESTAMPTZ,
+  ADD COLUMN IF NOT EXISTS collected_by TEXT,
+  ADD COLUMN IF NOT EXISTS received_at TIMESTAMPTZ,
+  ADD COLUMN IF NOT EXISTS received_by TEXT,
+  ADD COLUMN IF NOT EXISTS resulted_at TIMESTAMPTZ,
+  ADD COLUMN IF NOT EXISTS resulted_by TEXT;
+`)
+	if err != nil {
+		return fmt.Errorf("alter lab_specimens: %w", err)
+	}
+
+	for _, stmt := range []string{
+		`CREATE INDEX IF NOT EXISTS idx_lab_orders_visit ON lab_orders(visit_id);`,
+		`CREATE INDEX IF NOT EXISTS idx_lab_orders_clinic ON lab_orders(clinic_id);`,
+		`CREATE UNIQUE INDEX IF NOT EXISTS idx_lab_specimens_order ON lab_specimens(order_id);`,
+		`CREATE INDEX IF NOT EXISTS idx_lab_specimens_status ON lab_specimens(status) WHERE status <> 'resulted';`,
+	} {
+		if _, err := tx.Exec(ctx, stmt); err != nil {
+			return fmt.Errorf("lab orders index: %w", err)
+		}
+	}
+
+	// Направления, созданные до эпизода визита, остались без штрихкода: выдаём его,
+	// если эпизод уже есть
+	_, err = tx.Exec(ctx, `
+UPDATE lab_orders o SET episode_id = e.id
+FROM exam_episodes e
+WHERE o.episode_id IS NULL AND e.visit_id = o.visit_id;
+
+INSERT INTO lab_specimens (barcode, episode_id, patient_uid, order_id)
+SELECT '2' || lpad(nextval('lab_specimen_seq')::text, 11, '0'), o.episode_id, o.patient_uid, o.id
+FROM lab_orders o
+WHERE o.episode_id IS NOT NULL AND NOT EXISTS (SELECT 1 FROM lab_specimens s WHERE s.order_id = o.id);
+`)
+	if err != nil {
+		return fmt.Errorf("backfill lab specimens: %w", err)
+	}
+	return nil
+}
+
+// researchRule - признак исследования, для которого нужен образец. abbrevs сравниваются
+// с целыми словами («оак», «алт»), stems ищутся внутри текста («билирубин»)
+type researchRule struct {
+	specimen, panel string
+	abbrevs, stems  []string
+}
+
+// Правила одного образца проверяются от частного к общему и срабатывает первое:
+// «биохимический анализ крови» - биохимия, хотя в нём есть и «анализ крови»
+var researchRules = []researchRule{
+	{SpecimenBlood, "biochemistry", []string{"алт", "алат", "аст", "асат"},
+		[]string{"биохим", "билирубин", "холестерин", "креатинин", "глюкоз", "сахар"}},
+	{SpecimenBlood, "general-blood", []string{"оак"},
+		[]string{"общий анализ крови", "анализ крови", "анализ мочи и крови", "крови и мочи"}},
+	{SpecimenUrine, "general-urine", []string{"оам"}, []string{"мочи", "моче"}},
+	{SpecimenImaging, "", []string{"фг"}, []string{"флюорограф", "флюрограф", "рентген"}},
+}
+
+// classifyResearch определяет, какие образцы нужны для пункта маршрутного листа.
+// ЭКГ, спирография и осмотры в лабораторию не направляются.
+func classifyResearch(name string) map[string]string {
+	s := strings.ToLower(name)
+	words := map[string]bool{}
+	for _, w := range strings.FieldsFunc(s, func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) }) {
+		words[w] = true
+	}
+	matches := func(rule researchRule) bool {
+		for _, w := range rule.abbrevs {
+			if words[w] {
+				return true
+			}
+		}
+		for _, w := range rule.stems {
+			if strings.Contains(s, w) {
+				return true
+			}
+		}
+		return false
+	}
+	// «Глюкоза в моче» - анализ мочи, кровь для него не берут
+	urineOnly := (strings.Contains(s, "моч") || words["оам"]) && !strings.Contains(s, "кров") && !words["оак"]
+
+	kinds := make(map[string]string) // тип образца -> панель каталога
+	for _, rule := range researchRules {
+		if _, done := kinds[rule.specimen]; done || !matches(rule) {
+			continue
+		}
+		if rule.specimen == SpecimenBlood && urineOnly {
+			continue
+		}
+		kinds[rule.specimen] = rule.panel
+	}
+	return kinds
+}
+
+// createLabOrdersForVisit создаёт направления и штрихкоды по пунктам "research"
+// маршрутного листа. Повторный вызов не дублирует уже созданные направления.
+func createLabOrdersForVisit(ctx context.Context, q dbExecutor, visitID int64) ([]LabOrder, error) {
+	var (
+		patientUID, clinicID string
+		routeSheet           []byte
+	)
+	err := q.QueryRow(ctx, `SELECT employee_id, COALESCE(clinic_id, ''), COALESCE(route_sheet, '[]'::jsonb) FROM employee_visits WHERE id = $1`, visitID).
+		Scan(&patientUID, &clinicID, &routeSheet)
+	if err != nil {
+		return nil, err
+	}
+	var steps []RouteStep
+	if err := json.Unmarshal(routeSheet, &steps); err != nil {
+		return nil, fmt.Errorf("invalid route sheet: %w", err)
+	}
+
+	// Образец привязан к эпизоду; если визит создан без него, эпизод заводится здесь,
+	// иначе у направления не было бы штрихкода
+	episodeID, err := ensureEpisodeForVisit(ctx, q, visitID, ExamTypePeriodic)
+	if err != nil {
+		return nil, fmt.Errorf("episode for visit %d: %w", visitID, err)
+	}
+
+	for _, step := range steps {
+		if step.Type != "research" {
+			continue
+		}
+		for specimenType, panel := range classifyResearch(step.Specialty) {
+			var orderID int64
+			err := q.QueryRow(ctx, `
+INSERT INTO lab_orders (visit_id, episode_id, patient_uid, clinic_id, research, specimen_type, panel)
+VALUES ($1, $2, $3, NULLIF($4, ''), $5, $6, NULLIF($7, ''))
+ON CONFLICT (visit_id, research, specimen_type) DO UPDATE SET episode_id = COALESCE(lab_orders.episode_id, EXCLUDED.episode_id)
+RETURNING id
+`, visitID, episodeID, patientUID, clinicID, step.Specialty, specimenType, panel).Scan(&orderID)
+			if err != nil {
+				return nil, err
+			}
+			// Штрихкод: 12 цифр (набор C в Code128 - самая короткая этикетка).
+			// Направлению, созданному раньше без штрихкода, он выдаётся при повторном вызове
+			_, err = q.Exec(ctx, `
+INSERT INTO lab_specimens (barcode, episode_id, patient_uid, order_id)
+SELECT '2' || lpad(nextval('lab_specimen_seq')::text, 11, '0'), $2, $3, $1
+WHERE NOT EXISTS (SELECT 1 FROM lab_specimens WHERE order_id = $1)
+ON CONFLICT (order_id) DO NOTHING
+`, orderID, episodeID, patientUID)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return queryLabOrders(ctx, q, `WHERE o.visit_id = $1 ORDER BY o.id`, visitID)
+}
+
+const labOrderSelect = `
+SELECT o.id, o.visit_id, o.episode_id, o.patient_uid, COALESCE(v.employee_name, ''), COALESCE(o.clinic_id, ''),
+       o.research, o.specimen_type, COALESCE(o.panel, ''), o.created_at,
+       COALESCE(s.barcode, ''), COALESCE(s.status, 'ordered'), s.collected_at, s.collected_by,
+       s.received_at, s.received_by, s.resulted_at, s.resulted_by
+FROM lab_orders o
+JOIN employee_visits v ON v.id = o.visit_id
+LEFT JOIN lab_specimens s ON s.order_id = o.id
+`
+
+func queryLabOrders(ctx context.Context, q dbExecutor, where string, args ...interface{}) ([]LabOrder, error) {
+	rows, err := q.Query(ctx, labOrderSelect+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	fmtTime := func(t *time.Time) *string {
+		if t == nil {
+			return nil
+		}
+		s := t.Format(time.RFC3339)
+		return &s
+	}
+
+	res := make([]LabOrder, 0)
+	for rows.Next() {
+		var (
+			o                                   LabOrder
+			created                             time.Time
+			collectedAt, receivedAt, resultedAt *time.Time
+		)
+		if err := rows.Scan(&o.ID, &o.VisitID, &o.EpisodeID, &o.PatientUID, &o.EmployeeName, &o.ClinicID,
+			&o.Research, &o.SpecimenType, &o.Panel, &created,
+			&o.Specimen.Barcode, &o.Specimen.Status, &collectedAt, &o.Specimen.CollectedBy,
+			&receivedAt, &o.Specimen.ReceivedBy, &resultedAt, &o.Specimen.ResultedBy); err != nil {
+			return nil, err
+		}
+		o.CreatedAt = created.Format(time.RFC3339)
+		o.Specimen.CollectedAt = fmtTime(collectedAt)
+		o.Specimen.ReceivedAt = fmtTime(receivedAt)
+		o.Specimen.ResultedAt = fmtTime(resultedAt)
+		res = append(res, o)
+	}
+	return res, rows.Err()
+}
+
+// markSpecimenResulted отмечает образец выполненным при поступлении результата
+// и закрывает соответствующий пункт маршрутного листа.
+func markSpecimenResulted(ctx context.Context, q dbExecutor, barcode string) error {
+	var orderID *int64
+	err := q.QueryRow(ctx, `
+UPDATE lab_specimens SET
+  status = 'resulted',
+  received_at = COALESCE(received_at, NOW()),
+  resulted_at = COALESCE(resulted_at, NOW())
+WHERE barcode = $1
+RETURNING order_id
+`, barcode).Scan(&orderID)
+	if err == pgx.ErrNoRows || (err == nil && orderID == nil) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var visitID int64
+	var research string
+	if err := q.QueryRow(ctx, `SELECT visit_id, research FROM lab_orders WHERE id = $1`, *orderID).Scan(&visitID, &research); err != nil {
+		return err
+	}
+	return completeResearchStep(ctx, q, visitID, research)
+}
+
+// completeResearchStep закрывает пункт маршрутного листа, когда все образцы по нему выполнены
+func completeResearchStep(ctx context.Context, q dbExecutor, visitID int64, research string) error {
+	tag, err := q.Exec(ctx, `
+UPDATE employee_visits SET
+  route_sheet = (
+    SELECT jsonb_agg(
+      CASE
+        WHEN item->>'type' = 'research' AND item->>'specialty' = $2
+        THEN item || jsonb_build_object('status', 'completed', 'completedAt', NOW())
+        ELSE item
+      END
+    )
+    FROM jsonb_array_elements(route_sheet) AS item
+  ),
+  updated_at = NOW()
+WHERE id = $1
+  AND NOT EXISTS (
+    SELECT 1 FROM lab_orders o JOIN lab_specimens s ON s.order_id = o.id
+    WHERE o.visit_id = $1 AND o.research = $2 AND s.status <> 'resulted'
+  )
+`, visitID, research)
+	if err == nil && tag.RowsAffected() > 0 {
+		invalidateContractStatsForVisit(ctx, q, visitID)
+	}
+	return err
+}
+
+// --- HANDLERS ---
+
+// authorizeLabStaff - направления и образцы видят только сотрудники клиники
+func authorizeLabStaff(ctx context.Context, w http.ResponseWriter, r *http.Request) (*User, string, bool) {
+	user, ok := requestUser(ctx, w, r)
+	if !ok {
+		return nil, "", false
+	}
+	clinicID := userClinicID(user)
+	if !isClinicStaff(user) || clinicID == "" {
+		errorResponse(w, http.StatusForbidden, "only clinic staff can work with lab orders")
+		return nil, "", false
+	}
+	return user, clinicID, true
+}
+
+func visitLabOrdersHandler(w http.ResponseWriter, r *http.Request, visitID int64) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	_, clinicID, ok := authorizeLabStaff(ctx, w, r)
+	if !ok {
+		return
+	}
+	var visitClinic string
+	err := db.QueryRow(ctx, `SELECT COALESCE(clinic_id, '') FROM employee_visits WHERE id = $1`, visitID).Scan(&visitClinic)
+	if errors.Is(err, pgx.ErrNoRows) {
+		errorResponse(w, http.StatusNotFound, "visit not found")
+		return
+	}
+	if err != nil {
+		log.Printf("visitLabOrders %d: %v", visitID, err)
+		errorResponse(w, http.StatusInternalServerError, "db error")
+		return
+	}
+	if visitClinic != clinicID {
+		errorResponse(w, http.StatusForbidden, "visit belongs to another clinic")
+		return
+	}
+
+	var orders []LabOrder
+	if r.Method == http.MethodPost {
+		orders, err = createLabOrdersForVisit(ctx, db, visitID)
+	} else {
+		orders, err = queryLabOrders(ctx, db, `WHERE o.visit_id = $1 ORDER BY o.id`, visitID)
+	}
+	if err != nil {
+		log.Printf("visitLabOrders error: %v", err)
+		errorResponse(w, http.StatusInternalServerError, "db error")
+		return
+	}
+	jsonResponse(w, http.StatusOK, orders)
+}
+
+// labQueueHandler - очередь лаборатории: невыполненные образцы клиники
+func labQueueHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	_, clinicID, ok := authorizeLabStaff(ctx, w, r)
+	if !ok {
+		return
+	}
+	q := r.URL.Query()
+	if c := q.Get("clinicId"); c != "" && c != clinicID {
+		errorResponse(w, http.StatusForbidden, "access denied")
+		return
+	}
+
+	where := `WHERE o.clinic_id = $1`
+	args := []interface{}{clinicID}
+	if status := q.Get("status"); status != "" {
+		args = append(args, status)
+		where += fmt.Sprintf(" AND s.status = $%d", len(args))
+	} else {
+		where += " AND s.status <> 'resulted'"
+	}
+	if t := q.Get("specimenType"); t != "" {
+		args = append(args, t)
+		where += fmt.Sprintf(" AND o.specimen_type = $%d", len(args))
+	}
+	where += " ORDER BY o.created_at, o.id"
+
+	orders, err := queryLabOrders(ctx, db, where, args...)
+	if err != nil {
+		log.Printf("labQueue error: %v", err)
+		errorResponse(w, http.StatusInternalServerError, "db error")
+		return
+	}
+	jsonResponse(w, http.StatusOK, orders)
+}
+
+// specimenLabelHandler отдаёт этикетку: PNG (Code128), ZPL для термопринтера или JSON
+func specimenLabelHandler(w http.ResponseWriter, r *http.Request, barcode string) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	_, clinicID, ok := authorizeLabStaff(ctx, w, r)
+	if !ok {
+		return
+	}
+	orders, err := queryLabOrders(ctx, db, `WHERE s.barcode = $1`, barcode)
+	if err != nil {
+		log.Printf("specimenLabel error: %v", err)
+		errorResponse(w, http.StatusInternalServerError, "db error")
+		return
+	}
+	if len(orders) == 0 {
+		errorResponse(w, http.StatusNotFound, "specimen not found")
+		return
+	}
+	o := orders[0]
+	if o.ClinicID != clinicID {
+		errorResponse(w, http.StatusForbidden, "specimen belongs to another clinic")
+		return
+	}
+	created, _ := time.Parse(time.RFC3339, o.CreatedAt)
+
+	switch r.URL.Query().Get("format") {
+	case "png":
+		scale, _ := strconv.Atoi(r.URL.Query().Get("scale"))
+		if scale <= 0 || scale > 10 {
+			scale = 2
+		}
+		img, err := renderCode128PNG(barcode, scale)
+		if err != nil {
+			errorResponse(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(img)
+	case "zpl":
+		// ^CI28 - UTF-8, чтобы ФИО печаталось кириллицей
+		label := fmt.Sprintf("^XA\n^CI28\n"+
+			"^FO30,20^A0N,28,28^FD%s^FS\n"+
+			"^FO30,55^A0N,24,24^FD%s^FS\n"+
+			"^FO30,90^BY2^BCN,80,Y,N,N^FD%s^FS\n"+
+			"^FO30,200^A0N,22,22^FD%s^FS\n"+
+			"^XZ\n",
+			zplField(o.EmployeeName), zplField(o.Research), barcode, created.Format("02.01.2006"))
+		w.Header().Set("Content-Type", "application/zpl; charset=utf-8")
+		w.Write([]byte(label))
+	default:
+		jsonResponse(w, http.StatusOK, map[string]interface{}{
+			"barcode":      barcode,
+			"employeeName": o.EmployeeName,
+			"patientUid":   o.PatientUID,
+			"research":     o.Research,
+			"specimenType": o.SpecimenType,
+			"date":         created.Format("2006-01-02"),
+		})
+	}
+}
+
+var (
+	errSpecimenNotFound = errors.New("specimen not found")
+	errSpecimenForeign  = errors.New("specimen belongs to another clinic")
+	errSpecimenStatus   = errors.New("specimen status does not allow this action")
+)
+
+// specimenTransitions - из каких состояний возможно действие и что оно меняет; $2 - кто отметил.
+// Отметка "result" вручную нужна для флюорографии, где результат не приходит с анализатора.
+var specimenTransitions = map[string]struct {
+	from []string
+	set  string
+}{
+	"collect": {[]string{SpecimenOrdered}, "status = 'collected', collected_at = NOW(), collected_by = NULLIF($2, '')"},
+	"receive": {[]string{SpecimenCollected}, "status = 'received', received_at = NOW(), received_by = NULLIF($2, '')"},
+	"result": {[]string{SpecimenCollected, SpecimenReceived},
+		"status = 'resulted', received_at = COALESCE(received_at, NOW()), resulted_at = NOW(), resulted_by = NULLIF($2, '')"},
+}
+
+// transitionSpecimen переводит образец клиники clinicID в следующее состояние внутри транзакции q
+func transitionSpecimen(ctx context.Context, q dbExecutor, clinicID, userID, barcode, action string) (*LabOrder, error) {
+	t, ok := specimenTransitions[action]
+	if !ok {
+		return nil, fmt.Errorf("unknown specimen action %q", action)
+	}
+	var status, specimenClinic string
+	err := q.QueryRow(ctx, `
+SELECT s.status, COALESCE(o.clinic_id, e.clinic_id, '')
+FROM lab_specimens s
+LEFT JOIN lab_orders o ON o.id = s.order_id
+LEFT JOIN exam_episodes e ON e.id = s.episode_id
+WHERE s.barcode = $1
+FOR UPDATE OF s
+`, barcode).Scan(&status, &specimenClinic)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, errSpecimenNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if specimenClinic != clinicID {
+		return nil, errSpecimenForeign
+	}
+	allowed := false
+	for _, s := range t.from {
+		allowed = allowed || s == status
+	}
+	if !allowed {
+		return nil, fmt.Errorf("%w: cannot %s specimen in status %s", errSpecimenStatus, action, status)
+	}
+
+	if _, err := q.Exec(ctx, `UPDATE lab_specimens SET `+t.set+` WHERE barcode = $1`, barcode, userID); err != nil {
+		return nil, err
+	}
+	orders, err := queryLabOrders(ctx, q, `WHERE s.barcode = $1`, barcode)
+	if err != nil {
+		return nil, err
+	}
+	if len(orders) == 0 {
+		return nil, errSpecimenNotFound
+	}
+	o := orders[0]
+	if action == "result" {
+		if err := completeResearchStep(ctx, q, o.VisitID, o.Research); err != nil {
+			return nil, fmt.Errorf("route sheet: %w", err)
+		}
+	}
+	return &o, nil
+}
+
+// specimenTransitionHandler - взятие биоматериала, приём в лаборатории и отметка о результате
+func specimenTransitionHandler(w http.ResponseWriter, r *http.Request, barcode, action string) {
+	if _, ok := specimenTransitions[action]; !ok {
+		errorResponse(w, http.StatusNotFound, "not found")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	user, clinicID, ok := authorizeLabStaff(ctx, w, r)
+	if !ok {
+		return
+	}
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		errorResponse(w, http.StatusInternalServerError, "db error")
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	o, err := transitionSpecimen(ctx, tx, clinicID, user.ID, barcode, action)
+	switch {
+	case errors.Is(err, errSpecimenNotFound):
+		errorResponse(w, http.StatusNotFound, "specimen not found")
+		return
+	case errors.Is(err, errSpecimenForeign):
+		errorResponse(w, http.StatusForbidden, err.Error())
+		return
+	case errors.Is(err, errSpecimenStatus):
+		errorResponse(w, http.StatusConflict, err.Error())
+		return
+	case err != nil:
+		log.Printf("specimenTransition %s %s: %v", barcode, action, err)
+		errorResponse(w, http.StatusInternalServerError, "db error")
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		errorResponse(w, http.StatusInternalServerError, "db error")
+		return
+	}
+
+	if o.ClinicID != "" {
+		broadcastToUser(o.ClinicID, "lab_specimen_updated", map[string]interface{}{
+			"barcode": barcode,
+			"status":  o.Specimen.Status,
+			"visitId": o.VisitID,
+		})
+	}
+	jsonResponse(w, http.StatusOK, o)
+}
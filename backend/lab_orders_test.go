@@ -0,0 +1,294 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+func TestClassifyResearch(t *testing.T) {
+	cases := []struct {
+		name string
+		want map[string]string
+	}{
+		{"Общий анализ крови", map[string]string{SpecimenBlood: "general-blood"}},
+		{"ОАК", map[string]string{SpecimenBlood: "general-blood"}},
+		{"Биохимический анализ крови", map[string]string{SpecimenBlood: "biochemistry"}},
+		{"Анализ крови на сахар", map[string]string{SpecimenBlood: "biochemistry"}},
+		{"Кровь: АЛТ, АСТ, билирубин", map[string]string{SpecimenBlood: "biochemistry"}},
+		{"Глюкоза крови", map[string]string{SpecimenBlood: "biochemistry"}},
+		{"Общий анализ мочи", map[string]string{SpecimenUrine: "general-urine"}},
+		{"Глюкоза в моче", map[string]string{SpecimenUrine: "general-urine"}},
+		{"Анализ мочи и крови", map[string]string{SpecimenBlood: "general-blood", SpecimenUrine: "general-urine"}},
+		{"ОАК, ОАМ", map[string]string{SpecimenBlood: "general-blood", SpecimenUrine: "general-urine"}},
+		{"Флюорография", map[string]string{SpecimenImaging: ""}},
+		{"ФГ", map[string]string{SpecimenImaging: ""}},
+		{"ФГ органов грудной клетки", map[string]string{SpecimenImaging: ""}},
+		{"Рентгенография грудной клетки", map[string]string{SpecimenImaging: ""}},
+
+		// Короткие сокращения не ищутся внутри слов
+		{"Консультация по Алтайскому краю", map[string]string{}},
+		{"Пастеризация", map[string]string{}},
+		{"ЭКГ", map[string]string{}},
+		{"Спирография", map[string]string{}},
+		{"Аудиометрия", map[string]string{}},
+	}
+	for _, tc := range cases {
+		if got := classifyResearch(tc.name); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("classifyResearch(%q) = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+// decodeCode128 разбирает модули обратно в значения символов и проверяет стоп-символ
+func decodeCode128(t *testing.T, modules []bool) []int {
+	t.Helper()
+	var widths []byte
+	for i := 0; i < len(modules); {
+		j := i
+		for j < len(modules) && modules[j] == modules[i] {
+			j++
+		}
+		widths = append(widths, byte('0'+j-i))
+		i = j
+	}
+	if !modules[0] || (len(widths)-7)%6 != 0 {
+		t.Fatalf("unexpected module structure: %s", widths)
+	}
+	if stop := string(widths[len(widths)-7:]); stop != code128Stop {
+		t.Fatalf("stop = %s", stop)
+	}
+	var values []int
+	for i := 0; i+7 < len(widths); i += 6 {
+		pattern := string(widths[i : i+6])
+		value := -1
+		for v, p := range code128Patterns {
+			if p == pattern {
+				value = v
+			}
+		}
+		if value < 0 {
+			t.Fatalf("unknown symbol %s", pattern)
+		}
+		values = append(values, value)
+	}
+	return values
+}
+
+func TestEncodeCode128(t *testing.T) {
+	cases := []struct {
+		value string
+		want  []int // старт, данные, контрольный символ
+	}{
+		// Штрихкод образца - набор C: 105 + 20·1 + 42·6 = 377, 377 mod 103 = 68
+		{"200000000042", []int{105, 20, 0, 0, 0, 0, 42, 68}},
+		// Набор B: 104 + 48·1 + 42·2 + 42·3 + 17·4 + 18·5 + 19·6 + 35·7 = 879, 879 mod 103 = 55
+		{"PJJ123C", []int{104, 48, 42, 42, 17, 18, 19, 35, 55}},
+		// Нечётное число цифр - набор B
+		{"123", []int{104, 17, 18, 19, (104 + 17 + 18*2 + 19*3) % 103}},
+	}
+	for _, tc := range cases {
+		modules, err := encodeCode128(tc.value)
+		if err != nil {
+			t.Fatalf("%q: %v", tc.value, err)
+		}
+		if len(modules) != 11*len(tc.want)+13 {
+			t.Errorf("%q: %d modules, want %d", tc.value, len(modules), 11*len(tc.want)+13)
+		}
+		if got := decodeCode128(t, modules); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%q: symbols %v, want %v", tc.value, got, tc.want)
+		}
+	}
+
+	for _, bad := range []string{"", "кириллица", "tab\there", strings.Repeat("1", barcodeMaxText+1)} {
+		if _, err := encodeCode128(bad); err == nil {
+			t.Errorf("%q must be rejected", bad)
+		}
+	}
+}
+
+func TestCode128PatternTable(t *testing.T) {
+	if len(code128Patterns) != 106 {
+		t.Fatalf("%d patterns, want 106", len(code128Patterns))
+	}
+	seen := map[string]bool{}
+	for v, p := range code128Patterns {
+		sum := 0
+		for _, w := range p {
+			sum += int(w - '0')
+		}
+		if len(p) != 6 || sum != 11 || seen[p] {
+			t.Errorf("pattern %d = %s", v, p)
+		}
+		seen[p] = true
+	}
+	// Опорные символы стандарта: пробел, старт B, старт C
+	for v, want := range map[int]string{0: "212222", code128StartB: "211214", code128StartC: "211232"} {
+		if code128Patterns[v] != want {
+			t.Errorf("pattern %d = %s, want %s", v, code128Patterns[v], want)
+		}
+	}
+}
+
+var (
+	placeholderPattern = regexp.MustCompile(`\$(\d+)`)
+	setStatusPattern   = regexp.MustCompile(`status = '(\w+)'`)
+)
+
+// checkArgs, как и pgx, отклоняет запрос, число параметров которого не совпадает с аргументами
+func checkArgs(sql string, args []any) error {
+	n := 0
+	for _, m := range placeholderPattern.FindAllStringSubmatch(sql, -1) {
+		if i, _ := strconv.Atoi(m[1]); i > n {
+			n = i
+		}
+	}
+	if n != len(args) {
+		return fmt.Errorf("expected %d arguments, got %d", n, len(args))
+	}
+	return nil
+}
+
+// fakeSpecimenDB - один образец клиники clinic-a в заданном состоянии
+type fakeSpecimenDB struct {
+	status     string
+	clinicID   string
+	set        string
+	markedBy   any
+	routeSheet bool
+}
+
+func (f *fakeSpecimenDB) QueryRow(ctx context.Context, sql string, args ...any) pgx.Row {
+	if err := checkArgs(sql, args); err != nil {
+		return fakeRow{err: err}
+	}
+	if strings.Contains(sql, "FROM lab_specimens s") && strings.Contains(sql, "FOR UPDATE") {
+		return fakeRow{vals: []any{f.status, f.clinicID}}
+	}
+	return fakeRow{err: errors.New("unexpected query: " + sql)}
+}
+
+func (f *fakeSpecimenDB) Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error) {
+	if err := checkArgs(sql, args); err != nil {
+		return pgconn.CommandTag{}, err
+	}
+	switch {
+	case strings.HasPrefix(sql, "UPDATE lab_specimens SET "):
+		f.set, f.markedBy = sql, args[1]
+		f.status = setStatusPattern.FindStringSubmatch(sql)[1]
+		return pgconn.NewCommandTag("UPDATE 1"), nil
+	case strings.Contains(sql, "UPDATE employee_visits"):
+		f.routeSheet = true
+		return pgconn.NewCommandTag("UPDATE 0"), nil
+	}
+	return pgconn.CommandTag{}, errors.New("unexpected exec: " + sql)
+}
+
+func (f *fakeSpecimenDB) Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error) {
+	if err := checkArgs(sql, args); err != nil {
+		return nil, err
+	}
+	episodeID := int64(5)
+	return &fakeRows{rows: [][]any{{
+		int64(1), int64(7), &episodeID, "emp-a", "Иванов Иван", f.clinicID,
+		"Флюорография", SpecimenImaging, "", time.Date(2026, 3, 2, 8, 0, 0, 0, time.UTC),
+		"200000000042", f.status, (*time.Time)(nil), (*string)(nil),
+		(*time.Time)(nil), (*string)(nil), (*time.Time)(nil), (*string)(nil),
+	}}}, nil
+}
+
+func TestTransitionSpecimenRunsEveryAction(t *testing.T) {
+	ctx := context.Background()
+	for _, tc := range []struct {
+		action, from, want string
+	}{
+		{"collect", SpecimenOrdered, SpecimenCollected},
+		{"receive", SpecimenCollected, SpecimenReceived},
+		{"result", SpecimenReceived, SpecimenResulted},
+		// Флюорография отмечается выполненной без приёма в лаборатории
+		{"result", SpecimenCollected, SpecimenResulted},
+	} {
+		q := &fakeSpecimenDB{status: tc.from, clinicID: "clinic-a"}
+		o, err := transitionSpecimen(ctx, q, "clinic-a", "doc-a", "200000000042", tc.action)
+		if err != nil {
+			t.Errorf("%s from %s: %v", tc.action, tc.from, err)
+			continue
+		}
+		if o.Specimen.Status != tc.want || q.markedBy != "doc-a" {
+			t.Errorf("%s from %s: status %s, marked by %v", tc.action, tc.from, o.Specimen.Status, q.markedBy)
+		}
+		if q.routeSheet != (tc.action == "result") {
+			t.Errorf("%s: route sheet updated = %v", tc.action, q.routeSheet)
+		}
+	}
+	if len(specimenTransitions) != 3 {
+		t.Errorf("%d transitions, the test covers 3", len(specimenTransitions))
+	}
+}
+
+func TestTransitionSpecimenRejects(t *testing.T) {
+	ctx := context.Background()
+	for _, tc := range []struct {
+		name, action, status, clinic string
+		want                         error
+	}{
+		{"foreign clinic", "collect", SpecimenOrdered, "clinic-b", errSpecimenForeign},
+		{"specimen without clinic", "collect", SpecimenOrdered, "", errSpecimenForeign},
+		{"receive before collect", "receive", SpecimenOrdered, "clinic-a", errSpecimenStatus},
+		{"collect twice", "collect", SpecimenCollected, "clinic-a", errSpecimenStatus},
+		{"result twice", "result", SpecimenResulted, "clinic-a", errSpecimenStatus},
+	} {
+		q := &fakeSpecimenDB{status: tc.status, clinicID: tc.clinic}
+		_, err := transitionSpecimen(ctx, q, "clinic-a", "doc-a", "200000000042", tc.action)
+		if !errors.Is(err, tc.want) || q.set != "" {
+			t.Errorf("%s: err = %v, update = %q", tc.name, err, q.set)
+		}
+	}
+}
+
+// Очередь, этикетки и движение образцов доступны только сотрудникам своей клиники;
+// отказ происходит до обращения к базе
+func TestLabHandlersRequireClinicStaff(t *testing.T) {
+	prev := records
+	records = memRecordStore{}
+	defer func() { records = prev }()
+
+	label := func(w http.ResponseWriter, r *http.Request) { specimenLabelHandler(w, r, "200000000042") }
+	collect := func(w http.ResponseWriter, r *http.Request) {
+		specimenTransitionHandler(w, r, "200000000042", "collect")
+	}
+	orders := func(w http.ResponseWriter, r *http.Request) { visitLabOrdersHandler(w, r, 7) }
+	for _, tc := range []struct {
+		name   string
+		user   *User
+		method string
+		target string
+		h      func(http.ResponseWriter, *http.Request)
+		want   int
+	}{
+		{"anonymous queue", nil, http.MethodGet, "/api/lab/queue?clinicId=clinic-a", labQueueHandler, http.StatusUnauthorized},
+		{"employer queue", orgA, http.MethodGet, "/api/lab/queue?clinicId=clinic-a", labQueueHandler, http.StatusForbidden},
+		{"patient queue", employeA, http.MethodGet, "/api/lab/queue", labQueueHandler, http.StatusForbidden},
+		{"foreign clinic queue", doctorB, http.MethodGet, "/api/lab/queue?clinicId=clinic-a", labQueueHandler, http.StatusForbidden},
+		{"anonymous label", nil, http.MethodGet, "/api/lab/specimens/200000000042/label", label, http.StatusUnauthorized},
+		{"employer label", orgA, http.MethodGet, "/api/lab/specimens/200000000042/label", label, http.StatusForbidden},
+		{"anonymous transition", nil, http.MethodPost, "/api/lab/specimens/200000000042/collect", collect, http.StatusUnauthorized},
+		{"patient transition", employeA, http.MethodPost, "/api/lab/specimens/200000000042/collect", collect, http.StatusForbidden},
+		{"anonymous orders", nil, http.MethodGet, "/api/visits/7/lab-orders", orders, http.StatusUnauthorized},
+		{"employer orders", orgA, http.MethodPost, "/api/visits/7/lab-orders", orders, http.StatusForbidden},
+	} {
+		if rec := callAs(t, tc.user, tc.method, tc.target, "", tc.h); rec.Code != tc.want {
+			t.Errorf("%s: status %d, want %d", tc.name, rec.Code, tc.want)
+		}
+	}
+}
@@ -0,0 +1,747 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// --- LAB MODULE (каталог исследований, типизированные результаты, флаги отклонений) ---
+
+// Флаги результатов (как в HL7 OBX-8)
+const (
+	LabFlagNormal       = "N"
+	LabFlagHigh         = "H"
+	LabFlagLow          = "L"
+	LabFlagCriticalHigh = "HH"
+	LabFlagCriticalLow  = "LL"
+	LabFlagAbnormal     = "A" // качественный результат не совпал с нормой
+)
+
+// LabReferenceRange - референсный интервал для пола/возраста. Пустой Sex - для всех.
+type LabReferenceRange struct {
+	ID           int64    `json:"id,omitempty"`
+	Sex          string   `json:"sex,omitempty"` // "M" | "F" | ""
+	AgeMin       *int     `json:"ageMin,omitempty"`
+	AgeMax       *int     `json:"ageMax,omitempty"`
+	Low          *float64 `json:"low,omitempty"`
+	High         *float64 `json:"high,omitempty"`
+	CriticalLow  *float64 `json:"criticalLow,omitempty"`
+	CriticalHigh *float64 `json:"criticalHigh,omitempty"`
+}
+
+// LabTest - позиция каталога исследований
+type LabTest struct {
+	Code       string              `json:"code"`
+	Name       string              `json:"name"`
+	Panel      string              `json:"panel,omitempty"` // "general-blood", "biochemistry" ...
+	Unit       string              `json:"unit,omitempty"`
+	ValueType  string              `json:"valueType"`            // "numeric" | "text"
+	NormalText string              `json:"normalText,omitempty"` // норма для качественных тестов
+	Ranges     []LabReferenceRange `json:"ranges"`
+}
+
+// LabObservation - один результат исследования в эпизоде
+type LabObservation struct {
+	ID              int64    `json:"id"`
+	EpisodeID       int64    `json:"episodeId"`
+	PatientUID      string   `json:"patientUid"`
+	TestCode        string   `json:"testCode"`
+	TestName        string   `json:"testName,omitempty"`
+	ValueNum        *float64 `json:"valueNum,omitempty"`
+	ValueText       string   `json:"valueText,omitempty"`
+	Unit            string   `json:"unit,omitempty"`
+	Flag            string   `json:"flag"`
+	RefLow          *float64 `json:"refLow,omitempty"`
+	RefHigh         *float64 `json:"refHigh,omitempty"`
+	ObservedAt      string   `json:"observedAt"`
+	Source          string   `json:"source"`
+	SpecimenBarcode *string  `json:"specimenBarcode,omitempty"`
+}
+
+func migrateLabs(ctx context.Context, tx pgx.Tx) error {
+	_, err := tx.Exec(ctx, `
+CREATE TABLE IF NOT EXISTS lab_tests (
+  code        TEXT PRIMARY KEY,
+  name        TEXT NOT NULL,
+  panel       TEXT,
+  unit        TEXT,
+  value_type  TEXT NOT NULL DEFAULT 'numeric',
+  normal_text TEXT,
+  CONSTRAINT valid_lab_value_type CHECK (value_type IN ('numeric', 'text'))
+);
+`)
+	if err != nil {
+		return fmt.Errorf("migrate lab_tests: %w", err)
+	}
+
+	_, err = tx.Exec(ctx, `
+CREATE TABLE IF NOT EXISTS lab_reference_ranges (
+  id            SERIAL PRIMARY KEY,
+  test_code     TEXT NOT NULL REFERENCES lab_tests(code) ON DELETE CASCADE,
+  sex           TEXT NOT NULL DEFAULT '',
+  age_min       INTEGER,
+  age_max       INTEGER,
+  low           DOUBLE PRECISION,
+  high          DOUBLE PRECISION,
+  critical_low  DOUBLE PRECISION,
+  critical_high DOUBLE PRECISION
+);
+`)
+	if err != nil {
+		return fmt.Errorf("migrate lab_reference_ranges: %w", err)
+	}
+
+	_, err = tx.Exec(ctx, `
+CREATE TABLE IF NOT EXISTS lab_observations (
+  id          SERIAL PRIMARY KEY,
+  episode_id  INTEGER NOT NULL REFERENCES exam_episodes(id) ON DELETE CASCADE,
+  patient_uid TEXT NOT NULL,
+  test_code   TEXT NOT NULL REFERENCES lab_tests(code),
+  value_num   DOUBLE PRECISION,
+  value_text  TEXT,
+  unit        TEXT,
+  flag        TEXT NOT NULL DEFAULT 'N',
+  ref_low     DOUBLE PRECISION,
+  ref_high    DOUBLE PRECISION,
+  observed_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  source      TEXT NOT NULL DEFAULT 'manual',
+  created_at  TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+`)
+	if err != nil {
+		return fmt.Errorf("migrate lab_observations: %w", err)
+	}
+
+	_, err = tx.Exec(ctx, `CREATE INDEX IF NOT EXISTS idx_lab_observations_patient ON lab_observations(patient_uid, test_code, observed_at);`)
+	if err != nil {
+		return fmt.Errorf("create index lab_observations_patient: %w", err)
+	}
+
+	_, err = tx.Exec(ctx, `CREATE INDEX IF NOT EXISTS idx_lab_observations_episode ON lab_observations(episode_id);`)
+	if err != nil {
+		return fmt.Errorf("create index lab_observations_episode: %w", err)
+	}
+
+	// Базовый каталог (совпадает с шаблонами фронтенда src/utils/labTemplates.ts)
+	for _, t := range defaultLabCatalog() {
+		tag, err := tx.Exec(ctx, `
+INSERT INTO lab_tests (code, name, panel, unit, value_type, normal_text)
+VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''))
+ON CONFLICT (code) DO NOTHING
+`, t.Code, t.Name, t.Panel, t.Unit, t.ValueType, t.NormalText)
+		if err != nil {
+			return fmt.Errorf("seed lab_tests: %w", err)
+		}
+		if tag.RowsAffected() == 0 {
+			continue
+		}
+		for _, rr := range t.Ranges {
+			_, err = tx.Exec(ctx, `
+INSERT INTO lab_reference_ranges (test_code, sex, age_min, age_max, low, high, critical_low, critical_high)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+`, t.Code, rr.Sex, rr.AgeMin, rr.AgeMax, rr.Low, rr.High, rr.CriticalLow, rr.CriticalHigh)
+			if err != nil {
+				return fmt.Errorf("seed lab_reference_ranges: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+func f64(v float64) *float64 { return &v }
+
+func defaultLabCatalog() []LabTest {
+	numeric := func(code, name, panel, unit string, ranges ...LabReferenceRange) LabTest {
+		return LabTest{Code: code, Name: name, Panel: panel, Unit: unit, ValueType: "numeric", Ranges: ranges}
+	}
+	text := func(code, name, panel, normal string) LabTest {
+		return LabTest{Code: code, Name: name, Panel: panel, ValueType: "text", NormalText: normal, Ranges: []LabReferenceRange{}}
+	}
+	return []LabTest{
+		numeric("hemoglobin", "Гемоглобин", "general-blood", "г/л",
+			LabReferenceRange{Sex: "M", Low: f64(130), High: f64(160), CriticalLow: f64(70), CriticalHigh: f64(200)},
+			LabReferenceRange{Sex: "F", Low: f64(120), High: f64(140), CriticalLow: f64(70), CriticalHigh: f64(200)}),
+		numeric("erythrocytes", "Эритроциты", "general-blood", "×10¹²/л",
+			LabReferenceRange{Sex: "M", Low: f64(4.0), High: f64(5.0)},
+			LabReferenceRange{Sex: "F", Low: f64(3.5), High: f64(4.7)}),
+		numeric("leukocytes", "Лейкоциты", "general-blood", "×10⁹/л",
+			LabReferenceRange{Low: f64(4.0), High: f64(9.0), CriticalLow: f64(2.0), CriticalHigh: f64(30)}),
+		numeric("platelets", "Тромбоциты", "general-blood", "×10⁹/л",
+			LabReferenceRange{Low: f64(180), High: f64(320), CriticalLow: f64(50), CriticalHigh: f64(1000)}),
+		numeric("esr", "СОЭ", "general-blood", "мм/ч",
+			LabReferenceRange{Sex: "M", Low: f64(2), High: f64(10)},
+			LabReferenceRange{Sex: "F", Low: f64(2), High: f64(15)}),
+		text("color", "Цвет мочи", "general-urine", "соломенно-желтый"),
+		text("transparency", "Прозрачность мочи", "general-urine", "прозрачная"),
+		numeric("protein", "Белок в моче", "general-urine", "г/л",
+			LabReferenceRange{Low: f64(0), High: f64(0.033)}),
+		numeric("glucose", "Глюкоза в моче", "general-urine", "ммоль/л",
+			LabReferenceRange{Low: f64(0), High: f64(0.8)}),
+		numeric("leukocytes-urine", "Лейкоциты в моче", "general-urine", "в поле зрения",
+			LabReferenceRange{Low: f64(0), High: f64(3)}),
+		numeric("erythrocytes-urine", "Эритроциты в моче", "general-urine", "в поле зрения",
+			LabReferenceRange{Low: f64(0), High: f64(2)}),
+		numeric("glucose-blood", "Глюкоза крови", "biochemistry", "ммоль/л",
+			LabReferenceRange{Low: f64(3.9), High: f64(6.1), CriticalLow: f64(2.5), CriticalHigh: f64(25)}),
+		numeric("total-cholesterol", "Общий холестерин", "biochemistry", "ммоль/л",
+			LabReferenceRange{Low: f64(3.0), High: f64(6.0)}),
+		numeric("alt", "АЛТ", "biochemistry", "Ед/л",
+			LabReferenceRange{Low: f64(0), High: f64(40)}),
+		numeric("ast", "АСТ", "biochemistry", "Ед/л",
+			LabReferenceRange{Low: f64(0), High: f64(40)}),
+		numeric("creatinine", "Креатинин", "biochemistry", "мкмоль/л",
+			LabReferenceRange{Sex: "M", Low: f64(62), High: f64(106)},
+			LabReferenceRange{Sex: "F", Low: f64(44), High: f64(80)}),
+	}
+}
+
+// selectRange выбирает наиболее специфичный интервал для пола и возраста
+// (интервал с указанным полом важнее общего).
+func selectRange(ranges []LabReferenceRange, sex string, age int) *LabReferenceRange {
+	var best *LabReferenceRange
+	for i := range ranges {
+		rr := &ranges[i]
+		if rr.Sex != "" && rr.Sex != sex {
+			continue
+		}
+		if age >= 0 && ((rr.AgeMin != nil && age < *rr.AgeMin) || (rr.AgeMax != nil && age > *rr.AgeMax)) {
+			continue
+		}
+		if best == nil || (best.Sex == "" && rr.Sex != "") {
+			best = rr
+		}
+	}
+	return best
+}
+
+// flagNumeric вычисляет флаг численного результата по референсному интервалу
+func flagNumeric(v float64, rr *LabReferenceRange) string {
+	if rr == nil {
+		return LabFlagNormal
+	}
+	switch {
+	case rr.CriticalLow != nil && v < *rr.CriticalLow:
+		return LabFlagCriticalLow
+	case rr.CriticalHigh != nil && v > *rr.CriticalHigh:
+		return LabFlagCriticalHigh
+	case rr.Low != nil && v < *rr.Low:
+		return LabFlagLow
+	case rr.High != nil && v > *rr.High:
+		return LabFlagHigh
+	}
+	return LabFlagNormal
+}
+
+// flagText сравнивает качественный результат с нормой без учёта регистра
+func flagText(v, normal string) string {
+	if normal == "" || strings.EqualFold(strings.TrimSpace(v), strings.TrimSpace(normal)) {
+		return LabFlagNormal
+	}
+	return LabFlagAbnormal
+}
+
+// parseLabNumber разбирает число с запятой или точкой ("5,4" -> 5.4)
+func parseLabNumber(s string) (float64, bool) {
+	s = strings.TrimSpace(strings.ReplaceAll(s, ",", "."))
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+		return 0, false
+	}
+	return v, true
+}
+
+// patientSexAndAge берёт пол и возраст пациента из паспортной части карты (-1, если неизвестен)
+func patientSexAndAge(ctx context.Context, q dbExecutor, patientUID string, at time.Time) (string, int) {
+	var gender, dob *string
+	err := q.QueryRow(ctx, `SELECT general->>'gender', general->>'dob' FROM ambulatory_cards WHERE patient_uid = $1`, patientUID).Scan(&gender, &dob)
+	if err != nil {
+		return "", -1
+	}
+	sex := ""
+	if gender != nil {
+		switch strings.ToLower(*gender) {
+		case "male", "м", "m":
+			sex = "M"
+		case "female", "ж", "f":
+			sex = "F"
+		}
+	}
+	age := -1
+	if dob != nil {
+		for _, layout := range []string{"2006-01-02", "02.01.2006"} {
+			if d, err := time.Parse(layout, *dob); err == nil {
+				age = ageAt(d, at)
+				break
+			}
+		}
+	}
+	return sex, age
+}
+
+// ageAt - полных лет на дату at. Сравниваются месяц и день, а не номер дня в году:
+// в високосный год YearDay после февраля сдвигается на единицу.
+func ageAt(dob, at time.Time) int {
+	age := at.Year() - dob.Year()
+	if at.Month() < dob.Month() || (at.Month() == dob.Month() && at.Day() < dob.Day()) {
+		age--
+	}
+	return age
+}
+
+func loadLabTest(ctx context.Context, q dbExecutor, code string) (*LabTest, error) {
+	var t LabTest
+	var panel, unit, normal *string
+	err := q.QueryRow(ctx, `SELECT code, name, panel, unit, value_type, normal_text FROM lab_tests WHERE code = $1`, code).
+		Scan(&t.Code, &t.Name, &panel, &unit, &t.ValueType, &normal)
+	if err != nil {
+		return nil, err
+	}
+	if panel != nil {
+		t.Panel = *panel
+	}
+	if unit != nil {
+		t.Unit = *unit
+	}
+	if normal != nil {
+		t.NormalText = *normal
+	}
+
+	rows, err := q.Query(ctx, `
+SELECT id, sex, age_min, age_max, low, high, critical_low, critical_high
+FROM lab_reference_ranges WHERE test_code = $1 ORDER BY id
+`, code)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	t.Ranges = []LabReferenceRange{}
+	for rows.Next() {
+		var rr LabReferenceRange
+		if err := rows.Scan(&rr.ID, &rr.Sex, &rr.AgeMin, &rr.AgeMax, &rr.Low, &rr.High, &rr.CriticalLow, &rr.CriticalHigh); err != nil {
+			return nil, err
+		}
+		t.Ranges = append(t.Ranges, rr)
+	}
+	return &t, rows.Err()
+}
+
+// LabResultInput - входящий результат (вручную или от анализатора)
+type LabResultInput struct {
+	TestCode        string `json:"testCode"`
+	Value           string `json:"value"`
+	Unit            string `json:"unit,omitempty"`
+	ObservedAt      string `json:"observedAt,omitempty"`
+	SpecimenBarcode string `json:"specimenBarcode,omitempty"`
+}
+
+// labInputError - ошибка в присланном результате (неизвестный тест, не число), в отличие от ошибок базы
+type labInputError struct{ msg string }
+
+func (e *labInputError) Error() string { return e.msg }
+
+// recordLabResult сохраняет результат с флагом и дублирует его в episode.lab_results
+// (формат карты 052/у: testName -> {date, value, norm}).
+func recordLabResult(ctx context.Context, q dbExecutor, episodeID int64, patientUID string, in LabResultInput, source string) (*LabObservation, error) {
+	test, err := loadLabTest(ctx, q, in.TestCode)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, &labInputError{fmt.Sprintf("unknown test %q", in.TestCode)}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("load test %s: %w", in.TestCode, err)
+	}
+
+	observedAt := time.Now()
+	if in.ObservedAt != "" {
+		for _, layout := range []string{time.RFC3339, "2006-01-02T15:04", "2006-01-02"} {
+			if t, err := time.Parse(layout, in.ObservedAt); err == nil {
+				observedAt = t
+				break
+			}
+		}
+	}
+
+	obs := LabObservation{
+		EpisodeID:  episodeID,
+		PatientUID: patientUID,
+		TestCode:   test.Code,
+		TestName:   test.Name,
+		Unit:       test.Unit,
+		Source:     source,
+		Flag:       LabFlagNormal,
+	}
+	if in.Unit != "" {
+		obs.Unit = in.Unit
+	}
+	if in.SpecimenBarcode != "" {
+		obs.SpecimenBarcode = &in.SpecimenBarcode
+	}
+
+	norm := test.NormalText
+	if test.ValueType == "numeric" {
+		v, ok := parseLabNumber(in.Value)
+		if !ok {
+			return nil, &labInputError{fmt.Sprintf("value %q of %s is not a number", in.Value, test.Code)}
+		}
+		obs.ValueNum = &v
+		sex, age := patientSexAndAge(ctx, q, patientUID, observedAt)
+		if rr := selectRange(test.Ranges, sex, age); rr != nil {
+			obs.RefLow, obs.RefHigh = rr.Low, rr.High
+			obs.Flag = flagNumeric(v, rr)
+			if rr.Low != nil && rr.High != nil {
+				norm = fmt.Sprintf("%g–%g", *rr.Low, *rr.High)
+			}
+		}
+	} else {
+		obs.ValueText = in.Value
+		obs.Flag = flagText(in.Value, test.NormalText)
+	}
+
+	var observed time.Time
+	err = q.QueryRow(ctx, `
+INSERT INTO lab_observations (episode_id, patient_uid, test_code, value_num, value_text, unit, flag, ref_low, ref_high, observed_at, source, specimen_barcode)
+VALUES ($1, $2, $3, $4, NULLIF($5, ''), $6, $7, $8, $9, $10, $11, NULLIF($12, ''))
+RETURNING id, observed_at
+`, episodeID, patientUID, obs.TestCode, obs.ValueNum, obs.ValueText, obs.Unit, obs.Flag, obs.RefLow, obs.RefHigh, observedAt, source, in.SpecimenBarcode).Scan(&obs.ID, &observed)
+	if err != nil {
+		return nil, err
+	}
+	obs.ObservedAt = observed.Format(time.RFC3339)
+	if in.SpecimenBarcode != "" {
+		if err := markSpecimenResulted(ctx, q, in.SpecimenBarcode); err != nil {
+			return nil, err
+		}
+	}
+
+	display := strings.TrimSpace(in.Value + " " + obs.Unit)
+	if obs.Flag != LabFlagNormal {
+		display += " (" + obs.Flag + ")"
+	}
+	entry, _ := json.Marshal(map[string]string{
+		"date":  observed.Format("2006-01-02"),
+		"value": display,
+		"norm":  norm,
+		"flag":  obs.Flag,
+	})
+	_, err = q.Exec(ctx, `
+UPDATE exam_episodes SET lab_results = COALESCE(lab_results, '{}'::jsonb) || jsonb_build_object($2::text, $3::jsonb), updated_at = NOW()
+WHERE id = $1
+`, episodeID, test.Name, entry)
+	if err != nil {
+		return nil, err
+	}
+	return &obs, nil
+}
+
+const labObservationColumns = `o.id, o.episode_id, o.patient_uid, o.test_code, t.name, o.value_num, o.value_text, o.unit, o.flag,
+       o.ref_low, o.ref_high, o.observed_at, o.source, o.specimen_barcode`
+
+func scanLabObservation(row pgx.Row) (*LabObservation, error) {
+	var o LabObservation
+	var valueText, unit *string
+	var observedAt time.Time
+	err := row.Scan(&o.ID, &o.EpisodeID, &o.PatientUID, &o.TestCode, &o.TestName, &o.ValueNum, &valueText, &unit, &o.Flag,
+		&o.RefLow, &o.RefHigh, &observedAt, &o.Source, &o.SpecimenBarcode)
+	if err != nil {
+		return nil, err
+	}
+	if valueText != nil {
+		o.ValueText = *valueText
+	}
+	if unit != nil {
+		o.Unit = *unit
+	}
+	o.ObservedAt = observedAt.Format(time.RFC3339)
+	return &o, nil
+}
+
+func queryLabObservations(ctx context.Context, query string, args ...any) ([]LabObservation, error) {
+	rows, err := db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := []LabObservation{}
+	for rows.Next() {
+		o, err := scanLabObservation(rows)
+		if err != nil {
+			log.Printf("scan lab observation: %v", err)
+			continue
+		}
+		res = append(res, *o)
+	}
+	return res, rows.Err()
+}
+
+// GET /api/lab/tests
+func listLabTestsHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	rows, err := db.Query(ctx, `SELECT code FROM lab_tests ORDER BY panel, code`)
+	if err != nil {
+		log.Printf("listLabTests error: %v", err)
+		errorResponse(w, http.StatusInternalServerError, "db error")
+		return
+	}
+	var codes []string
+	for rows.Next() {
+		var code string
+		if err := rows.Scan(&code); err == nil {
+			codes = append(codes, code)
+		}
+	}
+	rows.Close()
+
+	res := []LabTest{}
+	for _, code := range codes {
+		t, err := loadLabTest(ctx, db, code)
+		if err != nil {
+			log.Printf("load lab test %s: %v", code, err)
+			continue
+		}
+		res = append(res, *t)
+	}
+	jsonResponse(w, http.StatusOK, res)
+}
+
+// POST /api/lab/tests - добавить/изменить позицию каталога вместе с интервалами
+func upsertLabTestHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	// Справочник общий для всех расчётов норм - менять его может только персонал клиники
+	user, ok := requestUser(ctx, w, r)
+	if !ok {
+		return
+	}
+	if !isClinicStaff(user) {
+		errorResponse(w, http.StatusForbidden, "only clinic staff can edit lab tests")
+		return
+	}
+
+	var in LabTest
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		errorResponse(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+	if in.Code == "" || in.Name == "" {
+		errorResponse(w, http.StatusBadRequest, "code and name are required")
+		return
+	}
+	if in.ValueType == "" {
+		in.ValueType = "numeric"
+	}
+	if in.ValueType != "numeric" && in.ValueType != "text" {
+		errorResponse(w, http.StatusBadRequest, "valueType must be numeric or text")
+		return
+	}
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		errorResponse(w, http.StatusInternalServerError, "db error")
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, `
+INSERT INTO lab_tests (code, name, panel, unit, value_type, normal_text)
+VALUES ($1, $2, NULLIF($3, ''), NULLIF($4, ''), $5, NULLIF($6, ''))
+ON CONFLICT (code) DO UPDATE SET
+  name = EXCLUDED.name, panel = EXCLUDED.panel, unit = EXCLUDED.unit,
+  value_type = EXCLUDED.value_type, normal_text = EXCLUDED.normal_text
+`, in.Code, in.Name, in.Panel, in.Unit, in.ValueType, in.NormalText)
+	if err == nil {
+		_, err = tx.Exec(ctx, `DELETE FROM lab_reference_ranges WHERE test_code = $1`, in.Code)
+	}
+	for _, rr := range in.Ranges {
+		if err != nil {
+			break
+		}
+		_, err = tx.Exec(ctx, `
+INSERT INTO lab_reference_ranges (test_code, sex, age_min, age_max, low, high, critical_low, critical_high)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+`, in.Code, rr.Sex, rr.AgeMin, rr.AgeMax, rr.Low, rr.High, rr.CriticalLow, rr.CriticalHigh)
+	}
+	if err != nil {
+		log.Printf("upsertLabTest error: %v", err)
+		errorResponse(w, http.StatusInternalServerError, "db error")
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		errorResponse(w, http.StatusInternalServerError, "db error")
+		return
+	}
+
+	t, err := loadLabTest(ctx, db, in.Code)
+	if err != nil {
+		errorResponse(w, http.StatusInternalServerError, "db error")
+		return
+	}
+	jsonResponse(w, http.StatusOK, t)
+}
+
+// POST /api/episodes/{id}/lab-results - body: [{testCode, value, observedAt}]
+func createLabResultsHandler(w http.ResponseWriter, r *http.Request, episodeID int64) {
+	var in []LabResultInput
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil || len(in) == 0 {
+		errorResponse(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	if _, _, ok := authorizeEpisode(ctx, w, r, episodeID, true); !ok {
+		return
+	}
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		errorResponse(w, http.StatusInternalServerError, "db error")
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	var patientUID string
+	var locked bool
+	if err := tx.QueryRow(ctx, `SELECT patient_uid, locked FROM exam_episodes WHERE id = $1 FOR UPDATE`, episodeID).Scan(&patientUID, &locked); err != nil {
+		errorResponse(w, http.StatusNotFound, "episode not found")
+		return
+	}
+	if locked {
+		errorResponse(w, http.StatusLocked, "episode is locked by the final conclusion")
+		return
+	}
+
+	res := make([]LabObservation, 0, len(in))
+	for _, item := range in {
+		obs, err := recordLabResult(ctx, tx, episodeID, patientUID, item, "manual")
+		var inputErr *labInputError
+		if errors.As(err, &inputErr) {
+			errorResponse(w, http.StatusBadRequest, inputErr.Error())
+			return
+		}
+		if err != nil {
+			log.Printf("createLabResults: episode %d, test %s: %v", episodeID, item.TestCode, err)
+			errorResponse(w, http.StatusInternalServerError, "db error")
+			return
+		}
+		res = append(res, *obs)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		errorResponse(w, http.StatusInternalServerError, "db error")
+		return
+	}
+	checkEmergencyNotifications(ctx, episodeID)
+
+	broadcastToUser(patientUID, "visit_updated", map[string]interface{}{
+		"employeeId": patientUID,
+		"episodeId":  episodeID,
+	})
+	jsonResponse(w, http.StatusCreated, res)
+}
+
+// GET /api/episodes/{id}/lab-results
+func listEpisodeLabResultsHandler(w http.ResponseWriter, r *http.Request, episodeID int64) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	if _, _, ok := authorizeEpisode(ctx, w, r, episodeID, false); !ok {
+		return
+	}
+
+	res, err := queryLabObservations(ctx, `
+SELECT `+labObservationColumns+`
+FROM lab_observations o JOIN lab_tests t ON t.code = o.test_code
+WHERE o.episode_id = $1 ORDER BY t.panel, o.test_code, o.observed_at
+`, episodeID)
+	if err != nil {
+		log.Printf("listEpisodeLabResults error: %v", err)
+		errorResponse(w, http.StatusInternalServerError, "db error")
+		return
+	}
+	jsonResponse(w, http.StatusOK, res)
+}
+
+// GET /api/patients/{patientUid}/lab-trends?testCode=hemoglobin
+func labTrendsHandler(w http.ResponseWriter, r *http.Request) {
+	rest := strings.TrimPrefix(r.URL.Path, "/api/patients/")
+	patientUID, err := url.PathUnescape(strings.TrimSuffix(rest, "/lab-trends"))
+	if err != nil || patientUID == "" {
+		errorResponse(w, http.StatusBadRequest, "invalid patient uid")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	if _, ok := authorizePatientRecords(ctx, w, r, patientUID); !ok {
+		return
+	}
+
+	query := `
+SELECT ` + labObservationColumns + `
+FROM lab_observations o JOIN lab_tests t ON t.code = o.test_code
+WHERE o.patient_uid = $1`
+	args := []any{patientUID}
+	if code := r.URL.Query().Get("testCode"); code != "" {
+		query += " AND o.test_code = $2"
+		args = append(args, code)
+	}
+	query += " ORDER BY o.test_code, o.observed_at"
+
+	obs, err := queryLabObservations(ctx, query, args...)
+	if err != nil {
+		log.Printf("labTrends error: %v", err)
+		errorResponse(w, http.StatusInternalServerError, "db error")
+		return
+	}
+
+	// Группируем ряды по коду теста
+	series := map[string][]LabObservation{}
+	for _, o := range obs {
+		series[o.TestCode] = append(series[o.TestCode], o)
+	}
+	jsonResponse(w, http.StatusOK, map[string]any{"patientUid": patientUID, "series": series})
+}
+
+// GET /api/contracts/{id}/abnormal-labs - отклонения по всем эпизодам договора (для председателя)
+func contractAbnormalLabsHandler(w http.ResponseWriter, r *http.Request, contractID int64) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	if _, _, ok := authorizeContract(ctx, w, r, contractID, true); !ok {
+		return
+	}
+
+	query := `
+SELECT ` + labObservationColumns + `
+FROM lab_observations o
+JOIN lab_tests t ON t.code = o.test_code
+JOIN exam_episodes e ON e.id = o.episode_id
+WHERE e.contract_id = $1 AND o.flag <> 'N'`
+	if r.URL.Query().Get("critical") == "1" {
+		query += " AND o.flag IN ('HH', 'LL')"
+	}
+	query += " ORDER BY (o.flag IN ('HH', 'LL')) DESC, o.patient_uid, o.observed_at"
+
+	res, err := queryLabObservations(ctx, query, contractID)
+	if err != nil {
+		log.Printf("contractAbnormalLabs error: %v", err)
+		errorResponse(w, http.StatusInternalServerError, "db error")
+		return
+	}
+	jsonResponse(w, http.StatusOK, res)
+}
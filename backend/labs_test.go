@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+func TestAgeAt(t *testing.T) {
+	day := func(s string) time.Time {
+		d, err := time.Parse("2006-01-02", s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+	for _, tc := range []struct {
+		dob, at string
+		want    int
+	}{
+		{"1985-04-12", "2026-04-11", 40},
+		{"1985-04-12", "2026-04-12", 41},
+		// Високосный год: 1 марта - 61-й день, а не 60-й
+		{"1990-03-01", "2024-02-29", 33},
+		{"1990-03-01", "2024-03-01", 34},
+		{"2000-03-01", "2023-03-01", 23},
+		{"1990-12-31", "2024-12-30", 33},
+		{"1990-12-31", "2024-12-31", 34},
+		// Родившиеся 29 февраля взрослеют 1 марта
+		{"2000-02-29", "2023-02-28", 22},
+		{"2000-02-29", "2023-03-01", 23},
+		{"2000-02-29", "2024-02-29", 24},
+	} {
+		if got := ageAt(day(tc.dob), day(tc.at)); got != tc.want {
+			t.Errorf("ageAt(%s, %s) = %d, want %d", tc.dob, tc.at, got, tc.want)
+		}
+	}
+}
+
+// Справочник тестов и норм меняет только персонал клиники; проверка прав - до разбора тела
+func TestUpsertLabTestRequiresClinicStaff(t *testing.T) {
+	prev := records
+	records = memRecordStore{}
+	defer func() { records = prev }()
+
+	body := `{"code":"GLU","name":"Глюкоза","ranges":[{"low":0,"high":100}]}`
+	for _, tc := range []struct {
+		user *User
+		want int
+	}{
+		{nil, http.StatusUnauthorized},
+		{employeA, http.StatusForbidden},
+		{orgA, http.StatusForbidden},
+	} {
+		if rec := callAs(t, tc.user, http.MethodPost, "/api/lab/tests", body, upsertLabTestHandler); rec.Code != tc.want {
+			t.Errorf("%v: status %d, want %d", tc.user, rec.Code, tc.want)
+		}
+	}
+	if rec := callAs(t, doctorA, http.MethodPost, "/api/lab/tests", `{"code":""}`, upsertLabTestHandler); rec.Code != http.StatusBadRequest {
+		t.Errorf("clinic staff: status %d, want 400 for an invalid body", rec.Code)
+	}
+}
+
+// fakeLabDB - каталог из одного численного теста; ошибка базы подставляется в loadLabTest
+type fakeLabDB struct {
+	dbErr error
+}
+
+func (f *fakeLabDB) QueryRow(ctx context.Context, sql string, args ...any) pgx.Row {
+	switch {
+	case f.dbErr != nil:
+		return fakeRow{err: f.dbErr}
+	case strings.Contains(sql, "FROM lab_tests") && args[0] == "hemoglobin":
+		unit := "г/л"
+		return fakeRow{vals: []any{"hemoglobin", "Гемоглобин", (*string)(nil), &unit, "numeric", (*string)(nil)}}
+	case strings.Contains(sql, "FROM lab_tests"):
+		return fakeRow{err: pgx.ErrNoRows}
+	}
+	return fakeRow{err: errors.New("unexpected query: " + sql)}
+}
+
+func (f *fakeLabDB) Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error) {
+	return &fakeRows{}, nil
+}
+
+func (f *fakeLabDB) Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error) {
+	return pgconn.CommandTag{}, errors.New("unexpected exec")
+}
+
+// Ошибки ввода отличаются от ошибок базы: первые - 400, вторые - 500
+func TestRecordLabResultSeparatesInputErrors(t *testing.T) {
+	var inputErr *labInputError
+	for _, in := range []LabResultInput{
+		{TestCode: "unknown", Value: "1"},
+		{TestCode: "hemoglobin", Value: "много"},
+	} {
+		_, err := recordLabResult(context.Background(), &fakeLabDB{}, 1, "emp-a", in, "manual")
+		if !errors.As(err, &inputErr) {
+			t.Errorf("%+v: %v is not an input error", in, err)
+		}
+	}
+
+	_, err := recordLabResult(context.Background(), &fakeLabDB{dbErr: errors.New("conn closed")}, 1, "emp-a",
+		LabResultInput{TestCode: "hemoglobin", Value: "140"}, "manual")
+	if err == nil || errors.As(err, &inputErr) {
+		t.Errorf("db failure reported as input error: %v", err)
+	}
+}
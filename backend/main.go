@@ -9,11 +9,10 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"strconv"
 	"strings"
-	"sync"
 	"time"
 
-	"github.com/gorilla/websocket"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
@@ -58,8 +57,16 @@ type AmbulatoryCard struct {
 	Final      json.RawMessage `json:"finalConclusion,omitempty"`
 	Comm       json.RawMessage `json:"communication,omitempty"`
 	Instr      *string         `json:"patientInstruction,omitempty"`
-	CreatedAt  string          `json:"createdAt"`
-	UpdatedAt  string          `json:"updatedAt"`
+	// Текущий эпизод медосмотра: записи специалистов, анализы и заключение берутся из exam_episodes
+	EpisodeID *int64 `json:"episodeId,omitempty"`
+	ExamType  string `json:"examType,omitempty"`
+	// Подписание итогового заключения председателем (только через /api/visits/{id}/final-conclusion)
+	Commission    json.RawMessage `json:"commission,omitempty"`
+	FinalSignedBy *int64          `json:"finalSignedBy,omitempty"`
+	FinalSignedAt *string         `json:"finalSignedAt,omitempty"`
+	Locked        bool            `json:"locked"`
+	CreatedAt     string          `json:"createdAt"`
+	UpdatedAt     string          `json:"updatedAt"`
 }
 
 type CalendarPlan struct {
@@ -106,334 +113,51 @@ type Doctor struct {
 
 var db *pgxpool.Pool
 
-// --- WEBSOCKET ---
-
-var upgrader = websocket.Upgrader{
-	CheckOrigin: func(r *http.Request) bool {
-		// Разрешаем подключения с любого origin (для разработки)
-		// В продакшене нужно проверять origin
-		return true
-	},
-	ReadBufferSize:  1024,
-	WriteBufferSize: 1024,
-}
-
-// WebSocketMessage - структура сообщения WebSocket
-type WebSocketMessage struct {
-	Type      string      `json:"type"`
-	Data      interface{} `json:"data"`
-	Timestamp string      `json:"timestamp"`
-	UserID    string      `json:"userId,omitempty"`
-}
-
-// Client - подключенный WebSocket клиент
-type Client struct {
-	ID     string
-	UserID string
-	Role   UserRole
-	Conn   *websocket.Conn
-	Send   chan WebSocketMessage
-	Hub    *Hub
-	mu     sync.Mutex
-}
-
-// Hub - центр управления WebSocket подключениями
-type Hub struct {
-	clients    map[*Client]bool
-	broadcast  chan WebSocketMessage
-	register   chan *Client
-	unregister chan *Client
-	mu         sync.RWMutex
-}
-
-// NewHub создает новый Hub
-func NewHub() *Hub {
-	return &Hub{
-		clients:    make(map[*Client]bool),
-		broadcast:  make(chan WebSocketMessage, 256),
-		register:   make(chan *Client),
-		unregister: make(chan *Client),
-	}
-}
-
-// Run запускает Hub
-func (h *Hub) Run() {
-	for {
-		select {
-		case client := <-h.register:
-			h.mu.Lock()
-			h.clients[client] = true
-			h.mu.Unlock()
-			log.Printf("WebSocket client connected: %s (user: %s)", client.ID, client.UserID)
-
-		case client := <-h.unregister:
-			h.mu.Lock()
-			if _, ok := h.clients[client]; ok {
-				delete(h.clients, client)
-				close(client.Send)
-			}
-			h.mu.Unlock()
-			log.Printf("WebSocket client disconnected: %s", client.ID)
-
-		case message := <-h.broadcast:
-			h.mu.RLock()
-			for client := range h.clients {
-				select {
-				case client.Send <- message:
-				default:
-					close(client.Send)
-					delete(h.clients, client)
-				}
-			}
-			h.mu.RUnlock()
-		}
-	}
-}
-
-// BroadcastToUser отправляет сообщение конкретному пользователю
-func (h *Hub) BroadcastToUser(userID string, message WebSocketMessage) {
-	h.mu.RLock()
-	defer h.mu.RUnlock()
-
-	for client := range h.clients {
-		if client.UserID == userID {
-			select {
-			case client.Send <- message:
-			default:
-				close(client.Send)
-				delete(h.clients, client)
-			}
-		}
-	}
-}
-
-// BroadcastToRole отправляет сообщение всем пользователям с определенной ролью
-func (h *Hub) BroadcastToRole(role UserRole, message WebSocketMessage) {
-	h.mu.RLock()
-	defer h.mu.RUnlock()
+// --- HELPERS ---
 
-	for client := range h.clients {
-		if client.Role == role {
-			select {
-			case client.Send <- message:
-			default:
-				close(client.Send)
-				delete(h.clients, client)
-			}
-		}
-	}
+func jsonResponse(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	// Всегда кодируем значение, даже если это nil (запишет "null")
+	_ = json.NewEncoder(w).Encode(v)
 }
 
-// BroadcastToUsers отправляет сообщение нескольким пользователям
-func (h *Hub) BroadcastToUsers(userIDs []string, message WebSocketMessage) {
-	h.mu.RLock()
-	defer h.mu.RUnlock()
-
-	userMap := make(map[string]bool)
-	for _, id := range userIDs {
-		userMap[id] = true
-	}
-
-	for client := range h.clients {
-		if userMap[client.UserID] {
-			select {
-			case client.Send <- message:
-			default:
-				close(client.Send)
-				delete(h.clients, client)
-			}
-		}
-	}
+func errorResponse(w http.ResponseWriter, status int, msg string) {
+	jsonResponse(w, status, map[string]string{"error": msg})
 }
 
-// readPump читает сообщения от клиента
-func (c *Client) readPump() {
-	defer func() {
-		c.Hub.unregister <- c
-		c.Conn.Close()
-	}()
-
-	c.Conn.SetReadDeadline(time.Now().Add(60 * time.Second))
-	c.Conn.SetPongHandler(func(string) error {
-		c.Conn.SetReadDeadline(time.Now().Add(60 * time.Second))
-		return nil
-	})
-
-	for {
-		_, _, err := c.Conn.ReadMessage()
-		if err != nil {
-			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
-				log.Printf("WebSocket error: %v", err)
-			}
-			break
-		}
+// requestUserID возвращает ID пользователя, выполняющего запрос (заголовок X-User-ID или query userId, как в /ws)
+func requestUserID(r *http.Request) string {
+	if uid := r.Header.Get("X-User-ID"); uid != "" {
+		return uid
 	}
+	return r.URL.Query().Get("userId")
 }
 
-// writePump отправляет сообщения клиенту
-func (c *Client) writePump() {
-	ticker := time.NewTicker(54 * time.Second)
-	defer func() {
-		ticker.Stop()
-		c.Conn.Close()
-	}()
-
-	for {
-		select {
-		case message, ok := <-c.Send:
-			c.Conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
-			if !ok {
-				c.Conn.WriteMessage(websocket.CloseMessage, []byte{})
-				return
-			}
-
-			w, err := c.Conn.NextWriter(websocket.TextMessage)
-			if err != nil {
-				return
-			}
-			json.NewEncoder(w).Encode(message)
-
-			n := len(c.Send)
-			for i := 0; i < n; i++ {
-				json.NewEncoder(w).Encode(<-c.Send)
-			}
-
-			if err := w.Close(); err != nil {
-				return
-			}
-
-		case <-ticker.C:
-			c.Conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
-			if err := c.Conn.WriteMessage(websocket.PingMessage, nil); err != nil {
-				return
-			}
-		}
-	}
-}
-
-var hub *Hub
-
-// wsHandler обрабатывает WebSocket подключения
-func wsHandler(w http.ResponseWriter, r *http.Request) {
-	conn, err := upgrader.Upgrade(w, r, nil)
-	if err != nil {
-		log.Printf("WebSocket upgrade error: %v", err)
-		return
-	}
-
-	// Получаем userID из query параметров или заголовков
-	userID := r.URL.Query().Get("userId")
-	if userID == "" {
-		userID = r.Header.Get("X-User-ID")
-	}
-	if userID == "" {
-		log.Printf("WebSocket: no userId provided, closing connection")
-		conn.Close()
-		return
-	}
-
-	// Получаем роль пользователя из БД
-	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
-	defer cancel()
-
-	var role UserRole
-	var bin *string
-	var foundID string
-
-	// Сначала пытаемся найти по id (uid)
-	err = db.QueryRow(ctx, "SELECT id, role, bin FROM users WHERE id = $1", userID).Scan(&foundID, &role, &bin)
+// loadUser загружает пользователя по uid
+func loadUser(ctx context.Context, uid string) (*User, error) {
+	var u User
+	var createdAt time.Time
+	err := db.QueryRow(ctx, `
+SELECT id, role, bin, company_name, leader_name, phone, created_at, doctor_id, clinic_id, specialty, clinic_bin, employee_id, contract_id
+FROM users WHERE id = $1
+`, uid).Scan(&u.ID, &u.Role, &u.BIN, &u.CompanyName, &u.LeaderName, &u.Phone, &createdAt, &u.DoctorID, &u.ClinicID, &u.Specialty, &u.ClinicBIN, &u.EmployeeID, &u.ContractID)
 	if err != nil {
-		// Если не нашли по id, пробуем найти по phone (на случай если передали phone вместо uid)
-		err2 := db.QueryRow(ctx, "SELECT id, role, bin FROM users WHERE phone = $1", userID).Scan(&foundID, &role, &bin)
-		if err2 != nil {
-			// Пользователь не найден - это нормально, если он ещё не зарегистрирован
-			// Не логируем как ошибку, просто закрываем соединение тихо
-			// (это может быть попытка подключения до регистрации или несуществующий пользователь)
-			conn.Close()
-			return
-		}
-		// Используем найденный ID вместо переданного
-		userID = foundID
-	} else {
-		// Используем найденный ID (может отличаться от переданного)
-		userID = foundID
-	}
-
-	client := &Client{
-		ID:     fmt.Sprintf("client_%d_%s", time.Now().UnixNano(), userID),
-		UserID: userID,
-		Role:   role,
-		Conn:   conn,
-		Send:   make(chan WebSocketMessage, 256),
-		Hub:    hub,
-	}
-
-	hub.register <- client
-
-	go client.writePump()
-	go client.readPump()
-}
-
-// broadcastMessage отправляет сообщение всем подключенным клиентам
-func broadcastMessage(messageType string, data interface{}) {
-	if hub == nil {
-		return
-	}
-	hub.broadcast <- WebSocketMessage{
-		Type:      messageType,
-		Data:      data,
-		Timestamp: time.Now().Format(time.RFC3339),
-	}
-}
-
-// broadcastToUser отправляет сообщение конкретному пользователю
-func broadcastToUser(userID string, messageType string, data interface{}) {
-	if hub == nil {
-		return
-	}
-	hub.BroadcastToUser(userID, WebSocketMessage{
-		Type:      messageType,
-		Data:      data,
-		Timestamp: time.Now().Format(time.RFC3339),
-		UserID:    userID,
-	})
-}
-
-// broadcastToRole отправляет сообщение всем пользователям с определенной ролью
-func broadcastToRole(role UserRole, messageType string, data interface{}) {
-	if hub == nil {
-		return
+		return nil, err
 	}
-	hub.BroadcastToRole(role, WebSocketMessage{
-		Type:      messageType,
-		Data:      data,
-		Timestamp: time.Now().Format(time.RFC3339),
-	})
+	u.CreatedAt = createdAt.Format(time.RFC3339)
+	return &u, nil
 }
 
-// broadcastToUsers отправляет сообщение нескольким пользователям
-func broadcastToUsers(userIDs []string, messageType string, data interface{}) {
-	if hub == nil {
-		return
+// parseResourcePath разбирает пути вида {prefix}{id}[/{sub}], например /api/episodes/12/lab-results
+func parseResourcePath(path, prefix string) (int64, string, bool) {
+	rest := strings.Trim(strings.TrimPrefix(path, prefix), "/")
+	idPart, sub, _ := strings.Cut(rest, "/")
+	id, err := strconv.ParseInt(idPart, 10, 64)
+	if err != nil || id <= 0 {
+		return 0, "", false
 	}
-	hub.BroadcastToUsers(userIDs, WebSocketMessage{
-		Type:      messageType,
-		Data:      data,
-		Timestamp: time.Now().Format(time.RFC3339),
-	})
-}
-
-// --- HELPERS ---
-
-func jsonResponse(w http.ResponseWriter, status int, v any) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(status)
-	// Всегда кодируем значение, даже если это nil (запишет "null")
-	_ = json.NewEncoder(w).Encode(v)
-}
-
-func errorResponse(w http.ResponseWriter, status int, msg string) {
-	jsonResponse(w, status, map[string]string{"error": msg})
+	return id, sub, true
 }
 
 // --- DB INIT & MIGRATIONS ---
@@ -675,9 +399,6 @@ CREATE TABLE IF NOT EXISTS ambulatory_cards (
 	_, _ = tx.Exec(ctx, `ALTER TABLE doctors ADD COLUMN IF NOT EXISTS room_number TEXT;`)
 	_, _ = tx.Exec(ctx, `ALTER TABLE employee_visits ADD COLUMN IF NOT EXISTS employee_name TEXT;`)
 	_, _ = tx.Exec(ctx, `ALTER TABLE employee_visits ADD COLUMN IF NOT EXISTS client_name TEXT;`)
-	_, _ = tx.Exec(ctx, `ALTER TABLE ambulatory_cards ADD COLUMN IF NOT EXISTS specialist_entries JSONB DEFAULT '{}'::jsonb;`)
-	_, _ = tx.Exec(ctx, `ALTER TABLE ambulatory_cards ADD COLUMN IF NOT EXISTS lab_results JSONB DEFAULT '{}'::jsonb;`)
-	_, _ = tx.Exec(ctx, `ALTER TABLE ambulatory_cards ADD COLUMN IF NOT EXISTS final_conclusion JSONB DEFAULT '{}'::jsonb;`)
 
 	log.Printf("INFO: Table columns updated successfully")
 
@@ -706,6 +427,68 @@ CREATE TABLE IF NOT EXISTS ambulatory_cards (
 		return nil, fmt.Errorf("create index employee_visits_date: %w", err)
 	}
 
+	if err := migrateEpisodes(ctx, tx); err != nil {
+		return nil, err
+	}
+
+	if err := migrateLabs(ctx, tx); err != nil {
+		return nil, err
+	}
+
+	if err := migrateHL7(ctx, tx); err != nil {
+		return nil, err
+	}
+
+	if err := migrateLabOrders(ctx, tx); err != nil {
+		return nil, err
+	}
+
+	if err := migrateAttachments(ctx, tx); err != nil {
+		return nil, err
+	}
+
+	if err := migrateContractDocuments(ctx, tx); err != nil {
+		return nil, err
+	}
+
+	if err := migrateHealthPlans(ctx, tx); err != nil {
+		return nil, err
+	}
+	if err := migrateEmergencyNotifications(ctx, tx); err != nil {
+		return nil, err
+	}
+	if err := migrateOccupationalReferrals(ctx, tx); err != nil {
+		return nil, err
+	}
+
+	if err := migrateDispensary(ctx, tx); err != nil {
+		return nil, err
+	}
+
+	if err := migrateExamRecalls(ctx, tx); err != nil {
+		return nil, err
+	}
+
+	if err := migrateShiftExams(ctx, tx); err != nil {
+		return nil, err
+	}
+
+	if err := migrateCertificates(ctx, tx); err != nil {
+		return nil, err
+	}
+
+	if err := migrateDevices(ctx, tx); err != nil {
+		return nil, err
+	}
+
+	if err := migrateEventLog(ctx, tx); err != nil {
+		return nil, err
+	}
+
+	if err := migratePresence(ctx, tx); err != nil {
+		return nil, err
+	}
+
 	if err := tx.Commit(ctx); err != nil {
 		return nil, fmt.Errorf("commit migrations: %w", err)
 	}
@@ -1102,6 +885,8 @@ func updateContractHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	invalidateContractStats(ctx, db, id)
+
 	// Отправляем событие об обновлении контракта
 	// Получаем информацию о контракте для отправки
 	var clinicBIN, clientBIN string
@@ -1510,6 +1295,7 @@ type CreateVisitRequest struct {
 	ClinicID     string          `json:"clinicId"`
 	Phone        string          `json:"phone"`
 	RouteSheet   json.RawMessage `json:"routeSheet"`
+	ExamType     string          `json:"examType,omitempty"` // preliminary / periodic / pre_shift / extraordinary
 }
 
 func createVisitHandler(w http.ResponseWriter, r *http.Request) {
@@ -1556,71 +1342,67 @@ func createVisitHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// 3. Отправляем уведомления через WebSocket
-	// Уведомляем клинику
-	broadcastToUser(in.ClinicID, "visit_started", map[string]interface{}{
-		"visitId":    visitID,
-		"employeeId": in.EmployeeID,
-	})
+	// Каждый визит открывает свой эпизод медосмотра
+	episodeID, err := ensureEpisodeForVisit(ctx, db, visitID, in.ExamType)
+	if err != nil {
+		log.Printf("createVisit: create episode error: %v", err)
+	}
+
+	// Направления в лабораторию по исследованиям маршрутного листа
+	if _, err := createLabOrdersForVisit(ctx, db, visitID); err != nil {
+		log.Printf("createVisit: create lab orders error: %v", err)
+	}
+
+	if in.ContractID > 0 {
+		invalidateContractStats(ctx, db, in.ContractID)
+	}
+
+	// 3. Отправляем уведомления через WebSocket - только клинике визита и её сотрудникам
+	event := map[string]interface{}{
+		"visitId":      visitID,
+		"employeeId":   in.EmployeeID,
+		"employeeName": in.EmployeeName,
+		"clinicId":     in.ClinicID,
+	}
+	if in.ContractID > 0 {
+		publishToTopic(contractTopic(in.ContractID), "visit_started", map[string]interface{}{
+			"visitId":    visitID,
+			"employeeId": in.EmployeeID,
+		})
+	}
 
-	// Уведомляем всех врачей, которые есть в маршрутном листе
+	// Клинике и врачам из маршрутного листа (только врачам этой клиники) - одним событием:
+	// врач подписан и на тему клиники, и на свою, но получает его один раз
+	topics := []string{clinicTopic(in.ClinicID)}
 	var routeSheetData []map[string]interface{}
 	if err := json.Unmarshal(in.RouteSheet, &routeSheetData); err == nil {
-		doctorIDs := make(map[string]bool) // Используем map для уникальности
-
+		var doctorIDs []int64
 		for _, step := range routeSheetData {
-			// Если это шаг с врачом, получаем его ID
 			if stepType, ok := step["type"].(string); ok && stepType == "doctor" {
-				if doctorID, hasDoctorID := step["doctorId"]; hasDoctorID && doctorID != nil {
-					// Преобразуем doctorId в строку (может быть число или строка)
-					doctorIDStr := fmt.Sprintf("%v", doctorID)
-					doctorIDs[doctorIDStr] = true
+				var doctorID int64
+				if _, err := fmt.Sscanf(fmt.Sprintf("%v", step["doctorId"]), "%d", &doctorID); err == nil {
+					doctorIDs = append(doctorIDs, doctorID)
 				}
 			}
 		}
-
-		// Находим пользователей-врачей по их ID из таблицы doctors
-		for doctorIDStr := range doctorIDs {
-			var doctorUID string
-			var doctorPhone *string
-			// Преобразуем строку в число для запроса
-			var doctorIDInt int64
-			if _, err := fmt.Sscanf(doctorIDStr, "%d", &doctorIDInt); err == nil {
-				err := db.QueryRow(ctx, "SELECT clinic_uid, phone FROM doctors WHERE id = $1", doctorIDInt).Scan(&doctorUID, &doctorPhone)
-				if err == nil {
-					// Находим пользователя-врача по телефону
-					if doctorPhone != nil && *doctorPhone != "" {
-						var userID string
-						err2 := db.QueryRow(ctx, "SELECT id FROM users WHERE phone = $1 AND role = 'doctor' LIMIT 1", *doctorPhone).Scan(&userID)
-						if err2 == nil && userID != "" {
-							broadcastToUser(userID, "visit_started", map[string]interface{}{
-								"visitId":      visitID,
-								"employeeId":   in.EmployeeID,
-								"employeeName": in.EmployeeName,
-							})
-							log.Printf("DEBUG createVisit: Notified doctor %s (userID=%s) about visit %d", doctorIDStr, userID, visitID)
-						} else {
-							log.Printf("DEBUG createVisit: Could not find user for doctor %s (phone=%v, err=%v)", doctorIDStr, doctorPhone, err2)
-						}
+		if len(doctorIDs) > 0 {
+			rows, err := db.Query(ctx, "SELECT id FROM doctors WHERE id = ANY($1) AND clinic_uid = $2", doctorIDs, in.ClinicID)
+			if err != nil {
+				log.Printf("createVisit: load route sheet doctors: %v", err)
+			} else {
+				for rows.Next() {
+					var doctorID int64
+					if rows.Scan(&doctorID) == nil {
+						topics = append(topics, doctorTopic(strconv.FormatInt(doctorID, 10)))
 					}
-				} else {
-					log.Printf("DEBUG createVisit: Could not find doctor %s in doctors table (err=%v)", doctorIDStr, err)
 				}
-			} else {
-				log.Printf("DEBUG createVisit: Could not parse doctorID %s as integer", doctorIDStr)
+				rows.Close()
 			}
 		}
-
-		// Также уведомляем всех врачей клиники через broadcastToRole (на случай если не нашли по doctorId)
-		broadcastToRole(UserRoleDoctor, "visit_started", map[string]interface{}{
-			"visitId":      visitID,
-			"employeeId":   in.EmployeeID,
-			"employeeName": in.EmployeeName,
-			"clinicId":     in.ClinicID,
-		})
 	}
+	publishToTopics(topics, "visit_started", event)
 
-	jsonResponse(w, http.StatusCreated, map[string]interface{}{"id": visitID})
+	jsonResponse(w, http.StatusCreated, map[string]interface{}{"id": visitID, "episodeId": episodeID})
 }
 
 func listVisitsHandler(w http.ResponseWriter, r *http.Request) {
@@ -1830,11 +1612,21 @@ func getAmbulatoryCardHandler(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
 	defer cancel()
 
+	user, ok := requestUser(ctx, w, r)
+	if !ok {
+		return
+	}
+	// Карта содержит диагнозы и записи специалистов: работодателю - только проекция итогов
+	if user.Role == UserRoleOrganization {
+		errorResponse(w, http.StatusForbidden, "organizations can only see examination outcomes via /api/contracts/{id}/outcomes")
+		return
+	}
+
 	var card AmbulatoryCard
-	var general, medical, spec, labs, final, comm []byte
+	var general, medical, comm []byte
 	var createdAt, updatedAt time.Time
 
-	query := `SELECT id, patient_uid, iin, general, medical, specialist_entries, lab_results, final_conclusion, communication, patient_instruction, created_at, updated_at 
+	query := `SELECT id, patient_uid, iin, general, medical, communication, patient_instruction, created_at, updated_at 
 	          FROM ambulatory_cards WHERE `
 	var arg any
 	if patientUID != "" {
@@ -1848,7 +1640,7 @@ func getAmbulatoryCardHandler(w http.ResponseWriter, r *http.Request) {
 	log.Printf("getAmbulatoryCard: Executing query with arg=%s", arg)
 
 	err := db.QueryRow(ctx, query, arg).Scan(
-		&card.ID, &card.PatientUID, &card.IIN, &general, &medical, &spec, &labs, &final, &comm, &card.Instr, &createdAt, &updatedAt,
+		&card.ID, &card.PatientUID, &card.IIN, &general, &medical, &comm, &card.Instr, &createdAt, &updatedAt,
 	)
 
 	if err != nil {
@@ -1858,20 +1650,95 @@ func getAmbulatoryCardHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	log.Printf("getAmbulatoryCard: Card found - id=%d, patientUid=%s, iin=%s, spec length=%d", card.ID, card.PatientUID, card.IIN, len(spec))
-
 	card.General = json.RawMessage(general)
 	card.Medical = json.RawMessage(medical)
-	card.Spec = json.RawMessage(spec)
-	card.Labs = json.RawMessage(labs)
-	card.Final = json.RawMessage(final)
 	card.Comm = json.RawMessage(comm)
 	card.CreatedAt = createdAt.Format(time.RFC3339)
 	card.UpdatedAt = updatedAt.Format(time.RFC3339)
 
+	// Записи специалистов и заключение - из запрошенного или текущего эпизода
+	var episodeID int64
+	found := false
+	if v := r.URL.Query().Get("episodeId"); v != "" {
+		_, err := fmt.Sscanf(v, "%d", &episodeID)
+		found = err == nil
+	}
+	if !found {
+		episodeID, found, _ = currentEpisodeID(ctx, db, card.PatientUID)
+	}
+	if found {
+		if e, err := loadEpisode(ctx, db, episodeID); err == nil && e.PatientUID == card.PatientUID {
+			card.EpisodeID = &e.ID
+			card.ExamType = e.ExamType
+			card.Spec = e.Spec
+			card.Labs = e.Labs
+			card.Final = e.Final
+			card.Commission = e.Commission
+			card.FinalSignedBy = e.FinalSignedBy
+			card.FinalSignedAt = e.FinalSignedAt
+			card.Locked = e.Locked
+		}
+	}
+
+	if !canReadAmbulatoryCard(user, card.PatientUID) {
+		errorResponse(w, http.StatusForbidden, "access denied")
+		return
+	}
+
+	log.Printf("getAmbulatoryCard: Card found - id=%d, patientUid=%s, iin=%s, spec length=%d", card.ID, card.PatientUID, card.IIN, len(card.Spec))
+
 	jsonResponse(w, http.StatusOK, card)
 }
 
+// completeEmployeeRouteSteps отмечает выполненными шаги открытых визитов работника
+// по специальностям done (ключи - normalizeSpecialty) и переводит визит в работу
+func completeEmployeeRouteSteps(ctx context.Context, employeeID string, done map[string]bool) error {
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	rows, err := tx.Query(ctx, `
+		SELECT id, COALESCE(route_sheet, '[]'::jsonb) FROM employee_visits
+		WHERE employee_id = $1 AND status IN ('registered', 'in_progress')
+		FOR UPDATE
+	`, employeeID)
+	if err != nil {
+		return err
+	}
+	sheets := map[int64][]RouteStep{}
+	for rows.Next() {
+		var id int64
+		var raw []byte
+		if err := rows.Scan(&id, &raw); err != nil {
+			rows.Close()
+			return err
+		}
+		var steps []RouteStep
+		_ = json.Unmarshal(raw, &steps)
+		sheets[id] = steps
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for id, steps := range sheets {
+		err := completeRouteSteps(ctx, tx, id, steps, func(step RouteStep) bool {
+			return done[normalizeSpecialty(step.Specialty)]
+		})
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(ctx, `UPDATE employee_visits SET status = 'in_progress', updated_at = NOW() WHERE id = $1 AND status = 'registered'`, id)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit(ctx)
+}
+
 func upsertAmbulatoryCardHandler(w http.ResponseWriter, r *http.Request) {
 	var in AmbulatoryCard
 	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
@@ -1879,6 +1746,11 @@ func upsertAmbulatoryCardHandler(w http.ResponseWriter, r *http.Request) {
 		errorResponse(w, http.StatusBadRequest, "invalid json")
 		return
 	}
+	// Заключение подписывает только председатель, в карте оно не принимается
+	if len(in.Final) > 0 && string(in.Final) != "null" {
+		errorResponse(w, http.StatusBadRequest, "finalConclusion is signed via /api/visits/{id}/final-conclusion")
+		return
+	}
 
 	log.Printf("upsertAmbulatoryCard: Received card for patientUid=%s, iin=%s", in.PatientUID, in.IIN)
 	specPreview := string(in.Spec)
@@ -1895,21 +1767,91 @@ func upsertAmbulatoryCardHandler(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
 	defer cancel()
 
-	// Используем ON CONFLICT для обновления если уже существует
-	_, err := db.Exec(ctx, `
-		INSERT INTO ambulatory_cards (patient_uid, iin, general, medical, specialist_entries, lab_results, final_conclusion, communication, patient_instruction, updated_at)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, NOW())
+	// Карту заполняют сотрудники клиники, осматривающей пациента
+	user, ok := requestUser(ctx, w, r)
+	if !ok {
+		return
+	}
+	if !isClinicStaff(user) {
+		errorResponse(w, http.StatusForbidden, "only clinic staff can edit ambulatory cards")
+		return
+	}
+
+	// Записи специалистов и анализы пишутся в эпизод открытого визита (или последний неподписанный эпизод)
+	var episodeID int64
+	var err error
+	if in.EpisodeID != nil {
+		episodeID = *in.EpisodeID
+	} else {
+		known, err := records.PatientInClinic(ctx, in.PatientUID, userClinicID(user))
+		if err != nil {
+			log.Printf("upsertAmbulatoryCard: patient clinic error: %v", err)
+			errorResponse(w, http.StatusInternalServerError, "db error")
+			return
+		}
+		if !known {
+			errorResponse(w, http.StatusForbidden, "patient is not examined by this clinic")
+			return
+		}
+		episodeID, _, err = writableEpisodeID(ctx, db, in.PatientUID, userClinicID(user), true)
+		if err != nil {
+			log.Printf("upsertAmbulatoryCard: resolve episode error: %v", err)
+			errorResponse(w, http.StatusInternalServerError, "db error")
+			return
+		}
+	}
+	_, episode, ok := authorizeEpisode(ctx, w, r, episodeID, true)
+	if !ok {
+		return
+	}
+	if episode.PatientUID != in.PatientUID {
+		errorResponse(w, http.StatusBadRequest, "episode belongs to another patient")
+		return
+	}
+
+	// Подписанный председателем эпизод закрыт для правок специалистов до переоткрытия
+	var locked, specSame, labsSame bool
+	err = db.QueryRow(ctx, `
+		SELECT locked,
+		       specialist_entries IS NOT DISTINCT FROM $3::jsonb,
+		       lab_results IS NOT DISTINCT FROM $4::jsonb
+		FROM exam_episodes WHERE id = $1 AND patient_uid = $2
+	`, episodeID, in.PatientUID, in.Spec, in.Labs).Scan(&locked, &specSame, &labsSame)
+	if err != nil {
+		errorResponse(w, http.StatusNotFound, "episode not found")
+		return
+	}
+	if locked && ((in.Spec != nil && !specSame) || (in.Labs != nil && !labsSame)) {
+		errorResponse(w, http.StatusLocked, "card is locked by the final conclusion")
+		return
+	}
+
+	// Раздел, который сейчас правит коллега, не перезаписываем
+	if cardPresenceStore != nil {
+		sections, err := cardSectionChanges(ctx, in.PatientUID, episodeID, in.General, in.Medical, in.Spec, in.Labs)
+		if err != nil {
+			log.Printf("upsertAmbulatoryCard: changed sections error: %v", err)
+			errorResponse(w, http.StatusInternalServerError, "db error")
+			return
+		}
+		if !checkSectionLocks(ctx, w, r, cardPresenceStore, in.PatientUID, sections) {
+			return
+		}
+	}
+
+	// Используем ON CONFLICT для обновления если уже существует.
+	// В самой карте остаются паспортная часть и анамнез; final_conclusion подписывает только председатель комиссии.
+	_, err = db.Exec(ctx, `
+		INSERT INTO ambulatory_cards (patient_uid, iin, general, medical, communication, patient_instruction, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, NOW())
 		ON CONFLICT (patient_uid) DO UPDATE SET
 			iin = EXCLUDED.iin,
 			general = EXCLUDED.general,
 			medical = EXCLUDED.medical,
-			specialist_entries = EXCLUDED.specialist_entries,
-			lab_results = EXCLUDED.lab_results,
-			final_conclusion = EXCLUDED.final_conclusion,
 			communication = EXCLUDED.communication,
 			patient_instruction = EXCLUDED.patient_instruction,
 			updated_at = NOW()
-	`, in.PatientUID, in.IIN, in.General, in.Medical, in.Spec, in.Labs, in.Final, in.Comm, in.Instr)
+	`, in.PatientUID, in.IIN, in.General, in.Medical, in.Comm, in.Instr)
 
 	if err != nil {
 		log.Printf("upsertAmbulatoryCard error: %v", err)
@@ -1917,49 +1859,41 @@ func upsertAmbulatoryCardHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	_, err = db.Exec(ctx, `
+		UPDATE exam_episodes SET
+			specialist_entries = COALESCE($2::jsonb, specialist_entries),
+			lab_results = COALESCE($3::jsonb, lab_results),
+			updated_at = NOW()
+		WHERE id = $1 AND NOT locked
+	`, episodeID, in.Spec, in.Labs)
+	if err != nil {
+		log.Printf("upsertAmbulatoryCard: update episode error: %v", err)
+		errorResponse(w, http.StatusInternalServerError, "db error")
+		return
+	}
+
 	// АВТОМАТИЧЕСКАЯ ОТМЕТКА В МАРШРУТНОМ ЛИСТЕ
 	var clinicIDForNotification string
-	if in.Spec != nil {
+	if in.Spec != nil && !locked {
 		var entries map[string]interface{}
-		if err := json.Unmarshal(in.Spec, &entries); err == nil {
-			for specialtyName := range entries {
-				specialty := specialtyName
-				log.Printf("Updating route sheet for employee %s, specialty: %s", in.PatientUID, specialty)
-
-				// Более надежный запрос обновления элемента в JSONB массиве
-				res, err := db.Exec(ctx, `
-					UPDATE employee_visits 
-					SET 
-						route_sheet = (
-							SELECT jsonb_agg(
-								CASE 
-									WHEN LOWER(TRIM(item->>'specialty')) = LOWER(TRIM($1)) 
-									THEN item || jsonb_build_object('status', 'completed', 'completedAt', NOW())
-									ELSE item 
-								END
-							)
-							FROM jsonb_array_elements(route_sheet) AS item
-						),
-						status = CASE WHEN status = 'registered' THEN 'in_progress' ELSE status END,
-						updated_at = NOW()
-					WHERE employee_id = $2 AND status IN ('registered', 'in_progress')
-				`, specialty, in.PatientUID)
-
-				if err != nil {
-					log.Printf("Error updating route_sheet for %s: %v", specialty, err)
-				} else {
-					rows := res.RowsAffected()
-					log.Printf("Route sheet updated, rows affected: %d", rows)
-				}
-
-				// Получаем clinicID для уведомлений (только один раз)
-				if clinicIDForNotification == "" {
-					_ = db.QueryRow(ctx, "SELECT clinic_id FROM employee_visits WHERE employee_id = $1 AND status IN ('registered', 'in_progress') LIMIT 1", in.PatientUID).Scan(&clinicIDForNotification)
-				}
+		if err := json.Unmarshal(in.Spec, &entries); err == nil && len(entries) > 0 {
+			done := make(map[string]bool, len(entries))
+			for specialty := range entries {
+				done[normalizeSpecialty(specialty)] = true
+			}
+			if err := completeEmployeeRouteSteps(ctx, in.PatientUID, done); err != nil {
+				log.Printf("upsertAmbulatoryCard: route sheet for %s: %v", in.PatientUID, err)
 			}
 		}
 	}
 
+	if in.Spec != nil && !locked {
+		invalidateContractStatsForEmployee(ctx, in.PatientUID)
+	}
+	if (in.Spec != nil || in.Labs != nil) && !locked {
+		checkEmergencyNotifications(ctx, episodeID)
+	}
+
 	// Оповещаем сотрудника (по ИИН и по UUID если возможно)
 	broadcastToUser(in.PatientUID, "visit_updated", map[string]interface{}{
 		"employeeId": in.PatientUID,
@@ -1975,23 +1909,16 @@ func upsertAmbulatoryCardHandler(w http.ResponseWriter, r *http.Request) {
 		})
 	}
 
-	// Оповещаем клинику и всех врачей клиники
+	// Оповещаем клинику и её врачей
 	if clinicIDForNotification == "" {
 		_ = db.QueryRow(ctx, "SELECT clinic_id FROM employee_visits WHERE employee_id = $1 AND status IN ('registered', 'in_progress') LIMIT 1", in.PatientUID).Scan(&clinicIDForNotification)
 	}
-	if clinicIDForNotification != "" {
-		broadcastToUser(clinicIDForNotification, "visit_updated", map[string]interface{}{
-			"employeeId": in.PatientUID,
-		})
-
-		// Также уведомляем всех врачей клиники о обновлении визита
-		broadcastToRole(UserRoleDoctor, "visit_updated", map[string]interface{}{
-			"employeeId": in.PatientUID,
-			"clinicId":   clinicIDForNotification,
-		})
-	}
+	broadcastToClinic(clinicIDForNotification, "visit_updated", map[string]interface{}{
+		"employeeId": in.PatientUID,
+		"clinicId":   clinicIDForNotification,
+	})
 
-	jsonResponse(w, http.StatusOK, map[string]string{"status": "ok"})
+	jsonResponse(w, http.StatusOK, map[string]any{"status": "ok", "episodeId": episodeID})
 }
 
 // --- MAIN ---
@@ -1999,7 +1926,13 @@ func upsertAmbulatoryCardHandler(w http.ResponseWriter, r *http.Request) {
 func main() {
 	ctx := context.Background()
 
+	// Ключи подписи ссылок, штампов и справок; без них сервер не запускается
 	var err error
+	serverKeys, err = newKeyConfigFromEnv()
+	if err != nil {
+		log.Fatalf("signing keys: %v", err)
+	}
+
 	db, err = initDB(ctx)
 	if err != nil {
 		log.Fatalf("db init failed: %v", err)
@@ -2008,7 +1941,40 @@ func main() {
 
 	// Инициализация WebSocket Hub
 	hub = NewHub()
-	go hub.Run()
+	// Доставка событий клиентам всех реплик (Postgres LISTEN/NOTIFY)
+	// с журналом для досылки пропущенных событий
+	events := pgEventLog{}
+	bus = newEventBusFromEnv(hub, events)
+	startEventLogPruner()
+
+	// Кто открыл амбулаторную карту и какие разделы заняты
+	cardPresenceStore = pgPresenceStore{}
+	startPresenceSweeper(cardPresenceStore)
+
+	// Приём результатов анализаторов по MLLP
+	hl7Auth, err = newHL7AccessFromEnv()
+	if err != nil {
+		log.Fatalf("HL7 access: %v", err)
+	}
+	startMLLPListener()
+
+	// Хранилище вложений (локальный диск или S3-совместимое)
+	attachmentStore, err = newBlobStoreFromEnv()
+	if err != nil {
+		log.Fatalf("attachment storage error: %v", err)
+	}
+
+	// Канал экстренных извещений в СЭС (SMTP или webhook)
+	sesChannel, err = newNotificationChannelFromEnv()
+	if err != nil {
+		log.Fatalf("emergency notification channel error: %v", err)
+	}
+
+	// Напоминания о явках на диспансерный осмотр
+	startDispensaryScheduler()
+
+	// Ежедневный список работников, которым подходит срок периодического осмотра
+	startExamRecallScheduler()
 
 	mux := http.NewServeMux()
 
@@ -2016,7 +1982,10 @@ func main() {
 	mux.HandleFunc("/health", healthHandler)
 
 	// WebSocket
-	mux.HandleFunc("/ws", wsHandler)
+	mux.HandleFunc("/ws", wsHandler(pgTopicDirectory{}, events, cardPresenceStore))
+	mux.HandleFunc("/api/ws/protocol", wsProtocolSchemaHandler)
+	// Запасной канал для сетей без WebSocket
+	mux.HandleFunc("/api/events", sseHandler(pgTopicDirectory{}, events))
 
 	// Users
 	mux.HandleFunc("/api/users/by-phone", getUserByPhoneHandler)
@@ -2042,6 +2011,336 @@ func main() {
 		}
 	})
 
+	mux.HandleFunc("/api/visits/", func(w http.ResponseWriter, r *http.Request) {
+		// routes:
+		// POST /api/visits/{id}/final-conclusion
+		// POST /api/visits/{id}/final-conclusion/reopen
+		if id, reopen, ok := parseVisitConclusionPath(r.URL.Path); ok {
+			if r.Method != http.MethodPost {
+				errorResponse(w, http.StatusMethodNotAllowed, "method not allowed")
+				return
+			}
+			if reopen {
+				reopenFinalConclusionHandler(w, r, id)
+			} else {
+				signFinalConclusionHandler(w, r, id)
+			}
+			return
+		}
+		// GET/POST /api/visits/{id}/lab-orders
+		if id, sub, ok := parseResourcePath(r.URL.Path, "/api/visits/"); ok && sub == "lab-orders" {
+			if r.Method != http.MethodGet && r.Method != http.MethodPost {
+				errorResponse(w, http.StatusMethodNotAllowed, "method not allowed")
+				return
+			}
+			visitLabOrdersHandler(w, r, id)
+			return
+		}
+		errorResponse(w, http.StatusNotFound, "not found")
+	})
+
+	// Exam episodes
+	mux.HandleFunc("/api/episodes", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			createEpisodeHandler(w, r)
+			return
+		}
+		errorResponse(w, http.StatusMethodNotAllowed, "method not allowed")
+	})
+	mux.HandleFunc("/api/episodes/", func(w http.ResponseWriter, r *http.Request) {
+		id, sub, ok := parseResourcePath(r.URL.Path, "/api/episodes/")
+		if !ok {
+			errorResponse(w, http.StatusNotFound, "not found")
+			return
+		}
+		if sub == "lab-results" {
+			switch r.Method {
+			case http.MethodGet:
+				listEpisodeLabResultsHandler(w, r, id)
+			case http.MethodPost:
+				createLabResultsHandler(w, r, id)
+			default:
+				errorResponse(w, http.StatusMethodNotAllowed, "method not allowed")
+			}
+			return
+		}
+		if sub != "" {
+			errorResponse(w, http.StatusNotFound, "not found")
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			getEpisodeHandler(w, r, id)
+		case http.MethodPatch:
+			updateEpisodeHandler(w, r, id)
+		default:
+			errorResponse(w, http.StatusMethodNotAllowed, "method not allowed")
+		}
+	})
+	mux.HandleFunc("/api/patients/", func(w http.ResponseWriter, r *http.Request) {
+		// GET /api/patients/{patientUid}/episodes
+		// GET /api/patients/{patientUid}/lab-trends
+		if strings.HasSuffix(r.URL.Path, "/episodes") && r.Method == http.MethodGet {
+			listPatientEpisodesHandler(w, r)
+			return
+		}
+		if strings.HasSuffix(r.URL.Path, "/lab-trends") && r.Method == http.MethodGet {
+			labTrendsHandler(w, r)
+			return
+		}
+		errorResponse(w, http.StatusNotFound, "not found")
+	})
+
+	// HL7 v2 ORU^R01 от анализаторов (HTTP; MLLP - отдельный TCP-порт)
+	mux.HandleFunc("/api/hl7/oru", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			hl7HTTPHandler(w, r)
+			return
+		}
+		errorResponse(w, http.StatusMethodNotAllowed, "method not allowed")
+	})
+
+	// Lab catalog
+	mux.HandleFunc("/api/lab/tests", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			listLabTestsHandler(w, r)
+		case http.MethodPost:
+			upsertLabTestHandler(w, r)
+		default:
+			errorResponse(w, http.StatusMethodNotAllowed, "method not allowed")
+		}
+	})
+
+	// Lab queue and specimens
+	mux.HandleFunc("/api/lab/queue", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			labQueueHandler(w, r)
+			return
+		}
+		errorResponse(w, http.StatusMethodNotAllowed, "method not allowed")
+	})
+	mux.HandleFunc("/api/lab/specimens/", func(w http.ResponseWriter, r *http.Request) {
+		// GET  /api/lab/specimens/{barcode}/label?format=png|zpl
+		// POST /api/lab/specimens/{barcode}/collect | receive | result
+		barcode, action, _ := strings.Cut(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/lab/specimens/"), "/"), "/")
+		switch {
+		case barcode == "":
+			errorResponse(w, http.StatusNotFound, "not found")
+		case action == "label" && r.Method == http.MethodGet:
+			specimenLabelHandler(w, r, barcode)
+		case r.Method == http.MethodPost:
+			specimenTransitionHandler(w, r, barcode, action)
+		default:
+			errorResponse(w, http.StatusMethodNotAllowed, "method not allowed")
+		}
+	})
+
+	// Emergency notifications
+	mux.HandleFunc("/api/emergency-notifications", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			errorResponse(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		listEmergencyNotificationsHandler(w, r)
+	})
+	mux.HandleFunc("/api/emergency-notifications/", func(w http.ResponseWriter, r *http.Request) {
+		// GET/PATCH /api/emergency-notifications/{id}
+		// POST /api/emergency-notifications/{id}/send | /acknowledge
+		id, sub, ok := parseResourcePath(r.URL.Path, "/api/emergency-notifications/")
+		if !ok {
+			errorResponse(w, http.StatusNotFound, "not found")
+			return
+		}
+		emergencyNotificationHandler(w, r, id, sub)
+	})
+
+	// Occupational disease referrals
+	mux.HandleFunc("/api/occupational-referrals", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			errorResponse(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		listOccupationalReferralsHandler(w, r)
+	})
+	mux.HandleFunc("/api/occupational-referrals/", func(w http.ResponseWriter, r *http.Request) {
+		// GET/PATCH /api/occupational-referrals/{id}
+		// GET /api/occupational-referrals/{id}/notice|referral?format=pdf|docx
+		// POST /api/occupational-referrals/{id}/outcome
+		id, sub, ok := parseResourcePath(r.URL.Path, "/api/occupational-referrals/")
+		if !ok {
+			errorResponse(w, http.StatusNotFound, "not found")
+			return
+		}
+		occupationalReferralHandler(w, r, id, sub)
+	})
+
+	// Next periodic exam due dates
+	mux.HandleFunc("/api/exam-recalls", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			listExamRecallsHandler(w, r)
+			return
+		}
+		errorResponse(w, http.StatusMethodNotAllowed, "method not allowed")
+	})
+
+	// Pre-shift / post-shift exams and their journal
+	mux.HandleFunc("/api/shift-exams", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			createShiftExamHandler(w, r)
+			return
+		}
+		errorResponse(w, http.StatusMethodNotAllowed, "method not allowed")
+	})
+	mux.HandleFunc("/api/shift-exams/", func(w http.ResponseWriter, r *http.Request) {
+		// GET /api/shift-exams/verify?id=&code= - публичная сверка штампа
+		// GET /api/shift-exams/{id}, GET /api/shift-exams/{id}/stamp?format=pdf|docx
+		if r.Method != http.MethodGet {
+			errorResponse(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		if r.URL.Path == "/api/shift-exams/verify" {
+			verifyShiftStampHandler(w, r)
+			return
+		}
+		id, sub, ok := parseResourcePath(r.URL.Path, "/api/shift-exams/")
+		if !ok {
+			errorResponse(w, http.StatusNotFound, "not found")
+			return
+		}
+		shiftExamHandler(w, r, id, sub)
+	})
+	mux.HandleFunc("/api/shift-journal", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			shiftJournalHandler(w, r)
+			return
+		}
+		errorResponse(w, http.StatusMethodNotAllowed, "method not allowed")
+	})
+	mux.HandleFunc("/api/shift-journal/export", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			exportShiftJournalHandler(w, r)
+			return
+		}
+		errorResponse(w, http.StatusMethodNotAllowed, "method not allowed")
+	})
+
+	// Pre-shift kiosk devices and their signed measurements
+	mux.HandleFunc("/api/devices", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			listDevicesHandler(w, r)
+		case http.MethodPost:
+			createDeviceHandler(w, r)
+		default:
+			errorResponse(w, http.StatusMethodNotAllowed, "method not allowed")
+		}
+	})
+	mux.HandleFunc("/api/devices/", func(w http.ResponseWriter, r *http.Request) {
+		// PATCH /api/devices/{id}, POST /api/devices/{id}/rotate-key
+		id, sub, ok := parseResourcePath(r.URL.Path, "/api/devices/")
+		if !ok {
+			errorResponse(w, http.StatusNotFound, "not found")
+			return
+		}
+		deviceHandler(w, r, id, sub)
+	})
+	ingestMeasurement := ingestDeviceMeasurement(pgDeviceStore{})
+	mux.HandleFunc("/api/device-measurements", func(w http.ResponseWriter, r *http.Request) {
+		// POST - от прибора (подпись вместо сессии), GET - медработнику
+		switch r.Method {
+		case http.MethodGet:
+			listDeviceMeasurementsHandler(w, r)
+		case http.MethodPost:
+			ingestMeasurement(w, r)
+		default:
+			errorResponse(w, http.StatusMethodNotAllowed, "method not allowed")
+		}
+	})
+
+	// QR certificates: admission to shift and fitness conclusion
+	mux.HandleFunc("/api/certificates", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			issueCertificateHandler(w, r)
+			return
+		}
+		errorResponse(w, http.StatusMethodNotAllowed, "method not allowed")
+	})
+	mux.HandleFunc("/api/certificates/", func(w http.ResponseWriter, r *http.Request) {
+		// GET /api/certificates/verify?t=&iin= и /api/certificates/public-key - без авторизации
+		// GET /api/certificates/{id}, .../qr, .../print?format=pdf|docx; POST .../revoke
+		switch {
+		case r.URL.Path == "/api/certificates/verify" && r.Method == http.MethodGet:
+			verifyCertificateHandler(w, r)
+			return
+		case r.URL.Path == "/api/certificates/public-key" && r.Method == http.MethodGet:
+			certificatePublicKeyHandler(w, r)
+			return
+		}
+		id, sub, ok := parseResourcePath(r.URL.Path, "/api/certificates/")
+		if !ok {
+			errorResponse(w, http.StatusNotFound, "not found")
+			return
+		}
+		certificateHandler(w, r, id, sub)
+	})
+
+	// Dispensary observation
+	mux.HandleFunc("/api/dispensary", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			listDispensaryHandler(w, r)
+		case http.MethodPost:
+			createDispensaryHandler(w, r)
+		default:
+			errorResponse(w, http.StatusMethodNotAllowed, "method not allowed")
+		}
+	})
+	mux.HandleFunc("/api/dispensary/", func(w http.ResponseWriter, r *http.Request) {
+		// GET /api/dispensary/due?days=14 - явки клиники на ближайшие дни и просроченные
+		// GET/PATCH /api/dispensary/{id}, POST /api/dispensary/{id}/end
+		// PATCH /api/dispensary/{id}/followups/{fid}, POST .../followups/{fid}/complete|miss
+		if r.URL.Path == "/api/dispensary/due" && r.Method == http.MethodGet {
+			dispensaryDueHandler(w, r)
+			return
+		}
+		id, sub, ok := parseResourcePath(r.URL.Path, "/api/dispensary/")
+		if !ok {
+			errorResponse(w, http.StatusNotFound, "not found")
+			return
+		}
+		dispensaryHandler(w, r, id, sub)
+	})
+
+	// Attachments
+	mux.HandleFunc("/api/attachments", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			listAttachmentsHandler(w, r)
+		case http.MethodPost:
+			uploadAttachmentHandler(w, r)
+		default:
+			errorResponse(w, http.StatusMethodNotAllowed, "method not allowed")
+		}
+	})
+	mux.HandleFunc("/api/attachments/", func(w http.ResponseWriter, r *http.Request) {
+		// GET/DELETE /api/attachments/{id}
+		// GET /api/attachments/{id}/download?uid=&expires=&sig=
+		id, sub, ok := parseResourcePath(r.URL.Path, "/api/attachments/")
+		switch {
+		case !ok:
+			errorResponse(w, http.StatusNotFound, "not found")
+		case sub == "download" && r.Method == http.MethodGet:
+			downloadAttachmentHandler(w, r, id)
+		case sub == "" && r.Method == http.MethodGet:
+			getAttachmentHandler(w, r, id)
+		case sub == "" && r.Method == http.MethodDelete:
+			deleteAttachmentHandler(w, r, id)
+		default:
+			errorResponse(w, http.StatusNotFound, "not found")
+		}
+	})
+
 	// Contracts
 	mux.HandleFunc("/api/contracts", func(w http.ResponseWriter, r *http.Request) {
 		switch r.Method {
@@ -2066,6 +2365,20 @@ func main() {
 		}
 	})
 
+	mux.HandleFunc("/api/ambulatory-cards/", func(w http.ResponseWriter, r *http.Request) {
+		// GET/POST /api/ambulatory-cards/{id}/form-052.pdf
+		id, sub, ok := parseResourcePath(r.URL.Path, "/api/ambulatory-cards/")
+		if !ok || sub != "form-052.pdf" {
+			errorResponse(w, http.StatusNotFound, "not found")
+			return
+		}
+		if r.Method != http.MethodGet && r.Method != http.MethodPost {
+			errorResponse(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		form052Handler(w, r, id)
+	})
+
 	// Doctors
 	mux.HandleFunc("/api/clinics/", func(w http.ResponseWriter, r *http.Request) {
 		// routes:
@@ -2101,6 +2414,30 @@ func main() {
 		errorResponse(w, http.StatusNotFound, "not found")
 	})
 	mux.HandleFunc("/api/contracts/", func(w http.ResponseWriter, r *http.Request) {
+		// Вложенные ресурсы договора: /api/contracts/{id}/{sub}
+		if id, sub, ok := parseResourcePath(r.URL.Path, "/api/contracts/"); ok && sub != "" {
+			switch {
+			case sub == "abnormal-labs" && r.Method == http.MethodGet:
+				contractAbnormalLabsHandler(w, r, id)
+			case sub == "final-act" && (r.Method == http.MethodGet || r.Method == http.MethodPost):
+				finalActHandler(w, r, id)
+			case sub == "health-plan" || strings.HasPrefix(sub, "health-plan/"):
+				healthPlanHandler(w, r, id, strings.TrimPrefix(strings.TrimPrefix(sub, "health-plan"), "/"))
+			case sub == "stats" && r.Method == http.MethodGet:
+				contractStatsHandler(w, r, id)
+			case sub == "exam-recalls" && r.Method == http.MethodPost:
+				recomputeExamRecallsHandler(w, r, id)
+			case sub == "outcomes" && r.Method == http.MethodGet:
+				employerOutcomesHandler(w, r, id)
+			case sub == "documents" && r.Method == http.MethodGet:
+				listContractDocumentsHandler(w, r, id)
+			case strings.HasPrefix(sub, "documents/") && r.Method == http.MethodGet:
+				contractDocumentHandler(w, r, id, strings.TrimPrefix(sub, "documents/"))
+			default:
+				errorResponse(w, http.StatusNotFound, "not found")
+			}
+			return
+		}
 		if r.Method == http.MethodGet {
 			getContractHandler(w, r)
 			return
@@ -2116,7 +2453,7 @@ func main() {
 	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Простые CORS-заголовки для локальной разработки
 		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-User-ID, Last-Event-ID")
 		w.Header().Set("Access-Control-Allow-Methods", "GET,POST,PATCH,PUT,DELETE,OPTIONS")
 
 		if r.Method == http.MethodOptions {
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/smtp"
+	"os"
+	"strings"
+	"time"
+)
+
+// outboundMessage - готовое извещение для отправки во внешнюю службу
+type outboundMessage struct {
+	ID      string // идемпотентный ключ: получатель по нему отбрасывает повторы
+	Subject string
+	Body    string
+	Payload any // структурированное содержимое для webhook
+}
+
+// notificationChannel - канал доставки извещений в СЭС
+type notificationChannel interface {
+	Name() string
+	Send(ctx context.Context, msg outboundMessage) error
+}
+
+var errChannelNotConfigured = errors.New("notification channel is not configured")
+
+// newNotificationChannelFromEnv выбирает канал по SES_CHANNEL: smtp или webhook.
+// Без настройки извещения остаются черновиками, отправка возвращает ошибку.
+func newNotificationChannelFromEnv() (notificationChannel, error) {
+	switch kind := os.Getenv("SES_CHANNEL"); kind {
+	case "":
+		return nil, nil
+	case "smtp":
+		addr := os.Getenv("SES_SMTP_ADDR")
+		from := os.Getenv("SES_SMTP_FROM")
+		to := splitList(os.Getenv("SES_EMAIL_TO"))
+		if addr == "" || from == "" || len(to) == 0 {
+			return nil, errors.New("SES_SMTP_ADDR, SES_SMTP_FROM and SES_EMAIL_TO are required for smtp channel")
+		}
+		return &smtpChannel{
+			Addr:     addr,
+			From:     from,
+			To:       to,
+			Username: os.Getenv("SES_SMTP_USER"),
+			Password: os.Getenv("SES_SMTP_PASSWORD"),
+		}, nil
+	case "webhook":
+		url := os.Getenv("SES_WEBHOOK_URL")
+		if url == "" {
+			return nil, errors.New("SES_WEBHOOK_URL is required for webhook channel")
+		}
+		return &webhookChannel{
+			URL:    url,
+			Secret: os.Getenv("SES_WEBHOOK_SECRET"),
+			Client: &http.Client{Timeout: 15 * time.Second},
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown SES_CHANNEL %q", kind)
+	}
+}
+
+func splitList(s string) []string {
+	var res []string
+	for _, p := range strings.Split(s, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			res = append(res, p)
+		}
+	}
+	return res
+}
+
+// --- SMTP ---
+
+type smtpChannel struct {
+	Addr     string // host:port
+	From     string
+	To       []string
+	Username string
+	Password string
+}
+
+func (c *smtpChannel) Name() string { return "smtp" }
+
+// buildMail - письмо text/plain в UTF-8; тело в base64, чтобы кириллица прошла через любой релей
+func (c *smtpChannel) buildMail(msg outboundMessage, now time.Time) []byte {
+	host, _, _ := net.SplitHostPort(c.Addr)
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", c.From)
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(c.To, ", "))
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.BEncoding.Encode("UTF-8", msg.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", now.Format(time.RFC1123Z))
+	fmt.Fprintf(&b, "Message-ID: <%s@%s>\r\n", msg.ID, host)
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	b.WriteString("Content-Transfer-Encoding: base64\r\n\r\n")
+	enc := base64.StdEncoding.EncodeToString([]byte(msg.Body))
+	for len(enc) > 76 {
+		b.WriteString(enc[:76] + "\r\n")
+		enc = enc[76:]
+	}
+	b.WriteString(enc + "\r\n")
+	return b.Bytes()
+}
+
+func (c *smtpChannel) Send(ctx context.Context, msg outboundMessage) error {
+	var auth smtp.Auth
+	if c.Username != "" {
+		host, _, _ := net.SplitHostPort(c.Addr)
+		auth = smtp.PlainAuth("", c.Username, c.Password, host)
+	}
+	// net/smtp не принимает контекст: отправка в отдельной горутине, ожидание ограничено ctx
+	done := make(chan error, 1)
+	go func() {
+		done <- smtp.SendMail(c.Addr, auth, c.From, c.To, c.buildMail(msg, time.Now()))
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// --- Webhook ---
+
+type webhookChannel struct {
+	URL    string
+	Secret string // HMAC-SHA256 тела в заголовке X-Signature
+	Client *http.Client
+}
+
+func (c *webhookChannel) Name() string { return "webhook" }
+
+func signWebhookBody(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// verifyWebhookSignature сравнивает подпись за постоянное время
+func verifyWebhookSignature(secret string, body []byte, signature string) bool {
+	if secret == "" || signature == "" {
+		return false
+	}
+	return hmac.Equal([]byte(signWebhookBody(secret, body)), []byte(signature))
+}
+
+func (c *webhookChannel) Send(ctx context.Context, msg outboundMessage) error {
+	body, err := json.Marshal(map[string]any{
+		"id":      msg.ID,
+		"subject": msg.Subject,
+		"text":    msg.Body,
+		"data":    msg.Payload,
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Idempotency-Key", msg.ID)
+	if c.Secret != "" {
+		req.Header.Set("X-Signature", signWebhookBody(c.Secret, body))
+	}
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		text, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("webhook responded %d: %s", resp.StatusCode, strings.TrimSpace(string(text)))
+	}
+	return nil
+}
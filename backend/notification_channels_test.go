@@ -0,0 +1,232 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/mail"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeSMTP - локальная замена почтового релея СЭС: принимает письма и хранит их в памяти
+type fakeSMTP struct {
+	ln         net.Listener
+	rejectRcpt string
+	mu         sync.Mutex
+	mails      []fakeMail
+}
+
+type fakeMail struct {
+	From string
+	To   []string
+	Data string
+}
+
+func startFakeSMTP(t *testing.T) *fakeSMTP {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	s := &fakeSMTP{ln: ln}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *fakeSMTP) serve(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	r := bufio.NewReader(conn)
+	reply := func(line string) { io.WriteString(conn, line+"\r\n") }
+	reply("220 localhost ESMTP test")
+
+	var cur fakeMail
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		cmd := strings.ToUpper(line)
+		switch {
+		case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+			reply("250-localhost")
+			reply("250 8BITMIME")
+		case strings.HasPrefix(cmd, "MAIL FROM:"):
+			cur = fakeMail{From: smtpPath(line)}
+			reply("250 OK")
+		case strings.HasPrefix(cmd, "RCPT TO:"):
+			rcpt := smtpPath(line)
+			if rcpt == s.rejectRcpt {
+				reply("550 mailbox unavailable")
+				continue
+			}
+			cur.To = append(cur.To, rcpt)
+			reply("250 OK")
+		case cmd == "DATA":
+			reply("354 end with <CRLF>.<CRLF>")
+			var data strings.Builder
+			for {
+				l, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if l == ".\r\n" {
+					break
+				}
+				data.WriteString(strings.TrimPrefix(l, "."))
+			}
+			cur.Data = data.String()
+			s.mu.Lock()
+			s.mails = append(s.mails, cur)
+			s.mu.Unlock()
+			reply("250 queued")
+		case cmd == "RSET", cmd == "NOOP":
+			reply("250 OK")
+		case cmd == "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("502 not implemented")
+		}
+	}
+}
+
+// smtpPath - адрес из <...>; параметры вроде BODY=8BITMIME отбрасываются
+func smtpPath(line string) string {
+	_, rest, _ := strings.Cut(line, "<")
+	addr, _, _ := strings.Cut(rest, ">")
+	return addr
+}
+
+func (s *fakeSMTP) received() []fakeMail {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]fakeMail(nil), s.mails...)
+}
+
+func TestSMTPChannelDeliversNotice(t *testing.T) {
+	relay := startFakeSMTP(t)
+	ch := &smtpChannel{Addr: relay.ln.Addr().String(), From: "clinic@example.kz", To: []string{"ses@example.kz", "duty@example.kz"}}
+
+	msg := outboundMessage{ID: "emergency-7", Subject: "Экстренное извещение № 7 (форма 058/у)", Body: "1. Диагноз: Туберкулёз лёгких\n   Код по МКБ-10: A15.0\n"}
+	if err := ch.Send(context.Background(), msg); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+
+	mails := relay.received()
+	if len(mails) != 1 {
+		t.Fatalf("relay received %d mails, want 1", len(mails))
+	}
+	got := mails[0]
+	if got.From != "clinic@example.kz" || strings.Join(got.To, ",") != "ses@example.kz,duty@example.kz" {
+		t.Fatalf("envelope = %s -> %v", got.From, got.To)
+	}
+
+	m, err := mail.ReadMessage(strings.NewReader(got.Data))
+	if err != nil {
+		t.Fatalf("parse mail: %v", err)
+	}
+	subject, err := new(mime.WordDecoder).DecodeHeader(m.Header.Get("Subject"))
+	if err != nil || subject != msg.Subject {
+		t.Errorf("subject = %q (%v), want %q", subject, err, msg.Subject)
+	}
+	if id := m.Header.Get("Message-ID"); !strings.HasPrefix(id, "<emergency-7@") {
+		t.Errorf("Message-ID = %q", id)
+	}
+	raw, _ := io.ReadAll(m.Body)
+	body, err := base64.StdEncoding.DecodeString(strings.ReplaceAll(string(raw), "\r\n", ""))
+	if err != nil {
+		t.Fatalf("decode body: %v", err)
+	}
+	if string(body) != msg.Body {
+		t.Errorf("body = %q, want %q", body, msg.Body)
+	}
+}
+
+func TestSMTPChannelReportsRejection(t *testing.T) {
+	relay := startFakeSMTP(t)
+	relay.rejectRcpt = "ses@example.kz"
+	ch := &smtpChannel{Addr: relay.ln.Addr().String(), From: "clinic@example.kz", To: []string{"ses@example.kz"}}
+	if err := ch.Send(context.Background(), outboundMessage{ID: "emergency-1", Subject: "s", Body: "b"}); err == nil {
+		t.Fatal("send to rejected recipient succeeded")
+	}
+	if n := len(relay.received()); n != 0 {
+		t.Fatalf("relay stored %d mails", n)
+	}
+}
+
+func TestWebhookChannelSignsPayload(t *testing.T) {
+	const secret = "test-secret"
+	var got map[string]any
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if !verifyWebhookSignature(secret, body, r.Header.Get("X-Signature")) {
+			http.Error(w, "bad signature", http.StatusUnauthorized)
+			return
+		}
+		if r.Header.Get("Idempotency-Key") != "emergency-3" {
+			http.Error(w, "missing idempotency key", http.StatusBadRequest)
+			return
+		}
+		json.Unmarshal(body, &got)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer srv.Close()
+
+	ch := &webhookChannel{URL: srv.URL, Secret: secret, Client: srv.Client()}
+	notice := EmergencyNotice{ICDCode: "A15.0", Diagnosis: "Туберкулёз лёгких"}
+	if err := ch.Send(context.Background(), outboundMessage{ID: "emergency-3", Subject: "s", Body: "text", Payload: notice}); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	data, _ := got["data"].(map[string]any)
+	if got["id"] != "emergency-3" || got["text"] != "text" || data["icdCode"] != "A15.0" {
+		t.Fatalf("payload = %v", got)
+	}
+
+	// Чужой секрет - получатель отвечает 401, канал возвращает ошибку
+	bad := &webhookChannel{URL: srv.URL, Secret: "other", Client: srv.Client()}
+	if err := bad.Send(context.Background(), outboundMessage{ID: "emergency-3"}); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Fatalf("send with wrong secret: %v", err)
+	}
+}
+
+func TestVerifyWebhookSignature(t *testing.T) {
+	body := []byte(`{"registrationNumber":"15-2026"}`)
+	sig := signWebhookBody("s3cret", body)
+	if !verifyWebhookSignature("s3cret", body, sig) {
+		t.Fatal("valid signature rejected")
+	}
+	cases := []struct {
+		name, secret, sig string
+		body              []byte
+	}{
+		{"empty secret", "", sig, body},
+		{"wrong secret", "other", sig, body},
+		{"empty header", "s3cret", "", body},
+		{"tampered body", "s3cret", sig, []byte(`{"registrationNumber":"16-2026"}`)},
+	}
+	for _, c := range cases {
+		if verifyWebhookSignature(c.secret, c.body, c.sig) {
+			t.Errorf("%s: accepted", c.name)
+		}
+	}
+}
@@ -0,0 +1,702 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Подозрение на профессиональное заболевание: при подписании заключения с группой
+// «признаки профзаболевания» (или флагом occupationalSuspicion) клиника выдаёт извещение
+// о предварительном диагнозе и направляет работника в центр профпатологии. Итог из центра
+// возвращается в историю болезней карты и в заключительный акт договора.
+
+// Группа здоровья «лица с признаками профессионального заболевания»
+const occupationalDiseaseGroup = "VI"
+
+const (
+	ReferralStatusIssued    = "issued"
+	ReferralStatusCompleted = "completed" // центр дал заключение
+	ReferralStatusCancelled = "cancelled" // заключение переподписано без подозрения
+
+	ReferralOutcomeConfirmed    = "confirmed"     // связь с профессией установлена
+	ReferralOutcomeNotConfirmed = "not_confirmed" // заболевание общее
+)
+
+func occupationalSuspected(final map[string]any) bool {
+	return conclusionFlag(final, "occupationalSuspicion") || cardText(final, "healthGroup") == occupationalDiseaseGroup
+}
+
+// OccupationalNotice - извещение о предварительном диагнозе и данные для направления
+type OccupationalNotice struct {
+	PatientName          string   `json:"patientName"`
+	IIN                  string   `json:"iin"`
+	Gender               string   `json:"gender"`
+	BirthDate            string   `json:"birthDate"`
+	Address              string   `json:"address"`
+	Employer             string   `json:"employer"`
+	EmployerBIN          string   `json:"employerBin"`
+	Site                 string   `json:"site"`
+	Position             string   `json:"position"`
+	HarmfulFactor        string   `json:"harmfulFactor"`
+	TotalExperience      string   `json:"totalExperience"`
+	PositionExperience   string   `json:"positionExperience"`
+	PreliminaryDiagnosis string   `json:"preliminaryDiagnosis"`
+	ICDCodes             []string `json:"icdCodes"`
+	HealthGroup          string   `json:"healthGroup"`
+	ExamDate             string   `json:"examDate"`
+	ClinicName           string   `json:"clinicName"`
+	ClinicBIN            string   `json:"clinicBin"`
+	Chairman             string   `json:"chairman"`
+}
+
+type OccupationalReferral struct {
+	ID                int64              `json:"id"`
+	Number            string             `json:"number"`
+	EpisodeID         int64              `json:"episodeId"`
+	VisitID           *int64             `json:"visitId,omitempty"`
+	ContractID        *int64             `json:"contractId,omitempty"`
+	PatientUID        string             `json:"patientUid"`
+	ClinicID          *string            `json:"clinicId,omitempty"`
+	Center            string             `json:"center"`
+	Notice            OccupationalNotice `json:"notice"`
+	Status            string             `json:"status"`
+	Outcome           *string            `json:"outcome,omitempty"`
+	OutcomeDiagnosis  *string            `json:"outcomeDiagnosis,omitempty"`
+	OutcomeICD        *string            `json:"outcomeIcd,omitempty"`
+	OutcomeDate       *string            `json:"outcomeDate,omitempty"`
+	OutcomeDocument   *string            `json:"outcomeDocument,omitempty"` // № заключения центра
+	OutcomeNote       *string            `json:"outcomeNote,omitempty"`
+	IssuedBy          *string            `json:"issuedBy,omitempty"`
+	OutcomeRecordedBy *string            `json:"outcomeRecordedBy,omitempty"`
+	CreatedAt         string             `json:"createdAt"`
+	UpdatedAt         string             `json:"updatedAt"`
+}
+
+func migrateOccupationalReferrals(ctx context.Context, tx pgx.Tx) error {
+	_, err := tx.Exec(ctx, `
+CREATE TABLE IF NOT EXISTS occupational_referrals (
+  id                  SERIAL PRIMARY KEY,
+  episode_id          INTEGER NOT NULL UNIQUE REFERENCES exam_episodes(id) ON DELETE CASCADE,
+  visit_id            INTEGER,
+  contract_id         INTEGER REFERENCES contracts(id) ON DELETE SET NULL,
+  patient_uid         TEXT NOT NULL,
+  clinic_id           TEXT,
+  center              TEXT NOT NULL DEFAULT '',
+  notice              JSONB NOT NULL,
+  status              TEXT NOT NULL DEFAULT 'issued',
+  outcome             TEXT,
+  outcome_diagnosis   TEXT,
+  outcome_icd         TEXT,
+  outcome_date        DATE,
+  outcome_document    TEXT,
+  outcome_note        TEXT,
+  issued_by           TEXT,
+  outcome_recorded_by TEXT,
+  created_at          TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  updated_at          TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  CONSTRAINT valid_referral_status CHECK (status IN ('issued', 'completed', 'cancelled')),
+  CONSTRAINT valid_referral_outcome CHECK (outcome IS NULL OR outcome IN ('confirmed', 'not_confirmed'))
+);
+`)
+	if err != nil {
+		return fmt.Errorf("migrate occupational_referrals: %w", err)
+	}
+	_, err = tx.Exec(ctx, `CREATE INDEX IF NOT EXISTS idx_occupational_referrals_contract ON occupational_referrals(contract_id);`)
+	if err != nil {
+		return fmt.Errorf("create index occupational_referrals_contract: %w", err)
+	}
+	// Направления без клиники не видит никто: клиника берётся из визита или договора
+	_, err = tx.Exec(ctx, `
+UPDATE occupational_referrals r SET clinic_id = v.clinic_id
+FROM employee_visits v
+WHERE r.clinic_id IS NULL AND v.id = r.visit_id AND v.clinic_id <> '';
+UPDATE occupational_referrals r SET clinic_id = u.id
+FROM contracts c JOIN users u ON u.bin = c.clinic_bin AND u.role = 'clinic'
+WHERE r.clinic_id IS NULL AND c.id = r.contract_id;
+`)
+	if err != nil {
+		return fmt.Errorf("backfill occupational_referrals clinic: %w", err)
+	}
+	_, err = tx.Exec(ctx, `CREATE INDEX IF NOT EXISTS idx_occupational_referrals_clinic ON occupational_referrals(clinic_id, status);`)
+	if err != nil {
+		return fmt.Errorf("create index occupational_referrals_clinic: %w", err)
+	}
+	return nil
+}
+
+const referralColumns = `id, episode_id, visit_id, contract_id, patient_uid, clinic_id, center, notice, status,
+outcome, outcome_diagnosis, outcome_icd, outcome_date, outcome_document, outcome_note, issued_by, outcome_recorded_by,
+created_at, updated_at`
+
+func scanOccupationalReferral(row pgx.Row) (*OccupationalReferral, error) {
+	var ref OccupationalReferral
+	var notice []byte
+	var outcomeDate *time.Time
+	var createdAt, updatedAt time.Time
+	err := row.Scan(&ref.ID, &ref.EpisodeID, &ref.VisitID, &ref.ContractID, &ref.PatientUID, &ref.ClinicID, &ref.Center, &notice,
+		&ref.Status, &ref.Outcome, &ref.OutcomeDiagnosis, &ref.OutcomeICD, &outcomeDate, &ref.OutcomeDocument, &ref.OutcomeNote,
+		&ref.IssuedBy, &ref.OutcomeRecordedBy, &createdAt, &updatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(notice, &ref.Notice); err != nil {
+		return nil, fmt.Errorf("parse referral notice %d: %w", ref.ID, err)
+	}
+	if outcomeDate != nil {
+		s := outcomeDate.Format("2006-01-02")
+		ref.OutcomeDate = &s
+	}
+	ref.Number = fmt.Sprintf("%d/%d", createdAt.Year(), ref.ID)
+	ref.CreatedAt = createdAt.Format(time.RFC3339)
+	ref.UpdatedAt = updatedAt.Format(time.RFC3339)
+	return &ref, nil
+}
+
+func loadOccupationalReferral(ctx context.Context, q dbExecutor, id int64) (*OccupationalReferral, error) {
+	return scanOccupationalReferral(q.QueryRow(ctx, `SELECT `+referralColumns+` FROM occupational_referrals WHERE id = $1`, id))
+}
+
+// --- Формирование ---
+
+// buildOccupationalNotice собирает извещение из эпизода, карты и контингента договора
+func buildOccupationalNotice(ctx context.Context, q dbExecutor, e *ExamEpisode, final map[string]any, chairman *Doctor) (OccupationalNotice, error) {
+	n := OccupationalNotice{
+		HealthGroup: cardText(final, "healthGroup"),
+		ExamDate:    cardText(final, "date"),
+		Chairman:    chairman.Name,
+		ICDCodes:    []string{},
+	}
+
+	var generalJSON []byte
+	err := q.QueryRow(ctx, `SELECT iin, general FROM ambulatory_cards WHERE patient_uid = $1`, e.PatientUID).Scan(&n.IIN, &generalJSON)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return n, err
+	}
+	var general map[string]any
+	_ = json.Unmarshal(generalJSON, &general)
+	n.PatientName = cardText(general, "fullName")
+	n.BirthDate = cardText(general, "dob")
+	n.Address = cardText(general, "address")
+	n.Employer = cardText(general, "workPlace")
+	n.Position = cardText(general, "position")
+	switch cardText(general, "gender") {
+	case "male":
+		n.Gender = "мужской"
+	case "female":
+		n.Gender = "женский"
+	}
+
+	// Участок, профессия, вредные факторы и стаж - из контингента договора (Приложение 3)
+	if e.ContractID != nil {
+		var employeesJSON []byte
+		err := q.QueryRow(ctx, `SELECT client_name, client_bin, clinic_name, clinic_bin, employees FROM contracts WHERE id = $1`, *e.ContractID).
+			Scan(&n.Employer, &n.EmployerBIN, &n.ClinicName, &n.ClinicBIN, &employeesJSON)
+		if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+			return n, err
+		}
+		var employees []contractEmployee
+		_ = json.Unmarshal(employeesJSON, &employees)
+		for _, emp := range employees {
+			if !emp.matches(e.PatientUID) {
+				continue
+			}
+			if n.PatientName == "" {
+				n.PatientName = strings.TrimSpace(emp.Name)
+			}
+			if n.BirthDate == "" {
+				n.BirthDate = emp.Dob
+			}
+			n.Site, n.HarmfulFactor = emp.Site, emp.HarmfulFactor
+			n.TotalExperience, n.PositionExperience = emp.TotalExperience, emp.PositionExperience
+			if emp.Position != "" {
+				n.Position = emp.Position
+			}
+			break
+		}
+	}
+	if n.ClinicName == "" && e.ClinicID != nil {
+		_ = q.QueryRow(ctx, `SELECT COALESCE(company_name, ''), COALESCE(bin, '') FROM users WHERE id = $1`, *e.ClinicID).Scan(&n.ClinicName, &n.ClinicBIN)
+	}
+
+	// Предварительный диагноз - из заключения, иначе из записей специалистов
+	var spec map[string]map[string]any
+	_ = json.Unmarshal(e.Spec, &spec)
+	codes := map[string]bool{}
+	n.PreliminaryDiagnosis = cardText(final, "diagnosis")
+	for _, code := range icdPattern.FindAllString(n.PreliminaryDiagnosis, -1) {
+		codes[code] = true
+	}
+	var specDiagnoses []string
+	for _, specialty := range sortedKeys(spec) {
+		d := cardText(spec[specialty], "diagnosis")
+		if d == "" {
+			continue
+		}
+		specDiagnoses = append(specDiagnoses, specialty+": "+d)
+		for _, code := range icdPattern.FindAllString(d, -1) {
+			codes[code] = true
+		}
+	}
+	if n.PreliminaryDiagnosis == "" {
+		n.PreliminaryDiagnosis = strings.Join(specDiagnoses, "; ")
+	}
+	n.ICDCodes = sortedKeys(codes)
+	return n, nil
+}
+
+// syncOccupationalReferral вызывается при подписании заключения: создаёт или обновляет
+// направление, а если подозрение снято - отменяет ещё не исполненное. Направление, по
+// которому центр уже дал заключение, не меняется.
+func syncOccupationalReferral(ctx context.Context, q dbExecutor, episodeID int64, final map[string]any, chairman *Doctor) (*OccupationalReferral, error) {
+	if !occupationalSuspected(final) {
+		_, err := q.Exec(ctx, `
+UPDATE occupational_referrals SET status = 'cancelled', updated_at = NOW()
+WHERE episode_id = $1 AND status = 'issued'
+`, episodeID)
+		return nil, err
+	}
+
+	e, err := loadEpisode(ctx, q, episodeID)
+	if err != nil {
+		return nil, err
+	}
+	notice, err := buildOccupationalNotice(ctx, q, e, final, chairman)
+	if err != nil {
+		return nil, err
+	}
+	noticeJSON, err := json.Marshal(notice)
+	if err != nil {
+		return nil, err
+	}
+	clinicID, err := episodeClinicID(ctx, q, e)
+	if err != nil {
+		return nil, err
+	}
+	if clinicID == "" {
+		// Без клиники направление некому выдать и увидеть
+		return nil, fmt.Errorf("episode %d has no clinic", e.ID)
+	}
+	ref, err := scanOccupationalReferral(q.QueryRow(ctx, `
+INSERT INTO occupational_referrals (episode_id, visit_id, contract_id, patient_uid, clinic_id, notice, issued_by)
+VALUES ($1, $2, $3, $4, $5, $6, $7)
+ON CONFLICT (episode_id) DO UPDATE SET
+  notice = EXCLUDED.notice, issued_by = EXCLUDED.issued_by, status = 'issued', updated_at = NOW()
+WHERE occupational_referrals.status <> 'completed'
+RETURNING `+referralColumns, e.ID, e.VisitID, e.ContractID, e.PatientUID, clinicID, noticeJSON, fmt.Sprintf("%d", chairman.ID)))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	return ref, err
+}
+
+// cancelEpisodeReferral вызывается при переоткрытии заключения: невыполненное направление
+// отменяется вместе с заключением и выдаётся заново при повторном подписании. Направление,
+// по которому центр уже дал заключение, остаётся в силе.
+func cancelEpisodeReferral(ctx context.Context, q dbExecutor, episodeID int64) (*int64, error) {
+	var id int64
+	err := q.QueryRow(ctx, `
+UPDATE occupational_referrals SET status = 'cancelled', updated_at = NOW()
+WHERE episode_id = $1 AND status = 'issued'
+RETURNING id
+`, episodeID).Scan(&id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &id, nil
+}
+
+// --- Печать ---
+
+func referralOutcomeText(status string, outcome, icd, diagnosis *string) string {
+	switch {
+	case status == ReferralStatusCancelled:
+		return "направление отменено"
+	case outcome == nil:
+		return "направлен, заключение центра не получено"
+	case *outcome == ReferralOutcomeConfirmed:
+		return strings.TrimSpace("профзаболевание подтверждено: " + derefString(icd) + " " + derefString(diagnosis))
+	default:
+		return strings.TrimSpace("связь с профессией не установлена " + derefString(icd))
+	}
+}
+
+func derefString(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+func (ref *OccupationalReferral) noticeFields(d *reportDoc) {
+	n := ref.Notice
+	employer := n.Employer
+	if n.EmployerBIN != "" {
+		employer += " (БИН " + n.EmployerBIN + ")"
+	}
+	rows := [][]string{
+		{"1. Фамилия, имя, отчество", orDash(n.PatientName)},
+		{"2. ИИН", orDash(n.IIN)},
+		{"3. Пол", orDash(n.Gender)},
+		{"4. Дата рождения", orDash(n.BirthDate)},
+		{"5. Адрес проживания", orDash(n.Address)},
+		{"6. Место работы (работодатель)", orDash(strings.TrimSpace(employer))},
+		{"7. Цех, участок", orDash(n.Site)},
+		{"8. Профессия, должность", orDash(n.Position)},
+		{"9. Вредные производственные факторы", orDash(n.HarmfulFactor)},
+		{"10. Стаж общий / в профессии", orDash(n.TotalExperience) + " / " + orDash(n.PositionExperience)},
+		{"11. Предварительный диагноз", orDash(n.PreliminaryDiagnosis)},
+		{"12. Код по МКБ-10", orDash(strings.Join(n.ICDCodes, ", "))},
+		{"13. Дата медицинского осмотра", formatActDate(n.ExamDate)},
+	}
+	d.Table([]float64{0.38, 0.62}, nil, rows)
+}
+
+func buildOccupationalNoticeReport(ref *OccupationalReferral) *reportDoc {
+	n := ref.Notice
+	d := &reportDoc{footer: fmt.Sprintf("Извещение № %s · %s", ref.Number, n.ClinicName)}
+	d.Text("Медицинская организация: " + orDash(n.ClinicName) + " (БИН " + orDash(n.ClinicBIN) + ")")
+	d.Title("Извещение № " + ref.Number)
+	d.Text("об установлении предварительного диагноза профессионального заболевания (отравления)")
+	ref.noticeFields(d)
+	d.Text("Извещение направляется работодателю, в территориальное подразделение государственного органа в сфере санитарно-эпидемиологического благополучия населения и в центр профессиональной патологии.")
+	d.Text("")
+	d.Text("Дата выдачи: " + formatActDate(ref.CreatedAt[:10]))
+	d.Text("Председатель врачебной комиссии: ____________________ " + n.Chairman)
+	d.Text("М.П.")
+	return d
+}
+
+func buildOccupationalReferralReport(ref *OccupationalReferral) *reportDoc {
+	n := ref.Notice
+	center := ref.Center
+	if center == "" {
+		center = "____________________________________________"
+	}
+	d := &reportDoc{footer: fmt.Sprintf("Направление № %s · %s", ref.Number, n.ClinicName)}
+	d.Text("Медицинская организация: " + orDash(n.ClinicName) + " (БИН " + orDash(n.ClinicBIN) + ")")
+	d.Title("Направление № " + ref.Number)
+	d.Text("в центр профессиональной патологии: " + center)
+	d.Text("для экспертизы связи заболевания с профессией по результатам периодического медицинского осмотра")
+	ref.noticeFields(d)
+	d.Heading("Прилагаются:")
+	d.Text("1. Выписка из медицинской карты амбулаторного пациента (форма 052/у).\n" +
+		"2. Результаты лабораторных и инструментальных исследований.\n" +
+		"3. Санитарно-гигиеническая характеристика условий труда (запрашивается работодателем).\n" +
+		"4. Копия трудовой книжки или иного документа о трудовой деятельности.")
+	d.Text("")
+	d.Text("Дата выдачи: " + formatActDate(ref.CreatedAt[:10]))
+	d.Text("Председатель врачебной комиссии: ____________________ " + n.Chairman)
+	d.Text("М.П.")
+	return d
+}
+
+// --- Handlers ---
+
+// canAccessReferral - работник видит свои направления, персонал - только своей клиники
+func canAccessReferral(u *User, ref *OccupationalReferral) bool {
+	if u.Role == UserRoleEmployee {
+		return u.ID == ref.PatientUID
+	}
+	if !isClinicStaff(u) {
+		return false
+	}
+	return ref.ClinicID != nil && *ref.ClinicID != "" && *ref.ClinicID == userClinicID(u)
+}
+
+// GET /api/occupational-referrals?status=&contractId=&patientUid=
+func listOccupationalReferralsHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	user, ok := requestUser(ctx, w, r)
+	if !ok {
+		return
+	}
+	query := `SELECT ` + referralColumns + ` FROM occupational_referrals WHERE `
+	var args []any
+	switch {
+	case isClinicStaff(user):
+		args = append(args, userClinicID(user))
+		query += `clinic_id = $1`
+	case user.Role == UserRoleEmployee:
+		args = append(args, user.ID)
+		query += `patient_uid = $1`
+	default:
+		errorResponse(w, http.StatusForbidden, "access denied")
+		return
+	}
+	q := r.URL.Query()
+	if v := q.Get("status"); v != "" {
+		args = append(args, v)
+		query += fmt.Sprintf(" AND status = $%d", len(args))
+	}
+	if v := q.Get("contractId"); v != "" {
+		args = append(args, v)
+		query += fmt.Sprintf(" AND contract_id = $%d::int", len(args))
+	}
+	if v := q.Get("patientUid"); v != "" {
+		args = append(args, v)
+		query += fmt.Sprintf(" AND patient_uid = $%d", len(args))
+	}
+	query += ` ORDER BY created_at DESC, id DESC LIMIT 500`
+
+	rows, err := db.Query(ctx, query, args...)
+	if err != nil {
+		log.Printf("listOccupationalReferrals error: %v", err)
+		errorResponse(w, http.StatusInternalServerError, "db error")
+		return
+	}
+	defer rows.Close()
+	res := []*OccupationalReferral{}
+	for rows.Next() {
+		ref, err := scanOccupationalReferral(rows)
+		if err != nil {
+			log.Printf("listOccupationalReferrals scan error: %v", err)
+			errorResponse(w, http.StatusInternalServerError, "db error")
+			return
+		}
+		res = append(res, ref)
+	}
+	jsonResponse(w, http.StatusOK, res)
+}
+
+// /api/occupational-referrals/{id}[/notice|/referral|/outcome]
+func occupationalReferralHandler(w http.ResponseWriter, r *http.Request, id int64, sub string) {
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	user, ok := requestUser(ctx, w, r)
+	if !ok {
+		return
+	}
+	ref, err := loadOccupationalReferral(ctx, db, id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		errorResponse(w, http.StatusNotFound, "referral not found")
+		return
+	}
+	if err != nil {
+		log.Printf("occupationalReferral %d: %v", id, err)
+		errorResponse(w, http.StatusInternalServerError, "db error")
+		return
+	}
+	if !canAccessReferral(user, ref) {
+		errorResponse(w, http.StatusForbidden, "access denied")
+		return
+	}
+	if r.Method != http.MethodGet && !isClinicStaff(user) {
+		errorResponse(w, http.StatusForbidden, "only clinic staff can change referrals")
+		return
+	}
+
+	switch {
+	case sub == "" && r.Method == http.MethodGet:
+		jsonResponse(w, http.StatusOK, ref)
+	case sub == "" && r.Method == http.MethodPatch:
+		updateOccupationalReferral(ctx, w, r, ref)
+	case (sub == "notice" || sub == "referral") && r.Method == http.MethodGet:
+		report := buildOccupationalNoticeReport(ref)
+		if sub == "referral" {
+			report = buildOccupationalReferralReport(ref)
+		}
+		writeReport(w, report, fmt.Sprintf("%s-%d", sub, ref.ID), r.URL.Query().Get("format"))
+	case sub == "outcome" && r.Method == http.MethodPost:
+		recordReferralOutcome(ctx, w, r, ref, user)
+	default:
+		errorResponse(w, http.StatusNotFound, "not found")
+	}
+}
+
+// writeReport отдаёт печатную форму: ?format=pdf (по умолчанию) или docx
+func writeReport(w http.ResponseWriter, report *reportDoc, name, format string) {
+	switch format {
+	case "", "pdf":
+		regular, bold, err := loadPDFFonts()
+		if err != nil {
+			log.Printf("report %s: font error: %v", name, err)
+			errorResponse(w, http.StatusInternalServerError, "pdf font is not available")
+			return
+		}
+		w.Header().Set("Content-Type", "application/pdf")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`inline; filename="%s.pdf"`, name))
+		w.Write(report.PDF(regular, bold))
+	case "docx":
+		w.Header().Set("Content-Type", "application/vnd.openxmlformats-officedocument.wordprocessingml.document")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.docx"`, name))
+		w.Write(report.DOCX())
+	default:
+		errorResponse(w, http.StatusBadRequest, "format must be pdf or docx")
+	}
+}
+
+// PATCH {center} - центр профпатологии, куда направлен работник
+func updateOccupationalReferral(ctx context.Context, w http.ResponseWriter, r *http.Request, ref *OccupationalReferral) {
+	var in struct {
+		Center *string `json:"center"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil || in.Center == nil {
+		errorResponse(w, http.StatusBadRequest, "center is required")
+		return
+	}
+	updated, err := scanOccupationalReferral(db.QueryRow(ctx, `
+UPDATE occupational_referrals SET center = $2, updated_at = NOW() WHERE id = $1
+RETURNING `+referralColumns, ref.ID, strings.TrimSpace(*in.Center)))
+	if err != nil {
+		log.Printf("updateOccupationalReferral %d: %v", ref.ID, err)
+		errorResponse(w, http.StatusInternalServerError, "db error")
+		return
+	}
+	jsonResponse(w, http.StatusOK, updated)
+}
+
+// occupationalHistoryEntry - запись о заключении центра в medical.occupationalDiseases карты
+type occupationalHistoryEntry struct {
+	ReferralID int64  `json:"referralId"`
+	Outcome    string `json:"outcome"`
+	ICDCode    string `json:"icdCode,omitempty"`
+	Diagnosis  string `json:"diagnosis"`
+	Date       string `json:"date"`
+	Center     string `json:"center,omitempty"`
+	Document   string `json:"document,omitempty"`
+}
+
+// recordOccupationalHistory заменяет запись по направлению в medical.occupationalDiseases карты.
+// Карты может не быть (эпизод заведён по визиту) - тогда она создаётся с ИИН из извещения.
+func recordOccupationalHistory(ctx context.Context, q dbExecutor, ref *OccupationalReferral, entry occupationalHistoryEntry) error {
+	entryJSON, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	tag, err := q.Exec(ctx, `
+INSERT INTO ambulatory_cards (patient_uid, iin, medical, updated_at)
+VALUES ($1, $4, jsonb_build_object('occupationalDiseases', jsonb_build_array($3::jsonb)), NOW())
+ON CONFLICT (patient_uid) DO UPDATE SET
+  medical = jsonb_set(COALESCE(ambulatory_cards.medical, '{}'::jsonb), '{occupationalDiseases}',
+    COALESCE((
+      SELECT jsonb_agg(x) FROM jsonb_array_elements(
+        CASE WHEN jsonb_typeof(ambulatory_cards.medical->'occupationalDiseases') = 'array'
+             THEN ambulatory_cards.medical->'occupationalDiseases' ELSE '[]'::jsonb END
+      ) AS x
+      WHERE (x->>'referralId') IS DISTINCT FROM $2::text
+    ), '[]'::jsonb) || jsonb_build_array($3::jsonb)),
+  updated_at = NOW()
+`, ref.PatientUID, fmt.Sprintf("%d", ref.ID), entryJSON, ref.Notice.IIN)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("card of patient %s not updated", ref.PatientUID)
+	}
+	return nil
+}
+
+// POST /outcome {outcome, diagnosis, icdCode, date, document, note} - заключение центра профпатологии.
+// Повторная отправка исправляет ранее внесённый итог.
+func recordReferralOutcome(ctx context.Context, w http.ResponseWriter, r *http.Request, ref *OccupationalReferral, user *User) {
+	var in struct {
+		Outcome   string `json:"outcome"`
+		Diagnosis string `json:"diagnosis"`
+		ICDCode   string `json:"icdCode"`
+		Date      string `json:"date"`
+		Document  string `json:"document"`
+		Note      string `json:"note"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		errorResponse(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+	if in.Outcome != ReferralOutcomeConfirmed && in.Outcome != ReferralOutcomeNotConfirmed {
+		errorResponse(w, http.StatusBadRequest, "outcome must be confirmed or not_confirmed")
+		return
+	}
+	in.Diagnosis = strings.TrimSpace(in.Diagnosis)
+	in.ICDCode = strings.ToUpper(strings.TrimSpace(in.ICDCode))
+	if in.ICDCode == "" {
+		in.ICDCode = icdPattern.FindString(in.Diagnosis)
+	}
+	if in.Outcome == ReferralOutcomeConfirmed && in.Diagnosis == "" {
+		errorResponse(w, http.StatusBadRequest, "diagnosis is required for a confirmed occupational disease")
+		return
+	}
+	if in.ICDCode != "" && !icdPattern.MatchString(in.ICDCode) {
+		errorResponse(w, http.StatusBadRequest, "invalid icdCode")
+		return
+	}
+	if in.Date == "" {
+		in.Date = time.Now().Format("2006-01-02")
+	} else if _, err := time.Parse("2006-01-02", in.Date); err != nil {
+		errorResponse(w, http.StatusBadRequest, "date must be YYYY-MM-DD")
+		return
+	}
+	if ref.Status == ReferralStatusCancelled {
+		errorResponse(w, http.StatusConflict, "referral is cancelled")
+		return
+	}
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		errorResponse(w, http.StatusInternalServerError, "db error")
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	updated, err := scanOccupationalReferral(tx.QueryRow(ctx, `
+UPDATE occupational_referrals SET
+  status = 'completed', outcome = $2, outcome_diagnosis = NULLIF($3, ''), outcome_icd = NULLIF($4, ''),
+  outcome_date = $5::text::date, outcome_document = NULLIF($6, ''), outcome_note = NULLIF($7, ''),
+  outcome_recorded_by = $8, updated_at = NOW()
+WHERE id = $1 AND status <> 'cancelled'
+RETURNING `+referralColumns, ref.ID, in.Outcome, in.Diagnosis, in.ICDCode, in.Date, strings.TrimSpace(in.Document),
+		strings.TrimSpace(in.Note), user.ID))
+	if errors.Is(err, pgx.ErrNoRows) {
+		errorResponse(w, http.StatusConflict, "referral is cancelled")
+		return
+	}
+	if err != nil {
+		log.Printf("recordReferralOutcome %d: %v", ref.ID, err)
+		errorResponse(w, http.StatusInternalServerError, "db error")
+		return
+	}
+
+	err = recordOccupationalHistory(ctx, tx, ref, occupationalHistoryEntry{
+		ReferralID: ref.ID,
+		Outcome:    in.Outcome,
+		ICDCode:    in.ICDCode,
+		Diagnosis:  in.Diagnosis,
+		Date:       in.Date,
+		Center:     ref.Center,
+		Document:   strings.TrimSpace(in.Document),
+	})
+	if err != nil {
+		log.Printf("recordReferralOutcome %d: card history: %v", ref.ID, err)
+		errorResponse(w, http.StatusInternalServerError, "db error")
+		return
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		errorResponse(w, http.StatusInternalServerError, "db error")
+		return
+	}
+
+	event := map[string]interface{}{
+		"id":         updated.ID,
+		"episodeId":  updated.EpisodeID,
+		"employeeId": updated.PatientUID,
+		"outcome":    in.Outcome,
+	}
+	if updated.ClinicID != nil {
+		broadcastToUser(*updated.ClinicID, "occupational_referral_updated", event)
+	}
+	broadcastToUser(updated.PatientUID, "visit_updated", event)
+	jsonResponse(w, http.StatusOK, updated)
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+func TestCanAccessReferralRequiresClinic(t *testing.T) {
+	own := &OccupationalReferral{PatientUID: "emp-a", ClinicID: strp("clinic-a")}
+	if !canAccessReferral(doctorA, own) || !canAccessReferral(clinicA, own) || !canAccessReferral(employeA, own) {
+		t.Error("own clinic staff and the patient must see the referral")
+	}
+	if canAccessReferral(doctorB, own) || canAccessReferral(orgA, own) {
+		t.Error("foreign clinic or employer must not see the referral")
+	}
+	for _, ref := range []*OccupationalReferral{{PatientUID: "emp-a"}, {PatientUID: "emp-a", ClinicID: strp("")}} {
+		if canAccessReferral(doctorA, ref) || canAccessReferral(doctorB, ref) {
+			t.Errorf("referral without clinic is open to every clinic: %+v", ref)
+		}
+	}
+}
+
+func TestOccupationalSuspected(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		final map[string]any
+		want  bool
+	}{
+		{"group VI", map[string]any{"healthGroup": "VI"}, true},
+		{"group VI with spaces", map[string]any{"healthGroup": " VI "}, true},
+		{"explicit flag", map[string]any{"healthGroup": "III", "occupationalSuspicion": true}, true},
+		{"flag off", map[string]any{"healthGroup": "III", "occupationalSuspicion": false}, false},
+		{"flag as text", map[string]any{"occupationalSuspicion": "true"}, false},
+		{"other group", map[string]any{"healthGroup": "V"}, false},
+		{"empty", map[string]any{}, false},
+		{"nil", nil, false},
+	} {
+		if got := occupationalSuspected(tc.final); got != tc.want {
+			t.Errorf("%s: occupationalSuspected = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+// fakeReferralDB - эпизод, карта, договор и одно направление эпизода в памяти
+type fakeReferralDB struct {
+	episode  *ExamEpisode
+	card     []any // iin, general
+	contract []any // client_name, client_bin, clinic_name, clinic_bin, employees
+
+	status   string // "" - направления ещё нет
+	notice   []byte
+	issuedBy string
+
+	sql  string
+	args []any
+	tag  pgconn.CommandTag
+}
+
+const fakeReferralID = 5
+
+func (f *fakeReferralDB) referralRow() pgx.Row {
+	e := f.episode
+	now := time.Date(2026, 3, 2, 10, 0, 0, 0, time.UTC)
+	return fakeRow{vals: []any{int64(fakeReferralID), e.ID, e.VisitID, e.ContractID, e.PatientUID, e.ClinicID, "", f.notice,
+		f.status, (*string)(nil), (*string)(nil), (*string)(nil), (*time.Time)(nil), (*string)(nil), (*string)(nil),
+		&f.issuedBy, (*string)(nil), now, now}}
+}
+
+// cancel повторяет UPDATE ... WHERE status = 'issued'
+func (f *fakeReferralDB) cancel() bool {
+	if f.status != ReferralStatusIssued {
+		return false
+	}
+	f.status = ReferralStatusCancelled
+	return true
+}
+
+func (f *fakeReferralDB) QueryRow(ctx context.Context, sql string, args ...any) pgx.Row {
+	switch {
+	case strings.Contains(sql, "FROM exam_episodes WHERE id"):
+		e := f.episode
+		date := mustDate(e.ExamDate)
+		return fakeRow{vals: []any{e.ID, e.PatientUID, e.VisitID, e.ContractID, e.ClinicID, e.ExamType, date, e.Status,
+			[]byte(e.Spec), []byte("{}"), []byte(e.Final), []byte("[]"), e.FinalSignedBy, (*time.Time)(nil), e.Locked, date, date}}
+	case strings.Contains(sql, "FROM ambulatory_cards"):
+		if f.card == nil {
+			return fakeRow{err: pgx.ErrNoRows}
+		}
+		return fakeRow{vals: f.card}
+	case strings.Contains(sql, "FROM contracts"):
+		if f.contract == nil {
+			return fakeRow{err: pgx.ErrNoRows}
+		}
+		return fakeRow{vals: f.contract}
+	case strings.Contains(sql, "FROM users"):
+		return fakeRow{vals: []any{"Клиника по профилю", "990440000011"}}
+	case strings.Contains(sql, "INSERT INTO occupational_referrals"):
+		if f.status == ReferralStatusCompleted {
+			return fakeRow{err: pgx.ErrNoRows}
+		}
+		f.status, f.notice, f.issuedBy = ReferralStatusIssued, args[5].([]byte), args[6].(string)
+		return f.referralRow()
+	case strings.Contains(sql, "SET status = 'cancelled'"):
+		if !f.cancel() {
+			return fakeRow{err: pgx.ErrNoRows}
+		}
+		return fakeRow{vals: []any{int64(fakeReferralID)}}
+	}
+	return fakeRow{err: errors.New("unexpected query: " + sql)}
+}
+
+func (f *fakeReferralDB) Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error) {
+	f.sql, f.args = sql, args
+	if strings.Contains(sql, "SET status = 'cancelled'") {
+		if f.cancel() {
+			return pgconn.NewCommandTag("UPDATE 1"), nil
+		}
+		return pgconn.NewCommandTag("UPDATE 0"), nil
+	}
+	return f.tag, nil
+}
+
+func (f *fakeReferralDB) Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error) {
+	return nil, errors.New("unexpected query")
+}
+
+func referralEpisode() *ExamEpisode {
+	contractID := int64(1)
+	return &ExamEpisode{
+		ID: 3, PatientUID: "emp-a", ContractID: &contractID, ClinicID: strp("clinic-a"),
+		ExamType: ExamTypePeriodic, ExamDate: "2026-03-02", Status: "concluded",
+		Spec: json.RawMessage(`{"Терапевт": {"diagnosis": "J44 ХОБЛ"}, "ЛОР": {"diagnosis": "H90.3 тугоухость"}, "Хирург": {"conclusion": "здоров"}}`),
+	}
+}
+
+func TestBuildOccupationalNotice(t *testing.T) {
+	q := &fakeReferralDB{
+		card: []any{"850412300123", []byte(`{"fullName": "Иванов Иван", "gender": "male", "dob": "1985-04-12", "address": "г. Темиртау", "position": "слесарь"}`)},
+		contract: []any{"ТОО «Завод»", "120340005678", "Клиника №1", "990440000022", []byte(`[
+			{"id": "emp-x", "name": "Другой", "site": "склад"},
+			{"id": "row-7", "userId": "emp-a", "site": "литейный цех", "position": "литейщик", "harmfulFactor": "шум, пыль",
+			 "totalExperience": "20 лет", "positionExperience": "12 лет"}
+		]`)},
+	}
+	e := referralEpisode()
+	final := map[string]any{"healthGroup": "VI", "date": "2026-03-02", "diagnosis": "J62.8 Пневмокониоз?"}
+
+	n, err := buildOccupationalNotice(context.Background(), q, e, final, &Doctor{ID: 9, Name: "Петров П.П."})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := OccupationalNotice{
+		PatientName: "Иванов Иван", IIN: "850412300123", Gender: "мужской", BirthDate: "1985-04-12", Address: "г. Темиртау",
+		Employer: "ТОО «Завод»", EmployerBIN: "120340005678", Site: "литейный цех", Position: "литейщик",
+		HarmfulFactor: "шум, пыль", TotalExperience: "20 лет", PositionExperience: "12 лет",
+		PreliminaryDiagnosis: "J62.8 Пневмокониоз?", ICDCodes: []string{"H90.3", "J44", "J62.8"},
+		HealthGroup: "VI", ExamDate: "2026-03-02", ClinicName: "Клиника №1", ClinicBIN: "990440000022", Chairman: "Петров П.П.",
+	}
+	if !reflect.DeepEqual(n, want) {
+		t.Errorf("notice = %+v\nwant    %+v", n, want)
+	}
+
+	// Без диагноза в заключении - диагнозы специалистов; без договора и карты - клиника из пользователя
+	q = &fakeReferralDB{}
+	e.ContractID = nil
+	n, err = buildOccupationalNotice(context.Background(), q, e, map[string]any{"occupationalSuspicion": true}, &Doctor{Name: "Петров П.П."})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n.PreliminaryDiagnosis != "ЛОР: H90.3 тугоухость; Терапевт: J44 ХОБЛ" || !reflect.DeepEqual(n.ICDCodes, []string{"H90.3", "J44"}) {
+		t.Errorf("diagnosis from specialists = %q, %v", n.PreliminaryDiagnosis, n.ICDCodes)
+	}
+	if n.ClinicName != "Клиника по профилю" || n.PatientName != "" || n.Gender != "" {
+		t.Errorf("notice without card and contract = %+v", n)
+	}
+}
+
+func TestSyncOccupationalReferralFollowsSignAndReopen(t *testing.T) {
+	ctx := context.Background()
+	q := &fakeReferralDB{episode: referralEpisode()}
+	chairman := &Doctor{ID: 9, Name: "Петров П.П."}
+	suspected := map[string]any{"healthGroup": "VI", "diagnosis": "J62.8"}
+
+	// Подписание с подозрением выдаёт направление
+	ref, err := syncOccupationalReferral(ctx, q, 3, suspected, chairman)
+	if err != nil || ref == nil {
+		t.Fatalf("sign: %+v, %v", ref, err)
+	}
+	if ref.Status != ReferralStatusIssued || *ref.ClinicID != "clinic-a" || ref.Notice.PreliminaryDiagnosis != "J62.8" || *ref.IssuedBy != "9" {
+		t.Errorf("issued referral = %+v", ref)
+	}
+
+	// Переоткрытие отменяет невыполненное направление, повторная подпись выдаёт его заново
+	id, err := cancelEpisodeReferral(ctx, q, 3)
+	if err != nil || id == nil || *id != fakeReferralID || q.status != ReferralStatusCancelled {
+		t.Fatalf("reopen: %v, %v, status %s", id, err, q.status)
+	}
+	if id, err := cancelEpisodeReferral(ctx, q, 3); id != nil || err != nil {
+		t.Errorf("second reopen cancelled again: %v, %v", id, err)
+	}
+	if ref, err := syncOccupationalReferral(ctx, q, 3, suspected, chairman); err != nil || ref == nil || ref.Status != ReferralStatusIssued {
+		t.Fatalf("re-sign: %+v, %v", ref, err)
+	}
+
+	// Подпись без подозрения отменяет направление
+	if ref, err := syncOccupationalReferral(ctx, q, 3, map[string]any{"healthGroup": "II"}, chairman); ref != nil || err != nil {
+		t.Fatalf("sign without suspicion: %+v, %v", ref, err)
+	}
+	if q.status != ReferralStatusCancelled {
+		t.Errorf("status after sign without suspicion = %s", q.status)
+	}
+
+	// Исполненное центром направление не отменяется и не перевыдаётся
+	q.status = ReferralStatusCompleted
+	if id, err := cancelEpisodeReferral(ctx, q, 3); id != nil || err != nil {
+		t.Errorf("completed referral cancelled on reopen: %v, %v", id, err)
+	}
+	if ref, err := syncOccupationalReferral(ctx, q, 3, suspected, chairman); ref != nil || err != nil || q.status != ReferralStatusCompleted {
+		t.Errorf("completed referral reissued: %+v, %v, status %s", ref, err, q.status)
+	}
+
+	// Эпизод без клиники направление не получает
+	q = &fakeReferralDB{episode: referralEpisode()}
+	q.episode.ClinicID, q.episode.ContractID = nil, nil
+	if _, err := syncOccupationalReferral(ctx, q, 3, suspected, chairman); err == nil || q.status != "" {
+		t.Errorf("referral without clinic: %v, status %q", err, q.status)
+	}
+}
+
+func TestRecordOccupationalHistory(t *testing.T) {
+	ref := &OccupationalReferral{ID: 5, PatientUID: "emp-a", Notice: OccupationalNotice{IIN: "850412300123"}}
+	entry := occupationalHistoryEntry{ReferralID: 5, Outcome: ReferralOutcomeConfirmed, ICDCode: "J62.8", Diagnosis: "Пневмокониоз", Date: "2026-04-01"}
+
+	q := &fakeReferralDB{tag: pgconn.NewCommandTag("INSERT 0 1")}
+	if err := recordOccupationalHistory(context.Background(), q, ref, entry); err != nil {
+		t.Fatal(err)
+	}
+	for _, part := range []string{
+		// Карта создаётся, если её ещё нет, и NULL в medical не обнуляет историю
+		"INSERT INTO ambulatory_cards (patient_uid, iin, medical",
+		"ON CONFLICT (patient_uid) DO UPDATE",
+		"jsonb_set(COALESCE(ambulatory_cards.medical, '{}'::jsonb), '{occupationalDiseases}'",
+		// Запись по тому же направлению заменяется, остальные сохраняются
+		"WHERE (x->>'referralId') IS DISTINCT FROM $2::text",
+		"|| jsonb_build_array($3::jsonb)",
+	} {
+		if !strings.Contains(q.sql, part) {
+			t.Errorf("history query lacks %q:\n%s", part, q.sql)
+		}
+	}
+	if err := checkArgs(q.sql, q.args); err != nil {
+		t.Error(err)
+	}
+	var got occupationalHistoryEntry
+	if err := json.Unmarshal(q.args[2].([]byte), &got); err != nil || got != entry {
+		t.Errorf("entry = %+v, %v", got, err)
+	}
+	if q.args[0] != "emp-a" || q.args[1] != "5" || q.args[3] != "850412300123" {
+		t.Errorf("args = %v", q.args)
+	}
+
+	q = &fakeReferralDB{tag: pgconn.NewCommandTag("INSERT 0 0")}
+	if err := recordOccupationalHistory(context.Background(), q, ref, entry); err == nil {
+		t.Error("card left unchanged without an error")
+	}
+}
@@ -0,0 +1,618 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// Минимальный генератор PDF: страницы A4, текст шрифтом TrueType (Identity-H,
+// кириллица), линии и рамки. Вывод детерминирован: нет дат создания,
+// /ID считается из содержимого, словари пишутся в фиксированном порядке.
+
+const (
+	pdfPageWidth  = 595.28
+	pdfPageHeight = 841.89
+	pdfMargin     = 56.0
+)
+
+// --- TrueType ---
+
+type ttfFont struct {
+	name       string
+	data       []byte
+	tables     map[string][2]int // тег -> смещение, длина
+	unitsPerEm float64
+	ascent     int16
+	descent    int16
+	bbox       [4]int16
+	advances   []uint16
+	cmap       map[rune]uint16
+}
+
+func parseTTF(name string, data []byte) (*ttfFont, error) {
+	if len(data) < 12 {
+		return nil, errors.New("font file is too short")
+	}
+	u16 := func(off int) uint16 { return binary.BigEndian.Uint16(data[off:]) }
+	u32 := func(off int) uint32 { return binary.BigEndian.Uint32(data[off:]) }
+
+	tables := make(map[string][2]int)
+	numTables := int(u16(4))
+	for i := 0; i < numTables; i++ {
+		rec := 12 + i*16
+		if rec+16 > len(data) {
+			return nil, errors.New("truncated table directory")
+		}
+		off, length := int(u32(rec+8)), int(u32(rec+12))
+		if off+length > len(data) {
+			return nil, fmt.Errorf("table %s is out of bounds", data[rec:rec+4])
+		}
+		tables[string(data[rec:rec+4])] = [2]int{off, length}
+	}
+	for _, t := range []string{"head", "hhea", "hmtx", "maxp", "cmap"} {
+		if _, ok := tables[t]; !ok {
+			return nil, fmt.Errorf("font has no %s table", t)
+		}
+	}
+
+	f := &ttfFont{name: name, data: data, tables: tables, cmap: make(map[rune]uint16)}
+	head := tables["head"][0]
+	f.unitsPerEm = float64(u16(head + 18))
+	for i := range f.bbox {
+		f.bbox[i] = int16(u16(head + 36 + i*2))
+	}
+	hhea := tables["hhea"][0]
+	f.ascent = int16(u16(hhea + 4))
+	f.descent = int16(u16(hhea + 6))
+	numHMetrics := int(u16(hhea + 34))
+	numGlyphs := int(u16(tables["maxp"][0] + 4))
+
+	hmtx := tables["hmtx"][0]
+	f.advances = make([]uint16, numGlyphs)
+	for g := 0; g < numGlyphs; g++ {
+		if g < numHMetrics {
+			f.advances[g] = u16(hmtx + g*4)
+		} else if numHMetrics > 0 {
+			f.advances[g] = f.advances[numHMetrics-1]
+		}
+	}
+
+	cmap := tables["cmap"][0]
+	var fmt4, fmt12 int
+	for i := 0; i < int(u16(cmap+2)); i++ {
+		rec := cmap + 4 + i*8
+		platform, encoding := u16(rec), u16(rec+2)
+		sub := cmap + int(u32(rec+4))
+		if platform == 3 && encoding == 10 && u16(sub) == 12 {
+			fmt12 = sub
+		}
+		if platform == 3 && encoding == 1 && u16(sub) == 4 {
+			fmt4 = sub
+		}
+	}
+	switch {
+	case fmt12 != 0:
+		groups := int(u32(fmt12 + 12))
+		for i := 0; i < groups; i++ {
+			g := fmt12 + 16 + i*12
+			start, end, glyph := u32(g), u32(g+4), u32(g+8)
+			for c := start; c <= end && c-start < 0x10000; c++ {
+				f.cmap[rune(c)] = uint16(glyph + c - start)
+			}
+		}
+	case fmt4 != 0:
+		segCount := int(u16(fmt4+6)) / 2
+		ends := fmt4 + 14
+		starts := ends + segCount*2 + 2
+		deltas := starts + segCount*2
+		ranges := deltas + segCount*2
+		for i := 0; i < segCount; i++ {
+			start, end := u16(starts+i*2), u16(ends+i*2)
+			delta, rangeOff := u16(deltas+i*2), u16(ranges+i*2)
+			for c := uint32(start); c <= uint32(end) && c != 0xFFFF; c++ {
+				var glyph uint16
+				if rangeOff == 0 {
+					glyph = uint16(c) + delta
+				} else {
+					addr := ranges + i*2 + int(rangeOff) + int(c-uint32(start))*2
+					if addr+2 > len(data) {
+						continue
+					}
+					if glyph = u16(addr); glyph != 0 {
+						glyph += delta
+					}
+				}
+				if glyph != 0 {
+					f.cmap[rune(c)] = glyph
+				}
+			}
+		}
+	default:
+		return nil, errors.New("font has no unicode cmap")
+	}
+	return f, nil
+}
+
+// Таблицы, которые остаются во встроенном подмножестве шрифта
+var ttfSubsetTables = []string{"OS/2", "cmap", "cvt ", "fpgm", "glyf", "head", "hhea", "hmtx", "loca", "maxp", "post", "prep"}
+
+// subset возвращает шрифт, в котором сохранены контуры только нужных глифов.
+// Номера глифов не меняются, поэтому CIDToGIDMap остаётся /Identity.
+func (f *ttfFont) subset(used map[uint16]rune) ([]byte, error) {
+	data := f.data
+	u16 := func(off int) uint16 { return binary.BigEndian.Uint16(data[off:]) }
+	u32 := func(off int) uint32 { return binary.BigEndian.Uint32(data[off:]) }
+	glyf, okG := f.tables["glyf"]
+	loca, okL := f.tables["loca"]
+	if !okG || !okL {
+		return nil, errors.New("font has no glyf/loca tables")
+	}
+	longLoca := u16(f.tables["head"][0]+50) == 1
+	numGlyphs := len(f.advances)
+	glyphRange := func(g int) (int, int) {
+		if longLoca {
+			return int(u32(loca[0] + g*4)), int(u32(loca[0] + g*4 + 4))
+		}
+		return int(u16(loca[0]+g*2)) * 2, int(u16(loca[0]+g*2+2)) * 2
+	}
+
+	// Составные глифы тянут за собой компоненты
+	keep := map[int]bool{0: true}
+	queue := []int{0}
+	for g := range used {
+		if int(g) < numGlyphs && !keep[int(g)] {
+			keep[int(g)] = true
+			queue = append(queue, int(g))
+		}
+	}
+	for len(queue) > 0 {
+		g := queue[0]
+		queue = queue[1:]
+		start, end := glyphRange(g)
+		if end-start < 10 || int16(u16(glyf[0]+start)) >= 0 {
+			continue
+		}
+		for p := glyf[0] + start + 10; p+4 <= len(data); {
+			flags, comp := u16(p), int(u16(p+2))
+			if comp < numGlyphs && !keep[comp] {
+				keep[comp] = true
+				queue = append(queue, comp)
+			}
+			p += 4
+			if flags&0x0001 != 0 {
+				p += 4
+			} else {
+				p += 2
+			}
+			switch {
+			case flags&0x0008 != 0:
+				p += 2
+			case flags&0x0040 != 0:
+				p += 4
+			case flags&0x0080 != 0:
+				p += 8
+			}
+			if flags&0x0020 == 0 {
+				break
+			}
+		}
+	}
+
+	var newGlyf bytes.Buffer
+	newLoca := make([]byte, (numGlyphs+1)*4)
+	for g := 0; g < numGlyphs; g++ {
+		binary.BigEndian.PutUint32(newLoca[g*4:], uint32(newGlyf.Len()))
+		if keep[g] {
+			start, end := glyphRange(g)
+			newGlyf.Write(data[glyf[0]+start : glyf[0]+end])
+			for newGlyf.Len()%4 != 0 {
+				newGlyf.WriteByte(0)
+			}
+		}
+	}
+	binary.BigEndian.PutUint32(newLoca[numGlyphs*4:], uint32(newGlyf.Len()))
+
+	tables := make(map[string][]byte)
+	for _, tag := range ttfSubsetTables {
+		t, ok := f.tables[tag]
+		if !ok {
+			continue
+		}
+		tables[tag] = data[t[0] : t[0]+t[1]]
+	}
+	tables["glyf"] = newGlyf.Bytes()
+	tables["loca"] = newLoca
+	head := append([]byte(nil), tables["head"]...)
+	binary.BigEndian.PutUint32(head[8:], 0)  // checkSumAdjustment
+	binary.BigEndian.PutUint16(head[50:], 1) // длинный формат loca
+	tables["head"] = head
+	if post, ok := tables["post"]; ok && len(post) >= 32 {
+		// post версии 3 - без имён глифов
+		post = append([]byte(nil), post[:32]...)
+		binary.BigEndian.PutUint32(post, 0x00030000)
+		tables["post"] = post
+	}
+
+	tags := sortedKeys(tables)
+	var out bytes.Buffer
+	binary.Write(&out, binary.BigEndian, uint32(0x00010000))
+	binary.Write(&out, binary.BigEndian, uint16(len(tags)))
+	binary.Write(&out, binary.BigEndian, [3]uint16{}) // searchRange и т.п. читателями не используются
+	offset := 12 + 16*len(tags)
+	for _, tag := range tags {
+		t := tables[tag]
+		out.WriteString(tag)
+		binary.Write(&out, binary.BigEndian, ttfChecksum(t))
+		binary.Write(&out, binary.BigEndian, uint32(offset))
+		binary.Write(&out, binary.BigEndian, uint32(len(t)))
+		offset += (len(t) + 3) &^ 3
+	}
+	for _, tag := range tags {
+		out.Write(tables[tag])
+		for out.Len()%4 != 0 {
+			out.WriteByte(0)
+		}
+	}
+	return out.Bytes(), nil
+}
+
+func ttfChecksum(t []byte) uint32 {
+	var sum uint32
+	for i := 0; i < len(t); i += 4 {
+		var w [4]byte
+		copy(w[:], t[i:])
+		sum += binary.BigEndian.Uint32(w[:])
+	}
+	return sum
+}
+
+func (f *ttfFont) glyph(r rune) uint16 {
+	return f.cmap[r]
+}
+
+// width - ширина строки в пунктах для кегля size
+func (f *ttfFont) width(s string, size float64) float64 {
+	var units float64
+	for _, r := range s {
+		g := f.glyph(r)
+		if int(g) < len(f.advances) {
+			units += float64(f.advances[g])
+		}
+	}
+	return units * size / f.unitsPerEm
+}
+
+func (f *ttfFont) scale(v int16) int {
+	return int(float64(v) * 1000 / f.unitsPerEm)
+}
+
+var (
+	pdfFontsOnce sync.Once
+	pdfFontsErr  error
+	pdfRegular   *ttfFont
+	pdfBold      *ttfFont
+)
+
+// loadPDFFonts читает шрифты из PDF_FONT_PATH / PDF_FONT_BOLD_PATH (по умолчанию DejaVu)
+func loadPDFFonts() (*ttfFont, *ttfFont, error) {
+	pdfFontsOnce.Do(func() {
+		load := func(path, name string) (*ttfFont, error) {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return nil, err
+			}
+			return parseTTF(name, data)
+		}
+		pdfRegular, pdfFontsErr = load(mustGetEnv("PDF_FONT_PATH", "/usr/share/fonts/truetype/dejavu/DejaVuSans.ttf"), "DejaVuSans")
+		if pdfFontsErr != nil {
+			return
+		}
+		var err error
+		pdfBold, err = load(mustGetEnv("PDF_FONT_BOLD_PATH", "/usr/share/fonts/truetype/dejavu/DejaVuSans-Bold.ttf"), "DejaVuSans-Bold")
+		if err != nil {
+			pdfBold = pdfRegular // без полужирного начертания документ всё равно читается
+		}
+	})
+	return pdfRegular, pdfBold, pdfFontsErr
+}
+
+// --- Документ ---
+
+type pdfFontUse struct {
+	font   *ttfFont
+	res    string          // имя ресурса /F1
+	glyphs map[uint16]rune // использованные глифы -> символ (для ToUnicode и /W)
+}
+
+type pdfDoc struct {
+	fonts []*pdfFontUse
+	pages []*bytes.Buffer
+	page  *bytes.Buffer
+	y     float64 // текущая позиция курсора от верхнего края
+	// footer вызывается для каждой страницы при сборке (номер, всего)
+	footer func(d *pdfDoc, page, total int)
+}
+
+func newPDFDoc(fonts ...*ttfFont) *pdfDoc {
+	d := &pdfDoc{}
+	for i, f := range fonts {
+		d.fonts = append(d.fonts, &pdfFontUse{font: f, res: fmt.Sprintf("F%d", i+1), glyphs: make(map[uint16]rune)})
+	}
+	d.AddPage()
+	return d
+}
+
+func (d *pdfDoc) AddPage() {
+	d.page = &bytes.Buffer{}
+	d.pages = append(d.pages, d.page)
+	d.y = pdfMargin
+}
+
+func pdfNum(v float64) string {
+	return strconv.FormatFloat(v, 'f', 2, 64)
+}
+
+// Text пишет строку; x, y - от левого верхнего угла, y - базовая линия
+func (d *pdfDoc) Text(font int, size, x, y float64, s string) {
+	fu := d.fonts[font]
+	var hex strings.Builder
+	for _, r := range s {
+		g := fu.font.glyph(r)
+		if _, seen := fu.glyphs[g]; !seen && g != 0 {
+			fu.glyphs[g] = r
+		}
+		fmt.Fprintf(&hex, "%04X", g)
+	}
+	fmt.Fprintf(d.page, "BT /%s %s Tf 1 0 0 1 %s %s Tm <%s> Tj ET\n",
+		fu.res, pdfNum(size), pdfNum(x), pdfNum(pdfPageHeight-y), hex.String())
+}
+
+func (d *pdfDoc) Line(x1, y1, x2, y2, width float64) {
+	fmt.Fprintf(d.page, "%s w %s %s m %s %s l S\n", pdfNum(width),
+		pdfNum(x1), pdfNum(pdfPageHeight-y1), pdfNum(x2), pdfNum(pdfPageHeight-y2))
+}
+
+func (d *pdfDoc) Rect(x, y, w, h, width float64) {
+	fmt.Fprintf(d.page, "%s w %s %s %s %s re S\n", pdfNum(width),
+		pdfNum(x), pdfNum(pdfPageHeight-y-h), pdfNum(w), pdfNum(h))
+}
+
+// FillRect закрашивает прямоугольник чёрным
+func (d *pdfDoc) FillRect(x, y, w, h float64) {
+	fmt.Fprintf(d.page, "%s %s %s %s re f\n", pdfNum(x), pdfNum(pdfPageHeight-y-h), pdfNum(w), pdfNum(h))
+}
+
+// Wrap разбивает текст на строки не шире width
+func (d *pdfDoc) Wrap(font int, size, width float64, s string) []string {
+	f := d.fonts[font].font
+	var lines []string
+	for _, para := range strings.Split(strings.ReplaceAll(s, "\r", ""), "\n") {
+		words := strings.Fields(para)
+		if len(words) == 0 {
+			lines = append(lines, "")
+			continue
+		}
+		line := ""
+		// Слово длиннее строки режем по символам
+		breakLong := func() {
+			for f.width(line, size) > width && utf8.RuneCountInString(line) > 1 {
+				cut := len(line)
+				for cut > 0 && f.width(line[:cut], size) > width {
+					_, n := utf8.DecodeLastRuneInString(line[:cut])
+					cut -= n
+				}
+				if cut == 0 {
+					return
+				}
+				lines = append(lines, line[:cut])
+				line = line[cut:]
+			}
+		}
+		for _, w := range words {
+			if line == "" {
+				line = w
+			} else if candidate := line + " " + w; f.width(candidate, size) <= width {
+				line = candidate
+				continue
+			} else {
+				lines = append(lines, line)
+				line = w
+			}
+			breakLong()
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// ensureSpace переносит курсор на новую страницу, если не хватает h пунктов
+func (d *pdfDoc) ensureSpace(h float64) {
+	if d.y+h > pdfPageHeight-pdfMargin {
+		d.AddPage()
+	}
+}
+
+// Paragraph выводит текст с переносами от текущей позиции курсора
+func (d *pdfDoc) Paragraph(font int, size, indent float64, s string) {
+	lead := size * 1.35
+	for _, line := range d.Wrap(font, size, pdfPageWidth-2*pdfMargin-indent, s) {
+		d.ensureSpace(lead)
+		d.y += lead
+		d.Text(font, size, pdfMargin+indent, d.y, line)
+	}
+}
+
+// Centered выводит строку по центру страницы
+func (d *pdfDoc) Centered(font int, size float64, s string) {
+	for _, line := range d.Wrap(font, size, pdfPageWidth-2*pdfMargin, s) {
+		lead := size * 1.35
+		d.ensureSpace(lead)
+		d.y += lead
+		w := d.fonts[font].font.width(line, size)
+		d.Text(font, size, (pdfPageWidth-w)/2, d.y, line)
+	}
+}
+
+func (d *pdfDoc) Space(h float64) {
+	d.y += h
+}
+
+// TableRow рисует строку таблицы от левого поля; высота - по самой длинной ячейке.
+// Если строка не помещается, начинается новая страница и вызывается onBreak (повтор шапки).
+func (d *pdfDoc) TableRow(font int, size float64, widths []float64, cells []string, onBreak func()) {
+	lead := size + 3
+	wrapped := make([][]string, len(cells))
+	lines := 1
+	for i, c := range cells {
+		wrapped[i] = d.Wrap(font, size, widths[i]-6, c)
+		lines = max(lines, len(wrapped[i]))
+	}
+	h := float64(lines)*lead + 5
+	if d.y+h > pdfPageHeight-pdfMargin {
+		d.AddPage()
+		if onBreak != nil {
+			onBreak()
+		}
+	}
+	x := pdfMargin
+	for i := range cells {
+		d.Rect(x, d.y, widths[i], h, 0.5)
+		for j, line := range wrapped[i] {
+			d.Text(font, size, x+3, d.y+size+2.5+float64(j)*lead, line)
+		}
+		x += widths[i]
+	}
+	d.y += h
+}
+
+// Bytes собирает файл PDF
+func (d *pdfDoc) Bytes() []byte {
+	if d.footer != nil {
+		for i, p := range d.pages {
+			d.page = p
+			d.footer(d, i+1, len(d.pages))
+		}
+	}
+
+	var out bytes.Buffer
+	var offsets []int
+	obj := func(body string) int {
+		offsets = append(offsets, out.Len())
+		n := len(offsets)
+		fmt.Fprintf(&out, "%d 0 obj\n%s\nendobj\n", n, body)
+		return n
+	}
+	stream := func(dict string, data []byte) int {
+		offsets = append(offsets, out.Len())
+		n := len(offsets)
+		fmt.Fprintf(&out, "%d 0 obj\n<< %s /Length %d >>\nstream\n", n, dict, len(data))
+		out.Write(data)
+		out.WriteString("\nendstream\nendobj\n")
+		return n
+	}
+	deflate := func(data []byte) []byte {
+		var buf bytes.Buffer
+		zw, _ := zlib.NewWriterLevel(&buf, zlib.BestCompression)
+		zw.Write(data)
+		zw.Close()
+		return buf.Bytes()
+	}
+
+	out.WriteString("%PDF-1.7\n%\xe2\xe3\xcf\xd3\n")
+
+	// Объекты 1 и 2 зарезервированы под каталог и дерево страниц
+	offsets = append(offsets, 0, 0)
+
+	var fontRefs []string
+	for _, fu := range d.fonts {
+		f := fu.font
+		gids := make([]int, 0, len(fu.glyphs))
+		for g := range fu.glyphs {
+			gids = append(gids, int(g))
+		}
+		sort.Ints(gids)
+
+		fontFile := f.data
+		if sub, err := f.subset(fu.glyphs); err == nil {
+			fontFile = sub
+		}
+		file := stream(fmt.Sprintf("/Filter /FlateDecode /Length1 %d", len(fontFile)), deflate(fontFile))
+		desc := obj(fmt.Sprintf("<< /Type /FontDescriptor /FontName /%s /Flags 32 /FontBBox [%d %d %d %d] /ItalicAngle 0 /Ascent %d /Descent %d /CapHeight %d /StemV 80 /FontFile2 %d 0 R >>",
+			f.name, f.scale(f.bbox[0]), f.scale(f.bbox[1]), f.scale(f.bbox[2]), f.scale(f.bbox[3]),
+			f.scale(f.ascent), f.scale(f.descent), f.scale(f.ascent), file))
+
+		var widths strings.Builder
+		for _, g := range gids {
+			fmt.Fprintf(&widths, "%d [%d] ", g, int(float64(f.advances[g])*1000/f.unitsPerEm))
+		}
+		cid := obj(fmt.Sprintf("<< /Type /Font /Subtype /CIDFontType2 /BaseFont /%s /CIDSystemInfo << /Registry (Adobe) /Ordering (Identity) /Supplement 0 >> /FontDescriptor %d 0 R /DW 1000 /W [%s] /CIDToGIDMap /Identity >>",
+			f.name, desc, strings.TrimSpace(widths.String())))
+
+		var cmap strings.Builder
+		cmap.WriteString("/CIDInit /ProcSet findresource begin\n12 dict begin\nbegincmap\n" +
+			"/CIDSystemInfo << /Registry (Adobe) /Ordering (UCS) /Supplement 0 >> def\n" +
+			"/CMapName /Adobe-Identity-UCS def\n/CMapType 2 def\n" +
+			"1 begincodespacerange\n<0000> <FFFF>\nendcodespacerange\n")
+		for i := 0; i < len(gids); i += 100 {
+			chunk := gids[i:min(i+100, len(gids))]
+			fmt.Fprintf(&cmap, "%d beginbfchar\n", len(chunk))
+			for _, g := range chunk {
+				r := fu.glyphs[uint16(g)]
+				var u strings.Builder
+				for _, c := range utf16Units(r) {
+					fmt.Fprintf(&u, "%04X", c)
+				}
+				fmt.Fprintf(&cmap, "<%04X> <%s>\n", g, u.String())
+			}
+			cmap.WriteString("endbfchar\n")
+		}
+		cmap.WriteString("endcmap\nCMapName currentdict /CMap defineresource pop\nend\nend\n")
+		toUnicode := stream("/Filter /FlateDecode", deflate([]byte(cmap.String())))
+
+		font := obj(fmt.Sprintf("<< /Type /Font /Subtype /Type0 /BaseFont /%s /Encoding /Identity-H /DescendantFonts [%d 0 R] /ToUnicode %d 0 R >>",
+			f.name, cid, toUnicode))
+		fontRefs = append(fontRefs, fmt.Sprintf("/%s %d 0 R", fu.res, font))
+	}
+	resources := obj(fmt.Sprintf("<< /Font << %s >> >>", strings.Join(fontRefs, " ")))
+
+	var kids []string
+	for _, p := range d.pages {
+		content := stream("/Filter /FlateDecode", deflate(p.Bytes()))
+		page := obj(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %s %s] /Resources %d 0 R /Contents %d 0 R >>",
+			pdfNum(pdfPageWidth), pdfNum(pdfPageHeight), resources, content))
+		kids = append(kids, fmt.Sprintf("%d 0 R", page))
+	}
+
+	offsets[0] = out.Len()
+	out.WriteString("1 0 obj\n<< /Type /Catalog /Pages 2 0 R >>\nendobj\n")
+	offsets[1] = out.Len()
+	fmt.Fprintf(&out, "2 0 obj\n<< /Type /Pages /Kids [%s] /Count %d >>\nendobj\n", strings.Join(kids, " "), len(kids))
+
+	id := sha256.Sum256(out.Bytes())
+	xref := out.Len()
+	fmt.Fprintf(&out, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(&out, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&out, "trailer\n<< /Size %d /Root 1 0 R /ID [<%X> <%X>] >>\nstartxref\n%d\n%%%%EOF\n",
+		len(offsets)+1, id[:16], id[:16], xref)
+	return out.Bytes()
+}
+
+func utf16Units(r rune) []uint16 {
+	if r < 0x10000 {
+		return []uint16{uint16(r)}
+	}
+	r -= 0x10000
+	return []uint16{uint16(0xD800 + (r >> 10)), uint16(0xDC00 + (r & 0x3FF))}
+}
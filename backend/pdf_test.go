@@ -0,0 +1,345 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// testFont собирает минимальный шрифт TrueType: по простому глифу на каждый символ,
+// глиф-надстрочник без символа и составной глиф «Й» = «И» + надстрочник.
+// Глифы по номерам: 0 - .notdef, затем символы в порядке runes, затем надстрочник и «Й».
+type testFont struct {
+	data   []byte
+	gids   map[rune]uint16
+	breve  uint16
+	glyphs [][]byte
+}
+
+func buildTestFont(t *testing.T, runes []rune) *testFont {
+	t.Helper()
+	be := binary.BigEndian
+	tf := &testFont{gids: map[rune]uint16{}}
+
+	simple := func(marker int) []byte {
+		g := make([]byte, 17)
+		be.PutUint16(g[0:], 1)              // один контур
+		be.PutUint16(g[6:], uint16(marker)) // xMax - метка глифа
+		be.PutUint16(g[8:], 700)
+		g[13] = 0x37 // одна точка, короткие положительные координаты
+		g[14], g[15] = byte(marker), 7
+		return g
+	}
+	tf.glyphs = append(tf.glyphs, simple(0))
+	for _, r := range runes {
+		tf.gids[r] = uint16(len(tf.glyphs))
+		tf.glyphs = append(tf.glyphs, simple(len(tf.glyphs)))
+	}
+	tf.breve = uint16(len(tf.glyphs))
+	tf.glyphs = append(tf.glyphs, simple(len(tf.glyphs)))
+	composite := make([]byte, 10, 26)
+	be.PutUint16(composite, 0xFFFF) // numberOfContours = -1
+	for i, part := range []struct{ flags, glyph uint16 }{{0x0021, tf.gids['И']}, {0x0001, tf.breve}} {
+		c := make([]byte, 8)
+		be.PutUint16(c, part.flags) // слова в аргументах; у первого - MORE_COMPONENTS
+		be.PutUint16(c[2:], part.glyph)
+		be.PutUint16(c[6:], uint16(i*100))
+		composite = append(composite, c...)
+	}
+	tf.gids['Й'] = uint16(len(tf.glyphs))
+	tf.glyphs = append(tf.glyphs, composite)
+	numGlyphs := len(tf.glyphs)
+
+	// loca в коротком формате: подмножество обязано перевести его в длинный
+	var glyf []byte
+	loca := make([]byte, (numGlyphs+1)*2)
+	for i, g := range tf.glyphs {
+		be.PutUint16(loca[i*2:], uint16(len(glyf)/2))
+		glyf = append(glyf, g...)
+		if len(glyf)%2 != 0 {
+			glyf = append(glyf, 0)
+		}
+	}
+	be.PutUint16(loca[numGlyphs*2:], uint16(len(glyf)/2))
+
+	head := make([]byte, 54)
+	be.PutUint32(head, 0x00010000)
+	be.PutUint16(head[18:], 1000)
+	for i, v := range []int16{-50, -200, 900, 800} {
+		be.PutUint16(head[36+i*2:], uint16(v))
+	}
+	hhea := make([]byte, 36)
+	be.PutUint16(hhea[4:], 800)
+	be.PutUint16(hhea[6:], uint16(0xFF38)) // -200
+	be.PutUint16(hhea[34:], uint16(numGlyphs))
+	maxp := make([]byte, 6)
+	be.PutUint32(maxp, 0x00005000)
+	be.PutUint16(maxp[4:], uint16(numGlyphs))
+	hmtx := make([]byte, numGlyphs*4)
+	for g := 0; g < numGlyphs; g++ {
+		be.PutUint16(hmtx[g*4:], uint16(400+g%5*50))
+	}
+	post := make([]byte, 40)
+	be.PutUint32(post, 0x00020000)
+
+	// cmap формата 4: по сегменту на символ и завершающий 0xFFFF
+	mapped := make([]rune, 0, len(tf.gids))
+	for r := range tf.gids {
+		mapped = append(mapped, r)
+	}
+	sort.Slice(mapped, func(i, j int) bool { return mapped[i] < mapped[j] })
+	segs := len(mapped) + 1
+	sub := make([]byte, 16+segs*8)
+	be.PutUint16(sub, 4)
+	be.PutUint16(sub[2:], uint16(len(sub)))
+	be.PutUint16(sub[6:], uint16(segs*2))
+	ends, starts, deltas := 14, 16+segs*2, 16+segs*4
+	for i := 0; i < segs; i++ {
+		c, delta := uint16(0xFFFF), uint16(1)
+		if i < len(mapped) {
+			c, delta = uint16(mapped[i]), tf.gids[mapped[i]]-uint16(mapped[i])
+		}
+		be.PutUint16(sub[ends+i*2:], c)
+		be.PutUint16(sub[starts+i*2:], c)
+		be.PutUint16(sub[deltas+i*2:], delta)
+	}
+	cmap := make([]byte, 12)
+	be.PutUint16(cmap[2:], 1)
+	be.PutUint16(cmap[4:], 3)
+	be.PutUint16(cmap[6:], 1)
+	be.PutUint32(cmap[8:], 12)
+	cmap = append(cmap, sub...)
+
+	tables := map[string][]byte{"cmap": cmap, "glyf": glyf, "head": head, "hhea": hhea, "hmtx": hmtx, "loca": loca, "maxp": maxp, "post": post}
+	tags := sortedKeys(tables)
+	var out bytes.Buffer
+	binary.Write(&out, be, uint32(0x00010000))
+	binary.Write(&out, be, uint16(len(tags)))
+	binary.Write(&out, be, [3]uint16{})
+	offset := 12 + 16*len(tags)
+	for _, tag := range tags {
+		out.WriteString(tag)
+		binary.Write(&out, be, ttfChecksum(tables[tag]))
+		binary.Write(&out, be, uint32(offset))
+		binary.Write(&out, be, uint32(len(tables[tag])))
+		offset += (len(tables[tag]) + 3) &^ 3
+	}
+	for _, tag := range tags {
+		out.Write(tables[tag])
+		for out.Len()%4 != 0 {
+			out.WriteByte(0)
+		}
+	}
+	tf.data = out.Bytes()
+	return tf
+}
+
+// testRunes - латиница, цифры, кириллица и типографские знаки из форм
+func testRunes() []rune {
+	var runes []rune
+	for r := rune(0x20); r < 0x7F; r++ {
+		runes = append(runes, r)
+	}
+	for r := 'А'; r <= 'я'; r++ {
+		if r != 'Й' {
+			runes = append(runes, r)
+		}
+	}
+	return append(runes, []rune("Ёё№«»–—·")...)
+}
+
+func testPDFFonts(t *testing.T) (*ttfFont, *ttfFont) {
+	t.Helper()
+	data := buildTestFont(t, testRunes()).data
+	regular, err := parseTTF("TestSans", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bold, err := parseTTF("TestSans-Bold", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return regular, bold
+}
+
+// subsetGlyph - контур глифа g из собранного подмножества (loca в длинном формате)
+func subsetGlyph(t *testing.T, font []byte, g int) []byte {
+	t.Helper()
+	f, err := parseTTF("subset", font)
+	if err != nil {
+		t.Fatalf("subset does not parse: %v", err)
+	}
+	be := binary.BigEndian
+	if be.Uint16(font[f.tables["head"][0]+50:]) != 1 {
+		t.Fatal("subset must use long loca")
+	}
+	loca, glyf := f.tables["loca"][0], f.tables["glyf"][0]
+	start, end := be.Uint32(font[loca+g*4:]), be.Uint32(font[loca+g*4+4:])
+	return font[glyf+int(start) : glyf+int(end)]
+}
+
+func TestTTFSubsetKeepsOnlyUsedGlyphs(t *testing.T) {
+	tf := buildTestFont(t, []rune("ABИZ"))
+	f, err := parseTTF("TestSans", tf.data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range "ABИZЙ" {
+		if f.glyph(r) != tf.gids[r] {
+			t.Errorf("cmap %q = %d, want %d", r, f.glyph(r), tf.gids[r])
+		}
+	}
+
+	// «Й» составной: вместе с ним остаются «И» и надстрочник
+	used := map[uint16]rune{tf.gids['B']: 'B', tf.gids['Й']: 'Й'}
+	sub, err := f.subset(used)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kept := map[uint16]bool{0: true, tf.gids['B']: true, tf.gids['Й']: true, tf.gids['И']: true, tf.breve: true}
+	for g := range tf.glyphs {
+		got := subsetGlyph(t, sub, g)
+		want := tf.glyphs[g]
+		if !kept[uint16(g)] {
+			want = nil
+		}
+		// Контуры выровнены по 4 байта
+		if !bytes.Equal(bytes.TrimRight(got, "\x00"), bytes.TrimRight(want, "\x00")) {
+			t.Errorf("glyph %d = %x, want %x", g, got, want)
+		}
+	}
+	if len(sub) >= len(tf.data) {
+		t.Errorf("subset is %d bytes, full font %d", len(sub), len(tf.data))
+	}
+
+	// Номера глифов и метрики не меняются - CIDToGIDMap остаётся /Identity
+	s, _ := parseTTF("subset", sub)
+	if s.glyph('Й') != tf.gids['Й'] || len(s.advances) != len(f.advances) || s.advances[tf.gids['B']] != f.advances[tf.gids['B']] {
+		t.Error("subset changed glyph ids or metrics")
+	}
+	numTables := int(binary.BigEndian.Uint16(sub[4:]))
+	for i := 0; i < numTables; i++ {
+		rec := sub[12+i*16:]
+		off, length := binary.BigEndian.Uint32(rec[8:]), binary.BigEndian.Uint32(rec[12:])
+		if got := ttfChecksum(sub[off : off+length]); got != binary.BigEndian.Uint32(rec[4:]) {
+			t.Errorf("table %s checksum %08x, recorded %08x", rec[:4], got, binary.BigEndian.Uint32(rec[4:]))
+		}
+	}
+}
+
+func testForm052Data() *form052Data {
+	return &form052Data{
+		CardID: 77, EpisodeID: 5, PatientUID: "emp-a", IIN: "850412300123", ClinicName: "Клиника №1",
+		ExamType: ExamTypePeriodic, ExamDate: "2026-03-02",
+		General: map[string]any{"fullName": "Иванов Иван Иванович", "gender": "male", "dob": "1985-04-12",
+			"address": "г. Караганда, ул. Ленина, 1", "workPlace": "ТОО «Карьер»", "position": "машинист экскаватора"},
+		Medical:    map[string]any{"allergies": "нет", "bloodType": "II+"},
+		Dispensary: []string{"I11.9 Гипертензивная болезнь"},
+		Spec: map[string]map[string]any{
+			"Терапевт":          {"complaints": "нет", "diagnosis": "I11.9", "recommendations": "Контроль АД", "date": "2026-03-02"},
+			"Окулист":           {"complaints": "снижение зрения", "diagnosis": "H52.1 Миопия", "date": "2026-03-02"},
+			"Невролог":          {"diagnosis": "здоров", "date": "2026-03-02"},
+			"Оториноларинголог": {"diagnosis": "здоров", "date": "2026-03-01"},
+			"Хирург":            {"diagnosis": "здоров", "date": "2026-03-01"},
+		},
+		Labs: map[string]map[string]any{
+			"Общий анализ крови": {"value": "Hb 140 г/л", "date": "2026-02-28"},
+			"Флюорография":       {"conclusion": "без патологии", "date": "2026-02-27"},
+		},
+		Final: map[string]any{"isFit": true, "healthGroup": "II", "diagnosis": "I11.9", "date": "2026-03-02",
+			"restrictions": strings.Repeat("без работы на высоте и в ночные смены; ", 20)},
+		Commission: []CommissionMember{{Name: "Петров П.П.", Specialty: "Терапевт", Chairman: true}, {Name: "Сидорова С.С.", Specialty: "Окулист"}},
+		SignedAt:   "2026-03-02T10:30:00Z",
+	}
+}
+
+// Одинаковые данные дают побайтно одинаковый файл: карты и акты хешируются при архивации
+func TestForm052RenderIsDeterministic(t *testing.T) {
+	regular, bold := testPDFFonts(t)
+	first := renderForm052(testForm052Data(), regular, bold)
+	if !bytes.HasPrefix(first, []byte("%PDF-1.7")) || !bytes.HasSuffix(first, []byte("%%EOF\n")) {
+		t.Fatalf("not a PDF: %q...", first[:min(len(first), 16)])
+	}
+	for _, banned := range []string{"CreationDate", "ModDate", "Producer"} {
+		if bytes.Contains(first, []byte(banned)) {
+			t.Errorf("PDF contains %s", banned)
+		}
+	}
+
+	// Карты заново: другой порядок обхода map, шрифты прочитаны заново
+	for i := 0; i < 10; i++ {
+		regular, bold := testPDFFonts(t)
+		if again := renderForm052(testForm052Data(), regular, bold); !bytes.Equal(again, first) {
+			t.Fatalf("render %d differs from the first one", i+2)
+		}
+	}
+
+	data := testForm052Data()
+	data.Final["healthGroup"] = "III"
+	if bytes.Equal(renderForm052(data, regular, bold), first) {
+		t.Error("different data rendered to the same file")
+	}
+}
+
+func TestReportRenderIsDeterministic(t *testing.T) {
+	build := func() *reportDoc {
+		d := &reportDoc{footer: "Заключительный акт № 1"}
+		d.Title("Заключительный акт")
+		d.Heading("Поименный список")
+		d.Text("Иванов Иван — годен")
+		d.Table([]float64{0.1, 0.5, 0.4}, []string{"№", "Ф.И.О.", "Рекомендации"}, [][]string{
+			{"1", "Иванов Иван", "Контроль АД"},
+			{"2", "Петров Пётр", strings.Repeat("диспансерное наблюдение ", 30)},
+		})
+		return d
+	}
+	regular, bold := testPDFFonts(t)
+	pdf, docx := build().PDF(regular, bold), build().DOCX()
+	for i := 0; i < 5; i++ {
+		if !bytes.Equal(build().PDF(regular, bold), pdf) {
+			t.Fatal("report PDF differs between renders")
+		}
+		if !bytes.Equal(build().DOCX(), docx) {
+			t.Fatal("report DOCX differs between renders")
+		}
+	}
+}
+
+// Во встроенный шрифт попадают только глифы текста документа
+func TestPDFEmbedsFontSubset(t *testing.T) {
+	regular, bold := testPDFFonts(t)
+	d := newPDFDoc(regular, bold)
+	d.Text(fontRegular, 10, pdfMargin, 100, "Год")
+	d.Text(fontBold, 10, pdfMargin, 120, "Й")
+	d.Bytes()
+
+	want := map[int]map[uint16]rune{
+		fontRegular: {regular.glyph('Г'): 'Г', regular.glyph('о'): 'о', regular.glyph('д'): 'д'},
+		fontBold:    {bold.glyph('Й'): 'Й'},
+	}
+	for font, glyphs := range want {
+		fu := d.fonts[font]
+		if len(fu.glyphs) != len(glyphs) {
+			t.Errorf("font %d uses %v, want %v", font, fu.glyphs, glyphs)
+		}
+		for g, r := range glyphs {
+			if fu.glyphs[g] != r {
+				t.Errorf("font %d glyph %d = %q, want %q", font, g, fu.glyphs[g], r)
+			}
+		}
+		sub, err := fu.font.subset(fu.glyphs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := subsetGlyph(t, sub, int(regular.glyph('А'))); len(got) != 0 {
+			t.Errorf("font %d: unused glyph А embedded", font)
+		}
+		for g := range glyphs {
+			if len(subsetGlyph(t, sub, int(g))) == 0 {
+				t.Errorf("font %d: used glyph %d dropped", font, g)
+			}
+		}
+	}
+}
@@ -0,0 +1,555 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// --- Присутствие в амбулаторной карте и блокировки разделов ---
+//
+// Врач, открывший карту, шлёт по WebSocket {"type":"presence","card":"<patientUid>",
+// "visitId":12,"state":"viewing|editing","section":"spec:Терапевт"} не реже раза в
+// presenceTTL/2, и коллеги, подписанные на тему card:{patientUid}, получают
+// card_presence: кто смотрит карту, кто что правит и какие разделы заняты.
+// {"type":"lock","card":...,"section":...} занимает раздел на lockTTL; блокировка
+// рекомендательная, но сохранение раздела, занятого другим пользователем, отклоняется
+// с 423. Блокировки продлеваются тем же presence и истекают сами, если вкладку закрыли.
+// Данные в Postgres, поэтому присутствие и блокировки видны со всех реплик.
+//
+// Разделы: general, medical, labs и spec:{специальность} - записи специалистов.
+
+const (
+	presenceTTL  = 90 * time.Second
+	lockTTL      = 2 * time.Minute
+	presenceTick = 30 * time.Second
+
+	PresenceViewing = "viewing"
+	PresenceEditing = "editing"
+)
+
+var errSectionLocked = errors.New("section is being edited by another user")
+
+// cardPresenceStore - хранилище присутствия для проверки блокировок при сохранении; nil - проверка отключена
+var cardPresenceStore presenceStore
+
+func cardTopic(patientUID string) string { return "card:" + patientUID }
+
+// presenceEntry - пользователь, открывший карту
+type presenceEntry struct {
+	PatientUID string `json:"-"`
+	ClientID   string `json:"-"`
+	UserID     string `json:"userId"`
+	UserName   string `json:"userName,omitempty"`
+	Specialty  string `json:"specialty,omitempty"`
+	VisitID    *int64 `json:"visitId,omitempty"`
+	State      string `json:"state"`
+	Section    string `json:"section,omitempty"`
+	Since      string `json:"since"`
+}
+
+// sectionLock - раздел карты, который правит пользователь
+type sectionLock struct {
+	PatientUID string `json:"-"`
+	ClientID   string `json:"-"`
+	Section    string `json:"section"`
+	UserID     string `json:"userId"`
+	UserName   string `json:"userName,omitempty"`
+	AcquiredAt string `json:"acquiredAt"`
+	ExpiresAt  string `json:"expiresAt"`
+}
+
+// cardPresence - данные события card_presence
+type cardPresence struct {
+	PatientUID string          `json:"patientUid"`
+	Viewers    []presenceEntry `json:"viewers"`
+	Locks      []sectionLock   `json:"locks"`
+}
+
+// presenceStore - присутствие и блокировки; в Postgres, чтобы их видели все реплики
+type presenceStore interface {
+	// Touch отмечает присутствие и продлевает блокировки этого подключения в карте;
+	// changed - изменилось то, что видят коллеги (новый участник, состояние, раздел)
+	Touch(ctx context.Context, e presenceEntry) (changed bool, err error)
+	// Leave убирает присутствие подключения в карте и снимает его блокировки
+	Leave(ctx context.Context, clientID, patientUID string) error
+	// Disconnect убирает присутствие подключения во всех картах; блокировки истекут сами.
+	// Возвращает затронутые карты.
+	Disconnect(ctx context.Context, clientID string) ([]string, error)
+	// Lock занимает раздел. Если раздел занят другим пользователем - errSectionLocked и текущая блокировка.
+	Lock(ctx context.Context, l sectionLock) (*sectionLock, error)
+	Unlock(ctx context.Context, patientUID, section, userID string) (bool, error)
+	Snapshot(ctx context.Context, patientUID string) (*cardPresence, error)
+	// Sweep удаляет истёкшие записи и возвращает затронутые карты
+	Sweep(ctx context.Context) ([]string, error)
+}
+
+func userDisplayName(u *User) string {
+	if u.LeaderName != nil && *u.LeaderName != "" {
+		return *u.LeaderName
+	}
+	if u.CompanyName != nil {
+		return *u.CompanyName
+	}
+	return ""
+}
+
+func validSection(s string) bool {
+	switch s {
+	case "general", "medical", "labs":
+		return true
+	}
+	return strings.HasPrefix(s, "spec:") && len(s) > len("spec:") && len(s) <= 128
+}
+
+// publishCardPresence рассылает состояние карты подписчикам карты и визитов, к которым относится присутствие
+func publishCardPresence(ctx context.Context, store presenceStore, patientUID string) {
+	snap, err := store.Snapshot(ctx, patientUID)
+	if err != nil {
+		log.Printf("card presence snapshot %s: %v", patientUID, err)
+		return
+	}
+	topics := []string{cardTopic(patientUID)}
+	seen := map[int64]bool{}
+	for _, v := range snap.Viewers {
+		if v.VisitID != nil && !seen[*v.VisitID] {
+			seen[*v.VisitID] = true
+			topics = append(topics, visitTopic(*v.VisitID))
+		}
+	}
+	publishToTopics(topics, "card_presence", snap)
+}
+
+// handlePresenceFrame обрабатывает presence, leave, lock и unlock
+func (c *Client) handlePresenceFrame(ctx context.Context, f clientFrame) {
+	if c.presence == nil {
+		c.replyError(f, wsErrUnknownType, "presence is not available on this connection")
+		return
+	}
+	if f.Card == "" {
+		c.replyError(f, wsErrBadFrame, "card is required")
+		return
+	}
+	topic := cardTopic(f.Card)
+	if err := authorizeTopic(ctx, c.dir, c.User, topic); err != nil {
+		if errors.Is(err, errTopicDenied) || errors.Is(err, errTopicInvalid) {
+			c.replyError(f, wsErrForbidden, errTopicDenied.Error())
+		} else {
+			log.Printf("WebSocket %s %s for %s: %v", f.Type, f.Card, c.UserID, err)
+			c.replyError(f, wsErrInternal, "internal error")
+		}
+		return
+	}
+	internal := func(err error) {
+		log.Printf("WebSocket %s %s for %s: %v", f.Type, f.Card, c.UserID, err)
+		c.replyError(f, wsErrInternal, "internal error")
+	}
+
+	switch f.Type {
+	case framePresence:
+		state := f.State
+		if state == "" {
+			state = PresenceViewing
+		}
+		if (state != PresenceViewing && state != PresenceEditing) || (f.Section != "" && !validSection(f.Section)) {
+			c.replyError(f, wsErrBadFrame, "state must be viewing or editing, section general, medical, labs or spec:{name}")
+			return
+		}
+		// Присутствие подписывает на события карты
+		if err := c.Hub.Subscribe(c, topic); err != nil {
+			if errors.Is(err, errTooManyTopics) {
+				c.replyError(f, wsErrTooManyTopics, err.Error())
+			}
+			return
+		}
+		e := presenceEntry{PatientUID: f.Card, ClientID: c.ID, UserID: c.UserID, UserName: userDisplayName(c.User),
+			State: state, Section: f.Section}
+		if c.User.Specialty != nil {
+			e.Specialty = *c.User.Specialty
+		}
+		if f.VisitID > 0 {
+			e.VisitID = &f.VisitID
+		}
+		changed, err := c.presence.Touch(ctx, e)
+		if err != nil {
+			internal(err)
+			return
+		}
+		if changed {
+			publishCardPresence(ctx, c.presence, f.Card)
+		}
+		snap, err := c.presence.Snapshot(ctx, f.Card)
+		if err != nil {
+			internal(err)
+			return
+		}
+		c.reply(f, "card_presence", map[string]any{"patientUid": snap.PatientUID, "viewers": snap.Viewers, "locks": snap.Locks})
+
+	case frameLeave:
+		if err := c.presence.Leave(ctx, c.ID, f.Card); err != nil {
+			internal(err)
+			return
+		}
+		c.Hub.Unsubscribe(c, topic)
+		c.reply(f, "left", map[string]any{"card": f.Card})
+		publishCardPresence(ctx, c.presence, f.Card)
+
+	case frameLock:
+		if !validSection(f.Section) {
+			c.replyError(f, wsErrBadFrame, "section must be general, medical, labs or spec:{name}")
+			return
+		}
+		l, err := c.presence.Lock(ctx, sectionLock{PatientUID: f.Card, ClientID: c.ID, Section: f.Section,
+			UserID: c.UserID, UserName: userDisplayName(c.User)})
+		if errors.Is(err, errSectionLocked) {
+			c.reply(f, "error", map[string]any{"code": wsErrSectionLocked, "message": err.Error(), "requestType": f.Type, "lock": l})
+			return
+		}
+		if err != nil {
+			internal(err)
+			return
+		}
+		c.reply(f, "locked", map[string]any{"card": f.Card, "lock": l})
+		publishCardPresence(ctx, c.presence, f.Card)
+
+	case frameUnlock:
+		released, err := c.presence.Unlock(ctx, f.Card, f.Section, c.UserID)
+		if err != nil {
+			internal(err)
+			return
+		}
+		c.reply(f, "unlocked", map[string]any{"card": f.Card, "section": f.Section})
+		if released {
+			publishCardPresence(ctx, c.presence, f.Card)
+		}
+	}
+}
+
+// leavePresence - подключение закрыто: коллеги больше не видят пользователя в картах
+func (c *Client) leavePresence() {
+	if c.presence == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	cards, err := c.presence.Disconnect(ctx, c.ID)
+	if err != nil {
+		log.Printf("presence disconnect %s: %v", c.ID, err)
+		return
+	}
+	for _, card := range cards {
+		publishCardPresence(ctx, c.presence, card)
+	}
+}
+
+// startPresenceSweeper убирает истёкшие присутствие и блокировки и сообщает об этом коллегам
+func startPresenceSweeper(store presenceStore) {
+	go func() {
+		ticker := time.NewTicker(presenceTick)
+		defer ticker.Stop()
+		for range ticker.C {
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			cards, err := store.Sweep(ctx)
+			if err != nil {
+				log.Printf("presence sweep: %v", err)
+			}
+			for _, card := range cards {
+				publishCardPresence(ctx, store, card)
+			}
+			cancel()
+		}
+	}()
+}
+
+// --- Проверка блокировок при сохранении ---
+
+// changedSpecSections - специальности, записи которых отличаются (добавлены, изменены, удалены)
+func changedSpecSections(old, new json.RawMessage) []string {
+	var before, after map[string]any
+	json.Unmarshal(old, &before)
+	json.Unmarshal(new, &after)
+	var out []string
+	for key, v := range after {
+		if !reflect.DeepEqual(before[key], v) {
+			out = append(out, "spec:"+key)
+		}
+	}
+	for key := range before {
+		if _, ok := after[key]; !ok {
+			out = append(out, "spec:"+key)
+		}
+	}
+	return out
+}
+
+// lockConflict - блокировка другого пользователя на одном из изменяемых разделов
+func lockConflict(snap *cardPresence, userID string, sections []string) *sectionLock {
+	for i := range snap.Locks {
+		l := &snap.Locks[i]
+		if l.UserID == userID {
+			continue
+		}
+		for _, s := range sections {
+			if l.Section == s {
+				return l
+			}
+		}
+	}
+	return nil
+}
+
+// checkSectionLocks отвечает 423, если изменяемый раздел занят другим пользователем
+func checkSectionLocks(ctx context.Context, w http.ResponseWriter, r *http.Request, store presenceStore, patientUID string, sections []string) bool {
+	if len(sections) == 0 {
+		return true
+	}
+	snap, err := store.Snapshot(ctx, patientUID)
+	if err != nil {
+		log.Printf("checkSectionLocks %s: %v", patientUID, err)
+		errorResponse(w, http.StatusInternalServerError, "db error")
+		return false
+	}
+	if l := lockConflict(snap, requestUserID(r), sections); l != nil {
+		jsonResponse(w, http.StatusLocked, map[string]any{"error": errSectionLocked.Error(), "section": l.Section, "lock": l})
+		return false
+	}
+	return true
+}
+
+// cardSectionChanges - разделы, которые изменит сохранение карты и записей эпизода
+func cardSectionChanges(ctx context.Context, patientUID string, episodeID int64, general, medical, spec, labs json.RawMessage) ([]string, error) {
+	var sections []string
+	var generalSame, medicalSame bool
+	err := db.QueryRow(ctx, `
+SELECT general IS NOT DISTINCT FROM $2::jsonb, medical IS NOT DISTINCT FROM $3::jsonb
+FROM ambulatory_cards WHERE patient_uid = $1
+`, patientUID, general, medical).Scan(&generalSame, &medicalSame)
+	if err != nil && err != pgx.ErrNoRows {
+		return nil, err
+	}
+	if general != nil && !generalSame {
+		sections = append(sections, "general")
+	}
+	if medical != nil && !medicalSame {
+		sections = append(sections, "medical")
+	}
+	episodeSections, err := episodeSectionChanges(ctx, episodeID, spec, labs)
+	return append(sections, episodeSections...), err
+}
+
+func episodeSectionChanges(ctx context.Context, episodeID int64, spec, labs json.RawMessage) ([]string, error) {
+	if spec == nil && labs == nil {
+		return nil, nil
+	}
+	var oldSpec json.RawMessage
+	var labsSame bool
+	err := db.QueryRow(ctx, `
+SELECT specialist_entries, lab_results IS NOT DISTINCT FROM $2::jsonb FROM exam_episodes WHERE id = $1
+`, episodeID, labs).Scan(&oldSpec, &labsSame)
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var sections []string
+	if spec != nil {
+		sections = changedSpecSections(oldSpec, spec)
+	}
+	if labs != nil && !labsSame {
+		sections = append(sections, "labs")
+	}
+	return sections, nil
+}
+
+// --- Хранилище в Postgres ---
+
+func migratePresence(ctx context.Context, tx pgx.Tx) error {
+	_, err := tx.Exec(ctx, `
+CREATE TABLE IF NOT EXISTS card_presence (
+  client_id   TEXT NOT NULL,
+  patient_uid TEXT NOT NULL,
+  user_id     TEXT NOT NULL,
+  user_name   TEXT NOT NULL DEFAULT '',
+  specialty   TEXT NOT NULL DEFAULT '',
+  visit_id    BIGINT,
+  state       TEXT NOT NULL CHECK (state IN ('viewing', 'editing')),
+  section     TEXT NOT NULL DEFAULT '',
+  since       TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  expires_at  TIMESTAMPTZ NOT NULL,
+  PRIMARY KEY (client_id, patient_uid)
+);`)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(ctx, `CREATE INDEX IF NOT EXISTS idx_card_presence_patient ON card_presence(patient_uid);`)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(ctx, `
+CREATE TABLE IF NOT EXISTS card_section_locks (
+  patient_uid TEXT NOT NULL,
+  section     TEXT NOT NULL,
+  user_id     TEXT NOT NULL,
+  user_name   TEXT NOT NULL DEFAULT '',
+  client_id   TEXT NOT NULL,
+  acquired_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  expires_at  TIMESTAMPTZ NOT NULL,
+  PRIMARY KEY (patient_uid, section)
+);`)
+	return err
+}
+
+type pgPresenceStore struct{}
+
+func pgInterval(d time.Duration) string {
+	return fmt.Sprintf("%d milliseconds", d.Milliseconds())
+}
+
+func (pgPresenceStore) Touch(ctx context.Context, e presenceEntry) (bool, error) {
+	var changed bool
+	err := db.QueryRow(ctx, `
+WITH prev AS (
+  SELECT state, section, visit_id FROM card_presence WHERE client_id = $1 AND patient_uid = $2 AND expires_at > NOW()
+), renewed AS (
+  UPDATE card_section_locks SET expires_at = NOW() + $9::interval
+  WHERE client_id = $1 AND patient_uid = $2 AND expires_at > NOW()
+)
+INSERT INTO card_presence (client_id, patient_uid, user_id, user_name, specialty, visit_id, state, section, expires_at)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, NOW() + $10::interval)
+ON CONFLICT (client_id, patient_uid) DO UPDATE SET
+  visit_id = EXCLUDED.visit_id, state = EXCLUDED.state, section = EXCLUDED.section,
+  since = CASE WHEN card_presence.expires_at > NOW() THEN card_presence.since ELSE NOW() END,
+  expires_at = EXCLUDED.expires_at
+RETURNING NOT EXISTS (
+  SELECT 1 FROM prev WHERE state = $7 AND section = $8 AND visit_id IS NOT DISTINCT FROM $6
+)
+`, e.ClientID, e.PatientUID, e.UserID, e.UserName, e.Specialty, e.VisitID, e.State, e.Section,
+		pgInterval(lockTTL), pgInterval(presenceTTL)).Scan(&changed)
+	return changed, err
+}
+
+func (pgPresenceStore) Leave(ctx context.Context, clientID, patientUID string) error {
+	_, err := db.Exec(ctx, `
+WITH locks AS (DELETE FROM card_section_locks WHERE client_id = $1 AND patient_uid = $2)
+DELETE FROM card_presence WHERE client_id = $1 AND patient_uid = $2
+`, clientID, patientUID)
+	return err
+}
+
+func (pgPresenceStore) Disconnect(ctx context.Context, clientID string) ([]string, error) {
+	rows, err := db.Query(ctx, `DELETE FROM card_presence WHERE client_id = $1 RETURNING patient_uid`, clientID)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, pgx.RowTo[string])
+}
+
+func (pgPresenceStore) Lock(ctx context.Context, l sectionLock) (*sectionLock, error) {
+	var acquired, expires time.Time
+	err := db.QueryRow(ctx, `
+INSERT INTO card_section_locks (patient_uid, section, user_id, user_name, client_id, expires_at)
+VALUES ($1, $2, $3, $4, $5, NOW() + $6::interval)
+ON CONFLICT (patient_uid, section) DO UPDATE SET
+  user_id = EXCLUDED.user_id, user_name = EXCLUDED.user_name, client_id = EXCLUDED.client_id,
+  acquired_at = CASE WHEN card_section_locks.user_id = EXCLUDED.user_id AND card_section_locks.expires_at > NOW()
+                     THEN card_section_locks.acquired_at ELSE NOW() END,
+  expires_at = EXCLUDED.expires_at
+WHERE card_section_locks.user_id = EXCLUDED.user_id OR card_section_locks.expires_at <= NOW()
+RETURNING acquired_at, expires_at
+`, l.PatientUID, l.Section, l.UserID, l.UserName, l.ClientID, pgInterval(lockTTL)).Scan(&acquired, &expires)
+	if err == pgx.ErrNoRows {
+		// Раздел занят другим пользователем
+		holder := sectionLock{PatientUID: l.PatientUID, Section: l.Section}
+		err = db.QueryRow(ctx, `
+SELECT user_id, user_name, acquired_at, expires_at FROM card_section_locks WHERE patient_uid = $1 AND section = $2
+`, l.PatientUID, l.Section).Scan(&holder.UserID, &holder.UserName, &acquired, &expires)
+		if err != nil {
+			return nil, err
+		}
+		holder.AcquiredAt = acquired.Format(time.RFC3339)
+		holder.ExpiresAt = expires.Format(time.RFC3339)
+		return &holder, errSectionLocked
+	}
+	if err != nil {
+		return nil, err
+	}
+	l.AcquiredAt = acquired.Format(time.RFC3339)
+	l.ExpiresAt = expires.Format(time.RFC3339)
+	return &l, nil
+}
+
+func (pgPresenceStore) Unlock(ctx context.Context, patientUID, section, userID string) (bool, error) {
+	tag, err := db.Exec(ctx, `DELETE FROM card_section_locks WHERE patient_uid = $1 AND section = $2 AND user_id = $3`,
+		patientUID, section, userID)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+func (pgPresenceStore) Snapshot(ctx context.Context, patientUID string) (*cardPresence, error) {
+	snap := &cardPresence{PatientUID: patientUID, Viewers: []presenceEntry{}, Locks: []sectionLock{}}
+	// Одна запись на пользователя, даже если карта открыта в нескольких вкладках; правка важнее просмотра
+	rows, err := db.Query(ctx, `
+SELECT DISTINCT ON (user_id) user_id, user_name, specialty, visit_id, state, section, since
+FROM card_presence WHERE patient_uid = $1 AND expires_at > NOW()
+ORDER BY user_id, (state = 'editing') DESC, since
+`, patientUID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var e presenceEntry
+		var since time.Time
+		if err := rows.Scan(&e.UserID, &e.UserName, &e.Specialty, &e.VisitID, &e.State, &e.Section, &since); err != nil {
+			return nil, err
+		}
+		e.Since = since.Format(time.RFC3339)
+		snap.Viewers = append(snap.Viewers, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = db.Query(ctx, `
+SELECT section, user_id, user_name, acquired_at, expires_at
+FROM card_section_locks WHERE patient_uid = $1 AND expires_at > NOW() ORDER BY section
+`, patientUID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		l := sectionLock{PatientUID: patientUID}
+		var acquired, expires time.Time
+		if err := rows.Scan(&l.Section, &l.UserID, &l.UserName, &acquired, &expires); err != nil {
+			return nil, err
+		}
+		l.AcquiredAt = acquired.Format(time.RFC3339)
+		l.ExpiresAt = expires.Format(time.RFC3339)
+		snap.Locks = append(snap.Locks, l)
+	}
+	return snap, rows.Err()
+}
+
+func (pgPresenceStore) Sweep(ctx context.Context) ([]string, error) {
+	rows, err := db.Query(ctx, `
+WITH p AS (DELETE FROM card_presence WHERE expires_at <= NOW() RETURNING patient_uid),
+     l AS (DELETE FROM card_section_locks WHERE expires_at <= NOW() RETURNING patient_uid)
+SELECT patient_uid FROM p UNION SELECT patient_uid FROM l
+`)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, pgx.RowTo[string])
+}
@@ -0,0 +1,332 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memPresenceStore - присутствие и блокировки в памяти с той же семантикой, что и в Postgres;
+// время задаётся вручную, чтобы проверять истечение
+type memPresenceStore struct {
+	mu       sync.Mutex
+	now      time.Time
+	presence map[[2]string]memPresence // клиент, карта
+	locks    map[[2]string]memLock     // карта, раздел
+}
+
+type memPresence struct {
+	presenceEntry
+	since, expires time.Time
+}
+
+type memLock struct {
+	sectionLock
+	acquired, expires time.Time
+}
+
+func newMemPresenceStore() *memPresenceStore {
+	return &memPresenceStore{now: time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC),
+		presence: map[[2]string]memPresence{}, locks: map[[2]string]memLock{}}
+}
+
+func (s *memPresenceStore) advance(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.now = s.now.Add(d)
+}
+
+func (s *memPresenceStore) Touch(ctx context.Context, e presenceEntry) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := [2]string{e.ClientID, e.PatientUID}
+	prev, ok := s.presence[key]
+	live := ok && prev.expires.After(s.now)
+	changed := !live || prev.State != e.State || prev.Section != e.Section ||
+		(prev.VisitID == nil) != (e.VisitID == nil) || (prev.VisitID != nil && *prev.VisitID != *e.VisitID)
+	since := s.now
+	if live {
+		since = prev.since
+	}
+	s.presence[key] = memPresence{presenceEntry: e, since: since, expires: s.now.Add(presenceTTL)}
+	for k, l := range s.locks {
+		if l.ClientID == e.ClientID && l.PatientUID == e.PatientUID && l.expires.After(s.now) {
+			l.expires = s.now.Add(lockTTL)
+			s.locks[k] = l
+		}
+	}
+	return changed, nil
+}
+
+func (s *memPresenceStore) Leave(ctx context.Context, clientID, patientUID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.presence, [2]string{clientID, patientUID})
+	for k, l := range s.locks {
+		if l.ClientID == clientID && l.PatientUID == patientUID {
+			delete(s.locks, k)
+		}
+	}
+	return nil
+}
+
+func (s *memPresenceStore) Disconnect(ctx context.Context, clientID string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var cards []string
+	for k := range s.presence {
+		if k[0] == clientID {
+			cards = append(cards, k[1])
+			delete(s.presence, k)
+		}
+	}
+	return cards, nil
+}
+
+func (s *memPresenceStore) Lock(ctx context.Context, l sectionLock) (*sectionLock, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := [2]string{l.PatientUID, l.Section}
+	cur, ok := s.locks[key]
+	live := ok && cur.expires.After(s.now)
+	if live && cur.UserID != l.UserID {
+		holder := cur.sectionLock
+		return &holder, errSectionLocked
+	}
+	acquired := s.now
+	if live {
+		acquired = cur.acquired
+	}
+	l.AcquiredAt = acquired.Format(time.RFC3339)
+	l.ExpiresAt = s.now.Add(lockTTL).Format(time.RFC3339)
+	s.locks[key] = memLock{sectionLock: l, acquired: acquired, expires: s.now.Add(lockTTL)}
+	return &l, nil
+}
+
+func (s *memPresenceStore) Unlock(ctx context.Context, patientUID, section, userID string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := [2]string{patientUID, section}
+	if l, ok := s.locks[key]; ok && l.UserID == userID {
+		delete(s.locks, key)
+		return true, nil
+	}
+	return false, nil
+}
+
+func (s *memPresenceStore) Snapshot(ctx context.Context, patientUID string) (*cardPresence, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	snap := &cardPresence{PatientUID: patientUID, Viewers: []presenceEntry{}, Locks: []sectionLock{}}
+	byUser := map[string]memPresence{}
+	for k, p := range s.presence {
+		if k[1] != patientUID || !p.expires.After(s.now) {
+			continue
+		}
+		// Одна запись на пользователя; правка важнее просмотра
+		if cur, ok := byUser[p.UserID]; !ok || (p.State == PresenceEditing && cur.State != PresenceEditing) {
+			byUser[p.UserID] = p
+		}
+	}
+	for _, p := range byUser {
+		e := p.presenceEntry
+		e.Since = p.since.Format(time.RFC3339)
+		snap.Viewers = append(snap.Viewers, e)
+	}
+	sort.Slice(snap.Viewers, func(i, j int) bool { return snap.Viewers[i].UserID < snap.Viewers[j].UserID })
+	for k, l := range s.locks {
+		if k[0] == patientUID && l.expires.After(s.now) {
+			snap.Locks = append(snap.Locks, l.sectionLock)
+		}
+	}
+	sort.Slice(snap.Locks, func(i, j int) bool { return snap.Locks[i].Section < snap.Locks[j].Section })
+	return snap, nil
+}
+
+func (s *memPresenceStore) Sweep(ctx context.Context) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cards := map[string]bool{}
+	for k, p := range s.presence {
+		if !p.expires.After(s.now) {
+			cards[k[1]] = true
+			delete(s.presence, k)
+		}
+	}
+	for k, l := range s.locks {
+		if !l.expires.After(s.now) {
+			cards[k[0]] = true
+			delete(s.locks, k)
+		}
+	}
+	var out []string
+	for c := range cards {
+		out = append(out, c)
+	}
+	return out, nil
+}
+
+// newPresenceHub - события присутствия публикуются через глобальный хаб
+func newPresenceHub(t *testing.T) *Hub {
+	t.Helper()
+	prev := hub
+	hub = NewHub()
+	t.Cleanup(func() { hub = prev })
+	return hub
+}
+
+func connectPresenceUser(h *Hub, store presenceStore, u *User) *Client {
+	c := connectTestUser(h, u)
+	c.presence = store
+	return c
+}
+
+func frame(c *Client, v clientFrame) []WebSocketMessage {
+	raw, _ := json.Marshal(v)
+	c.handleFrame(raw)
+	return received(c)
+}
+
+func presenceData(t *testing.T, msgs []WebSocketMessage) *cardPresence {
+	t.Helper()
+	for i := len(msgs) - 1; i >= 0; i-- {
+		if msgs[i].Type != "card_presence" {
+			continue
+		}
+		switch d := msgs[i].Data.(type) {
+		case *cardPresence:
+			return d
+		case map[string]any:
+			return &cardPresence{PatientUID: d["patientUid"].(string), Viewers: d["viewers"].([]presenceEntry), Locks: d["locks"].([]sectionLock)}
+		}
+	}
+	t.Fatalf("no card_presence in %v", msgs)
+	return nil
+}
+
+func TestPresenceIsSharedWithClinicColleagues(t *testing.T) {
+	store := newMemPresenceStore()
+	h := newPresenceHub(t)
+	colleague := connectPresenceUser(h, store, regA)
+	frame(colleague, clientFrame{Type: "presence", Card: "emp-a"})
+
+	doctor := connectPresenceUser(h, store, doctorA)
+	reply := frame(doctor, clientFrame{ID: "p-1", Type: "presence", Card: "emp-a", VisitID: 1, State: "editing", Section: "spec:Терапевт"})
+	if snap := presenceData(t, reply); len(snap.Viewers) != 2 {
+		t.Fatalf("doctor sees %+v, want both viewers", snap.Viewers)
+	}
+
+	snap := presenceData(t, received(colleague))
+	var editing *presenceEntry
+	for i := range snap.Viewers {
+		if snap.Viewers[i].UserID == doctorA.ID {
+			editing = &snap.Viewers[i]
+		}
+	}
+	if editing == nil || editing.State != PresenceEditing || editing.Section != "spec:Терапевт" || *editing.VisitID != 1 {
+		t.Fatalf("colleague sees %+v", snap.Viewers)
+	}
+
+	// Повтор того же присутствия коллегам не рассылается
+	frame(doctor, clientFrame{Type: "presence", Card: "emp-a", VisitID: 1, State: "editing", Section: "spec:Терапевт"})
+	if msgs := received(colleague); len(msgs) != 0 {
+		t.Errorf("unchanged presence must not be broadcast, got %v", msgs)
+	}
+
+	// Чужая клиника не видит и не может отметиться
+	foreign := connectPresenceUser(h, store, doctorB)
+	if got := frame(foreign, clientFrame{Type: "presence", Card: "emp-a"}); len(got) != 1 || errorCode(got[0]) != wsErrForbidden {
+		t.Errorf("foreign clinic presence: got %v", got)
+	}
+
+	// Закрытая вкладка - коллега больше не видит врача
+	h.Unregister(doctor)
+	doctor.leavePresence()
+	if snap := presenceData(t, received(colleague)); len(snap.Viewers) != 1 || snap.Viewers[0].UserID != regA.ID {
+		t.Errorf("after disconnect colleague sees %+v", snap.Viewers)
+	}
+}
+
+func TestSectionLocksConflictAndExpire(t *testing.T) {
+	store := newMemPresenceStore()
+	h := newPresenceHub(t)
+	doctor := connectPresenceUser(h, store, doctorA)
+	other := connectPresenceUser(h, store, regA)
+
+	got := frame(doctor, clientFrame{ID: "l-1", Type: "lock", Card: "emp-a", Section: "medical"})
+	if len(got) != 1 || got[0].Type != "locked" || got[0].ReplyTo != "l-1" {
+		t.Fatalf("lock: got %v", got)
+	}
+	got = frame(other, clientFrame{Type: "lock", Card: "emp-a", Section: "medical"})
+	if len(got) != 1 || errorCode(got[0]) != wsErrSectionLocked {
+		t.Fatalf("second lock: want section_locked, got %v", got)
+	}
+	if holder := got[0].Data.(map[string]any)["lock"].(*sectionLock); holder.UserID != doctorA.ID {
+		t.Errorf("conflict must name the holder, got %+v", holder)
+	}
+	if got := frame(other, clientFrame{Type: "lock", Card: "emp-a", Section: "spec:Терапевт"}); got[0].Type != "locked" {
+		t.Errorf("other section must be free, got %v", got)
+	}
+
+	// Присутствие продлевает блокировку
+	frame(doctor, clientFrame{Type: "presence", Card: "emp-a", State: "editing", Section: "medical"})
+	store.advance(lockTTL - time.Second)
+	frame(doctor, clientFrame{Type: "presence", Card: "emp-a", State: "editing", Section: "medical"})
+	store.advance(lockTTL - time.Second)
+	if got := frame(other, clientFrame{Type: "lock", Card: "emp-a", Section: "medical"}); errorCode(got[0]) != wsErrSectionLocked {
+		t.Fatalf("renewed lock must hold, got %v", got)
+	}
+
+	// Вкладку закрыли без unlock - блокировка истекает сама
+	h.Unregister(doctor)
+	doctor.leavePresence()
+	store.advance(lockTTL)
+	cards, _ := store.Sweep(context.Background())
+	if len(cards) != 1 || cards[0] != "emp-a" {
+		t.Errorf("sweep: got %v", cards)
+	}
+	if got := frame(other, clientFrame{Type: "lock", Card: "emp-a", Section: "medical"}); len(got) != 1 || got[0].Type != "locked" {
+		t.Errorf("expired lock must be free, got %v", got)
+	}
+	if got := frame(other, clientFrame{Type: "unlock", Card: "emp-a", Section: "medical"}); len(got) != 1 || got[0].Type != "unlocked" {
+		t.Errorf("unlock: got %v", got)
+	}
+
+	cases := []clientFrame{
+		{Type: "lock", Card: "emp-a", Section: "passport"},
+		{Type: "lock", Section: "medical"},
+		{Type: "presence", Card: "emp-a", State: "typing"},
+	}
+	for _, f := range cases {
+		if got := frame(other, f); len(got) != 1 || errorCode(got[0]) != wsErrBadFrame {
+			t.Errorf("%+v: want bad_frame, got %v", f, got)
+		}
+	}
+}
+
+func TestSaveChecksLocksOfChangedSections(t *testing.T) {
+	old := json.RawMessage(`{"Терапевт":{"diagnosis":"J06"},"ЛОР":{"diagnosis":"здоров"}}`)
+	now := json.RawMessage(`{"Терапевт":{"diagnosis":"J06.9"},"ЛОР":{"diagnosis":"здоров"},"Окулист":{}}`)
+	changed := changedSpecSections(old, now)
+	sort.Strings(changed)
+	if len(changed) != 2 || changed[0] != "spec:Окулист" || changed[1] != "spec:Терапевт" {
+		t.Errorf("changed sections = %v", changed)
+	}
+	if removed := changedSpecSections(old, json.RawMessage(`{"ЛОР":{"diagnosis":"здоров"}}`)); len(removed) != 1 || removed[0] != "spec:Терапевт" {
+		t.Errorf("removed entry: %v", removed)
+	}
+
+	snap := &cardPresence{Locks: []sectionLock{{Section: "spec:Терапевт", UserID: "doc-a"}, {Section: "medical", UserID: "doc-b"}}}
+	if l := lockConflict(snap, "doc-a", changed); l != nil {
+		t.Errorf("own lock is not a conflict, got %+v", l)
+	}
+	if l := lockConflict(snap, "doc-b", changed); l == nil || l.UserID != "doc-a" {
+		t.Errorf("want conflict with doc-a, got %+v", l)
+	}
+	if l := lockConflict(snap, "doc-a", []string{"general"}); l != nil {
+		t.Errorf("unlocked section: got %+v", l)
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// Эталонные матрицы (уровень M, без тихой зоны) получены независимым кодировщиком;
+// маска указана явно, выбор маски по штрафу у кодировщиков может отличаться
+var qrReference = []struct {
+	data    string
+	version int
+	mask    int
+	matrix  string
+}{
+	{"https://medwork.example/verify", 3, 3, `
+#######.##.#.###..###.#######
+#.....#.####.#..###.#.#.....#
+#.###.#..#.#....#.###.#.###.#
+#.###.#.####..##.###..#.###.#
+#.###.#..#.#..#.#..#..#.###.#
+#.....#....#.#.#####..#.....#
+#######.#.#.#.#.#.#.#.#######
+........##.##..####.#........
+#.##.###..#.##.#.####.#..#.##
+###..#...#...###...######...#
+#...#.#..#...#..#.#..##.#.##.
+###.##...#......#..###.#....#
+..##.###.#.##.##.#.#...#.##..
+####....####..#.##.#.##...###
+#.#.#.#..#..##.##.###.#...###
+.####...#.##..#.#....####..#.
+....#.####.#..###.#.##.###.#.
+..#.##...#..#.#.#.#.#..#.###.
+#..#.####..########..####.#..
+....##.#.#..###..#..###...#..
+.#..####...#.##.###.#######..
+........#...#..######...#####
+#######.#####.##.#.##.#.##.#.
+#.....#.#....###..#.#...##...
+#.###.#...#......#..#####.#.#
+#.###.#.##.......#.##..###.#.
+#.###.#.###.#.#..##....#..#.#
+#.....#..##....#..#.#.####.#.
+#######.##.#..###.###.#.#..#.`},
+	// Версия 8: два размера блоков и блок информации о версии
+	{"https://medwork.example/api/certificates/verify?t=abcdefghijklmnopqrstuvwxyz-_abcdefghijklmnopqrstuvwxyz-_abcdefghijklmnopqrstuvwxyz-_abcdef", 8, 2, `
+#######..#..###.#.###...#....##.#...#...#.#######
+#.....#....##..#.#.#.#####.#......#..####.#.....#
+#.###.#.#...##.##...#..####.##.##.#....##.#.###.#
+#.###.#.######...###.#..###...##.#####.#..#.###.#
+#.###.#.#..#.........######..####..###....#.###.#
+#.....#.#.#.#..#.#.#..#...###....###..#...#.....#
+#######.#.#.#.#.#.#.#.#.#.#.#.#.#.#.#.#.#.#######
+........##..#...###...#...#.#...#....##..........
+#.#####..#......#.#.#.#####....#.#.####.#.#####..
+.###.#....#.#......#.#.###..####...#.#...##.#.##.
+.####.#..#.#####.#.####.#.#.#...###.#####..##..##
+####.#.##...#####..#.......####.#.#...##....#..##
+.##.#.###...###..#...####..#..##.##.#..##..#...##
+#..##...#...#...#####.####...##....#.#....######.
+...#.##..#..##.#.##.###.######...##...#.#....####
+.#####.##....##.#..##.##..##..#.####......###...#
+##.##.##....##..##..##.##.#.##.#...####.#..#.##..
+....##.#...##..#..##.###...#####...#.#.#.###.....
+#.#.#.#.#.#########.#...#.#.#.....#####....#.#.##
+..#..#.##...#......#...#.##.#####....#.######..##
+##....##.####.#.##.######.#..###...##..##.#...#..
+.......##.########.#.....###..#.#...##...#####...
+#...#####..#..###.#..########.....##.##.#####.#.#
+#####...#########.#.#.#...###...#....#.##...##...
+.#.##.#.#..#..##..#.###.#.#..#.#...##...#.#.###..
+##.##...##..#.##..##..#...#####.........#...#.#..
+.#..#########...#..##.#####.#...###.###.#####.###
+###....##..##..##.#..##.#..######.#...#.#.......#
+.##..###.......#...#....#....###..####.###..#####
+#.##.#.#.#.#.#.####..###.###.###.....#.#.#.#.##..
+#.##..##.#....##..##..#..#.#.#.##.#.#.##.###..###
+#..#.#.###..##..#.#..#.#..##.##.####.#..##.##...#
+..#.###.##.###..#.#.##.....#..##.####..#.#..####.
+.#..#......#...#...#.#####.#######...#..##.#.....
+.####.##.#........##.#.#.#.....##########.#.##.##
+##.......####.###..#.###.##.##.####..#..#.......#
+##..#.####..######.##.##.##..#.#.#.#####....#.#..
+#.#....##.#..###.#.#.#.###..####...#.#..####..##.
+.#...##..#.#.#.#.####.#..#.......##...##.##.#.###
+.###......#......###.#..##.###..#....##.#..#...##
+###...#...##.######.#.#####...##.#####.######.#..
+........###.######.#..#...#..####..###..#...##.#.
+#######....##..##..#.##.#.#.#..#.##.#.#.#.#.#..##
+#.....#.#.#..###..#.###...#.###.##.#..#.#...#..##
+#.###.#.#..#...###....#####....#.#.##.#.#######.#
+#.###.#.#.#.#..##..##.##.....###...#.#..#####.#.#
+#.###.#.#.#.#.###...#..###.#.#....##..#..###.#...
+#.....#..#..##...#####.##..#####..##.#.#.##.....#
+#######.#.##.##...#.#..###...###...###.#.....####`},
+}
+
+func qrMatrix(q *qrCode) string {
+	var b strings.Builder
+	for _, row := range q.modules {
+		b.WriteByte('\n')
+		for _, dark := range row {
+			if dark {
+				b.WriteByte('#')
+			} else {
+				b.WriteByte('.')
+			}
+		}
+	}
+	return b.String()
+}
+
+func TestEncodeQRMatchesReference(t *testing.T) {
+	for _, ref := range qrReference {
+		q, err := encodeQRMask([]byte(ref.data), ref.mask)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if q.size != 17+4*ref.version {
+			t.Errorf("%q: size %d, want version %d", ref.data, q.size, ref.version)
+			continue
+		}
+		if got := qrMatrix(q); got != ref.matrix {
+			t.Errorf("%q: matrix differs from reference:%s\nwant:%s", ref.data, got, ref.matrix)
+		}
+	}
+}
+
+func TestEncodeQRPicksSmallestVersion(t *testing.T) {
+	for _, tc := range []struct{ n, version int }{{14, 1}, {15, 2}, {122, 7}, {123, 8}, {412, 15}} {
+		q, err := encodeQR([]byte(strings.Repeat("a", tc.n)))
+		if err != nil {
+			t.Fatalf("%d bytes: %v", tc.n, err)
+		}
+		if q.size != 17+4*tc.version {
+			t.Errorf("%d bytes: size %d, want version %d", tc.n, q.size, tc.version)
+		}
+	}
+	if _, err := encodeQR(make([]byte, 413)); err == nil {
+		t.Error("payload over version 15 capacity must fail")
+	}
+}
@@ -0,0 +1,335 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// reportDoc - печатный документ из заголовков, абзацев и таблиц.
+// Одно описание выводится и в PDF, и в DOCX (заключительный акт, план оздоровления).
+
+type reportBlockKind int
+
+const (
+	reportTitle reportBlockKind = iota
+	reportHeading
+	reportText
+	reportTable
+	reportPageBreak
+	reportQR
+)
+
+type reportBlock struct {
+	kind   reportBlockKind
+	text   string
+	bold   bool
+	widths []float64 // доли ширины страницы, в сумме 1
+	header []string
+	rows   [][]string
+	qr     *qrCode
+	size   float64 // сторона QR-кода в пунктах
+}
+
+type reportDoc struct {
+	footer string // нижний колонтитул, к нему добавляется номер страницы
+	blocks []reportBlock
+}
+
+func (d *reportDoc) Title(s string) {
+	d.blocks = append(d.blocks, reportBlock{kind: reportTitle, text: s, bold: true})
+}
+
+func (d *reportDoc) Heading(s string) {
+	d.blocks = append(d.blocks, reportBlock{kind: reportHeading, text: s, bold: true})
+}
+
+func (d *reportDoc) Text(s string) {
+	d.blocks = append(d.blocks, reportBlock{kind: reportText, text: s})
+}
+
+func (d *reportDoc) Bold(s string) {
+	d.blocks = append(d.blocks, reportBlock{kind: reportText, text: s, bold: true})
+}
+
+func (d *reportDoc) Table(widths []float64, header []string, rows [][]string) {
+	d.blocks = append(d.blocks, reportBlock{kind: reportTable, widths: widths, header: header, rows: rows})
+}
+
+// QR выводит код по центру; size - сторона в пунктах вместе с тихой зоной
+func (d *reportDoc) QR(q *qrCode, size float64) {
+	d.blocks = append(d.blocks, reportBlock{kind: reportQR, qr: q, size: size})
+}
+
+func (d *reportDoc) PageBreak() {
+	d.blocks = append(d.blocks, reportBlock{kind: reportPageBreak})
+}
+
+// --- PDF ---
+
+func (d *reportDoc) PDF(regular, bold *ttfFont) []byte {
+	doc := newPDFDoc(regular, bold)
+	if d.footer != "" {
+		doc.footer = func(p *pdfDoc, page, total int) {
+			p.Line(pdfMargin, pdfPageHeight-40, pdfPageWidth-pdfMargin, pdfPageHeight-40, 0.5)
+			p.Text(fontRegular, 8, pdfMargin, pdfPageHeight-28, d.footer)
+			s := fmt.Sprintf("стр. %d из %d", page, total)
+			p.Text(fontRegular, 8, pdfPageWidth-pdfMargin-p.fonts[fontRegular].font.width(s, 8), pdfPageHeight-28, s)
+		}
+	}
+	font := func(b reportBlock) int {
+		if b.bold {
+			return fontBold
+		}
+		return fontRegular
+	}
+	content := pdfPageWidth - 2*pdfMargin
+
+	for i, b := range d.blocks {
+		switch b.kind {
+		case reportTitle:
+			doc.Centered(fontBold, 13, b.text)
+			doc.Space(6)
+		case reportHeading:
+			if i > 0 {
+				doc.Space(8)
+			}
+			// Заголовок не оставляем последней строкой страницы
+			doc.ensureSpace(40)
+			doc.Paragraph(fontBold, 10.5, 0, b.text)
+			doc.Space(2)
+		case reportText:
+			doc.Paragraph(font(b), 10.5, 0, b.text)
+		case reportTable:
+			widths := make([]float64, len(b.widths))
+			for j, w := range b.widths {
+				widths[j] = w * content
+			}
+			doc.Space(4)
+			header := func() {
+				if len(b.header) > 0 {
+					doc.TableRow(fontBold, 8.5, widths, b.header, nil)
+				}
+			}
+			header()
+			for _, row := range b.rows {
+				doc.TableRow(fontRegular, 8.5, widths, row, header)
+			}
+			doc.Space(4)
+		case reportPageBreak:
+			doc.AddPage()
+		case reportQR:
+			doc.ensureSpace(b.size + 8)
+			module := b.size / float64(b.qr.size+2*qrQuiet)
+			x0 := (pdfPageWidth-b.size)/2 + qrQuiet*module
+			y0 := doc.y + 4 + qrQuiet*module
+			// Тёмные модули строки - отрезками, чтобы не плодить прямоугольники
+			for y, row := range b.qr.modules {
+				for x := 0; x < len(row); x++ {
+					if !row[x] {
+						continue
+					}
+					start := x
+					for x+1 < len(row) && row[x+1] {
+						x++
+					}
+					doc.FillRect(x0+float64(start)*module, y0+float64(y)*module, float64(x-start+1)*module, module)
+				}
+			}
+			doc.Space(b.size + 8)
+		}
+	}
+	return doc.Bytes()
+}
+
+// --- DOCX ---
+
+// Размеры в twips (1/20 пункта): A4, поля 2 см
+const (
+	docxPageWidth  = 11906
+	docxPageHeight = 16838
+	docxMargin     = 1134
+)
+
+func docxEscape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// docxRun - фрагмент текста; переводы строк превращаются в <w:br/>
+func docxRun(s string, bold bool, halfPoints int) string {
+	var b strings.Builder
+	b.WriteString(`<w:r><w:rPr><w:rFonts w:ascii="Times New Roman" w:hAnsi="Times New Roman" w:cs="Times New Roman"/>`)
+	if bold {
+		b.WriteString(`<w:b/>`)
+	}
+	fmt.Fprintf(&b, `<w:sz w:val="%d"/></w:rPr>`, halfPoints)
+	for i, line := range strings.Split(strings.ReplaceAll(s, "\r", ""), "\n") {
+		if i > 0 {
+			b.WriteString(`<w:br/>`)
+		}
+		b.WriteString(`<w:t xml:space="preserve">` + docxEscape(line) + `</w:t>`)
+	}
+	b.WriteString(`</w:r>`)
+	return b.String()
+}
+
+func docxParagraph(s string, bold, center bool, halfPoints int) string {
+	ppr := `<w:pPr><w:spacing w:after="60"/>`
+	if center {
+		ppr += `<w:jc w:val="center"/>`
+	}
+	ppr += `</w:pPr>`
+	return `<w:p>` + ppr + docxRun(s, bold, halfPoints) + `</w:p>`
+}
+
+func docxTable(b reportBlock) string {
+	content := float64(docxPageWidth - 2*docxMargin)
+	widths := make([]int, len(b.widths))
+	for i, w := range b.widths {
+		widths[i] = int(w * content)
+	}
+
+	var out strings.Builder
+	out.WriteString(`<w:tbl><w:tblPr><w:tblW w:w="0" w:type="auto"/><w:tblBorders>`)
+	for _, side := range []string{"top", "left", "bottom", "right", "insideH", "insideV"} {
+		fmt.Fprintf(&out, `<w:%s w:val="single" w:sz="4" w:space="0" w:color="000000"/>`, side)
+	}
+	out.WriteString(`</w:tblBorders><w:tblLayout w:type="fixed"/></w:tblPr><w:tblGrid>`)
+	for _, w := range widths {
+		fmt.Fprintf(&out, `<w:gridCol w:w="%d"/>`, w)
+	}
+	out.WriteString(`</w:tblGrid>`)
+
+	row := func(cells []string, header bool) {
+		out.WriteString(`<w:tr>`)
+		if header {
+			out.WriteString(`<w:trPr><w:tblHeader/></w:trPr>`)
+		}
+		for i, c := range cells {
+			fmt.Fprintf(&out, `<w:tc><w:tcPr><w:tcW w:w="%d" w:type="dxa"/></w:tcPr>`, widths[i])
+			out.WriteString(`<w:p><w:pPr><w:spacing w:after="0"/></w:pPr>` + docxRun(c, header, 18) + `</w:p></w:tc>`)
+		}
+		out.WriteString(`</w:tr>`)
+	}
+	if len(b.header) > 0 {
+		row(b.header, true)
+	}
+	for _, r := range b.rows {
+		row(r, false)
+	}
+	out.WriteString(`</w:tbl>`)
+	return out.String()
+}
+
+// docxImage - встроенный рисунок word/media/image{n}.png по центру; side - сторона в EMU
+func docxImage(n, side int) string {
+	return fmt.Sprintf(`<w:p><w:pPr><w:jc w:val="center"/></w:pPr><w:r><w:drawing><wp:inline distT="0" distB="0" distL="0" distR="0">`+
+		`<wp:extent cx="%[2]d" cy="%[2]d"/><wp:docPr id="%[1]d" name="image%[1]d"/>`+
+		`<a:graphic xmlns:a="http://schemas.openxmlformats.org/drawingml/2006/main"><a:graphicData uri="http://schemas.openxmlformats.org/drawingml/2006/picture">`+
+		`<pic:pic xmlns:pic="http://schemas.openxmlformats.org/drawingml/2006/picture"><pic:nvPicPr><pic:cNvPr id="%[1]d" name="image%[1]d.png"/><pic:cNvPicPr/></pic:nvPicPr>`+
+		`<pic:blipFill><a:blip r:embed="rIdImg%[1]d"/><a:stretch><a:fillRect/></a:stretch></pic:blipFill>`+
+		`<pic:spPr><a:xfrm><a:off x="0" y="0"/><a:ext cx="%[2]d" cy="%[2]d"/></a:xfrm><a:prstGeom prst="rect"><a:avLst/></a:prstGeom></pic:spPr></pic:pic>`+
+		`</a:graphicData></a:graphic></wp:inline></w:drawing></w:r></w:p>`, n, side)
+}
+
+// DOCX собирает документ WordprocessingML; архив детерминирован (фиксированные даты файлов)
+func (d *reportDoc) DOCX() []byte {
+	var body strings.Builder
+	var images [][]byte
+	for _, b := range d.blocks {
+		switch b.kind {
+		case reportTitle:
+			body.WriteString(docxParagraph(b.text, true, true, 26))
+		case reportHeading:
+			body.WriteString(`<w:p><w:pPr><w:keepNext/><w:spacing w:before="160" w:after="60"/></w:pPr>` + docxRun(b.text, true, 21) + `</w:p>`)
+		case reportText:
+			body.WriteString(docxParagraph(b.text, b.bold, false, 21))
+		case reportTable:
+			body.WriteString(docxTable(b))
+			body.WriteString(`<w:p/>`)
+		case reportPageBreak:
+			body.WriteString(`<w:p><w:r><w:br w:type="page"/></w:r></w:p>`)
+		case reportQR:
+			img, err := b.qr.png(8)
+			if err != nil {
+				continue
+			}
+			images = append(images, img)
+			body.WriteString(docxImage(len(images), int(b.size*12700)))
+		}
+	}
+
+	var footer string
+	if d.footer != "" {
+		footer = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<w:ftr xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main"><w:p><w:pPr><w:pBdr><w:top w:val="single" w:sz="4" w:space="1" w:color="000000"/></w:pBdr></w:pPr>` +
+			docxRun(d.footer+" · стр. ", false, 16) +
+			`<w:fldSimple w:instr="PAGE"><w:r><w:rPr><w:sz w:val="16"/></w:rPr><w:t>1</w:t></w:r></w:fldSimple></w:p></w:ftr>`
+	}
+
+	var sect strings.Builder
+	sect.WriteString(`<w:sectPr>`)
+	if footer != "" {
+		sect.WriteString(`<w:footerReference w:type="default" r:id="rIdFooter"/>`)
+	}
+	fmt.Fprintf(&sect, `<w:pgSz w:w="%d" w:h="%d"/><w:pgMar w:top="%d" w:right="%d" w:bottom="%d" w:left="%d" w:header="567" w:footer="567" w:gutter="0"/></w:sectPr>`,
+		docxPageWidth, docxPageHeight, docxMargin, docxMargin, docxMargin, docxMargin)
+
+	document := `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<w:document xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships" xmlns:wp="http://schemas.openxmlformats.org/drawingml/2006/wordprocessingDrawing"><w:body>` +
+		body.String() + sect.String() + `</w:body></w:document>`
+
+	contentTypes := `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+		`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+		`<Default Extension="xml" ContentType="application/xml"/>` +
+		`<Default Extension="png" ContentType="image/png"/>` +
+		`<Override PartName="/word/document.xml" ContentType="application/vnd.openxmlformats-officedocument.wordprocessingml.document.main+xml"/>`
+	docRels := `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">`
+	if footer != "" {
+		contentTypes += `<Override PartName="/word/footer1.xml" ContentType="application/vnd.openxmlformats-officedocument.wordprocessingml.footer+xml"/>`
+		docRels += `<Relationship Id="rIdFooter" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/footer" Target="footer1.xml"/>`
+	}
+	for i := range images {
+		docRels += fmt.Sprintf(`<Relationship Id="rIdImg%d" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/image" Target="media/image%d.png"/>`, i+1, i+1)
+	}
+	contentTypes += `</Types>`
+	docRels += `</Relationships>`
+
+	rels := `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+		`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="word/document.xml"/>` +
+		`</Relationships>`
+
+	files := []struct{ name, data string }{
+		{"[Content_Types].xml", contentTypes},
+		{"_rels/.rels", rels},
+		{"word/document.xml", document},
+		{"word/_rels/document.xml.rels", docRels},
+	}
+	if footer != "" {
+		files = append(files, struct{ name, data string }{"word/footer1.xml", footer})
+	}
+	for i, img := range images {
+		files = append(files, struct{ name, data string }{fmt.Sprintf("word/media/image%d.png", i+1), string(img)})
+	}
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	modified := time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, f := range files {
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: f.name, Method: zip.Deflate, Modified: modified})
+		if err != nil {
+			continue
+		}
+		w.Write([]byte(f.data))
+	}
+	zw.Close()
+	return buf.Bytes()
+}
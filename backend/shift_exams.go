@@ -0,0 +1,705 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Предсменные (предрейсовые) и послесменные (послерейсовые) осмотры - п. 26-40 Правил.
+// Быстрый осмотр без договора и маршрутного листа: давление, пульс, температура, проба
+// на алкоголь, жалобы и решение о допуске. Записи образуют электронный журнал
+// (Приложение 4 к Правилам) по организации и дню; допущенному выдаётся штамп для
+// путевого листа (п. 39), подписанный ключом сервера.
+
+const (
+	ShiftExamPre  = ExamTypePreShift
+	ShiftExamPost = "post_shift" // эпизод осмотра не заводится, поэтому вне ExamType*
+
+	AlcoholNegative     = "negative"
+	AlcoholPositive     = "positive"
+	AlcoholNotPerformed = "not_performed" // проба по показаниям
+)
+
+var shiftExamTitles = map[string]string{
+	ShiftExamPre:  "предсменный (предрейсовый)",
+	ShiftExamPost: "послесменный (послерейсовый)",
+}
+
+var alcoholTitles = map[string]string{
+	AlcoholNegative:     "отрицательная",
+	AlcoholPositive:     "положительная",
+	AlcoholNotPerformed: "не проводилась",
+}
+
+type ShiftExam struct {
+	ID               int64    `json:"id"`
+	Kind             string   `json:"kind"` // pre_shift / post_shift
+	ClinicID         string   `json:"clinicId"`
+	ClientBIN        string   `json:"clientBin"`
+	ClientName       string   `json:"clientName"`
+	ContractID       *int64   `json:"contractId,omitempty"`
+	PatientUID       *string  `json:"patientUid,omitempty"`
+	EmployeeName     string   `json:"employeeName"`
+	PersonnelNumber  string   `json:"personnelNumber,omitempty"` // табельный номер
+	Position         string   `json:"position,omitempty"`
+	ExamAt           string   `json:"examAt"`
+	ShiftDate        string   `json:"shiftDate"`
+	BPSystolic       int      `json:"bpSystolic"`
+	BPDiastolic      int      `json:"bpDiastolic"`
+	Pulse            int      `json:"pulse"`
+	Temperature      *float64 `json:"temperature,omitempty"`
+	AlcoholTest      string   `json:"alcoholTest"`
+	AlcoholValue     string   `json:"alcoholValue,omitempty"`
+	Complaints       string   `json:"complaints,omitempty"`
+	Referral         string   `json:"referral,omitempty"` // направление к специалисту с предполагаемым диагнозом
+	Admitted         bool     `json:"admitted"`
+	NotAdmittedCause string   `json:"notAdmittedCause,omitempty"`
+	ExaminerID       string   `json:"examinerId"`
+	ExaminerName     string   `json:"examinerName"`
+	StampCode        string   `json:"stampCode,omitempty"`
+	CreatedAt        string   `json:"createdAt"`
+}
+
+func migrateShiftExams(ctx context.Context, tx pgx.Tx) error {
+	_, err := tx.Exec(ctx, `
+CREATE TABLE IF NOT EXISTS shift_exams (
+  id                 SERIAL PRIMARY KEY,
+  kind               TEXT NOT NULL,
+  clinic_id          TEXT NOT NULL,
+  client_bin         TEXT NOT NULL,
+  client_name        TEXT NOT NULL DEFAULT '',
+  contract_id        INTEGER REFERENCES contracts(id) ON DELETE SET NULL,
+  patient_uid        TEXT,
+  employee_name      TEXT NOT NULL,
+  personnel_number   TEXT NOT NULL DEFAULT '',
+  position           TEXT NOT NULL DEFAULT '',
+  exam_at            TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  shift_date         DATE NOT NULL DEFAULT CURRENT_DATE,
+  bp_systolic        INTEGER NOT NULL,
+  bp_diastolic       INTEGER NOT NULL,
+  pulse              INTEGER NOT NULL,
+  temperature        NUMERIC(3,1),
+  alcohol_test       TEXT NOT NULL,
+  alcohol_value      TEXT NOT NULL DEFAULT '',
+  complaints         TEXT NOT NULL DEFAULT '',
+  referral           TEXT NOT NULL DEFAULT '',
+  admitted           BOOLEAN NOT NULL,
+  not_admitted_cause TEXT NOT NULL DEFAULT '',
+  examiner_id        TEXT NOT NULL,
+  examiner_name      TEXT NOT NULL,
+  stamp_signature    TEXT,
+  created_at         TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  CONSTRAINT valid_shift_exam_kind CHECK (kind IN ('pre_shift', 'post_shift')),
+  CONSTRAINT valid_shift_alcohol CHECK (alcohol_test IN ('negative', 'positive', 'not_performed')),
+  CONSTRAINT shift_admit_sober CHECK (NOT (admitted AND alcohol_test = 'positive'))
+);
+`)
+	if err != nil {
+		return fmt.Errorf("migrate shift_exams: %w", err)
+	}
+	_, err = tx.Exec(ctx, `CREATE INDEX IF NOT EXISTS idx_shift_exams_journal ON shift_exams(client_bin, shift_date);`)
+	if err != nil {
+		return fmt.Errorf("create index shift_exams_journal: %w", err)
+	}
+	_, err = tx.Exec(ctx, `CREATE INDEX IF NOT EXISTS idx_shift_exams_clinic ON shift_exams(clinic_id, shift_date);`)
+	if err != nil {
+		return fmt.Errorf("create index shift_exams_clinic: %w", err)
+	}
+	return nil
+}
+
+// --- Штамп допуска ---
+
+// stampPayload - подписываемое содержимое штампа
+func (e *ShiftExam) stampPayload() string {
+	return strings.Join([]string{
+		strconv.FormatInt(e.ID, 10), e.Kind, e.ExamAt, e.ClientBIN, e.EmployeeName, e.PersonnelNumber,
+		strconv.FormatBool(e.Admitted), e.ExaminerID, e.ExaminerName,
+	}, "|")
+}
+
+func signShiftStamp(e *ShiftExam) string {
+	mac := hmac.New(sha256.New, serverKeys.ShiftStamp)
+	mac.Write([]byte(e.stampPayload()))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// verifyShiftStamp пересчитывает подпись по записи: правка строки в базе делает штамп
+// недействительным. code - короткий код с путевого листа.
+func verifyShiftStamp(e *ShiftExam, signature, code string) bool {
+	return signature != "" && hmac.Equal([]byte(signature), []byte(signShiftStamp(e))) && code == stampCode(signature)
+}
+
+// stampCode - короткий код для сверки по телефону: первые 8 знаков подписи
+func stampCode(signature string) string {
+	if len(signature) < 8 {
+		return ""
+	}
+	s := strings.ToUpper(signature[:8])
+	return s[:4] + "-" + s[4:]
+}
+
+func buildShiftStampReport(e *ShiftExam) *reportDoc {
+	examAt, _ := time.Parse(time.RFC3339, e.ExamAt)
+	d := &reportDoc{footer: "Штамп медицинского осмотра № " + strconv.FormatInt(e.ID, 10)}
+	d.Title(strings.ToUpper(shiftExamTitles[e.Kind]) + " МЕДИЦИНСКИЙ ОСМОТР")
+	d.Bold("К РАБОТЕ ДОПУЩЕН")
+	d.Text("Дата, время: " + examAt.Local().Format("02.01.2006 15:04"))
+	d.Text("Работник: " + e.EmployeeName + tabNumberSuffix(e.PersonnelNumber))
+	d.Text("Организация: " + orDash(e.ClientName) + " (БИН " + orDash(e.ClientBIN) + ")")
+	d.Text(fmt.Sprintf("АД %d/%d мм рт. ст., пульс %d уд/мин, проба на алкоголь: %s", e.BPSystolic, e.BPDiastolic, e.Pulse, alcoholTitles[e.AlcoholTest]))
+	d.Text("Медицинский работник: " + e.ExaminerName + "  ____________")
+	d.Text("Подпись работника: ____________")
+	d.Text("Код проверки: " + e.StampCode + " (№ " + strconv.FormatInt(e.ID, 10) + ")")
+	return d
+}
+
+func tabNumberSuffix(n string) string {
+	if n == "" {
+		return ""
+	}
+	return ", таб. № " + n
+}
+
+// --- Загрузка ---
+
+const shiftExamColumns = `id, kind, clinic_id, client_bin, client_name, contract_id, patient_uid, employee_name,
+personnel_number, position, exam_at, shift_date, bp_systolic, bp_diastolic, pulse, temperature::float8,
+alcohol_test, alcohol_value, complaints, referral, admitted, not_admitted_cause, examiner_id, examiner_name,
+COALESCE(stamp_signature, ''), created_at`
+
+func scanShiftExam(row pgx.Row) (*ShiftExam, string, error) {
+	var e ShiftExam
+	var examAt, shiftDate, createdAt time.Time
+	var signature string
+	err := row.Scan(&e.ID, &e.Kind, &e.ClinicID, &e.ClientBIN, &e.ClientName, &e.ContractID, &e.PatientUID, &e.EmployeeName,
+		&e.PersonnelNumber, &e.Position, &examAt, &shiftDate, &e.BPSystolic, &e.BPDiastolic, &e.Pulse, &e.Temperature,
+		&e.AlcoholTest, &e.AlcoholValue, &e.Complaints, &e.Referral, &e.Admitted, &e.NotAdmittedCause, &e.ExaminerID, &e.ExaminerName,
+		&signature, &createdAt)
+	if err != nil {
+		return nil, "", err
+	}
+	e.ExamAt = examAt.UTC().Format(time.RFC3339)
+	e.ShiftDate = shiftDate.Format("2006-01-02")
+	e.CreatedAt = createdAt.Format(time.RFC3339)
+	e.StampCode = stampCode(signature)
+	return &e, signature, nil
+}
+
+// canSeeShiftExam: клиника, проводившая осмотр, и организация работника
+func canSeeShiftExam(u *User, e *ShiftExam) bool {
+	if isClinicStaff(u) {
+		return userClinicID(u) == e.ClinicID
+	}
+	return u.Role == UserRoleOrganization && u.BIN != nil && *u.BIN == e.ClientBIN
+}
+
+// --- Handlers ---
+
+// POST /api/shift-exams - запись осмотра. Работник - из контингента договора
+// (contractId + employeeId) или вводится вручную вместе с организацией.
+func createShiftExamHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	user, ok := requestUser(ctx, w, r)
+	if !ok {
+		return
+	}
+	if !isClinicStaff(user) {
+		errorResponse(w, http.StatusForbidden, "only medical staff can record shift exams")
+		return
+	}
+	var in struct {
+		Kind             string   `json:"kind"`
+		ContractID       *int64   `json:"contractId"`
+		EmployeeID       string   `json:"employeeId"`
+		ClientBIN        string   `json:"clientBin"`
+		ClientName       string   `json:"clientName"`
+		EmployeeName     string   `json:"employeeName"`
+		PersonnelNumber  string   `json:"personnelNumber"`
+		Position         string   `json:"position"`
+		ShiftDate        string   `json:"shiftDate"`
+		BPSystolic       int      `json:"bpSystolic"`
+		BPDiastolic      int      `json:"bpDiastolic"`
+		Pulse            int      `json:"pulse"`
+		Temperature      *float64 `json:"temperature"`
+		AlcoholTest      string   `json:"alcoholTest"`
+		AlcoholValue     string   `json:"alcoholValue"`
+		Complaints       string   `json:"complaints"`
+		Referral         string   `json:"referral"`
+		Admitted         *bool    `json:"admitted"`
+		NotAdmittedCause string   `json:"notAdmittedCause"`
+		ExaminerName     string   `json:"examinerName"`
+		MeasurementIDs   []int64  `json:"measurementIds"` // показания приборов пункта осмотра
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		errorResponse(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+	if _, ok := shiftExamTitles[in.Kind]; !ok {
+		errorResponse(w, http.StatusBadRequest, "kind must be pre_shift or post_shift")
+		return
+	}
+	if in.Admitted == nil {
+		errorResponse(w, http.StatusBadRequest, "admitted is required")
+		return
+	}
+	shiftDate := today()
+	if in.ShiftDate != "" {
+		t, err := parseDate(in.ShiftDate)
+		if err != nil {
+			errorResponse(w, http.StatusBadRequest, "shiftDate must be YYYY-MM-DD")
+			return
+		}
+		shiftDate = t
+	}
+
+	// Работник из контингента договора клиники
+	var patientUID *string
+	if in.ContractID != nil {
+		parties, err := loadContractParties(ctx, *in.ContractID)
+		if err != nil || !parties.isClinicSide(user) {
+			errorResponse(w, http.StatusForbidden, "contract not found for this clinic")
+			return
+		}
+		var employeesJSON []byte
+		if err := db.QueryRow(ctx, `SELECT client_name, employees FROM contracts WHERE id = $1`, *in.ContractID).Scan(&in.ClientName, &employeesJSON); err != nil {
+			errorResponse(w, http.StatusInternalServerError, "db error")
+			return
+		}
+		in.ClientBIN = parties.ClientBIN
+		var employees []contractEmployee
+		_ = json.Unmarshal(employeesJSON, &employees)
+		found := false
+		for _, emp := range employees {
+			if in.EmployeeID != "" && emp.matches(in.EmployeeID) {
+				uid := emp.ID
+				if emp.UserID != "" {
+					uid = emp.UserID
+				}
+				patientUID, found = &uid, true
+				in.EmployeeName, in.Position = strings.TrimSpace(emp.Name), emp.Position
+				break
+			}
+		}
+		if !found {
+			errorResponse(w, http.StatusBadRequest, "employee not found in contract")
+			return
+		}
+	}
+	in.EmployeeName = strings.TrimSpace(in.EmployeeName)
+	in.ClientBIN = strings.TrimSpace(in.ClientBIN)
+	if in.EmployeeName == "" || in.ClientBIN == "" {
+		errorResponse(w, http.StatusBadRequest, "employeeName and clientBin are required without a contract")
+		return
+	}
+
+	// Медицинский работник - врач клиники или имя из запроса
+	examiner := strings.TrimSpace(in.ExaminerName)
+	if user.DoctorID != nil {
+		_ = db.QueryRow(ctx, `SELECT name FROM doctors WHERE id::text = $1`, *user.DoctorID).Scan(&examiner)
+	}
+	if examiner == "" {
+		errorResponse(w, http.StatusBadRequest, "examinerName is required")
+		return
+	}
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		errorResponse(w, http.StatusInternalServerError, "db error")
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	// Показания приборов заменяют введённые вручную значения
+	if len(in.MeasurementIDs) > 0 {
+		measurements, err := claimMeasurements(ctx, tx, userClinicID(user), in.MeasurementIDs)
+		if err != nil {
+			errorResponse(w, http.StatusConflict, err.Error())
+			return
+		}
+		for _, m := range measurements {
+			if !m.Worker.matches(patientUID, in.ClientBIN, strings.TrimSpace(in.PersonnelNumber)) {
+				errorResponse(w, http.StatusBadRequest, fmt.Sprintf("measurement %d was taken from another worker", m.ID))
+				return
+			}
+			switch m.Type {
+			case ReadingAlcohol:
+				in.AlcoholTest, in.AlcoholValue = AlcoholNegative, deviceAlcoholValue(m)
+				if *m.AlcoholMgL > alcoholThresholdMgL {
+					in.AlcoholTest = AlcoholPositive
+				}
+			case ReadingBloodPressure:
+				in.BPSystolic, in.BPDiastolic, in.Pulse = *m.Systolic, *m.Diastolic, *m.Pulse
+			case ReadingTemperature:
+				in.Temperature = m.Temperature
+			}
+		}
+	}
+	if msg := checkVitals(in.BPSystolic, in.BPDiastolic, in.Pulse, in.Temperature); msg != "" {
+		errorResponse(w, http.StatusBadRequest, msg)
+		return
+	}
+	if in.AlcoholTest == "" {
+		in.AlcoholTest = AlcoholNotPerformed
+	}
+	if _, ok := alcoholTitles[in.AlcoholTest]; !ok {
+		errorResponse(w, http.StatusBadRequest, "alcoholTest must be negative, positive or not_performed")
+		return
+	}
+	if *in.Admitted && in.AlcoholTest == AlcoholPositive {
+		errorResponse(w, http.StatusBadRequest, "worker with a positive alcohol test cannot be admitted")
+		return
+	}
+	if !*in.Admitted && strings.TrimSpace(in.NotAdmittedCause) == "" {
+		errorResponse(w, http.StatusBadRequest, "notAdmittedCause is required when the worker is not admitted")
+		return
+	}
+
+	e, _, err := scanShiftExam(tx.QueryRow(ctx, `
+INSERT INTO shift_exams (kind, clinic_id, client_bin, client_name, contract_id, patient_uid, employee_name,
+  personnel_number, position, shift_date, bp_systolic, bp_diastolic, pulse, temperature, alcohol_test, alcohol_value,
+  complaints, referral, admitted, not_admitted_cause, examiner_id, examiner_name)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, $22)
+RETURNING `+shiftExamColumns,
+		in.Kind, userClinicID(user), in.ClientBIN, strings.TrimSpace(in.ClientName), in.ContractID, patientUID, in.EmployeeName,
+		strings.TrimSpace(in.PersonnelNumber), strings.TrimSpace(in.Position), shiftDate, in.BPSystolic, in.BPDiastolic, in.Pulse,
+		in.Temperature, in.AlcoholTest, strings.TrimSpace(in.AlcoholValue), strings.TrimSpace(in.Complaints),
+		strings.TrimSpace(in.Referral), *in.Admitted, strings.TrimSpace(in.NotAdmittedCause), user.ID, examiner))
+	if err != nil {
+		log.Printf("createShiftExam error: %v", err)
+		errorResponse(w, http.StatusInternalServerError, "db error")
+		return
+	}
+	if len(in.MeasurementIDs) > 0 {
+		if _, err := tx.Exec(ctx, `UPDATE device_measurements SET shift_exam_id = $1 WHERE id = ANY($2)`, e.ID, in.MeasurementIDs); err != nil {
+			log.Printf("createShiftExam measurements error: %v", err)
+			errorResponse(w, http.StatusInternalServerError, "db error")
+			return
+		}
+	}
+	// Подпись ставится по сохранённой записи: в неё входят id и время осмотра
+	if e.Admitted {
+		signature := signShiftStamp(e)
+		if _, err := tx.Exec(ctx, `UPDATE shift_exams SET stamp_signature = $2 WHERE id = $1`, e.ID, signature); err != nil {
+			log.Printf("createShiftExam stamp error: %v", err)
+			errorResponse(w, http.StatusInternalServerError, "db error")
+			return
+		}
+		e.StampCode = stampCode(signature)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		errorResponse(w, http.StatusInternalServerError, "db error")
+		return
+	}
+
+	event := map[string]any{"id": e.ID, "kind": e.Kind, "clientBin": e.ClientBIN, "shiftDate": e.ShiftDate, "admitted": e.Admitted}
+	broadcastToUser(e.ClinicID, "shift_exam_recorded", event)
+	// п. 34: об отстранении работодателю сообщается немедленно
+	if !e.Admitted {
+		event["employeeName"], event["cause"] = e.EmployeeName, e.NotAdmittedCause
+		broadcastToUsers(organizationUserIDs(ctx, db, e.ClientBIN), "shift_exam_not_admitted", event)
+	}
+	jsonResponse(w, http.StatusCreated, e)
+}
+
+// checkVitals - границы правдоподобных значений; пустая строка, если всё в порядке
+func checkVitals(systolic, diastolic, pulse int, temperature *float64) string {
+	switch {
+	case systolic < 60 || systolic > 260 || diastolic < 30 || diastolic > 160 || diastolic >= systolic:
+		return "blood pressure is out of range"
+	case pulse < 30 || pulse > 220:
+		return "pulse is out of range"
+	case temperature != nil && (*temperature < 34 || *temperature > 42):
+		return "temperature is out of range"
+	}
+	return ""
+}
+
+// organizationUserIDs - учётные записи организации по БИН
+func organizationUserIDs(ctx context.Context, q dbExecutor, bin string) []string {
+	rows, err := q.Query(ctx, `SELECT id FROM users WHERE role = 'organization' AND bin = $1`, bin)
+	if err != nil {
+		log.Printf("organizationUserIDs %s: %v", bin, err)
+		return nil
+	}
+	defer rows.Close()
+	var ids []string
+	for rows.Next() {
+		var id string
+		if rows.Scan(&id) == nil {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// /api/shift-exams/{id}[/stamp?format=pdf|docx]
+func shiftExamHandler(w http.ResponseWriter, r *http.Request, id int64, sub string) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	user, ok := requestUser(ctx, w, r)
+	if !ok {
+		return
+	}
+	e, _, err := scanShiftExam(db.QueryRow(ctx, `SELECT `+shiftExamColumns+` FROM shift_exams WHERE id = $1`, id))
+	if errors.Is(err, pgx.ErrNoRows) {
+		errorResponse(w, http.StatusNotFound, "shift exam not found")
+		return
+	}
+	if err != nil {
+		log.Printf("shiftExam %d: %v", id, err)
+		errorResponse(w, http.StatusInternalServerError, "db error")
+		return
+	}
+	if !canSeeShiftExam(user, e) {
+		errorResponse(w, http.StatusForbidden, "access denied")
+		return
+	}
+	switch sub {
+	case "":
+		jsonResponse(w, http.StatusOK, e)
+	case "stamp":
+		if !e.Admitted || e.StampCode == "" {
+			errorResponse(w, http.StatusConflict, "worker was not admitted, no stamp is issued")
+			return
+		}
+		writeReport(w, buildShiftStampReport(e), fmt.Sprintf("stamp-%d", e.ID), r.URL.Query().Get("format"))
+	default:
+		errorResponse(w, http.StatusNotFound, "not found")
+	}
+}
+
+// GET /api/shift-exams/verify?id=&code= - сверка штампа путевого листа без авторизации.
+// Отдаёт только то, что и так напечатано на штампе.
+func verifyShiftStampHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	id, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+	code := strings.ToUpper(strings.TrimSpace(r.URL.Query().Get("code")))
+	if err != nil || code == "" {
+		errorResponse(w, http.StatusBadRequest, "id and code are required")
+		return
+	}
+	e, signature, err := scanShiftExam(db.QueryRow(ctx, `SELECT `+shiftExamColumns+` FROM shift_exams WHERE id = $1`, id))
+	if err != nil || signature == "" {
+		jsonResponse(w, http.StatusOK, map[string]any{"valid": false})
+		return
+	}
+	if !verifyShiftStamp(e, signature, code) {
+		jsonResponse(w, http.StatusOK, map[string]any{"valid": false})
+		return
+	}
+	jsonResponse(w, http.StatusOK, map[string]any{
+		"valid":        true,
+		"kind":         e.Kind,
+		"examAt":       e.ExamAt,
+		"employeeName": e.EmployeeName,
+		"clientName":   e.ClientName,
+		"admitted":     e.Admitted,
+		"examinerName": e.ExaminerName,
+	})
+}
+
+// --- Журнал ---
+
+// loadShiftJournal - записи журнала за период: организация видит своих работников,
+// клиника - проведённые ею осмотры (clientBin сужает до одной организации)
+func loadShiftJournal(ctx context.Context, w http.ResponseWriter, r *http.Request) ([]*ShiftExam, time.Time, time.Time, bool) {
+	user, ok := requestUser(ctx, w, r)
+	if !ok {
+		return nil, time.Time{}, time.Time{}, false
+	}
+	q := r.URL.Query()
+	from, to := today(), today()
+	if v := q.Get("date"); v != "" {
+		t, err := parseDate(v)
+		if err != nil {
+			errorResponse(w, http.StatusBadRequest, "date must be YYYY-MM-DD")
+			return nil, from, to, false
+		}
+		from, to = t, t
+	}
+	if v := q.Get("from"); v != "" {
+		t, err := parseDate(v)
+		if err != nil {
+			errorResponse(w, http.StatusBadRequest, "from must be YYYY-MM-DD")
+			return nil, from, to, false
+		}
+		from = t
+	}
+	if v := q.Get("to"); v != "" {
+		t, err := parseDate(v)
+		if err != nil || t.Before(from) || t.Sub(from) > 92*24*time.Hour {
+			errorResponse(w, http.StatusBadRequest, "to must be YYYY-MM-DD within 92 days after from")
+			return nil, from, to, false
+		}
+		to = t
+	}
+
+	query := `SELECT ` + shiftExamColumns + ` FROM shift_exams WHERE shift_date BETWEEN $1 AND $2 AND `
+	args := []any{from, to}
+	switch {
+	case isClinicStaff(user):
+		args = append(args, userClinicID(user))
+		query += `clinic_id = $3`
+		if v := q.Get("clientBin"); v != "" {
+			args = append(args, v)
+			query += ` AND client_bin = $4`
+		}
+	case user.Role == UserRoleOrganization && user.BIN != nil:
+		args = append(args, *user.BIN)
+		query += `client_bin = $3`
+	default:
+		errorResponse(w, http.StatusForbidden, "access denied")
+		return nil, from, to, false
+	}
+	if v := q.Get("kind"); v != "" {
+		args = append(args, v)
+		query += fmt.Sprintf(" AND kind = $%d", len(args))
+	}
+	query += ` ORDER BY exam_at, id`
+
+	rows, err := db.Query(ctx, query, args...)
+	if err != nil {
+		log.Printf("shiftJournal error: %v", err)
+		errorResponse(w, http.StatusInternalServerError, "db error")
+		return nil, from, to, false
+	}
+	defer rows.Close()
+	res := []*ShiftExam{}
+	for rows.Next() {
+		e, _, err := scanShiftExam(rows)
+		if err != nil {
+			log.Printf("shiftJournal scan error: %v", err)
+			errorResponse(w, http.StatusInternalServerError, "db error")
+			return nil, from, to, false
+		}
+		res = append(res, e)
+	}
+	return res, from, to, true
+}
+
+// GET /api/shift-journal?date=|from=&to=[&clientBin=][&kind=]
+func shiftJournalHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	entries, _, _, ok := loadShiftJournal(ctx, w, r)
+	if !ok {
+		return
+	}
+	w.Header().Set("Cache-Control", "private, no-store")
+	jsonResponse(w, http.StatusOK, entries)
+}
+
+// journalRow - строка по колонкам формы Приложения 4 к Правилам
+func journalRow(e *ShiftExam) []string {
+	examAt, _ := time.Parse(time.RFC3339, e.ExamAt)
+	temperature := "—"
+	if e.Temperature != nil {
+		temperature = strconv.FormatFloat(*e.Temperature, 'f', 1, 64)
+	}
+	alcohol := alcoholTitles[e.AlcoholTest]
+	if e.AlcoholValue != "" {
+		alcohol += " (" + e.AlcoholValue + ")"
+	}
+	decision := "допущен"
+	if !e.Admitted {
+		decision = "не допущен: " + e.NotAdmittedCause
+	}
+	referral := decision
+	if e.Referral != "" {
+		referral += "; " + e.Referral
+	}
+	stamp := e.ExaminerName
+	if e.StampCode != "" {
+		stamp += " (код штампа " + e.StampCode + ")"
+	}
+	return []string{
+		examAt.Local().Format("02.01.2006 15:04"), e.EmployeeName, orDash(e.PersonnelNumber), orDash(e.Complaints),
+		fmt.Sprintf("%d/%d", e.BPSystolic, e.BPDiastolic), strconv.Itoa(e.Pulse), temperature, alcohol, referral, stamp, "",
+	}
+}
+
+var journalHeader = []string{
+	"Дата, время", "Ф.И.О.", "Табельный номер", "Жалоба", "Артериальное давление", "Пульс",
+	"Температура", "Проба на алкоголь, наркотические или психоактивные вещества",
+	"Допуск; направление к специалисту", "Подпись медицинского работника", "Подпись работника",
+}
+
+// GET /api/shift-journal/export?...&format=pdf|docx|csv
+func exportShiftJournalHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	entries, from, to, ok := loadShiftJournal(ctx, w, r)
+	if !ok {
+		return
+	}
+	period := from.Format("02.01.2006")
+	if !to.Equal(from) {
+		period += " – " + to.Format("02.01.2006")
+	}
+	name := "shift-journal-" + from.Format("2006-01-02")
+	format := r.URL.Query().Get("format")
+
+	if format == "csv" {
+		var buf bytes.Buffer
+		buf.WriteString("\ufeff") // BOM: Excel открывает UTF-8 без перекодировки
+		cw := csv.NewWriter(&buf)
+		cw.Comma = ';'
+		cw.Write(append([]string{"Организация", "Вид осмотра"}, journalHeader[:len(journalHeader)-1]...))
+		for _, e := range entries {
+			row := journalRow(e)
+			cw.Write(append([]string{e.ClientName, shiftExamTitles[e.Kind]}, row[:len(row)-1]...))
+		}
+		cw.Flush()
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.csv"`, name))
+		w.Write(buf.Bytes())
+		return
+	}
+
+	// Журнал по организациям: у каждой свой раздел
+	d := &reportDoc{footer: "Журнал предсменного и послесменного медицинского осмотра · " + period}
+	d.Title("Журнал проведения предсменного (предрейсового) и послесменного (послерейсового) медицинского осмотра")
+	d.Text("Период: " + period)
+	var clients []string
+	byClient := map[string][][]string{}
+	for _, e := range entries {
+		key := orDash(e.ClientName) + " (БИН " + e.ClientBIN + ")"
+		if _, seen := byClient[key]; !seen {
+			clients = append(clients, key)
+		}
+		byClient[key] = append(byClient[key], journalRow(e))
+	}
+	if len(clients) == 0 {
+		d.Text("Записей нет.")
+	}
+	widths := []float64{0.09, 0.13, 0.07, 0.1, 0.08, 0.06, 0.07, 0.11, 0.12, 0.1, 0.07}
+	for _, c := range clients {
+		d.Heading("Организация: " + c)
+		d.Table(widths, journalHeader, byClient[c])
+	}
+	// Штамп подписан ключом сервера, а не ЭЦП работника: графу 10 заверяют собственноручно
+	d.Text("В графе 10 указан код штампа допуска для сверки по номеру осмотра; он не заменяет подпись медицинского работника.")
+	writeReport(w, d, name, format)
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func testShiftExam() *ShiftExam {
+	return &ShiftExam{
+		ID: 17, Kind: ShiftExamPre, ExamAt: "2026-03-02T01:30:00Z", ClientBIN: "900", ClientName: "ТОО «Карьер»",
+		EmployeeName: "Иванов Иван", PersonnelNumber: "0042", BPSystolic: 125, BPDiastolic: 80, Pulse: 72,
+		AlcoholTest: AlcoholNegative, Admitted: true, ExaminerID: "nurse-1", ExaminerName: "Петрова А.",
+	}
+}
+
+func TestShiftStampVerifies(t *testing.T) {
+	e := testShiftExam()
+	signature := signShiftStamp(e)
+	code := stampCode(signature)
+	if len(code) != 9 || code[4] != '-' || code != strings.ToUpper(code) {
+		t.Fatalf("stamp code = %q", code)
+	}
+	if signShiftStamp(testShiftExam()) != signature {
+		t.Fatal("signature of the same record must not change")
+	}
+	if !verifyShiftStamp(e, signature, code) {
+		t.Fatal("genuine stamp rejected")
+	}
+	for _, bad := range []string{"", "0000-0000", strings.ToLower(code)} {
+		if verifyShiftStamp(e, signature, bad) {
+			t.Errorf("code %q accepted", bad)
+		}
+	}
+	if verifyShiftStamp(e, "", "") {
+		t.Error("record without a stamp accepted")
+	}
+}
+
+// Правка любого поля штампа в базе делает его недействительным
+func TestShiftStampRejectsEditedRecord(t *testing.T) {
+	signature := signShiftStamp(testShiftExam())
+	code := stampCode(signature)
+	for name, edit := range map[string]func(*ShiftExam){
+		"employee": func(e *ShiftExam) { e.EmployeeName = "Сидоров Сидор" },
+		"number":   func(e *ShiftExam) { e.PersonnelNumber = "0043" },
+		"client":   func(e *ShiftExam) { e.ClientBIN = "901" },
+		"time":     func(e *ShiftExam) { e.ExamAt = "2026-03-03T01:30:00Z" },
+		"kind":     func(e *ShiftExam) { e.Kind = ShiftExamPost },
+		"admitted": func(e *ShiftExam) { e.Admitted = false },
+		"examiner": func(e *ShiftExam) { e.ExaminerName = "Другой" },
+	} {
+		e := testShiftExam()
+		edit(e)
+		if verifyShiftStamp(e, signature, code) {
+			t.Errorf("%s: edited record still verifies", name)
+		}
+	}
+}
+
+func TestJournalRowShowsStampCode(t *testing.T) {
+	e := testShiftExam()
+	e.StampCode = stampCode(signShiftStamp(e))
+	row := journalRow(e)
+	if len(row) != len(journalHeader) {
+		t.Fatalf("row has %d columns, header %d", len(row), len(journalHeader))
+	}
+	if got := row[9]; got != "Петрова А. (код штампа "+e.StampCode+")" {
+		t.Errorf("examiner column = %q", got)
+	}
+	if row[8] != "допущен" || row[4] != "125/80" || row[7] != "отрицательная" {
+		t.Errorf("row = %q", row)
+	}
+}
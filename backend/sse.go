@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// --- Server-Sent Events ---
+//
+// Запасной канал для сетей, где прокси режут WebSocket. GET /api/events?userId=...
+// отдаёт те же кадры, что и /ws (JSON в data, id - номер события в журнале), через
+// ту же маршрутизацию хаба. Темы передаются сразу: ?topics=visit:42,contract:7 -
+// права проверяются как при подписке по WebSocket. Браузер сам присылает
+// Last-Event-ID при переподключении, и пропущенное досылается из журнала.
+
+var sseHeartbeat = 25 * time.Second
+
+// GET /api/events
+func sseHandler(dir topicDirectory, events eventLog) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			errorResponse(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+		user, ok := requestUser(ctx, w, r)
+		cancel()
+		if !ok {
+			return
+		}
+		serveSSE(w, r, hub, user, dir, events)
+	}
+}
+
+func serveSSE(w http.ResponseWriter, r *http.Request, h *Hub, user *User, dir topicDirectory, events eventLog) {
+	var topics []string
+	if raw := r.URL.Query().Get("topics"); raw != "" {
+		topics = strings.Split(raw, ",")
+		if len(topics) > maxClientTopics {
+			errorResponse(w, http.StatusBadRequest, errTooManyTopics.Error())
+			return
+		}
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	for _, topic := range topics {
+		err := authorizeTopic(ctx, dir, user, topic)
+		switch {
+		case errors.Is(err, errTopicInvalid):
+			errorResponse(w, http.StatusBadRequest, fmt.Sprintf("invalid topic %q", topic))
+			return
+		case errors.Is(err, errTopicDenied):
+			errorResponse(w, http.StatusForbidden, fmt.Sprintf("access denied to topic %q", topic))
+			return
+		case err != nil:
+			log.Printf("SSE authorize %s for %s: %v", topic, user.ID, err)
+			errorResponse(w, http.StatusInternalServerError, "db error")
+			return
+		}
+	}
+
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no") // nginx не должен копить поток
+	w.WriteHeader(http.StatusOK)
+
+	client := &Client{
+		ID:       fmt.Sprintf("sse_%d_%s", time.Now().UnixNano(), user.ID),
+		UserID:   user.ID,
+		Role:     user.Role,
+		ClinicID: connectionClinicID(user),
+		User:     user,
+		Send:     make(chan WebSocketMessage, clientSendBuffer),
+		Hub:      h,
+		dir:      dir,
+		events:   events,
+	}
+	h.Register(client)
+	defer h.Unregister(client)
+	if user.Role == UserRoleDoctor && user.DoctorID != nil {
+		h.Subscribe(client, doctorTopic(*user.DoctorID))
+	}
+	for _, topic := range topics {
+		h.Subscribe(client, topic)
+	}
+	log.Printf("SSE client connected: %s (user: %s)", client.ID, client.UserID)
+
+	write := func(chunk string) bool {
+		// У сервера общий WriteTimeout - продлеваем его перед каждой записью
+		rc.SetWriteDeadline(time.Now().Add(wsWriteWait))
+		if _, err := fmt.Fprint(w, chunk); err != nil {
+			return false
+		}
+		return rc.Flush() == nil
+	}
+	if !write("retry: 3000\n\n") {
+		return
+	}
+	if !write(sseFrame(newMessage("welcome", map[string]any{"protocol": wsProtocolVersion, "clientId": client.ID}))) {
+		return
+	}
+
+	// Досылка после Last-Event-ID: события по одному, чтобы браузер запоминал их id
+	lastEventID := parseLastEventID(r.Header.Get("Last-Event-ID"))
+	if lastEventID == 0 {
+		lastEventID = parseLastEventID(r.URL.Query().Get("lastEventId"))
+	}
+	if lastEventID > 0 && events != nil {
+		streams := h.streams(client)
+		missed, err := events.Since(ctx, streams, lastEventID, replayLimit)
+		if err != nil {
+			if !errors.Is(err, errResyncRequired) {
+				log.Printf("SSE replay for %s after %d: %v", user.ID, lastEventID, err)
+			}
+			missed = []WebSocketMessage{newMessage("resync_required", map[string]any{"lastEventId": lastEventID, "streams": streams})}
+		}
+		for _, m := range missed {
+			if !write(sseFrame(m)) {
+				return
+			}
+		}
+	}
+
+	heartbeat := time.NewTicker(sseHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case msg, ok := <-client.Send:
+			if !ok {
+				return // хаб отключил медленного клиента
+			}
+			if !write(sseFrame(msg)) {
+				return
+			}
+		case <-heartbeat.C:
+			if !write(": heartbeat\n\n") {
+				return
+			}
+		}
+	}
+}
+
+// sseFrame - событие SSE; data - тот же JSON, что уходит по WebSocket
+func sseFrame(msg WebSocketMessage) string {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		log.Printf("SSE encode %s: %v", msg.Type, err)
+		return ""
+	}
+	var b strings.Builder
+	if msg.ID > 0 {
+		fmt.Fprintf(&b, "id: %d\n", msg.ID)
+	}
+	fmt.Fprintf(&b, "data: %s\n\n", data)
+	return b.String()
+}
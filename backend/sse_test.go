@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// sseReader читает поток событий так же, как EventSource
+type sseReader struct {
+	t    *testing.T
+	scan *bufio.Scanner
+}
+
+type sseEvent struct {
+	id      string
+	comment bool
+	msg     WebSocketMessage
+}
+
+func (s *sseReader) next() sseEvent {
+	s.t.Helper()
+	var ev sseEvent
+	for s.scan.Scan() {
+		line := s.scan.Text()
+		switch {
+		case line == "":
+			if ev.comment || ev.msg.Type != "" {
+				return ev
+			}
+		case strings.HasPrefix(line, ":"):
+			ev.comment = true
+		case strings.HasPrefix(line, "id: "):
+			ev.id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "data: "):
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &ev.msg); err != nil {
+				s.t.Fatalf("bad data line %q: %v", line, err)
+			}
+		}
+	}
+	s.t.Fatalf("stream ended: %v", s.scan.Err())
+	return ev
+}
+
+func openSSE(t *testing.T, h *Hub, u *User, events eventLog, query, lastEventID string) (*http.Response, *sseReader) {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		serveSSE(w, r, h, u, testDirectory, events)
+	}))
+	t.Cleanup(srv.Close)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/api/events"+query, nil)
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp, &sseReader{t: t, scan: bufio.NewScanner(resp.Body)}
+}
+
+func waitClients(t *testing.T, h *Hub, n int) {
+	t.Helper()
+	for deadline := time.Now().Add(time.Second); h.ClientCount() != n; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("want %d clients, have %d", n, h.ClientCount())
+		}
+	}
+}
+
+func TestSSEResumesAndStreamsClinicEvents(t *testing.T) {
+	h := NewHub()
+	events := &memEventLog{}
+	ctx := context.Background()
+	first, _ := events.Append(ctx, clinicEvent("clinic-a", "visit_started"))
+	events.Append(ctx, clinicEvent("clinic-b", "visit_started"))
+	missed, _ := events.Append(ctx, clinicEvent("clinic-a", "visit_updated"))
+
+	resp, stream := openSSE(t, h, regA, events, "", strconv.FormatInt(first, 10))
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Content-Type = %q", ct)
+	}
+	if ev := stream.next(); ev.msg.Type != "welcome" {
+		t.Fatalf("want welcome first, got %+v", ev)
+	}
+	// Досылается только своя клиника, с id для следующего Last-Event-ID
+	if ev := stream.next(); ev.msg.Type != "visit_updated" || ev.id != strconv.FormatInt(missed, 10) {
+		t.Fatalf("replayed %+v, want visit_updated id %d", ev, missed)
+	}
+
+	waitClients(t, h, 1)
+	h.BroadcastToClinic("clinic-b", WebSocketMessage{Type: "foreign", ID: 10})
+	h.BroadcastToClinic("clinic-a", WebSocketMessage{Type: "visit_started", ID: 11})
+	if ev := stream.next(); ev.msg.Type != "visit_started" || ev.id != "11" {
+		t.Fatalf("live event: got %+v", ev)
+	}
+
+	// Клиент ушёл - хаб его забывает
+	resp.Body.Close()
+	h.BroadcastToClinic("clinic-a", WebSocketMessage{Type: "visit_updated"})
+	waitClients(t, h, 0)
+}
+
+func TestSSEHeartbeatAndResync(t *testing.T) {
+	defer func(d time.Duration) { sseHeartbeat = d }(sseHeartbeat)
+	sseHeartbeat = 20 * time.Millisecond
+
+	h := NewHub()
+	events := &memEventLog{}
+	for i := 0; i < 3; i++ {
+		events.Append(context.Background(), clinicEvent("clinic-a", "visit_updated"))
+	}
+	events.prune(2)
+
+	_, stream := openSSE(t, h, clinicA, events, "?lastEventId=1", "")
+	stream.next() // welcome
+	if ev := stream.next(); ev.msg.Type != "resync_required" {
+		t.Fatalf("pruned gap: want resync_required, got %+v", ev)
+	}
+	if ev := stream.next(); !ev.comment {
+		t.Fatalf("want heartbeat comment, got %+v", ev)
+	}
+}
+
+func TestSSEChecksTopicAccess(t *testing.T) {
+	h := NewHub()
+	cases := map[string]int{
+		"?topics=visit:2":            http.StatusForbidden, // визит другой клиники
+		"?topics=visit:1,contract:2": http.StatusForbidden,
+		"?topics=role:doctor":        http.StatusBadRequest,
+	}
+	for query, want := range cases {
+		resp, _ := openSSE(t, h, doctorA, nil, query, "")
+		if resp.StatusCode != want {
+			t.Errorf("%s: want %d, got %d", query, want, resp.StatusCode)
+		}
+	}
+	if h.ClientCount() != 0 {
+		t.Errorf("rejected streams must not be registered")
+	}
+
+	_, stream := openSSE(t, h, doctorA, nil, "?topics=visit:1", "")
+	stream.next() // welcome
+	waitClients(t, h, 1)
+	h.Publish(visitTopic(2), WebSocketMessage{Type: "foreign"})
+	h.Publish(visitTopic(1), WebSocketMessage{Type: "visit_updated"})
+	if ev := stream.next(); ev.msg.Type != "visit_updated" {
+		t.Fatalf("subscribed topic: got %+v", ev)
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Тестовый вектор get-vanilla из набора AWS SigV4
+func TestSignAWSv4Vector(t *testing.T) {
+	req, _ := http.NewRequest(http.MethodGet, "https://example.amazonaws.com/", nil)
+	now := time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+	signAWSv4(req, emptyPayloadHash, "AKIDEXAMPLE", "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY", "us-east-1", "service", now)
+
+	want := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, " +
+		"SignedHeaders=host;x-amz-date, " +
+		"Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31"
+	if got := req.Header.Get("Authorization"); got != want {
+		t.Fatalf("Authorization =\n%s\nwant\n%s", got, want)
+	}
+}
+
+// fakeS3 - локальная замена MinIO: бакет в памяти и проверка подписи запросов
+type fakeS3 struct {
+	mu      sync.Mutex
+	bucket  string
+	access  string
+	secret  string
+	objects map[string][]byte
+	types   map[string]string
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	sum := sha256.Sum256(body)
+	if got := r.Header.Get("X-Amz-Content-Sha256"); got != hex.EncodeToString(sum[:]) {
+		http.Error(w, "XAmzContentSHA256Mismatch", http.StatusBadRequest)
+		return
+	}
+
+	// Пересчитываем подпись по тому, что реально пришло по сети
+	amzDate, err := time.Parse("20060102T150405Z", r.Header.Get("X-Amz-Date"))
+	if err != nil {
+		http.Error(w, "AccessDenied", http.StatusForbidden)
+		return
+	}
+	check, _ := http.NewRequest(r.Method, "http://"+r.Host+r.URL.RequestURI(), nil)
+	for _, h := range []string{"Content-Type", "X-Amz-Content-Sha256"} {
+		if v := r.Header.Get(h); v != "" {
+			check.Header.Set(h, v)
+		}
+	}
+	signAWSv4(check, r.Header.Get("X-Amz-Content-Sha256"), f.access, f.secret, "us-east-1", "s3", amzDate)
+	if check.Header.Get("Authorization") != r.Header.Get("Authorization") {
+		http.Error(w, "SignatureDoesNotMatch", http.StatusForbidden)
+		return
+	}
+
+	prefix := "/" + f.bucket + "/"
+	if !strings.HasPrefix(r.URL.Path, prefix) {
+		http.Error(w, "NoSuchBucket", http.StatusNotFound)
+		return
+	}
+	key := strings.TrimPrefix(r.URL.Path, prefix)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch r.Method {
+	case http.MethodPut:
+		f.objects[key] = body
+		f.types[key] = r.Header.Get("Content-Type")
+	case http.MethodGet:
+		obj, ok := f.objects[key]
+		if !ok {
+			http.Error(w, "NoSuchKey", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", f.types[key])
+		w.Write(obj)
+	case http.MethodDelete:
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "MethodNotAllowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func exerciseBlobStore(t *testing.T, store blobStore) {
+	t.Helper()
+	ctx := context.Background()
+	content := []byte("%PDF-1.4\nlab report")
+	sum := sha256.Sum256(content)
+	sumHex := hex.EncodeToString(sum[:])
+	key := "sha256/" + sumHex[:2] + "/" + sumHex
+
+	if err := store.Put(ctx, key, bytes.NewReader(content), int64(len(content)), sumHex, "application/pdf"); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	rc, err := store.Get(ctx, key)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	got, _ := io.ReadAll(rc)
+	rc.Close()
+	if !bytes.Equal(got, content) {
+		t.Fatalf("Get returned %q", got)
+	}
+
+	if err := store.Delete(ctx, key); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := store.Get(ctx, key); !errors.Is(err, errBlobNotFound) {
+		t.Fatalf("Get after delete: %v, want errBlobNotFound", err)
+	}
+	if err := store.Delete(ctx, key); err != nil {
+		t.Fatalf("second Delete: %v", err)
+	}
+}
+
+func TestLocalBlobStore(t *testing.T) {
+	store, err := newLocalBlobStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	exerciseBlobStore(t, store)
+}
+
+func TestS3BlobStore(t *testing.T) {
+	fake := &fakeS3{bucket: "attachments", access: "minio", secret: "minio-secret", objects: map[string][]byte{}, types: map[string]string{}}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	store := &s3BlobStore{Endpoint: srv.URL, Bucket: "attachments", Region: "us-east-1", AccessKey: "minio", SecretKey: "minio-secret", Client: srv.Client()}
+	exerciseBlobStore(t, store)
+
+	wrong := *store
+	wrong.SecretKey = "other"
+	xSum := sha256.Sum256([]byte("x"))
+	err := wrong.Put(context.Background(), "k", strings.NewReader("x"), 1, hex.EncodeToString(xSum[:]), "")
+	if err == nil {
+		t.Fatal("expected signature error for wrong secret")
+	}
+}
+
+func TestSignedAttachmentURL(t *testing.T) {
+	now := time.Now()
+	link := signedAttachmentURL(42, "user-1", now)
+	req := httptest.NewRequest(http.MethodGet, link, nil)
+	q := req.URL.Query()
+
+	if err := verifyAttachmentURL(42, q, now); err != nil {
+		t.Fatalf("fresh link rejected: %v", err)
+	}
+	if err := verifyAttachmentURL(43, q, now); err == nil {
+		t.Fatal("link for another attachment accepted")
+	}
+	if err := verifyAttachmentURL(42, q, now.Add(attachmentURLTTL()+time.Second)); err == nil {
+		t.Fatal("expired link accepted")
+	}
+	q.Set("uid", "user-2")
+	if err := verifyAttachmentURL(42, q, now); err == nil {
+		t.Fatal("link with another uid accepted")
+	}
+}
+
+func TestDetectAttachmentType(t *testing.T) {
+	dicom := append(make([]byte, 128), []byte("DICM....")...)
+	cases := map[string][]byte{
+		"application/pdf":   []byte("%PDF-1.7\n"),
+		"image/png":         []byte("\x89PNG\r\n\x1a\n0000"),
+		"image/jpeg":        []byte("\xff\xd8\xff\xe0"),
+		"application/dicom": dicom,
+		"text/html":         []byte("<html><script>"),
+	}
+	for want, head := range cases {
+		if got := detectAttachmentType(head); got != want {
+			t.Errorf("detectAttachmentType(%q...) = %q, want %q", head[:4], got, want)
+		}
+	}
+	if allowedAttachmentTypes["text/html"] {
+		t.Error("html must not be accepted")
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// --- Темы WebSocket ---
+//
+// Событие уходит только подписчикам темы, а не всем пользователям роли.
+// Темы: clinic:{uid}, contract:{id}, visit:{id}, doctor:{id}, card:{patientUid}.
+// На тему клиники сотрудник подписан при подключении; на остальные клиент
+// подписывается сам сообщением {"type":"subscribe","topic":"visit:42"} (ws_protocol.go),
+// и права проверяются в момент подписки.
+
+const maxClientTopics = 64
+
+var (
+	errClientGone    = errors.New("client is disconnected")
+	errTooManyTopics = errors.New("too many subscriptions")
+	errTopicInvalid  = errors.New("unknown topic")
+	errTopicDenied   = errors.New("access denied")
+)
+
+func clinicTopic(clinicID string) string { return "clinic:" + clinicID }
+func contractTopic(id int64) string      { return "contract:" + strconv.FormatInt(id, 10) }
+func visitTopic(id int64) string         { return "visit:" + strconv.FormatInt(id, 10) }
+func doctorTopic(id string) string       { return "doctor:" + id }
+
+// connectionClinicID - клиника, на тему которой подключение подписано сразу.
+// У работников тоже есть clinic_id, но события клиники им не положены.
+func connectionClinicID(u *User) string {
+	if !isClinicStaff(u) {
+		return ""
+	}
+	return userClinicID(u)
+}
+
+// topicDirectory - сведения о ресурсах, нужные для проверки подписки
+type topicDirectory interface {
+	ContractParties(ctx context.Context, contractID int64) (*contractParties, error)
+	// VisitScope - клиника визита и работник (employee_id), которого осматривают
+	VisitScope(ctx context.Context, visitID int64) (clinicID, employeeID string, err error)
+	DoctorClinic(ctx context.Context, doctorID int64) (string, error)
+	// PatientInClinic - у клиники есть визит или эпизод осмотра пациента
+	PatientInClinic(ctx context.Context, patientUID, clinicID string) (bool, error)
+}
+
+// authorizeTopic проверяет, что пользователь вправе получать события темы:
+//   - клиника - только её сотрудники;
+//   - договор - стороны договора;
+//   - визит - сотрудники клиники визита и сам работник (работодатель медицинские данные не видит);
+//   - врач - сам врач, клиника и регистратура его клиники;
+//   - карта - сотрудники клиники, которая осматривает пациента.
+func authorizeTopic(ctx context.Context, dir topicDirectory, u *User, topic string) error {
+	kind, key, ok := strings.Cut(topic, ":")
+	if !ok || key == "" {
+		return errTopicInvalid
+	}
+	if kind == "clinic" {
+		if isClinicStaff(u) && userClinicID(u) == key {
+			return nil
+		}
+		return errTopicDenied
+	}
+	if kind == "card" {
+		if !isClinicStaff(u) {
+			return errTopicDenied
+		}
+		ok, err := dir.PatientInClinic(ctx, key, userClinicID(u))
+		if err != nil {
+			return err
+		}
+		if ok {
+			return nil
+		}
+		return errTopicDenied
+	}
+	id, err := strconv.ParseInt(key, 10, 64)
+	if err != nil || id <= 0 {
+		return errTopicInvalid
+	}
+
+	switch kind {
+	case "contract":
+		parties, err := dir.ContractParties(ctx, id)
+		if err == pgx.ErrNoRows {
+			return errTopicDenied
+		}
+		if err != nil {
+			return err
+		}
+		if parties.isClinicSide(u) || parties.isClientSide(u) {
+			return nil
+		}
+	case "visit":
+		clinicID, employeeID, err := dir.VisitScope(ctx, id)
+		if err == pgx.ErrNoRows {
+			return errTopicDenied
+		}
+		if err != nil {
+			return err
+		}
+		if u.Role == UserRoleEmployee {
+			if u.ID == employeeID || (u.EmployeeID != nil && *u.EmployeeID == employeeID) {
+				return nil
+			}
+		} else if isClinicStaff(u) && userClinicID(u) == clinicID {
+			return nil
+		}
+	case "doctor":
+		if u.Role == UserRoleDoctor {
+			if u.DoctorID != nil && *u.DoctorID == key {
+				return nil
+			}
+			return errTopicDenied
+		}
+		if !isClinicStaff(u) {
+			return errTopicDenied
+		}
+		clinicID, err := dir.DoctorClinic(ctx, id)
+		if err == pgx.ErrNoRows {
+			return errTopicDenied
+		}
+		if err != nil {
+			return err
+		}
+		if clinicID == userClinicID(u) {
+			return nil
+		}
+	default:
+		return errTopicInvalid
+	}
+	return errTopicDenied
+}
+
+// --- Справочник в Postgres ---
+
+type pgTopicDirectory struct{}
+
+func (pgTopicDirectory) ContractParties(ctx context.Context, contractID int64) (*contractParties, error) {
+	return loadContractParties(ctx, contractID)
+}
+
+func (pgTopicDirectory) VisitScope(ctx context.Context, visitID int64) (string, string, error) {
+	var clinicID, employeeID string
+	err := db.QueryRow(ctx, `SELECT clinic_id, employee_id FROM employee_visits WHERE id = $1`, visitID).Scan(&clinicID, &employeeID)
+	return clinicID, employeeID, err
+}
+
+func (pgTopicDirectory) DoctorClinic(ctx context.Context, doctorID int64) (string, error) {
+	var clinicUID string
+	err := db.QueryRow(ctx, `SELECT clinic_uid FROM doctors WHERE id = $1`, doctorID).Scan(&clinicUID)
+	return clinicUID, err
+}
+
+func (pgTopicDirectory) PatientInClinic(ctx context.Context, patientUID, clinicID string) (bool, error) {
+	var ok bool
+	err := db.QueryRow(ctx, `
+SELECT EXISTS (SELECT 1 FROM employee_visits WHERE employee_id = $1 AND clinic_id = $2)
+    OR EXISTS (SELECT 1 FROM exam_episodes WHERE patient_uid = $1 AND clinic_id = $2)
+`, patientUID, clinicID).Scan(&ok)
+	return ok, err
+}
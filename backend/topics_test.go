@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// memTopicDirectory - две клиники, у каждой свой врач, визит и договор
+type memTopicDirectory struct {
+	contracts map[int64]*contractParties
+	visits    map[int64][2]string // клиника, работник
+	doctors   map[int64]string
+}
+
+func (d memTopicDirectory) ContractParties(ctx context.Context, id int64) (*contractParties, error) {
+	if p, ok := d.contracts[id]; ok {
+		return p, nil
+	}
+	return nil, pgx.ErrNoRows
+}
+
+func (d memTopicDirectory) VisitScope(ctx context.Context, id int64) (string, string, error) {
+	if v, ok := d.visits[id]; ok {
+		return v[0], v[1], nil
+	}
+	return "", "", pgx.ErrNoRows
+}
+
+func (d memTopicDirectory) DoctorClinic(ctx context.Context, id int64) (string, error) {
+	if c, ok := d.doctors[id]; ok {
+		return c, nil
+	}
+	return "", pgx.ErrNoRows
+}
+
+func (d memTopicDirectory) PatientInClinic(ctx context.Context, patientUID, clinicID string) (bool, error) {
+	for _, v := range d.visits {
+		if v[0] == clinicID && v[1] == patientUID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+var testDirectory = memTopicDirectory{
+	contracts: map[int64]*contractParties{
+		1: {ClinicBIN: "111", ClientBIN: "900", ClinicUserID: "clinic-a", OrgUserID: "org-a"},
+		2: {ClinicBIN: "222", ClientBIN: "800", ClinicUserID: "clinic-b", OrgUserID: "org-b"},
+	},
+	visits:  map[int64][2]string{1: {"clinic-a", "emp-a"}, 2: {"clinic-b", "emp-b"}},
+	doctors: map[int64]string{10: "clinic-a", 20: "clinic-b"},
+}
+
+func strp(s string) *string { return &s }
+
+var (
+	clinicA  = &User{ID: "clinic-a", Role: UserRoleClinic, BIN: strp("111")}
+	clinicB  = &User{ID: "clinic-b", Role: UserRoleClinic, BIN: strp("222")}
+	doctorA  = &User{ID: "doc-a", Role: UserRoleDoctor, DoctorID: strp("10"), ClinicID: strp("clinic-a"), ClinicBIN: strp("111")}
+	doctorB  = &User{ID: "doc-b", Role: UserRoleDoctor, DoctorID: strp("20"), ClinicID: strp("clinic-b"), ClinicBIN: strp("222")}
+	regA     = &User{ID: "reg-a", Role: UserRoleRegistration, ClinicID: strp("clinic-a"), ClinicBIN: strp("111")}
+	orgA     = &User{ID: "org-a", Role: UserRoleOrganization, BIN: strp("900")}
+	employeA = &User{ID: "emp-a", Role: UserRoleEmployee, EmployeeID: strp("emp-a"), ClinicID: strp("clinic-a")}
+)
+
+func TestAuthorizeTopicIsolatesClinics(t *testing.T) {
+	cases := []struct {
+		user  *User
+		topic string
+		want  error
+	}{
+		{clinicA, "clinic:clinic-a", nil},
+		{doctorA, "clinic:clinic-a", nil},
+		{doctorB, "clinic:clinic-a", errTopicDenied},
+		{orgA, "clinic:clinic-a", errTopicDenied},
+		{employeA, "clinic:clinic-a", errTopicDenied}, // осматривается в клинике, но не работает в ней
+
+		{clinicA, "contract:1", nil},
+		{regA, "contract:1", nil},
+		{orgA, "contract:1", nil},
+		{clinicB, "contract:1", errTopicDenied},
+		{orgA, "contract:2", errTopicDenied},
+		{employeA, "contract:1", errTopicDenied},
+
+		{doctorA, "visit:1", nil},
+		{employeA, "visit:1", nil},
+		{doctorB, "visit:1", errTopicDenied},
+		{employeA, "visit:2", errTopicDenied},
+		{orgA, "visit:1", errTopicDenied}, // работодатель - только через договор
+		{doctorA, "visit:99", errTopicDenied},
+
+		{doctorA, "doctor:10", nil},
+		{regA, "doctor:10", nil},
+		{clinicA, "doctor:10", nil},
+		{doctorA, "doctor:20", errTopicDenied},
+		{clinicA, "doctor:20", errTopicDenied},
+		{clinicB, "doctor:10", errTopicDenied},
+
+		{doctorA, "card:emp-a", nil},
+		{regA, "card:emp-a", nil},
+		{doctorB, "card:emp-a", errTopicDenied}, // пациент другой клиники
+		{employeA, "card:emp-a", errTopicDenied},
+		{orgA, "card:emp-a", errTopicDenied},
+
+		{clinicA, "role:doctor", errTopicInvalid},
+		{clinicA, "visit:abc", errTopicInvalid},
+		{clinicA, "clinic:", errTopicInvalid},
+	}
+	for _, tc := range cases {
+		err := authorizeTopic(context.Background(), testDirectory, tc.user, tc.topic)
+		if !errors.Is(err, tc.want) && err != tc.want {
+			t.Errorf("%s -> %s: got %v, want %v", tc.user.ID, tc.topic, err, tc.want)
+		}
+	}
+}
+
+func connectTestUser(h *Hub, u *User) *Client {
+	c := newTestClient(h, "ws-"+u.ID, u.ID, u.Role, connectionClinicID(u), 16)
+	c.User = u
+	c.dir = testDirectory
+	h.Register(c)
+	return c
+}
+
+func received(c *Client) []WebSocketMessage {
+	var out []WebSocketMessage
+	for len(c.Send) > 0 {
+		out = append(out, <-c.Send)
+	}
+	return out
+}
+
+func subscribe(t *testing.T, c *Client, topic string) string {
+	t.Helper()
+	raw, _ := json.Marshal(clientFrame{Type: "subscribe", Topic: topic})
+	c.handleFrame(raw)
+	msgs := received(c)
+	if len(msgs) != 1 {
+		t.Fatalf("%s subscribe %s: want one reply, got %v", c.UserID, topic, msgs)
+	}
+	return msgs[0].Type
+}
+
+func TestClinicEventsDoNotCrossClinics(t *testing.T) {
+	h := NewHub()
+	a := []*Client{connectTestUser(h, clinicA), connectTestUser(h, doctorA), connectTestUser(h, regA)}
+	b := []*Client{connectTestUser(h, clinicB), connectTestUser(h, doctorB)}
+	outsiders := []*Client{connectTestUser(h, orgA), connectTestUser(h, employeA)}
+
+	h.BroadcastToClinic("clinic-a", WebSocketMessage{Type: "visit_started", Data: map[string]any{"employeeName": "Иванов"}})
+
+	for _, c := range a {
+		if msgs := received(c); len(msgs) != 1 || msgs[0].Type != "visit_started" {
+			t.Errorf("%s must receive its clinic event, got %v", c.UserID, msgs)
+		}
+	}
+	for _, c := range append(b, outsiders...) {
+		if msgs := received(c); len(msgs) != 0 {
+			t.Errorf("%s received a foreign clinic event: %v", c.UserID, msgs)
+		}
+	}
+}
+
+// Врач из маршрутного листа подписан и на клинику, и на свою тему - событие приходит один раз
+func TestEventForSeveralTopicsIsDeliveredOnce(t *testing.T) {
+	h := NewHub()
+	doctor := connectTestUser(h, doctorA)
+	h.Subscribe(doctor, doctorTopic("10"))
+	reg := connectTestUser(h, regA)
+	foreign := connectTestUser(h, doctorB)
+
+	deliverLocal(h, busEvent{Scope: busScopeTopic, Keys: []string{clinicTopic("clinic-a"), doctorTopic("10")},
+		Message: newMessage("visit_started", nil)})
+	for _, c := range []*Client{doctor, reg} {
+		if msgs := received(c); len(msgs) != 1 {
+			t.Errorf("%s: want the event once, got %v", c.UserID, msgs)
+		}
+	}
+	if msgs := received(foreign); len(msgs) != 0 {
+		t.Errorf("foreign doctor: got %v", msgs)
+	}
+}
+
+func TestSubscribeChecksAccessAndIsolatesTopics(t *testing.T) {
+	h := NewHub()
+	docA := connectTestUser(h, doctorA)
+	docB := connectTestUser(h, doctorB)
+	org := connectTestUser(h, orgA)
+	emp := connectTestUser(h, employeA)
+
+	if got := subscribe(t, docA, "visit:1"); got != "subscribed" {
+		t.Fatalf("doctor of the visit clinic: %s", got)
+	}
+	if got := subscribe(t, emp, "visit:1"); got != "subscribed" {
+		t.Fatalf("examined employee: %s", got)
+	}
+	if got := subscribe(t, docB, "visit:1"); got != "error" {
+		t.Fatalf("doctor of another clinic must be denied, got %s", got)
+	}
+	if got := subscribe(t, org, "visit:1"); got != "error" {
+		t.Fatalf("employer must be denied the visit topic, got %s", got)
+	}
+	if got := subscribe(t, org, "contract:1"); got != "subscribed" {
+		t.Fatalf("contract client: %s", got)
+	}
+	if got := subscribe(t, docB, "contract:1"); got != "error" {
+		t.Fatalf("foreign clinic must be denied the contract topic, got %s", got)
+	}
+
+	h.Publish(visitTopic(1), WebSocketMessage{Type: "visit_updated"})
+	h.Publish(visitTopic(2), WebSocketMessage{Type: "visit_updated"})
+	h.Publish(contractTopic(1), WebSocketMessage{Type: "visit_started"})
+
+	expect := map[*Client]int{docA: 1, emp: 1, docB: 0, org: 1}
+	for c, n := range expect {
+		if msgs := received(c); len(msgs) != n {
+			t.Errorf("%s: want %d events, got %v", c.UserID, n, msgs)
+		}
+	}
+
+	// После отписки события темы не приходят
+	raw, _ := json.Marshal(clientFrame{Type: "unsubscribe", Topic: "visit:1"})
+	docA.handleFrame(raw)
+	received(docA)
+	h.Publish(visitTopic(1), WebSocketMessage{Type: "visit_updated"})
+	if msgs := received(docA); len(msgs) != 0 {
+		t.Errorf("unsubscribed client received %v", msgs)
+	}
+	h.Unregister(docA)
+	if _, ok := h.byTopic[visitTopic(1)][docA]; ok {
+		t.Error("disconnected client is still subscribed")
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+)
+
+// --- Протокол клиент -> сервер ---
+//
+// Клиент шлёт JSON-кадры {"v":1,"id":"...","type":"...", ...}. id - произвольная строка,
+// сервер возвращает её в replyTo ответа, чтобы клиент сопоставил запрос и ответ.
+// Схема кадров в обе стороны - ws_protocol.schema.json (GET /api/ws/protocol);
+// при несовместимых изменениях поднимаем wsProtocolVersion и $id схемы.
+
+const (
+	wsProtocolVersion = 1
+	maxClientFrame    = 4096 // байт
+	maxAckBatch       = 100
+)
+
+// Типы кадров от клиента
+const (
+	frameSubscribe   = "subscribe"
+	frameUnsubscribe = "unsubscribe"
+	framePing        = "ping"
+	frameAck         = "ack"
+	framePresence    = "presence"
+	frameLeave       = "leave"
+	frameLock        = "lock"
+	frameUnlock      = "unlock"
+	// Старый клиент после подключения присылает user_connected; принимаем без ответа
+	frameLegacyHello = "user_connected"
+)
+
+// Коды ошибок в кадре error
+const (
+	wsErrBadFrame           = "bad_frame"
+	wsErrUnsupportedVersion = "unsupported_version"
+	wsErrUnknownType        = "unknown_type"
+	wsErrInvalidTopic       = "invalid_topic"
+	wsErrForbidden          = "forbidden"
+	wsErrTooManyTopics      = "too_many_subscriptions"
+	wsErrSectionLocked      = "section_locked"
+	wsErrInternal           = "internal_error"
+)
+
+//go:embed ws_protocol.schema.json
+var wsProtocolSchema []byte
+
+// clientFrame - кадр от клиента; поля кроме type зависят от типа
+type clientFrame struct {
+	V     int    `json:"v,omitempty"`
+	ID    string `json:"id,omitempty"`
+	Type  string `json:"type"`
+	Topic string `json:"topic,omitempty"`
+	// LastEventID - при повторной подписке: дослать события темы после этого id
+	LastEventID int64 `json:"lastEventId,omitempty"`
+	// EventIDs - подтверждаемые события (ack)
+	EventIDs []int64 `json:"eventIds,omitempty"`
+	// SentAt - время клиента в мс (ping), возвращается в pong для расчёта задержки
+	SentAt int64 `json:"sentAt,omitempty"`
+	// Card, VisitID, State, Section - присутствие и блокировки в амбулаторной карте (presence.go)
+	Card    string `json:"card,omitempty"`
+	VisitID int64  `json:"visitId,omitempty"`
+	State   string `json:"state,omitempty"`
+	Section string `json:"section,omitempty"`
+}
+
+// handleFrame разбирает кадр клиента; ответ уходит только этому клиенту
+func (c *Client) handleFrame(raw []byte) {
+	var f clientFrame
+	if err := json.Unmarshal(raw, &f); err != nil || f.Type == "" {
+		c.replyError(f, wsErrBadFrame, "frame must be a JSON object with a type")
+		return
+	}
+	if f.V > wsProtocolVersion {
+		c.replyError(f, wsErrUnsupportedVersion, "supported protocol version is 1")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	switch f.Type {
+	case frameSubscribe:
+		err := authorizeTopic(ctx, c.dir, c.User, f.Topic)
+		if err == nil {
+			err = c.Hub.Subscribe(c, f.Topic)
+		}
+		switch {
+		case err == nil:
+			c.reply(f, "subscribed", map[string]any{"topic": f.Topic})
+			replayEvents(ctx, c.events, c, []string{f.Topic}, f.LastEventID)
+		case errors.Is(err, errClientGone):
+		case errors.Is(err, errTopicInvalid):
+			c.replyError(f, wsErrInvalidTopic, err.Error())
+		case errors.Is(err, errTopicDenied):
+			c.replyError(f, wsErrForbidden, err.Error())
+		case errors.Is(err, errTooManyTopics):
+			c.replyError(f, wsErrTooManyTopics, err.Error())
+		default:
+			log.Printf("WebSocket subscribe %s for %s: %v", f.Topic, c.UserID, err)
+			c.replyError(f, wsErrInternal, "internal error")
+		}
+
+	case frameUnsubscribe:
+		if f.Topic == "" {
+			c.replyError(f, wsErrInvalidTopic, "topic is required")
+			return
+		}
+		c.Hub.Unsubscribe(c, f.Topic)
+		c.reply(f, "unsubscribed", map[string]any{"topic": f.Topic})
+
+	case framePing:
+		c.reply(f, "pong", map[string]any{"sentAt": f.SentAt, "serverTime": time.Now().UnixMilli()})
+
+	case frameAck:
+		if len(f.EventIDs) == 0 || len(f.EventIDs) > maxAckBatch {
+			c.replyError(f, wsErrBadFrame, "eventIds must contain 1..100 ids")
+			return
+		}
+		acked := 0
+		if c.events != nil {
+			n, err := c.events.Ack(ctx, c.UserID, c.Hub.streams(c), f.EventIDs)
+			if err != nil {
+				log.Printf("WebSocket ack for %s: %v", c.UserID, err)
+				c.replyError(f, wsErrInternal, "internal error")
+				return
+			}
+			acked = n
+		}
+		c.reply(f, "acked", map[string]any{"eventIds": f.EventIDs, "acked": acked})
+
+	case framePresence, frameLeave, frameLock, frameUnlock:
+		c.handlePresenceFrame(ctx, f)
+
+	case frameLegacyHello:
+
+	default:
+		c.replyError(f, wsErrUnknownType, "unknown message type "+f.Type)
+	}
+}
+
+func (c *Client) reply(f clientFrame, messageType string, data map[string]any) {
+	msg := newMessage(messageType, data)
+	msg.ReplyTo = f.ID
+	c.Hub.SendTo(c, msg)
+}
+
+func (c *Client) replyError(f clientFrame, code, message string) {
+	c.reply(f, "error", map[string]any{"code": code, "message": message, "requestType": f.Type})
+}
+
+// GET /api/ws/protocol - JSON-схема протокола
+func wsProtocolSchemaHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		errorResponse(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	w.Header().Set("Content-Type", "application/schema+json")
+	w.Write(wsProtocolSchema)
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"testing"
+)
+
+func sendFrame(t *testing.T, c *Client, frame string) WebSocketMessage {
+	t.Helper()
+	c.handleFrame([]byte(frame))
+	msgs := received(c)
+	if len(msgs) != 1 {
+		t.Fatalf("frame %s: want one reply, got %v", frame, msgs)
+	}
+	return msgs[0]
+}
+
+func errorCode(m WebSocketMessage) string {
+	if m.Type != "error" {
+		return ""
+	}
+	return m.Data.(map[string]any)["code"].(string)
+}
+
+func TestProtocolCorrelatesRepliesAndRejectsBadFrames(t *testing.T) {
+	h := NewHub()
+	c := connectTestUser(h, doctorA)
+
+	pong := sendFrame(t, c, `{"v":1,"id":"p-1","type":"ping","sentAt":1700000000000}`)
+	if pong.Type != "pong" || pong.ReplyTo != "p-1" || pong.Data.(map[string]any)["sentAt"] != int64(1700000000000) {
+		t.Errorf("ping: got %+v", pong)
+	}
+
+	sub := sendFrame(t, c, `{"id":"s-1","type":"subscribe","topic":"visit:1"}`)
+	if sub.Type != "subscribed" || sub.ReplyTo != "s-1" {
+		t.Errorf("subscribe: got %+v", sub)
+	}
+	unsub := sendFrame(t, c, `{"id":"u-1","type":"unsubscribe","topic":"visit:1"}`)
+	if unsub.Type != "unsubscribed" || unsub.ReplyTo != "u-1" {
+		t.Errorf("unsubscribe: got %+v", unsub)
+	}
+
+	cases := map[string]string{
+		`{"id":"x","type":"shout"}`:      wsErrUnknownType,
+		`not json`:                       wsErrBadFrame,
+		`{"id":"x"}`:                     wsErrBadFrame,
+		`{"v":2,"id":"x","type":"ping"}`: wsErrUnsupportedVersion,
+		`{"id":"x","type":"subscribe","topic":"visit:2"}`: wsErrForbidden,
+		`{"id":"x","type":"subscribe","topic":"role:x"}`:  wsErrInvalidTopic,
+		`{"id":"x","type":"unsubscribe"}`:                 wsErrInvalidTopic,
+		`{"id":"x","type":"ack","eventIds":[]}`:           wsErrBadFrame,
+	}
+	for frame, code := range cases {
+		if got := sendFrame(t, c, frame); errorCode(got) != code {
+			t.Errorf("%s: want error %s, got %+v", frame, code, got)
+		}
+	}
+
+	// Старый клиент присылает user_connected - без ответа и без ошибки
+	c.handleFrame([]byte(`{"type":"user_connected","data":{"userId":"doc-a"}}`))
+	if msgs := received(c); len(msgs) != 0 {
+		t.Errorf("legacy hello must be accepted silently, got %v", msgs)
+	}
+}
+
+func TestProtocolAcksLoggedEvents(t *testing.T) {
+	h := NewHub()
+	events := &memEventLog{}
+	c := connectTestUser(h, doctorA)
+	c.events = events
+	id, _ := events.Append(context.Background(), clinicEvent("clinic-a", "visit_started"))
+
+	reply := sendFrame(t, c, fmt.Sprintf(`{"id":"a-1","type":"ack","eventIds":[%d,%d]}`, id, id+100))
+	data := reply.Data.(map[string]any)
+	if reply.Type != "acked" || reply.ReplyTo != "a-1" || data["acked"] != 1 {
+		t.Errorf("ack: got %+v", reply)
+	}
+	// Повторное подтверждение ничего не меняет
+	if again := sendFrame(t, c, fmt.Sprintf(`{"type":"ack","eventIds":[%d]}`, id)); again.Data.(map[string]any)["acked"] != 0 {
+		t.Errorf("repeated ack: got %+v", again)
+	}
+	// Событие чужой клиники подтвердить нельзя
+	foreign, _ := events.Append(context.Background(), clinicEvent("clinic-b", "visit_started"))
+	if got := sendFrame(t, c, fmt.Sprintf(`{"type":"ack","eventIds":[%d]}`, foreign)); got.Data.(map[string]any)["acked"] != 0 {
+		t.Errorf("ack of an event for another clinic: got %+v", got)
+	}
+}
+
+// Схема должна описывать ровно те типы кадров и коды ошибок, что обрабатывает сервер
+func TestProtocolSchemaMatchesImplementation(t *testing.T) {
+	var schema struct {
+		Defs map[string]json.RawMessage `json:"$defs"`
+	}
+	if err := json.Unmarshal(wsProtocolSchema, &schema); err != nil {
+		t.Fatalf("schema is not valid JSON: %v", err)
+	}
+	var client struct {
+		Properties struct {
+			Type struct {
+				Enum []string `json:"enum"`
+			} `json:"type"`
+		} `json:"properties"`
+	}
+	json.Unmarshal(schema.Defs["clientFrame"], &client)
+	var errDef struct {
+		Properties struct {
+			Data struct {
+				Properties struct {
+					Code struct {
+						Enum []string `json:"enum"`
+					} `json:"code"`
+				} `json:"properties"`
+			} `json:"data"`
+		} `json:"properties"`
+	}
+	json.Unmarshal(schema.Defs["error"], &errDef)
+
+	same := func(name string, got, want []string) {
+		sort.Strings(got)
+		sort.Strings(want)
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("%s in schema %v, implemented %v", name, got, want)
+		}
+	}
+	same("client frame types", client.Properties.Type.Enum,
+		[]string{frameSubscribe, frameUnsubscribe, framePing, frameAck, framePresence, frameLeave, frameLock, frameUnlock, frameLegacyHello})
+	same("error codes", errDef.Properties.Data.Properties.Code.Enum,
+		[]string{wsErrBadFrame, wsErrUnsupportedVersion, wsErrUnknownType, wsErrInvalidTopic, wsErrForbidden, wsErrTooManyTopics, wsErrSectionLocked, wsErrInternal})
+}
@@ -11,13 +11,16 @@ async function request<T>(path: string, options: RequestInit = {}): Promise<T> {
     if (import.meta.env.DEV) {
       console.log(`API Request: ${options.method || 'GET'} ${API_BASE_URL}${path}`);
     }
+    // Сервер проверяет права по пользователю: медицинские данные отдаются только пациенту и клинике
+    const uid = localStorage.getItem('medwork_uid');
     const res = await fetch(`${API_BASE_URL}${path}`, {
+      ...options,
       signal: controller.signal,
       headers: {
         'Content-Type': 'application/json',
+        ...(uid ? { 'X-User-ID': uid } : {}),
         ...(options.headers || {}),
       },
-      ...options,
     });
 
     clearTimeout(timeoutId);
@@ -376,10 +379,14 @@ export async function apiUpsertAmbulatoryCard(card: AmbulatoryCard): Promise<voi
     specialistEntriesKeys: card.specialistEntries ? Object.keys(card.specialistEntries) : []
   });
   
+  // Итоговое заключение подписывает председатель через /api/visits/{id}/final-conclusion,
+  // сервер не принимает его в карте
+  const { finalConclusion: _final, ...body } = card as AmbulatoryCard & { finalConclusion?: unknown };
+
   try {
     await request('/api/ambulatory-cards', {
       method: 'POST',
-      body: JSON.stringify(card),
+      body: JSON.stringify(body),
     });
     console.log('apiUpsertAmbulatoryCard: Card saved successfully');
   } catch (error) {
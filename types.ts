@@ -31,8 +31,10 @@ export interface Employee {
   lastMedDate?: string;            // Дата последнего медосмотра
   note?: string;                   // Примечание (может содержать телефон для регистрации)
   harmfulFactor: string;
+  factorCodes?: string[];          // Пункты Перечня вредных факторов (Приложение 4)
+  decreedGroup?: string;           // Пункт целевой группы (Приложение 1), 'none' - не входит
   status: 'pending' | 'fit' | 'unfit' | 'needs_observation' | 'fit_with_restrictions';
-  healthGroup?: 'I' | 'II' | 'III' | 'IV' | 'V'; // Группа здоровья
+  healthGroup?: 'I' | 'II' | 'III' | 'IV' | 'V' | 'VI'; // Группа здоровья (VI - признаки профзаболевания)
   phone?: string;                  // Телефон сотрудника (извлекается из note)
   userId?: string;                  // UID пользователя, если зарегистрирован
   visitId?: number;                 // ID текущего визита
@@ -139,7 +141,8 @@ export interface AmbulatoryCard {
   finalConclusion?: {
     chairmanName: string;
     date: string;
-    healthGroup: 'I' | 'II' | 'III' | 'IV' | 'V';
+    healthGroup: 'I' | 'II' | 'III' | 'IV' | 'V' | 'VI';
+    occupationalSuspicion?: boolean; // подозрение на профзаболевание - направление в центр профпатологии
     isFit: boolean;
     restrictions?: string;
     nextExamDate: string;